@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// runBench encodes a sample with each x264 preset and hardware encoder,
+// prints how fast each was and recommends the preset for this machine
+func runBench(args []string) error {
+	if benchDuration <= 0 {
+		return fmt.Errorf("--duration must be positive")
+	}
+	if benchMinSpeed <= 0 {
+		return fmt.Errorf("--min-speed must be positive")
+	}
+	for _, preset := range benchPresets {
+		if !slices.Contains(config.X264Presets, preset) {
+			return fmt.Errorf("invalid --presets %q, not an x264 preset", preset)
+		}
+	}
+	// Benchmark from fastest to slowest, the order recommendations use
+	presets := slices.Clone(benchPresets)
+	slices.SortFunc(presets, func(a, b string) int {
+		return slices.Index(config.X264Presets, a) - slices.Index(config.X264Presets, b)
+	})
+	presets = slices.Compact(presets)
+
+	// Load configuration
+	var err error
+	cfg, err = config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	source, sample := "", "a generated test pattern"
+	if len(args) > 0 {
+		if source, err = filepath.Abs(args[0]); err != nil {
+			return err
+		}
+		if _, err := transcoder.Probe(source); err != nil {
+			return err
+		}
+		sample = filepath.Base(source)
+	}
+
+	settings, err := transcoder.BenchSettings(presets)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.MkdirTemp("", "streaming-bench-")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Ctrl-C stops FFmpeg and skips the remaining settings
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// The table shows the progress, with --json the results are printed
+	// at the end
+	w := messages()
+	profile, _ := cfg.Profile("")
+	q := profile.Variants[0]
+	fmt.Fprintf(w, "Encoding %s of %s to %s at %s with %d settings\n\n",
+		benchDuration, sample, q.Name(), q.Bitrate(), len(settings))
+	fmt.Fprintf(w, "%-24s %8s %9s %10s %10s\n", "SETTING", "FPS", "REALTIME", "SIZE", "BITRATE")
+
+	out := benchOutput{Sample: source, Seconds: benchDuration.Seconds(), MinSpeed: benchMinSpeed}
+	var results []*transcoder.BenchResult
+	for _, setting := range settings {
+		result, err := transcoder.Bench(ctx, source, benchDuration.Seconds(), setting, q, dir)
+		if ctx.Err() != nil {
+			return fmt.Errorf("benchmark interrupted")
+		}
+		benched := benchedSetting{
+			Encoder:  setting.Encoder,
+			Preset:   setting.Preset,
+			HWAccel:  setting.HWAccel(),
+			Hardware: setting.Hardware(),
+		}
+		// Hardware encoders are listed by FFmpeg builds that support them,
+		// whether or not the machine has the hardware
+		if err != nil && setting.Hardware() {
+			reason := err.Error()
+			if result.StderrTail != "" {
+				lines := strings.Split(result.StderrTail, "\n")
+				reason = lines[len(lines)-1]
+			}
+			fmt.Fprintf(w, "%-24s not usable: %s\n", setting, reason)
+			benched.Error = "not usable: " + reason
+			out.Results = append(out.Results, benched)
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(w, "%-24s %v\n", setting, err)
+			if result.StderrTail != "" {
+				fmt.Fprintln(w, result.StderrTail)
+			}
+			benched.Error = err.Error()
+			out.Results = append(out.Results, benched)
+			continue
+		}
+		results = append(results, result)
+		benched.FPS = result.FPS()
+		benched.Realtime = result.Realtime()
+		benched.Size = result.Size
+		benched.BitrateKbps = float64(result.Size) * 8 / result.Seconds / 1000
+		out.Results = append(out.Results, benched)
+		fmt.Fprintf(w, "%-24s %8.1f %8.1fx %10s %7.0f kb/s\n", setting, benched.FPS, benched.Realtime,
+			formatSize(benched.Size), benched.BitrateKbps)
+	}
+	fmt.Fprintln(w)
+
+	best, fastest := recommendBench(results)
+	switch {
+	case best != nil:
+		out.RecommendedPreset = best.Setting.Preset
+	case fastest != nil:
+		out.RecommendedPreset = fastest.Setting.Preset
+	}
+	return printResult(out, func() {
+		printRecommendation(results, best, fastest)
+	})
+}
+
+// benchOutput is the result of bench --json
+type benchOutput struct {
+	// Sample is the benchmarked file, "" for the test pattern
+	Sample   string           `json:"sample"`
+	Seconds  float64          `json:"seconds"`
+	MinSpeed float64          `json:"min_speed"`
+	Results  []benchedSetting `json:"results"`
+	// RecommendedPreset is the slowest preset reaching min_speed, or the
+	// fastest when none does. It is "" when no preset could be benchmarked.
+	RecommendedPreset string `json:"recommended_preset"`
+}
+
+// benchedSetting is the outcome of benchmarking one setting
+type benchedSetting struct {
+	Encoder     string  `json:"encoder"`
+	Preset      string  `json:"preset,omitempty"`
+	HWAccel     string  `json:"hwaccel"`
+	Hardware    bool    `json:"hardware"`
+	FPS         float64 `json:"fps,omitempty"`
+	Realtime    float64 `json:"realtime,omitempty"`
+	Size        int64   `json:"size,omitempty"`
+	BitrateKbps float64 `json:"bitrate_kbps,omitempty"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// recommendBench returns the slowest preset, which compresses best, that
+// still encodes at least --min-speed times faster than playback, and the
+// fastest preset. best is nil when no preset is fast enough.
+func recommendBench(results []*transcoder.BenchResult) (best, fastest *transcoder.BenchResult) {
+	for _, r := range results {
+		if r.Setting.Hardware() {
+			continue
+		}
+		if fastest == nil || r.Realtime() > fastest.Realtime() {
+			fastest = r
+		}
+		if r.Realtime() >= benchMinSpeed {
+			best = r
+		}
+	}
+	return best, fastest
+}
+
+// printRecommendation prints the preset recommended by recommendBench and
+// the hardware encoders faster than every preset
+func printRecommendation(results []*transcoder.BenchResult, best, fastest *transcoder.BenchResult) {
+	switch {
+	case fastest == nil:
+		fmt.Println("No preset could be benchmarked.")
+		return
+	case best == nil:
+		fmt.Printf("No preset reaches %.1fx realtime, use the fastest: transcode_preset = %q at %.1fx\n",
+			benchMinSpeed, fastest.Setting.Preset, fastest.Realtime())
+		if fastest.Realtime() < 1 {
+			fmt.Println("Processing videos like this one takes longer than playing them.")
+		}
+	default:
+		fmt.Printf("Recommended: transcode_preset = %q, the slowest preset encoding at %.1fx realtime or more\n",
+			best.Setting.Preset, benchMinSpeed)
+	}
+	profile, _ := cfg.Profile("")
+	fmt.Printf("Configured:  preset = %q, hwaccel = %q in profile %q\n", profile.Preset, profile.HWAccel, profile.Name)
+
+	for _, r := range results {
+		if r.Setting.Hardware() && r.Realtime() > fastest.Realtime() {
+			fmt.Printf("%s is faster at %.1fx realtime, transcode with it using hwaccel = %q.\n",
+				r.Setting.Encoder, r.Realtime(), r.Setting.HWAccel())
+		}
+	}
+	fmt.Println("Speeds are those of one job, the librarian's --threads jobs share the CPU.")
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// pruneOutput is the result of cache prune --json
+type pruneOutput struct {
+	DryRun  bool        `json:"dry_run"`
+	Removed []prunedDir `json:"removed"`
+	// Skipped are directories being transcoded or streamed
+	Skipped    []string `json:"skipped"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+// prunedDir is a cache directory removed by cache prune
+type prunedDir struct {
+	Dir        string    `json:"dir"`
+	VideoID    int64     `json:"video_id,omitempty"`
+	SizeBytes  int64     `json:"size_bytes"`
+	LastAccess time.Time `json:"last_access"`
+}
+
+// runCachePrune evicts cache directories selected by the prune flags
+func runCachePrune() error {
+	opts := library.EvictOptions{VideoID: pruneVideo, DryRun: pruneDryRun}
+	var err error
+	if pruneOlderThan != "" {
+		if opts.OlderThan, err = parseAge(pruneOlderThan); err != nil {
+			return fmt.Errorf("invalid --older-than: %w", err)
+		}
+	}
+	if pruneMaxSize != "" {
+		if opts.MaxSize, err = parseSize(pruneMaxSize); err != nil {
+			return fmt.Errorf("invalid --max-size: %w", err)
+		}
+	}
+	// Without any limit the whole cache would go, which is what the purge
+	// API is for
+	if opts.OlderThan <= 0 && opts.MaxSize <= 0 && opts.VideoID == 0 {
+		return fmt.Errorf("give --older-than, --max-size or --video")
+	}
+
+	// Load configuration
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	result, err := lm.EvictCache(opts, database.ActorCLI)
+	if result != nil {
+		out := pruneOutput{
+			DryRun:     opts.DryRun,
+			Removed:    make([]prunedDir, 0, len(result.Removed)),
+			Skipped:    result.Skipped,
+			FreedBytes: result.FreedBytes,
+		}
+		for _, dir := range result.Removed {
+			pd := prunedDir{Dir: dir.Dir, SizeBytes: dir.SizeBytes, LastAccess: dir.LastAccess}
+			if dir.Video != nil {
+				pd.VideoID = dir.Video.ID
+			}
+			out.Removed = append(out.Removed, pd)
+		}
+		if out.Skipped == nil {
+			out.Skipped = []string{}
+		}
+
+		printErr := printResult(out, func() {
+			verb := "removed"
+			if opts.DryRun {
+				verb = "would remove"
+			}
+			for _, dir := range result.Removed {
+				fmt.Printf("%s %s (%s, last used %s ago)\n", verb, dir.Dir,
+					formatSize(dir.SizeBytes), time.Since(dir.LastAccess).Round(time.Minute))
+			}
+			for _, dir := range result.Skipped {
+				fmt.Printf("skipped %s, it is being transcoded or streamed\n", dir)
+			}
+			if opts.DryRun {
+				fmt.Printf("Would free %s in %d directories\n", formatSize(result.FreedBytes), len(result.Removed))
+			} else {
+				fmt.Printf("Freed %s in %d directories\n", formatSize(result.FreedBytes), len(result.Removed))
+			}
+		})
+		if printErr != nil {
+			return printErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error pruning cache: %w", err)
+	}
+	return nil
+}
+
+// fsckOutput is the result of cache fsck --json
+type fsckOutput struct {
+	DryRun     bool          `json:"dry_run"`
+	Orphaned   []prunedDir   `json:"orphaned"`
+	Requeued   []fsckedVideo `json:"requeued"`
+	FreedBytes int64         `json:"freed_bytes"`
+}
+
+// fsckedVideo is a video cache fsck queued for reprocessing
+type fsckedVideo struct {
+	ID   int64  `json:"id"`
+	Path string `json:"path"`
+}
+
+// runCacheFsck reconciles the cache with the database
+func runCacheFsck() error {
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	result, err := lm.Fsck(fsckDryRun, database.ActorCLI)
+	if result != nil {
+		out := fsckOutput{
+			DryRun:     fsckDryRun,
+			Orphaned:   make([]prunedDir, 0, len(result.Orphaned)),
+			Requeued:   make([]fsckedVideo, 0, len(result.Requeued)),
+			FreedBytes: result.FreedBytes,
+		}
+		for _, dir := range result.Orphaned {
+			out.Orphaned = append(out.Orphaned, prunedDir{Dir: dir.Dir, SizeBytes: dir.SizeBytes, LastAccess: dir.LastAccess})
+		}
+		for _, video := range result.Requeued {
+			out.Requeued = append(out.Requeued, fsckedVideo{ID: video.ID, Path: video.Path})
+		}
+
+		printErr := printResult(out, func() {
+			removeVerb, queueVerb := "removed", "queued"
+			if fsckDryRun {
+				removeVerb, queueVerb = "would remove", "would queue"
+			}
+			for _, dir := range result.Orphaned {
+				fmt.Printf("%s %s (%s), no video uses it\n", removeVerb, dir.Dir, formatSize(dir.SizeBytes))
+			}
+			for _, video := range result.Requeued {
+				fmt.Printf("%s %d %s for reprocessing, its output is missing\n", queueVerb, video.ID, video.Filename)
+			}
+			if len(result.Orphaned) == 0 && len(result.Requeued) == 0 {
+				fmt.Println("The cache and the database agree")
+			}
+		})
+		if printErr != nil {
+			return printErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error checking cache: %w", err)
+	}
+	return nil
+}
+
+// verifyOutput is the result of cache verify --json
+type verifyOutput struct {
+	Checked  int             `json:"checked"`
+	Broken   []brokenVariant `json:"broken"`
+	Requeued []fsckedVideo   `json:"requeued"`
+}
+
+// brokenVariant is a variant cache verify found damaged
+type brokenVariant struct {
+	VideoID int64  `json:"video_id"`
+	Path    string `json:"path"`
+	Variant string `json:"variant"`
+	Problem string `json:"problem"`
+}
+
+// runCacheVerify checks cached output for damage and optionally repairs it
+func runCacheVerify() error {
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	opts := library.VerifyOptions{VideoID: verifyVideo, Quick: verifyQuick, Repair: verifyRepair}
+	result, err := lm.VerifyCache(opts, database.ActorCLI)
+	if result != nil {
+		out := verifyOutput{
+			Checked:  result.Checked,
+			Broken:   make([]brokenVariant, 0, len(result.Broken)),
+			Requeued: make([]fsckedVideo, 0, len(result.Requeued)),
+		}
+		for _, b := range result.Broken {
+			out.Broken = append(out.Broken, brokenVariant{VideoID: b.Video.ID, Path: b.Video.Path, Variant: b.Variant, Problem: b.Problem})
+		}
+		for _, video := range result.Requeued {
+			out.Requeued = append(out.Requeued, fsckedVideo{ID: video.ID, Path: video.Path})
+		}
+
+		printErr := printResult(out, func() {
+			for _, b := range result.Broken {
+				fmt.Printf("%d %s %s: %s\n", b.Video.ID, b.Video.Filename, b.Variant, b.Problem)
+			}
+			for _, video := range result.Requeued {
+				fmt.Printf("queued %d %s for repair\n", video.ID, video.Filename)
+			}
+			if len(result.Broken) == 0 {
+				fmt.Printf("Checked %d variants, none are broken\n", result.Checked)
+			}
+		})
+		if printErr != nil {
+			return printErr
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error verifying cache: %w", err)
+	}
+	if n := len(result.Broken); n > 0 && !verifyRepair {
+		return fmt.Errorf("%d of %d variants are broken, repair them with --repair", n, result.Checked)
+	}
+	return nil
+}
+
+// parseAge parses a duration, which unlike time.ParseDuration also accepts
+// whole days such as "7d"
+func parseAge(s string) (time.Duration, error) {
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("%q is not a number of days", s)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// sizeUnits are the binary size suffixes accepted by parseSize
+var sizeUnits = []string{"K", "M", "G", "T"}
+
+// parseSize parses a byte count with an optional binary suffix, e.g. "50G"
+func parseSize(s string) (int64, error) {
+	num := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(s)), "B")
+	multiplier := int64(1)
+	for i, unit := range sizeUnits {
+		if n, ok := strings.CutSuffix(num, unit); ok {
+			num = n
+			multiplier = 1 << (10 * (i + 1))
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(strings.TrimSpace(num), 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("%q is not a size", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatSize formats a byte count with a binary suffix
+func formatSize(b int64) string {
+	if b < 1024 {
+		return fmt.Sprintf("%d B", b)
+	}
+	size, unit := float64(b), ""
+	for _, u := range sizeUnits {
+		if size < 1024 {
+			break
+		}
+		size /= 1024
+		unit = u
+	}
+	return fmt.Sprintf("%.1f %siB", size, unit)
+}
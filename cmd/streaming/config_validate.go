@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/device"
+	"github.com/kaero/streaming/internal/middleware"
+	"github.com/kaero/streaming/internal/notify"
+	"github.com/kaero/streaming/internal/scrobble"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// validateOutput is the result of config validate --json
+type validateOutput struct {
+	// File is the config file read, "" when there is none
+	File     string         `json:"file"`
+	Settings map[string]any `json:"settings"`
+	FFmpeg   string         `json:"ffmpeg,omitempty"`
+	Valid    bool           `json:"valid"`
+	Problems []string       `json:"problems"`
+}
+
+// checkConfig refuses to start a service with an invalid configuration,
+// listing every problem rather than passing bad values on to FFmpeg.
+// Unknown settings are only logged as a warning.
+func checkConfig(c *config.Config) error {
+	var problems []string
+	for _, err := range c.Validate() {
+		if errors.Is(err, config.ErrUnknownSetting) {
+			logger.Warn("Ignoring setting", "err", err)
+			continue
+		}
+		problems = append(problems, err.Error())
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration, run config validate for details:\n  %s", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// runConfigValidate loads the configuration without side effects, prints
+// the effective settings and reports every problem found in them
+func runConfigValidate() error {
+	// Load configuration
+	var err error
+	cfg, err = config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	// Override with command-line flags if provided
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	out := validateOutput{File: cfg.File(), Settings: cfg.Settings(showSecrets), Problems: []string{}}
+
+	problems := cfg.Validate()
+	if _, err := device.New(cfg.Devices); err != nil {
+		problems = append(problems, fmt.Errorf("devices: %w", err))
+	}
+	if _, err := notify.New(cfg.Notify.Targets, logger); err != nil {
+		problems = append(problems, fmt.Errorf("notify: %w", err))
+	}
+	if _, err := scrobble.New(cfg.Scrobble.Targets, nil, logger); err != nil {
+		problems = append(problems, fmt.Errorf("scrobble: %w", err))
+	}
+	if _, err := middleware.ParseNets(cfg.RateLimit.TrustedProxies); err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit.trusted_proxies: %w", err))
+	}
+	if _, err := middleware.ParseNets(cfg.RateLimit.BannedIPs); err != nil {
+		problems = append(problems, fmt.Errorf("rate_limit.banned_ips: %w", err))
+	}
+	if err := transcoder.CheckTools(cfg.TranscodeProfiles()); err != nil {
+		problems = append(problems, err)
+	} else if version, err := transcoder.FFmpegVersion(); err == nil {
+		out.FFmpeg = version
+	}
+	for _, p := range problems {
+		out.Problems = append(out.Problems, p.Error())
+	}
+	out.Valid = len(problems) == 0
+
+	settings, err := toml.Marshal(out.Settings)
+	if err != nil {
+		return fmt.Errorf("failed to print configuration: %w", err)
+	}
+	err = printResult(out, func() {
+		if out.File != "" {
+			fmt.Printf("# Config file: %s\n", out.File)
+		} else {
+			fmt.Println("# No config file found, using defaults and environment")
+		}
+		fmt.Println(string(settings))
+
+		if out.FFmpeg != "" {
+			fmt.Printf("# %s\n", out.FFmpeg)
+		}
+		if out.Valid {
+			fmt.Println("# Configuration is valid")
+		}
+		for _, p := range out.Problems {
+			fmt.Printf("error: %s\n", p)
+		}
+	})
+	if err != nil || out.Valid {
+		return err
+	}
+	if len(problems) == 1 {
+		return fmt.Errorf("1 problem found")
+	}
+	return fmt.Errorf("%d problems found", len(problems))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// dbOutput is the result of the db commands with --json. Each sets the
+// fields it has a value for.
+type dbOutput struct {
+	Path string `json:"path"`
+	// Backup is the copy written by backup, or of the replaced database by
+	// restore
+	Backup       string `json:"backup,omitempty"`
+	RestoredFrom string `json:"restored_from,omitempty"`
+	// FromVersion is the schema version before migrating
+	FromVersion   int   `json:"from_version,omitempty"`
+	SchemaVersion int   `json:"schema_version,omitempty"`
+	SizeBefore    int64 `json:"size_before,omitempty"`
+	SizeAfter     int64 `json:"size_after,omitempty"`
+}
+
+// runDBMigrate applies pending schema migrations to the database
+func runDBMigrate() error {
+	path, err := databasePath()
+	if err != nil {
+		return err
+	}
+
+	before := 0
+	if _, err := os.Stat(path); err == nil {
+		if before, err = database.CheckFile(path); err != nil {
+			return err
+		}
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	after, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	out := dbOutput{Path: path, FromVersion: before, SchemaVersion: after}
+	return printResult(out, func() {
+		if after == before {
+			fmt.Printf("%s is up to date at schema version %d\n", path, after)
+		} else {
+			fmt.Printf("Migrated %s from schema version %d to %d\n", path, before, after)
+		}
+	})
+}
+
+// runDBBackup writes a consistent copy of the database to dest, or next to
+// the database when dest is empty
+func runDBBackup(dest string) error {
+	path, err := databasePath()
+	if err != nil {
+		return err
+	}
+	if _, err := database.CheckFile(path); err != nil {
+		return err
+	}
+
+	if dest == "" {
+		dest = backupName(path, "backup")
+	}
+	if _, err := os.Stat(dest); err == nil {
+		return fmt.Errorf("%s already exists", dest)
+	}
+
+	if err := database.BackupFile(path, dest); err != nil {
+		return err
+	}
+	return printResult(dbOutput{Path: path, Backup: dest}, func() {
+		fmt.Printf("Backed up %s to %s\n", path, dest)
+	})
+}
+
+// runDBRestore replaces the database with a backup. The current database
+// is backed up first, so a restore can be undone.
+func runDBRestore(src string) error {
+	path, err := databasePath()
+	if err != nil {
+		return err
+	}
+
+	version, err := database.CheckFile(src)
+	if err != nil {
+		return fmt.Errorf("can't restore %s: %w", src, err)
+	}
+
+	ok, err := confirm(fmt.Sprintf("This replaces %s with %s (schema version %d).\nStop the server and librarian first. Continue?", path, src, version))
+	if err != nil || !ok {
+		return err
+	}
+
+	out := dbOutput{Path: path, RestoredFrom: src, FromVersion: version}
+	if _, err := os.Stat(path); err == nil {
+		out.Backup = backupName(path, "before-restore")
+		if err := database.BackupFile(path, out.Backup); err != nil {
+			return err
+		}
+		fmt.Fprintf(messages(), "Backed up the current database to %s\n", out.Backup)
+	}
+
+	// Copy next to the database first, so the database is replaced
+	// atomically by the rename
+	tmp := path + ".restore"
+	if err := copyFile(src, tmp); err != nil {
+		return fmt.Errorf("failed to copy backup: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to replace database: %w", err)
+	}
+
+	// A journal left behind belongs to the replaced database and would
+	// corrupt the restored one
+	for _, suffix := range []string{"-journal", "-wal", "-shm"} {
+		if err := os.Remove(path + suffix); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove stale %s: %w", path+suffix, err)
+		}
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	after, err := db.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	out.SchemaVersion = after
+	return printResult(out, func() {
+		fmt.Printf("Restored %s from %s", path, src)
+		if after != version {
+			fmt.Printf(", migrated from schema version %d to %d", version, after)
+		}
+		fmt.Println()
+	})
+}
+
+// runDBVacuum rebuilds the database file to reclaim the space of deleted
+// rows
+func runDBVacuum() error {
+	path, err := databasePath()
+	if err != nil {
+		return err
+	}
+	if _, err := database.CheckFile(path); err != nil {
+		return err
+	}
+
+	before, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	if err := db.Vacuum(); err != nil {
+		return err
+	}
+
+	after, err := fileSize(path)
+	if err != nil {
+		return err
+	}
+	out := dbOutput{Path: path, SizeBefore: before, SizeAfter: after}
+	return printResult(out, func() {
+		fmt.Printf("Vacuumed %s: %s before, %s after\n", path, formatSize(before), formatSize(after))
+	})
+}
+
+// databasePath returns the configured database path
+func databasePath() (string, error) {
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return "", fmt.Errorf("error initializing config: %w", err)
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+	return cfg.Database.Path, nil
+}
+
+// backupName returns a timestamped file name next to the database
+func backupName(path, kind string) string {
+	return fmt.Sprintf("%s.%s-%s", path, kind, time.Now().Format("20060102-150405"))
+}
+
+// confirm asks a yes/no question on the terminal. --yes answers it for
+// scripts; without a terminal the question is refused.
+func confirm(question string) (bool, error) {
+	if assumeYes {
+		return true, nil
+	}
+	if info, err := os.Stdin.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false, fmt.Errorf("not a terminal, pass --yes to confirm")
+	}
+
+	fmt.Fprintf(messages(), "%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	fmt.Fprintln(messages(), "Aborted")
+	return false, nil
+}
+
+// copyFile copies a file, syncing the copy to disk
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// fileSize returns the size of a file
+func fileSize(path string) (int64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	return info.Size(), nil
+}
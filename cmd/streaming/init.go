@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/term"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/utils"
+)
+
+// initResult is the outcome of init, as printed by init --json
+type initResult struct {
+	ConfigFile    string `json:"config_file"`
+	MediaDir      string `json:"media_dir"`
+	CacheDir      string `json:"cache_dir"`
+	DBPath        string `json:"db_path"`
+	Port          int    `json:"port"`
+	SchemaVersion int    `json:"schema_version"`
+	AdminUser     string `json:"admin_user,omitempty"`
+	// AdminPassword is only set when it was generated
+	AdminPassword string `json:"admin_password,omitempty"`
+	AdminToken    string `json:"admin_token,omitempty"`
+	FFmpeg        string `json:"ffmpeg,omitempty"`
+	FFmpegError   string `json:"ffmpeg_error,omitempty"`
+}
+
+// runInit asks for the basic settings, writes them to a new config file
+// and prepares everything the services need to start: the directories,
+// the database and an admin user. It ends by checking FFmpeg.
+func runInit() error {
+	path := cfgFile
+	if path == "" {
+		path = "config.toml"
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+
+	if !assumeYes && !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("not a terminal, pass --yes to accept the defaults")
+	}
+	p := &prompter{in: bufio.NewReader(os.Stdin), out: messages(), defaults: assumeYes}
+
+	if _, err := os.Stat(path); err == nil && !assumeYes {
+		ok, err := p.askYesNo(path+" exists, replace it? Its other settings are lost", false)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintln(p.out, "Aborted")
+			return nil
+		}
+	}
+
+	// Keep everything next to the config file unless told otherwise
+	dir := filepath.Dir(path)
+	result := initResult{
+		ConfigFile: path,
+		MediaDir:   filepath.Join(dir, "media"),
+		CacheDir:   filepath.Join(dir, "cache"),
+		DBPath:     filepath.Join(dir, "library.db"),
+		Port:       config.DefaultPort,
+	}
+	if mediaDir != "" {
+		result.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		result.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		result.DBPath = dbPath
+	}
+
+	if !assumeYes {
+		fmt.Fprintf(p.out, "Setting up %s. Press enter to keep the answer in brackets.\n\n", path)
+	}
+	for _, q := range []struct {
+		question string
+		answer   *string
+	}{
+		{"Media directory, where your videos are", &result.MediaDir},
+		{"Cache directory, where transcoded videos go", &result.CacheDir},
+		{"Database file", &result.DBPath},
+	} {
+		answer, err := p.ask(q.question, *q.answer)
+		if err != nil {
+			return err
+		}
+		if *q.answer, err = filepath.Abs(answer); err != nil {
+			return err
+		}
+	}
+	if result.Port, err = p.askPort("Port to listen on", result.Port); err != nil {
+		return err
+	}
+
+	createAdmin, err := p.askYesNo("Create an admin user for the web UI?", true)
+	if err != nil {
+		return err
+	}
+	var password string
+	if createAdmin {
+		if result.AdminUser, err = p.ask("Admin username", "admin"); err != nil {
+			return err
+		}
+		if password, err = p.askPassword("Admin password, empty to generate one"); err != nil {
+			return err
+		}
+		if password == "" {
+			key, err := auth.GenerateKey()
+			if err != nil {
+				return err
+			}
+			password = key[:16]
+			result.AdminPassword = password
+		}
+	}
+	protect, err := p.askYesNo("Require a login to watch videos?", false)
+	if err != nil {
+		return err
+	}
+	wantToken, err := p.askYesNo("Generate an admin API token for scripts and streaming top?", true)
+	if err != nil {
+		return err
+	}
+
+	// A fixed session secret keeps users logged in across restarts
+	secret, err := auth.GenerateKey()
+	if err != nil {
+		return err
+	}
+	settings := map[string]any{
+		"media.media_dir":      result.MediaDir,
+		"media.cache_dir":      result.CacheDir,
+		"database.path":        result.DBPath,
+		"server.port":          result.Port,
+		"auth.protect_streams": protect,
+		"auth.session_secret":  secret,
+	}
+	if wantToken {
+		if result.AdminToken, err = auth.GenerateKey(); err != nil {
+			return err
+		}
+		settings["auth.admin_token"] = result.AdminToken
+	}
+
+	if err := config.WriteConfig(path, settings); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
+	// The file holds secrets
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+
+	cfg, err = config.Load(path)
+	if err != nil {
+		return fmt.Errorf("error loading the written config: %w", err)
+	}
+	if err := utils.CreateDirectories(cfg); err != nil {
+		return fmt.Errorf("error creating directories: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(cfg.Database.Path), 0755); err != nil {
+		return fmt.Errorf("error creating directories: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+	if result.SchemaVersion, err = db.SchemaVersion(); err != nil {
+		return err
+	}
+
+	adminStatus := ""
+	if createAdmin {
+		if adminStatus, err = saveAdmin(db, result.AdminUser, password); err != nil {
+			return err
+		}
+	}
+
+	if err := transcoder.CheckTools(cfg.TranscodeProfiles()); err != nil {
+		result.FFmpegError = err.Error()
+	} else if result.FFmpeg, err = transcoder.FFmpegVersion(); err != nil {
+		result.FFmpegError = err.Error()
+	}
+
+	return printResult(result, func() {
+		fmt.Println()
+		fmt.Printf("Wrote %s\n", result.ConfigFile)
+		fmt.Printf("Database %s is at schema version %d\n", result.DBPath, result.SchemaVersion)
+		if createAdmin {
+			fmt.Printf("%s admin user %q\n", adminStatus, result.AdminUser)
+		}
+		if result.AdminPassword != "" {
+			fmt.Printf("Admin password: %s\n", result.AdminPassword)
+		}
+		if result.AdminToken != "" {
+			fmt.Printf("Admin API token: %s\n", result.AdminToken)
+		}
+		if result.FFmpegError != "" {
+			fmt.Printf("FFmpeg is not usable: %s\n", result.FFmpegError)
+			fmt.Println("Install FFmpeg with ffprobe and libx264 before processing videos.")
+		} else {
+			fmt.Printf("Found %s\n", result.FFmpeg)
+		}
+		fmt.Println()
+		fmt.Println("Start the server and librarian with:")
+		fmt.Printf("  %s standalone --config %s\n", os.Args[0], result.ConfigFile)
+		fmt.Printf("and open http://localhost:%d/\n", result.Port)
+	})
+}
+
+// saveAdmin creates an admin user, or makes an existing user of that name
+// an admin with the new password. It returns what it did for people.
+func saveAdmin(db *database.DB, username, password string) (string, error) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return "", err
+	}
+	user, err := db.GetUserByName(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		if _, err := db.CreateUser(username, hash, auth.RoleAdmin); err != nil {
+			return "", err
+		}
+		return "Created", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := db.UpdateUser(user.ID, hash, auth.RoleAdmin); err != nil {
+		return "", err
+	}
+	return "Updated", nil
+}
+
+// prompter asks the questions of init on the terminal. With defaults set
+// every question is answered with its default without asking.
+type prompter struct {
+	in       *bufio.Reader
+	out      io.Writer
+	defaults bool
+}
+
+// ask asks a question, returning def for an empty answer
+func (p *prompter) ask(question, def string) (string, error) {
+	if p.defaults {
+		return def, nil
+	}
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || answer == "") {
+		return "", fmt.Errorf("no answer: %w", err)
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+// askYesNo asks a yes/no question until it is answered
+func (p *prompter) askYesNo(question string, def bool) (bool, error) {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	for {
+		answer, err := p.ask(question+" ["+hint+"]", "")
+		if err != nil || p.defaults {
+			return def, err
+		}
+		switch strings.ToLower(answer) {
+		case "":
+			return def, nil
+		case "y", "yes":
+			return true, nil
+		case "n", "no":
+			return false, nil
+		}
+		fmt.Fprintln(p.out, "Answer yes or no.")
+	}
+}
+
+// askPort asks for a port number until a valid one is given
+func (p *prompter) askPort(question string, def int) (int, error) {
+	for {
+		answer, err := p.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		port, err := strconv.Atoi(answer)
+		if err == nil && port > 0 && port <= 65535 {
+			return port, nil
+		}
+		if p.defaults {
+			return 0, fmt.Errorf("invalid port %q", answer)
+		}
+		fmt.Fprintln(p.out, "Enter a port between 1 and 65535.")
+	}
+}
+
+// askPassword asks for a password twice without echoing it, until both
+// match and it is long enough. An empty answer is returned as is.
+func (p *prompter) askPassword(question string) (string, error) {
+	if p.defaults {
+		return "", nil
+	}
+	for {
+		fmt.Fprintf(p.out, "%s: ", question)
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		if len(password) == 0 {
+			return "", nil
+		}
+		if len(password) < auth.MinPasswordLength {
+			fmt.Fprintf(p.out, "The password needs at least %d characters.\n", auth.MinPasswordLength)
+			continue
+		}
+
+		fmt.Fprint(p.out, "Repeat the password: ")
+		repeated, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(p.out)
+		if err != nil {
+			return "", fmt.Errorf("failed to read password: %w", err)
+		}
+		if string(repeated) != string(password) {
+			fmt.Fprintln(p.out, "The passwords don't match.")
+			continue
+		}
+		return string(password), nil
+	}
+}
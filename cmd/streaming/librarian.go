@@ -1,21 +1,30 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 
 	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/control"
 	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/dvr"
 	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/scrobble"
 	"github.com/kaero/streaming/internal/transcoder"
 	"github.com/kaero/streaming/internal/utils"
+	"github.com/kaero/streaming/internal/version"
 )
 
 // runLibrarian sets up and starts the librarian service
 func runLibrarian() error {
+	if err := checkTranscodeFlags(); err != nil {
+		return err
+	}
+
 	// Load configuration
 	var err error
 	cfg, err = config.InitConfig(cfgFile)
@@ -24,32 +33,19 @@ func runLibrarian() error {
 	}
 
 	// Override with command-line flags if provided
-	if mediaDir != "" {
-		cfg.Media.MediaDir = mediaDir
-	}
-	if cacheDir != "" {
-		cfg.Media.CacheDir = cacheDir
-	}
-	if dbPath != "" {
-		cfg.Database.Path = dbPath
-	}
-	if scanOnStart {
-		cfg.Library.ScanOnStart = scanOnStart
-	}
-	if watchForChanges {
-		cfg.Library.WatchForChanges = watchForChanges
-	}
-	if scanIntervalMinutes > 0 {
-		cfg.Library.ScanIntervalMinutes = scanIntervalMinutes
-	}
-	if processingThreads > 0 {
-		cfg.Library.ProcessingThreads = processingThreads
+	applyLibrarianFlags(cfg)
+	if err := setupLogging(cfg); err != nil {
+		return err
 	}
+	defer setupTelemetry(cfg)()
 
 	// Create required directories
 	if err := utils.CreateDirectories(cfg); err != nil {
 		return fmt.Errorf("error creating directories: %w", err)
 	}
+	if err := checkConfig(cfg); err != nil {
+		return err
+	}
 
 	// Initialize database
 	db, err := database.New(cfg.Database.Path)
@@ -58,58 +54,135 @@ func runLibrarian() error {
 	}
 	defer db.Close()
 
+	// Send the watch history to the scrobble targets
+	scrobbler, err := scrobble.New(cfg.Scrobble.Targets, db, logger)
+	if err != nil {
+		return fmt.Errorf("error creating scrobbler: %w", err)
+	}
+
 	// Create transcoding manager
-	tm := transcoder.NewManager(cfg)
+	tm := transcoder.NewManager(cfg, logger)
 
 	// Create library manager
-	lm, err := library.New(cfg, db, tm)
+	lm, err := library.New(cfg, db, tm, logger)
 	if err != nil {
 		return fmt.Errorf("error creating library manager: %w", err)
 	}
-	defer lm.Close()
 
 	// Setup signal handling for graceful shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
-
-	// Start the library manager
-	log.Printf("Starting librarian service")
-	log.Printf("Media directory: %s", cfg.Media.MediaDir)
-	log.Printf("Cache directory: %s", cfg.Media.CacheDir)
-	log.Printf("Database path: %s", cfg.Database.Path)
-	log.Printf("Scan on start: %t", cfg.Library.ScanOnStart)
-	log.Printf("Watch for changes: %t", cfg.Library.WatchForChanges)
-	log.Printf("Scan interval: %d minutes", cfg.Library.ScanIntervalMinutes)
-	log.Printf("Processing threads: %d", cfg.Library.ProcessingThreads)
-
-	// Scan library on start if requested
-	if cfg.Library.ScanOnStart {
-		log.Println("Scanning library for new videos...")
-		if err := lm.ScanLibrary(); err != nil {
-			log.Printf("Error scanning library: %v", err)
-		}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-		// Process pending videos
-		if err := lm.ProcessPendingVideos(); err != nil {
-			log.Printf("Error processing pending videos: %v", err)
-		}
+	sockets, err := activatedSockets(controlSocket)
+	if err != nil {
+		lm.Close()
+		return err
+	}
+
+	logger.Info("Starting librarian service", "version", version.Get().String())
+	reloader := newConfigReloader(database.ActorLibrarian, applyLibrarianFlags, tm, lm, nil)
+	ctl, err := startLibrarian(lm, sockets[controlSocket], reloader)
+	if err != nil {
+		lm.Close()
+		return err
+	}
+	// Record the scheduled network streams into the library
+	rec := dvr.New(cfg, db, tm, lm, ctl, logger)
+	rec.Start()
+	scrobbler.Start()
+	reloader.reloadOnSIGHUP(ctx)
+	if err := reloader.watchConfigFile(ctx); err != nil {
+		logger.Error("Error watching config file", "err", err)
+	}
+	notifyReady(ctx, db, "Watching the library")
+
+	// Wait for interrupt signal
+	<-ctx.Done()
+	logger.Info("Shutting down librarian service")
+	notifyStopping()
+
+	// Keeps what the running recordings recorded, then cancels the
+	// running jobs, which are requeued
+	rec.Stop()
+	scrobbler.Stop()
+	ctl.Stop()
+
+	return nil
+}
+
+// libraryFlagChanged reports whether a flag of the librarian was given on
+// the command line, set by the commands taking them
+var libraryFlagChanged = func(name string) bool { return false }
+
+// applyLibrarianFlags overrides the library settings given on the command line
+func applyLibrarianFlags(c *config.Config) {
+	if mediaDir != "" {
+		c.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		c.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		c.Database.Path = dbPath
+	}
+	// These flags default to true, so they only override the config file
+	// when given
+	if libraryFlagChanged("scan-on-start") {
+		c.Library.ScanOnStart = scanOnStart
+	}
+	if libraryFlagChanged("watch") {
+		c.Library.WatchForChanges = watchForChanges
+	}
+	if scanIntervalMinutes > 0 {
+		c.Library.ScanIntervalMinutes = scanIntervalMinutes
+	}
+	if processingThreads > 0 {
+		c.Library.ProcessingThreads = processingThreads
+	}
+	if controlAddr != "" {
+		c.Library.ControlAddr = controlAddr
+	}
+	applyTranscodeFlags(c)
+}
+
+// startLibrarian starts scanning, watching and processing the library in
+// the background. Scans and processing runs are queued on the returned
+// worker, which also serves the control API on ctlListener when systemd
+// passed a socket, or on the configured address, including reloads through
+// reloader. Stopping the worker closes the library manager.
+func startLibrarian(lm *library.Manager, ctlListener net.Listener, reloader *configReloader) (*control.Server, error) {
+	logLibraries()
+	logger.Info("Librarian settings",
+		"scan_on_start", cfg.Library.ScanOnStart,
+		"watch_for_changes", cfg.Library.WatchForChanges,
+		"scan_interval_minutes", cfg.Library.ScanIntervalMinutes,
+		"processing_threads", cfg.Library.ProcessingThreads)
+
+	// Accept scan and process requests from the server and scripts
+	ctl := control.NewServer(lm, reloader.reloadFromControl, logger)
+	if ctlListener != nil {
+		ctl.StartListener(ctlListener)
+	} else if err := ctl.Start(cfg.Library.ControlAddr); err != nil {
+		return nil, fmt.Errorf("error starting control API: %w", err)
+	}
+
+	// Scan library on start if requested, then process the pending videos
+	if cfg.Library.ScanOnStart {
+		ctl.RequestScan()
 	}
 
 	// Watch for file system changes if requested
 	if cfg.Library.WatchForChanges {
 		if err := lm.StartWatching(); err != nil {
-			log.Printf("Error starting file watcher: %v", err)
+			logger.Error("Error starting file watcher", "err", err)
 		}
 	}
 
-	// Start periodic scanning if interval is set
-	if cfg.Library.ScanIntervalMinutes > 0 {
-		lm.StartPeriodicScan()
-	}
+	// Start periodic scanning, unless the interval is 0
+	lm.StartPeriodicScan()
 
-	// Wait for interrupt signal
-	<-stop
-	log.Println("Shutting down librarian service...")
+	// Purge expired videos from the trash
+	lm.StartTrashPurge()
 
-	return nil
-}
\ No newline at end of file
+	return ctl, nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// videoStatuses are the statuses accepted by --status
+var videoStatuses = []database.VideoStatus{
+	database.StatusPending, database.StatusProcessing, database.StatusReady, database.StatusError,
+}
+
+// listedVideo is a video as printed by list --json
+type listedVideo struct {
+	ID          int64      `json:"id"`
+	Filename    string     `json:"filename"`
+	Path        string     `json:"path"`
+	Library     string     `json:"library,omitempty"`
+	Size        int64      `json:"size"`
+	Duration    float64    `json:"duration"`
+	Status      string     `json:"status"`
+	Error       string     `json:"error,omitempty"`
+	RetryCount  int        `json:"retry_count"`
+	NextRetryAt *time.Time `json:"next_retry_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// videoList is the output of list --json
+type videoList struct {
+	Videos []listedVideo `json:"videos"`
+	Total  int           `json:"total"`
+}
+
+// runList prints the library entries matching the list flags, filtered and
+// sorted like the JSON API does
+func runList() error {
+	opts := database.ListOptions{
+		Sort:  listSort,
+		Desc:  listDesc,
+		Limit: listLimit,
+		Query: strings.TrimSpace(listQuery),
+		Tag:   strings.TrimSpace(listTag),
+	}
+	if !database.ValidSort(opts.Sort) {
+		return fmt.Errorf("invalid --sort %q", opts.Sort)
+	}
+	// Ratings belong to users, which the command has none of
+	if opts.Sort == database.SortRating {
+		return fmt.Errorf("--sort rating needs a user, use the API")
+	}
+	for _, s := range listStatuses {
+		status := database.VideoStatus(s)
+		if !slices.Contains(videoStatuses, status) {
+			return fmt.Errorf("invalid --status %q", s)
+		}
+		opts.Statuses = append(opts.Statuses, status)
+	}
+
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	if listLibrary != "" {
+		for _, lib := range cfg.Libraries {
+			if lib.Name == listLibrary {
+				opts.Dirs = []string{lib.MediaDir}
+			}
+		}
+		if opts.Dirs == nil {
+			return fmt.Errorf("unknown library %q", listLibrary)
+		}
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	page, err := db.ListVideos(opts)
+	if err != nil {
+		return err
+	}
+
+	out := videoList{Videos: make([]listedVideo, 0, len(page.Videos)), Total: page.Total}
+	for _, v := range page.Videos {
+		out.Videos = append(out.Videos, newListedVideo(v))
+	}
+	return printResult(out, func() {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tSTATUS\tDURATION\tSIZE\tPATH")
+		for _, v := range page.Videos {
+			status := string(v.Status)
+			if v.Status == database.StatusError && v.NextRetryAt.Valid {
+				status += " (retry)"
+			} else if v.Status == database.StatusError && v.FailureClass == database.FailureNoSpace {
+				status += " (no space)"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\n", v.ID, status,
+				formatDuration(v.Duration), formatSize(v.Size), v.Path)
+		}
+		w.Flush()
+		if len(page.Videos) < page.Total {
+			fmt.Printf("%d of %d videos shown\n", len(page.Videos), page.Total)
+		}
+	})
+}
+
+// newListedVideo converts a database video for list --json
+func newListedVideo(v *database.Video) listedVideo {
+	lib, _ := cfg.LibraryFor(v.Path)
+	lv := listedVideo{
+		ID:         v.ID,
+		Filename:   v.Filename,
+		Path:       v.Path,
+		Library:    lib.Name,
+		Size:       v.Size,
+		Duration:   v.Duration,
+		Status:     string(v.Status),
+		RetryCount: v.RetryCount,
+		CreatedAt:  v.CreatedAt,
+		UpdatedAt:  v.UpdatedAt,
+	}
+	if v.ErrorMessage.Valid {
+		lv.Error = v.ErrorMessage.String
+	}
+	if v.NextRetryAt.Valid {
+		lv.NextRetryAt = &v.NextRetryAt.Time
+	}
+	return lv
+}
+
+// formatDuration formats seconds as h:mm:ss, "-" when unknown
+func formatDuration(seconds float64) string {
+	if seconds <= 0 {
+		return "-"
+	}
+	s := int(seconds + 0.5)
+	return fmt.Sprintf("%d:%02d:%02d", s/3600, s/60%60, s%60)
+}
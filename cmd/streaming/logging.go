@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/logging"
+	"github.com/kaero/streaming/internal/telemetry"
+)
+
+// logLevel is the level of the shared logger, which changes on reload
+var logLevel = new(slog.LevelVar)
+
+// logger is the logger shared by the services, set up by setupLogging
+var logger = slog.Default()
+
+// logFile is the file the logger writes to when logging.file is set
+var logFile *logging.File
+
+// applyLogFlags overrides the log settings of c with --log-level and
+// --log-format
+func applyLogFlags(c *config.Config) {
+	if logLevelFlag != "" {
+		c.Log.Level = logLevelFlag
+	}
+	if logFormatFlag != "" {
+		c.Log.Format = logFormatFlag
+	}
+}
+
+// setupLogging creates the shared logger for the log settings of c and
+// the log flags, writing to logging.file or stderr. It becomes the default
+// logger too, so messages of the log package go through it.
+func setupLogging(c *config.Config) error {
+	applyLogFlags(c)
+	level, err := logging.ParseLevel(c.Log.Level)
+	if err != nil {
+		return fmt.Errorf("invalid log level: %w", err)
+	}
+
+	var w io.Writer = os.Stderr
+	var file *logging.File
+	if c.Log.File != "" {
+		maxSize := int64(c.Log.MaxSizeMB) << 20
+		maxAge := time.Duration(c.Log.MaxAgeDays) * 24 * time.Hour
+		if file, err = logging.OpenFile(c.Log.File, maxSize, maxAge); err != nil {
+			return err
+		}
+		w = file
+	}
+	l, err := logging.New(w, c.Log.Format, logLevel)
+	if err != nil {
+		if file != nil {
+			file.Close()
+		}
+		return fmt.Errorf("invalid log format: %w", err)
+	}
+
+	logLevel.Set(level)
+	logger = l
+	slog.SetDefault(logger)
+	if logFile != nil {
+		logFile.Close()
+	}
+	logFile = file
+	return nil
+}
+
+// logLibraries logs the media directories and where the cache and the
+// database are
+func logLibraries() {
+	for _, lib := range cfg.MediaLibraries() {
+		if lib.Name == "" {
+			logger.Info("Media directory", "dir", lib.MediaDir)
+		} else {
+			logger.Info("Library", "name", lib.Name, "dir", lib.MediaDir)
+		}
+	}
+	logger.Info("Cache directory", "dir", cfg.Media.CacheDir)
+	logger.Info("Database path", "path", cfg.Database.Path)
+}
+
+// setupTelemetry starts exporting traces when telemetry.enabled is set.
+// The returned function exports the spans left, giving up after a few
+// seconds when the collector doesn't answer.
+func setupTelemetry(c *config.Config) func() {
+	shutdown := telemetry.Setup(c.Telemetry, logger)
+	return func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown(ctx)
+	}
+}
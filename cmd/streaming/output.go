@@ -0,0 +1,51 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+)
+
+// printResult prints the result of a command: with --json as one JSON
+// document on stdout, otherwise as the text written by text
+func printResult(v any, text func()) error {
+	if jsonOutput {
+		return printJSON(os.Stdout, v)
+	}
+	text()
+	return nil
+}
+
+// printJSON writes v to w as indented JSON
+func printJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(v)
+}
+
+// messages returns where commands print progress and questions: stdout,
+// or stderr with --json so stdout only carries the result
+func messages() *os.File {
+	if jsonOutput {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// errorOutput is how errors are printed with --json
+type errorOutput struct {
+	Error string `json:"error"`
+}
+
+// exitWithError prints the error a command failed with and exits with a
+// non-zero status
+func exitWithError(err error) {
+	if jsonOutput {
+		printJSON(os.Stderr, errorOutput{Error: err.Error()})
+	} else {
+		fmt.Println(err)
+	}
+	os.Exit(1)
+}
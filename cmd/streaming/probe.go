@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// probedFile is a video file as printed by probe --json
+type probedFile struct {
+	Path string `json:"path"`
+	// Library is the name of the library the file is in, "" for the media
+	// directory, and null when the librarian doesn't pick it up
+	Library       *string         `json:"library"`
+	Container     string          `json:"container"`
+	Duration      float64         `json:"duration"`
+	VideoCodec    string          `json:"video_codec"`
+	Width         int             `json:"width"`
+	Height        int             `json:"height"`
+	FrameRate     float64         `json:"frame_rate"`
+	BitDepth      int             `json:"bit_depth,omitempty"`
+	HDR           bool            `json:"hdr"`
+	FieldOrder    string          `json:"field_order,omitempty"`
+	Interlaced    bool            `json:"interlaced"`
+	AudioCodec    string          `json:"audio_codec,omitempty"`
+	AudioChannels int             `json:"audio_channels,omitempty"`
+	CoverArt      bool            `json:"cover_art"`
+	Chapters      int             `json:"chapters"`
+	Streams       []probedStream  `json:"streams"`
+	Profile       string          `json:"profile"`
+	Variants      []probedVariant `json:"variants"`
+	Caveats       []string        `json:"caveats"`
+}
+
+// probedStream is a stream of a probed file
+type probedStream struct {
+	Index    int    `json:"index"`
+	Type     string `json:"type"`
+	Codec    string `json:"codec"`
+	Language string `json:"language,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Used     bool   `json:"used"`
+}
+
+// probedVariant is a variant a probed file would be transcoded to
+type probedVariant struct {
+	Width   int    `json:"width"`
+	Height  int    `json:"height"`
+	Bitrate string `json:"bitrate"`
+	Preset  string `json:"preset"`
+}
+
+// runProbe prints the metadata of a video file as the librarian reads it,
+// along with the variants it would be transcoded to
+func runProbe(path string) error {
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	probe, err := transcoder.Probe(abs)
+	if err != nil {
+		return err
+	}
+
+	lib, inLibrary := cfg.LibraryFor(abs)
+	profile := transcoder.NewManager(cfg, logger).ProfileFor(abs)
+	out := newProbedFile(abs, probe, profile)
+	if inLibrary {
+		out.Library = &lib.Name
+	}
+
+	return printResult(out, func() {
+		field := func(name, value string) {
+			fmt.Printf("%-12s %s\n", name+":", value)
+		}
+
+		field("File", abs)
+		if !inLibrary {
+			field("Library", "none, the librarian won't pick this file up")
+		} else if lib.Name != "" {
+			field("Library", lib.Name)
+		}
+		field("Container", probe.Container)
+		field("Duration", fmt.Sprintf("%s (%.3fs)", time.Duration(probe.Duration*float64(time.Second)).Round(time.Second), probe.Duration))
+
+		video := fmt.Sprintf("%s %dx%d, %.3f fps", probe.VideoCodec, probe.Width, probe.Height, probe.FrameRate)
+		if probe.BitDepth > 0 {
+			video += fmt.Sprintf(", %d-bit", probe.BitDepth)
+		}
+		if probe.HDR {
+			video += ", HDR"
+		} else {
+			video += ", SDR"
+		}
+		if probe.Interlaced() {
+			video += ", interlaced (" + probe.FieldOrder + ")"
+		} else if probe.FieldOrder != "" {
+			video += ", " + probe.FieldOrder
+		}
+		field("Video", video)
+
+		if probe.AudioCodec != "" {
+			field("Audio", fmt.Sprintf("%s, %d channels", probe.AudioCodec, probe.AudioChannels))
+		} else {
+			field("Audio", "none")
+		}
+		field("Cover art", yesNo(probe.CoverArt))
+		field("Chapters", fmt.Sprint(len(probe.Chapters)))
+
+		fmt.Println("Streams:")
+		for _, s := range probe.Streams {
+			line := fmt.Sprintf("  #%d %s %s", s.Index, s.Type, s.Codec)
+			if s.Language != "" {
+				line += " " + s.Language
+			}
+			if s.Title != "" {
+				line += fmt.Sprintf(" %q", s.Title)
+			}
+			if s.Used {
+				line += " (used)"
+			}
+			fmt.Println(line)
+		}
+
+		field("Profile", fmt.Sprintf("%s, %s %s, %s at %dk", profile.Name,
+			transcoder.EncoderFor(profile.VideoCodec, profile.HWAccel), profile.Preset, profile.AudioCodec, profile.AudioBitrateKbps))
+		fmt.Println("Variants:")
+		for _, q := range profile.Variants {
+			fmt.Printf("  %s %dx%d at %s\n", q.Name(), q.Width, q.Height, q.Bitrate())
+		}
+
+		if len(out.Caveats) > 0 {
+			fmt.Println("Caveats:")
+			for _, c := range out.Caveats {
+				fmt.Println("  " + c)
+			}
+		}
+	})
+}
+
+// newProbedFile converts the probe of a file for probe --json
+func newProbedFile(path string, probe *transcoder.ProbeResult, profile config.TranscodeProfile) probedFile {
+	out := probedFile{
+		Path:          path,
+		Container:     probe.Container,
+		Duration:      probe.Duration,
+		VideoCodec:    probe.VideoCodec,
+		Width:         probe.Width,
+		Height:        probe.Height,
+		FrameRate:     probe.FrameRate,
+		BitDepth:      probe.BitDepth,
+		HDR:           probe.HDR,
+		FieldOrder:    probe.FieldOrder,
+		Interlaced:    probe.Interlaced(),
+		AudioCodec:    probe.AudioCodec,
+		AudioChannels: probe.AudioChannels,
+		CoverArt:      probe.CoverArt,
+		Chapters:      len(probe.Chapters),
+		Streams:       make([]probedStream, 0, len(probe.Streams)),
+		Profile:       profile.Name,
+		Caveats:       transcoder.Caveats(probe, profile.Variants),
+	}
+	for _, s := range probe.Streams {
+		out.Streams = append(out.Streams, probedStream(s))
+	}
+	for _, q := range profile.Variants {
+		out.Variants = append(out.Variants, probedVariant{
+			Width: q.Width, Height: q.Height, Bitrate: q.Bitrate(), Preset: profile.Preset,
+		})
+	}
+	if out.Caveats == nil {
+		out.Caveats = []string{}
+	}
+	return out
+}
+
+// yesNo formats a boolean for people
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
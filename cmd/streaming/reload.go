@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/device"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/logging"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// configReloader re-reads the config file on SIGHUP, a control request or
+// a change of the file and applies the settings that can change while
+// running. The others are logged, as they only take effect on a restart.
+type configReloader struct {
+	mu      sync.Mutex
+	current *config.Config
+	// flags overrides the settings given on the command line, which win
+	// over the config file on reload too
+	flags   func(*config.Config)
+	tm      *transcoder.Manager
+	lm      *library.Manager
+	devices *device.Profiles // nil without the HTTP server
+	// actor records applied reloads in the audit log
+	actor string
+}
+
+// newConfigReloader creates a reloader for the services running on cfg
+func newConfigReloader(actor string, flags func(*config.Config), tm *transcoder.Manager, lm *library.Manager, devices *device.Profiles) *configReloader {
+	return &configReloader{current: cfg, flags: flags, tm: tm, lm: lm, devices: devices, actor: actor}
+}
+
+// reloadFromControl reloads the configuration for the control API
+func (r *configReloader) reloadFromControl() (applied, restart []string, err error) {
+	return r.reload("control API")
+}
+
+// reload re-reads the configuration and applies it, returning the changed
+// settings that were applied and those that need a restart. Nothing is
+// applied if any of the new settings is invalid, and a service failing to
+// apply them is rolled back to the running configuration. Applied changes
+// are recorded in the audit log with what triggered the reload.
+func (r *configReloader) reload(trigger string) (applied, restart []string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	notifyReloading()
+	defer notifyReloaded()
+
+	next, err := config.Load(cfgFile)
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading config: %w", err)
+	}
+	r.flags(next)
+	applyLogFlags(next)
+
+	reloaded, applied, restart := r.current.Reload(next)
+	if err := checkReloaded(reloaded); err != nil {
+		return nil, nil, err
+	}
+
+	if err := r.apply(reloaded); err != nil {
+		if rollbackErr := r.apply(r.current); rollbackErr != nil {
+			logger.Error("Error restoring the running configuration", "err", rollbackErr)
+		}
+		return nil, nil, err
+	}
+	r.current = reloaded
+
+	if len(applied) > 0 {
+		logger.Info("Configuration reloaded", "trigger", trigger, "applied", strings.Join(applied, ", "))
+		r.lm.LogEvent(database.EventConfigReload, r.actor,
+			fmt.Sprintf("configuration reloaded by %s: %s", trigger, strings.Join(applied, ", ")))
+	} else {
+		logger.Info("Configuration reloaded, nothing to apply", "trigger", trigger)
+	}
+	if len(restart) > 0 {
+		logger.Warn("Restart to apply", "settings", strings.Join(restart, ", "))
+	}
+	return applied, restart, nil
+}
+
+// apply hands a configuration to the running services
+func (r *configReloader) apply(c *config.Config) error {
+	// The library validates the notify targets, so it goes first
+	if err := r.lm.Reload(c); err != nil {
+		return err
+	}
+	if r.devices != nil {
+		if err := r.devices.Replace(c.Devices); err != nil {
+			return fmt.Errorf("error loading device profiles: %w", err)
+		}
+	}
+	r.tm.Reload(c)
+	level, _ := logging.ParseLevel(c.Log.Level)
+	logLevel.Set(level)
+	return nil
+}
+
+// checkReloaded checks the reloadable settings the services don't
+// validate when applying them
+func checkReloaded(c *config.Config) error {
+	if !slices.Contains(config.X264Presets, c.Server.TranscodePreset) {
+		return fmt.Errorf("server.transcode_preset %q is not an x264 preset", c.Server.TranscodePreset)
+	}
+	for _, lib := range c.Libraries {
+		if lib.TranscodePreset != "" && !slices.Contains(config.X264Presets, lib.TranscodePreset) {
+			return fmt.Errorf("library %q: transcode_preset %q is not an x264 preset", lib.Name, lib.TranscodePreset)
+		}
+	}
+	if !slices.Contains(config.HWAccels, c.Server.HWAccel) {
+		return fmt.Errorf("server.hwaccel %q is not one of %s", c.Server.HWAccel, strings.Join(config.HWAccels, ", "))
+	}
+	for _, p := range c.TranscodeProfiles() {
+		if err := p.Check(); err != nil {
+			return fmt.Errorf("transcode profile %q: %w", p.Name, err)
+		}
+	}
+	if c.Library.ScanIntervalMinutes < 0 {
+		return fmt.Errorf("library.scan_interval_minutes must not be negative")
+	}
+	if c.Cache.MaxAgeHours < 0 || c.Cache.MaxSizeGB < 0 || c.Cache.MinFreeDiskGB < 0 {
+		return fmt.Errorf("cache limits must not be negative")
+	}
+	if _, err := device.New(c.Devices); err != nil {
+		return fmt.Errorf("error loading device profiles: %w", err)
+	}
+	if _, err := logging.ParseLevel(c.Log.Level); err != nil {
+		return fmt.Errorf("logging.level: %w", err)
+	}
+	return nil
+}
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives
+// SIGHUP, until ctx is done
+func (r *configReloader) reloadOnSIGHUP(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hup)
+		for {
+			select {
+			case <-hup:
+				logger.Info("Received SIGHUP, reloading configuration")
+				if _, _, err := r.reload("SIGHUP"); err != nil {
+					logger.Error("Error reloading configuration, keeping the current one", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+// configWatchDelay is how long the config file has to stay unchanged
+// before it is reloaded, so editors writing it in steps trigger one reload
+const configWatchDelay = time.Second
+
+// watchConfigFile reloads the configuration whenever the config file
+// changes, until ctx is done. The directory is watched rather than the
+// file, so files replaced by a rename, as editors and Kubernetes config
+// maps do, are followed. Reloads only happen when the content changed.
+func (r *configReloader) watchConfigFile(ctx context.Context) error {
+	r.mu.Lock()
+	path, enabled := r.current.File(), r.current.Server.WatchConfig
+	r.mu.Unlock()
+	if path == "" || !enabled {
+		return nil
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	last, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create config file watcher: %w", err)
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("failed to watch config file: %w", err)
+	}
+	logger.Info("Watching config file for changes", "file", path)
+
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(configWatchDelay)
+		timer.Stop()
+		for {
+			select {
+			case _, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				// Any change in the directory may replace the file, through
+				// a symlink or a rename, so the content decides
+				timer.Reset(configWatchDelay)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.Error("Config file watcher error", "err", err)
+			case <-timer.C:
+				content, err := os.ReadFile(path)
+				if err != nil || bytes.Equal(content, last) {
+					// A missing file is usually being replaced, the next
+					// event brings it back
+					continue
+				}
+				last = content
+				logger.Info("Config file changed, reloading configuration", "file", path)
+				if _, _, err := r.reload("config file change"); err != nil {
+					logger.Error("Error reloading configuration, keeping the current one", "err", err)
+				}
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return nil
+}
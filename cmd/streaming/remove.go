@@ -0,0 +1,154 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// removedVideo is the outcome of removing a video, as printed by
+// remove --json
+type removedVideo struct {
+	ID   int64  `json:"id"`
+	Path string `json:"path"`
+	// Result is "trashed", "deleted" or "deleted_with_source", empty when
+	// the video couldn't be removed
+	Result string `json:"result,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runRemove removes videos, given by ID or path, and their cached output
+// like the delete endpoint of the API: into the trash by default,
+// permanently with --permanent and together with the source file with
+// --source
+func runRemove(args []string) error {
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	// Resolve all videos first, so a typo doesn't leave a partial removal
+	videos := make([]*database.Video, 0, len(args))
+	for _, arg := range args {
+		video, err := findVideo(db, arg)
+		if err != nil {
+			return err
+		}
+		videos = append(videos, video)
+	}
+
+	if removeSource {
+		question := "This deletes the source files of:\n"
+		for _, video := range videos {
+			question += "  " + video.Path + "\n"
+		}
+		ok, err := confirm(question + "Continue?")
+		if err != nil || !ok {
+			return err
+		}
+	}
+
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	permanent := removeSource || removePermanent
+	out := make([]removedVideo, 0, len(videos))
+	failed := 0
+	for _, video := range videos {
+		if permanent {
+			err = lm.DeleteVideo(video.ID, database.ActorCLI, removeSource)
+		} else {
+			err = lm.RemoveVideo(video.ID, database.ActorCLI)
+		}
+		removed := removedVideo{ID: video.ID, Path: video.Path}
+		switch {
+		case err != nil:
+			removed.Error = err.Error()
+			failed++
+		case removeSource:
+			removed.Result = "deleted_with_source"
+		case permanent:
+			removed.Result = "deleted"
+		default:
+			removed.Result = "trashed"
+		}
+		out = append(out, removed)
+	}
+
+	err = printResult(out, func() {
+		for _, removed := range out {
+			switch removed.Result {
+			case "":
+				fmt.Printf("error removing %s: %s\n", removed.Path, removed.Error)
+			case "deleted_with_source":
+				fmt.Printf("deleted %s and its source file\n", removed.Path)
+			case "deleted":
+				fmt.Printf("deleted %s\n", removed.Path)
+			default:
+				fmt.Printf("moved %s to the trash\n", removed.Path)
+			}
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d videos not removed", failed, len(videos))
+	}
+	return nil
+}
+
+// findVideo looks up a video by ID or by the path of its source file
+func findVideo(db *database.DB, arg string) (*database.Video, error) {
+	if id, err := strconv.ParseInt(arg, 10, 64); err == nil {
+		video, err := db.GetVideo(id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, fmt.Errorf("no video with ID %d", id)
+		}
+		return video, err
+	}
+
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, err
+	}
+	video, err := db.GetVideoByPath(abs)
+	if err != nil {
+		return nil, err
+	}
+	if video == nil {
+		return nil, fmt.Errorf("%s is not in the library", abs)
+	}
+	return video, nil
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"os"
+	"time"
 
 	"github.com/spf13/cobra"
 
@@ -21,6 +22,41 @@ var (
 	watchForChanges    bool
 	scanIntervalMinutes int
 	processingThreads  int
+	controlAddr        string
+	recordTranscode    bool
+	scanProcess        bool
+	scanThreads        int
+	pruneOlderThan     string
+	pruneMaxSize       string
+	pruneVideo         int64
+	pruneDryRun        bool
+	fsckDryRun         bool
+	verifyVideo        int64
+	verifyQuick        bool
+	verifyRepair       bool
+	assumeYes          bool
+	showSecrets        bool
+	listStatuses       []string
+	listQuery          string
+	listTag            string
+	listLibrary        string
+	listSort           string
+	listDesc           bool
+	listLimit          int
+	removePermanent    bool
+	removeSource       bool
+	topURL             string
+	topAPIKey          string
+	topInterval        time.Duration
+	benchDuration      time.Duration
+	benchPresets       []string
+	benchMinSpeed      float64
+	jsonOutput         bool
+	logLevelFlag       string
+	logFormatFlag      string
+	transcodePreset    string
+	segmentDuration    int
+	hwAccel            string
 )
 
 // rootCmd represents the base command when called without any subcommands
@@ -32,9 +68,10 @@ var rootCmd = &cobra.Command{
 This application has two main components:
 1. 'streaming' server - Serves videos and handles user requests
 2. 'librarian' - Processes videos in the background
+'standalone' runs both in one process.
     
 It can be configured using command line flags, environment variables,
-or a TOML configuration file.`,
+or a TOML, YAML or JSON configuration file.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		// Generate config file if requested
 		if genConfig {
@@ -55,6 +92,23 @@ or a TOML configuration file.`,
 	},
 }
 
+// initCmd represents the init subcommand
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up a new installation",
+	Long: `Asks for the media and cache directories, the database file, the port
+and how to log in, then writes them to a new config file, ./config.toml
+unless --config is given. It creates the directories, the database and
+an admin user and checks that FFmpeg is usable. --yes accepts every
+default without asking, generating the admin password.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runInit(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
 // streamingCmd represents the streaming subcommand
 var streamingCmd = &cobra.Command{
 	Use:   "streaming",
@@ -64,8 +118,7 @@ The streaming server serves preprocessed videos from the library
 and handles user requests.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runServer(); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			exitWithError(err)
 		}
 	},
 }
@@ -77,9 +130,326 @@ var librarianCmd = &cobra.Command{
 	Long: `Starts the library processing service that scans for new videos
 and processes them in the background.`,
 	Run: func(cmd *cobra.Command, args []string) {
+		libraryFlagChanged = cmd.Flags().Changed
 		if err := runLibrarian(); err != nil {
-			fmt.Println(err)
-			os.Exit(1)
+			exitWithError(err)
+		}
+	},
+}
+
+// standaloneCmd represents the standalone subcommand
+var standaloneCmd = &cobra.Command{
+	Use:   "standalone",
+	Short: "Start the server and librarian in one process",
+	Long: `Starts the HTTP streaming server and the library processing service
+in one process sharing the database. Scans requested in the web UI are
+processed right away, and jobs can be cancelled through the API. Suits
+small setups that don't need to run the two separately.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		libraryFlagChanged = cmd.Flags().Changed
+		if err := runStandalone(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// transcodeCmd represents the transcode subcommand
+var transcodeCmd = &cobra.Command{
+	Use:   "transcode <file or directory>...",
+	Short: "Transcode videos into the cache",
+	Long: `Transcodes video files, or all videos in directories, with the
+configured settings and writes them to the cache like the librarian does.
+Progress is printed to the terminal. The library isn't changed unless
+--record is given, which marks the videos ready so they aren't processed
+again.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runTranscode(args); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// scanCmd represents the scan subcommand
+var scanCmd = &cobra.Command{
+	Use:   "scan",
+	Short: "Scan the media directories once",
+	Long: `Scans the media directories once, like the librarian does on start,
+prints a summary and exits. With --process the pending videos are
+processed before exiting. Useful from cron or a download client's
+post-processing hook when no librarian is running.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runScan(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// probeCmd represents the probe subcommand
+var probeCmd = &cobra.Command{
+	Use:   "probe <file>",
+	Short: "Show how the librarian reads a video file",
+	Long: `Runs ffprobe on a video file and prints the metadata as the librarian
+interprets it: the streams it uses, codecs, duration, HDR and interlacing,
+followed by the variants the file would be transcoded to and known problems
+with them.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runProbe(args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// benchCmd represents the bench subcommand
+var benchCmd = &cobra.Command{
+	Use:   "bench [file]",
+	Short: "Measure transcoding speed to pick a preset",
+	Long: `Encodes the start of a video file, or a generated test pattern, to the
+largest variant with each x264 preset and every hardware encoder FFmpeg
+has, one after another, and prints the frames per second, the speed
+relative to playback and the output size of each. It then recommends the
+slowest preset, which compresses best, that is still fast enough for
+this machine to keep up. Nothing is written to the cache or library.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runBench(args); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// listCmd represents the list subcommand
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the videos in the library",
+	Long: `Lists the videos in the library with their status, filtered and
+sorted like the JSON API's video list. --json prints them as JSON for
+scripts. Videos in the trash aren't listed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runList(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// removeCmd represents the remove subcommand
+var removeCmd = &cobra.Command{
+	Use:   "remove <id or path>...",
+	Short: "Remove videos from the library",
+	Long: `Removes videos, given by ID or source file path, from the library
+together with their cached output, like the API's delete endpoint. They
+are moved to the trash unless --permanent is given. --source deletes the
+source files as well, after asking for confirmation; otherwise the next
+scan adds permanently deleted videos again.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRemove(args); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// topCmd represents the top subcommand
+var topCmd = &cobra.Command{
+	Use:   "top",
+	Short: "Show a live dashboard of a running server",
+	Long: `Shows the transcode jobs with their progress, the processing queue,
+the active playback sessions and the latest events of a running server,
+refreshed every second until interrupted. Events are received over the
+control channel, the rest is read from the JSON API, so an admin API key
+is needed; the configured admin token is used by default. With --json
+the state is printed once as JSON.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runTop(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// cacheCmd groups the cache management subcommands
+var cacheCmd = &cobra.Command{
+	Use:   "cache",
+	Short: "Manage the transcoding cache",
+}
+
+// cachePruneCmd represents the cache prune subcommand
+var cachePruneCmd = &cobra.Command{
+	Use:   "prune",
+	Short: "Remove cached output by age or size",
+	Long: `Removes transcoded output from the cache, least recently streamed
+first, with the same rules as the server's hourly cleanup. Directories
+that haven't been streamed for --older-than are removed, and more are
+removed until the cache fits in --max-size. --video limits pruning to
+one video, or removes its output when no other limit is given.
+Directories a running job writes to are skipped.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCachePrune(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// cacheFsckCmd represents the cache fsck subcommand
+var cacheFsckCmd = &cobra.Command{
+	Use:   "fsck",
+	Short: "Reconcile the cache with the database",
+	Long: `Reconciles the cache with the database after crashes or manual
+deletions, like the server does every cache.fsck_interval_hours.
+Cache directories no video uses are removed, and ready videos whose
+output is missing are queued for reprocessing. Output that was evicted
+or purged is left alone, as are directories a running job writes to.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCacheFsck(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// cacheVerifyCmd represents the cache verify subcommand
+var cacheVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check cached output for damage",
+	Long: `Checks the output of ready videos: the master playlist lists every
+variant of the cache inventory, every variant playlist is complete, its
+segments exist and they still match the checksum recorded when they were
+transcoded. Broken variants are reported and, with --repair, queued to
+be transcoded again without the rest of the video. Exits with an error
+when broken variants were found and not repaired.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runCacheVerify(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// dbCmd groups the database administration subcommands
+var dbCmd = &cobra.Command{
+	Use:   "db",
+	Short: "Administer the library database",
+}
+
+// dbMigrateCmd represents the db migrate subcommand
+var dbMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Apply pending schema migrations",
+	Long: `Applies the schema migrations the database is missing. The server and
+librarian do this on start as well; running it separately lets upgrades
+be checked before starting them.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDBMigrate(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// dbBackupCmd represents the db backup subcommand
+var dbBackupCmd = &cobra.Command{
+	Use:   "backup [file]",
+	Short: "Write a consistent copy of the database",
+	Long: `Writes a consistent copy of the database to file, by default a
+timestamped file next to it. The server and librarian can keep running.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		dest := ""
+		if len(args) > 0 {
+			dest = args[0]
+		}
+		if err := runDBBackup(dest); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// dbRestoreCmd represents the db restore subcommand
+var dbRestoreCmd = &cobra.Command{
+	Use:   "restore <file>",
+	Short: "Replace the database with a backup",
+	Long: `Replaces the database with a backup after checking that it is intact,
+then migrates it. The current database is backed up next to it first.
+Stop the server and librarian before restoring.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDBRestore(args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// dbVacuumCmd represents the db vacuum subcommand
+var dbVacuumCmd = &cobra.Command{
+	Use:   "vacuum",
+	Short: "Reclaim the space of deleted rows",
+	Long: `Rebuilds the database file so the space of deleted rows is returned
+to the file system. Other processes have to wait while it runs.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDBVacuum(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// configCmd groups the configuration subcommands
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+// configValidateCmd represents the config validate subcommand
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration and print the effective settings",
+	Long: `Loads the configuration from the config file, environment and flags,
+prints the effective settings and checks them: unknown settings, ports,
+paths, transcode settings, libraries, device profiles, notify and
+scrobble targets, TLS and the FFmpeg installation. Exits with a non-zero
+status if any problem is found. Nothing is created or changed.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runConfigValidate(); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// scrobbleCmd groups the scrobbling subcommands
+var scrobbleCmd = &cobra.Command{
+	Use:   "scrobble",
+	Short: "Manage the services the watch history is sent to",
+}
+
+// scrobbleLoginCmd represents the scrobble login subcommand
+var scrobbleLoginCmd = &cobra.Command{
+	Use:   "login <target>",
+	Short: "Log a Trakt scrobble target in to an account",
+	Long: `Logs the Trakt scrobble target of that name in to a Trakt account: enter
+the code shown on the Trakt website and approve the app. The tokens are
+stored in the database, so the librarian needs no restart.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runScrobbleLogin(args[0]); err != nil {
+			exitWithError(err)
+		}
+	},
+}
+
+// versionCmd represents the version subcommand
+var versionCmd = &cobra.Command{
+	Use:   "version",
+	Short: "Print the version and build information",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runVersion(); err != nil {
+			exitWithError(err)
 		}
 	},
 }
@@ -87,8 +457,7 @@ and processes them in the background.`,
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		exitWithError(err)
 	}
 }
 
@@ -97,31 +466,138 @@ func init() {
 	cobra.OnInitialize(initConfig)
 
 	// Define global persistent flags
-	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is ./config.toml)")
+	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file in TOML, YAML or JSON (default is ./config.toml)")
 	rootCmd.PersistentFlags().StringVar(&mediaDir, "media-dir", "", "directory containing media files")
 	rootCmd.PersistentFlags().StringVar(&cacheDir, "cache-dir", "", "directory for cached transcoded files")
 	rootCmd.PersistentFlags().StringVar(&dbPath, "db-path", "", "path to the SQLite database file")
 	rootCmd.PersistentFlags().BoolVar(&genConfig, "gen-config", false, "generate a default config file")
+	rootCmd.PersistentFlags().BoolVar(&jsonOutput, "json", false, "print results and errors as JSON for scripts")
+	rootCmd.PersistentFlags().StringVar(&logLevelFlag, "log-level", "", "log level: debug, info, warn or error (default from config)")
+	rootCmd.PersistentFlags().StringVar(&logFormatFlag, "log-format", "", "log format: text or json (default from config)")
+
+	// Init specific flags
+	initCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "accept the defaults without asking")
 
 	// Streaming server specific flags
 	streamingCmd.Flags().StringVar(&listenHost, "host", "", "host to listen on")
 	streamingCmd.Flags().IntVar(&listenPort, "port", 0, "port to listen on")
+	streamingCmd.Flags().IntVar(&segmentDuration, "segment-duration", 0, "HLS segment duration in seconds (default from config)")
 
 	// Librarian specific flags
 	librarianCmd.Flags().BoolVar(&scanOnStart, "scan-on-start", true, "scan for new videos on start")
 	librarianCmd.Flags().BoolVar(&watchForChanges, "watch", true, "watch for file system changes")
-	librarianCmd.Flags().IntVar(&scanIntervalMinutes, "scan-interval", 60, "interval between scans (minutes)")
-	librarianCmd.Flags().IntVar(&processingThreads, "threads", 2, "number of processing threads")
+	librarianCmd.Flags().IntVar(&scanIntervalMinutes, "scan-interval", 0, "interval between scans in minutes (default from config)")
+	librarianCmd.Flags().IntVar(&processingThreads, "threads", 0, "number of processing threads (default from config)")
+	librarianCmd.Flags().StringVar(&controlAddr, "control-addr", "", "control API address, host:port or unix:/path")
+	librarianCmd.Flags().StringVar(&transcodePreset, "preset", "", "x264 preset for all libraries (default from config)")
+	librarianCmd.Flags().IntVar(&segmentDuration, "segment-duration", 0, "HLS segment duration in seconds (default from config)")
+	librarianCmd.Flags().StringVar(&hwAccel, "hwaccel", "", "hardware encoder: none, nvenc, qsv, vaapi or videotoolbox (default from config)")
+
+	// Standalone flags, those of both the server and the librarian
+	standaloneCmd.Flags().StringVar(&listenHost, "host", "", "host to listen on")
+	standaloneCmd.Flags().IntVar(&listenPort, "port", 0, "port to listen on")
+	standaloneCmd.Flags().BoolVar(&scanOnStart, "scan-on-start", true, "scan for new videos on start")
+	standaloneCmd.Flags().BoolVar(&watchForChanges, "watch", true, "watch for file system changes")
+	standaloneCmd.Flags().IntVar(&scanIntervalMinutes, "scan-interval", 0, "interval between scans in minutes (default from config)")
+	standaloneCmd.Flags().IntVar(&processingThreads, "threads", 0, "number of processing threads (default from config)")
+	standaloneCmd.Flags().StringVar(&controlAddr, "control-addr", "", "control API address, host:port or unix:/path")
+	standaloneCmd.Flags().StringVar(&transcodePreset, "preset", "", "x264 preset for all libraries (default from config)")
+	standaloneCmd.Flags().IntVar(&segmentDuration, "segment-duration", 0, "HLS segment duration in seconds (default from config)")
+	standaloneCmd.Flags().StringVar(&hwAccel, "hwaccel", "", "hardware encoder: none, nvenc, qsv, vaapi or videotoolbox (default from config)")
+
+	// Transcode specific flags
+	transcodeCmd.Flags().BoolVar(&recordTranscode, "record", false, "mark the videos ready in the library")
+	transcodeCmd.Flags().StringVar(&transcodePreset, "preset", "", "x264 preset for all libraries (default from config)")
+	transcodeCmd.Flags().IntVar(&segmentDuration, "segment-duration", 0, "HLS segment duration in seconds (default from config)")
+	transcodeCmd.Flags().StringVar(&hwAccel, "hwaccel", "", "hardware encoder: none, nvenc, qsv, vaapi or videotoolbox (default from config)")
+
+	// Scan specific flags
+	scanCmd.Flags().BoolVar(&scanProcess, "process", false, "process pending videos after scanning")
+	scanCmd.Flags().IntVar(&scanThreads, "threads", 0, "number of processing threads (default from config)")
+
+	// Bench specific flags
+	benchCmd.Flags().DurationVar(&benchDuration, "duration", 20*time.Second, "length of the sample to encode")
+	benchCmd.Flags().StringSliceVar(&benchPresets, "presets", []string{"ultrafast", "superfast", "veryfast", "faster", "fast", "medium"}, "x264 presets to benchmark")
+	benchCmd.Flags().Float64Var(&benchMinSpeed, "min-speed", 2, "realtime multiple a preset needs to be recommended")
+
+	// List specific flags
+	listCmd.Flags().StringSliceVar(&listStatuses, "status", nil, "only list videos with these statuses: pending, processing, ready, error")
+	listCmd.Flags().StringVar(&listQuery, "query", "", "only list videos whose filename matches the search words")
+	listCmd.Flags().StringVar(&listTag, "tag", "", "only list videos with this tag")
+	listCmd.Flags().StringVar(&listLibrary, "library", "", "only list videos of this library")
+	listCmd.Flags().StringVar(&listSort, "sort", "name", "sort by name, size, added, updated or duration")
+	listCmd.Flags().BoolVar(&listDesc, "desc", false, "reverse the sort order")
+	listCmd.Flags().IntVar(&listLimit, "limit", 0, "list at most this many videos")
+
+	// Remove specific flags
+	removeCmd.Flags().BoolVar(&removePermanent, "permanent", false, "delete the videos instead of moving them to the trash")
+	removeCmd.Flags().BoolVar(&removeSource, "source", false, "delete the source files too, implies --permanent")
+	removeCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation")
+
+	// Top specific flags
+	topCmd.Flags().StringVar(&topURL, "url", "", "server URL (default from the server settings)")
+	topCmd.Flags().StringVar(&topAPIKey, "api-key", "", "admin API key (default auth.admin_token)")
+	topCmd.Flags().DurationVar(&topInterval, "interval", time.Second, "refresh interval")
+
+	// Cache prune specific flags
+	cachePruneCmd.Flags().StringVar(&pruneOlderThan, "older-than", "", "remove output not streamed for this long, e.g. 12h or 7d")
+	cachePruneCmd.Flags().StringVar(&pruneMaxSize, "max-size", "", "remove the least recently streamed output until the cache fits, e.g. 50G")
+	cachePruneCmd.Flags().Int64Var(&pruneVideo, "video", 0, "only prune the output of the video with this ID")
+	cachePruneCmd.Flags().BoolVar(&pruneDryRun, "dry-run", false, "print what would be removed without removing it")
+	cacheCmd.AddCommand(cachePruneCmd)
+
+	// Cache fsck specific flags
+	cacheFsckCmd.Flags().BoolVar(&fsckDryRun, "dry-run", false, "print what would be changed without changing it")
+	cacheCmd.AddCommand(cacheFsckCmd)
+
+	// Cache verify specific flags
+	cacheVerifyCmd.Flags().Int64Var(&verifyVideo, "video", 0, "only verify the video with this ID")
+	cacheVerifyCmd.Flags().BoolVar(&verifyQuick, "quick", false, "skip the checksums, which reads every segment")
+	cacheVerifyCmd.Flags().BoolVar(&verifyRepair, "repair", false, "queue broken variants to be transcoded again")
+	cacheCmd.AddCommand(cacheVerifyCmd)
+
+	// Database specific flags
+	dbRestoreCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "don't ask for confirmation")
+	dbCmd.AddCommand(dbMigrateCmd)
+	dbCmd.AddCommand(dbBackupCmd)
+	dbCmd.AddCommand(dbRestoreCmd)
+	dbCmd.AddCommand(dbVacuumCmd)
+
+	// Config specific flags
+	configValidateCmd.Flags().BoolVar(&showSecrets, "show-secrets", false, "print tokens, passwords and webhook URLs instead of redacting them")
+	configCmd.AddCommand(configValidateCmd)
+
+	scrobbleCmd.AddCommand(scrobbleLoginCmd)
 
 	// Add subcommands
+	rootCmd.AddCommand(initCmd)
 	rootCmd.AddCommand(streamingCmd)
 	rootCmd.AddCommand(librarianCmd)
+	rootCmd.AddCommand(standaloneCmd)
+	rootCmd.AddCommand(transcodeCmd)
+	rootCmd.AddCommand(scanCmd)
+	rootCmd.AddCommand(probeCmd)
+	rootCmd.AddCommand(benchCmd)
+	rootCmd.AddCommand(listCmd)
+	rootCmd.AddCommand(removeCmd)
+	rootCmd.AddCommand(topCmd)
+	rootCmd.AddCommand(cacheCmd)
+	rootCmd.AddCommand(dbCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(scrobbleCmd)
+	rootCmd.AddCommand(versionCmd)
 }
 
 // initConfig reads in config file and ENV variables if set.
 func initConfig() {
 	// This is just to prepare for reading the config.
-	// Actual config loading happens in command functions.
+	// Actual config loading happens in command functions, which set up
+	// logging for the configured level and format. Until then the log
+	// flags apply.
+	defaults := &config.Config{Log: config.LogConfig{Level: config.DefaultLogLevel, Format: config.DefaultLogFormat}}
+	if err := setupLogging(defaults); err != nil {
+		exitWithError(err)
+	}
 }
 
 // Configuration variable used globally
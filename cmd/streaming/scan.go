@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/utils"
+)
+
+// scanOutput is the result of scan --json
+type scanOutput struct {
+	Added   int `json:"added"`
+	Updated int `json:"updated"`
+	// Removed are the paths of the videos whose files are gone
+	Removed   []string       `json:"removed"`
+	Processed *processOutput `json:"processed,omitempty"`
+}
+
+// processOutput is the result of scan --process --json
+type processOutput struct {
+	Ready  int `json:"ready"`
+	Failed int `json:"failed"`
+	// Pending videos were left pending when processing was interrupted
+	Pending int `json:"pending"`
+}
+
+// runScan scans the media directories once, optionally processes the
+// pending videos, and prints a summary
+func runScan() error {
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+
+	// Override with command-line flags if provided
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+	if scanThreads > 0 {
+		cfg.Library.ProcessingThreads = scanThreads
+	}
+
+	if err := utils.CreateDirectories(cfg); err != nil {
+		return fmt.Errorf("error creating directories: %w", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	summary, err := lm.Scan(database.ActorCLI)
+	if err != nil {
+		return fmt.Errorf("error scanning library: %w", err)
+	}
+	out := scanOutput{Added: summary.Added, Updated: summary.Updated, Removed: make([]string, 0, len(summary.Removed))}
+	for _, video := range summary.Removed {
+		out.Removed = append(out.Removed, video.Path)
+	}
+	printScan := func() {
+		fmt.Printf("Scan: %d added, %d updated, %d removed\n", out.Added, out.Updated, len(out.Removed))
+		for _, path := range out.Removed {
+			fmt.Printf("  removed %s\n", path)
+		}
+	}
+
+	if !scanProcess {
+		return printResult(out, printScan)
+	}
+	// The scan is printed right away, processing can take long
+	if !jsonOutput {
+		printScan()
+	}
+
+	// Ctrl-C cancels the running jobs and leaves the rest pending
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	processed, err := lm.ProcessPending(ctx)
+	if err != nil {
+		return fmt.Errorf("error processing pending videos: %w", err)
+	}
+	out.Processed = &processOutput{Ready: processed.Ready, Failed: processed.Failed, Pending: processed.Skipped}
+	err = printResult(out, func() {
+		fmt.Printf("Processing: %d ready, %d failed, %d left pending\n",
+			processed.Ready, processed.Failed, processed.Skipped)
+	})
+	if err != nil {
+		return err
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("processing interrupted")
+	}
+	if processed.Failed > 0 {
+		return fmt.Errorf("%d videos failed to process", processed.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"slices"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/scrobble"
+)
+
+// runScrobbleLogin logs a Trakt scrobble target in to the account of the
+// user, who approves it on the Trakt website
+func runScrobbleLogin(name string) error {
+	path, err := databasePath()
+	if err != nil {
+		return err
+	}
+	// Checks the targets like the librarian does
+	if _, err := scrobble.New(cfg.Scrobble.Targets, nil, logger); err != nil {
+		return err
+	}
+	i := slices.IndexFunc(cfg.Scrobble.Targets, func(t config.ScrobbleTarget) bool { return t.Name == name })
+	if i < 0 {
+		return fmt.Errorf("there is no scrobble target %q", name)
+	}
+
+	db, err := database.New(path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	err = scrobble.Login(ctx, cfg.Scrobble.Targets[i], db, func(code scrobble.DeviceCode) {
+		fmt.Printf("Go to %s and enter the code %s\n", code.VerificationURL, code.UserCode)
+		fmt.Println("Waiting for approval...")
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Logged in, the watch history is sent to %s from now on\n", name)
+	return nil
+}
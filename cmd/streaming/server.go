@@ -1,23 +1,49 @@
 package main
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/cache"
+	"github.com/kaero/streaming/internal/control"
 	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/device"
+	"github.com/kaero/streaming/internal/dav"
+	"github.com/kaero/streaming/internal/dlna"
+	"github.com/kaero/streaming/internal/events"
 	"github.com/kaero/streaming/internal/handlers"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/live"
+	"github.com/kaero/streaming/internal/middleware"
 	"github.com/kaero/streaming/internal/templates"
 	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/upload"
 	"github.com/kaero/streaming/internal/utils"
+	"github.com/kaero/streaming/internal/version"
 )
 
+// shutdownTimeout is how long running requests may take to finish on
+// shutdown
+const shutdownTimeout = 10 * time.Second
+
 // runServer sets up and starts the HTTP server
 func runServer() error {
+	if err := checkTranscodeFlags(); err != nil {
+		return err
+	}
+
 	// Load configuration
 	var err error
 	cfg, err = config.InitConfig(cfgFile)
@@ -26,26 +52,19 @@ func runServer() error {
 	}
 
 	// Override with command-line flags if provided
-	if mediaDir != "" {
-		cfg.Media.MediaDir = mediaDir
-	}
-	if cacheDir != "" {
-		cfg.Media.CacheDir = cacheDir
-	}
-	if dbPath != "" {
-		cfg.Database.Path = dbPath
-	}
-	if listenHost != "" {
-		cfg.Server.Host = listenHost
-	}
-	if listenPort != 0 {
-		cfg.Server.Port = listenPort
+	applyServerFlags(cfg)
+	if err := setupLogging(cfg); err != nil {
+		return err
 	}
+	defer setupTelemetry(cfg)()
 
 	// Create required directories
 	if err := utils.CreateDirectories(cfg); err != nil {
 		return fmt.Errorf("error creating directories: %w", err)
 	}
+	if err := checkConfig(cfg); err != nil {
+		return err
+	}
 
 	// Initialize database
 	db, err := database.New(cfg.Database.Path)
@@ -55,63 +74,410 @@ func runServer() error {
 	defer db.Close()
 
 	// Create transcoding manager
-	tm := transcoder.NewManager(cfg)
-	
+	tm := transcoder.NewManager(cfg, logger)
+
+	// Create library manager for API actions such as deleting videos
+	lm, err := library.New(cfg, db, tm, logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+	defer lm.Close()
+
+	// Load the device profiles limiting master playlists
+	devices, err := device.New(cfg.Devices)
+	if err != nil {
+		return fmt.Errorf("error loading device profiles: %w", err)
+	}
+
+	// Setup signal handling for graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sockets, err := activatedSockets(httpSocket)
+	if err != nil {
+		return err
+	}
+
+	// Scans, processing and cancels requested in the web UI and the API
+	// run in the librarian, reached through its control API
+	jobs := control.NewClient(cfg.Library.ControlAddr)
+	stopServer, err := startServer(db, tm, lm, devices, sockets[httpSocket], jobs)
+	if err != nil {
+		return err
+	}
+	reloader := newConfigReloader(database.ActorServer, applyServerFlags, tm, lm, devices)
+	reloader.reloadOnSIGHUP(ctx)
+	if err := reloader.watchConfigFile(ctx); err != nil {
+		logger.Error("Error watching config file", "err", err)
+	}
+	notifyReady(ctx, db, "Serving HTTP")
+
+	// Wait for interrupt signal
+	<-ctx.Done()
+	logger.Info("Shutting down server")
+	notifyStopping()
+	stopServer()
+
+	return nil
+}
+
+// applyServerFlags overrides the server settings given on the command line
+func applyServerFlags(c *config.Config) {
+	if mediaDir != "" {
+		c.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		c.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		c.Database.Path = dbPath
+	}
+	if listenHost != "" {
+		c.Server.Host = listenHost
+	}
+	if listenPort != 0 {
+		c.Server.Port = listenPort
+	}
+	if segmentDuration > 0 {
+		c.Server.SegmentDuration = segmentDuration
+	}
+}
+
+// startServer starts the HTTP server on top of the shared database,
+// library manager and device profiles. It serves on ln when systemd passed a socket, and
+// listens on the configured address otherwise. Library scans, processing
+// and cancels requested by the web UI and the API run through jobs. The
+// returned function stops the server, letting running requests finish for
+// a while.
+func startServer(db *database.DB, tm *transcoder.Manager, lm *library.Manager, devices *device.Profiles, ln net.Listener, jobs control.Jobs) (func(), error) {
 	// Initialize templates
-	tmpl := templates.New()
+	theme := templates.Theme{Default: cfg.UI.Theme, Accent: cfg.UI.AccentColor}
+	if cfg.UI.Logo != "" {
+		theme.Logo = cfg.Server.Path("/logo")
+	}
+	tmpl := templates.New(cfg.Server.BasePath, theme)
+
+	// Stage uploads inside the media directory they go to. Without a local
+	// library there is nowhere to put them.
+	var uploads *upload.Store
+	if lib, ok := cfg.UploadLibrary(); ok {
+		var err error
+		uploads, err = upload.NewStore(filepath.Join(lib.MediaDir, handlers.UploadDirName), logger)
+		if err != nil {
+			return nil, fmt.Errorf("error creating upload store: %w", err)
+		}
+	}
+
+	// Create the authenticator for API keys and logins
+	authn, err := auth.New(cfg, db, logger)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing authentication: %w", err)
+	}
+
+	// Track cache accesses for eviction
+	tracker := cache.NewAccessTracker(db, 30*time.Second, logger)
+
+	// Push new audit log events to control channel clients
+	hub := events.NewHub(db, 1*time.Second, logger)
+
+	// Receive the streams of the live channels and play the playlist
+	// channels, if any
+	var lv *live.Manager
+	if len(cfg.Live.Channels) > 0 {
+		if lv, err = live.New(cfg, db, tm, logger); err != nil {
+			return nil, fmt.Errorf("error starting live channels: %w", err)
+		}
+	}
 
 	// Create HTTP handlers
-	h := handlers.NewHandler(cfg, tm, tmpl, db)
+	h := handlers.NewHandler(cfg, tm, tmpl, db, lm, tracker, uploads, hub, authn, devices, lv, jobs, logger)
+
+	admin := func(hf http.HandlerFunc) http.HandlerFunc { return authn.Require(auth.ScopeAdmin, hf) }
+	read := func(hf http.HandlerFunc) http.HandlerFunc { return authn.Allow(auth.ScopeRead, hf) }
+	write := func(hf http.HandlerFunc) http.HandlerFunc { return authn.Allow(auth.ScopeWrite, hf) }
 
 	// Setup HTTP routes
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", h.ListVideosHandler)
-	mux.HandleFunc("/video/", h.VideoHandler)
-	mux.HandleFunc("/stream/", h.StreamHandler)
-	mux.HandleFunc("/player/", h.PlayerHandler)
+	mux.HandleFunc("/", authn.Stream(h.ListVideosHandler))
+	mux.HandleFunc("/video/", authn.Stream(h.VideoHandler))
+	mux.HandleFunc("/stream/", authn.Stream(h.StreamHandler))
+	mux.HandleFunc("/player/", authn.Stream(h.PlayerHandler))
+	mux.HandleFunc("GET /direct/", authn.Stream(h.DirectHandler))
+	mux.HandleFunc("GET /download/", read(h.DownloadHandler))
+	mux.HandleFunc("GET /feeds/{format}", authn.Stream(h.FeedHandler))
+	mux.HandleFunc("GET /feeds/tags/{tag}/{format}", authn.Stream(h.FeedHandler))
+	mux.HandleFunc("GET /feeds/series/{series}/{format}", authn.Stream(h.FeedHandler))
+	mux.HandleFunc("GET /live/{channel}", authn.Stream(h.LivePageHandler))
+	mux.HandleFunc("GET /live/{channel}/{file}", authn.Stream(h.LiveStreamHandler))
+	mux.HandleFunc("GET /live/{channel}/v/{path...}", authn.Stream(h.LiveVideoHandler))
+	mux.HandleFunc("POST /live/{channel}/whep", authn.Stream(h.LiveWHEPHandler))
+	mux.HandleFunc("DELETE /live/{channel}/whep/{id}", authn.Stream(h.LiveWHEPEndHandler))
+	mux.HandleFunc("GET /live/"+config.LiveEPGFile, authn.Stream(h.EPGHandler))
+	// Cast devices can't send credentials, the cast token in the path
+	// authenticates them
+	mux.HandleFunc("/cast/{token}/{path...}", h.CastHandler)
+	mux.HandleFunc("GET /iptv/playlist.m3u", authn.Stream(h.IPTVPlaylistHandler))
+	mux.HandleFunc("GET /iptv/videos/{id}", authn.Stream(h.IPTVVideoHandler))
+
+	// Scripts, styles and the logo of the pages, which hold nothing
+	// private
+	mux.Handle("GET "+templates.StaticPrefix, templates.StaticHandler())
+	mux.HandleFunc("GET /logo", h.LogoHandler)
+
+	// Health checks
+	mux.HandleFunc("GET /healthz", h.HealthHandler)
+	mux.HandleFunc("GET /readyz", h.ReadyHandler)
+
+	// Login
+	mux.HandleFunc("GET /login", h.LoginPageHandler)
+	mux.HandleFunc("POST /login", h.LoginHandler)
+	mux.HandleFunc("/logout", authn.LogoutHandler)
+	if oidc := authn.OIDC(); oidc != nil {
+		mux.HandleFunc("GET /auth/login", oidc.LoginHandler)
+		mux.HandleFunc("GET /auth/callback", oidc.CallbackHandler)
+	}
+
+	// JSON API
+	mux.HandleFunc("GET /api/v1/videos", read(h.APIListVideosHandler))
+	mux.HandleFunc("GET /api/v1/videos/events", read(h.VideoEventsHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}", read(h.APIVideoHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}", admin(h.APIDeleteVideoHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/status", read(h.APIVideoStatusHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/progress", read(h.APIProgressHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/variants", read(h.APIVariantsHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/artwork", read(h.ArtworkHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/next", read(h.APINextVideoHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/thumbnails.vtt", read(h.ThumbnailsHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/thumbnails.jpg", read(h.ThumbnailsHandler))
+	mux.HandleFunc("POST /api/v1/videos/{id}/reprocess", admin(h.APIReprocessHandler))
+	mux.HandleFunc("POST /api/v1/videos/{id}/restore", admin(h.RestoreVideoHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}/processing", admin(h.CancelJobHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}/cache", admin(h.PurgeVideoCacheHandler))
+	mux.HandleFunc("PUT /api/v1/videos/{id}/pin", admin(h.PinHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}/pin", admin(h.PinHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/rating", read(h.GetRatingHandler))
+	mux.HandleFunc("PUT /api/v1/videos/{id}/rating", write(h.SetRatingHandler))
+	mux.HandleFunc("PUT /api/v1/videos/{id}/favorite", write(h.FavoriteHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}/favorite", write(h.FavoriteHandler))
+	mux.HandleFunc("PUT /api/v1/videos/{id}/tags", admin(h.SetTagsHandler))
+	mux.HandleFunc("GET /api/v1/trash", admin(h.TrashHandler))
+	mux.HandleFunc("GET /api/v1/tags", read(h.ListTagsHandler))
+	mux.HandleFunc("GET /api/v1/libraries", read(h.APILibrariesHandler))
+	mux.HandleFunc("GET /api/v1/devices", read(h.APIDevicesHandler))
+	mux.HandleFunc("GET /api/v1/live", read(h.APILiveHandler))
+	mux.HandleFunc("GET /api/v1/live/{channel}/schedule", read(h.APILiveScheduleHandler))
+	mux.HandleFunc("GET /api/v1/version", read(h.APIVersionHandler))
+	mux.HandleFunc("GET /api/v1/videos/{id}/position", read(h.GetPositionHandler))
+	mux.HandleFunc("PUT /api/v1/videos/{id}/position", write(h.SetPositionHandler))
+	mux.HandleFunc("DELETE /api/v1/videos/{id}/position", write(h.ClearPositionHandler))
+	mux.HandleFunc("GET /api/v1/history", read(h.HistoryHandler))
+	mux.HandleFunc("GET /api/v1/sessions", admin(h.ListSessionsHandler))
+	mux.HandleFunc("DELETE /api/v1/sessions/{id}", admin(h.TerminateSessionHandler))
+	mux.HandleFunc("GET /api/v1/admin/events", admin(h.EventsHandler))
+	mux.HandleFunc("GET /api/v1/admin/ws", admin(h.ControlHandler))
+	mux.HandleFunc("GET /api/v1/admin/cache", admin(h.CacheStatsHandler))
+	mux.HandleFunc("DELETE /api/v1/admin/cache", admin(h.PurgeCacheHandler))
+	mux.HandleFunc("GET /api/v1/admin/jobs", admin(h.JobsHandler))
+	mux.HandleFunc("POST /api/v1/admin/jobs/{action}", admin(h.JobsActionHandler))
+	mux.HandleFunc("GET /api/v1/admin/keys", admin(h.ListAPIKeysHandler))
+	mux.HandleFunc("POST /api/v1/admin/keys", admin(h.CreateAPIKeyHandler))
+	mux.HandleFunc("DELETE /api/v1/admin/keys/{id}", admin(h.DeleteAPIKeyHandler))
+	mux.HandleFunc("GET /api/v1/admin/users", admin(h.ListUsersHandler))
+	mux.HandleFunc("POST /api/v1/admin/users", admin(h.CreateUserHandler))
+	mux.HandleFunc("PATCH /api/v1/admin/users/{id}", admin(h.UpdateUserHandler))
+	mux.HandleFunc("DELETE /api/v1/admin/users/{id}", admin(h.DeleteUserHandler))
+	if uploads != nil {
+		mux.HandleFunc("POST /api/v1/uploads", admin(h.CreateUploadHandler))
+		mux.HandleFunc("GET /api/v1/uploads/{id}", admin(h.GetUploadHandler))
+		mux.HandleFunc("PATCH /api/v1/uploads/{id}", admin(h.UploadChunkHandler))
+		mux.HandleFunc("DELETE /api/v1/uploads/{id}", admin(h.DeleteUploadHandler))
+	}
+
+	// DLNA media server for TVs on the local network, which can't log in
+	var dl *dlna.Server
+	if cfg.DLNA.Enabled {
+		dl = dlna.New(cfg, db, tm, logger)
+		dl.Register(mux)
+		mux.HandleFunc("GET /dlna/media/{id}/{name}", dlna.LocalOnly(h.DLNAMediaHandler))
+		mux.HandleFunc("GET /dlna/artwork/{id}", dlna.LocalOnly(h.ArtworkHandler))
+		mux.HandleFunc("/dlna/stream/", dlna.LocalOnly(h.DLNAStreamHandler))
+	}
+
+	// Jellyfin compatible API for Jellyfin and Emby apps, which sign in
+	// with their own tokens
+	if cfg.Jellyfin.Enabled {
+		jf := authn.Jellyfin
+		mux.HandleFunc("GET /System/Info/Public", h.JellyfinPublicInfoHandler)
+		mux.HandleFunc("GET /System/Ping", h.JellyfinPingHandler)
+		mux.HandleFunc("POST /System/Ping", h.JellyfinPingHandler)
+		mux.HandleFunc("GET /Branding/Configuration", h.JellyfinBrandingHandler)
+		mux.HandleFunc("GET /Users/Public", h.JellyfinPublicUsersHandler)
+		mux.HandleFunc("GET /QuickConnect/Enabled", h.JellyfinQuickConnectHandler)
+		mux.HandleFunc("POST /Users/AuthenticateByName", h.JellyfinLoginHandler)
+		mux.HandleFunc("GET /System/Info", jf(h.JellyfinSystemInfoHandler))
+		mux.HandleFunc("POST /Sessions/Capabilities", jf(h.JellyfinCapabilitiesHandler))
+		mux.HandleFunc("POST /Sessions/Capabilities/Full", jf(h.JellyfinCapabilitiesHandler))
+		mux.HandleFunc("GET /Users/Me", jf(h.JellyfinUserHandler))
+		mux.HandleFunc("GET /Users/{userId}", jf(h.JellyfinUserHandler))
+		mux.HandleFunc("GET /Users/{userId}/Views", jf(h.JellyfinViewsHandler))
+		mux.HandleFunc("GET /UserViews", jf(h.JellyfinViewsHandler))
+		mux.HandleFunc("GET /Users/{userId}/Items", jf(h.JellyfinItemsHandler))
+		mux.HandleFunc("GET /Items", jf(h.JellyfinItemsHandler))
+		mux.HandleFunc("GET /Users/{userId}/Items/Latest", jf(h.JellyfinLatestHandler))
+		mux.HandleFunc("GET /Items/Latest", jf(h.JellyfinLatestHandler))
+		mux.HandleFunc("GET /Users/{userId}/Items/Resume", jf(h.JellyfinResumeHandler))
+		mux.HandleFunc("GET /UserItems/Resume", jf(h.JellyfinResumeHandler))
+		mux.HandleFunc("GET /Users/{userId}/Items/{itemId}", jf(h.JellyfinItemHandler))
+		mux.HandleFunc("GET /Items/{itemId}", jf(h.JellyfinItemHandler))
+		mux.HandleFunc("GET /Items/{itemId}/Images/{type}", jf(h.JellyfinImageHandler))
+		mux.HandleFunc("GET /Items/{itemId}/Images/{type}/{index}", jf(h.JellyfinImageHandler))
+		mux.HandleFunc("GET /Items/{itemId}/PlaybackInfo", jf(h.JellyfinPlaybackInfoHandler))
+		mux.HandleFunc("POST /Items/{itemId}/PlaybackInfo", jf(h.JellyfinPlaybackInfoHandler))
+		mux.HandleFunc("GET /Videos/{itemId}/{file}", jf(h.JellyfinVideoHandler))
+		mux.HandleFunc("GET /videos/{itemId}/{file}", jf(h.JellyfinVideoHandler))
+		mux.HandleFunc("POST /Sessions/Playing", jf(h.JellyfinPlayingHandler))
+		mux.HandleFunc("POST /Sessions/Playing/Progress", jf(h.JellyfinPlayingHandler))
+		mux.HandleFunc("POST /Sessions/Playing/Stopped", jf(h.JellyfinPlayingHandler))
+		mux.HandleFunc("POST /Users/{userId}/FavoriteItems/{itemId}", jf(h.JellyfinFavoriteHandler))
+		mux.HandleFunc("DELETE /Users/{userId}/FavoriteItems/{itemId}", jf(h.JellyfinFavoriteHandler))
+		mux.HandleFunc("POST /UserFavoriteItems/{itemId}", jf(h.JellyfinFavoriteHandler))
+		mux.HandleFunc("DELETE /UserFavoriteItems/{itemId}", jf(h.JellyfinFavoriteHandler))
+		// Emby apps put /emby in front of every path
+		mux.Handle("/emby/", http.StripPrefix("/emby", mux))
+	}
+
+	// WebDAV share of the media directories for file managers
+	if cfg.WebDAV.Enabled {
+		share := authn.WebDAV(dav.New(cfg, db, lm, jobs, logger))
+		mux.HandleFunc(dav.Prefix, share)
+		mux.HandleFunc(dav.Prefix+"/", share)
+	}
+
+	// Debug endpoints
+	if cfg.Server.EnableDebug {
+		mux.HandleFunc("GET /api/v1/admin/runtime", admin(h.RuntimeHandler))
+		mux.HandleFunc("/debug/pprof/", admin(pprof.Index))
+		mux.HandleFunc("/debug/pprof/cmdline", admin(pprof.Cmdline))
+		mux.HandleFunc("/debug/pprof/profile", admin(pprof.Profile))
+		mux.HandleFunc("/debug/pprof/symbol", admin(pprof.Symbol))
+		mux.HandleFunc("/debug/pprof/trace", admin(pprof.Trace))
+		logger.Info("Debug endpoints enabled under /debug/pprof/ and /api/v1/admin/runtime")
+	}
+
+	// Log requests and protect the server from abusive clients
+	trusted, err := middleware.ParseNets(cfg.RateLimit.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate_limit.trusted_proxies: %w", err)
+	}
+	banned, err := middleware.ParseNets(cfg.RateLimit.BannedIPs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rate_limit.banned_ips: %w", err)
+	}
+	mws := []middleware.Middleware{middleware.RealIP(trusted), middleware.RequestID()}
+	if cfg.Telemetry.Enabled {
+		mws = append(mws, middleware.Tracing())
+	}
+	if cfg.Server.AccessLog {
+		mws = append(mws, middleware.AccessLog(logger))
+	}
+	mws = append(mws, middleware.BanList(banned), middleware.BasePath(cfg.Server.BasePath))
+	if cfg.RateLimit.RequestsPerSecond > 0 {
+		limiter := middleware.NewRateLimiter(cfg.RateLimit.RequestsPerSecond, cfg.RateLimit.Burst)
+		mws = append(mws, limiter.Middleware(rateLimited))
+		logger.Info("Rate limiting clients", "requests_per_second", cfg.RateLimit.RequestsPerSecond)
+	}
+	if cfg.Server.Compression {
+		mws = append(mws, middleware.Compress())
+	}
 
 	// Get server address
 	serverAddr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port)
 
 	// Setup HTTP server
 	server := &http.Server{
-		Addr:    serverAddr,
-		Handler: mux,
+		Addr:     serverAddr,
+		Handler:  middleware.Chain(middleware.TraceRoute(mux), mws...),
+		ErrorLog: slog.NewLogLogger(logger.Handler(), slog.LevelError),
 	}
 
-	// Setup signal handling for graceful shutdown
-	stop := make(chan os.Signal, 1)
-	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	// Listen before starting anything, so a taken port fails the start
+	if ln == nil {
+		if ln, err = net.Listen("tcp", serverAddr); err != nil {
+			return nil, fmt.Errorf("error starting server: %w", err)
+		}
+	} else {
+		serverAddr = ln.Addr().String()
+	}
+
+	scheme := "http"
+	if tlsEnabled(cfg) {
+		scheme = "https"
+	}
+
+	// Announce the DLNA server on the port actually listened on
+	if dl != nil {
+		port := cfg.Server.Port
+		if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+			port = addr.Port
+		}
+		if err := dl.Start(port, scheme); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("error starting DLNA server: %w", err)
+		}
+	}
+
+	// Start the background helpers only once nothing can fail anymore
+	if err := hub.Start(); err != nil {
+		dl.Stop()
+		ln.Close()
+		return nil, fmt.Errorf("error starting event hub: %w", err)
+	}
+	tracker.Start()
+	if lv != nil {
+		lv.Start()
+	}
 
 	// Start the server in a goroutine
 	go func() {
-		log.Printf("Starting server on http://%s", serverAddr)
-		log.Printf("Media directory: %s", cfg.Media.MediaDir)
-		log.Printf("Cache directory: %s", cfg.Media.CacheDir)
-		log.Printf("Database path: %s", cfg.Database.Path)
+		logger.Info("Starting server", "version", version.Get().String(),
+			"url", fmt.Sprintf("%s://%s%s/", scheme, serverAddr, cfg.Server.BasePath))
+		logLibraries()
 		
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("Error starting server: %v", err)
+		if err := serve(cfg, server, ln); err != nil && err != http.ErrServerClosed {
+			logger.Error("Error serving HTTP", "err", err)
+			os.Exit(1)
 		}
 	}()
 
-	// Handle refresh requests from the web UI
-	refreshCh := h.RefreshChannel()
-	
-	go func() {
-		for range refreshCh {
-			log.Println("Received library refresh request from web UI")
-			// In a real implementation, we would communicate to the librarian service
-			// For now, we'll just log the request
-		}
-	}()
+	// Keep the cache within its limits
+	lm.StartCacheCleanup()
 
-	// Start cache cleanup goroutine
-	go utils.CleanupCache(cfg)
+	// Start cleanup of abandoned uploads
+	if uploads != nil {
+		go uploads.StartCleanup(24 * time.Hour)
+	}
 
-	// Wait for interrupt signal
-	<-stop
-	log.Println("Shutting down server...")
+	return func() {
+		// Tell DLNA clients the server is going away
+		dl.Stop()
+		// Ending the event streams first lets their requests finish
+		hub.Stop()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			logger.Error("Error shutting down server", "err", err)
+		}
+		tracker.Stop()
+		lv.Stop()
+	}, nil
+}
 
-	return nil
-}
\ No newline at end of file
+// rateLimited reports whether a request counts against the per-IP rate
+// limit: API calls, logins, playlists and WebRTC offers. Segments are
+// exempt, as players fetch them in quick succession.
+func rateLimited(r *http.Request) bool {
+	p := r.URL.Path
+	return strings.HasPrefix(p, "/api/") || strings.HasPrefix(p, "/auth/") || p == "/login" ||
+		strings.HasSuffix(p, "/Users/AuthenticateByName") ||
+		strings.HasPrefix(p, "/video/") || strings.HasSuffix(p, ".m3u8") || strings.HasSuffix(p, "/whep")
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/device"
+	"github.com/kaero/streaming/internal/dvr"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/scrobble"
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/utils"
+	"github.com/kaero/streaming/internal/version"
+)
+
+// runStandalone runs the HTTP server and the librarian in one process,
+// sharing the database, library manager and processing queue
+func runStandalone() error {
+	if err := checkTranscodeFlags(); err != nil {
+		return err
+	}
+
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+
+	// Override with command-line flags if provided
+	applyStandaloneFlags(cfg)
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+	defer setupTelemetry(cfg)()
+
+	// Create required directories
+	if err := utils.CreateDirectories(cfg); err != nil {
+		return fmt.Errorf("error creating directories: %w", err)
+	}
+	if err := checkConfig(cfg); err != nil {
+		return err
+	}
+
+	// Initialize database
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		return fmt.Errorf("error initializing database: %w", err)
+	}
+	defer db.Close()
+
+	// Send the watch history to the scrobble targets
+	scrobbler, err := scrobble.New(cfg.Scrobble.Targets, db, logger)
+	if err != nil {
+		return fmt.Errorf("error creating scrobbler: %w", err)
+	}
+
+	// Create transcoding manager
+	tm := transcoder.NewManager(cfg, logger)
+
+	// Create the library manager both components use, so jobs started by
+	// the librarian can be cancelled through the API
+	lm, err := library.New(cfg, db, tm, logger)
+	if err != nil {
+		return fmt.Errorf("error creating library manager: %w", err)
+	}
+
+	// Load the device profiles limiting master playlists
+	devices, err := device.New(cfg.Devices)
+	if err != nil {
+		lm.Close()
+		return fmt.Errorf("error loading device profiles: %w", err)
+	}
+
+	// Setup signal handling for graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sockets, err := activatedSockets(httpSocket, controlSocket)
+	if err != nil {
+		lm.Close()
+		return err
+	}
+
+	logger.Info("Starting standalone mode", "version", version.Get().String())
+	reloader := newConfigReloader(database.ActorServer, applyStandaloneFlags, tm, lm, devices)
+	ctl, err := startLibrarian(lm, sockets[controlSocket], reloader)
+	if err != nil {
+		lm.Close()
+		return err
+	}
+
+	// Scans requested in the web UI run on the librarian's queue
+	stopServer, err := startServer(db, tm, lm, devices, sockets[httpSocket], ctl)
+	if err != nil {
+		ctl.Stop()
+		return err
+	}
+	// Record the scheduled network streams into the library
+	rec := dvr.New(cfg, db, tm, lm, ctl, logger)
+	rec.Start()
+	scrobbler.Start()
+	reloader.reloadOnSIGHUP(ctx)
+	if err := reloader.watchConfigFile(ctx); err != nil {
+		logger.Error("Error watching config file", "err", err)
+	}
+	notifyReady(ctx, db, "Serving HTTP and watching the library")
+
+	// Wait for interrupt signal
+	<-ctx.Done()
+	logger.Info("Shutting down")
+	notifyStopping()
+
+	// Stop taking requests and recording before cancelling the running
+	// jobs, which are requeued
+	stopServer()
+	rec.Stop()
+	scrobbler.Stop()
+	ctl.Stop()
+
+	return nil
+}
+
+// applyStandaloneFlags overrides the server and library settings given on
+// the command line
+func applyStandaloneFlags(c *config.Config) {
+	applyServerFlags(c)
+	applyLibrarianFlags(c)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/systemd"
+)
+
+// Names of the sockets systemd can pass, set as FileDescriptorName in the
+// socket units
+const (
+	httpSocket    = "http"
+	controlSocket = "control"
+)
+
+// activatedSockets returns the sockets systemd passed, warning about those
+// the command doesn't use
+func activatedSockets(used ...string) (map[string]net.Listener, error) {
+	sockets, err := systemd.Listeners()
+	if err != nil {
+		return nil, fmt.Errorf("error taking sockets from systemd: %w", err)
+	}
+	for name, ln := range sockets {
+		if !slices.Contains(used, name) {
+			logger.Warn("Ignoring socket passed by systemd", "socket", name, "expected", strings.Join(used, " or "))
+			ln.Close()
+			delete(sockets, name)
+		}
+	}
+	return sockets, nil
+}
+
+// notifyReady tells systemd that the service started and keeps its
+// watchdog fed while the database answers, until ctx is done
+func notifyReady(ctx context.Context, db *database.DB, status string) {
+	if _, err := systemd.Notify(systemd.Ready, systemd.Status("%s", status)); err != nil {
+		logger.Error("Error notifying systemd", "err", err)
+	}
+	systemd.StartWatchdog(ctx, db.Ping, logger)
+}
+
+// notifyStopping tells systemd that the service is shutting down
+func notifyStopping() {
+	if _, err := systemd.Notify(systemd.Stopping); err != nil {
+		logger.Error("Error notifying systemd", "err", err)
+	}
+}
+
+// notifyReloading tells systemd that the configuration is being reloaded,
+// until notifyReloaded is called
+func notifyReloading() {
+	if _, err := systemd.Notify(systemd.Reloading); err != nil {
+		logger.Error("Error notifying systemd", "err", err)
+	}
+}
+
+// notifyReloaded tells systemd that a reload finished
+func notifyReloaded() {
+	if _, err := systemd.Notify(systemd.Ready); err != nil {
+		logger.Error("Error notifying systemd", "err", err)
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/kaero/streaming/config"
+)
+
+// tlsEnabled reports whether the server is configured for HTTPS
+func tlsEnabled(cfg *config.Config) bool {
+	t := cfg.Server.TLS
+	return t.ACME || t.CertFile != "" || t.KeyFile != ""
+}
+
+// serve runs server on ln over HTTPS when server.tls is configured and
+// over plain HTTP otherwise
+func serve(cfg *config.Config, server *http.Server, ln net.Listener) error {
+	t := cfg.Server.TLS
+	redirect := redirectToHTTPS(cfg.Server.Port)
+
+	switch {
+	case t.ACME:
+		cacheDir := t.ACMECacheDir
+		if cacheDir == "" {
+			cacheDir = filepath.Join(filepath.Dir(cfg.Database.Path), "acme")
+		}
+		m := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(t.Domains...),
+			Cache:      autocert.DirCache(cacheDir),
+			Email:      t.Email,
+		}
+		server.TLSConfig = m.TLSConfig()
+		logger.Info("Obtaining certificates via ACME", "domains", t.Domains, "cache_dir", cacheDir)
+
+		// Without the HTTP listener, challenges are answered over TLS, which
+		// only works when the server listens on port 443
+		if t.HTTPAddr != "" {
+			go serveHTTP(t.HTTPAddr, m.HTTPHandler(redirect))
+		}
+		return server.ServeTLS(ln, "", "")
+
+	case t.CertFile != "":
+		server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if t.HTTPAddr != "" {
+			go serveHTTP(t.HTTPAddr, redirect)
+		}
+		return server.ServeTLS(ln, t.CertFile, t.KeyFile)
+
+	default:
+		return server.Serve(ln)
+	}
+}
+
+// serveHTTP runs the plain HTTP listener next to the HTTPS server
+func serveHTTP(addr string, h http.Handler) {
+	logger.Info("Redirecting HTTP requests to HTTPS", "addr", addr)
+	if err := http.ListenAndServe(addr, h); err != nil {
+		logger.Error("Error serving HTTP", "addr", addr, "err", err)
+	}
+}
+
+// redirectToHTTPS sends requests to the same URL on the HTTPS port
+func redirectToHTTPS(port int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != 443 {
+			host = net.JoinHostPort(host, strconv.Itoa(port))
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
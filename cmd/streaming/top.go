@@ -0,0 +1,391 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+	"golang.org/x/term"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/handlers"
+	"github.com/kaero/streaming/internal/version"
+)
+
+// topEvents is the number of recent events the dashboard shows
+const topEvents = 10
+
+// topClient reads the state shown by the dashboard from the JSON API and
+// the control channel of a running server
+type topClient struct {
+	base   *url.URL
+	apiKey string
+	http   *http.Client
+
+	mu     sync.Mutex
+	events []handlers.EventResponse
+	// live is set while watching the control channel, connected while it
+	// is connected
+	live      bool
+	connected bool
+}
+
+// topSnapshot is the state shown on one refresh of the dashboard
+type topSnapshot struct {
+	pending    int
+	failed     int
+	processing []handlers.VideoJSON
+	progress   map[int64]*handlers.ProgressJSON
+	sessions   []handlers.SessionJSON
+	sessionErr error
+}
+
+// topStatus is the state of the server as printed by top --json. Counts
+// that couldn't be read are -1.
+type topStatus struct {
+	Server     string                 `json:"server"`
+	Version    version.Info           `json:"version"`
+	Pending    int                    `json:"pending"`
+	Failed     int                    `json:"failed"`
+	Processing []topJob               `json:"processing"`
+	Sessions   []handlers.SessionJSON `json:"sessions"`
+	// SessionsError is set when the sessions couldn't be read
+	SessionsError string                   `json:"sessions_error,omitempty"`
+	Events        []handlers.EventResponse `json:"events"`
+}
+
+// topJob is a video being processed with its progress, if known
+type topJob struct {
+	handlers.VideoJSON
+	Progress *handlers.ProgressJSON `json:"progress,omitempty"`
+}
+
+// runTop shows a dashboard of the server's transcode jobs, queue, playback
+// sessions and events, refreshed until interrupted
+func runTop() error {
+	if topInterval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	// Load configuration
+	var err error
+	cfg, err = config.Load(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+
+	base := topURL
+	if base == "" {
+		base = serverURL(cfg)
+	}
+	u, err := url.Parse(strings.TrimRight(base, "/"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return fmt.Errorf("invalid --url %q", base)
+	}
+	key := topAPIKey
+	if key == "" {
+		key = cfg.Auth.AdminToken
+	}
+	c := &topClient{base: u, apiKey: key, http: &http.Client{Timeout: 5 * time.Second}}
+
+	var info version.Info
+	if err := c.get("/api/v1/version", &info); err != nil {
+		return fmt.Errorf("can't reach the server at %s: %w", u, err)
+	}
+	if err := c.get(fmt.Sprintf("/api/v1/admin/events?limit=%d", topEvents), &c.events); err != nil {
+		return fmt.Errorf("can't read events, is the API key an admin key? %w", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if jsonOutput {
+		return printJSON(os.Stdout, c.status(info, c.snapshot()))
+	}
+	// Without a terminal, print the state once for logs and scripts
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		fmt.Print(c.render(info, c.snapshot(), 0))
+		return nil
+	}
+
+	c.live = true
+	go c.watchEvents(ctx)
+
+	// Use the alternate screen, restoring the terminal on exit
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	ticker := time.NewTicker(topInterval)
+	defer ticker.Stop()
+	for {
+		width, _, err := term.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			width = 80
+		}
+		fmt.Print("\x1b[H" + strings.ReplaceAll(c.render(info, c.snapshot(), width), "\n", "\x1b[K\n") + "\x1b[J")
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// serverURL returns the URL the configured server is reachable at locally
+func serverURL(cfg *config.Config) string {
+	scheme := "http"
+	if tlsEnabled(cfg) {
+		scheme = "https"
+	}
+	host := cfg.Server.Host
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(host, strconv.Itoa(cfg.Server.Port)), cfg.Server.BasePath)
+}
+
+// get fetches an API path and decodes the JSON answer into v
+func (c *topClient) get(path string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, c.base.String()+path, nil)
+	if err != nil {
+		return err
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		if apiErr.Error == "" {
+			apiErr.Error = http.StatusText(resp.StatusCode)
+		}
+		return fmt.Errorf("%s: %s", path, apiErr.Error)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// total returns the number of videos with a status
+func (c *topClient) total(status string) int {
+	var list handlers.VideoListJSON
+	if err := c.get("/api/v1/videos?per_page=1&status="+status, &list); err != nil {
+		return -1
+	}
+	return list.Total
+}
+
+// snapshot reads the current state from the server. Failed requests leave
+// their part of the dashboard empty.
+func (c *topClient) snapshot() *topSnapshot {
+	s := &topSnapshot{
+		pending:  c.total("pending"),
+		failed:   c.total("error"),
+		progress: make(map[int64]*handlers.ProgressJSON),
+	}
+
+	var list handlers.VideoListJSON
+	if err := c.get("/api/v1/videos?per_page=100&sort=updated&status=processing", &list); err == nil {
+		s.processing = list.Videos
+	}
+	for _, v := range s.processing {
+		p := &handlers.ProgressJSON{}
+		if err := c.get(fmt.Sprintf("/api/v1/videos/%d/progress", v.ID), p); err == nil {
+			s.progress[v.ID] = p
+		}
+	}
+
+	s.sessionErr = c.get("/api/v1/sessions", &s.sessions)
+	return s
+}
+
+// watchEvents receives new events over the control channel, reconnecting
+// when the connection drops, until ctx is done
+func (c *topClient) watchEvents(ctx context.Context) {
+	wsURL := *c.base
+	wsURL.Scheme = strings.Replace(wsURL.Scheme, "http", "ws", 1)
+	wsURL.Path += "/api/v1/admin/ws"
+	header := http.Header{}
+	if c.apiKey != "" {
+		header.Set("X-API-Key", c.apiKey)
+	}
+
+	for ctx.Err() == nil {
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, wsURL.String(), header)
+		if err == nil {
+			c.setConnected(true)
+			go func() {
+				<-ctx.Done()
+				conn.Close()
+			}()
+			for {
+				var msg handlers.ControlMessage
+				if err := conn.ReadJSON(&msg); err != nil {
+					break
+				}
+				if msg.Type == "event" && msg.Event != nil {
+					c.addEvent(*msg.Event)
+				}
+			}
+			conn.Close()
+			c.setConnected(false)
+		}
+
+		select {
+		case <-time.After(2 * time.Second):
+		case <-ctx.Done():
+		}
+	}
+}
+
+// setConnected records whether the control channel is connected
+func (c *topClient) setConnected(connected bool) {
+	c.mu.Lock()
+	c.connected = connected
+	c.mu.Unlock()
+}
+
+// addEvent adds a new event in front of the recent events
+func (c *topClient) addEvent(e handlers.EventResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.events = append([]handlers.EventResponse{e}, c.events...)
+	if len(c.events) > topEvents {
+		c.events = c.events[:topEvents]
+	}
+}
+
+// status converts a snapshot for top --json
+func (c *topClient) status(info version.Info, s *topSnapshot) topStatus {
+	c.mu.Lock()
+	events := c.events
+	c.mu.Unlock()
+
+	st := topStatus{
+		Server:     c.base.String(),
+		Version:    info,
+		Pending:    s.pending,
+		Failed:     s.failed,
+		Processing: make([]topJob, 0, len(s.processing)),
+		Sessions:   s.sessions,
+		Events:     events,
+	}
+	for _, v := range s.processing {
+		st.Processing = append(st.Processing, topJob{VideoJSON: v, Progress: s.progress[v.ID]})
+	}
+	if s.sessionErr != nil {
+		st.SessionsError = s.sessionErr.Error()
+	}
+	if st.Sessions == nil {
+		st.Sessions = []handlers.SessionJSON{}
+	}
+	if st.Events == nil {
+		st.Events = []handlers.EventResponse{}
+	}
+	return st
+}
+
+// render draws the dashboard, cutting lines to width unless it is 0
+func (c *topClient) render(info version.Info, s *topSnapshot, width int) string {
+	var b strings.Builder
+	line := func(format string, args ...any) {
+		text := fmt.Sprintf(format, args...)
+		if width > 0 && utf8.RuneCountInString(text) > width {
+			text = string([]rune(text)[:width])
+		}
+		b.WriteString(text + "\n")
+	}
+
+	c.mu.Lock()
+	events := c.events
+	disconnected := c.live && !c.connected
+	c.mu.Unlock()
+
+	line("streaming top - %s, server %s, %s", c.base, info, time.Now().Format("15:04:05"))
+	line("Queue: %s pending, %d processing, %s failed", count(s.pending), len(s.processing), count(s.failed))
+	line("")
+
+	line("JOBS")
+	if len(s.processing) == 0 {
+		line("  none")
+	}
+	for _, v := range s.processing {
+		p := s.progress[v.ID]
+		if p == nil {
+			line("  %-6d %s", v.ID, v.Filename)
+			continue
+		}
+		detail := p.ActiveVariant
+		for _, variant := range p.Variants {
+			if variant.Name == p.ActiveVariant && variant.Speed > 0 {
+				detail += fmt.Sprintf(" %.1fx", variant.Speed)
+			}
+		}
+		if p.ETASeconds != nil {
+			detail += " ETA " + (time.Duration(*p.ETASeconds) * time.Second).String()
+		}
+		line("  %-6d %s %5.1f%%  %s  %s", v.ID, progressBar(p.Percent, 20), p.Percent, detail, v.Filename)
+	}
+	line("")
+
+	switch {
+	case s.sessionErr != nil:
+		line("STREAMS: %v", s.sessionErr)
+	default:
+		line("STREAMS (%d)", len(s.sessions))
+		for _, session := range s.sessions {
+			line("  %-12s %-15s %8s  %-6s %s", session.User, session.ClientIP,
+				formatDuration(session.PositionSeconds), session.Variant, session.Video)
+		}
+	}
+	line("")
+
+	if disconnected {
+		line("EVENTS (control channel disconnected, reconnecting)")
+	} else {
+		line("EVENTS")
+	}
+	for _, e := range events {
+		video := ""
+		if e.VideoID != nil {
+			video = fmt.Sprintf("#%d ", *e.VideoID)
+		}
+		line("  %s %-13s %s%s", e.CreatedAt.Local().Format("15:04:05"), e.Type, video, e.Message)
+	}
+	return b.String()
+}
+
+// progressBar draws a bar of width characters filled to percent
+func progressBar(percent float64, width int) string {
+	filled := int(percent / 100 * float64(width))
+	filled = max(0, min(filled, width))
+	return "[" + strings.Repeat("#", filled) + strings.Repeat("-", width-filled) + "]"
+}
+
+// count formats a video count, "?" when it couldn't be read
+func count(n int) string {
+	if n < 0 {
+		return "?"
+	}
+	return strconv.Itoa(n)
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/utils"
+)
+
+// runTranscode transcodes the video files named by paths, or found in
+// them, into the cache without running the librarian
+func runTranscode(paths []string) error {
+	if err := checkTranscodeFlags(); err != nil {
+		return err
+	}
+
+	// Load configuration
+	var err error
+	cfg, err = config.InitConfig(cfgFile)
+	if err != nil {
+		return fmt.Errorf("error initializing config: %w", err)
+	}
+	if err := setupLogging(cfg); err != nil {
+		return err
+	}
+
+	// Override with command-line flags if provided
+	if mediaDir != "" {
+		cfg.Media.MediaDir = mediaDir
+	}
+	if cacheDir != "" {
+		cfg.Media.CacheDir = cacheDir
+	}
+	if dbPath != "" {
+		cfg.Database.Path = dbPath
+	}
+	applyTranscodeFlags(cfg)
+
+	if err := utils.CreateDirectories(cfg); err != nil {
+		return fmt.Errorf("error creating directories: %w", err)
+	}
+
+	files, err := collectVideoFiles(paths)
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no video files found")
+	}
+
+	tm := transcoder.NewManager(cfg, logger)
+
+	// Only open the library when results are recorded, so trying out
+	// transcode settings never changes it
+	var lm *library.Manager
+	if recordTranscode {
+		db, err := database.New(cfg.Database.Path)
+		if err != nil {
+			return fmt.Errorf("error initializing database: %w", err)
+		}
+		defer db.Close()
+
+		if lm, err = library.New(cfg, db, tm, logger); err != nil {
+			return fmt.Errorf("error creating library manager: %w", err)
+		}
+		defer lm.Close()
+	}
+
+	// Ctrl-C stops FFmpeg and skips the remaining files
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Progress is printed as it happens, with --json the results follow
+	// at the end
+	out := make([]transcodedFile, 0, len(files))
+	failed := 0
+	for i, file := range files {
+		if ctx.Err() != nil {
+			break
+		}
+		label := fmt.Sprintf("[%d/%d] %s", i+1, len(files), filepath.Base(file))
+		result := transcodedFile{Path: file}
+		if err := transcodeFile(ctx, tm, lm, file, label, &result); err != nil {
+			fmt.Fprintf(messages(), "%s: %v\n", label, err)
+			result.Error = err.Error()
+			failed++
+		}
+		out = append(out, result)
+	}
+	if jsonOutput {
+		if err := printJSON(os.Stdout, out); err != nil {
+			return err
+		}
+	}
+
+	if ctx.Err() != nil {
+		return fmt.Errorf("transcoding interrupted")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed", failed, len(files))
+	}
+	return nil
+}
+
+// checkTranscodeFlags rejects transcode settings given on the command line
+// that FFmpeg would fail on
+func checkTranscodeFlags() error {
+	if transcodePreset != "" && !slices.Contains(config.X264Presets, transcodePreset) {
+		return fmt.Errorf("invalid --preset %q, use one of %s", transcodePreset, strings.Join(config.X264Presets, ", "))
+	}
+	if segmentDuration < 0 {
+		return fmt.Errorf("--segment-duration must be positive")
+	}
+	if hwAccel != "" && !slices.Contains(config.HWAccels, hwAccel) {
+		return fmt.Errorf("invalid --hwaccel %q, use one of %s", hwAccel, strings.Join(config.HWAccels, ", "))
+	}
+	return nil
+}
+
+// applyTranscodeFlags overrides the transcode settings given on the
+// command line. They apply to every transcode profile, and --preset to
+// every library, including those with settings of their own.
+func applyTranscodeFlags(c *config.Config) {
+	c.Transcode.Profiles = slices.Clone(c.Transcode.Profiles)
+	if transcodePreset != "" {
+		c.Server.TranscodePreset = transcodePreset
+		c.Libraries = slices.Clone(c.Libraries)
+		for i := range c.Libraries {
+			c.Libraries[i].TranscodePreset = ""
+		}
+		for i := range c.Transcode.Profiles {
+			c.Transcode.Profiles[i].Preset = ""
+		}
+	}
+	if segmentDuration > 0 {
+		c.Server.SegmentDuration = segmentDuration
+		for i := range c.Transcode.Profiles {
+			c.Transcode.Profiles[i].SegmentDuration = 0
+		}
+	}
+	if hwAccel != "" {
+		c.Server.HWAccel = hwAccel
+		for i := range c.Transcode.Profiles {
+			c.Transcode.Profiles[i].HWAccel = ""
+		}
+	}
+}
+
+// transcodedFile is the outcome of transcoding a file, as printed by
+// transcode --json
+type transcodedFile struct {
+	Path       string  `json:"path"`
+	MasterPath string  `json:"master_path,omitempty"`
+	Seconds    float64 `json:"seconds,omitempty"`
+	// VideoID is the library entry the result was recorded as with --record
+	VideoID int64  `json:"video_id,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// transcodeFile transcodes one file, printing its progress, and records
+// the result when lm is set. The outcome is filled into out.
+func transcodeFile(ctx context.Context, tm *transcoder.Manager, lm *library.Manager, file, label string, out *transcodedFile) error {
+	if _, ok := cfg.LibraryFor(file); lm != nil && !ok {
+		return fmt.Errorf("not inside a media directory, can't record it")
+	}
+
+	probe, err := transcoder.Probe(file)
+	if err != nil {
+		return fmt.Errorf("probing failed: %w", err)
+	}
+
+	start := time.Now()
+	progress := newTerminalProgress(label, probe.Duration)
+	result, err := tm.PrepareVideo(ctx, file, progress.report)
+	progress.done()
+	if err != nil {
+		if tail := result.StderrTail(); tail != "" {
+			fmt.Fprintln(messages(), tail)
+		}
+		return err
+	}
+
+	elapsed := time.Since(start)
+	out.MasterPath = result.MasterPath
+	out.Seconds = elapsed.Seconds()
+	fmt.Fprintf(messages(), "%s: done in %s, %s\n", label, elapsed.Round(time.Second), result.MasterPath)
+
+	if lm != nil {
+		id, err := lm.RecordTranscode(file, probe, result, database.ActorCLI)
+		if err != nil {
+			return fmt.Errorf("failed to record result: %w", err)
+		}
+		out.VideoID = id
+		fmt.Fprintf(messages(), "%s: recorded as video %d\n", label, id)
+	}
+	return nil
+}
+
+// collectVideoFiles returns the absolute paths of the video files named by
+// paths, walking directories
+func collectVideoFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+
+		if !info.IsDir() {
+			if !library.IsVideoFile(strings.ToLower(filepath.Ext(abs))) {
+				return nil, fmt.Errorf("%s is not a video file", p)
+			}
+			files = append(files, abs)
+			continue
+		}
+
+		err = filepath.Walk(abs, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && library.IsVideoFile(strings.ToLower(filepath.Ext(path))) {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to walk %s: %w", p, err)
+		}
+	}
+	return files, nil
+}
+
+// terminalProgress prints the progress of a transcode. On a terminal the
+// line is redrawn in place, otherwise a line is printed every few seconds.
+type terminalProgress struct {
+	label    string
+	duration float64
+	out      io.Writer
+	tty      bool
+
+	mu        sync.Mutex
+	variants  map[string]transcoder.Progress
+	lastPrint time.Time
+	printed   bool
+}
+
+// Progress print intervals on a terminal and in logs
+const (
+	ttyPrintInterval = 250 * time.Millisecond
+	logPrintInterval = 10 * time.Second
+)
+
+// newTerminalProgress creates a progress printer for a video of duration
+// seconds
+func newTerminalProgress(label string, duration float64) *terminalProgress {
+	out := messages()
+	info, err := out.Stat()
+	return &terminalProgress{
+		label:    label,
+		duration: duration,
+		out:      out,
+		tty:      err == nil && info.Mode()&os.ModeCharDevice != 0,
+		variants: make(map[string]transcoder.Progress),
+	}
+}
+
+// report records the progress of a variant and prints the overall
+// progress, determined by the slowest variant
+func (t *terminalProgress) report(p transcoder.Progress) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.variants[p.Variant] = p
+	interval := logPrintInterval
+	if t.tty {
+		interval = ttyPrintInterval
+	}
+	if time.Since(t.lastPrint) < interval {
+		return
+	}
+	t.lastPrint = time.Now()
+
+	var slowest *transcoder.Progress
+	for _, v := range t.variants {
+		if slowest == nil || v.Position < slowest.Position {
+			slowest = &v
+		}
+	}
+
+	line := fmt.Sprintf("%s: %s", t.label, slowest.Variant)
+	if t.duration > 0 {
+		line += fmt.Sprintf(" %5.1f%%", min(slowest.Position/t.duration*100, 100))
+	}
+	if slowest.Speed > 0 {
+		line += fmt.Sprintf(" at %.1fx", slowest.Speed)
+		if t.duration > 0 {
+			eta := time.Duration(max(t.duration-slowest.Position, 0) / slowest.Speed * float64(time.Second))
+			line += fmt.Sprintf(", %s left", eta.Round(time.Second))
+		}
+	}
+
+	if t.tty {
+		fmt.Fprintf(t.out, "\r\033[K%s", line)
+		t.printed = true
+	} else {
+		fmt.Fprintln(t.out, line)
+	}
+}
+
+// done ends the progress line
+func (t *terminalProgress) done() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.printed {
+		fmt.Fprint(t.out, "\r\033[K")
+	}
+}
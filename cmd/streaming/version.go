@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/version"
+)
+
+// runVersion prints the build information and the FFmpeg version
+func runVersion() error {
+	info := version.Get()
+	if v, err := transcoder.FFmpegVersion(); err == nil {
+		info.FFmpeg = v
+	} else {
+		info.FFmpeg = "not found"
+	}
+
+	return printResult(info, func() {
+		fmt.Printf("Version:    %s\n", info.Version)
+		if info.Commit != "" {
+			fmt.Printf("Commit:     %s\n", info.Commit)
+		}
+		if info.BuildDate != "" {
+			fmt.Printf("Build date: %s\n", info.BuildDate)
+		}
+		fmt.Printf("Go:         %s\n", info.GoVersion)
+		fmt.Printf("FFmpeg:     %s\n", info.FFmpeg)
+	})
+}
@@ -2,19 +2,52 @@ package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
+
+	"github.com/kaero/streaming/internal/safepath"
 )
 
 // Config holds all configuration for the application
 type Config struct {
-	Server   ServerConfig   `mapstructure:"server"`
-	Media    MediaConfig    `mapstructure:"media"`
-	Database DatabaseConfig `mapstructure:"database"`
-	Library  LibraryConfig  `mapstructure:"library"`
+	Server    ServerConfig    `mapstructure:"server"`
+	Media     MediaConfig     `mapstructure:"media"`
+	Cache     CacheConfig     `mapstructure:"cache"`
+	Database  DatabaseConfig  `mapstructure:"database"`
+	Library   LibraryConfig   `mapstructure:"library"`
+	Queue     QueueConfig     `mapstructure:"queue"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Notify    NotifyConfig    `mapstructure:"notify"`
+	Scrobble  ScrobbleConfig  `mapstructure:"scrobble"`
+	DLNA      DLNAConfig      `mapstructure:"dlna"`
+	Jellyfin  JellyfinConfig  `mapstructure:"jellyfin"`
+	WebDAV    WebDAVConfig    `mapstructure:"webdav"`
+	Live      LiveConfig      `mapstructure:"live"`
+	DVR       DVRConfig       `mapstructure:"dvr"`
+	Telemetry TelemetryConfig `mapstructure:"telemetry"`
+	Log       LogConfig       `mapstructure:"logging"`
+	Transcode TranscodeConfig `mapstructure:"transcode"`
+	UI        UIConfig        `mapstructure:"ui"`
+	// Libraries are named media directories, each with its own access
+	// rules. When none are configured media.media_dir is the only library.
+	Libraries []MediaLibrary `mapstructure:"libraries"`
+	// Devices are client profiles limiting the variants in master
+	// playlists, in addition to the built-in ones
+	Devices []DeviceProfile `mapstructure:"devices"`
+
+	// file is the config file read, if any, and unknown the settings in it
+	// that don't exist
+	file    string
+	unknown []string
 }
 
 // ServerConfig holds server-specific configuration
@@ -25,12 +58,161 @@ type ServerConfig struct {
 	SegmentFormat   string `mapstructure:"segment_format"`
 	SegmentDuration int    `mapstructure:"segment_duration"`
 	PlaylistEntries int    `mapstructure:"playlist_entries"`
+	// HWAccel selects the hardware encoder transcodes use, "nvenc",
+	// "qsv", "vaapi" or "videotoolbox", or "none" for libx264 with
+	// TranscodePreset
+	HWAccel string `mapstructure:"hwaccel"`
+	// BasePath is the path prefix the server is reachable under behind a
+	// reverse proxy, such as "/media"
+	BasePath string `mapstructure:"base_path"`
+	// PublicURL is the scheme and host clients reach the server at, such
+	// as "https://media.example.com", for absolute links handed to other
+	// applications like feed readers. Links use the host of the request
+	// when empty.
+	PublicURL string `mapstructure:"public_url"`
+	// EnableDebug exposes pprof and runtime statistics to admins
+	EnableDebug bool `mapstructure:"enable_debug"`
+	// AccessLog logs every request with the other logs
+	AccessLog bool `mapstructure:"access_log"`
+	// Compression compresses playlists, JSON and HTML responses
+	Compression bool `mapstructure:"compression"`
+	// WatchConfig reloads the configuration when the config file changes
+	WatchConfig bool `mapstructure:"watch_config"`
+	// MaxStreamsPerUser limits how many videos a user can watch at the
+	// same time, 0 for no limit
+	MaxStreamsPerUser int `mapstructure:"max_streams_per_user"`
+	// ResumeSaveInterval is how often the player saves the playback
+	// position, in seconds, 0 to disable resuming
+	ResumeSaveInterval int       `mapstructure:"resume_save_interval"`
+	TLS                TLSConfig `mapstructure:"tls"`
+}
+
+// Path returns the URL path of a route, including the base path
+func (s ServerConfig) Path(p string) string {
+	return s.BasePath + p
+}
+
+// TLSConfig holds HTTPS settings. HTTPS is enabled with a certificate and
+// key, or with ACME to obtain certificates from Let's Encrypt.
+type TLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	// ACME obtains and renews certificates for Domains automatically
+	ACME    bool     `mapstructure:"acme"`
+	Domains []string `mapstructure:"domains"`
+	Email   string   `mapstructure:"email"`
+	// ACMECacheDir stores the ACME account and certificates, next to the
+	// database by default
+	ACMECacheDir string `mapstructure:"acme_cache_dir"`
+	// HTTPAddr is an extra plain HTTP listener, such as ":80", answering
+	// ACME challenges and redirecting everything else to HTTPS
+	HTTPAddr string `mapstructure:"http_addr"`
 }
 
 // MediaConfig holds media-specific configuration
 type MediaConfig struct {
 	MediaDir string `mapstructure:"media_dir"`
-	CacheDir string `mapstructure:"cache_dir"`
+	CacheDir        string `mapstructure:"cache_dir"`
+	// WorkDir is where transcodes are written before they are moved into
+	// the cache, a hidden directory in the cache by default
+	WorkDir         string `mapstructure:"work_dir"`
+	MaxUploadSizeMB int64  `mapstructure:"max_upload_size_mb"`
+}
+
+// WorkDirPath returns the directory transcodes are written to until they
+// are complete
+func (m MediaConfig) WorkDirPath() string {
+	if m.WorkDir != "" {
+		return m.WorkDir
+	}
+	return filepath.Join(m.CacheDir, DefaultWorkDirName)
+}
+
+// CacheConfig holds the limits of the transcoded output kept in the cache
+type CacheConfig struct {
+	// Policy selects the limits output is evicted by, one of
+	// CachePolicies. min_free_disk_gb is kept with every policy.
+	Policy string `mapstructure:"policy"`
+	// CleanupIntervalMinutes is how often the server evicts output
+	CleanupIntervalMinutes int `mapstructure:"cleanup_interval_minutes"`
+	// MaxAgeHours removes output that hasn't been streamed for as long, 0
+	// to keep it regardless of age
+	MaxAgeHours int `mapstructure:"max_age_hours"`
+	// MaxSizeGB is the size the cache is kept under by removing the least
+	// recently streamed output, 0 for no limit
+	MaxSizeGB float64 `mapstructure:"max_size_gb"`
+	// MinFreeDiskGB is the free space kept on the cache disk. Videos
+	// aren't transcoded while less is free and nothing can be evicted.
+	MinFreeDiskGB float64 `mapstructure:"min_free_disk_gb"`
+	// WarmNewest and WarmTags keep the newest videos of media.media_dir
+	// transcoded when no libraries are configured, see MediaLibrary
+	WarmNewest int      `mapstructure:"warm_newest"`
+	WarmTags   []string `mapstructure:"warm_tags"`
+	// FsckIntervalHours is how often the server reconciles the cache with
+	// the database, 0 to only do it with cache fsck
+	FsckIntervalHours int `mapstructure:"fsck_interval_hours"`
+	// Backend is where segments are kept, one of CacheBackends: "local"
+	// in the cache directory or "s3" in object storage
+	Backend string   `mapstructure:"backend"`
+	S3      S3Config `mapstructure:"s3"`
+	// MaxVideoSizeGB limits the output of a single video by dropping its
+	// variants with the highest bitrate, 0 for no limit
+	MaxVideoSizeGB float64 `mapstructure:"max_video_size_gb"`
+	// HotCacheMB is the memory the server keeps playlists and the first
+	// HotSegments segments of each variant in, 0 to read them from disk
+	HotCacheMB  int `mapstructure:"hot_cache_mb"`
+	HotSegments int `mapstructure:"hot_segments"`
+}
+
+// S3Config locates the S3 compatible bucket segments are kept in with the
+// "s3" cache backend
+type S3Config struct {
+	// Endpoint is the URL of the service, e.g. http://minio:9000, and
+	// defaults to AWS in Region
+	Endpoint  string `mapstructure:"endpoint"`
+	Region    string `mapstructure:"region"`
+	Bucket    string `mapstructure:"bucket"`
+	// Prefix is prepended to the object keys, e.g. "cache/"
+	Prefix    string `mapstructure:"prefix"`
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key" secret:"true"`
+	// PathStyle puts the bucket into the path rather than the host name,
+	// as MinIO and most other S3 compatible services need
+	PathStyle bool `mapstructure:"path_style"`
+	// Serve is how segments reach players, one of S3ServeModes:
+	// "redirect" to presigned URLs or "proxy" through the server
+	Serve string `mapstructure:"serve"`
+	// URLExpiryMinutes is how long presigned URLs are valid
+	URLExpiryMinutes int `mapstructure:"url_expiry_minutes"`
+}
+
+// EndpointURL returns the URL of the S3 service, AWS in the region unless
+// an endpoint is configured
+func (s S3Config) EndpointURL() string {
+	if s.Endpoint != "" {
+		return s.Endpoint
+	}
+	return "https://s3." + s.Region + ".amazonaws.com"
+}
+
+// MaxSizeBytes returns max_size_gb in bytes
+func (c CacheConfig) MaxSizeBytes() int64 {
+	return int64(c.MaxSizeGB * (1 << 30))
+}
+
+// MinFreeDiskBytes returns min_free_disk_gb in bytes
+func (c CacheConfig) MinFreeDiskBytes() int64 {
+	return int64(c.MinFreeDiskGB * (1 << 30))
+}
+
+// MaxVideoSizeBytes returns max_video_size_gb in bytes
+func (c CacheConfig) MaxVideoSizeBytes() int64 {
+	return int64(c.MaxVideoSizeGB * (1 << 30))
+}
+
+// HotCacheBytes returns hot_cache_mb in bytes
+func (c CacheConfig) HotCacheBytes() int64 {
+	return int64(c.HotCacheMB) << 20
 }
 
 // DatabaseConfig holds database-specific configuration
@@ -44,6 +226,741 @@ type LibraryConfig struct {
 	WatchForChanges      bool  `mapstructure:"watch_for_changes"`
 	ScanIntervalMinutes  int   `mapstructure:"scan_interval_minutes"`
 	ProcessingThreads    int   `mapstructure:"processing_threads"`
+	TrashRetentionDays   int   `mapstructure:"trash_retention_days"`
+	MaxRetries           int   `mapstructure:"max_retries"`
+	RetryBackoffMinutes  int   `mapstructure:"retry_backoff_minutes"`
+	// ControlAddr is where the librarian control API listens, a host:port
+	// or "unix:" followed by a socket path, empty to disable it
+	ControlAddr string `mapstructure:"control_addr"`
+}
+
+// QueueConfig selects how librarians share the processing queue
+type QueueConfig struct {
+	// Backend is one of QueueBackends: "database" picks pending videos
+	// from the database for a single librarian, "redis" shares them
+	// through Redis between librarians on several machines
+	Backend string      `mapstructure:"backend"`
+	Redis   RedisConfig `mapstructure:"redis"`
+	// VisibilityTimeoutSeconds is how long a job claimed by a librarian
+	// that stopped sending heartbeats is kept from the others
+	VisibilityTimeoutSeconds int `mapstructure:"visibility_timeout_seconds"`
+	// HeartbeatSeconds is how often a librarian extends the claim of its
+	// running jobs
+	HeartbeatSeconds int `mapstructure:"heartbeat_seconds"`
+}
+
+// RedisConfig locates the Redis server of the "redis" queue backend
+type RedisConfig struct {
+	// Addr is the host:port of the server
+	Addr     string `mapstructure:"addr"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+	DB       int    `mapstructure:"db"`
+	// TLS connects with TLS, as hosted Redis services require
+	TLS bool `mapstructure:"tls"`
+	// KeyPrefix is prepended to the keys of the queue, so installations
+	// can share a server
+	KeyPrefix string `mapstructure:"key_prefix"`
+}
+
+// AuthConfig holds authentication configuration
+type AuthConfig struct {
+	// Mode is how people sign in, one of AuthModes. Empty accepts every
+	// configured kind of sign-in and leaves what needs one to
+	// RequireAPIKey and ProtectStreams.
+	Mode string `mapstructure:"mode"`
+	// AllowedUsers are the only built-in and OIDC users who may sign in,
+	// everyone when empty. API keys aren't affected.
+	AllowedUsers []string `mapstructure:"allowed_users"`
+	// AdminToken is accepted as an API key with the admin scope
+	AdminToken string `mapstructure:"admin_token" secret:"true"`
+	// RequireAPIKey requires an API key for all API endpoints instead of
+	// only for admin endpoints
+	RequireAPIKey bool `mapstructure:"require_api_key"`
+	// ProtectStreams requires an API key with the read scope for the web
+	// UI, the player and streams
+	ProtectStreams bool `mapstructure:"protect_streams"`
+	// APIKeys are static API keys in addition to those stored in the database
+	APIKeys []APIKeyConfig `mapstructure:"api_keys"`
+	// SessionSecret signs login session cookies. A random secret is used
+	// when empty, which ends all sessions on restart.
+	SessionSecret   string     `mapstructure:"session_secret" secret:"true"`
+	SessionTTLHours int        `mapstructure:"session_ttl_hours"`
+	OIDC            OIDCConfig `mapstructure:"oidc"`
+}
+
+// Auth modes
+const (
+	// AuthNone leaves the web UI, streams and API open, only admin
+	// endpoints need an API key
+	AuthNone = "none"
+	// AuthAPIKey requires an API key everywhere
+	AuthAPIKey = "apikey"
+	// AuthBasic requires a built-in user, signed in with the login form or
+	// HTTP Basic authentication, or an API key
+	AuthBasic = "basic"
+	// AuthOIDC requires signing in through the OpenID Connect provider, or
+	// an API key
+	AuthOIDC = "oidc"
+)
+
+// AuthModes are the values accepted by auth.mode
+var AuthModes = []string{AuthNone, AuthAPIKey, AuthBasic, AuthOIDC}
+
+// StreamsProtected reports whether the web UI, player and streams need
+// credentials
+func (a AuthConfig) StreamsProtected() bool {
+	if a.Mode == "" {
+		return a.ProtectStreams
+	}
+	return a.Mode != AuthNone
+}
+
+// APIProtected reports whether the API endpoints that aren't admin only
+// need credentials
+func (a AuthConfig) APIProtected() bool {
+	if a.Mode == "" {
+		return a.RequireAPIKey
+	}
+	return a.Mode != AuthNone
+}
+
+// PasswordLogin reports whether built-in users can sign in with a password
+func (a AuthConfig) PasswordLogin() bool {
+	return a.Mode == "" || a.Mode == AuthBasic
+}
+
+// OIDCLogin reports whether users can sign in through OpenID Connect
+func (a AuthConfig) OIDCLogin() bool {
+	return (a.Mode == "" || a.Mode == AuthOIDC) && a.OIDC.Issuer != ""
+}
+
+// Allows reports whether a user may sign in
+func (a AuthConfig) Allows(user string) bool {
+	return len(a.AllowedUsers) == 0 || slices.Contains(a.AllowedUsers, user)
+}
+
+// validateAuth checks that the auth mode has the settings it needs and
+// none it contradicts
+func (c *Config) validateAuth() error {
+	a := c.Auth
+	switch a.Mode {
+	case "":
+		return nil
+	case AuthNone:
+		if a.ProtectStreams || a.RequireAPIKey {
+			return fmt.Errorf("auth.mode %q leaves everything open, unset protect_streams and require_api_key", a.Mode)
+		}
+	case AuthAPIKey, AuthBasic:
+		if a.OIDC.Issuer != "" {
+			return fmt.Errorf("auth.mode %q doesn't use auth.oidc, use mode \"oidc\" or remove the issuer", a.Mode)
+		}
+	case AuthOIDC:
+		if a.OIDC.Issuer == "" || a.OIDC.ClientID == "" || a.OIDC.RedirectURL == "" {
+			return fmt.Errorf("auth.mode %q needs auth.oidc.issuer, client_id and redirect_url", a.Mode)
+		}
+	default:
+		return fmt.Errorf("auth.mode %s", oneOf(a.Mode, AuthModes))
+	}
+	if len(a.AllowedUsers) > 0 && !a.PasswordLogin() && !a.OIDCLogin() {
+		return fmt.Errorf("auth.mode %q has no users to sign in, remove allowed_users", a.Mode)
+	}
+	return nil
+}
+
+// OIDCConfig holds OpenID Connect configuration. OIDC login is enabled
+// when an issuer is set.
+type OIDCConfig struct {
+	Issuer       string   `mapstructure:"issuer"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret" secret:"true"`
+	RedirectURL  string   `mapstructure:"redirect_url"`
+	Scopes       []string `mapstructure:"scopes"`
+	// RolesClaim is the claim holding the user's roles or groups. Nested
+	// claims are addressed with dots, e.g. "realm_access.roles".
+	RolesClaim string `mapstructure:"roles_claim"`
+	// AdminRoles map to the admin role. ViewerRoles map to the viewer
+	// role; when empty every authenticated user is a viewer.
+	AdminRoles  []string `mapstructure:"admin_roles"`
+	ViewerRoles []string `mapstructure:"viewer_roles"`
+	// APIAudience is the audience required of bearer JWTs sent to the API,
+	// the client ID by default
+	APIAudience string `mapstructure:"api_audience"`
+}
+
+// RateLimitConfig holds abuse protection settings
+type RateLimitConfig struct {
+	// RequestsPerSecond is the rate each client IP may call the API, log in
+	// and fetch playlists at, 0 to disable rate limiting
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+	// BannedIPs are addresses or CIDR ranges refused on every route
+	BannedIPs []string `mapstructure:"banned_ips"`
+	// TrustedProxies are addresses or CIDR ranges of reverse proxies whose
+	// X-Forwarded-For header is used to find the client IP
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// DLNAConfig holds the DLNA media server smart TVs and other UPnP clients
+// on the local network browse the libraries with
+type DLNAConfig struct {
+	// Enabled announces the server on the local network and serves the
+	// libraries without restricted users to clients on private networks,
+	// without authentication
+	Enabled bool `mapstructure:"enabled"`
+	// FriendlyName is the name clients show, "Streaming on <hostname>"
+	// when empty
+	FriendlyName string `mapstructure:"friendly_name"`
+	// Interface is the network interface discovery requests are answered
+	// on, the one of the default route when empty
+	Interface string `mapstructure:"interface"`
+}
+
+// JellyfinConfig holds the subset of the Jellyfin API that lets Jellyfin
+// and Emby apps browse and play the libraries
+type JellyfinConfig struct {
+	// Enabled serves the Jellyfin API next to the web UI
+	Enabled bool `mapstructure:"enabled"`
+	// ServerName is the name apps show, "Streaming on <hostname>" when
+	// empty
+	ServerName string `mapstructure:"server_name"`
+}
+
+// WebDAVConfig holds the WebDAV share of the local media directories
+type WebDAVConfig struct {
+	// Enabled serves the media directories over WebDAV under /webdav/
+	Enabled bool `mapstructure:"enabled"`
+}
+
+// TelemetryConfig holds the export of traces to an OpenTelemetry collector
+type TelemetryConfig struct {
+	// Enabled traces HTTP requests, database calls and transcode jobs
+	Enabled bool `mapstructure:"enabled"`
+	// Endpoint is the base URL of the collector's OTLP/HTTP receiver, to
+	// which /v1/traces is appended
+	Endpoint string `mapstructure:"endpoint"`
+	// Headers are sent with every export, such as an API key of a hosted
+	// collector
+	Headers map[string]string `mapstructure:"headers" secret:"true"`
+	// ServiceName names the process in traces
+	ServiceName string `mapstructure:"service_name"`
+	// SampleRatio is the share of traces recorded, from 0 to 1. Requests
+	// carrying a traceparent header follow the decision of their caller.
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+// UIConfig holds the look of the web UI
+type UIConfig struct {
+	// Theme is the color scheme of visitors who didn't pick one with the
+	// toggle: "auto" follows their system, "light" or "dark" sets it
+	Theme string `mapstructure:"theme"`
+	// AccentColor replaces the blue of links and buttons, as a hex color
+	// such as "#e50914"
+	AccentColor string `mapstructure:"accent_color"`
+	// Logo is an image file shown in the page headers, such as a PNG or
+	// SVG
+	Logo string `mapstructure:"logo"`
+}
+
+// LiveConfig holds the live channels encoders such as OBS push to the
+// server, which are served as live HLS alongside the libraries
+type LiveConfig struct {
+	// SegmentDuration and PlaylistEntries set the length of live segments
+	// and how many of them the playlist keeps, short for a low delay
+	SegmentDuration int           `mapstructure:"segment_duration"`
+	PlaylistEntries int           `mapstructure:"playlist_entries"`
+	WebRTC          LiveWebRTC    `mapstructure:"webrtc"`
+	Channels        []LiveChannel `mapstructure:"channels"`
+}
+
+// LiveWebRTC sets how WebRTC viewers of live channels reach the server
+type LiveWebRTC struct {
+	// ICEServers are the STUN and TURN servers the server finds its public
+	// address with, such as "stun:stun.l.google.com:19302"
+	ICEServers []string `mapstructure:"ice_servers"`
+	// ICEUsername and ICECredential sign in to the TURN servers
+	ICEUsername   string `mapstructure:"ice_username"`
+	ICECredential string `mapstructure:"ice_credential" secret:"true"`
+	// UDPPort is the port all WebRTC viewers are served on, to be opened
+	// in the firewall, or 0 for a random port per viewer
+	UDPPort int `mapstructure:"udp_port"`
+	// PublicIPs are the addresses viewers reach the server on when it is
+	// behind NAT with UDPPort forwarded to it
+	PublicIPs []string `mapstructure:"public_ips"`
+}
+
+// LiveChannel is a live stream accepted on an address of its own, or a
+// playlist of library videos played around the clock
+type LiveChannel struct {
+	// Name identifies the channel in URLs, /live/<name>
+	Name string `mapstructure:"name"`
+	// Listen is the URL FFmpeg waits for the push on, such as
+	// "rtmp://0.0.0.0:1935/live/stream" or
+	// "srt://0.0.0.0:9000?passphrase=<secret>". Channels without one
+	// play a playlist.
+	Listen string `mapstructure:"listen" secret:"true"`
+	// Profile is the transcode profile the stream is encoded to the
+	// variants of, or "" to segment the pushed stream as it is
+	Profile string `mapstructure:"profile"`
+	// WebRTC also offers the stream over WebRTC with WHEP, for sub-second
+	// latency: "on", "video" for streams without audio such as cameras,
+	// or "" for HLS only
+	WebRTC string `mapstructure:"webrtc"`
+
+	// Library, Folder and Tag select the processed videos a playlist
+	// channel plays: those of a library, below a folder of the libraries
+	// and with a tag, all of them when unset. They play in the order of
+	// their names, or reshuffled every round with Shuffle.
+	Library string `mapstructure:"library"`
+	Folder  string `mapstructure:"folder"`
+	Tag     string `mapstructure:"tag"`
+	Shuffle bool   `mapstructure:"shuffle"`
+	// MaxHeight picks the largest variant of each video up to this height,
+	// the largest one when 0
+	MaxHeight int `mapstructure:"max_height"`
+}
+
+// Playlist reports whether a channel plays library videos rather than
+// receiving a stream
+func (l LiveChannel) Playlist() bool {
+	return l.Listen == ""
+}
+
+// LiveEPGFile is served below /live/ as the programme guide of the
+// channels, so no channel can have its name
+const LiveEPGFile = "epg.xml"
+
+// LiveProtocols are the URL schemes live channels accept pushes with
+var LiveProtocols = []string{"rtmp", "srt"}
+
+// LiveWebRTCModes are the values of the webrtc setting of live channels
+var LiveWebRTCModes = []string{"", "on", "video"}
+
+// Protocol returns the URL scheme of the address a channel listens on
+func (l LiveChannel) Protocol() string {
+	scheme, _, _ := strings.Cut(l.Listen, "://")
+	return strings.ToLower(scheme)
+}
+
+// validateLive checks that live channel names are unique and can be used
+// in URLs, that every channel receiving a stream listens on an address of
+// its own and that playlist channels select videos that can exist
+func (c *Config) validateLive() error {
+	names := make(map[string]bool, len(c.Live.Channels))
+	addrs := make(map[string]string, len(c.Live.Channels))
+	for _, l := range c.Live.Channels {
+		if l.Name == "" || strings.ContainsAny(l.Name, "/\\") || strings.HasPrefix(l.Name, ".") {
+			return fmt.Errorf("live channel %q needs a name without slashes or a leading dot", l.Name)
+		}
+		if l.Name == LiveEPGFile {
+			return fmt.Errorf("live channel name %q is taken by the programme guide", l.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("live channel %q is configured twice", l.Name)
+		}
+		names[l.Name] = true
+		if l.Playlist() {
+			if err := c.validatePlaylistChannel(l); err != nil {
+				return fmt.Errorf("live channel %q: %w", l.Name, err)
+			}
+			continue
+		}
+		if !slices.Contains(LiveWebRTCModes, l.WebRTC) {
+			return fmt.Errorf("live channel %q: webrtc must be \"on\", \"video\" or empty", l.Name)
+		}
+		if l.Library != "" || l.Folder != "" || l.Tag != "" || l.Shuffle || l.MaxHeight != 0 {
+			return fmt.Errorf("live channel %q receives a stream and can't have library, folder, tag, shuffle or max_height", l.Name)
+		}
+		u, err := url.Parse(l.Listen)
+		if err != nil || !slices.Contains(LiveProtocols, l.Protocol()) || u.Port() == "" {
+			return fmt.Errorf("live channel %q: listen must be an rtmp:// or srt:// URL with a port", l.Name)
+		}
+		if other, ok := addrs[u.Host]; ok {
+			return fmt.Errorf("live channels %q and %q both listen on %s", other, l.Name, u.Host)
+		}
+		addrs[u.Host] = l.Name
+		if l.Profile != "" {
+			if _, ok := c.Profile(l.Profile); !ok {
+				return fmt.Errorf("live channel %q: profile %q is not a configured transcode profile", l.Name, l.Profile)
+			}
+		}
+	}
+	return nil
+}
+
+// validatePlaylistChannel checks the settings of a channel playing videos
+func (c *Config) validatePlaylistChannel(l LiveChannel) error {
+	if l.Profile != "" {
+		return fmt.Errorf("profile only applies to channels receiving a stream, playlists play the transcoded videos")
+	}
+	if l.WebRTC != "" {
+		return fmt.Errorf("webrtc only applies to channels receiving a stream")
+	}
+	if l.Library != "" && !slices.ContainsFunc(c.Libraries, func(m MediaLibrary) bool { return m.Name == l.Library }) {
+		return fmt.Errorf("library %q is not configured", l.Library)
+	}
+	if l.Folder != "" && (filepath.IsAbs(l.Folder) || !filepath.IsLocal(l.Folder)) {
+		return fmt.Errorf("folder %q must be relative to the libraries", l.Folder)
+	}
+	if l.MaxHeight < 0 {
+		return fmt.Errorf("max_height must not be negative")
+	}
+	return nil
+}
+
+// DVRConfig holds the network streams the librarian records into the
+// libraries on a schedule
+type DVRConfig struct {
+	Recordings []Recording `mapstructure:"recordings"`
+}
+
+// Recording is a network stream recorded at the same time on some or all
+// days of the week
+type Recording struct {
+	// Name identifies the recording and starts the names of its files,
+	// "<name> 2006-01-02 15.04.mkv"
+	Name string `mapstructure:"name"`
+	// URL is the stream to record: an HLS playlist or other stream over
+	// http(s)://, or an rtsp://, rtmp:// or srt:// stream
+	URL string `mapstructure:"url" secret:"true"`
+	// Days are the days the recording runs on, "mon" to "sun", every day
+	// when empty
+	Days []string `mapstructure:"days"`
+	// Start is when the recording starts, "15:04" in the local time of
+	// the librarian
+	Start           string `mapstructure:"start"`
+	DurationMinutes int    `mapstructure:"duration_minutes"`
+	// Library and Folder are where the files are written: a folder of the
+	// library of that name, or of the first local library when Library is
+	// unset
+	Library string `mapstructure:"library"`
+	Folder  string `mapstructure:"folder"`
+	// Keep is how many of the recordings are kept, older ones being
+	// deleted, or 0 to keep them all
+	Keep int `mapstructure:"keep"`
+}
+
+// RecordingProtocols are the URL schemes recordings accept
+var RecordingProtocols = []string{"http", "https", "rtsp", "rtsps", "rtmp", "rtmps", "srt"}
+
+// recordingDays maps the days of recordings to weekdays
+var recordingDays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// RunsOn reports whether a recording runs on a day of the week
+func (r Recording) RunsOn(day time.Weekday) bool {
+	if len(r.Days) == 0 {
+		return true
+	}
+	for _, d := range r.Days {
+		if recordingDays[strings.ToLower(d)] == day {
+			return true
+		}
+	}
+	return false
+}
+
+// StartClock returns the hour and minute a recording starts at
+func (r Recording) StartClock() (hour, min int) {
+	t, _ := time.Parse("15:04", r.Start)
+	return t.Hour(), t.Minute()
+}
+
+// Duration returns how long a recording runs
+func (r Recording) Duration() time.Duration {
+	return time.Duration(r.DurationMinutes) * time.Minute
+}
+
+// validateDVR checks that recording names are unique and can be used in
+// file names, and that every recording has a stream, a schedule and a
+// local library to be written to
+func (c *Config) validateDVR() error {
+	names := make(map[string]bool, len(c.DVR.Recordings))
+	for _, r := range c.DVR.Recordings {
+		if r.Name == "" || strings.ContainsAny(r.Name, "/\\") || strings.HasPrefix(r.Name, ".") {
+			return fmt.Errorf("recording %q needs a name without slashes or a leading dot", r.Name)
+		}
+		if names[r.Name] {
+			return fmt.Errorf("recording %q is configured twice", r.Name)
+		}
+		names[r.Name] = true
+
+		scheme, _, _ := strings.Cut(r.URL, "://")
+		if !slices.Contains(RecordingProtocols, strings.ToLower(scheme)) {
+			return fmt.Errorf("recording %q: url must be an http(s)://, rtsp(s)://, rtmp(s):// or srt:// URL", r.Name)
+		}
+		for _, d := range r.Days {
+			if _, ok := recordingDays[strings.ToLower(d)]; !ok {
+				return fmt.Errorf("recording %q: day %q must be one of mon, tue, wed, thu, fri, sat or sun", r.Name, d)
+			}
+		}
+		if _, err := time.Parse("15:04", r.Start); err != nil {
+			return fmt.Errorf("recording %q: start must be a time like \"18:30\"", r.Name)
+		}
+		if r.DurationMinutes <= 0 {
+			return fmt.Errorf("recording %q: duration_minutes must be positive", r.Name)
+		}
+		if r.Keep < 0 {
+			return fmt.Errorf("recording %q: keep must not be negative", r.Name)
+		}
+		if r.Folder != "" && (filepath.IsAbs(r.Folder) || !filepath.IsLocal(r.Folder)) {
+			return fmt.Errorf("recording %q: folder %q must be relative to the library", r.Name, r.Folder)
+		}
+		if _, ok := c.RecordingLibrary(r); !ok {
+			if r.Library == "" {
+				return fmt.Errorf("recording %q: there is no local library to record into", r.Name)
+			}
+			return fmt.Errorf("recording %q: library %q is not a configured local library", r.Name, r.Library)
+		}
+	}
+	return nil
+}
+
+// RecordingLibrary returns the library a recording is written to
+func (c *Config) RecordingLibrary(r Recording) (MediaLibrary, bool) {
+	if r.Library == "" {
+		return c.UploadLibrary()
+	}
+	for _, l := range c.Libraries {
+		if l.Name == r.Library && !l.Remote() {
+			return l, true
+		}
+	}
+	return MediaLibrary{}, false
+}
+
+// LiveDirPath returns the directory the HLS output of live channels is
+// written to
+func (m MediaConfig) LiveDirPath() string {
+	return filepath.Join(m.CacheDir, DefaultLiveDirName)
+}
+
+// NotifyConfig holds the notifications the librarian sends when videos
+// finish processing
+type NotifyConfig struct {
+	Targets []NotifyTarget `mapstructure:"targets"`
+}
+
+// NotifyTarget is an external service or command notified of processing
+// results
+type NotifyTarget struct {
+	Name string `mapstructure:"name"`
+	// Type is "webhook", "ntfy", "discord" or "command"
+	Type string `mapstructure:"type"`
+	URL  string `mapstructure:"url" secret:"true"`
+	// Events are "video_ready" and "video_failed", both when empty
+	Events []string `mapstructure:"events"`
+	// Template is a Go text/template rendering the webhook body, or the
+	// message sent to ntfy and Discord
+	Template string `mapstructure:"template"`
+	// ContentType is the content type of webhook bodies
+	ContentType string `mapstructure:"content_type"`
+	// Headers are added to every request, e.g. Authorization for ntfy
+	Headers map[string]string `mapstructure:"headers" secret:"true"`
+	// Command is the program and arguments run for command targets
+	Command []string `mapstructure:"command"`
+	// TimeoutSeconds is how long a command may run before it is killed
+	TimeoutSeconds int `mapstructure:"timeout_seconds"`
+}
+
+// ScrobbleConfig holds the services the librarian sends the videos users
+// watched to
+type ScrobbleConfig struct {
+	Targets []ScrobbleTarget `mapstructure:"targets"`
+}
+
+// ScrobbleTarget is a service, such as Trakt, the watch history of some or
+// all users is sent to
+type ScrobbleTarget struct {
+	// Name identifies the target, and which watches were sent to it, so
+	// renaming a target sends the whole history again
+	Name string `mapstructure:"name"`
+	// Type is "trakt" or "webhook"
+	Type string `mapstructure:"type"`
+	// Users are the users whose watches are sent, everyone's when empty.
+	// A Trakt account belongs to one person, so Trakt targets usually list
+	// one user.
+	Users []string `mapstructure:"users"`
+	// URL is where webhook targets post watches to
+	URL string `mapstructure:"url" secret:"true"`
+	// Headers are added to every webhook request, e.g. Authorization
+	Headers map[string]string `mapstructure:"headers" secret:"true"`
+	// ClientID and ClientSecret are those of the Trakt API app, created at
+	// https://trakt.tv/oauth/applications
+	ClientID     string `mapstructure:"client_id"`
+	ClientSecret string `mapstructure:"client_secret" secret:"true"`
+}
+
+// Sends reports whether a target receives the watches of a user
+func (t ScrobbleTarget) Sends(user string) bool {
+	return len(t.Users) == 0 || slices.Contains(t.Users, user)
+}
+
+// LogConfig holds the settings of the service logs
+type LogConfig struct {
+	// Level is "debug", "info", "warn" or "error". Debug includes the
+	// FFmpeg commands run.
+	Level string `mapstructure:"level"`
+	// Format is "text" for key=value lines or "json" for JSON lines
+	Format string `mapstructure:"format"`
+	// File is where the logs are written instead of stderr
+	File string `mapstructure:"file"`
+	// MaxSizeMB rotates the file once it grows past this size, 0 never
+	// rotates it
+	MaxSizeMB int `mapstructure:"max_size_mb"`
+	// MaxAgeDays removes rotated files older than this, 0 keeps them
+	MaxAgeDays int `mapstructure:"max_age_days"`
+}
+
+// MediaLibrary is a named media directory, such as "Kids" or "Main"
+type MediaLibrary struct {
+	Name     string `mapstructure:"name"`
+	MediaDir string `mapstructure:"media_dir"`
+	// TranscodePreset overrides server.transcode_preset for this library
+	TranscodePreset string `mapstructure:"transcode_preset"`
+	// Users are the users and API keys allowed to see the library,
+	// everyone when empty. Admins can always see every library.
+	Users []string `mapstructure:"users"`
+	// WarmNewest is the number of most recently added videos kept
+	// transcoded, transcoding them again after their output was evicted
+	// and keeping it from being evicted, 0 for none
+	WarmNewest int `mapstructure:"warm_newest"`
+	// WarmTags restricts the videos kept warm to those with one of these
+	// tags, all videos when empty
+	WarmTags []string `mapstructure:"warm_tags"`
+	// Source reads the videos from object storage or a web server instead
+	// of media_dir, which is set to its Root
+	Source SourceConfig `mapstructure:"source"`
+}
+
+// SourceConfig locates the videos of a library kept outside the local file
+// system
+type SourceConfig struct {
+	// Type is one of SourceTypes, empty for a local media_dir
+	Type string `mapstructure:"type"`
+	// URL is the WebDAV collection, or the directory listing of a web
+	// server, the videos are in
+	URL      string `mapstructure:"url"`
+	Username string `mapstructure:"username"`
+	Password string `mapstructure:"password" secret:"true"`
+	// S3 is the bucket of "s3" sources, prefix selects a folder in it.
+	// serve and url_expiry_minutes don't apply.
+	S3 S3Config `mapstructure:"s3"`
+}
+
+// Root returns the directory the videos of the source are at in the
+// library, e.g. "s3:bucket/prefix" or "webdav:host/path"
+func (s SourceConfig) Root() string {
+	if s.Type == "s3" {
+		return path.Join("s3:"+s.S3.Bucket, s.S3.Prefix)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return s.Type + ":" + s.URL
+	}
+	return path.Join(s.Type+":"+u.Host, u.Path)
+}
+
+// Allows reports whether a user may see the library
+func (l MediaLibrary) Allows(user string) bool {
+	return len(l.Users) == 0 || slices.Contains(l.Users, user)
+}
+
+// Remote reports whether the library reads its videos from a source
+// other than the local file system
+func (l MediaLibrary) Remote() bool {
+	return l.Source.Type != ""
+}
+
+// Restricted reports whether the library is hidden from some users
+func (l MediaLibrary) Restricted() bool {
+	return len(l.Users) > 0
+}
+
+// MediaLibraries returns the configured libraries, or a single unnamed
+// library for media.media_dir when there are none
+func (c *Config) MediaLibraries() []MediaLibrary {
+	if len(c.Libraries) == 0 {
+		return []MediaLibrary{{
+			MediaDir:   c.Media.MediaDir,
+			WarmNewest: c.Cache.WarmNewest,
+			WarmTags:   c.Cache.WarmTags,
+		}}
+	}
+	return c.Libraries
+}
+
+// UploadLibrary returns the first library with a local media directory,
+// which uploads go to
+func (c *Config) UploadLibrary() (MediaLibrary, bool) {
+	for _, l := range c.MediaLibraries() {
+		if !l.Remote() {
+			return l, true
+		}
+	}
+	return MediaLibrary{}, false
+}
+
+// LibraryFor returns the library a video file belongs to
+func (c *Config) LibraryFor(path string) (MediaLibrary, bool) {
+	for _, l := range c.MediaLibraries() {
+		if safepath.Within(l.MediaDir, path) {
+			return l, true
+		}
+	}
+	return MediaLibrary{}, false
+}
+
+// validateLibraries checks that library names are unique and can be used
+// in URLs
+func (c *Config) validateLibraries() error {
+	names := make(map[string]bool, len(c.Libraries))
+	for i := range c.Libraries {
+		l := &c.Libraries[i]
+		if l.Name == "" || strings.ContainsAny(l.Name, "/\\") || l.Name == "." || l.Name == ".." {
+			return fmt.Errorf("library %q needs a name without slashes", l.Name)
+		}
+		if names[l.Name] {
+			return fmt.Errorf("library %q is configured twice", l.Name)
+		}
+		names[l.Name] = true
+		if l.Remote() {
+			if l.MediaDir != "" {
+				return fmt.Errorf("library %q reads from a %s source and can't have a media_dir", l.Name, l.Source.Type)
+			}
+			l.MediaDir = l.Source.Root()
+			continue
+		}
+		if l.MediaDir == "" {
+			return fmt.Errorf("library %q needs a media_dir", l.Name)
+		}
+		l.MediaDir = filepath.Clean(l.MediaDir)
+	}
+	return nil
+}
+
+// DeviceProfile limits the variants offered to a kind of client, such as
+// old Chromecasts that can't decode HEVC
+type DeviceProfile struct {
+	Name string `mapstructure:"name"`
+	// UserAgents identify the device by substrings of the User-Agent
+	// header, matched ignoring case
+	UserAgents []string `mapstructure:"user_agents"`
+	// MaxHeight and MaxBitrateKbps drop larger variants, 0 for no limit
+	MaxHeight      int `mapstructure:"max_height"`
+	MaxBitrateKbps int `mapstructure:"max_bitrate_kbps"`
+	// ExcludeCodecs drops variants whose CODECS attribute contains one of
+	// these, e.g. "hvc1"
+	ExcludeCodecs []string `mapstructure:"exclude_codecs"`
+}
+
+// APIKeyConfig defines a static API key
+type APIKeyConfig struct {
+	Name   string   `mapstructure:"name"`
+	Key    string   `mapstructure:"key" secret:"true"`
+	Scopes []string `mapstructure:"scopes"`
 }
 
 // Default configuration values
@@ -54,14 +971,94 @@ const (
 	DefaultSegmentFormat          = "mpegts"
 	DefaultSegmentDuration        = 10
 	DefaultPlaylistEntries        = 6
+	DefaultHWAccel                = "none"
+	DefaultEnableDebug            = false
+	DefaultMaxStreamsPerUser      = 0
+	DefaultResumeSaveInterval     = 10
+	DefaultAccessLog              = true
+	DefaultCompression            = true
+	DefaultWatchConfig            = true
 	DefaultScanOnStart            = true
 	DefaultWatchForChanges        = true
 	DefaultScanIntervalMinutes    = 60
 	DefaultProcessingThreads      = 2
+	DefaultTrashRetentionDays     = 30
+	DefaultMaxRetries             = 3
+	DefaultRetryBackoffMinutes    = 30
+	DefaultControlAddr            = "127.0.0.1:8081"
+	DefaultQueueBackend           = "database"
+	DefaultRedisAddr              = "localhost:6379"
+	DefaultRedisKeyPrefix         = "streaming:"
+	DefaultQueueVisibilityTimeout = 300
+	DefaultQueueHeartbeat         = 30
+	DefaultMaxUploadSizeMB        = 20480
+	DefaultWorkDirName            = ".work"
+	DefaultLiveDirName            = ".live"
+	DefaultCachePolicy            = "hybrid"
+	DefaultCacheCleanupInterval   = 60
+	DefaultCacheMaxAgeHours       = 24
+	DefaultCacheMaxSizeGB         = 0
+	DefaultCacheMinFreeDiskGB     = 1
+	DefaultCacheMaxVideoSizeGB    = 0
+	DefaultCacheFsckIntervalHours = 24
+	DefaultCacheBackend           = "local"
+	DefaultCacheHotCacheMB        = 64
+	DefaultCacheHotSegments       = 2
+	DefaultS3Region               = "us-east-1"
+	DefaultS3Serve                = "redirect"
+	DefaultS3URLExpiryMinutes     = 60
+	DefaultRequireAPIKey          = false
+	DefaultProtectStreams         = false
+	DefaultSessionTTLHours        = 168
+	DefaultOIDCRolesClaim         = "groups"
+	DefaultRequestsPerSecond      = 0
+	DefaultRateLimitBurst         = 20
+	DefaultDLNAEnabled            = false
+	DefaultJellyfinEnabled        = false
+	DefaultWebDAVEnabled          = false
+	DefaultLiveSegmentDuration    = 2
+	DefaultLivePlaylistEntries    = 6
+	DefaultLiveWebRTCUDPPort      = 0
+	DefaultTelemetryEnabled       = false
+	DefaultTelemetryEndpoint      = "http://localhost:4318"
+	DefaultTelemetryServiceName   = "streaming"
+	DefaultTelemetrySampleRatio   = 1.0
+	DefaultLogLevel               = "info"
+	DefaultLogFormat              = "text"
+	DefaultLogMaxSizeMB           = 100
+	DefaultLogMaxAgeDays          = 14
+	DefaultUITheme                = "auto"
 )
 
-// InitConfig initializes the configuration system
+// InitConfig initializes the configuration system and creates the media
+// and cache directories
 func InitConfig(cfgFile string) (*Config, error) {
+	cfg, err := Load(cfgFile)
+	if err != nil {
+		return nil, err
+	}
+	
+	// Create directories if they don't exist. Remote libraries have none.
+	dirs := []string{cfg.Media.MediaDir, cfg.Media.CacheDir}
+	for _, l := range cfg.Libraries {
+		if !l.Remote() {
+			dirs = append(dirs, l.MediaDir)
+		}
+	}
+	for _, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
+			}
+		}
+	}
+
+	return cfg, nil
+}
+
+// Load reads the configuration from the config file and environment
+// without touching the file system otherwise
+func Load(cfgFile string) (*Config, error) {
 	v := viper.New()
 
 	// Set default values
@@ -71,12 +1068,40 @@ func InitConfig(cfgFile string) (*Config, error) {
 	v.SetDefault("server.segment_format", DefaultSegmentFormat)
 	v.SetDefault("server.segment_duration", DefaultSegmentDuration)
 	v.SetDefault("server.playlist_entries", DefaultPlaylistEntries)
+	v.SetDefault("server.hwaccel", DefaultHWAccel)
+	v.SetDefault("server.enable_debug", DefaultEnableDebug)
+	v.SetDefault("server.max_streams_per_user", DefaultMaxStreamsPerUser)
+	v.SetDefault("server.resume_save_interval", DefaultResumeSaveInterval)
+	v.SetDefault("server.access_log", DefaultAccessLog)
+	v.SetDefault("server.compression", DefaultCompression)
+	v.SetDefault("server.watch_config", DefaultWatchConfig)
+	v.SetDefault("server.base_path", "")
+	v.SetDefault("server.public_url", "")
+	v.SetDefault("server.tls.cert_file", "")
+	v.SetDefault("server.tls.key_file", "")
+	v.SetDefault("server.tls.acme", false)
+	v.SetDefault("server.tls.email", "")
+	v.SetDefault("server.tls.acme_cache_dir", "")
+	v.SetDefault("server.tls.http_addr", "")
 	
 	// Library config defaults
 	v.SetDefault("library.scan_on_start", DefaultScanOnStart)
 	v.SetDefault("library.watch_for_changes", DefaultWatchForChanges)
 	v.SetDefault("library.scan_interval_minutes", DefaultScanIntervalMinutes)
 	v.SetDefault("library.processing_threads", DefaultProcessingThreads)
+	v.SetDefault("library.trash_retention_days", DefaultTrashRetentionDays)
+	v.SetDefault("library.max_retries", DefaultMaxRetries)
+	v.SetDefault("library.retry_backoff_minutes", DefaultRetryBackoffMinutes)
+	v.SetDefault("library.control_addr", DefaultControlAddr)
+	v.SetDefault("queue.backend", DefaultQueueBackend)
+	v.SetDefault("queue.redis.addr", DefaultRedisAddr)
+	v.SetDefault("queue.redis.username", "")
+	v.SetDefault("queue.redis.password", "")
+	v.SetDefault("queue.redis.db", 0)
+	v.SetDefault("queue.redis.tls", false)
+	v.SetDefault("queue.redis.key_prefix", DefaultRedisKeyPrefix)
+	v.SetDefault("queue.visibility_timeout_seconds", DefaultQueueVisibilityTimeout)
+	v.SetDefault("queue.heartbeat_seconds", DefaultQueueHeartbeat)
 
 	// Determine default paths based on executable location
 	execDir, err := getExecutableDir()
@@ -86,12 +1111,73 @@ func InitConfig(cfgFile string) (*Config, error) {
 
 	v.SetDefault("media.media_dir", filepath.Join(execDir, "media"))
 	v.SetDefault("media.cache_dir", filepath.Join(execDir, "cache"))
+	v.SetDefault("media.work_dir", "")
+	v.SetDefault("media.max_upload_size_mb", DefaultMaxUploadSizeMB)
+	v.SetDefault("cache.policy", DefaultCachePolicy)
+	v.SetDefault("cache.cleanup_interval_minutes", DefaultCacheCleanupInterval)
+	v.SetDefault("cache.max_age_hours", DefaultCacheMaxAgeHours)
+	v.SetDefault("cache.max_size_gb", DefaultCacheMaxSizeGB)
+	v.SetDefault("cache.min_free_disk_gb", DefaultCacheMinFreeDiskGB)
+	v.SetDefault("cache.max_video_size_gb", DefaultCacheMaxVideoSizeGB)
+	v.SetDefault("cache.warm_newest", 0)
+	v.SetDefault("cache.fsck_interval_hours", DefaultCacheFsckIntervalHours)
+	v.SetDefault("cache.backend", DefaultCacheBackend)
+	v.SetDefault("cache.hot_cache_mb", DefaultCacheHotCacheMB)
+	v.SetDefault("cache.hot_segments", DefaultCacheHotSegments)
+	v.SetDefault("cache.s3.endpoint", "")
+	v.SetDefault("cache.s3.region", DefaultS3Region)
+	v.SetDefault("cache.s3.bucket", "")
+	v.SetDefault("cache.s3.prefix", "")
+	v.SetDefault("cache.s3.access_key", "")
+	v.SetDefault("cache.s3.secret_key", "")
+	v.SetDefault("cache.s3.path_style", false)
+	v.SetDefault("cache.s3.serve", DefaultS3Serve)
+	v.SetDefault("cache.s3.url_expiry_minutes", DefaultS3URLExpiryMinutes)
 	v.SetDefault("database.path", filepath.Join(execDir, "library.db"))
+	v.SetDefault("auth.mode", "")
+	v.SetDefault("auth.admin_token", "")
+	v.SetDefault("auth.require_api_key", DefaultRequireAPIKey)
+	v.SetDefault("auth.protect_streams", DefaultProtectStreams)
+	v.SetDefault("auth.session_secret", "")
+	v.SetDefault("auth.session_ttl_hours", DefaultSessionTTLHours)
+	v.SetDefault("auth.oidc.issuer", "")
+	v.SetDefault("auth.oidc.client_id", "")
+	v.SetDefault("auth.oidc.client_secret", "")
+	v.SetDefault("auth.oidc.redirect_url", "")
+	v.SetDefault("auth.oidc.roles_claim", DefaultOIDCRolesClaim)
+	v.SetDefault("rate_limit.requests_per_second", DefaultRequestsPerSecond)
+	v.SetDefault("rate_limit.burst", DefaultRateLimitBurst)
+	v.SetDefault("dlna.enabled", DefaultDLNAEnabled)
+	v.SetDefault("dlna.friendly_name", "")
+	v.SetDefault("dlna.interface", "")
+	v.SetDefault("jellyfin.enabled", DefaultJellyfinEnabled)
+	v.SetDefault("jellyfin.server_name", "")
+	v.SetDefault("webdav.enabled", DefaultWebDAVEnabled)
+	v.SetDefault("live.segment_duration", DefaultLiveSegmentDuration)
+	v.SetDefault("live.playlist_entries", DefaultLivePlaylistEntries)
+	v.SetDefault("live.webrtc.udp_port", DefaultLiveWebRTCUDPPort)
+	v.SetDefault("telemetry.enabled", DefaultTelemetryEnabled)
+	v.SetDefault("telemetry.endpoint", DefaultTelemetryEndpoint)
+	v.SetDefault("telemetry.headers", map[string]string{})
+	v.SetDefault("telemetry.service_name", DefaultTelemetryServiceName)
+	v.SetDefault("telemetry.sample_ratio", DefaultTelemetrySampleRatio)
+	v.SetDefault("logging.level", DefaultLogLevel)
+	v.SetDefault("logging.format", DefaultLogFormat)
+	v.SetDefault("logging.file", "")
+	v.SetDefault("logging.max_size_mb", DefaultLogMaxSizeMB)
+	v.SetDefault("logging.max_age_days", DefaultLogMaxAgeDays)
+	v.SetDefault("transcode.profile", "")
+	v.SetDefault("ui.theme", DefaultUITheme)
+	v.SetDefault("ui.accent_color", "")
+	v.SetDefault("ui.logo", "")
 
 	// Environment variables
-	v.SetEnvPrefix("STREAMING")
+	v.SetEnvPrefix(EnvPrefix)
 	v.AutomaticEnv()
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	if err := bindEnv(v); err != nil {
+		return nil, err
+	}
 
 	// Config file
 	if cfgFile != "" {
@@ -103,7 +1189,6 @@ func InitConfig(cfgFile string) (*Config, error) {
 		v.AddConfigPath("$HOME/.streaming")
 		v.AddConfigPath("/etc/streaming")
 		v.SetConfigName("config")
-		v.SetConfigType("toml")
 	}
 
 	// If a config file is found, read it in
@@ -114,20 +1199,64 @@ func InitConfig(cfgFile string) (*Config, error) {
 		}
 	}
 
-	// Create configuration structure
+	// [logging] used to be called [log], which config files written
+	// before keep working with
+	oldLog := v.InConfig("log") && !v.InConfig("logging")
+	if oldLog {
+		for key, value := range v.GetStringMap("log") {
+			// Set overrides the environment, which should win
+			if _, ok := os.LookupEnv(EnvName("logging." + key)); !ok {
+				v.Set("logging."+key, value)
+			}
+		}
+	}
+
+	// Create configuration structure, remembering settings that don't
+	// exist so they can be reported as typos
 	cfg := &Config{}
-	if err := v.Unmarshal(cfg); err != nil {
+	var meta mapstructure.Metadata
+	if err := v.Unmarshal(cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.Metadata = &meta
+		dc.DecodeHook = decodeHook
+	}); err != nil {
 		return nil, fmt.Errorf("unable to decode config: %w", err)
 	}
-
-	// Create directories if they don't exist
-	dirs := []string{cfg.Media.MediaDir, cfg.Media.CacheDir}
-	for _, dir := range dirs {
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			if err := os.MkdirAll(dir, 0755); err != nil {
-				return nil, fmt.Errorf("failed to create directory %s: %w", dir, err)
-			}
+	cfg.file = v.ConfigFileUsed()
+	for _, key := range meta.Unused {
+		if oldLog && (key == "log" || strings.HasPrefix(key, "log.")) {
+			continue
 		}
+		cfg.unknown = append(cfg.unknown, key)
+	}
+	if cfg.file != "" && !slices.Contains(ConfigTypes, strings.TrimPrefix(filepath.Ext(cfg.file), ".")) {
+		return nil, fmt.Errorf("config file %s is not TOML, YAML or JSON", cfg.file)
+	}
+
+	if err := cfg.expandPaths(); err != nil {
+		return nil, err
+	}
+
+	// Use "" or "/prefix" as base path, so routes can be appended to it
+	cfg.Server.BasePath = strings.TrimRight(cfg.Server.BasePath, "/")
+	if cfg.Server.BasePath != "" && !strings.HasPrefix(cfg.Server.BasePath, "/") {
+		cfg.Server.BasePath = "/" + cfg.Server.BasePath
+	}
+	cfg.Server.PublicURL = strings.TrimRight(cfg.Server.PublicURL, "/")
+
+	if err := cfg.validateLibraries(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateProfiles(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateAuth(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateLive(); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateDVR(); err != nil {
+		return nil, err
 	}
 
 	return cfg, nil
@@ -135,6 +1264,13 @@ func InitConfig(cfgFile string) (*Config, error) {
 
 // WriteDefaultConfig writes a default configuration file
 func WriteDefaultConfig(path string) error {
+	return WriteConfig(path, nil)
+}
+
+// WriteConfig writes a configuration file with the default settings,
+// replacing those in settings, which are keyed like the file, e.g.
+// "server.port"
+func WriteConfig(path string, settings map[string]any) error {
 	v := viper.New()
 
 	v.SetDefault("server.host", DefaultHost)
@@ -143,12 +1279,40 @@ func WriteDefaultConfig(path string) error {
 	v.SetDefault("server.segment_format", DefaultSegmentFormat)
 	v.SetDefault("server.segment_duration", DefaultSegmentDuration)
 	v.SetDefault("server.playlist_entries", DefaultPlaylistEntries)
+	v.SetDefault("server.hwaccel", DefaultHWAccel)
+	v.SetDefault("server.enable_debug", DefaultEnableDebug)
+	v.SetDefault("server.max_streams_per_user", DefaultMaxStreamsPerUser)
+	v.SetDefault("server.resume_save_interval", DefaultResumeSaveInterval)
+	v.SetDefault("server.access_log", DefaultAccessLog)
+	v.SetDefault("server.compression", DefaultCompression)
+	v.SetDefault("server.watch_config", DefaultWatchConfig)
+	v.SetDefault("server.base_path", "")
+	v.SetDefault("server.public_url", "")
+	v.SetDefault("server.tls.cert_file", "")
+	v.SetDefault("server.tls.key_file", "")
+	v.SetDefault("server.tls.acme", false)
+	v.SetDefault("server.tls.email", "")
+	v.SetDefault("server.tls.acme_cache_dir", "")
+	v.SetDefault("server.tls.http_addr", "")
 	
 	// Library config defaults
 	v.SetDefault("library.scan_on_start", DefaultScanOnStart)
 	v.SetDefault("library.watch_for_changes", DefaultWatchForChanges)
 	v.SetDefault("library.scan_interval_minutes", DefaultScanIntervalMinutes)
 	v.SetDefault("library.processing_threads", DefaultProcessingThreads)
+	v.SetDefault("library.trash_retention_days", DefaultTrashRetentionDays)
+	v.SetDefault("library.max_retries", DefaultMaxRetries)
+	v.SetDefault("library.retry_backoff_minutes", DefaultRetryBackoffMinutes)
+	v.SetDefault("library.control_addr", DefaultControlAddr)
+	v.SetDefault("queue.backend", DefaultQueueBackend)
+	v.SetDefault("queue.redis.addr", DefaultRedisAddr)
+	v.SetDefault("queue.redis.username", "")
+	v.SetDefault("queue.redis.password", "")
+	v.SetDefault("queue.redis.db", 0)
+	v.SetDefault("queue.redis.tls", false)
+	v.SetDefault("queue.redis.key_prefix", DefaultRedisKeyPrefix)
+	v.SetDefault("queue.visibility_timeout_seconds", DefaultQueueVisibilityTimeout)
+	v.SetDefault("queue.heartbeat_seconds", DefaultQueueHeartbeat)
 
 	// Determine default paths based on executable location
 	execDir, err := getExecutableDir()
@@ -158,7 +1322,68 @@ func WriteDefaultConfig(path string) error {
 
 	v.SetDefault("media.media_dir", filepath.Join(execDir, "media"))
 	v.SetDefault("media.cache_dir", filepath.Join(execDir, "cache"))
+	v.SetDefault("media.work_dir", "")
+	v.SetDefault("media.max_upload_size_mb", DefaultMaxUploadSizeMB)
+	v.SetDefault("cache.policy", DefaultCachePolicy)
+	v.SetDefault("cache.cleanup_interval_minutes", DefaultCacheCleanupInterval)
+	v.SetDefault("cache.max_age_hours", DefaultCacheMaxAgeHours)
+	v.SetDefault("cache.max_size_gb", DefaultCacheMaxSizeGB)
+	v.SetDefault("cache.min_free_disk_gb", DefaultCacheMinFreeDiskGB)
+	v.SetDefault("cache.max_video_size_gb", DefaultCacheMaxVideoSizeGB)
+	v.SetDefault("cache.warm_newest", 0)
+	v.SetDefault("cache.fsck_interval_hours", DefaultCacheFsckIntervalHours)
+	v.SetDefault("cache.backend", DefaultCacheBackend)
+	v.SetDefault("cache.hot_cache_mb", DefaultCacheHotCacheMB)
+	v.SetDefault("cache.hot_segments", DefaultCacheHotSegments)
+	v.SetDefault("cache.s3.endpoint", "")
+	v.SetDefault("cache.s3.region", DefaultS3Region)
+	v.SetDefault("cache.s3.bucket", "")
+	v.SetDefault("cache.s3.prefix", "")
+	v.SetDefault("cache.s3.access_key", "")
+	v.SetDefault("cache.s3.secret_key", "")
+	v.SetDefault("cache.s3.path_style", false)
+	v.SetDefault("cache.s3.serve", DefaultS3Serve)
+	v.SetDefault("cache.s3.url_expiry_minutes", DefaultS3URLExpiryMinutes)
 	v.SetDefault("database.path", filepath.Join(execDir, "library.db"))
+	v.SetDefault("auth.mode", "")
+	v.SetDefault("auth.admin_token", "")
+	v.SetDefault("auth.require_api_key", DefaultRequireAPIKey)
+	v.SetDefault("auth.protect_streams", DefaultProtectStreams)
+	v.SetDefault("auth.session_secret", "")
+	v.SetDefault("auth.session_ttl_hours", DefaultSessionTTLHours)
+	v.SetDefault("auth.oidc.issuer", "")
+	v.SetDefault("auth.oidc.client_id", "")
+	v.SetDefault("auth.oidc.client_secret", "")
+	v.SetDefault("auth.oidc.redirect_url", "")
+	v.SetDefault("auth.oidc.roles_claim", DefaultOIDCRolesClaim)
+	v.SetDefault("rate_limit.requests_per_second", DefaultRequestsPerSecond)
+	v.SetDefault("rate_limit.burst", DefaultRateLimitBurst)
+	v.SetDefault("dlna.enabled", DefaultDLNAEnabled)
+	v.SetDefault("dlna.friendly_name", "")
+	v.SetDefault("dlna.interface", "")
+	v.SetDefault("jellyfin.enabled", DefaultJellyfinEnabled)
+	v.SetDefault("jellyfin.server_name", "")
+	v.SetDefault("webdav.enabled", DefaultWebDAVEnabled)
+	v.SetDefault("live.segment_duration", DefaultLiveSegmentDuration)
+	v.SetDefault("live.playlist_entries", DefaultLivePlaylistEntries)
+	v.SetDefault("live.webrtc.udp_port", DefaultLiveWebRTCUDPPort)
+	v.SetDefault("telemetry.enabled", DefaultTelemetryEnabled)
+	v.SetDefault("telemetry.endpoint", DefaultTelemetryEndpoint)
+	v.SetDefault("telemetry.headers", map[string]string{})
+	v.SetDefault("telemetry.service_name", DefaultTelemetryServiceName)
+	v.SetDefault("telemetry.sample_ratio", DefaultTelemetrySampleRatio)
+	v.SetDefault("logging.level", DefaultLogLevel)
+	v.SetDefault("logging.format", DefaultLogFormat)
+	v.SetDefault("logging.file", "")
+	v.SetDefault("logging.max_size_mb", DefaultLogMaxSizeMB)
+	v.SetDefault("logging.max_age_days", DefaultLogMaxAgeDays)
+	v.SetDefault("transcode.profile", "")
+	v.SetDefault("ui.theme", DefaultUITheme)
+	v.SetDefault("ui.accent_color", "")
+	v.SetDefault("ui.logo", "")
+	for key, value := range settings {
+		v.Set(key, value)
+	}
 
 	// Create the directory if it doesn't exist
 	dir := filepath.Dir(path)
@@ -0,0 +1,87 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding
+// settings, e.g. STREAMING_SERVER_PORT for server.port
+const EnvPrefix = "STREAMING"
+
+// EnvName returns the environment variable overriding a setting
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_"))
+}
+
+// bindEnv binds every setting to its environment variable. Viper only
+// looks up the variables of settings it knows about, so settings without
+// a default, like lists, would otherwise be missed.
+func bindEnv(v *viper.Viper) error {
+	for _, key := range settingKeys(reflect.TypeOf(Config{}), "") {
+		if err := v.BindEnv(key, EnvName(key)); err != nil {
+			return fmt.Errorf("failed to bind %s: %w", EnvName(key), err)
+		}
+	}
+	return nil
+}
+
+// settingKeys returns the keys of the settings of a section, following
+// the mapstructure tags into nested sections. Lists and maps are single
+// settings.
+func settingKeys(t reflect.Type, prefix string) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("mapstructure")
+		if name == "" || !field.IsExported() {
+			continue
+		}
+		if prefix != "" {
+			name = prefix + "." + name
+		}
+		if field.Type.Kind() == reflect.Struct {
+			keys = append(keys, settingKeys(field.Type, name)...)
+		} else {
+			keys = append(keys, name)
+		}
+	}
+	return keys
+}
+
+// jsonEnvHook decodes settings given as JSON in environment variables,
+// such as STREAMING_TRANSCODE_PROFILES='[{"name": "hd"}]', into the lists
+// and maps they configure. Lists of plain values can also be given
+// separated by commas.
+func jsonEnvHook(from, to reflect.Type, data any) (any, error) {
+	if from.Kind() != reflect.String {
+		return data, nil
+	}
+	switch to.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Struct:
+	default:
+		return data, nil
+	}
+	s := strings.TrimSpace(data.(string))
+	if !strings.HasPrefix(s, "[") && !strings.HasPrefix(s, "{") {
+		return data, nil
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(s), &decoded); err != nil {
+		return nil, fmt.Errorf("invalid JSON: %w", err)
+	}
+	return decoded, nil
+}
+
+// decodeHook is how settings are converted into the types of Config:
+// JSON and comma separated lists from the environment, and durations
+var decodeHook = mapstructure.ComposeDecodeHookFunc(
+	jsonEnvHook,
+	mapstructure.StringToTimeDurationHookFunc(),
+	mapstructure.StringToSliceHookFunc(","),
+)
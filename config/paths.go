@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConfigTypes are the extensions of the config files read: TOML, YAML
+// and JSON
+var ConfigTypes = []string{"toml", "yaml", "yml", "json"}
+
+// ExpandPath expands a leading "~" to the home directory and $VAR or
+// ${VAR} to environment variables. Unset variables are an error rather
+// than becoming empty, which would turn "$DATA/media" into "/media".
+func ExpandPath(path string) (string, error) {
+	var missing []string
+	path = os.Expand(path, func(name string) string {
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			missing = append(missing, name)
+		}
+		return value
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("environment variable %s is not set", strings.Join(missing, ", "))
+	}
+
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("can't expand ~: %w", err)
+		}
+		path = filepath.Join(home, path[1:])
+	}
+	return path, nil
+}
+
+// expandPaths expands the paths of the configuration with ExpandPath
+func (c *Config) expandPaths() error {
+	type path struct {
+		key   string
+		value *string
+	}
+	paths := []path{
+		{"media.media_dir", &c.Media.MediaDir},
+		{"media.cache_dir", &c.Media.CacheDir},
+		{"media.work_dir", &c.Media.WorkDir},
+		{"database.path", &c.Database.Path},
+		{"logging.file", &c.Log.File},
+		{"server.tls.cert_file", &c.Server.TLS.CertFile},
+		{"server.tls.key_file", &c.Server.TLS.KeyFile},
+		{"server.tls.acme_cache_dir", &c.Server.TLS.ACMECacheDir},
+		{"ui.logo", &c.UI.Logo},
+	}
+	for i := range c.Libraries {
+		paths = append(paths, path{fmt.Sprintf("libraries[%d].media_dir", i), &c.Libraries[i].MediaDir})
+	}
+	for _, p := range paths {
+		expanded, err := ExpandPath(*p.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", p.key, err)
+		}
+		*p.value = expanded
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// TranscodeConfig holds the named profiles videos are transcoded with
+type TranscodeConfig struct {
+	// Profile is the name of the profile videos are transcoded with, the
+	// first one when empty
+	Profile  string             `mapstructure:"profile"`
+	Profiles []TranscodeProfile `mapstructure:"profiles"`
+}
+
+// TranscodeProfile is a named set of encoding settings: the quality
+// variants a video is transcoded to and how its video, audio and segments
+// are encoded. Settings left out fall back to those in [server] and the
+// defaults.
+type TranscodeProfile struct {
+	Name string `mapstructure:"name"`
+	// VideoCodec is "h264" or "hevc"
+	VideoCodec string `mapstructure:"video_codec"`
+	// Preset is the x264 or x265 preset of software encoding
+	Preset string `mapstructure:"preset"`
+	// HWAccel selects a hardware encoder like server.hwaccel
+	HWAccel  string           `mapstructure:"hwaccel"`
+	Variants []QualityVariant `mapstructure:"variants"`
+	// AudioCodec is "aac", "ac3" or "eac3"
+	AudioCodec       string `mapstructure:"audio_codec"`
+	AudioBitrateKbps int    `mapstructure:"audio_bitrate_kbps"`
+	// AudioChannels downmixes the audio, 0 keeps the channels of the source
+	AudioChannels   int    `mapstructure:"audio_channels"`
+	SegmentFormat   string `mapstructure:"segment_format"`
+	SegmentDuration int    `mapstructure:"segment_duration"`
+}
+
+// QualityVariant is a resolution and bitrate of the quality ladder
+type QualityVariant struct {
+	Width            int `mapstructure:"width"`
+	Height           int `mapstructure:"height"`
+	VideoBitrateKbps int `mapstructure:"video_bitrate_kbps"`
+}
+
+// Name returns the name of the variant, e.g. "720p"
+func (q QualityVariant) Name() string {
+	return fmt.Sprintf("%dp", q.Height)
+}
+
+// Bitrate returns the video bitrate as FFmpeg takes it, e.g. "2500k"
+func (q QualityVariant) Bitrate() string {
+	return fmt.Sprintf("%dk", q.VideoBitrateKbps)
+}
+
+// DefaultProfileName is the name of the profile built from [server] when
+// no profiles are configured
+const DefaultProfileName = "default"
+
+// Default settings of transcode profiles not found in [server]
+const (
+	DefaultVideoCodec       = "h264"
+	DefaultAudioCodec       = "aac"
+	DefaultAudioBitrateKbps = 128
+)
+
+// DefaultVariants is the quality ladder of profiles without variants
+var DefaultVariants = []QualityVariant{
+	{Width: 1280, Height: 720, VideoBitrateKbps: 2500},
+}
+
+// VideoCodecs and AudioCodecs are the codecs transcode profiles can encode
+// to
+var (
+	VideoCodecs = []string{"h264", "hevc"}
+	AudioCodecs = []string{"aac", "ac3", "eac3"}
+)
+
+// TranscodeProfiles returns the configured transcode profiles with the
+// settings they leave out filled in, or a single "default" profile built
+// from [server] when there are none
+func (c *Config) TranscodeProfiles() []TranscodeProfile {
+	profiles := c.Transcode.Profiles
+	if len(profiles) == 0 {
+		profiles = []TranscodeProfile{{Name: DefaultProfileName}}
+	}
+	resolved := make([]TranscodeProfile, len(profiles))
+	for i, p := range profiles {
+		resolved[i] = c.withDefaults(p)
+	}
+	return resolved
+}
+
+// Profile returns the transcode profile called name, or the one selected
+// by transcode.profile when name is ""
+func (c *Config) Profile(name string) (TranscodeProfile, bool) {
+	if name == "" {
+		name = c.Transcode.Profile
+	}
+	profiles := c.TranscodeProfiles()
+	if name == "" {
+		return profiles[0], true
+	}
+	for _, p := range profiles {
+		if p.Name == name {
+			return p, true
+		}
+	}
+	return TranscodeProfile{}, false
+}
+
+// withDefaults fills in the settings a profile leaves out
+func (c *Config) withDefaults(p TranscodeProfile) TranscodeProfile {
+	if p.VideoCodec == "" {
+		p.VideoCodec = DefaultVideoCodec
+	}
+	if p.Preset == "" {
+		p.Preset = c.Server.TranscodePreset
+	}
+	if p.HWAccel == "" {
+		p.HWAccel = c.Server.HWAccel
+	}
+	if len(p.Variants) == 0 {
+		p.Variants = DefaultVariants
+	}
+	p.Variants = slices.Clone(p.Variants)
+	if p.AudioCodec == "" {
+		p.AudioCodec = DefaultAudioCodec
+	}
+	if p.AudioBitrateKbps == 0 {
+		p.AudioBitrateKbps = DefaultAudioBitrateKbps
+	}
+	if p.SegmentFormat == "" {
+		p.SegmentFormat = c.Server.SegmentFormat
+	}
+	if p.SegmentDuration == 0 {
+		p.SegmentDuration = c.Server.SegmentDuration
+	}
+	return p
+}
+
+// Check reports the first setting of a profile FFmpeg would fail on or
+// players couldn't play. Run it on profiles with their defaults filled in.
+func (p TranscodeProfile) Check() error {
+	if !slices.Contains(VideoCodecs, p.VideoCodec) {
+		return fmt.Errorf("video_codec %s", oneOf(p.VideoCodec, VideoCodecs))
+	}
+	if !slices.Contains(X264Presets, p.Preset) {
+		return fmt.Errorf("preset %q is not an x264 preset, use one of %s%s", p.Preset, strings.Join(X264Presets, ", "), didYouMean(p.Preset, X264Presets))
+	}
+	if !slices.Contains(HWAccels, p.HWAccel) {
+		return fmt.Errorf("hwaccel %s", oneOf(p.HWAccel, HWAccels))
+	}
+
+	heights := make(map[int]bool, len(p.Variants))
+	for i, q := range p.Variants {
+		// Encoders want even dimensions for 4:2:0 chroma
+		if q.Width <= 0 || q.Height <= 0 || q.Width%2 != 0 || q.Height%2 != 0 {
+			return fmt.Errorf("variants[%d]: width and height must be positive and even", i)
+		}
+		if q.VideoBitrateKbps <= 0 {
+			return fmt.Errorf("variants[%d]: video_bitrate_kbps must be positive", i)
+		}
+		// Variant files are named after the height
+		if heights[q.Height] {
+			return fmt.Errorf("variants[%d]: there is another %s variant", i, q.Name())
+		}
+		heights[q.Height] = true
+	}
+
+	if !slices.Contains(AudioCodecs, p.AudioCodec) {
+		return fmt.Errorf("audio_codec %s", oneOf(p.AudioCodec, AudioCodecs))
+	}
+	if p.AudioBitrateKbps < 0 {
+		return errors.New("audio_bitrate_kbps must be positive")
+	}
+	if p.AudioChannels < 0 {
+		return errors.New("audio_channels must not be negative")
+	}
+	if !slices.Contains(segmentFormats, p.SegmentFormat) {
+		return fmt.Errorf("segment_format %s", oneOf(p.SegmentFormat, segmentFormats))
+	}
+	if p.SegmentDuration <= 0 {
+		return errors.New("segment_duration must be positive")
+	}
+	// Apple players only take HEVC in fragmented MP4
+	if p.VideoCodec == "hevc" && p.SegmentFormat != "fmp4" {
+		return errors.New("hevc needs segment_format \"fmp4\"")
+	}
+	return nil
+}
+
+// validateProfiles checks that profile names are unique and that
+// transcode.profile names one of them
+func (c *Config) validateProfiles() error {
+	names := make(map[string]bool, len(c.Transcode.Profiles))
+	for _, p := range c.Transcode.Profiles {
+		if p.Name == "" {
+			return errors.New("transcode profiles need a name")
+		}
+		if names[p.Name] {
+			return fmt.Errorf("transcode profile %q is configured twice", p.Name)
+		}
+		names[p.Name] = true
+	}
+	if _, ok := c.Profile(""); !ok {
+		return fmt.Errorf("transcode.profile %q is not a configured profile", c.Transcode.Profile)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+)
+
+// reloadable are the settings running services apply when the
+// configuration is reloaded, with a function taking them over from the
+// new configuration
+var reloadable = []func(dst, src *Config){
+	func(dst, src *Config) { dst.Server.TranscodePreset = src.Server.TranscodePreset },
+	func(dst, src *Config) { dst.Server.HWAccel = src.Server.HWAccel },
+	func(dst, src *Config) { dst.Transcode = src.Transcode },
+	func(dst, src *Config) { dst.Library.ScanIntervalMinutes = src.Library.ScanIntervalMinutes },
+	func(dst, src *Config) { dst.Library.TrashRetentionDays = src.Library.TrashRetentionDays },
+	func(dst, src *Config) { dst.Library.MaxRetries = src.Library.MaxRetries },
+	func(dst, src *Config) { dst.Library.RetryBackoffMinutes = src.Library.RetryBackoffMinutes },
+	func(dst, src *Config) {
+		// The storage of segments and the hot cache stay until a restart
+		backend, s3 := dst.Cache.Backend, dst.Cache.S3
+		hotMB, hotSegments := dst.Cache.HotCacheMB, dst.Cache.HotSegments
+		dst.Cache = src.Cache
+		dst.Cache.Backend, dst.Cache.S3 = backend, s3
+		dst.Cache.HotCacheMB, dst.Cache.HotSegments = hotMB, hotSegments
+	},
+	func(dst, src *Config) { dst.Notify = src.Notify },
+	func(dst, src *Config) { dst.Devices = src.Devices },
+	func(dst, src *Config) { dst.Log.Level = src.Log.Level },
+}
+
+// Reload returns a copy of c with the settings that can change at runtime
+// taken from next: transcode presets, hardware encoder, transcode
+// profiles, scan interval, trash retention, retries, cache limits and
+// warming, notify targets, device profiles and the log level. applied lists the settings
+// that changed with it, restart those that differ but only take effect on
+// a restart.
+func (c *Config) Reload(next *Config) (reloaded *Config, applied, restart []string) {
+	merged := *c
+	for _, apply := range reloadable {
+		apply(&merged, next)
+	}
+	// Libraries can change their preset and what is kept warm, but not
+	// their directory or users
+	if sameLibraries(c.Libraries, next.Libraries) {
+		merged.Libraries = slices.Clone(next.Libraries)
+	}
+	return &merged, Diff(c, &merged), Diff(&merged, next)
+}
+
+// sameLibraries reports whether two sets of libraries only differ in
+// their transcode presets and warming
+func sameLibraries(a, b []MediaLibrary) bool {
+	return slices.EqualFunc(a, b, func(x, y MediaLibrary) bool {
+		x.TranscodePreset, y.TranscodePreset = "", ""
+		x.WarmNewest, y.WarmNewest = 0, 0
+		x.WarmTags, y.WarmTags = nil, nil
+		return reflect.DeepEqual(x, y)
+	})
+}
+
+// Diff returns the settings that differ between two configurations, named
+// like in the config file, e.g. "server.port" or "devices[0].max_height"
+func Diff(a, b *Config) []string {
+	var keys []string
+	diffOf(reflect.ValueOf(*a), reflect.ValueOf(*b), "", &keys)
+	return keys
+}
+
+// diffOf appends the keys of the settings that differ between a and b,
+// following the mapstructure tags. Lists of the same length are compared
+// entry by entry, other lists and maps as a whole.
+func diffOf(a, b reflect.Value, key string, keys *[]string) {
+	switch {
+	case a.Kind() == reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name := field.Tag.Get("mapstructure")
+			if name == "" || !field.IsExported() {
+				continue
+			}
+			if key != "" {
+				name = key + "." + name
+			}
+			diffOf(a.Field(i), b.Field(i), name, keys)
+		}
+	case a.Kind() == reflect.Slice && a.Len() == b.Len() && a.Type().Elem().Kind() == reflect.Struct:
+		for i := 0; i < a.Len(); i++ {
+			diffOf(a.Index(i), b.Index(i), fmt.Sprintf("%s[%d]", key, i), keys)
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*keys = append(*keys, key)
+		}
+	}
+}
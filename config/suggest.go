@@ -0,0 +1,132 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// didYouMean returns a hint naming the option closest to value, such as
+// `; did you mean "medium"?`, or "" when none is close enough to be a typo
+func didYouMean(value string, options []string) string {
+	if best, ok := closest(value, options); ok {
+		return fmt.Sprintf("; did you mean %q?", best)
+	}
+	return ""
+}
+
+// closest returns the option closest to value, if it is close enough for
+// value to be a typo of it
+func closest(value string, options []string) (string, bool) {
+	best, bestDist := "", -1
+	for _, o := range options {
+		d := editDistance(strings.ToLower(value), strings.ToLower(o))
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = o, d
+		}
+	}
+	if bestDist < 0 || bestDist > max(1, len(value)/3) {
+		return "", false
+	}
+	return best, true
+}
+
+// oneOf describes a value that isn't one of options, suggesting the
+// option it is probably a typo of
+func oneOf(value string, options []string) string {
+	return fmt.Sprintf("%q is not one of %s%s", value, strings.Join(options, ", "), didYouMean(value, options))
+}
+
+// editDistance returns the number of single character insertions,
+// deletions, substitutions and swaps of adjacent characters turning a
+// into b
+func editDistance(a, b string) int {
+	d := make([][]int, len(a)+1)
+	for i := range d {
+		d[i] = make([]int, len(b)+1)
+		d[i][0] = i
+	}
+	for j := range d[0] {
+		d[0][j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			d[i][j] = min(d[i-1][j]+1, d[i][j-1]+1, d[i-1][j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				d[i][j] = min(d[i][j], d[i-2][j-2]+1)
+			}
+		}
+	}
+	return d[len(a)][len(b)]
+}
+
+// listIndex matches the list indexes in setting names, e.g. "[0]"
+var listIndex = regexp.MustCompile(`\[\d+\]`)
+
+// suggestSetting returns a hint naming the setting an unknown one is
+// probably a typo of, e.g. "server.port" for "server.prot"
+func suggestSetting(key string) string {
+	parent, name := "", key
+	if i := strings.LastIndex(key, "."); i >= 0 {
+		parent, name = key[:i], key[i+1:]
+	}
+
+	// Find the section the setting is in, ignoring list indexes
+	t := reflect.TypeOf(Config{})
+	if parent != "" {
+		for _, part := range strings.Split(listIndex.ReplaceAllString(parent, ""), ".") {
+			field, ok := fieldByKey(t, part)
+			if !ok {
+				return ""
+			}
+			t = elemType(field.Type)
+		}
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
+	}
+
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		if tag := t.Field(i).Tag.Get("mapstructure"); tag != "" {
+			names = append(names, tag)
+		}
+	}
+	best, ok := closest(name, names)
+	if !ok {
+		return ""
+	}
+	if parent != "" {
+		// Name the whole setting, keeping the list indexes
+		best = parent + "." + best
+	}
+	return fmt.Sprintf("; did you mean %q?", best)
+}
+
+// fieldByKey returns the field of a struct type with the mapstructure tag
+// key
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	if t.Kind() != reflect.Struct {
+		return reflect.StructField{}, false
+	}
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("mapstructure") == key {
+			return t.Field(i), true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// elemType returns the type of the settings in a section, looking
+// through lists and maps
+func elemType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Slice || t.Kind() == reflect.Map || t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
@@ -0,0 +1,464 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/internal/safepath"
+)
+
+// X264Presets are the presets accepted by transcode_preset, fastest first
+var X264Presets = []string{
+	"ultrafast", "superfast", "veryfast", "faster", "fast",
+	"medium", "slow", "slower", "veryslow", "placebo",
+}
+
+// segmentFormats are the HLS segment types FFmpeg can write
+var segmentFormats = []string{"mpegts", "fmp4"}
+
+// HWAccels are the values accepted by server.hwaccel
+var HWAccels = []string{"none", "nvenc", "qsv", "vaapi", "videotoolbox"}
+
+// Cache eviction policies accepted by cache.policy: evicting output by
+// max_age_hours, by max_size_gb, or by both
+const (
+	CachePolicyAge    = "age"
+	CachePolicySize   = "size"
+	CachePolicyHybrid = "hybrid"
+)
+
+// CachePolicies are the values accepted by cache.policy
+var CachePolicies = []string{CachePolicyAge, CachePolicySize, CachePolicyHybrid}
+
+// CacheBackends and S3ServeModes are the values accepted by cache.backend
+// and cache.s3.serve
+var (
+	CacheBackends = []string{"local", "s3"}
+	S3ServeModes  = []string{"redirect", "proxy"}
+)
+
+// QueueBackends are the values accepted by queue.backend
+var QueueBackends = []string{"database", "redis"}
+
+// SourceTypes are the values accepted by the source.type of libraries
+var SourceTypes = []string{"s3", "webdav", "http"}
+
+// LogLevels and LogFormats are the values accepted by logging.level and
+// logging.format
+var (
+	LogLevels  = []string{"debug", "info", "warn", "error"}
+	LogFormats = []string{"text", "json"}
+)
+
+// UIThemes are the values accepted by ui.theme
+var UIThemes = []string{"auto", "light", "dark"}
+
+// hexColor matches the colors accepted by ui.accent_color
+var hexColor = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// ErrUnknownSetting is wrapped by the problems Validate reports for
+// settings in the config file that don't exist
+var ErrUnknownSetting = errors.New("unknown setting")
+
+// File returns the path of the config file that was read, "" if none
+func (c *Config) File() string {
+	return c.file
+}
+
+// Validate checks the settings that would otherwise only fail, or silently
+// misbehave, once used, and returns every problem found. Problems with a
+// value that looks like a typo suggest the intended one. Settings checked by
+// the packages using them, such as device profiles and notify targets, aren't
+// covered. Paths are checked for existence and the directories written to for
+// write access, so run it where the server runs.
+func (c *Config) Validate() []error {
+	var errs []error
+	add := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	for _, key := range c.unknown {
+		errs = append(errs, fmt.Errorf("%w %q, check for typos%s", ErrUnknownSetting, key, suggestSetting(key)))
+	}
+
+	if c.Server.Port < 1 || c.Server.Port > 65535 {
+		add("server.port %d is not a TCP port", c.Server.Port)
+	}
+	if !slices.Contains(X264Presets, c.Server.TranscodePreset) {
+		add("server.transcode_preset %q is not an x264 preset, use one of %s%s", c.Server.TranscodePreset, strings.Join(X264Presets, ", "), didYouMean(c.Server.TranscodePreset, X264Presets))
+	}
+	if !slices.Contains(segmentFormats, c.Server.SegmentFormat) {
+		add("server.segment_format %s", oneOf(c.Server.SegmentFormat, segmentFormats))
+	}
+	if c.Server.SegmentDuration <= 0 {
+		add("server.segment_duration must be positive")
+	}
+	if c.Server.PlaylistEntries < 0 {
+		add("server.playlist_entries must not be negative")
+	}
+	if !slices.Contains(HWAccels, c.Server.HWAccel) {
+		add("server.hwaccel %s", oneOf(c.Server.HWAccel, HWAccels))
+	}
+	for _, p := range c.TranscodeProfiles() {
+		if err := p.Check(); err != nil {
+			add("transcode profile %q: %v", p.Name, err)
+		}
+	}
+	switch t := c.Server.TLS; {
+	case t.ACME && (t.CertFile != "" || t.KeyFile != ""):
+		add("server.tls: use either acme or cert_file and key_file, not both")
+	case t.ACME && len(t.Domains) == 0:
+		add("server.tls: acme needs at least one domain")
+	case !t.ACME && (t.CertFile == "") != (t.KeyFile == ""):
+		add("server.tls: cert_file and key_file must be set together")
+	}
+	if pub := c.Server.PublicURL; pub != "" {
+		if u, err := url.Parse(pub); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.Path != "" {
+			add("server.public_url %q must be an http(s) URL without a path, such as https://media.example.com", pub)
+		}
+	}
+	if addr := c.Server.TLS.HTTPAddr; addr != "" {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			add("server.tls.http_addr %q is not a host:port address", addr)
+		}
+	}
+	for _, f := range []string{c.Server.TLS.CertFile, c.Server.TLS.KeyFile} {
+		if f != "" {
+			if err := checkFile(f); err != nil {
+				add("server.tls: %v", err)
+			}
+		}
+	}
+
+	if c.Library.ProcessingThreads <= 0 {
+		add("library.processing_threads must be positive")
+	}
+	if c.Library.ScanIntervalMinutes < 0 {
+		add("library.scan_interval_minutes must not be negative")
+	}
+	if addr := c.Library.ControlAddr; addr != "" && !strings.HasPrefix(addr, "unix:") {
+		if _, _, err := net.SplitHostPort(addr); err != nil {
+			add("library.control_addr %q is neither host:port nor unix:/path", addr)
+		}
+	}
+
+	if !slices.Contains(QueueBackends, c.Queue.Backend) {
+		add("queue.backend %s", oneOf(c.Queue.Backend, QueueBackends))
+	}
+	if c.Queue.Backend == "redis" {
+		if _, _, err := net.SplitHostPort(c.Queue.Redis.Addr); err != nil {
+			add("queue.redis.addr %q is not a host:port address", c.Queue.Redis.Addr)
+		}
+		if c.Queue.HeartbeatSeconds <= 0 {
+			add("queue.heartbeat_seconds must be positive")
+		}
+		if c.Queue.VisibilityTimeoutSeconds <= c.Queue.HeartbeatSeconds {
+			add("queue.visibility_timeout_seconds must be longer than queue.heartbeat_seconds")
+		}
+	}
+
+	if !slices.Contains(LogLevels, c.Log.Level) {
+		add("logging.level %s", oneOf(c.Log.Level, LogLevels))
+	}
+	if !slices.Contains(LogFormats, c.Log.Format) {
+		add("logging.format %s", oneOf(c.Log.Format, LogFormats))
+	}
+	if c.Log.File != "" {
+		if err := checkWritable(filepath.Dir(c.Log.File)); err != nil {
+			add("logging.file: %v", err)
+		}
+	}
+	if c.Log.MaxSizeMB < 0 {
+		add("logging.max_size_mb must not be negative")
+	}
+	if c.Log.MaxAgeDays < 0 {
+		add("logging.max_age_days must not be negative")
+	}
+
+	if c.Auth.SessionTTLHours <= 0 {
+		add("auth.session_ttl_hours must be positive")
+	}
+	if c.RateLimit.RequestsPerSecond < 0 {
+		add("rate_limit.requests_per_second must not be negative")
+	}
+	if c.RateLimit.RequestsPerSecond > 0 && c.RateLimit.Burst <= 0 {
+		add("rate_limit.burst must be positive when rate limiting is enabled")
+	}
+	if c.DLNA.Enabled && c.DLNA.Interface != "" {
+		if _, err := net.InterfaceByName(c.DLNA.Interface); err != nil {
+			add("dlna.interface: %v", err)
+		}
+	}
+
+	if c.Live.SegmentDuration <= 0 {
+		add("live.segment_duration must be positive")
+	}
+	if c.Live.PlaylistEntries <= 0 {
+		add("live.playlist_entries must be positive")
+	}
+	for _, server := range c.Live.WebRTC.ICEServers {
+		scheme, _, _ := strings.Cut(server, ":")
+		if !slices.Contains([]string{"stun", "stuns", "turn", "turns"}, scheme) {
+			add("live.webrtc.ice_servers: %q must be a stun: or turn: URL", server)
+		}
+	}
+	if c.Live.WebRTC.UDPPort < 0 || c.Live.WebRTC.UDPPort > 65535 {
+		add("live.webrtc.udp_port must be between 0 and 65535")
+	}
+	for _, ip := range c.Live.WebRTC.PublicIPs {
+		if net.ParseIP(ip) == nil {
+			add("live.webrtc.public_ips: %q is not an IP address", ip)
+		}
+	}
+	if c.Telemetry.Enabled {
+		if u, err := url.Parse(c.Telemetry.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			add("telemetry.endpoint must be an http:// or https:// URL")
+		}
+		if c.Telemetry.ServiceName == "" {
+			add("telemetry.service_name must not be empty")
+		}
+	}
+	if c.Telemetry.SampleRatio < 0 || c.Telemetry.SampleRatio > 1 {
+		add("telemetry.sample_ratio must be between 0 and 1")
+	}
+	if !slices.Contains(UIThemes, c.UI.Theme) {
+		add("ui.theme %s", oneOf(c.UI.Theme, UIThemes))
+	}
+	if c.UI.AccentColor != "" && !hexColor.MatchString(c.UI.AccentColor) {
+		add("ui.accent_color %q must be a hex color such as #e50914", c.UI.AccentColor)
+	}
+	if c.UI.Logo != "" {
+		if err := checkFile(c.UI.Logo); err != nil {
+			add("ui.logo: %v", err)
+		}
+	}
+
+	libs := c.MediaLibraries()
+	for i, lib := range libs {
+		name := "media.media_dir"
+		if lib.Name != "" {
+			name = fmt.Sprintf("library %q", lib.Name)
+			if lib.TranscodePreset != "" && !slices.Contains(X264Presets, lib.TranscodePreset) {
+				add("%s: transcode_preset %q is not an x264 preset%s", name, lib.TranscodePreset, didYouMean(lib.TranscodePreset, X264Presets))
+			}
+		}
+		if lib.Remote() {
+			for _, problem := range lib.Source.validate() {
+				add("%s: source.%s", name, problem)
+			}
+		} else if err := checkDir(lib.MediaDir); err != nil {
+			add("%s: %v", name, err)
+		}
+		if lib.WarmNewest < 0 {
+			add("%s: warm_newest must not be negative", name)
+		}
+		// The scanner would pick up the transcoded output as new videos,
+		// and evicting the cache would delete videos
+		if safepath.Within(lib.MediaDir, c.Media.CacheDir) || safepath.Within(c.Media.CacheDir, lib.MediaDir) {
+			add("%s and media.cache_dir overlap, keep the cache outside the media directories", name)
+		}
+		if w := c.Media.WorkDir; w != "" && (safepath.Within(lib.MediaDir, w) || safepath.Within(w, lib.MediaDir)) {
+			add("%s and media.work_dir overlap, keep the work directory outside the media directories", name)
+		}
+		// A file would belong to whichever library comes first
+		for _, other := range libs[i+1:] {
+			if safepath.Within(lib.MediaDir, other.MediaDir) || safepath.Within(other.MediaDir, lib.MediaDir) {
+				add("libraries %q and %q overlap", lib.Name, other.Name)
+			}
+		}
+	}
+	if c.Cache.MaxAgeHours < 0 {
+		add("cache.max_age_hours must not be negative")
+	}
+	if c.Cache.MaxSizeGB < 0 {
+		add("cache.max_size_gb must not be negative")
+	}
+	if c.Cache.MinFreeDiskGB < 0 {
+		add("cache.min_free_disk_gb must not be negative")
+	}
+	if c.Cache.MaxVideoSizeGB < 0 {
+		add("cache.max_video_size_gb must not be negative")
+	}
+	if !slices.Contains(CachePolicies, c.Cache.Policy) {
+		add("cache.policy %s", oneOf(c.Cache.Policy, CachePolicies))
+	}
+	if c.Cache.CleanupIntervalMinutes <= 0 {
+		add("cache.cleanup_interval_minutes must be positive")
+	}
+	if c.Cache.FsckIntervalHours < 0 {
+		add("cache.fsck_interval_hours must not be negative")
+	}
+	if c.Cache.HotCacheMB < 0 {
+		add("cache.hot_cache_mb must not be negative")
+	}
+	if c.Cache.HotSegments < 0 {
+		add("cache.hot_segments must not be negative")
+	}
+	if !slices.Contains(CacheBackends, c.Cache.Backend) {
+		add("cache.backend %s", oneOf(c.Cache.Backend, CacheBackends))
+	}
+	if c.Cache.Backend == "s3" {
+		for _, problem := range c.Cache.S3.validate() {
+			add("cache.s3.%s", problem)
+		}
+	}
+	if len(c.Libraries) > 0 && (c.Cache.WarmNewest != 0 || len(c.Cache.WarmTags) > 0) {
+		add("cache.warm_newest and cache.warm_tags only apply without [[libraries]], set warm_newest and warm_tags on the libraries instead")
+	}
+	if err := checkWritable(c.Media.CacheDir); err != nil {
+		add("media.cache_dir: %v", err)
+	}
+	if c.Media.WorkDir != "" {
+		if err := checkWritable(c.Media.WorkDir); err != nil {
+			add("media.work_dir: %v", err)
+		}
+	}
+	if err := checkWritable(filepath.Dir(c.Database.Path)); err != nil {
+		add("database.path: %v", err)
+	}
+
+	return errs
+}
+
+// validate returns the problems of the S3 settings, each starting with
+// the name of the setting
+func (s S3Config) validate() []string {
+	problems := s.validateBucket()
+	if !slices.Contains(S3ServeModes, s.Serve) {
+		problems = append(problems, "serve "+oneOf(s.Serve, S3ServeModes))
+	}
+	if s.URLExpiryMinutes <= 0 || s.URLExpiryMinutes > 7*24*60 {
+		problems = append(problems, "url_expiry_minutes must be between 1 and 10080, a week")
+	}
+	return problems
+}
+
+// validateBucket returns the problems of the settings locating the bucket
+// and signing requests
+func (s S3Config) validateBucket() []string {
+	var problems []string
+	if s.Bucket == "" {
+		problems = append(problems, "bucket is required")
+	}
+	if s.Region == "" {
+		problems = append(problems, "region is required")
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		problems = append(problems, "access_key and secret_key are required")
+	}
+	if u, err := url.Parse(s.EndpointURL()); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		problems = append(problems, fmt.Sprintf("endpoint %q is not an http or https URL", s.Endpoint))
+	}
+	return problems
+}
+
+// validate returns the problems of the source of a library, each starting
+// with the name of the setting
+func (s SourceConfig) validate() []string {
+	switch s.Type {
+	case "s3":
+		var problems []string
+		for _, problem := range s.S3.validateBucket() {
+			problems = append(problems, "s3."+problem)
+		}
+		return problems
+	case "webdav", "http":
+		if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return []string{fmt.Sprintf("url %q is not an http or https URL", s.URL)}
+		}
+		if s.Password != "" && s.Username == "" {
+			return []string{"password needs a username"}
+		}
+		return nil
+	}
+	return []string{"type " + oneOf(s.Type, SourceTypes)}
+}
+
+// checkDir checks that a directory exists
+func checkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("%s does not exist", dir)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	return nil
+}
+
+// checkWritable checks that a directory exists and files can be created
+// in it
+func checkWritable(dir string) error {
+	if err := checkDir(dir); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".streaming-check-*")
+	if err != nil {
+		return fmt.Errorf("%s is not writable", dir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return nil
+}
+
+// checkFile checks that a regular file exists and can be read
+func checkFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("can't read %s", path)
+	}
+	f.Close()
+	return nil
+}
+
+// Settings returns the configuration as nested maps keyed like the config
+// file, for printing the effective configuration. Secrets are replaced
+// unless showSecrets is set.
+func (c *Config) Settings(showSecrets bool) map[string]any {
+	return settingsOf(reflect.ValueOf(*c), showSecrets).(map[string]any)
+}
+
+// settingsOf converts a config value into maps and slices, following the
+// mapstructure tags. Fields tagged secret:"true" are redacted when set.
+func settingsOf(v reflect.Value, showSecrets bool) any {
+	switch v.Kind() {
+	case reflect.Struct:
+		m := make(map[string]any)
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			key := field.Tag.Get("mapstructure")
+			if key == "" || !field.IsExported() {
+				continue
+			}
+			if field.Tag.Get("secret") == "true" && !showSecrets && !v.Field(i).IsZero() {
+				m[key] = "<redacted>"
+				continue
+			}
+			m[key] = settingsOf(v.Field(i), showSecrets)
+		}
+		return m
+	case reflect.Slice:
+		s := make([]any, v.Len())
+		for i := range s {
+			s[i] = settingsOf(v.Index(i), showSecrets)
+		}
+		return s
+	case reflect.Map:
+		m := make(map[string]any)
+		for _, k := range v.MapKeys() {
+			m[fmt.Sprint(k.Interface())] = settingsOf(v.MapIndex(k), showSecrets)
+		}
+		return m
+	default:
+		return v.Interface()
+	}
+}
@@ -0,0 +1,430 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// Scope is a permission granted to an API key
+type Scope string
+
+// Scope constants. The admin scope implies all others.
+const (
+	ScopeRead  Scope = "read"  // browse the library and stream videos
+	ScopeWrite Scope = "write" // rate videos and manage favorites
+	ScopeAdmin Scope = "admin" // manage the library, uploads and keys
+)
+
+// ValidScope reports whether s names a known scope
+func ValidScope(s string) bool {
+	switch Scope(s) {
+	case ScopeRead, ScopeWrite, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// CookieName is the cookie a key passed as a query parameter is stored in,
+// so browsers keep sending it for the playlist and segment requests made
+// by the player
+const CookieName = "streaming_api_key"
+
+// touchInterval limits how often the last use of a stored key is written
+const touchInterval = time.Minute
+
+// ErrInvalidKey is returned for credentials that don't match any key
+var ErrInvalidKey = errors.New("invalid API key")
+
+// ErrNotAllowed is returned for users missing from auth.allowed_users
+var ErrNotAllowed = errors.New("user is not allowed to sign in")
+
+// basicAuthTTL is how long HTTP Basic credentials are trusted after their
+// password was checked, sparing a bcrypt comparison on every segment
+const basicAuthTTL = time.Minute
+
+// Principal is the authenticated identity behind a request
+type Principal struct {
+	Name   string
+	Scopes []Scope
+	// UserID is set for built-in users
+	UserID int64
+}
+
+// Has reports whether the principal was granted a scope
+func (p *Principal) Has(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+type contextKey struct{}
+
+// WithPrincipal returns a copy of ctx carrying p
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, contextKey{}, p)
+}
+
+// FromContext returns the principal of a request, or nil if it is anonymous
+func FromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(contextKey{}).(*Principal)
+	return p
+}
+
+// staticKey is an API key defined in the configuration
+type staticKey struct {
+	key       []byte
+	principal *Principal
+}
+
+// Authenticator resolves API keys from the configuration and the database
+// to principals
+type Authenticator struct {
+	config   *config.Config
+	db       *database.DB
+	static   []staticKey
+	sessions *Sessions
+	oidc     *OIDC
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	touched map[int64]time.Time
+	// basic maps the hash of checked HTTP Basic credentials to the time
+	// their password was checked
+	basic map[string]time.Time
+}
+
+// New creates an authenticator. The legacy admin token is accepted as a
+// key named "admin" with the admin scope. When OIDC is configured the
+// provider is contacted for discovery. Logins are logged to logger.
+func New(cfg *config.Config, db *database.DB, logger *slog.Logger) (*Authenticator, error) {
+	sessions, err := NewSessions(cfg.Auth.SessionSecret, time.Duration(cfg.Auth.SessionTTLHours)*time.Hour)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &Authenticator{
+		config:   cfg,
+		db:       db,
+		sessions: sessions,
+		log:      logger,
+		touched:  make(map[int64]time.Time),
+		basic:    make(map[string]time.Time),
+	}
+
+	if cfg.Auth.OIDCLogin() {
+		a.oidc, err = NewOIDC(cfg.Auth.OIDC, cfg.Server.BasePath, sessions, logger)
+		if err != nil {
+			return nil, err
+		}
+		a.oidc.allows = cfg.Auth.Allows
+		logger.Info("OIDC login enabled", "issuer", cfg.Auth.OIDC.Issuer)
+	}
+	if cfg.Auth.SessionSecret == "" && (a.oidc != nil || cfg.Auth.Mode == config.AuthBasic) {
+		logger.Warn("No auth.session_secret configured, logins won't survive a restart")
+	}
+
+	if cfg.Auth.AdminToken != "" {
+		a.static = append(a.static, staticKey{
+			key:       []byte(cfg.Auth.AdminToken),
+			principal: &Principal{Name: "admin", Scopes: []Scope{ScopeAdmin}},
+		})
+	}
+
+	for _, k := range cfg.Auth.APIKeys {
+		if k.Name == "" || k.Key == "" {
+			return nil, fmt.Errorf("api key %q needs both a name and a key", k.Name)
+		}
+		p := &Principal{Name: k.Name}
+		for _, s := range k.Scopes {
+			if !ValidScope(s) {
+				return nil, fmt.Errorf("api key %q has unknown scope %q", k.Name, s)
+			}
+			p.Scopes = append(p.Scopes, Scope(s))
+		}
+		a.static = append(a.static, staticKey{key: []byte(k.Key), principal: p})
+	}
+
+	return a, nil
+}
+
+// OIDC returns the OpenID Connect login, or nil if it isn't configured
+func (a *Authenticator) OIDC() *OIDC {
+	return a.oidc
+}
+
+// LogoutHandler ends the login session and returns to the home page
+func (a *Authenticator) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	a.sessions.Clear(w)
+	http.SetCookie(w, &http.Cookie{Name: CookieName, Path: "/", MaxAge: -1})
+	http.Redirect(w, r, a.config.Server.Path("/"), http.StatusFound)
+}
+
+// PasswordLogin reports whether built-in users can sign in with a password
+func (a *Authenticator) PasswordLogin() bool {
+	return a.config.Auth.PasswordLogin()
+}
+
+// authenticate resolves the credentials sent with a request: a bearer JWT
+// when OIDC is configured, an API key, HTTP Basic credentials in basic mode
+// or a login session. It returns nil without an error for anonymous
+// requests.
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, keySource, error) {
+	if username, password, ok := r.BasicAuth(); ok && a.config.Auth.Mode == config.AuthBasic {
+		p, err := a.basicAuth(username, password)
+		return p, sourceHeader, err
+	}
+
+	key, source := requestKey(r)
+	if key != "" {
+		var p *Principal
+		var err error
+		if source == sourceHeader && a.oidc != nil && looksLikeJWT(key) {
+			p, err = a.oidc.VerifyBearer(r.Context(), key)
+		} else {
+			p, err = a.lookup(key)
+		}
+		// A stale cookie shouldn't lock a browser out of open routes
+		if !(errors.Is(err, ErrInvalidKey) && source == sourceCookie) {
+			return p, source, err
+		}
+	}
+
+	// Expired or tampered sessions count as logged out, as do sessions of
+	// users no longer allowed or signed in a way that is now disabled
+	p, _ := a.sessions.Principal(r)
+	if p != nil && !a.config.Auth.Allows(p.Name) {
+		return nil, sourceNone, nil
+	}
+	if p != nil && ((p.UserID != 0 && !a.PasswordLogin()) || (p.UserID == 0 && a.oidc == nil)) {
+		return nil, sourceNone, nil
+	}
+
+	// Built-in users are looked up again, so role changes and deletions
+	// take effect without waiting for the session to expire
+	if p != nil && p.UserID != 0 {
+		user, err := a.db.GetUser(p.UserID)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, sourceNone, nil
+		}
+		if err != nil {
+			return nil, sourceNone, err
+		}
+		p = userPrincipal(user)
+	}
+	return p, sourceNone, nil
+}
+
+// lookup resolves a key to its principal
+func (a *Authenticator) lookup(key string) (*Principal, error) {
+	for _, k := range a.static {
+		if subtle.ConstantTimeCompare([]byte(key), k.key) == 1 {
+			return k.principal, nil
+		}
+	}
+
+	stored, err := a.db.GetAPIKeyByHash(HashKey(key))
+	if errors.Is(err, database.ErrAPIKeyNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	a.touch(stored.ID)
+
+	p := &Principal{Name: stored.Name}
+	for _, s := range stored.Scopes {
+		p.Scopes = append(p.Scopes, Scope(s))
+	}
+	return p, nil
+}
+
+// touch records the use of a stored key, at most once per touchInterval
+func (a *Authenticator) touch(id int64) {
+	now := time.Now()
+
+	a.mu.Lock()
+	last := a.touched[id]
+	if now.Sub(last) < touchInterval {
+		a.mu.Unlock()
+		return
+	}
+	a.touched[id] = now
+	a.mu.Unlock()
+
+	if err := a.db.TouchAPIKey(id, now); err != nil {
+		a.log.Error("Error recording api key usage", "err", err)
+	}
+}
+
+// Require wraps a handler so it only runs for requests carrying a key with
+// the given scope. The principal is added to the request context.
+func (a *Authenticator) Require(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return a.handle(scope, true, next)
+}
+
+// Allow wraps a handler that needs the given scope only when the API is
+// protected by auth.mode or auth.require_api_key. Otherwise anonymous requests are let
+// through, while requests with a key still have it checked.
+func (a *Authenticator) Allow(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return a.handle(scope, a.config.Auth.APIProtected(), next)
+}
+
+// Stream wraps the web UI, player and stream handlers, which need the read
+// scope only when they are protected by auth.mode or auth.protect_streams
+func (a *Authenticator) Stream(next http.HandlerFunc) http.HandlerFunc {
+	return a.handle(ScopeRead, a.config.Auth.StreamsProtected(), next)
+}
+
+// handle implements Require, Allow and Stream
+func (a *Authenticator) handle(scope Scope, required bool, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, source, err := a.authenticate(r)
+		if errors.Is(err, ErrInvalidKey) || errors.Is(err, errNoRole) || errors.Is(err, ErrInvalidLogin) || errors.Is(err, ErrNotAllowed) {
+			a.deny(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			deny(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		// Send browsers to the login page instead of showing an error,
+		// unless there is nothing to sign in with there
+		if p == nil && required && wantsHTML(r) && (a.PasswordLogin() || a.oidc != nil) {
+			http.Redirect(w, r, a.config.Server.Path("/login?next="+url.QueryEscape(r.URL.RequestURI())), http.StatusFound)
+			return
+		}
+
+		switch {
+		case p == nil && required:
+			a.deny(w, http.StatusUnauthorized, "an API key or login is required")
+			return
+		case p != nil && !p.Has(scope):
+			deny(w, http.StatusForbidden, fmt.Sprintf("the %s scope is required", scope))
+			return
+		}
+
+		if p != nil && source == sourceQuery {
+			http.SetCookie(w, &http.Cookie{
+				Name:     CookieName,
+				Value:    r.URL.Query().Get("access_token"),
+				Path:     "/",
+				HttpOnly: true,
+				SameSite: http.SameSiteLaxMode,
+				Secure:   r.TLS != nil,
+			})
+		}
+
+		if p != nil {
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+		}
+		next(w, r)
+	}
+}
+
+// deny writes an authentication error, asking browsers for a password in
+// basic mode
+func (a *Authenticator) deny(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized && a.config.Auth.Mode == config.AuthBasic {
+		w.Header().Add("WWW-Authenticate", `Basic realm="streaming", charset="UTF-8"`)
+	}
+	deny(w, status, msg)
+}
+
+// deny writes an authentication error as JSON
+func deny(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Add("WWW-Authenticate", `Bearer realm="streaming"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
+
+// wantsHTML reports whether a request comes from a browser navigating to a page
+func wantsHTML(r *http.Request) bool {
+	return r.Method == http.MethodGet && strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// keySource tells where the key of a request came from
+type keySource int
+
+const (
+	sourceNone keySource = iota
+	sourceHeader
+	sourceQuery
+	sourceCookie
+)
+
+// requestKey returns the API key sent with a request and where it came
+// from. Keys are accepted as a bearer token, in the X-API-Key header, as the
+// access_token query parameter for clients such as browser WebSockets that
+// can't set headers, or from the cookie set after a query parameter login.
+func requestKey(r *http.Request) (string, keySource) {
+	if key, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		return key, sourceHeader
+	}
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key, sourceHeader
+	}
+	if key := r.URL.Query().Get("access_token"); key != "" {
+		return key, sourceQuery
+	}
+	if c, err := r.Cookie(CookieName); err == nil && c.Value != "" {
+		return c.Value, sourceCookie
+	}
+	return "", sourceNone
+}
+
+// Token returns the credential sent with a request, an API key or the
+// session cookie, or "" for anonymous requests
+func Token(r *http.Request) string {
+	if key, _ := requestKey(r); key != "" {
+		return key
+	}
+	if c, err := r.Cookie(SessionCookieName); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// LinkKey returns the API key a request was made with, for links handed to
+// clients that can't send headers or keep cookies, such as podcast apps.
+// It is "" for logins and OIDC tokens, which expire, and for keys only
+// found in a cookie, which may be stale.
+func LinkKey(r *http.Request) string {
+	key, source := requestKey(r)
+	if source == sourceCookie || (source == sourceHeader && looksLikeJWT(key)) {
+		return ""
+	}
+	return key
+}
+
+// GenerateKey creates a new random API key
+func GenerateKey() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate api key: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// HashKey returns the hash API keys are stored by
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
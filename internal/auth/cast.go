@@ -0,0 +1,69 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// castTTL is how long a cast token works, enough for a long film with
+// pauses
+const castTTL = 12 * time.Hour
+
+// ErrInvalidCastToken is returned for cast tokens that are malformed,
+// tampered with, expired or for another video
+var ErrInvalidCastToken = errors.New("invalid cast token")
+
+// castGrant is the payload of a cast token
+type castGrant struct {
+	Name    string  `json:"n,omitempty"`
+	Scopes  []Scope `json:"s,omitempty"`
+	UserID  int64   `json:"u,omitempty"`
+	Dir     string  `json:"d"`
+	Expires int64   `json:"e"`
+}
+
+// CastToken returns a token letting a cast device, which can't send
+// credentials or cookies, stream the files of the cache directory dir on
+// behalf of p, nil for anonymous viewers. The token goes into the URL path
+// so the relative URLs of playlists keep it.
+func (a *Authenticator) CastToken(p *Principal, dir string) (string, error) {
+	g := castGrant{Dir: dir, Expires: time.Now().Add(castTTL).Unix()}
+	if p != nil {
+		g.Name, g.Scopes, g.UserID = p.Name, p.Scopes, p.UserID
+	}
+	payload, err := json.Marshal(g)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode cast token: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	return value + "." + a.sessions.sign("cast|"+value), nil
+}
+
+// CastPrincipal checks a cast token for the cache directory dir and
+// returns the principal it was issued to, nil for anonymous viewers
+func (a *Authenticator) CastPrincipal(token, dir string) (*Principal, error) {
+	value, sig, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(a.sessions.sign("cast|"+value))) {
+		return nil, ErrInvalidCastToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCastToken
+	}
+	var g castGrant
+	if err := json.Unmarshal(payload, &g); err != nil {
+		return nil, ErrInvalidCastToken
+	}
+	if g.Dir != dir || time.Now().Unix() > g.Expires {
+		return nil, ErrInvalidCastToken
+	}
+	if g.Name == "" {
+		return nil, nil
+	}
+	return &Principal{Name: g.Name, Scopes: g.Scopes, UserID: g.UserID}, nil
+}
@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// jellyfinGrant is the payload of the access tokens handed to Jellyfin
+// clients. Guests have no name.
+type jellyfinGrant struct {
+	Name    string  `json:"n,omitempty"`
+	Scopes  []Scope `json:"s,omitempty"`
+	UserID  int64   `json:"u,omitempty"`
+	Expires int64   `json:"e"`
+}
+
+// JellyfinLogin signs in a Jellyfin client with the username and password
+// of a built-in user and returns the principal and the access token the
+// client sends from then on. The token lasts as long as a login session.
+// Without password logins or a username, clients are let in as guests,
+// with a nil principal, as long as streams are open to everyone.
+func (a *Authenticator) JellyfinLogin(username, password string) (*Principal, string, error) {
+	var p *Principal
+	switch {
+	case a.PasswordLogin() && (username != "" || a.config.Auth.StreamsProtected()):
+		var err error
+		if p, err = a.Login(username, password); err != nil {
+			return nil, "", err
+		}
+	case a.config.Auth.StreamsProtected():
+		return nil, "", ErrInvalidLogin
+	}
+
+	g := jellyfinGrant{Expires: time.Now().Add(a.sessions.ttl).Unix()}
+	if p != nil {
+		g.Name, g.Scopes, g.UserID = p.Name, p.Scopes, p.UserID
+	}
+	payload, err := json.Marshal(g)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to encode access token: %w", err)
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	return p, value + "." + a.sessions.sign("jellyfin|"+value), nil
+}
+
+// jellyfinPrincipal resolves the token of a Jellyfin client: an access
+// token issued by JellyfinLogin, or an API key. Built-in users are looked
+// up again, so role changes and deletions take effect right away.
+func (a *Authenticator) jellyfinPrincipal(token string) (*Principal, error) {
+	value, sig, ok := strings.Cut(token, ".")
+	if !ok {
+		return a.lookup(token)
+	}
+	if !hmac.Equal([]byte(sig), []byte(a.sessions.sign("jellyfin|"+value))) {
+		return nil, ErrInvalidKey
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidKey
+	}
+	var g jellyfinGrant
+	if err := json.Unmarshal(payload, &g); err != nil || time.Now().Unix() > g.Expires {
+		return nil, ErrInvalidKey
+	}
+
+	switch {
+	case g.Name == "":
+		// Guests lose access once streams are protected
+		if a.config.Auth.StreamsProtected() {
+			return nil, ErrInvalidKey
+		}
+		return nil, nil
+	case g.UserID == 0:
+		return &Principal{Name: g.Name, Scopes: g.Scopes}, nil
+	}
+
+	user, err := a.db.GetUser(g.UserID)
+	if errors.Is(err, database.ErrUserNotFound) {
+		return nil, ErrInvalidKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !a.PasswordLogin() || !a.config.Auth.Allows(user.Username) {
+		return nil, ErrNotAllowed
+	}
+	return userPrincipal(user), nil
+}
+
+// Jellyfin wraps the handlers of the Jellyfin compatible API, which need
+// the read scope when streams are protected. Jellyfin clients send their
+// token in the Authorization header, as
+// MediaBrowser Client="...", Token="...", in the X-Emby-Token or
+// X-MediaBrowser-Token headers, or as the api_key query parameter for
+// stream URLs.
+func (a *Authenticator) Jellyfin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var p *Principal
+		token := jellyfinToken(r)
+		if token != "" {
+			var err error
+			p, err = a.jellyfinPrincipal(token)
+			if errors.Is(err, ErrInvalidKey) || errors.Is(err, ErrNotAllowed) {
+				deny(w, http.StatusUnauthorized, err.Error())
+				return
+			}
+			if err != nil {
+				deny(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+		}
+
+		switch {
+		case p == nil && a.config.Auth.StreamsProtected():
+			deny(w, http.StatusUnauthorized, "sign in first")
+			return
+		case p != nil && !p.Has(ScopeRead):
+			deny(w, http.StatusForbidden, fmt.Sprintf("the %s scope is required", ScopeRead))
+			return
+		}
+
+		if p != nil {
+			r = r.WithContext(WithPrincipal(r.Context(), p))
+		}
+		next(w, r)
+	}
+}
+
+// jellyfinToken returns the token a Jellyfin client sent, or ""
+func jellyfinToken(r *http.Request) string {
+	for _, name := range []string{"Authorization", "X-Emby-Authorization"} {
+		if token := mediaBrowserParam(r.Header.Get(name), "Token"); token != "" {
+			return token
+		}
+	}
+	for _, name := range []string{"X-Emby-Token", "X-MediaBrowser-Token"} {
+		if token := r.Header.Get(name); token != "" {
+			return token
+		}
+	}
+	query := r.URL.Query()
+	if token := query.Get("api_key"); token != "" {
+		return token
+	}
+	return query.Get("ApiKey")
+}
+
+// mediaBrowserParam returns a parameter of a MediaBrowser or Emby
+// authorization header, such as
+// MediaBrowser Client="Findroid", Device="Pixel", Token="..."
+func mediaBrowserParam(header, name string) string {
+	scheme, params, ok := strings.Cut(header, " ")
+	if !ok || (!strings.EqualFold(scheme, "MediaBrowser") && !strings.EqualFold(scheme, "Emby")) {
+		return ""
+	}
+	for _, param := range strings.Split(params, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if ok && strings.EqualFold(key, name) {
+			return strings.Trim(value, `"`)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,299 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+
+	"github.com/kaero/streaming/config"
+)
+
+// Roles assigned to OIDC users
+const (
+	RoleViewer = "viewer"
+	RoleAdmin  = "admin"
+)
+
+// roleScopes are the scopes granted to each role
+var roleScopes = map[string][]Scope{
+	RoleViewer: {ScopeRead, ScopeWrite},
+	RoleAdmin:  {ScopeAdmin},
+}
+
+// stateCookieName holds the state, nonce and return path of a login in
+// progress
+const stateCookieName = "streaming_oidc_state"
+
+// discoveryTimeout bounds fetching the provider's discovery document
+const discoveryTimeout = 15 * time.Second
+
+// errNoRole is returned for users that don't map to any role
+var errNoRole = errors.New("user has no role for this server")
+
+// OIDC implements OpenID Connect login for the web UI and validation of
+// bearer JWTs issued by the same provider
+type OIDC struct {
+	config      config.OIDCConfig
+	oauth       oauth2.Config
+	verifier    *oidc.IDTokenVerifier
+	apiVerifier *oidc.IDTokenVerifier
+	basePath    string
+	sessions    *Sessions
+	// allows checks auth.allowed_users
+	allows func(user string) bool
+	log    *slog.Logger
+}
+
+// NewOIDC discovers the provider's endpoints and keys. basePath is the
+// prefix of the server's routes, logins are logged to logger.
+func NewOIDC(cfg config.OIDCConfig, basePath string, sessions *Sessions, logger *slog.Logger) (*OIDC, error) {
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, fmt.Errorf("auth.oidc needs client_id and redirect_url")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, cfg.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+	}
+
+	scopes := cfg.Scopes
+	if len(scopes) == 0 {
+		scopes = []string{"profile", "email"}
+	}
+	if !slices.Contains(scopes, oidc.ScopeOpenID) {
+		scopes = append([]string{oidc.ScopeOpenID}, scopes...)
+	}
+
+	audience := cfg.APIAudience
+	if audience == "" {
+		audience = cfg.ClientID
+	}
+
+	return &OIDC{
+		config: cfg,
+		oauth: oauth2.Config{
+			ClientID:     cfg.ClientID,
+			ClientSecret: cfg.ClientSecret,
+			RedirectURL:  cfg.RedirectURL,
+			Endpoint:     provider.Endpoint(),
+			Scopes:       scopes,
+		},
+		verifier:    provider.Verifier(&oidc.Config{ClientID: cfg.ClientID}),
+		apiVerifier: provider.Verifier(&oidc.Config{ClientID: audience}),
+		basePath:    basePath,
+		sessions:    sessions,
+		allows:      func(string) bool { return true },
+		log:         logger,
+	}, nil
+}
+
+// LoginHandler redirects to the provider's login page. The next query
+// parameter names the local page to return to afterwards.
+func (o *OIDC) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	value := state + "|" + nonce + "|" + LocalPath(r.URL.Query().Get("next"))
+	http.SetCookie(w, &http.Cookie{
+		Name:     stateCookieName,
+		Value:    value + "|" + o.sessions.sign(value),
+		Path:     o.basePath + "/auth/",
+		MaxAge:   600,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+
+	http.Redirect(w, r, o.oauth.AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+// CallbackHandler completes a login: it exchanges the authorization code,
+// verifies the ID token, maps the user's roles and starts a session
+func (o *OIDC) CallbackHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := r.Cookie(stateCookieName)
+	if err != nil {
+		http.Error(w, "Login expired, please try again", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: stateCookieName, Path: o.basePath + "/auth/", MaxAge: -1})
+
+	parts := strings.Split(c.Value, "|")
+	if len(parts) != 4 || !hmac.Equal([]byte(o.sessions.sign(strings.Join(parts[:3], "|"))), []byte(parts[3])) {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+	state, nonce, next := parts[0], parts[1], parts[2]
+
+	if errMsg := r.URL.Query().Get("error"); errMsg != "" {
+		http.Error(w, "Login failed: "+errMsg, http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Query().Get("state") != state {
+		http.Error(w, "Invalid login state", http.StatusBadRequest)
+		return
+	}
+
+	token, err := o.oauth.Exchange(r.Context(), r.URL.Query().Get("code"))
+	if err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		http.Error(w, "Login failed: no ID token in response", http.StatusUnauthorized)
+		return
+	}
+	idToken, err := o.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if idToken.Nonce != nonce {
+		http.Error(w, "Login failed: nonce mismatch", http.StatusUnauthorized)
+		return
+	}
+
+	p, err := o.principalFromToken(idToken)
+	if err == nil && !o.allows(p.Name) {
+		err = ErrNotAllowed
+	}
+	if err != nil {
+		http.Error(w, "Login failed: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	if err := o.sessions.Issue(w, r, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	o.log.InfoContext(r.Context(), "User logged in via OIDC", "user", p.Name)
+	http.Redirect(w, r, o.basePath+next, http.StatusFound)
+}
+
+// VerifyBearer validates a JWT sent to the API and maps it to a principal
+func (o *OIDC) VerifyBearer(ctx context.Context, raw string) (*Principal, error) {
+	token, err := o.apiVerifier.Verify(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	p, err := o.principalFromToken(token)
+	if err == nil && !o.allows(p.Name) {
+		return nil, ErrNotAllowed
+	}
+	return p, err
+}
+
+// principalFromToken maps the claims of a verified token to a principal
+func (o *OIDC) principalFromToken(token *oidc.IDToken) (*Principal, error) {
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("failed to parse token claims: %w", err)
+	}
+
+	name := token.Subject
+	for _, claim := range []string{"preferred_username", "email"} {
+		if v, ok := claims[claim].(string); ok && v != "" {
+			name = v
+			break
+		}
+	}
+
+	role := o.mapRole(claimStrings(claims, o.config.RolesClaim))
+	if role == "" {
+		return nil, errNoRole
+	}
+
+	return &Principal{Name: name, Scopes: roleScopes[role]}, nil
+}
+
+// mapRole picks the role for a user's groups, admin taking precedence
+func (o *OIDC) mapRole(groups []string) string {
+	for _, g := range groups {
+		if slices.Contains(o.config.AdminRoles, g) {
+			return RoleAdmin
+		}
+	}
+	if len(o.config.ViewerRoles) == 0 {
+		return RoleViewer
+	}
+	for _, g := range groups {
+		if slices.Contains(o.config.ViewerRoles, g) {
+			return RoleViewer
+		}
+	}
+	return ""
+}
+
+// claimStrings reads a string or string list claim, following dots into
+// nested objects
+func claimStrings(claims map[string]interface{}, path string) []string {
+	var v interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = m[key]
+	}
+
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var out []string
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// looksLikeJWT reports whether a bearer token has the shape of a JWT
+// rather than an API key
+func looksLikeJWT(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// LocalPath returns p if it is a path on this server, "/" otherwise, so
+// a login can't be abused as an open redirect
+func LocalPath(p string) string {
+	if !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") || strings.HasPrefix(p, "/\\") ||
+		strings.Contains(p, "|") {
+		return "/"
+	}
+	return p
+}
+
+// randomString returns a random hex string for state and nonce values
+func randomString() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate random value: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
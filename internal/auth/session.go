@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SessionCookieName is the cookie holding a signed login session
+const SessionCookieName = "streaming_session"
+
+// ErrInvalidSession is returned for session cookies that are malformed,
+// tampered with or expired
+var ErrInvalidSession = errors.New("invalid session")
+
+// session is the payload of a session cookie
+type session struct {
+	Name    string  `json:"n"`
+	Scopes  []Scope `json:"s"`
+	Expires int64   `json:"e"`
+	UserID  int64   `json:"u,omitempty"`
+}
+
+// Sessions issues and verifies login sessions stored in signed cookies, so
+// no server side state is needed to check them
+type Sessions struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+// NewSessions creates a session manager signing cookies with secret. An
+// empty secret is replaced by a random one, which logs everyone out when
+// the server restarts.
+func NewSessions(secret string, ttl time.Duration) (*Sessions, error) {
+	key := []byte(secret)
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate session secret: %w", err)
+		}
+	}
+
+	return &Sessions{secret: key, ttl: ttl}, nil
+}
+
+// Issue sets a session cookie for a principal
+func (s *Sessions) Issue(w http.ResponseWriter, r *http.Request, p *Principal) error {
+	expires := time.Now().Add(s.ttl)
+	payload, err := json.Marshal(session{Name: p.Name, Scopes: p.Scopes, Expires: expires.Unix(), UserID: p.UserID})
+	if err != nil {
+		return fmt.Errorf("failed to encode session: %w", err)
+	}
+
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    value + "." + s.sign(value),
+		Path:     "/",
+		Expires:  expires,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   r.TLS != nil,
+	})
+	return nil
+}
+
+// Clear removes the session cookie
+func (s *Sessions) Clear(w http.ResponseWriter) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     SessionCookieName,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+	})
+}
+
+// Principal returns the principal of the session cookie sent with a
+// request. It returns nil without an error if there is no session.
+func (s *Sessions) Principal(r *http.Request) (*Principal, error) {
+	c, err := r.Cookie(SessionCookieName)
+	if err != nil || c.Value == "" {
+		return nil, nil
+	}
+
+	value, sig, ok := strings.Cut(c.Value, ".")
+	if !ok || !hmac.Equal([]byte(sig), []byte(s.sign(value))) {
+		return nil, ErrInvalidSession
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidSession
+	}
+	var sess session
+	if err := json.Unmarshal(payload, &sess); err != nil {
+		return nil, ErrInvalidSession
+	}
+	if time.Now().Unix() > sess.Expires {
+		return nil, ErrInvalidSession
+	}
+
+	return &Principal{Name: sess.Name, Scopes: sess.Scopes, UserID: sess.UserID}, nil
+}
+
+// sign returns the HMAC of a cookie value
+func (s *Sessions) sign(value string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
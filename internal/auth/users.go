@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// MinPasswordLength is the shortest password accepted for users
+const MinPasswordLength = 8
+
+// ErrInvalidLogin is returned for a wrong username or password
+var ErrInvalidLogin = errors.New("invalid username or password")
+
+// dummyHash is compared against when a user doesn't exist, so unknown
+// usernames can't be told apart by how long the login takes
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// ValidRole reports whether r names a known role
+func ValidRole(r string) bool {
+	_, ok := roleScopes[r]
+	return ok
+}
+
+// HashPassword returns the bcrypt hash a password is stored by
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %w", err)
+	}
+	return string(hash), nil
+}
+
+// Login checks the password of a built-in user signing in
+func (a *Authenticator) Login(username, password string) (*Principal, error) {
+	user, err := a.checkPassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := a.db.TouchUserLogin(user.ID, time.Now()); err != nil {
+		a.log.Error("Error recording login", "user", user.Username, "err", err)
+	}
+	a.log.Info("User logged in", "user", user.Username)
+
+	return userPrincipal(user), nil
+}
+
+// basicAuth checks HTTP Basic credentials of a built-in user. Credentials
+// that passed are trusted for basicAuthTTL, while the user is looked up
+// every time so role changes and deletions apply right away.
+func (a *Authenticator) basicAuth(username, password string) (*Principal, error) {
+	sum := sha256.Sum256([]byte(username + "\x00" + password))
+	key := hex.EncodeToString(sum[:])
+
+	a.mu.Lock()
+	checked, ok := a.basic[key]
+	a.mu.Unlock()
+	if ok && time.Since(checked) < basicAuthTTL {
+		user, err := a.db.GetUserByName(username)
+		if errors.Is(err, database.ErrUserNotFound) {
+			return nil, ErrInvalidLogin
+		}
+		if err != nil {
+			return nil, err
+		}
+		return userPrincipal(user), nil
+	}
+
+	user, err := a.checkPassword(username, password)
+	if err != nil {
+		return nil, err
+	}
+	a.mu.Lock()
+	for k, t := range a.basic {
+		if time.Since(t) >= basicAuthTTL {
+			delete(a.basic, k)
+		}
+	}
+	a.basic[key] = time.Now()
+	a.mu.Unlock()
+	return userPrincipal(user), nil
+}
+
+// checkPassword returns the built-in user with a username and password, if
+// password logins are enabled and the user is allowed to sign in
+func (a *Authenticator) checkPassword(username, password string) (*database.User, error) {
+	if !a.PasswordLogin() {
+		return nil, ErrInvalidLogin
+	}
+
+	user, err := a.db.GetUserByName(username)
+	if errors.Is(err, database.ErrUserNotFound) {
+		bcrypt.CompareHashAndPassword(dummyHash(), []byte(password))
+		return nil, ErrInvalidLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return nil, ErrInvalidLogin
+	}
+	if !a.config.Auth.Allows(user.Username) {
+		return nil, ErrNotAllowed
+	}
+	return user, nil
+}
+
+// StartSession sets the login session cookie for a principal
+func (a *Authenticator) StartSession(w http.ResponseWriter, r *http.Request, p *Principal) error {
+	return a.sessions.Issue(w, r, p)
+}
+
+// userPrincipal returns the principal of a built-in user
+func userPrincipal(u *database.User) *Principal {
+	return &Principal{Name: u.Username, UserID: u.ID, Scopes: roleScopes[u.Role]}
+}
@@ -0,0 +1,72 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// WebDAV wraps the WebDAV share. File managers only send HTTP Basic
+// credentials: the name and password of a built-in user, or any name with
+// an API key as the password. Credentials are always required; browsing
+// needs the read scope and changing files the admin scope.
+func (a *Authenticator) WebDAV(next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		p, err := a.webDAVPrincipal(r)
+		if errors.Is(err, ErrInvalidKey) || errors.Is(err, errNoRole) || errors.Is(err, ErrInvalidLogin) || errors.Is(err, ErrNotAllowed) {
+			denyWebDAV(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		if err != nil {
+			denyWebDAV(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		scope := ScopeAdmin
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+			scope = ScopeRead
+		}
+		switch {
+		case p == nil:
+			denyWebDAV(w, http.StatusUnauthorized, "a username and password are required")
+			return
+		case !p.Has(scope):
+			denyWebDAV(w, http.StatusForbidden, fmt.Sprintf("the %s scope is required", scope))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithPrincipal(r.Context(), p)))
+	}
+}
+
+// webDAVPrincipal resolves the credentials of a WebDAV request. Without
+// Basic credentials API keys and login sessions are accepted as for the
+// other routes.
+func (a *Authenticator) webDAVPrincipal(r *http.Request) (*Principal, error) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		p, _, err := a.authenticate(r)
+		return p, err
+	}
+
+	if a.PasswordLogin() {
+		p, err := a.basicAuth(username, password)
+		if !errors.Is(err, ErrInvalidLogin) {
+			return p, err
+		}
+	}
+	return a.lookup(password)
+}
+
+// denyWebDAV writes an authentication error, asking file managers for a
+// username and password
+func denyWebDAV(w http.ResponseWriter, status int, msg string) {
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="streaming", charset="UTF-8"`)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": msg})
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"os"
+	"sync"
+	"time"
+)
+
+// HotCache keeps the content of frequently served stream files in memory,
+// so that players polling playlists every few seconds don't read them from
+// disk each time. The least recently served files are dropped to stay
+// within the size limit.
+type HotCache struct {
+	limit int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *hotEntry, most recently served first
+	entries map[string]*list.Element
+	hits    int64
+	misses  int64
+}
+
+// hotEntry is a cached file, with the modification time and size it had
+// when it was read
+type hotEntry struct {
+	name    string
+	content []byte
+	modTime time.Time
+}
+
+// HotCacheStats describes the content and effectiveness of a HotCache
+type HotCacheStats struct {
+	Files      int   `json:"files"`
+	Bytes      int64 `json:"bytes"`
+	LimitBytes int64 `json:"limit_bytes"`
+	Hits       int64 `json:"hits"`
+	Misses     int64 `json:"misses"`
+}
+
+// NewHotCache creates a cache holding up to limit bytes
+func NewHotCache(limit int64) *HotCache {
+	return &HotCache{
+		limit:   limit,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// Get returns the content of a file relative to the cache root, if it is
+// cached and unchanged since, going by info of the file on disk
+func (c *HotCache) Get(name string, info os.FileInfo) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[name]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	entry := elem.Value.(*hotEntry)
+	if !entry.modTime.Equal(info.ModTime()) || int64(len(entry.content)) != info.Size() {
+		// Reprocessing replaced the file
+		c.remove(elem)
+		c.misses++
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	c.hits++
+	return entry.content, true
+}
+
+// Put caches the content of a file, dropping the least recently served
+// files to make room. Files larger than a quarter of the limit aren't
+// cached, so a single file can't push out everything else.
+func (c *HotCache) Put(name string, info os.FileInfo, content []byte) {
+	size := int64(len(content))
+	if size != info.Size() || size > c.limit/4 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[name]; ok {
+		c.remove(elem)
+	}
+	for c.size+size > c.limit && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+	c.entries[name] = c.order.PushFront(&hotEntry{name: name, content: content, modTime: info.ModTime()})
+	c.size += size
+}
+
+// remove drops a cached file, the caller holds mu
+func (c *HotCache) remove(elem *list.Element) {
+	entry := c.order.Remove(elem).(*hotEntry)
+	delete(c.entries, entry.name)
+	c.size -= int64(len(entry.content))
+}
+
+// Stats returns the number and size of the cached files and how many
+// requests were served from memory
+func (c *HotCache) Stats() HotCacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return HotCacheStats{
+		Files:      c.order.Len(),
+		Bytes:      c.size,
+		LimitBytes: c.limit,
+		Hits:       c.hits,
+		Misses:     c.misses,
+	}
+}
@@ -0,0 +1,95 @@
+package cache
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// AccessTracker records which cached files are being served and flushes the
+// access times to the cache inventory in batches, so serving a segment
+// doesn't cost a database write
+type AccessTracker struct {
+	db       *database.DB
+	interval time.Duration
+	log      *slog.Logger
+
+	mu      sync.Mutex
+	pending map[string]time.Time
+	stopCh  chan struct{}
+	doneCh  chan struct{}
+}
+
+// NewAccessTracker creates a tracker that flushes every interval, logging
+// failed flushes to logger
+func NewAccessTracker(db *database.DB, interval time.Duration, logger *slog.Logger) *AccessTracker {
+	return &AccessTracker{
+		db:       db,
+		interval: interval,
+		log:      logger,
+		pending:  make(map[string]time.Time),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Touch records an access to a cached file, relative to the cache root
+func (t *AccessTracker) Touch(file string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.pending[file] = time.Now()
+}
+
+// Start starts flushing recorded accesses in the background
+func (t *AccessTracker) Start() {
+	go func() {
+		defer close(t.doneCh)
+
+		ticker := time.NewTicker(t.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				t.Flush()
+			case <-t.stopCh:
+				t.Flush()
+				return
+			}
+		}
+	}()
+}
+
+// Stop stops the background flushing after a final flush
+func (t *AccessTracker) Stop() {
+	close(t.stopCh)
+	<-t.doneCh
+}
+
+// Flush writes all recorded accesses to the database
+func (t *AccessTracker) Flush() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[string]time.Time)
+	t.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	// Accesses within one interval are close enough to share a timestamp
+	var latest time.Time
+	files := make([]string, 0, len(pending))
+	for file, at := range pending {
+		files = append(files, file)
+		if at.After(latest) {
+			latest = at
+		}
+	}
+
+	if err := t.db.TouchCacheEntries(files, latest); err != nil {
+		t.log.Error("Error recording cache access", "err", err)
+	}
+}
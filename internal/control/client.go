@@ -0,0 +1,158 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/library"
+)
+
+// clientTimeout limits how long a control request may take. Every action
+// only queues or signals work, so they answer right away.
+const clientTimeout = 10 * time.Second
+
+// ErrDisabled is returned by a Client without address, when the control
+// API of the librarian is disabled
+var ErrDisabled = errors.New("the librarian control API is disabled, set library.control_addr")
+
+// Client calls the control API of a librarian running in another process,
+// so the streaming server can have the librarian scan, process, cancel and
+// pause on behalf of the web UI
+type Client struct {
+	// base is the URL the control paths are appended to, "" when disabled
+	base string
+	http *http.Client
+}
+
+// NewClient creates a client of the control API listening on addr, a
+// host:port or "unix:" followed by a socket path. With an empty addr every
+// call returns ErrDisabled.
+func NewClient(addr string) *Client {
+	c := &Client{http: &http.Client{Timeout: clientTimeout}}
+	if addr == "" {
+		return c
+	}
+
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		c.base = "http://" + addr
+		return c
+	}
+
+	// The host is ignored, every request goes through the socket
+	c.base = "http://librarian"
+	c.http.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+	return c
+}
+
+// Scan queues a library scan followed by processing, reporting false when
+// one is already waiting to run
+func (c *Client) Scan(ctx context.Context) (bool, error) {
+	var reply StatusJSON
+	err := c.do(ctx, http.MethodPost, "/control/scan", &reply)
+	return reply.Queued, err
+}
+
+// Process queues processing of the pending videos, reporting false when it
+// is already waiting to run
+func (c *Client) Process(ctx context.Context) (bool, error) {
+	var reply StatusJSON
+	err := c.do(ctx, http.MethodPost, "/control/process", &reply)
+	return reply.Queued, err
+}
+
+// Status reports the running and queued work of the librarian
+func (c *Client) Status(ctx context.Context) (*JobsJSON, error) {
+	var reply JobsJSON
+	if err := c.do(ctx, http.MethodGet, "/control/status", &reply); err != nil {
+		return nil, err
+	}
+	return &reply, nil
+}
+
+// Cancel stops the processing job of a video, returning
+// library.ErrNotProcessing when the librarian isn't processing it
+func (c *Client) Cancel(ctx context.Context, videoID int64, actor string) error {
+	err := c.do(ctx, http.MethodPost, "/control/cancel/"+strconv.FormatInt(videoID, 10)+actorQuery(actor), nil)
+	if se, ok := err.(*statusError); ok && se.code == http.StatusNotFound {
+		return library.ErrNotProcessing
+	}
+	return err
+}
+
+// Pause keeps the librarian from starting new processing jobs
+func (c *Client) Pause(ctx context.Context, actor string) error {
+	return c.do(ctx, http.MethodPost, "/control/pause"+actorQuery(actor), nil)
+}
+
+// Resume lets the librarian start new processing jobs again
+func (c *Client) Resume(ctx context.Context, actor string) error {
+	return c.do(ctx, http.MethodPost, "/control/resume"+actorQuery(actor), nil)
+}
+
+// actorQuery passes the actor recorded in the librarian's audit log
+func actorQuery(actor string) string {
+	if actor == "" {
+		return ""
+	}
+	return "?actor=" + url.QueryEscape(actor)
+}
+
+// statusError is an error reply of the librarian
+type statusError struct {
+	code int
+	msg  string
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("librarian replied %d %s: %s", e.code, http.StatusText(e.code), e.msg)
+}
+
+// do sends a control request and decodes the JSON reply into reply, if not
+// nil. Error replies are returned as a statusError with the message of the
+// librarian.
+func (c *Client) do(ctx context.Context, method, path string, reply any) error {
+	if c.base == "" {
+		return ErrDisabled
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach the librarian: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var e ErrorJSON
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(body, &e) != nil || e.Error == "" {
+			e.Error = strings.TrimSpace(string(body))
+		}
+		return &statusError{code: resp.StatusCode, msg: e.Error}
+	}
+	if reply == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(reply); err != nil {
+		return fmt.Errorf("invalid reply from the librarian: %w", err)
+	}
+	return nil
+}
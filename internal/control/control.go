@@ -0,0 +1,419 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+)
+
+// unixPrefix marks a control address as a unix socket path
+const unixPrefix = "unix:"
+
+// Server is the control API of the librarian. It lets the streaming
+// server, cron jobs and download hooks trigger scans and processing without
+// waiting for the periodic scan. Requests only queue the work; a single
+// worker runs it, so repeated triggers while one is pending are merged.
+// Configuration reloads, cancels and pauses are run right away.
+type Server struct {
+	library *library.Manager
+	reload  ReloadFunc
+	server  *http.Server
+	log     *slog.Logger
+
+	scanCh    chan struct{}
+	processCh chan struct{}
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+
+	// runningMu guards running, the action the worker runs
+	runningMu sync.Mutex
+	running   string
+}
+
+// StatusJSON is the reply to a control request
+type StatusJSON struct {
+	Action string `json:"action"`
+	// Queued is false when the same action was already waiting to run
+	Queued bool `json:"queued"`
+}
+
+// JobsJSON is the state of the librarian's work
+type JobsJSON struct {
+	// Paused is true while processing doesn't start new jobs
+	Paused bool `json:"paused"`
+	// Running is the action the worker runs, "" when idle
+	Running string `json:"running"`
+	// Queued are the actions waiting to run
+	Queued []string `json:"queued"`
+	// Jobs are the IDs of the videos being processed
+	Jobs []int64 `json:"jobs"`
+}
+
+// ErrorJSON is the reply to a failed control request
+type ErrorJSON struct {
+	Error string `json:"error"`
+}
+
+// Jobs runs the work of the librarian on behalf of the web UI and the API:
+// the Server itself when running standalone, or a Client of the control
+// API of a separate librarian
+type Jobs interface {
+	// Scan queues a library scan followed by processing, reporting false
+	// when one is already waiting to run
+	Scan(ctx context.Context) (bool, error)
+	// Process queues processing of the pending videos, reporting false
+	// when it is already waiting to run
+	Process(ctx context.Context) (bool, error)
+	// Status reports the running and queued work
+	Status(ctx context.Context) (*JobsJSON, error)
+	// Cancel stops the processing job of a video, returning
+	// library.ErrNotProcessing when there is none
+	Cancel(ctx context.Context, videoID int64, actor string) error
+	// Pause keeps processing from starting new jobs
+	Pause(ctx context.Context, actor string) error
+	// Resume lets processing start new jobs again and processes the
+	// videos left pending meanwhile
+	Resume(ctx context.Context, actor string) error
+}
+
+// ReloadFunc re-reads the configuration and applies it, returning the
+// changed settings that were applied and those that need a restart
+type ReloadFunc func() (applied, restart []string, err error)
+
+// ReloadJSON is the reply to a reload request
+type ReloadJSON struct {
+	Applied []string `json:"applied"`
+	Restart []string `json:"restart"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// NewServer creates a control API for a library, reloading the
+// configuration with reload and logging to logger
+func NewServer(lm *library.Manager, reload ReloadFunc, logger *slog.Logger) *Server {
+	s := &Server{
+		library:   lm,
+		reload:    reload,
+		log:       logger,
+		scanCh:    make(chan struct{}, 1),
+		processCh: make(chan struct{}, 1),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /control/scan", s.ScanHandler)
+	mux.HandleFunc("POST /control/process", s.ProcessHandler)
+	mux.HandleFunc("POST /control/reload", s.ReloadHandler)
+	mux.HandleFunc("GET /control/status", s.StatusHandler)
+	mux.HandleFunc("POST /control/cancel/{id}", s.CancelHandler)
+	mux.HandleFunc("POST /control/pause", s.PauseHandler)
+	mux.HandleFunc("POST /control/resume", s.ResumeHandler)
+	s.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	return s
+}
+
+// Start listens on addr, a host:port or "unix:" followed by a socket path,
+// and starts the worker running queued actions. An empty addr only starts
+// the worker, for queueing actions within the process.
+func (s *Server) Start(addr string) error {
+	if addr == "" {
+		go s.run()
+		return nil
+	}
+
+	ln, err := listen(addr)
+	if err != nil {
+		return err
+	}
+	s.StartListener(ln)
+	return nil
+}
+
+// StartListener serves the control API on an open listener, such as a
+// socket passed by systemd, and starts the worker
+func (s *Server) StartListener(ln net.Listener) {
+	go s.run()
+	go func() {
+		if err := s.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+			s.log.Error("Control API stopped", "err", err)
+		}
+	}()
+
+	s.log.Info("Control API listening", "addr", ln.Addr().String())
+}
+
+// Stop closes the listener and the library manager, which cancels the
+// running jobs, and waits for the worker to finish. Queued actions are
+// dropped.
+func (s *Server) Stop() {
+	s.server.Close()
+	close(s.stopCh)
+	s.library.Close()
+	<-s.doneCh
+}
+
+// listen opens a TCP or unix socket listener. Stale sockets left behind by
+// a crash are replaced, and new sockets are only accessible by the owner
+// and group.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixPrefix)
+	if !ok {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on %s: %w", addr, err)
+		}
+		return ln, nil
+	}
+
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to remove stale socket %s: %w", path, err)
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0660); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return ln, nil
+}
+
+// ScanHandler queues a library scan followed by processing of the
+// pending videos
+func (s *Server) ScanHandler(w http.ResponseWriter, r *http.Request) {
+	s.queue(w, "scan", s.scanCh)
+}
+
+// ProcessHandler queues processing of the pending videos without scanning
+func (s *Server) ProcessHandler(w http.ResponseWriter, r *http.Request) {
+	s.queue(w, "process", s.processCh)
+}
+
+// ReloadHandler reloads the configuration and replies with the settings
+// that changed. Invalid configurations are rejected with 422 and leave the
+// current settings in place.
+func (s *Server) ReloadHandler(w http.ResponseWriter, r *http.Request) {
+	s.log.Info("Control API: reload requested")
+	applied, restart, err := s.reload()
+
+	reply := ReloadJSON{Applied: applied, Restart: restart}
+	if reply.Applied == nil {
+		reply.Applied = []string{}
+	}
+	if reply.Restart == nil {
+		reply.Restart = []string{}
+	}
+	status := http.StatusOK
+	if err != nil {
+		s.log.Error("Error reloading configuration, keeping the current one", "err", err)
+		reply.Error = err.Error()
+		status = http.StatusUnprocessableEntity
+	}
+
+	writeJSON(w, status, reply)
+}
+
+// StatusHandler reports the running and queued work
+func (s *Server) StatusHandler(w http.ResponseWriter, r *http.Request) {
+	status, _ := s.Status(r.Context())
+	writeJSON(w, http.StatusOK, status)
+}
+
+// CancelHandler stops the processing job of the video with the ID in the
+// path, replying 404 when there is none. The actor query parameter is
+// recorded in the audit log.
+func (s *Server) CancelHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorJSON{Error: "invalid video ID"})
+		return
+	}
+
+	if err := s.Cancel(r.Context(), id, actor(r)); err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, library.ErrNotProcessing) {
+			status = http.StatusNotFound
+		}
+		writeJSON(w, status, ErrorJSON{Error: err.Error()})
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PauseHandler pauses processing and replies with the new state
+func (s *Server) PauseHandler(w http.ResponseWriter, r *http.Request) {
+	s.Pause(r.Context(), actor(r))
+	s.StatusHandler(w, r)
+}
+
+// ResumeHandler resumes processing and replies with the new state
+func (s *Server) ResumeHandler(w http.ResponseWriter, r *http.Request) {
+	s.Resume(r.Context(), actor(r))
+	s.StatusHandler(w, r)
+}
+
+// actor returns who a request acts for, as given by the actor query
+// parameter, defaulting to the librarian
+func actor(r *http.Request) string {
+	if a := r.URL.Query().Get("actor"); a != "" {
+		return a
+	}
+	return database.ActorLibrarian
+}
+
+// writeJSON replies with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Scan queues a library scan like RequestScan, for the Jobs interface
+func (s *Server) Scan(ctx context.Context) (bool, error) {
+	return s.RequestScan(), nil
+}
+
+// Process queues processing like RequestProcess, for the Jobs interface
+func (s *Server) Process(ctx context.Context) (bool, error) {
+	return s.RequestProcess(), nil
+}
+
+// Status reports the running and queued work
+func (s *Server) Status(ctx context.Context) (*JobsJSON, error) {
+	s.runningMu.Lock()
+	running := s.running
+	s.runningMu.Unlock()
+
+	status := &JobsJSON{
+		Paused:  s.library.Paused(),
+		Running: running,
+		Queued:  []string{},
+		Jobs:    s.library.ActiveJobs(),
+	}
+	if len(s.scanCh) > 0 {
+		status.Queued = append(status.Queued, "scan")
+	}
+	if len(s.processCh) > 0 {
+		status.Queued = append(status.Queued, "process")
+	}
+	slices.Sort(status.Jobs)
+	return status, nil
+}
+
+// Cancel stops the processing job of a video
+func (s *Server) Cancel(ctx context.Context, videoID int64, actor string) error {
+	return s.library.CancelProcessing(videoID, actor)
+}
+
+// Pause keeps processing from starting new jobs. Running jobs finish.
+func (s *Server) Pause(ctx context.Context, actor string) error {
+	s.library.Pause(actor)
+	return nil
+}
+
+// Resume lets processing start new jobs again and queues processing of
+// the videos left pending while paused
+func (s *Server) Resume(ctx context.Context, actor string) error {
+	s.library.Resume(actor)
+	s.RequestProcess()
+	return nil
+}
+
+// RequestScan queues a library scan followed by processing, reporting
+// false when one is already waiting to run
+func (s *Server) RequestScan() bool {
+	return request(s.scanCh)
+}
+
+// RequestProcess queues processing of the pending videos, reporting false
+// when it is already waiting to run
+func (s *Server) RequestProcess() bool {
+	return request(s.processCh)
+}
+
+// request signals the worker unless the action is already pending
+func request(ch chan struct{}) bool {
+	select {
+	case ch <- struct{}{}:
+		return true
+	default:
+		// Already pending, the queued run picks up the new files too
+		return false
+	}
+}
+
+// queue signals the worker and replies with 202 Accepted
+func (s *Server) queue(w http.ResponseWriter, action string, ch chan struct{}) {
+	queued := request(ch)
+	if queued {
+		s.log.Info("Control API: action requested", "action", action)
+	}
+
+	writeJSON(w, http.StatusAccepted, StatusJSON{Action: action, Queued: queued})
+}
+
+// run executes queued actions one at a time until Stop is called
+func (s *Server) run() {
+	defer close(s.doneCh)
+	for {
+		// Drop the queued actions once stopping
+		select {
+		case <-s.stopCh:
+			return
+		default:
+		}
+
+		select {
+		case <-s.scanCh:
+			s.setRunning("scan")
+			if err := s.library.ScanLibrary(); err != nil {
+				s.log.Error("Error scanning library", "err", err)
+			}
+			// A scan processes what it found, covering a pending process request
+			select {
+			case <-s.processCh:
+			default:
+			}
+			s.setRunning("process")
+			s.process()
+			s.setRunning("")
+
+		case <-s.processCh:
+			s.setRunning("process")
+			s.process()
+			s.setRunning("")
+
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// process processes the pending videos, logging any failure
+func (s *Server) process() {
+	if err := s.library.ProcessPendingVideos(); err != nil {
+		s.log.Error("Error processing pending videos", "err", err)
+	}
+}
+
+// setRunning records the action the worker runs
+func (s *Server) setRunning(action string) {
+	s.runningMu.Lock()
+	s.running = action
+	s.runningMu.Unlock()
+}
@@ -0,0 +1,148 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrAPIKeyNotFound is returned when no API key matches a lookup
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+// APIKey is an API key stored in the database. Only a hash of the key is
+// kept, so a key can't be recovered after it was created.
+type APIKey struct {
+	ID         int64
+	Name       string
+	KeyHash    string
+	Scopes     []string
+	CreatedAt  time.Time
+	LastUsedAt sql.NullTime
+}
+
+// initAPIKeysSchema creates the API keys table
+func (d *DB) initAPIKeysSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS api_keys (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name TEXT NOT NULL UNIQUE,
+			key_hash TEXT NOT NULL UNIQUE,
+			scopes TEXT NOT NULL DEFAULT '',
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_used_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create api_keys table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateAPIKey stores a new API key by the hash of its secret
+func (d *DB) CreateAPIKey(name, keyHash string, scopes []string) (*APIKey, error) {
+	result, err := d.exec(
+		"INSERT INTO api_keys (name, key_hash, scopes) VALUES (?, ?, ?)",
+		name, keyHash, strings.Join(scopes, ","),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create api key: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	return d.getAPIKey("id = ?", id)
+}
+
+// GetAPIKeyByHash retrieves the API key with the given secret hash
+func (d *DB) GetAPIKeyByHash(keyHash string) (*APIKey, error) {
+	return d.getAPIKey("key_hash = ?", keyHash)
+}
+
+// ListAPIKeys retrieves all stored API keys ordered by name
+func (d *DB) ListAPIKeys() ([]*APIKey, error) {
+	rows, err := d.query(`
+		SELECT id, name, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan api key row: %w", err)
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating api key rows: %w", err)
+	}
+
+	return keys, nil
+}
+
+// DeleteAPIKey revokes an API key
+func (d *DB) DeleteAPIKey(id int64) error {
+	result, err := d.exec("DELETE FROM api_keys WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete api key: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrAPIKeyNotFound
+	}
+
+	return nil
+}
+
+// TouchAPIKey records that an API key was used
+func (d *DB) TouchAPIKey(id int64, at time.Time) error {
+	_, err := d.exec("UPDATE api_keys SET last_used_at = ? WHERE id = ?", at.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update api key usage: %w", err)
+	}
+
+	return nil
+}
+
+// getAPIKey retrieves a single API key matching a condition
+func (d *DB) getAPIKey(cond string, arg interface{}) (*APIKey, error) {
+	row := d.queryRow(`
+		SELECT id, name, key_hash, scopes, created_at, last_used_at
+		FROM api_keys
+		WHERE `+cond, arg)
+
+	key, err := scanAPIKey(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get api key: %w", err)
+	}
+
+	return key, nil
+}
+
+// scanAPIKey scans an api_keys row
+func scanAPIKey(row rowScanner) (*APIKey, error) {
+	var key APIKey
+	var scopes string
+	if err := row.Scan(&key.ID, &key.Name, &key.KeyHash, &scopes, &key.CreatedAt, &key.LastUsedAt); err != nil {
+		return nil, err
+	}
+	if scopes != "" {
+		key.Scopes = strings.Split(scopes, ",")
+	}
+	return &key, nil
+}
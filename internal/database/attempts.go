@@ -0,0 +1,130 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Attempt represents a single processing attempt for a video
+type Attempt struct {
+	ID           int64
+	VideoID      int64
+	StartedAt    time.Time
+	FinishedAt   sql.NullTime
+	DurationMs   int64
+	Command      string
+	StderrTail   string
+	Success      bool
+	ErrorMessage sql.NullString
+}
+
+// initAttemptsSchema creates the processing attempts table
+func (d *DB) initAttemptsSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS processing_attempts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			finished_at TIMESTAMP,
+			duration_ms INTEGER NOT NULL DEFAULT 0,
+			command TEXT NOT NULL DEFAULT '',
+			stderr_tail TEXT NOT NULL DEFAULT '',
+			success INTEGER NOT NULL DEFAULT 0,
+			error_message TEXT
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create processing_attempts table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_processing_attempts_video_id
+		ON processing_attempts(video_id, started_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create processing_attempts index: %w", err)
+	}
+
+	return nil
+}
+
+// StartAttempt records the start of a processing attempt and returns its ID
+func (d *DB) StartAttempt(videoID int64) (int64, error) {
+	result, err := d.exec(
+		"INSERT INTO processing_attempts (video_id, started_at) VALUES (?, ?)",
+		videoID, time.Now().UTC(),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("failed to start attempt: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	return id, nil
+}
+
+// FinishAttempt records the outcome of a processing attempt. A non-empty
+// errorMsg marks the attempt as failed.
+func (d *DB) FinishAttempt(id int64, command, stderrTail, errorMsg string) error {
+	var startedAt time.Time
+	err := d.queryRow(
+		"SELECT started_at FROM processing_attempts WHERE id = ?", id,
+	).Scan(&startedAt)
+	if err != nil {
+		return fmt.Errorf("failed to get attempt: %w", err)
+	}
+
+	finishedAt := time.Now().UTC()
+	_, err = d.exec(`
+		UPDATE processing_attempts
+		SET finished_at = ?, duration_ms = ?, command = ?, stderr_tail = ?,
+		    success = ?, error_message = ?
+		WHERE id = ?
+	`,
+		finishedAt, finishedAt.Sub(startedAt).Milliseconds(), command, stderrTail,
+		errorMsg == "", sql.NullString{String: errorMsg, Valid: errorMsg != ""}, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to finish attempt: %w", err)
+	}
+
+	return nil
+}
+
+// ListAttempts retrieves the processing history of a video, newest first
+func (d *DB) ListAttempts(videoID int64) ([]*Attempt, error) {
+	rows, err := d.query(`
+		SELECT id, video_id, started_at, finished_at, duration_ms, command,
+		       stderr_tail, success, error_message
+		FROM processing_attempts
+		WHERE video_id = ?
+		ORDER BY started_at DESC, id DESC
+	`, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list attempts: %w", err)
+	}
+	defer rows.Close()
+
+	var attempts []*Attempt
+	for rows.Next() {
+		var a Attempt
+		err := rows.Scan(
+			&a.ID, &a.VideoID, &a.StartedAt, &a.FinishedAt, &a.DurationMs,
+			&a.Command, &a.StderrTail, &a.Success, &a.ErrorMessage,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan attempt row: %w", err)
+		}
+		attempts = append(attempts, &a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating attempt rows: %w", err)
+	}
+
+	return attempts, nil
+}
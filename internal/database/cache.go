@@ -0,0 +1,294 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CacheEntry is a transcoded variant of a video stored in the cache
+type CacheEntry struct {
+	ID             int64
+	VideoID        int64
+	Variant        string
+	Playlist       string // relative to the cache root
+	SizeBytes      int64
+	Checksum       string // of the segments, empty for older entries
+	CreatedAt      time.Time
+	LastAccessedAt sql.NullTime
+}
+
+// initCacheSchema creates the cache inventory table
+func (d *DB) initCacheSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS cache_entries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			variant TEXT NOT NULL,
+			playlist TEXT NOT NULL,
+			size_bytes INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			last_accessed_at TIMESTAMP,
+			UNIQUE (video_id, variant)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create cache_entries table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_cache_entries_last_accessed_at
+		ON cache_entries(last_accessed_at)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create cache_entries index: %w", err)
+	}
+
+	return nil
+}
+
+// UpsertCacheEntry records a cached variant of a video, replacing any
+// previous entry for the same variant
+func (d *DB) UpsertCacheEntry(videoID int64, variant, playlist string, sizeBytes int64, checksum string) error {
+	_, err := d.exec(`
+		INSERT INTO cache_entries (video_id, variant, playlist, size_bytes, checksum, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(video_id, variant) DO UPDATE
+		SET playlist = excluded.playlist, size_bytes = excluded.size_bytes,
+		    checksum = excluded.checksum, created_at = excluded.created_at,
+		    last_accessed_at = NULL
+	`, videoID, variant, playlist, sizeBytes, checksum, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to record cache entry: %w", err)
+	}
+
+	return nil
+}
+
+// ListCacheEntries retrieves the cached variants of a video
+func (d *DB) ListCacheEntries(videoID int64) ([]*CacheEntry, error) {
+	rows, err := d.query(`
+		SELECT id, video_id, variant, playlist, size_bytes, checksum, created_at,
+		       last_accessed_at
+		FROM cache_entries
+		WHERE video_id = ?
+		ORDER BY variant
+	`, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cache entries: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []*CacheEntry
+	for rows.Next() {
+		var e CacheEntry
+		err := rows.Scan(
+			&e.ID, &e.VideoID, &e.Variant, &e.Playlist, &e.SizeBytes,
+			&e.Checksum, &e.CreatedAt, &e.LastAccessedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cache entry row: %w", err)
+		}
+		entries = append(entries, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cache entry rows: %w", err)
+	}
+
+	return entries, nil
+}
+
+// DeleteCacheEntries removes the cache inventory of a video
+func (d *DB) DeleteCacheEntries(videoID int64) error {
+	if _, err := d.exec("DELETE FROM cache_entries WHERE video_id = ?", videoID); err != nil {
+		return fmt.Errorf("failed to delete cache entries: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteCacheEntriesForDir removes the cache inventory of the video whose
+// cache lives in dir, relative to the cache root
+func (d *DB) DeleteCacheEntriesForDir(dir string) error {
+	_, err := d.exec(`
+		DELETE FROM cache_entries
+		WHERE video_id IN (SELECT id FROM videos WHERE cache_dir = ?)
+	`, dir)
+	if err != nil {
+		return fmt.Errorf("failed to delete cache entries: %w", err)
+	}
+
+	return nil
+}
+
+// CachedVideoIDs returns the IDs of the videos with a cache inventory,
+// those whose output wasn't evicted or purged since it was transcoded
+func (d *DB) CachedVideoIDs() (map[int64]bool, error) {
+	rows, err := d.query("SELECT DISTINCT video_id FROM cache_entries")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list cached videos: %w", err)
+	}
+	defer rows.Close()
+
+	ids := make(map[int64]bool)
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan cached video: %w", err)
+		}
+		ids[id] = true
+	}
+	return ids, rows.Err()
+}
+
+// TouchCacheEntries marks the variants serving the given cache files as
+// accessed at the given time. Files are paths relative to the cache root,
+// such as segments or variant playlists.
+func (d *DB) TouchCacheEntries(files []string, at time.Time) error {
+	if len(files) == 0 {
+		return nil
+	}
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin touch transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A variant's files all share its playlist name without the extension
+	// as a prefix, e.g. movie/movie.mp4_720.m3u8 and movie/movie.mp4_720003.ts
+	stmt, err := tx.Prepare(`
+		UPDATE cache_entries SET last_accessed_at = ?
+		WHERE substr(?, 1, length(playlist) - 5) = substr(playlist, 1, length(playlist) - 5)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to prepare touch statement: %w", err)
+	}
+	defer stmt.Close()
+
+	for _, file := range files {
+		if _, err := stmt.Exec(at.UTC(), strings.TrimPrefix(file, "/")); err != nil {
+			return fmt.Errorf("failed to touch cache entry: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit touch: %w", err)
+	}
+
+	return nil
+}
+
+// CacheDirLastAccess returns the most recent access time of each video's
+// cache directory, keyed by the directory relative to the cache root.
+// Directories whose variants were never accessed report their creation time.
+func (d *DB) CacheDirLastAccess() (map[string]time.Time, error) {
+	rows, err := d.query(`
+		SELECT v.cache_dir, MAX(COALESCE(c.last_accessed_at, c.created_at))
+		FROM cache_entries c
+		JOIN videos v ON v.id = c.video_id
+		WHERE v.cache_dir != ''
+		GROUP BY v.cache_dir
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache access times: %w", err)
+	}
+	defer rows.Close()
+
+	access := make(map[string]time.Time)
+	for rows.Next() {
+		var dir string
+		var last string
+		if err := rows.Scan(&dir, &last); err != nil {
+			return nil, fmt.Errorf("failed to scan cache access row: %w", err)
+		}
+		t, err := parseTimestamp(last)
+		if err != nil {
+			return nil, err
+		}
+		access[dir] = t
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cache access rows: %w", err)
+	}
+
+	return access, nil
+}
+
+// SetPinned pins or unpins a video. The output of pinned videos is never
+// evicted from the cache.
+func (d *DB) SetPinned(videoID int64, pinned bool) error {
+	if _, err := d.exec("UPDATE videos SET pinned = ? WHERE id = ?", pinned, videoID); err != nil {
+		return fmt.Errorf("failed to set pinned: %w", err)
+	}
+	return nil
+}
+
+// SetDroppedVariants records the variants the last transcode of a video
+// dropped to fit the per-video cache quota
+func (d *DB) SetDroppedVariants(videoID int64, variants []string) error {
+	_, err := d.exec("UPDATE videos SET dropped_variants = ? WHERE id = ?", strings.Join(variants, ","), videoID)
+	if err != nil {
+		return fmt.Errorf("failed to set dropped variants: %w", err)
+	}
+	return nil
+}
+
+// CacheDirLastStreamed returns when each video's cache directory was last
+// streamed, keyed by the directory relative to the cache root. Directories
+// never streamed are left out.
+func (d *DB) CacheDirLastStreamed() (map[string]time.Time, error) {
+	rows, err := d.query(`
+		SELECT v.cache_dir, MAX(c.last_accessed_at)
+		FROM cache_entries c
+		JOIN videos v ON v.id = c.video_id
+		WHERE v.cache_dir != '' AND c.last_accessed_at IS NOT NULL
+		GROUP BY v.cache_dir
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get cache stream times: %w", err)
+	}
+	defer rows.Close()
+
+	streamed := make(map[string]time.Time)
+	for rows.Next() {
+		var dir string
+		var last string
+		if err := rows.Scan(&dir, &last); err != nil {
+			return nil, fmt.Errorf("failed to scan cache stream row: %w", err)
+		}
+		t, err := parseTimestamp(last)
+		if err != nil {
+			return nil, err
+		}
+		streamed[dir] = t
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating cache stream rows: %w", err)
+	}
+
+	return streamed, nil
+}
+
+// timestampFormats are the formats SQLite timestamps can come back in. Times
+// bound from Go are stored in the first, CURRENT_TIMESTAMP uses the last.
+var timestampFormats = []string{
+	"2006-01-02 15:04:05.999999999-07:00",
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+}
+
+// parseTimestamp parses a timestamp returned by an expression, which the
+// SQLite driver doesn't convert to time.Time like it does for columns
+func parseTimestamp(s string) (time.Time, error) {
+	for _, layout := range timestampFormats {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid timestamp %q", s)
+}
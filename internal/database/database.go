@@ -1,9 +1,11 @@
 package database
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"path/filepath"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -20,6 +22,19 @@ const (
 	StatusError      VideoStatus = "error"
 )
 
+// FailureClass tells whether a processing failure is worth retrying
+type FailureClass string
+
+// Failure class constants
+const (
+	FailureNone      FailureClass = ""
+	FailureTransient FailureClass = "transient"
+	FailurePermanent FailureClass = "permanent"
+	// FailureNoSpace marks videos waiting for free space on the cache disk,
+	// which are retried once there is enough
+	FailureNoSpace FailureClass = "insufficient_space"
+)
+
 // Video represents a video file in the library
 type Video struct {
 	ID           int64
@@ -31,16 +46,118 @@ type Video struct {
 	ErrorMessage sql.NullString // Change to sql.NullString to handle NULL values
 	CreatedAt    time.Time
 	UpdatedAt    time.Time
+	DeletedAt    sql.NullTime
+	Metadata
+
+	// CacheDir and MasterPlaylist locate the HLS output relative to the
+	// cache root. Both are empty until the video has been processed.
+	CacheDir       string
+	MasterPlaylist string
+
+	// RetryCount is the number of failed attempts since the video last
+	// became ready or changed on disk. NextRetryAt is set while an automatic
+	// retry is scheduled.
+	RetryCount   int
+	FailureClass FailureClass
+	NextRetryAt  sql.NullTime
+
+	// Pinned videos keep their output when the cache is evicted
+	Pinned bool
+	// DroppedVariants are the comma separated variants the last transcode
+	// dropped to fit cache.max_video_size_gb
+	DroppedVariants string
+	// RepairVariants are the comma separated variants to transcode again
+	// when the video is processed, all of them when empty
+	RepairVariants string
 }
 
+// Metadata holds the technical properties of a video as found by probing it
+type Metadata struct {
+	Container     string
+	VideoCodec    string
+	Width         int
+	Height        int
+	FrameRate     float64
+	BitDepth      int
+	HDR           bool
+	AudioCodec    string
+	AudioChannels int
+	CoverArt      bool
+}
+
+// videoColumns lists the columns read by scanVideo, in order. Columns are
+// qualified so queries can join other tables.
+const videoColumns = `videos.id, videos.filename, videos.path, videos.size,
+		videos.duration, videos.status, videos.error_message, videos.created_at,
+		videos.updated_at, videos.deleted_at, videos.container, videos.video_codec,
+		videos.width, videos.height, videos.frame_rate, videos.bit_depth, videos.hdr,
+		videos.audio_codec, videos.audio_channels, videos.cache_dir,
+		videos.master_playlist, videos.retry_count, videos.failure_class,
+		videos.next_retry_at, videos.cover_art, videos.pinned,
+		videos.dropped_variants, videos.repair_variants`
+
 // DB handles database operations
 type DB struct {
 	db *sql.DB
+	// ctx traces and cancels queries, set by WithContext
+	ctx context.Context
+
+	// Prepared statements for the hot query paths
+	getVideoStmt       *sql.Stmt
+	getVideoByPathStmt *sql.Stmt
+	listByStatusStmt   *sql.Stmt
+	videoExistsStmt    *sql.Stmt
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVideo scans a row selected with videoColumns into a Video
+func scanVideo(row rowScanner) (*Video, error) {
+	var video Video
+	err := row.Scan(
+		&video.ID, &video.Filename, &video.Path, &video.Size,
+		&video.Duration, &video.Status, &video.ErrorMessage,
+		&video.CreatedAt, &video.UpdatedAt, &video.DeletedAt,
+		&video.Container, &video.VideoCodec, &video.Width, &video.Height,
+		&video.FrameRate, &video.BitDepth, &video.HDR, &video.AudioCodec,
+		&video.AudioChannels, &video.CacheDir, &video.MasterPlaylist,
+		&video.RetryCount, &video.FailureClass, &video.NextRetryAt,
+		&video.CoverArt, &video.Pinned, &video.DroppedVariants,
+		&video.RepairVariants,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &video, nil
+}
+
+// scanVideos scans all rows selected with videoColumns
+func scanVideos(rows *sql.Rows) ([]*Video, error) {
+	defer rows.Close()
+
+	var videos []*Video
+	for rows.Next() {
+		video, err := scanVideo(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan video row: %w", err)
+		}
+		videos = append(videos, video)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating video rows: %w", err)
+	}
+
+	return videos, nil
 }
 
 // New creates a new database connection
 func New(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// Foreign keys are needed for cascading deletes of dependent rows
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -58,18 +175,64 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to initialize database schema: %w", err)
 	}
 
+	// Prepare frequently used statements
+	if err := instance.prepareStatements(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to prepare statements: %w", err)
+	}
+
 	return instance, nil
 }
 
 // Close closes the database connection
 func (d *DB) Close() error {
+	for _, stmt := range []*sql.Stmt{
+		d.getVideoStmt, d.getVideoByPathStmt, d.listByStatusStmt,
+		d.videoExistsStmt,
+	} {
+		if stmt != nil {
+			stmt.Close()
+		}
+	}
 	return d.db.Close()
 }
 
+// Ping checks that the database can answer queries
+func (d *DB) Ping(ctx context.Context) error {
+	var one int
+	if err := d.db.QueryRowContext(ctx, "SELECT 1").Scan(&one); err != nil {
+		return fmt.Errorf("database not reachable: %w", err)
+	}
+	return nil
+}
+
+// prepareStatements prepares the statements used on hot paths
+func (d *DB) prepareStatements() error {
+	stmts := []struct {
+		dest  **sql.Stmt
+		query string
+	}{
+		{&d.getVideoStmt, "SELECT " + videoColumns + " FROM videos WHERE id = ? AND deleted_at IS NULL"},
+		{&d.getVideoByPathStmt, "SELECT " + videoColumns + " FROM videos WHERE path = ? AND deleted_at IS NULL"},
+		{&d.listByStatusStmt, "SELECT " + videoColumns + " FROM videos WHERE status = ? AND deleted_at IS NULL ORDER BY filename"},
+		{&d.videoExistsStmt, "SELECT EXISTS(SELECT 1 FROM videos WHERE path = ?)"},
+	}
+
+	for _, s := range stmts {
+		stmt, err := d.db.Prepare(s.query)
+		if err != nil {
+			return fmt.Errorf("failed to prepare %q: %w", s.query, err)
+		}
+		*s.dest = stmt
+	}
+
+	return nil
+}
+
 // initSchema creates the necessary tables if they don't exist
 func (d *DB) initSchema() error {
 	// Create videos table
-	_, err := d.db.Exec(`
+	_, err := d.exec(`
 		CREATE TABLE IF NOT EXISTS videos (
 			id INTEGER PRIMARY KEY AUTOINCREMENT,
 			filename TEXT NOT NULL,
@@ -86,12 +249,96 @@ func (d *DB) initSchema() error {
 		return fmt.Errorf("failed to create videos table: %w", err)
 	}
 
+	// Create cache inventory table, which migrations extend
+	if err := d.initCacheSchema(); err != nil {
+		return err
+	}
+
+	// Apply schema migrations on top of the base tables
+	if err := d.migrate(); err != nil {
+		return err
+	}
+
+	// Create indexes used by listing and filtering queries. The path column
+	// is already indexed through its UNIQUE constraint.
+	indexes := []string{
+		"CREATE INDEX IF NOT EXISTS idx_videos_status ON videos(status, filename)",
+		"CREATE INDEX IF NOT EXISTS idx_videos_filename ON videos(filename)",
+		"CREATE INDEX IF NOT EXISTS idx_videos_updated_at ON videos(updated_at)",
+		"CREATE INDEX IF NOT EXISTS idx_videos_created_at ON videos(created_at)",
+		// Only trashed videos, so live listings keep using the indexes of
+		// their sort order, while nearly every video has no deleted_at
+		"CREATE INDEX IF NOT EXISTS idx_videos_trash ON videos(deleted_at) WHERE deleted_at IS NOT NULL",
+		"CREATE INDEX IF NOT EXISTS idx_videos_video_codec ON videos(video_codec)",
+		"CREATE INDEX IF NOT EXISTS idx_videos_height ON videos(height)",
+	}
+	for _, stmt := range indexes {
+		if _, err := d.exec(stmt); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
+	}
+
+	// Create processing attempts table
+	if err := d.initAttemptsSchema(); err != nil {
+		return err
+	}
+
+	// Create user ratings table
+	if err := d.initRatingsSchema(); err != nil {
+		return err
+	}
+
+	// Create audit log table
+	if err := d.initEventsSchema(); err != nil {
+		return err
+	}
+
+	// Create API keys table
+	if err := d.initAPIKeysSchema(); err != nil {
+		return err
+	}
+
+	// Create users table
+	if err := d.initUsersSchema(); err != nil {
+		return err
+	}
+
+	// Create playback positions table
+	if err := d.initPositionsSchema(); err != nil {
+		return err
+	}
+
+	// Create the watch history table
+	if err := d.initHistorySchema(); err != nil {
+		return err
+	}
+
+	// Create video tags table
+	if err := d.initTagsSchema(); err != nil {
+		return err
+	}
+
+	// Create chapters and subtitle tracks tables
+	if err := d.initTracksSchema(); err != nil {
+		return err
+	}
+
+	// Create processing progress table
+	if err := d.initProgressSchema(); err != nil {
+		return err
+	}
+
+	// Create the full text search index
+	if err := d.initSearchSchema(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
 // AddVideo adds a new video to the database
 func (d *DB) AddVideo(filename, path string, size int64) (int64, error) {
-	result, err := d.db.Exec(
+	result, err := d.exec(
 		"INSERT INTO videos (filename, path, size, status, error_message) VALUES (?, ?, ?, ?, NULL)",
 		filename, path, size, StatusPending,
 	)
@@ -107,39 +354,91 @@ func (d *DB) AddVideo(filename, path string, size int64) (int64, error) {
 	return id, nil
 }
 
-// GetVideo retrieves a video by its ID
-func (d *DB) GetVideo(id int64) (*Video, error) {
-	var video Video
-	err := d.db.QueryRow(`
-		SELECT id, filename, path, size, duration, status, error_message, 
-		       created_at, updated_at
-		FROM videos
-		WHERE id = ?
-	`, id).Scan(
-		&video.ID, &video.Filename, &video.Path, &video.Size, 
-		&video.Duration, &video.Status, &video.ErrorMessage,
-		&video.CreatedAt, &video.UpdatedAt,
+// PutVideo adds a video file a user wrote into a media directory, or
+// queues the video already at its path for processing again when the file
+// replaced its source, taking it out of the trash. It returns the video ID.
+func (d *DB) PutVideo(filename, path string, size int64) (int64, error) {
+	var id int64
+	err := d.queryRow(`
+		INSERT INTO videos (filename, path, size, status, error_message) VALUES (?, ?, ?, ?, NULL)
+		ON CONFLICT(path) DO UPDATE
+		SET size = excluded.size, status = excluded.status, error_message = NULL,
+		    retry_count = 0, failure_class = '', next_retry_at = NULL,
+		    deleted_at = NULL, updated_at = CURRENT_TIMESTAMP
+		RETURNING id
+	`, filename, path, size, StatusPending).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to put video: %w", err)
+	}
+
+	return id, nil
+}
+
+// MoveVideos changes the path of the video at oldPath to newPath, or of
+// all videos below oldPath when a directory was moved, keeping their
+// processed output
+func (d *DB) MoveVideos(oldPath, newPath string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin move transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Paths below the directory sort between "dir/" and "dir0", as '0'
+	// follows '/'
+	dir := oldPath + string(filepath.Separator)
+	rows, err := tx.Query(
+		"SELECT id, path FROM videos WHERE path = ? OR (path >= ? AND path < ?)",
+		oldPath, dir, oldPath+string(filepath.Separator+1),
 	)
+	if err != nil {
+		return fmt.Errorf("failed to find moved videos: %w", err)
+	}
+	moved := make(map[int64]string)
+	for rows.Next() {
+		var id int64
+		var path string
+		if err := rows.Scan(&id, &path); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan moved video: %w", err)
+		}
+		moved[id] = newPath + strings.TrimPrefix(path, oldPath)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating moved videos: %w", err)
+	}
+
+	for id, path := range moved {
+		_, err := tx.Exec(
+			"UPDATE videos SET path = ?, filename = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			path, filepath.Base(path), id,
+		)
+		if err != nil {
+			return fmt.Errorf("failed to move video %d: %w", id, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit move: %w", err)
+	}
+	return nil
+}
+
+// GetVideo retrieves a video by its ID. Videos in the trash are not returned.
+func (d *DB) GetVideo(id int64) (*Video, error) {
+	video, err := scanVideo(d.stmtQueryRow(d.getVideoStmt, id))
 	if err != nil {
 		return nil, fmt.Errorf("failed to get video: %w", err)
 	}
 
-	return &video, nil
+	return video, nil
 }
 
-// GetVideoByPath retrieves a video by its file path
+// GetVideoByPath retrieves a video by its file path. Videos in the trash are
+// not returned.
 func (d *DB) GetVideoByPath(path string) (*Video, error) {
-	var video Video
-	err := d.db.QueryRow(`
-		SELECT id, filename, path, size, duration, status, error_message, 
-		       created_at, updated_at
-		FROM videos
-		WHERE path = ?
-	`, path).Scan(
-		&video.ID, &video.Filename, &video.Path, &video.Size, 
-		&video.Duration, &video.Status, &video.ErrorMessage,
-		&video.CreatedAt, &video.UpdatedAt,
-	)
+	video, err := scanVideo(d.stmtQueryRow(d.getVideoByPathStmt, path))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil // No video found, not an error
@@ -147,81 +446,46 @@ func (d *DB) GetVideoByPath(path string) (*Video, error) {
 		return nil, fmt.Errorf("failed to get video by path: %w", err)
 	}
 
-	return &video, nil
+	return video, nil
 }
 
-// ListVideos retrieves all videos
-func (d *DB) ListVideos() ([]*Video, error) {
-	rows, err := d.db.Query(`
-		SELECT id, filename, path, size, duration, status, error_message, 
-		       created_at, updated_at
-		FROM videos
-		ORDER BY filename
-	`)
+// GetVideoByCacheDir retrieves the video whose HLS output is stored in a
+// directory relative to the cache root. It returns nil if there is none.
+func (d *DB) GetVideoByCacheDir(dir string) (*Video, error) {
+	video, err := scanVideo(d.queryRow("SELECT "+videoColumns+" FROM videos WHERE cache_dir = ? AND deleted_at IS NULL", dir))
 	if err != nil {
-		return nil, fmt.Errorf("failed to list videos: %w", err)
-	}
-	defer rows.Close()
-
-	var videos []*Video
-	for rows.Next() {
-		var video Video
-		err := rows.Scan(
-			&video.ID, &video.Filename, &video.Path, &video.Size, 
-			&video.Duration, &video.Status, &video.ErrorMessage,
-			&video.CreatedAt, &video.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan video row: %w", err)
+		if err == sql.ErrNoRows {
+			return nil, nil
 		}
-		videos = append(videos, &video)
+		return nil, fmt.Errorf("failed to get video by cache directory: %w", err)
 	}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating video rows: %w", err)
-	}
-
-	return videos, nil
+	return video, nil
 }
 
 // ListVideosByStatus retrieves videos with a specific status
 func (d *DB) ListVideosByStatus(status VideoStatus) ([]*Video, error) {
-	rows, err := d.db.Query(`
-		SELECT id, filename, path, size, duration, status, error_message, 
-		       created_at, updated_at
-		FROM videos
-		WHERE status = ?
-		ORDER BY filename
-	`, status)
+	rows, err := d.stmtQuery(d.listByStatusStmt, status)
 	if err != nil {
 		return nil, fmt.Errorf("failed to list videos by status: %w", err)
 	}
-	defer rows.Close()
 
-	var videos []*Video
-	for rows.Next() {
-		var video Video
-		err := rows.Scan(
-			&video.ID, &video.Filename, &video.Path, &video.Size, 
-			&video.Duration, &video.Status, &video.ErrorMessage,
-			&video.CreatedAt, &video.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to scan video row: %w", err)
-		}
-		videos = append(videos, &video)
-	}
+	return scanVideos(rows)
+}
 
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating video rows: %w", err)
+// ListAllVideos retrieves every video outside the trash
+func (d *DB) ListAllVideos() ([]*Video, error) {
+	rows, err := d.query("SELECT " + videoColumns + " FROM videos WHERE deleted_at IS NULL ORDER BY filename")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
 	}
 
-	return videos, nil
+	return scanVideos(rows)
 }
 
 // UpdateVideoStatus updates the status of a video
 func (d *DB) UpdateVideoStatus(id int64, status VideoStatus, errorMsg string) error {
-	_, err := d.db.Exec(
+	_, err := d.exec(
 		"UPDATE videos SET status = ?, error_message = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
 		status, sql.NullString{String: errorMsg, Valid: errorMsg != ""}, id,
 	)
@@ -237,12 +501,49 @@ func (d *DB) SetVideoProcessing(id int64) error {
 	return d.UpdateVideoStatus(id, StatusProcessing, "")
 }
 
-// SetVideoReady marks a video as ready
-func (d *DB) SetVideoReady(id int64, duration float64) error {
-	_, err := d.db.Exec(
-		"UPDATE videos SET status = ?, duration = ?, error_message = NULL, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-		StatusReady, duration, id,
-	)
+// SetVideoPending puts a video back into the processing queue to be
+// transcoded entirely
+func (d *DB) SetVideoPending(id int64) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = NULL, repair_variants = '',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, StatusPending, id)
+	if err != nil {
+		return fmt.Errorf("failed to update video status: %w", err)
+	}
+
+	return nil
+}
+
+// SetVideoRepair puts a ready video back into the processing queue to
+// transcode only the given variants again
+func (d *DB) SetVideoRepair(id int64, variants []string) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = NULL, repair_variants = ?,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, StatusPending, strings.Join(variants, ","), id)
+	if err != nil {
+		return fmt.Errorf("failed to queue video for repair: %w", err)
+	}
+
+	return nil
+}
+
+// SetVideoReady marks a video as ready and records where its HLS output is
+// stored. Both paths are relative to the cache root.
+func (d *DB) SetVideoReady(id int64, duration float64, cacheDir, masterPlaylist string) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, duration = ?, error_message = NULL, cache_dir = ?,
+		    master_playlist = ?, retry_count = 0, failure_class = '',
+		    next_retry_at = NULL, repair_variants = '',
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, StatusReady, duration, cacheDir, masterPlaylist, id)
 	if err != nil {
 		return fmt.Errorf("failed to update video as ready: %w", err)
 	}
@@ -250,14 +551,134 @@ func (d *DB) SetVideoReady(id int64, duration float64) error {
 	return nil
 }
 
+// SetVideoMetadata stores the probed technical metadata and duration of a video
+func (d *DB) SetVideoMetadata(id int64, duration float64, meta Metadata) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET duration = ?, container = ?, video_codec = ?, width = ?, height = ?,
+		    frame_rate = ?, bit_depth = ?, hdr = ?, audio_codec = ?, audio_channels = ?,
+		    cover_art = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`,
+		duration, meta.Container, meta.VideoCodec, meta.Width, meta.Height,
+		meta.FrameRate, meta.BitDepth, meta.HDR, meta.AudioCodec, meta.AudioChannels,
+		meta.CoverArt, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update video metadata: %w", err)
+	}
+
+	return nil
+}
+
 // SetVideoError marks a video as having an error
 func (d *DB) SetVideoError(id int64, errorMsg string) error {
 	return d.UpdateVideoStatus(id, StatusError, errorMsg)
 }
 
-// DeleteVideo removes a video from the database
+// SetVideoFailed marks a video as failed, counting the failure and recording
+// its class. A valid nextRetry schedules an automatic retry.
+func (d *DB) SetVideoFailed(id int64, errorMsg string, class FailureClass, nextRetry sql.NullTime) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = ?, retry_count = retry_count + 1,
+		    failure_class = ?, next_retry_at = ?, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, StatusError, errorMsg, class, nextRetry, id)
+	if err != nil {
+		return fmt.Errorf("failed to update video as failed: %w", err)
+	}
+
+	return nil
+}
+
+// SetVideoWaitingForSpace marks a video as failed for lack of free space
+// on the cache disk. Waiting doesn't count as a failed attempt.
+func (d *DB) SetVideoWaitingForSpace(id int64, errorMsg string) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = ?, failure_class = ?, next_retry_at = NULL,
+		    updated_at = CURRENT_TIMESTAMP
+		WHERE id = ?
+	`, StatusError, errorMsg, FailureNoSpace, id)
+	if err != nil {
+		return fmt.Errorf("failed to update video as waiting for space: %w", err)
+	}
+
+	return nil
+}
+
+// ListWaitingForSpace retrieves the videos waiting for free space on the
+// cache disk, longest waiting first
+func (d *DB) ListWaitingForSpace() ([]*Video, error) {
+	rows, err := d.query(`
+		SELECT `+videoColumns+` FROM videos
+		WHERE status = ? AND failure_class = ? AND deleted_at IS NULL
+		ORDER BY updated_at, id
+	`, StatusError, FailureNoSpace)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos waiting for space: %w", err)
+	}
+
+	return scanVideos(rows)
+}
+
+// RequeueWaitingForSpace moves a video waiting for free space back to
+// pending, keeping the variants queued for repair, if any
+func (d *DB) RequeueWaitingForSpace(id int64) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND status = ? AND failure_class = ?
+	`, StatusPending, id, StatusError, FailureNoSpace)
+	if err != nil {
+		return fmt.Errorf("failed to requeue video: %w", err)
+	}
+
+	return nil
+}
+
+// RequeueDueRetries moves failed videos whose retry is due back to pending
+// and returns the number of videos requeued
+func (d *DB) RequeueDueRetries(now time.Time) (int64, error) {
+	result, err := d.exec(`
+		UPDATE videos
+		SET status = ?, next_retry_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE status = ? AND next_retry_at IS NOT NULL AND next_retry_at <= ?
+		  AND deleted_at IS NULL
+	`, StatusPending, StatusError, now.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to requeue retries: %w", err)
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to get requeued row count: %w", err)
+	}
+
+	return n, nil
+}
+
+// ResetRetries clears the retry bookkeeping of a video and queues it for
+// processing, e.g. after it was fixed by hand
+func (d *DB) ResetRetries(id int64) error {
+	_, err := d.exec(`
+		UPDATE videos
+		SET status = ?, error_message = NULL, retry_count = 0, failure_class = '',
+		    next_retry_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NULL
+	`, StatusPending, id)
+	if err != nil {
+		return fmt.Errorf("failed to reset retries: %w", err)
+	}
+
+	return nil
+}
+
+// DeleteVideo permanently removes a video and its history from the database.
+// Use SoftDeleteVideo to move a video to the trash instead.
 func (d *DB) DeleteVideo(id int64) error {
-	_, err := d.db.Exec("DELETE FROM videos WHERE id = ?", id)
+	_, err := d.exec("DELETE FROM videos WHERE id = ?", id)
 	if err != nil {
 		return fmt.Errorf("failed to delete video: %w", err)
 	}
@@ -270,15 +691,16 @@ func (d *DB) GetPendingVideos() ([]*Video, error) {
 	return d.ListVideosByStatus(StatusPending)
 }
 
-// VideoExists checks if a video exists in the database
+// VideoExists checks if a video exists in the database. Videos in the trash
+// count as existing so that scans don't re-add them.
 func (d *DB) VideoExists(path string) (bool, error) {
-	var count int
-	err := d.db.QueryRow("SELECT COUNT(*) FROM videos WHERE path = ?", path).Scan(&count)
+	var exists bool
+	err := d.stmtQueryRow(d.videoExistsStmt, path).Scan(&exists)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if video exists: %w", err)
 	}
 
-	return count > 0, nil
+	return exists, nil
 }
 
 // HasProcessedVideo checks if a given path already has been processed
@@ -286,8 +708,8 @@ func (d *DB) HasProcessedVideo(originalPath string) (bool, error) {
 	filename := filepath.Base(originalPath)
 	
 	var count int
-	err := d.db.QueryRow(
-		"SELECT COUNT(*) FROM videos WHERE filename = ? AND status = ?", 
+	err := d.queryRow(
+		"SELECT COUNT(*) FROM videos WHERE filename = ? AND status = ? AND deleted_at IS NULL",
 		filename, StatusReady,
 	).Scan(&count)
 	
@@ -0,0 +1,239 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// newTestDB opens a new database in a temporary directory, closed when
+// the test ends
+func newTestDB(tb testing.TB) *DB {
+	tb.Helper()
+	db, err := New(filepath.Join(tb.TempDir(), "library.db"))
+	if err != nil {
+		tb.Fatalf("New: %v", err)
+	}
+	tb.Cleanup(func() { db.Close() })
+	return db
+}
+
+// queryPlan returns the steps of SQLite's plan for query, one per line
+func queryPlan(tb testing.TB, db *DB, query string, args ...interface{}) string {
+	tb.Helper()
+	rows, err := db.db.Query("EXPLAIN QUERY PLAN "+query, args...)
+	if err != nil {
+		tb.Fatalf("EXPLAIN QUERY PLAN: %v", err)
+	}
+	defer rows.Close()
+
+	var steps []string
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		if err := rows.Scan(&id, &parent, &unused, &detail); err != nil {
+			tb.Fatal(err)
+		}
+		steps = append(steps, detail)
+	}
+	if err := rows.Err(); err != nil {
+		tb.Fatal(err)
+	}
+	return strings.Join(steps, "\n")
+}
+
+// TestListVideosPlan checks that listings by name walk idx_videos_filename
+// in order, instead of sorting all videos for every page
+func TestListVideosPlan(t *testing.T) {
+	db := newTestDB(t)
+	seedVideos(t, db, 1000)
+
+	for _, opts := range []ListOptions{
+		{Limit: 50, Offset: 100},
+		{Sort: SortName, Desc: true, Limit: 50},
+		{Statuses: []VideoStatus{StatusError}, Limit: 50},
+	} {
+		from, args := opts.fromClause()
+		where, whereArgs := opts.whereClause()
+		args = append(args, whereArgs...)
+		query := "SELECT " + videoColumns + from + where + opts.orderClause() + " LIMIT ? OFFSET ?"
+		plan := queryPlan(t, db, query, append(args, opts.Limit, opts.Offset)...)
+		if strings.Contains(plan, "TEMP B-TREE") || !strings.Contains(plan, "idx_videos_") {
+			t.Errorf("%+v sorts the videos instead of walking an index:\n%s", opts, plan)
+		}
+	}
+
+	plan := queryPlan(t, db, "SELECT "+videoColumns+" FROM videos WHERE deleted_at IS NULL ORDER BY filename")
+	if strings.Contains(plan, "TEMP B-TREE") {
+		t.Errorf("ListAllVideos sorts the videos instead of walking idx_videos_filename:\n%s", plan)
+	}
+}
+
+func TestListVideosTotal(t *testing.T) {
+	db := newTestDB(t)
+	seedVideos(t, db, 120)
+
+	tests := []struct {
+		opts  ListOptions
+		count int
+		total int
+	}{
+		{ListOptions{}, 120, 120},
+		{ListOptions{Limit: 50}, 50, 120},
+		{ListOptions{Limit: 50, Offset: 50}, 50, 120},
+		{ListOptions{Limit: 50, Offset: 100}, 20, 120},
+		{ListOptions{Limit: 50, Offset: 200}, 0, 120},
+		{ListOptions{Limit: 50, Offset: 120}, 0, 120},
+		{ListOptions{Limit: 50, Statuses: []VideoStatus{StatusError}}, 3, 3},
+		{ListOptions{Limit: 50, SkipTotal: true}, 50, 0},
+	}
+	for _, tt := range tests {
+		page, err := db.ListVideos(tt.opts)
+		if err != nil {
+			t.Fatalf("ListVideos(%+v): %v", tt.opts, err)
+		}
+		if len(page.Videos) != tt.count || page.Total != tt.total {
+			t.Errorf("ListVideos(%+v) = %d videos of %d, want %d of %d", tt.opts, len(page.Videos), page.Total, tt.count, tt.total)
+		}
+	}
+}
+
+// benchVideos is the number of videos the benchmarks run against
+const benchVideos = 50000
+
+// seedVideos adds n videos in folders of 20 episodes, one in 50 failed
+// and one in 10 still pending, and returns the path of one of them
+func seedVideos(b testing.TB, db *DB, n int) string {
+	b.Helper()
+	tx, err := db.db.Begin()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	stmt, err := tx.Prepare("INSERT INTO videos (filename, path, size, status) VALUES (?, ?, ?, ?)")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer stmt.Close()
+
+	for i := 0; i < n; i++ {
+		status := StatusReady
+		switch {
+		case i%50 == 0:
+			status = StatusError
+		case i%10 == 0:
+			status = StatusPending
+		}
+		name := fmt.Sprintf("Show %d Episode %d.mkv", i/20, i%20)
+		path := fmt.Sprintf("/media/Show %d/%s", i/20, name)
+		if _, err := stmt.Exec(name, path, int64(i)*1000, status); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	return fmt.Sprintf("/media/Show %d/Show %d Episode 7.mkv", n/40, n/40)
+}
+
+// BenchmarkQueries runs the queries of the web UI, the API and the
+// librarian against 50k videos. The unindexed and unprepared variants run
+// the same SQL with NOT INDEXED or without the prepared statement, to show
+// what the indexes and statements save.
+func BenchmarkQueries(b *testing.B) {
+	db := newTestDB(b)
+	path := seedVideos(b, db, benchVideos)
+
+	b.Run("ListVideos/name", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.ListVideos(ListOptions{Sort: SortName, Limit: 50, Offset: 1000}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListVideos/name/unindexed", func(b *testing.B) {
+		query := "SELECT " + videoColumns + " FROM videos NOT INDEXED WHERE deleted_at IS NULL ORDER BY filename, id LIMIT 50 OFFSET 1000"
+		for i := 0; i < b.N; i++ {
+			rows, err := db.db.Query(query)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := scanVideos(rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListVideos/name/skip-total", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.ListVideos(ListOptions{Sort: SortName, Limit: 50, Offset: 1000, SkipTotal: true}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListVideos/status", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.ListVideos(ListOptions{Statuses: []VideoStatus{StatusError}, Limit: 50}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListVideos/query", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.ListVideos(ListOptions{Query: "show 1234", Limit: 50}); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("ListVideosByStatus", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := db.ListVideosByStatus(StatusError); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("ListVideosByStatus/unindexed", func(b *testing.B) {
+		query := "SELECT " + videoColumns + " FROM videos NOT INDEXED WHERE status = ? AND deleted_at IS NULL ORDER BY filename"
+		for i := 0; i < b.N; i++ {
+			rows, err := db.db.Query(query, StatusError)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err := scanVideos(rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("GetVideoByPath", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if v, err := db.GetVideoByPath(path); err != nil || v == nil {
+				b.Fatalf("GetVideoByPath(%q) = %v, %v", path, v, err)
+			}
+		}
+	})
+	b.Run("GetVideoByPath/unprepared", func(b *testing.B) {
+		query := "SELECT " + videoColumns + " FROM videos WHERE path = ? AND deleted_at IS NULL"
+		for i := 0; i < b.N; i++ {
+			if _, err := scanVideo(db.db.QueryRow(query, path)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("GetVideoByPath/unindexed", func(b *testing.B) {
+		query := "SELECT " + videoColumns + " FROM videos NOT INDEXED WHERE path = ? AND deleted_at IS NULL"
+		for i := 0; i < b.N; i++ {
+			if _, err := scanVideo(db.db.QueryRow(query, path)); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("VideoExists", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if ok, err := db.VideoExists(path); err != nil || !ok {
+				b.Fatalf("VideoExists(%q) = %v, %v", path, ok, err)
+			}
+		}
+	})
+}
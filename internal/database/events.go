@@ -0,0 +1,161 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventType identifies the kind of change recorded in the audit log
+type EventType string
+
+// Event type constants
+const (
+	EventScan         EventType = "scan"
+	EventStatusChange EventType = "status_change"
+	EventCacheCleanup EventType = "cache_cleanup"
+	EventDelete       EventType = "delete"
+	EventRestore      EventType = "restore"
+	EventPurge        EventType = "purge"
+	EventUpload       EventType = "upload"
+	EventConfigReload EventType = "config_reload"
+	EventPin          EventType = "pin"
+	EventRecording    EventType = "recording"
+)
+
+// Actors recording events on behalf of a subsystem rather than a user
+const (
+	ActorLibrarian = "librarian"
+	ActorServer    = "server"
+	ActorCLI       = "cli"
+)
+
+// Event is an entry in the append-only audit log
+type Event struct {
+	ID        int64
+	CreatedAt time.Time
+	Type      EventType
+	VideoID   sql.NullInt64
+	Actor     string
+	Message   string
+}
+
+// EventFilter restricts the events returned by ListEvents
+type EventFilter struct {
+	// VideoID limits the result to events about one video when non-zero
+	VideoID int64
+	// Type limits the result to one event type when non-empty
+	Type EventType
+	// BeforeID returns only events older than this ID when non-zero
+	BeforeID int64
+	// AfterID returns only events newer than this ID when non-zero
+	AfterID int64
+	// Limit is the maximum number of events returned, 100 by default
+	Limit int
+	// Ascending returns the oldest events first, which suits tailing the
+	// log with AfterID
+	Ascending bool
+}
+
+// initEventsSchema creates the audit log table. Events deliberately don't
+// reference videos with a foreign key so they outlive purged videos.
+func (d *DB) initEventsSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS events (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			type TEXT NOT NULL,
+			video_id INTEGER,
+			actor TEXT NOT NULL,
+			message TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create events table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_events_video_id ON events(video_id, id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create events index: %w", err)
+	}
+
+	return nil
+}
+
+// LogEvent appends an event to the audit log. A videoID of 0 records an
+// event that isn't about a specific video.
+func (d *DB) LogEvent(eventType EventType, videoID int64, actor, message string) error {
+	_, err := d.exec(
+		"INSERT INTO events (created_at, type, video_id, actor, message) VALUES (?, ?, ?, ?, ?)",
+		time.Now().UTC(), eventType, sql.NullInt64{Int64: videoID, Valid: videoID != 0}, actor, message,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to log event: %w", err)
+	}
+
+	return nil
+}
+
+// ListEvents retrieves audit log events matching the filter, newest first
+// unless the filter asks for ascending order
+func (d *DB) ListEvents(filter EventFilter) ([]*Event, error) {
+	var conds []string
+	var args []interface{}
+
+	if filter.VideoID != 0 {
+		conds = append(conds, "video_id = ?")
+		args = append(args, filter.VideoID)
+	}
+	if filter.Type != "" {
+		conds = append(conds, "type = ?")
+		args = append(args, filter.Type)
+	}
+	if filter.BeforeID != 0 {
+		conds = append(conds, "id < ?")
+		args = append(args, filter.BeforeID)
+	}
+	if filter.AfterID != 0 {
+		conds = append(conds, "id > ?")
+		args = append(args, filter.AfterID)
+	}
+
+	query := "SELECT id, created_at, type, video_id, actor, message FROM events"
+	if len(conds) > 0 {
+		query += " WHERE " + strings.Join(conds, " AND ")
+	}
+
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = 100
+	}
+	order := "DESC"
+	if filter.Ascending {
+		order = "ASC"
+	}
+	query += " ORDER BY id " + order + " LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list events: %w", err)
+	}
+	defer rows.Close()
+
+	var events []*Event
+	for rows.Next() {
+		var e Event
+		if err := rows.Scan(&e.ID, &e.CreatedAt, &e.Type, &e.VideoID, &e.Actor, &e.Message); err != nil {
+			return nil, fmt.Errorf("failed to scan event row: %w", err)
+		}
+		events = append(events, &e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating event rows: %w", err)
+	}
+
+	return events, nil
+}
@@ -0,0 +1,262 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Watch is a video a user watched to the end. The file of the video is
+// kept with it, as the history outlives purged videos.
+type Watch struct {
+	ID        int64
+	User      string
+	VideoID   int64
+	Filename  string
+	Path      string
+	Duration  float64
+	WatchedAt time.Time
+}
+
+// ScrobbleToken holds the OAuth tokens of a scrobble target
+type ScrobbleToken struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresAt    time.Time
+}
+
+// initHistorySchema creates the watch history table and the progress of
+// sending it to scrobble targets. Watches deliberately don't reference
+// videos with a foreign key so they outlive purged videos.
+func (d *DB) initHistorySchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS watch_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user TEXT NOT NULL,
+			video_id INTEGER NOT NULL,
+			filename TEXT NOT NULL,
+			path TEXT NOT NULL,
+			duration REAL NOT NULL DEFAULT 0,
+			watched_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create watch_history table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_watch_history_user ON watch_history(user, id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create watch_history index: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_watch_history_video ON watch_history(user, video_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create watch_history index: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE TABLE IF NOT EXISTS scrobble_targets (
+			name TEXT PRIMARY KEY,
+			last_watch_id INTEGER NOT NULL DEFAULT 0,
+			access_token TEXT NOT NULL DEFAULT '',
+			refresh_token TEXT NOT NULL DEFAULT '',
+			expires_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create scrobble_targets table: %w", err)
+	}
+
+	return nil
+}
+
+// AddWatch records that a user watched a video to the end, reporting
+// whether it was recorded. Players keep reporting positions near the end,
+// so a watch of the same video within its duration of the last one isn't
+// recorded again.
+func (d *DB) AddWatch(user string, video *Video) (bool, error) {
+	now := time.Now().UTC()
+	var last time.Time
+	err := d.queryRow(`
+		SELECT watched_at FROM watch_history
+		WHERE user = ? AND video_id = ?
+		ORDER BY id DESC LIMIT 1
+	`, user, video.ID).Scan(&last)
+	if err != nil && err != sql.ErrNoRows {
+		return false, fmt.Errorf("failed to get last watch: %w", err)
+	}
+	if err == nil && now.Sub(last) < time.Duration(video.Duration*float64(time.Second)) {
+		return false, nil
+	}
+
+	_, err = d.exec(`
+		INSERT INTO watch_history (user, video_id, filename, path, duration, watched_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, user, video.ID, video.Filename, video.Path, video.Duration, now)
+	if err != nil {
+		return false, fmt.Errorf("failed to add watch: %w", err)
+	}
+
+	return true, nil
+}
+
+// ListWatches retrieves the watch history of a user, most recent first,
+// with the total number of watches for paging
+func (d *DB) ListWatches(user string, limit, offset int) ([]*Watch, int, error) {
+	var total int
+	if err := d.queryRow("SELECT COUNT(*) FROM watch_history WHERE user = ?", user).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("failed to count watches: %w", err)
+	}
+
+	watches, err := d.scanWatches(`
+		SELECT id, user, video_id, filename, path, duration, watched_at
+		FROM watch_history
+		WHERE user = ?
+		ORDER BY id DESC
+		LIMIT ? OFFSET ?
+	`, user, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return watches, total, nil
+}
+
+// WatchesAfter retrieves the watches of all users recorded after the one
+// with ID afterID, oldest first, at most limit of them
+func (d *DB) WatchesAfter(afterID int64, limit int) ([]*Watch, error) {
+	return d.scanWatches(`
+		SELECT id, user, video_id, filename, path, duration, watched_at
+		FROM watch_history
+		WHERE id > ?
+		ORDER BY id
+		LIMIT ?
+	`, afterID, limit)
+}
+
+// scanWatches runs a query selecting watches
+func (d *DB) scanWatches(query string, args ...interface{}) ([]*Watch, error) {
+	rows, err := d.query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get watches: %w", err)
+	}
+	defer rows.Close()
+
+	watches := []*Watch{}
+	for rows.Next() {
+		var w Watch
+		if err := rows.Scan(&w.ID, &w.User, &w.VideoID, &w.Filename, &w.Path, &w.Duration, &w.WatchedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan watch row: %w", err)
+		}
+		watches = append(watches, &w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating watch rows: %w", err)
+	}
+
+	return watches, nil
+}
+
+// WatchedVideos reports which of a set of videos a user watched to the
+// end at least once, keyed by video ID. Videos the user hasn't watched are
+// absent from the map.
+func (d *DB) WatchedVideos(user string, videoIDs []int64) (map[int64]bool, error) {
+	watched := make(map[int64]bool, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return watched, nil
+	}
+
+	placeholders := make([]string, len(videoIDs))
+	args := []interface{}{user}
+	for i, id := range videoIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := d.query(`
+		SELECT DISTINCT video_id FROM watch_history
+		WHERE user = ? AND video_id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get watched videos: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan watched video row: %w", err)
+		}
+		watched[id] = true
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating watched video rows: %w", err)
+	}
+
+	return watched, nil
+}
+
+// ScrobbledUpTo retrieves the ID of the last watch sent to a scrobble
+// target, 0 when none was
+func (d *DB) ScrobbledUpTo(target string) (int64, error) {
+	var id int64
+	err := d.queryRow("SELECT last_watch_id FROM scrobble_targets WHERE name = ?", target).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return 0, fmt.Errorf("failed to get scrobble progress: %w", err)
+	}
+	return id, nil
+}
+
+// SetScrobbledUpTo stores the ID of the last watch sent to a scrobble
+// target
+func (d *DB) SetScrobbledUpTo(target string, id int64) error {
+	_, err := d.exec(`
+		INSERT INTO scrobble_targets (name, last_watch_id) VALUES (?, ?)
+		ON CONFLICT(name) DO UPDATE SET last_watch_id = excluded.last_watch_id
+	`, target, id)
+	if err != nil {
+		return fmt.Errorf("failed to set scrobble progress: %w", err)
+	}
+	return nil
+}
+
+// GetScrobbleToken retrieves the OAuth tokens of a scrobble target, nil
+// when it hasn't been logged in to
+func (d *DB) GetScrobbleToken(target string) (*ScrobbleToken, error) {
+	var t ScrobbleToken
+	var expires sql.NullTime
+	err := d.queryRow(`
+		SELECT access_token, refresh_token, expires_at FROM scrobble_targets WHERE name = ?
+	`, target).Scan(&t.AccessToken, &t.RefreshToken, &expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scrobble token: %w", err)
+	}
+	if t.AccessToken == "" {
+		return nil, nil
+	}
+	t.ExpiresAt = expires.Time
+	return &t, nil
+}
+
+// SetScrobbleToken stores the OAuth tokens of a scrobble target
+func (d *DB) SetScrobbleToken(target string, t *ScrobbleToken) error {
+	_, err := d.exec(`
+		INSERT INTO scrobble_targets (name, access_token, refresh_token, expires_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(name) DO UPDATE
+		SET access_token = excluded.access_token, refresh_token = excluded.refresh_token, expires_at = excluded.expires_at
+	`, target, t.AccessToken, t.RefreshToken, t.ExpiresAt.UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set scrobble token: %w", err)
+	}
+	return nil
+}
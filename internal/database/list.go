@@ -0,0 +1,194 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Sort orders accepted by ListVideos
+const (
+	SortName     = "name"
+	SortSize     = "size"
+	SortAdded    = "added"
+	SortUpdated  = "updated"
+	SortDuration = "duration"
+	SortRating   = "rating"
+)
+
+// sortColumns maps sort orders to the columns they sort by
+var sortColumns = map[string]string{
+	SortName:     "videos.filename",
+	SortSize:     "videos.size",
+	SortAdded:    "videos.created_at",
+	SortUpdated:  "videos.updated_at",
+	SortDuration: "videos.duration",
+	SortRating:   "COALESCE(ur.rating, 0)",
+}
+
+// ListOptions controls filtering, ordering and pagination in ListVideos.
+// The zero value lists all videos ordered by name.
+type ListOptions struct {
+	// Statuses restricts the result to videos with one of these statuses
+	Statuses []VideoStatus
+	// Sort is one of the Sort* constants, SortName by default
+	Sort string
+	// Desc reverses the sort order
+	Desc bool
+	// Limit is the maximum number of videos returned, 0 for no limit
+	Limit int
+	// Offset is the number of matching videos to skip
+	Offset int
+	// User is the user whose ratings are used by SortRating and FavoritesOnly
+	User string
+	// FavoritesOnly restricts the result to videos favorited by User
+	FavoritesOnly bool
+	// Query restricts the result to videos whose filename contains words
+	// starting with each word of the query
+	Query string
+	// Tag restricts the result to videos with this tag, ignoring case
+	Tag string
+	// AnyTags restricts the result to videos with one of these tags,
+	// ignoring case
+	AnyTags []string
+	// Dirs restricts the result to videos below one of these directories,
+	// such as the libraries a user may see. nil doesn't restrict the
+	// result, while an empty slice matches nothing.
+	Dirs []string
+	// SkipTotal leaves VideoPage.Total 0 for callers that don't show it,
+	// sparing the count of all matches
+	SkipTotal bool
+}
+
+// VideoPage is a page of videos together with the total number of matches,
+// 0 with ListOptions.SkipTotal
+type VideoPage struct {
+	Videos []*Video
+	Total  int
+}
+
+// ValidSort reports whether sort is an accepted sort order
+func ValidSort(sort string) bool {
+	_, ok := sortColumns[sort]
+	return ok
+}
+
+// ListVideos retrieves a page of videos matching opts. The matches are
+// only counted when the page doesn't tell their number, as counting a
+// large library costs about as much as listing all of it.
+func (d *DB) ListVideos(opts ListOptions) (*VideoPage, error) {
+	from, args := opts.fromClause()
+	where, whereArgs := opts.whereClause()
+	args = append(args, whereArgs...)
+
+	query := "SELECT " + videoColumns + from + where + opts.orderClause()
+	pageArgs := args
+	if opts.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		pageArgs = append(args[:len(args):len(args)], opts.Limit, opts.Offset)
+	}
+
+	rows, err := d.query(query, pageArgs...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+
+	page := &VideoPage{}
+	page.Videos, err = scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(page.Videos)
+	switch {
+	case opts.SkipTotal:
+	case opts.Limit <= 0:
+		page.Total = n
+	case n < opts.Limit && (n > 0 || opts.Offset == 0):
+		// The last page
+		page.Total = opts.Offset + n
+	default:
+		err := d.queryRow("SELECT COUNT(*)"+from+where, args...).Scan(&page.Total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count videos: %w", err)
+		}
+	}
+
+	return page, nil
+}
+
+// fromClause builds the FROM clause, joining the user's ratings when needed
+func (o ListOptions) fromClause() (string, []interface{}) {
+	if o.Sort != SortRating && !o.FavoritesOnly {
+		return " FROM videos", nil
+	}
+	return " FROM videos LEFT JOIN user_ratings ur ON ur.video_id = videos.id AND ur.user = ?",
+		[]interface{}{o.User}
+}
+
+// whereClause builds the WHERE clause and arguments for the options
+func (o ListOptions) whereClause() (string, []interface{}) {
+	conds := []string{"videos.deleted_at IS NULL"}
+	var args []interface{}
+
+	if len(o.Statuses) > 0 {
+		placeholders := make([]string, len(o.Statuses))
+		for i, status := range o.Statuses {
+			placeholders[i] = "?"
+			args = append(args, status)
+		}
+		conds = append(conds, "videos.status IN ("+strings.Join(placeholders, ", ")+")")
+	}
+
+	if o.FavoritesOnly {
+		conds = append(conds, "ur.favorite = 1")
+	}
+
+	if match := matchExpression(o.Query); match != "" {
+		conds = append(conds, "videos.id IN (SELECT docid FROM videos_fts WHERE videos_fts MATCH ?)")
+		args = append(args, match)
+	}
+
+	if o.Tag != "" {
+		conds = append(conds, "videos.id IN (SELECT video_id FROM video_tags WHERE tag = ?)")
+		args = append(args, o.Tag)
+	}
+
+	if len(o.AnyTags) > 0 {
+		placeholders := make([]string, len(o.AnyTags))
+		for i, tag := range o.AnyTags {
+			placeholders[i] = "?"
+			args = append(args, tag)
+		}
+		conds = append(conds, "videos.id IN (SELECT video_id FROM video_tags WHERE tag IN ("+strings.Join(placeholders, ", ")+"))")
+	}
+
+	if o.Dirs != nil {
+		dirConds := []string{"0"}
+		for _, dir := range o.Dirs {
+			// Compare the prefix exactly, LIKE would ignore case
+			dirConds = append(dirConds, "substr(videos.path, 1, length(?)) = ?")
+			prefix := filepath.Clean(dir) + string(filepath.Separator)
+			args = append(args, prefix, prefix)
+		}
+		conds = append(conds, "("+strings.Join(dirConds, " OR ")+")")
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderClause builds the ORDER BY clause for the options. The ID is used as
+// a tie-breaker so pages are stable.
+func (o ListOptions) orderClause() string {
+	column, ok := sortColumns[o.Sort]
+	if !ok {
+		column = sortColumns[SortName]
+	}
+
+	direction := "ASC"
+	if o.Desc {
+		direction = "DESC"
+	}
+
+	return fmt.Sprintf(" ORDER BY %s %s, videos.id %s", column, direction, direction)
+}
@@ -0,0 +1,81 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+)
+
+// LatestSchemaVersion returns the schema version this build migrates
+// databases to
+func LatestSchemaVersion() int {
+	return len(migrations)
+}
+
+// CheckFile verifies that the file at path is an intact database of this
+// application that this build can use, and returns its schema version. The
+// file is opened read-only and not migrated.
+func CheckFile(path string) (int, error) {
+	if _, err := os.Stat(path); err != nil {
+		return 0, err
+	}
+
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return 0, fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	var check string
+	if err := db.QueryRow("PRAGMA quick_check").Scan(&check); err != nil {
+		return 0, fmt.Errorf("%s is not a database: %w", path, err)
+	}
+	if check != "ok" {
+		return 0, fmt.Errorf("%s is damaged: %s", path, check)
+	}
+
+	var tables int
+	if err := db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'videos'").Scan(&tables); err != nil {
+		return 0, fmt.Errorf("failed to read schema: %w", err)
+	}
+	if tables == 0 {
+		return 0, fmt.Errorf("%s is not a library database", path)
+	}
+
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	if version > LatestSchemaVersion() {
+		return version, fmt.Errorf("%s has schema version %d, newer than the %d this build knows", path, version, LatestSchemaVersion())
+	}
+	return version, nil
+}
+
+// BackupFile writes a consistent copy of the database at path to dest,
+// which must not exist yet. Other processes may keep using the database
+// meanwhile. The database is neither migrated nor otherwise changed.
+func BackupFile(path, dest string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return fmt.Errorf("failed to open database: %w", err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("VACUUM INTO ?", dest); err != nil {
+		return fmt.Errorf("failed to back up database: %w", err)
+	}
+	return nil
+}
+
+// Vacuum rebuilds the database file, returning the space of deleted rows to
+// the file system
+func (d *DB) Vacuum() error {
+	if _, err := d.exec("VACUUM"); err != nil {
+		return fmt.Errorf("failed to vacuum database: %w", err)
+	}
+	if _, err := d.exec("PRAGMA optimize"); err != nil {
+		return fmt.Errorf("failed to optimize database: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package database
+
+import (
+	"fmt"
+)
+
+// migrations holds schema changes applied on top of the base schema created
+// by initSchema. Each entry is applied once, in order, and the number of
+// applied migrations is tracked in SQLite's user_version pragma. Never edit
+// or reorder existing entries; append new ones instead.
+var migrations = []string{
+	// 1: soft delete support
+	`ALTER TABLE videos ADD COLUMN deleted_at TIMESTAMP`,
+
+	// 2: technical metadata populated by the probe step
+	`ALTER TABLE videos ADD COLUMN container TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE videos ADD COLUMN video_codec TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE videos ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN frame_rate REAL NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN bit_depth INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN hdr INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN audio_codec TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE videos ADD COLUMN audio_channels INTEGER NOT NULL DEFAULT 0`,
+
+	// 3: cache locations, relative to the cache root
+	`ALTER TABLE videos ADD COLUMN cache_dir TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE videos ADD COLUMN master_playlist TEXT NOT NULL DEFAULT ''`,
+
+	// 4: retry bookkeeping for failed videos
+	`ALTER TABLE videos ADD COLUMN retry_count INTEGER NOT NULL DEFAULT 0;
+	 ALTER TABLE videos ADD COLUMN failure_class TEXT NOT NULL DEFAULT '';
+	 ALTER TABLE videos ADD COLUMN next_retry_at TIMESTAMP`,
+
+	// 5: embedded cover art found by the probe step
+	`ALTER TABLE videos ADD COLUMN cover_art INTEGER NOT NULL DEFAULT 0`,
+
+	// 6: stream requests look up their video by cache directory
+	`CREATE INDEX IF NOT EXISTS idx_videos_cache_dir ON videos(cache_dir)`,
+
+	// 7: pinned videos keep their output when the cache is evicted
+	`ALTER TABLE videos ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0`,
+
+	// 8: variants dropped to fit the per-video cache quota
+	`ALTER TABLE videos ADD COLUMN dropped_variants TEXT NOT NULL DEFAULT ''`,
+
+	// 9: checksums of the segments of cached variants, for cache verify
+	`ALTER TABLE cache_entries ADD COLUMN checksum TEXT NOT NULL DEFAULT ''`,
+
+	// 10: videos queued to transcode some of their variants again
+	`ALTER TABLE videos ADD COLUMN repair_variants TEXT NOT NULL DEFAULT ''`,
+
+	// 11: the full index on deleted_at made listings sort the whole table,
+	// initSchema creates a partial index of the trash instead
+	`DROP INDEX IF EXISTS idx_videos_deleted_at`,
+}
+
+// SchemaVersion returns the number of migrations applied to the database
+func (d *DB) SchemaVersion() (int, error) {
+	var version int
+	if err := d.queryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return 0, fmt.Errorf("failed to read schema version: %w", err)
+	}
+	return version, nil
+}
+
+// migrate applies all pending migrations, each in its own transaction
+func (d *DB) migrate() error {
+	version, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+
+	for i := version; i < len(migrations); i++ {
+		tx, err := d.db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin migration %d: %w", i+1, err)
+		}
+
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to apply migration %d: %w", i+1, err)
+		}
+
+		// PRAGMA does not accept bound parameters
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", i+1)); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("failed to record migration %d: %w", i+1, err)
+		}
+
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit migration %d: %w", i+1, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Position is where a user stopped watching a video
+type Position struct {
+	VideoID   int64
+	User      string
+	Position  float64 // seconds, 0 when the video wasn't started
+	UpdatedAt time.Time
+}
+
+// initPositionsSchema creates the playback positions table
+func (d *DB) initPositionsSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS playback_positions (
+			user TEXT NOT NULL,
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			position REAL NOT NULL,
+			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user, video_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create playback_positions table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_playback_positions_video_id ON playback_positions(video_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create playback_positions index: %w", err)
+	}
+
+	return nil
+}
+
+// SetPosition stores where a user stopped watching a video
+func (d *DB) SetPosition(user string, videoID int64, position float64) error {
+	if position < 0 {
+		return fmt.Errorf("position must not be negative, got %v", position)
+	}
+
+	_, err := d.exec(`
+		INSERT INTO playback_positions (user, video_id, position, updated_at) VALUES (?, ?, ?, ?)
+		ON CONFLICT(user, video_id) DO UPDATE
+		SET position = excluded.position, updated_at = excluded.updated_at
+	`, user, videoID, position, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set playback position: %w", err)
+	}
+
+	return nil
+}
+
+// GetPosition retrieves where a user stopped watching a video. A zero
+// Position is returned when the user hasn't started the video.
+func (d *DB) GetPosition(user string, videoID int64) (*Position, error) {
+	p := &Position{VideoID: videoID, User: user}
+	err := d.queryRow(`
+		SELECT position, updated_at FROM playback_positions
+		WHERE user = ? AND video_id = ?
+	`, user, videoID).Scan(&p.Position, &p.UpdatedAt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("failed to get playback position: %w", err)
+	}
+
+	return p, nil
+}
+
+// ClearPosition forgets where a user stopped watching a video, such as
+// after watching it to the end
+func (d *DB) ClearPosition(user string, videoID int64) error {
+	_, err := d.exec("DELETE FROM playback_positions WHERE user = ? AND video_id = ?", user, videoID)
+	if err != nil {
+		return fmt.Errorf("failed to clear playback position: %w", err)
+	}
+
+	return nil
+}
+
+// PositionsForVideos retrieves where a user stopped watching a set of
+// videos, keyed by video ID. Videos the user hasn't started are absent from
+// the map.
+func (d *DB) PositionsForVideos(user string, videoIDs []int64) (map[int64]*Position, error) {
+	positions := make(map[int64]*Position, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return positions, nil
+	}
+
+	placeholders := make([]string, len(videoIDs))
+	args := []interface{}{user}
+	for i, id := range videoIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := d.query(`
+		SELECT video_id, user, position, updated_at
+		FROM playback_positions
+		WHERE user = ? AND video_id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get playback positions: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.VideoID, &p.User, &p.Position, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan playback position row: %w", err)
+		}
+		positions[p.VideoID] = &p
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating playback position rows: %w", err)
+	}
+
+	return positions, nil
+}
+
+// RecentPositions retrieves the videos a user stopped watching part way
+// through, most recently watched first, at most limit of them
+func (d *DB) RecentPositions(user string, limit int) ([]*Position, error) {
+	rows, err := d.query(`
+		SELECT video_id, user, position, updated_at
+		FROM playback_positions
+		WHERE user = ? AND position > 0
+		ORDER BY updated_at DESC
+		LIMIT ?
+	`, user, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get recent playback positions: %w", err)
+	}
+	defer rows.Close()
+
+	var positions []*Position
+	for rows.Next() {
+		var p Position
+		if err := rows.Scan(&p.VideoID, &p.User, &p.Position, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan playback position row: %w", err)
+		}
+		positions = append(positions, &p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating playback position rows: %w", err)
+	}
+
+	return positions, nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// Progress is how far the transcode of one variant of a video got. The
+// librarian records it while processing, so the server can report it.
+type Progress struct {
+	VideoID  int64
+	Variant  string
+	Position float64 // seconds of the source transcoded
+	// Speed is the transcoding speed relative to playback, 0 when unknown
+	Speed     float64
+	StartedAt time.Time
+	UpdatedAt time.Time
+}
+
+// initProgressSchema creates the processing progress table
+func (d *DB) initProgressSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS processing_progress (
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			variant TEXT NOT NULL,
+			position REAL NOT NULL DEFAULT 0,
+			speed REAL NOT NULL DEFAULT 0,
+			started_at TIMESTAMP NOT NULL,
+			updated_at TIMESTAMP NOT NULL,
+			PRIMARY KEY (video_id, variant)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create processing_progress table: %w", err)
+	}
+
+	return nil
+}
+
+// SetProgress records how far the transcode of a variant got
+func (d *DB) SetProgress(videoID int64, variant string, position, speed float64) error {
+	now := time.Now().UTC()
+	_, err := d.exec(`
+		INSERT INTO processing_progress (video_id, variant, position, speed, started_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT(video_id, variant) DO UPDATE
+		SET position = excluded.position, speed = excluded.speed, updated_at = excluded.updated_at
+	`, videoID, variant, position, speed, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to set processing progress: %w", err)
+	}
+
+	return nil
+}
+
+// ListProgress retrieves the progress of every variant of a video being
+// transcoded, ordered by variant
+func (d *DB) ListProgress(videoID int64) ([]*Progress, error) {
+	rows, err := d.query(`
+		SELECT video_id, variant, position, speed, started_at, updated_at
+		FROM processing_progress WHERE video_id = ? ORDER BY variant
+	`, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list processing progress: %w", err)
+	}
+	defer rows.Close()
+
+	var progress []*Progress
+	for rows.Next() {
+		p := &Progress{}
+		if err := rows.Scan(&p.VideoID, &p.Variant, &p.Position, &p.Speed, &p.StartedAt, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan processing progress: %w", err)
+		}
+		progress = append(progress, p)
+	}
+
+	return progress, rows.Err()
+}
+
+// ClearProgress forgets the progress of a video, once processing finished
+func (d *DB) ClearProgress(videoID int64) error {
+	if _, err := d.exec("DELETE FROM processing_progress WHERE video_id = ?", videoID); err != nil {
+		return fmt.Errorf("failed to clear processing progress: %w", err)
+	}
+
+	return nil
+}
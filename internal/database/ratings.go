@@ -0,0 +1,129 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Rating is a user's rating and favorite flag for a video
+type Rating struct {
+	VideoID   int64
+	User      string
+	Rating    int // 1-5, 0 when unrated
+	Favorite  bool
+	UpdatedAt time.Time
+}
+
+// initRatingsSchema creates the user ratings table
+func (d *DB) initRatingsSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS user_ratings (
+			user TEXT NOT NULL,
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			rating INTEGER,
+			favorite INTEGER NOT NULL DEFAULT 0,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (user, video_id)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_ratings table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_user_ratings_video_id ON user_ratings(video_id)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create user_ratings index: %w", err)
+	}
+
+	return nil
+}
+
+// SetRating sets a user's rating for a video. A rating of 0 clears it.
+func (d *DB) SetRating(user string, videoID int64, rating int) error {
+	if rating < 0 || rating > 5 {
+		return fmt.Errorf("rating must be between 0 and 5, got %d", rating)
+	}
+
+	_, err := d.exec(`
+		INSERT INTO user_ratings (user, video_id, rating) VALUES (?, ?, ?)
+		ON CONFLICT(user, video_id) DO UPDATE
+		SET rating = excluded.rating, updated_at = CURRENT_TIMESTAMP
+	`, user, videoID, sql.NullInt64{Int64: int64(rating), Valid: rating > 0})
+	if err != nil {
+		return fmt.Errorf("failed to set rating: %w", err)
+	}
+
+	return nil
+}
+
+// SetFavorite sets or clears a user's favorite flag for a video
+func (d *DB) SetFavorite(user string, videoID int64, favorite bool) error {
+	_, err := d.exec(`
+		INSERT INTO user_ratings (user, video_id, favorite) VALUES (?, ?, ?)
+		ON CONFLICT(user, video_id) DO UPDATE
+		SET favorite = excluded.favorite, updated_at = CURRENT_TIMESTAMP
+	`, user, videoID, favorite)
+	if err != nil {
+		return fmt.Errorf("failed to set favorite: %w", err)
+	}
+
+	return nil
+}
+
+// GetRating retrieves a user's rating for a video. A zero Rating is returned
+// when the user hasn't rated the video.
+func (d *DB) GetRating(user string, videoID int64) (*Rating, error) {
+	ratings, err := d.RatingsForVideos(user, []int64{videoID})
+	if err != nil {
+		return nil, err
+	}
+
+	if r, ok := ratings[videoID]; ok {
+		return r, nil
+	}
+	return &Rating{VideoID: videoID, User: user}, nil
+}
+
+// RatingsForVideos retrieves a user's ratings for a set of videos, keyed by
+// video ID. Videos the user hasn't rated are absent from the map.
+func (d *DB) RatingsForVideos(user string, videoIDs []int64) (map[int64]*Rating, error) {
+	ratings := make(map[int64]*Rating, len(videoIDs))
+	if len(videoIDs) == 0 {
+		return ratings, nil
+	}
+
+	placeholders := make([]string, len(videoIDs))
+	args := []interface{}{user}
+	for i, id := range videoIDs {
+		placeholders[i] = "?"
+		args = append(args, id)
+	}
+
+	rows, err := d.query(`
+		SELECT video_id, user, COALESCE(rating, 0), favorite, updated_at
+		FROM user_ratings
+		WHERE user = ? AND video_id IN (`+strings.Join(placeholders, ", ")+`)
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get ratings: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var r Rating
+		if err := rows.Scan(&r.VideoID, &r.User, &r.Rating, &r.Favorite, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan rating row: %w", err)
+		}
+		ratings[r.VideoID] = &r
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating rating rows: %w", err)
+	}
+
+	return ratings, nil
+}
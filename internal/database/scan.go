@@ -0,0 +1,139 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/safepath"
+)
+
+// scanInsertBatchSize is the number of rows inserted per INSERT statement
+const scanInsertBatchSize = 200
+
+// ScannedFile describes a video file found on disk during a library scan
+type ScannedFile struct {
+	Filename string
+	Path     string
+	Size     int64
+}
+
+// ScanSummary reports the changes applied by ReconcileScan
+type ScanSummary struct {
+	Added   int
+	Updated int
+	Removed []*Video
+}
+
+// ReconcileScan brings the videos table in line with the files found by a
+// scan of the media directory. New files are inserted, files whose size
+// changed are queued for reprocessing and videos whose file disappeared are
+// moved to the trash. All changes are applied in a single transaction, so
+// running the same scan twice is a no-op and a crash leaves the table as it
+// was. Videos below one of keepDirs are never removed, which is used for
+// media directories that looked unmounted.
+func (d *DB) ReconcileScan(files []ScannedFile, keepDirs []string) (*ScanSummary, error) {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin scan transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Load the current state of the library, including the trash so that
+	// trashed videos aren't re-added
+	rows, err := tx.Query("SELECT " + videoColumns + " FROM videos")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load library state: %w", err)
+	}
+	existing, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[string]*Video, len(existing))
+	for _, v := range existing {
+		known[v.Path] = v
+	}
+
+	summary := &ScanSummary{}
+	seen := make(map[string]bool, len(files))
+	var added []ScannedFile
+
+	for _, f := range files {
+		seen[f.Path] = true
+		v, ok := known[f.Path]
+		switch {
+		case !ok:
+			added = append(added, f)
+		case !v.DeletedAt.Valid && v.Size != f.Size:
+			_, err := tx.Exec(`
+				UPDATE videos SET size = ?, status = ?, error_message = NULL,
+				       retry_count = 0, failure_class = '', next_retry_at = NULL,
+				       updated_at = CURRENT_TIMESTAMP
+				WHERE id = ?
+			`, f.Size, StatusPending, v.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to update video %d: %w", v.ID, err)
+			}
+			summary.Updated++
+		}
+	}
+
+	for start := 0; start < len(added); start += scanInsertBatchSize {
+		end := min(start+scanInsertBatchSize, len(added))
+		if err := insertScannedBatch(tx, added[start:end]); err != nil {
+			return nil, err
+		}
+	}
+	summary.Added = len(added)
+
+	now := time.Now().UTC()
+	for _, v := range existing {
+		if seen[v.Path] || v.DeletedAt.Valid || withinAny(keepDirs, v.Path) {
+			continue
+		}
+		_, err := tx.Exec(
+			"UPDATE videos SET deleted_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
+			now, v.ID,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to remove video %d: %w", v.ID, err)
+		}
+		summary.Removed = append(summary.Removed, v)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit scan: %w", err)
+	}
+
+	return summary, nil
+}
+
+// withinAny reports whether path is below one of dirs
+func withinAny(dirs []string, path string) bool {
+	for _, dir := range dirs {
+		if safepath.Within(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// insertScannedBatch inserts a batch of new videos with a single statement
+func insertScannedBatch(tx *sql.Tx, files []ScannedFile) error {
+	placeholders := make([]string, len(files))
+	args := make([]interface{}, 0, len(files)*4)
+	for i, f := range files {
+		placeholders[i] = "(?, ?, ?, ?)"
+		args = append(args, f.Filename, f.Path, f.Size, StatusPending)
+	}
+
+	query := "INSERT INTO videos (filename, path, size, status) VALUES " +
+		strings.Join(placeholders, ", ") + " ON CONFLICT(path) DO NOTHING"
+	if _, err := tx.Exec(query, args...); err != nil {
+		return fmt.Errorf("failed to insert scanned videos: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// initSearchSchema creates the full text index of video filenames. The
+// index is kept in sync with the videos table by triggers and built from
+// the existing videos when it is created.
+func (d *DB) initSearchSchema() error {
+	var exists int
+	err := d.queryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'videos_fts'").Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("failed to check for the search index: %w", err)
+	}
+
+	stmts := []string{
+		`CREATE VIRTUAL TABLE IF NOT EXISTS videos_fts USING fts4(content="videos", filename, tokenize=unicode61)`,
+		`CREATE TRIGGER IF NOT EXISTS videos_fts_insert AFTER INSERT ON videos BEGIN
+			INSERT INTO videos_fts(docid, filename) VALUES (new.id, new.filename);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS videos_fts_before_update BEFORE UPDATE OF filename ON videos BEGIN
+			DELETE FROM videos_fts WHERE docid = old.id;
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS videos_fts_after_update AFTER UPDATE OF filename ON videos BEGIN
+			INSERT INTO videos_fts(docid, filename) VALUES (new.id, new.filename);
+		END`,
+		`CREATE TRIGGER IF NOT EXISTS videos_fts_delete BEFORE DELETE ON videos BEGIN
+			DELETE FROM videos_fts WHERE docid = old.id;
+		END`,
+	}
+	for _, stmt := range stmts {
+		if _, err := d.exec(stmt); err != nil {
+			return fmt.Errorf("failed to create search index: %w", err)
+		}
+	}
+
+	if exists == 0 {
+		if _, err := d.exec("INSERT INTO videos_fts(videos_fts) VALUES ('rebuild')"); err != nil {
+			return fmt.Errorf("failed to build search index: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// matchExpression turns a search query into a full text match expression
+// finding filenames that contain words starting with each of the query's
+// words. It returns "" if the query has no words.
+func matchExpression(query string) string {
+	words := strings.FieldsFunc(query, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for i, w := range words {
+		words[i] = w + "*"
+	}
+	return strings.Join(words, " ")
+}
@@ -0,0 +1,124 @@
+package database
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// initTagsSchema creates the video tags table
+func (d *DB) initTagsSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS video_tags (
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			tag TEXT NOT NULL COLLATE NOCASE,
+			PRIMARY KEY (video_id, tag)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create video_tags table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_video_tags_tag ON video_tags(tag)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create video_tags index: %w", err)
+	}
+
+	return nil
+}
+
+// SetTags replaces the tags of a video. Tags are trimmed, and empty and
+// duplicate tags are dropped.
+func (d *DB) SetTags(videoID int64, tags []string) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_tags WHERE video_id = ?", videoID); err != nil {
+		return fmt.Errorf("failed to clear tags: %w", err)
+	}
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if tag == "" {
+			continue
+		}
+		if _, err := tx.Exec("INSERT OR IGNORE INTO video_tags (video_id, tag) VALUES (?, ?)", videoID, tag); err != nil {
+			return fmt.Errorf("failed to add tag: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tags: %w", err)
+	}
+	return nil
+}
+
+// TagsForVideos retrieves the tags of several videos, keyed by video ID.
+// Videos without tags are missing from the map.
+func (d *DB) TagsForVideos(videoIDs []int64) (map[int64][]string, error) {
+	tags := make(map[int64][]string)
+	if len(videoIDs) == 0 {
+		return tags, nil
+	}
+
+	placeholders := make([]string, len(videoIDs))
+	args := make([]interface{}, len(videoIDs))
+	for i, id := range videoIDs {
+		placeholders[i] = "?"
+		args[i] = id
+	}
+
+	rows, err := d.query(`
+		SELECT video_id, tag FROM video_tags
+		WHERE video_id IN (`+strings.Join(placeholders, ", ")+`)
+		ORDER BY tag
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tags: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags[id] = append(tags[id], tag)
+	}
+
+	return tags, rows.Err()
+}
+
+// ListTags returns all tags in use, sorted
+func (d *DB) ListTags() ([]string, error) {
+	rows, err := d.query(`
+		SELECT DISTINCT video_tags.tag FROM video_tags
+		JOIN videos ON videos.id = video_tags.video_id AND videos.deleted_at IS NULL
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var tag string
+		if err := rows.Scan(&tag); err != nil {
+			return nil, fmt.Errorf("failed to scan tag: %w", err)
+		}
+		tags = append(tags, tag)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(tags, func(i, j int) bool {
+		return strings.ToLower(tags[i]) < strings.ToLower(tags[j])
+	})
+	return tags, nil
+}
@@ -0,0 +1,91 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"runtime"
+	"strings"
+
+	"github.com/kaero/streaming/internal/telemetry"
+)
+
+// maxStatementLength bounds the statements recorded in spans
+const maxStatementLength = 500
+
+// WithContext returns a DB whose queries are traced as children of the
+// span in ctx and are cancelled with it. The DB shares the connections of
+// d and must not be closed.
+func (d *DB) WithContext(ctx context.Context) *DB {
+	c := *d
+	c.ctx = ctx
+	return &c
+}
+
+// exec runs a statement that returns no rows
+func (d *DB) exec(query string, args ...any) (sql.Result, error) {
+	ctx, span := d.span(query)
+	defer span.End()
+	result, err := d.db.ExecContext(ctx, query, args...)
+	span.SetError(err)
+	return result, err
+}
+
+// query runs a query that returns rows
+func (d *DB) query(query string, args ...any) (*sql.Rows, error) {
+	ctx, span := d.span(query)
+	defer span.End()
+	rows, err := d.db.QueryContext(ctx, query, args...)
+	span.SetError(err)
+	return rows, err
+}
+
+// queryRow runs a query that returns at most one row
+func (d *DB) queryRow(query string, args ...any) *sql.Row {
+	ctx, span := d.span(query)
+	defer span.End()
+	return d.db.QueryRowContext(ctx, query, args...)
+}
+
+// stmtQuery runs a prepared query that returns rows
+func (d *DB) stmtQuery(stmt *sql.Stmt, args ...any) (*sql.Rows, error) {
+	ctx, span := d.span("")
+	defer span.End()
+	rows, err := stmt.QueryContext(ctx, args...)
+	span.SetError(err)
+	return rows, err
+}
+
+// stmtQueryRow runs a prepared query that returns at most one row
+func (d *DB) stmtQueryRow(stmt *sql.Stmt, args ...any) *sql.Row {
+	ctx, span := d.span("")
+	defer span.End()
+	return stmt.QueryRowContext(ctx, args...)
+}
+
+// span starts the span of a query run by a method of DB, named after the
+// method. Queries of a DB without a context aren't traced.
+func (d *DB) span(query string) (context.Context, *telemetry.Span) {
+	if d.ctx == nil {
+		return context.Background(), nil
+	}
+	if telemetry.SpanFromContext(d.ctx) == nil {
+		// Keep background queries out of traces of their own
+		return d.ctx, nil
+	}
+
+	name := "db"
+	if pc, _, _, ok := runtime.Caller(2); ok {
+		if fn := runtime.FuncForPC(pc); fn != nil {
+			name += " " + fn.Name()[strings.LastIndex(fn.Name(), ".")+1:]
+		}
+	}
+	kv := []any{"db.system", "sqlite"}
+	if query != "" {
+		statement := strings.Join(strings.Fields(query), " ")
+		if len(statement) > maxStatementLength {
+			statement = statement[:maxStatementLength] + "…"
+		}
+		kv = append(kv, "db.statement", statement)
+	}
+	return telemetry.Start(d.ctx, name, kv...)
+}
@@ -0,0 +1,150 @@
+package database
+
+import (
+	"fmt"
+)
+
+// Chapter is a chapter marker of a video, in seconds
+type Chapter struct {
+	VideoID int64
+	Start   float64
+	End     float64
+	Title   string
+}
+
+// SubtitleTrack is a subtitle stream embedded in a video
+type SubtitleTrack struct {
+	VideoID int64
+	// StreamIndex is the index of the stream within the source file
+	StreamIndex int
+	Codec       string
+	Language    string
+	Title       string
+	Default     bool
+	Forced      bool
+}
+
+// initTracksSchema creates the chapters and subtitle tracks tables
+func (d *DB) initTracksSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS video_chapters (
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			start_seconds REAL NOT NULL,
+			end_seconds REAL NOT NULL,
+			title TEXT NOT NULL DEFAULT ''
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create video_chapters table: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE INDEX IF NOT EXISTS idx_video_chapters_video_id ON video_chapters(video_id, start_seconds)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create video_chapters index: %w", err)
+	}
+
+	_, err = d.exec(`
+		CREATE TABLE IF NOT EXISTS video_subtitles (
+			video_id INTEGER NOT NULL REFERENCES videos(id) ON DELETE CASCADE,
+			stream_index INTEGER NOT NULL,
+			codec TEXT NOT NULL DEFAULT '',
+			language TEXT NOT NULL DEFAULT '',
+			title TEXT NOT NULL DEFAULT '',
+			is_default INTEGER NOT NULL DEFAULT 0,
+			forced INTEGER NOT NULL DEFAULT 0,
+			PRIMARY KEY (video_id, stream_index)
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create video_subtitles table: %w", err)
+	}
+
+	return nil
+}
+
+// SetVideoTracks replaces the chapters and subtitle tracks of a video with
+// those found by the probe step
+func (d *DB) SetVideoTracks(videoID int64, chapters []Chapter, subtitles []SubtitleTrack) error {
+	tx, err := d.db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("DELETE FROM video_chapters WHERE video_id = ?", videoID); err != nil {
+		return fmt.Errorf("failed to clear chapters: %w", err)
+	}
+	for _, c := range chapters {
+		_, err := tx.Exec("INSERT INTO video_chapters (video_id, start_seconds, end_seconds, title) VALUES (?, ?, ?, ?)",
+			videoID, c.Start, c.End, c.Title)
+		if err != nil {
+			return fmt.Errorf("failed to add chapter: %w", err)
+		}
+	}
+
+	if _, err := tx.Exec("DELETE FROM video_subtitles WHERE video_id = ?", videoID); err != nil {
+		return fmt.Errorf("failed to clear subtitle tracks: %w", err)
+	}
+	for _, s := range subtitles {
+		_, err := tx.Exec(`
+			INSERT INTO video_subtitles (video_id, stream_index, codec, language, title, is_default, forced)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, videoID, s.StreamIndex, s.Codec, s.Language, s.Title, s.Default, s.Forced)
+		if err != nil {
+			return fmt.Errorf("failed to add subtitle track: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit tracks: %w", err)
+	}
+	return nil
+}
+
+// ListChapters retrieves the chapters of a video in order
+func (d *DB) ListChapters(videoID int64) ([]*Chapter, error) {
+	rows, err := d.query(`
+		SELECT video_id, start_seconds, end_seconds, title FROM video_chapters
+		WHERE video_id = ? ORDER BY start_seconds
+	`, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list chapters: %w", err)
+	}
+	defer rows.Close()
+
+	var chapters []*Chapter
+	for rows.Next() {
+		c := &Chapter{}
+		if err := rows.Scan(&c.VideoID, &c.Start, &c.End, &c.Title); err != nil {
+			return nil, fmt.Errorf("failed to scan chapter: %w", err)
+		}
+		chapters = append(chapters, c)
+	}
+
+	return chapters, rows.Err()
+}
+
+// ListSubtitleTracks retrieves the subtitle tracks of a video in stream order
+func (d *DB) ListSubtitleTracks(videoID int64) ([]*SubtitleTrack, error) {
+	rows, err := d.query(`
+		SELECT video_id, stream_index, codec, language, title, is_default, forced
+		FROM video_subtitles WHERE video_id = ? ORDER BY stream_index
+	`, videoID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list subtitle tracks: %w", err)
+	}
+	defer rows.Close()
+
+	var tracks []*SubtitleTrack
+	for rows.Next() {
+		s := &SubtitleTrack{}
+		if err := rows.Scan(&s.VideoID, &s.StreamIndex, &s.Codec, &s.Language, &s.Title, &s.Default, &s.Forced); err != nil {
+			return nil, fmt.Errorf("failed to scan subtitle track: %w", err)
+		}
+		tracks = append(tracks, s)
+	}
+
+	return tracks, rows.Err()
+}
@@ -0,0 +1,107 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// SoftDeleteVideo moves a video to the trash. The row and its history are
+// kept until PurgeDeletedVideos removes them.
+func (d *DB) SoftDeleteVideo(id int64) error {
+	result, err := d.exec(
+		"UPDATE videos SET deleted_at = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ? AND deleted_at IS NULL",
+		time.Now().UTC(), id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to soft-delete video: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("video %d not found", id)
+	}
+
+	return nil
+}
+
+// RestoreVideo moves a video out of the trash. The video is reset to pending
+// because its cache was removed when it was deleted.
+func (d *DB) RestoreVideo(id int64) error {
+	result, err := d.exec(`
+		UPDATE videos
+		SET deleted_at = NULL, status = ?, error_message = NULL, retry_count = 0,
+		    failure_class = '', next_retry_at = NULL, updated_at = CURRENT_TIMESTAMP
+		WHERE id = ? AND deleted_at IS NOT NULL
+	`, StatusPending, id)
+	if err != nil {
+		return fmt.Errorf("failed to restore video: %w", err)
+	}
+
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("video %d not found in trash", id)
+	}
+
+	return nil
+}
+
+// GetDeletedVideo retrieves a video in the trash by its ID, nil when
+// there is none
+func (d *DB) GetDeletedVideo(id int64) (*Video, error) {
+	video, err := scanVideo(d.queryRow(
+		"SELECT "+videoColumns+" FROM videos WHERE id = ? AND deleted_at IS NOT NULL", id,
+	))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get deleted video: %w", err)
+	}
+	return video, nil
+}
+
+// ListDeletedVideos retrieves all videos in the trash, most recently deleted first
+func (d *DB) ListDeletedVideos() ([]*Video, error) {
+	rows, err := d.query(
+		"SELECT " + videoColumns + " FROM videos WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC",
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list deleted videos: %w", err)
+	}
+
+	return scanVideos(rows)
+}
+
+// PurgeDeletedVideos permanently removes videos that have been in the trash
+// for longer than retention and returns the videos removed
+func (d *DB) PurgeDeletedVideos(retention time.Duration) ([]*Video, error) {
+	cutoff := time.Now().UTC().Add(-retention)
+
+	tx, err := d.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin purge transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		"SELECT "+videoColumns+" FROM videos WHERE deleted_at IS NOT NULL AND deleted_at < ?", cutoff,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find expired videos: %w", err)
+	}
+	videos, err := scanVideos(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range videos {
+		if _, err := tx.Exec("DELETE FROM videos WHERE id = ?", v.ID); err != nil {
+			return nil, fmt.Errorf("failed to purge video %d: %w", v.ID, err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit purge: %w", err)
+	}
+
+	return videos, nil
+}
@@ -0,0 +1,156 @@
+package database
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTrash(t *testing.T) {
+	db := newTestDB(t)
+
+	kept, err := db.AddVideo("kept.mkv", "/media/kept.mkv", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	trashed, err := db.AddVideo("trashed.mkv", "/media/trashed.mkv", 200)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SetVideoError(trashed, "broken"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.SoftDeleteVideo(trashed); err != nil {
+		t.Fatalf("SoftDeleteVideo: %v", err)
+	}
+	if err := db.SoftDeleteVideo(trashed); err == nil {
+		t.Error("SoftDeleteVideo of a video in the trash succeeded")
+	}
+
+	deleted, err := db.ListDeletedVideos()
+	if err != nil {
+		t.Fatalf("ListDeletedVideos: %v", err)
+	}
+	if len(deleted) != 1 || deleted[0].ID != trashed || !deleted[0].DeletedAt.Valid {
+		t.Fatalf("ListDeletedVideos = %+v, want only video %d", deleted, trashed)
+	}
+
+	if err := db.RestoreVideo(kept); err == nil {
+		t.Error("RestoreVideo of a video not in the trash succeeded")
+	}
+	if err := db.RestoreVideo(trashed); err != nil {
+		t.Fatalf("RestoreVideo: %v", err)
+	}
+	v, err := db.GetVideo(trashed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.DeletedAt.Valid || v.Status != StatusPending || v.ErrorMessage.Valid {
+		t.Errorf("restored video has deleted_at %v, status %s and error %q, want a pending video", v.DeletedAt, v.Status, v.ErrorMessage.String)
+	}
+	if deleted, _ := db.ListDeletedVideos(); len(deleted) != 0 {
+		t.Errorf("trash holds %d videos after restoring, want none", len(deleted))
+	}
+}
+
+func TestPurgeDeletedVideos(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.AddVideo("old.mkv", "/media/old.mkv", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := db.SoftDeleteVideo(id); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := db.PurgeDeletedVideos(time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeletedVideos: %v", err)
+	}
+	if len(purged) != 0 {
+		t.Fatalf("purged %d videos deleted just now, want none", len(purged))
+	}
+
+	purged, err = db.PurgeDeletedVideos(-time.Hour)
+	if err != nil {
+		t.Fatalf("PurgeDeletedVideos: %v", err)
+	}
+	if len(purged) != 1 || purged[0].ID != id {
+		t.Fatalf("PurgeDeletedVideos = %+v, want video %d", purged, id)
+	}
+	if _, err := db.GetVideo(id); err == nil {
+		t.Error("purged video still exists")
+	}
+}
+
+func TestGetDeletedVideo(t *testing.T) {
+	db := newTestDB(t)
+
+	id, err := db.AddVideo("movie.mkv", "/media/movie.mkv", 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.GetDeletedVideo(id); err != nil || v != nil {
+		t.Fatalf("GetDeletedVideo of a video not in the trash = %v, %v, want nil", v, err)
+	}
+	if err := db.SoftDeleteVideo(id); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := db.GetDeletedVideo(id); err != nil || v == nil || v.ID != id {
+		t.Fatalf("GetDeletedVideo = %v, %v, want video %d", v, err, id)
+	}
+}
+
+func TestTrashIndex(t *testing.T) {
+	// A database with the full index on deleted_at of before migration 11
+	path := filepath.Join(t.TempDir(), "library.db")
+	db, err := New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, stmt := range []string{
+		"DROP INDEX idx_videos_trash",
+		"CREATE INDEX idx_videos_deleted_at ON videos(deleted_at)",
+		"PRAGMA user_version = 10",
+	} {
+		if _, err := db.db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Close()
+
+	db, err = New(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	var indexes []string
+	rows, err := db.db.Query("SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'videos' AND name LIKE 'idx_videos_%'")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		rows.Scan(&name)
+		indexes = append(indexes, name)
+	}
+	rows.Close()
+	list := strings.Join(indexes, " ")
+	if strings.Contains(list, "idx_videos_deleted_at") || !strings.Contains(list, "idx_videos_trash") {
+		t.Errorf("indexes after migrating are %s, want idx_videos_trash instead of idx_videos_deleted_at", list)
+	}
+
+	// The trash is read through the partial index, live videos aren't
+	plan := queryPlan(t, db, "SELECT id FROM videos WHERE deleted_at IS NOT NULL ORDER BY deleted_at DESC")
+	if !strings.Contains(plan, "idx_videos_trash") {
+		t.Errorf("listing the trash doesn't use idx_videos_trash:\n%s", plan)
+	}
+	plan = queryPlan(t, db, "SELECT id FROM videos WHERE deleted_at IS NULL ORDER BY filename, id")
+	if strings.Contains(plan, "idx_videos_trash") {
+		t.Errorf("listing live videos uses idx_videos_trash:\n%s", plan)
+	}
+}
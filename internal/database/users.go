@@ -0,0 +1,164 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrUserNotFound is returned when no user matches a lookup
+var ErrUserNotFound = errors.New("user not found")
+
+// User is an account for the built-in login
+type User struct {
+	ID           int64
+	Username     string
+	PasswordHash string
+	Role         string
+	CreatedAt    time.Time
+	LastLoginAt  sql.NullTime
+}
+
+// initUsersSchema creates the users table
+func (d *DB) initUsersSchema() error {
+	_, err := d.exec(`
+		CREATE TABLE IF NOT EXISTS users (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			username TEXT NOT NULL UNIQUE COLLATE NOCASE,
+			password_hash TEXT NOT NULL,
+			role TEXT NOT NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			last_login_at TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("failed to create users table: %w", err)
+	}
+
+	return nil
+}
+
+// CreateUser stores a new user with an already hashed password
+func (d *DB) CreateUser(username, passwordHash, role string) (*User, error) {
+	result, err := d.exec(
+		"INSERT INTO users (username, password_hash, role) VALUES (?, ?, ?)",
+		username, passwordHash, role,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get last insert ID: %w", err)
+	}
+
+	return d.getUser("id = ?", id)
+}
+
+// GetUser retrieves a user by ID
+func (d *DB) GetUser(id int64) (*User, error) {
+	return d.getUser("id = ?", id)
+}
+
+// GetUserByName retrieves a user by username, ignoring case
+func (d *DB) GetUserByName(username string) (*User, error) {
+	return d.getUser("username = ?", username)
+}
+
+// ListUsers retrieves all users ordered by username
+func (d *DB) ListUsers() ([]*User, error) {
+	rows, err := d.query(`
+		SELECT id, username, password_hash, role, created_at, last_login_at
+		FROM users
+		ORDER BY username
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+	defer rows.Close()
+
+	var users []*User
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan user row: %w", err)
+		}
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating user rows: %w", err)
+	}
+
+	return users, nil
+}
+
+// UpdateUser changes the password hash and role of a user
+func (d *DB) UpdateUser(id int64, passwordHash, role string) error {
+	result, err := d.exec(
+		"UPDATE users SET password_hash = ?, role = ? WHERE id = ?",
+		passwordHash, role, id,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// DeleteUser removes a user
+func (d *DB) DeleteUser(id int64) error {
+	result, err := d.exec("DELETE FROM users WHERE id = ?", id)
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %w", err)
+	}
+
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
+// TouchUserLogin records that a user logged in
+func (d *DB) TouchUserLogin(id int64, at time.Time) error {
+	_, err := d.exec("UPDATE users SET last_login_at = ? WHERE id = ?", at.UTC(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update user login: %w", err)
+	}
+
+	return nil
+}
+
+// getUser retrieves a single user matching a condition
+func (d *DB) getUser(cond string, arg interface{}) (*User, error) {
+	row := d.queryRow(`
+		SELECT id, username, password_hash, role, created_at, last_login_at
+		FROM users
+		WHERE `+cond, arg)
+
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return nil, ErrUserNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user: %w", err)
+	}
+
+	return user, nil
+}
+
+// scanUser scans a users row
+func scanUser(row rowScanner) (*User, error) {
+	var user User
+	if err := row.Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Role, &user.CreatedAt, &user.LastLoginAt); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,261 @@
+// Package dav shares the local media directories over WebDAV, so users can
+// manage the files from Finder, Explorer and other file managers. Changes
+// go through the library: written videos are added and processed like
+// uploads, while moved and deleted videos keep or lose their processed
+// output along with the file.
+package dav
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/net/webdav"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/control"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/safepath"
+)
+
+// Prefix is the path the share is served under
+const Prefix = "/webdav"
+
+// Server answers the WebDAV requests of file managers
+type Server struct {
+	cfg  *config.Config
+	db   *database.DB
+	lm   *library.Manager
+	jobs control.Jobs
+	log  *slog.Logger
+	// started is reported as the modification time of the root listing
+	// the libraries, which has no directory of its own
+	started time.Time
+	handler *webdav.Handler
+}
+
+// New creates the WebDAV server. Videos written to the share are processed
+// by the librarian through jobs.
+func New(cfg *config.Config, db *database.DB, lm *library.Manager, jobs control.Jobs, logger *slog.Logger) *Server {
+	s := &Server{
+		cfg:     cfg,
+		db:      db,
+		lm:      lm,
+		jobs:    jobs,
+		log:     logger,
+		started: time.Now(),
+	}
+	s.handler = &webdav.Handler{
+		Prefix:     cfg.Server.Path(Prefix),
+		FileSystem: fileSystem{s},
+		LockSystem: webdav.NewMemLS(),
+		Logger: func(r *http.Request, err error) {
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				s.log.WarnContext(r.Context(), "WebDAV request failed", "method", r.Method, "path", r.URL.Path, "err", err)
+			}
+		},
+	}
+	return s
+}
+
+// ServeHTTP serves a WebDAV request. The base path is put back in front of
+// the request path, as the links in responses need to include it.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = s.cfg.Server.Path(r.URL.Path)
+	r2.URL.RawPath = ""
+	s.handler.ServeHTTP(w, r2)
+}
+
+// libraries returns the local libraries the user of a request may see.
+// Remote libraries are read through their source and aren't shared.
+func (s *Server) libraries(ctx context.Context) []config.MediaLibrary {
+	p := auth.FromContext(ctx)
+	var libs []config.MediaLibrary
+	for _, lib := range s.cfg.Libraries {
+		if lib.Remote() {
+			continue
+		}
+		if p != nil && (p.Has(auth.ScopeAdmin) || lib.Allows(p.Name)) {
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
+
+// resolve maps a WebDAV path to a file in a library the user may see,
+// returning "" for the root listing the libraries when libraries are
+// configured. Unknown libraries and those the user can't see don't exist,
+// and symlinks can't lead out of the media directories.
+func (s *Server) resolve(ctx context.Context, name string) (string, error) {
+	name = strings.TrimPrefix(path.Clean("/"+name), "/")
+
+	root, rest := s.cfg.Media.MediaDir, name
+	if len(s.cfg.Libraries) > 0 {
+		if name == "" {
+			return "", nil
+		}
+		libName, libRest, _ := strings.Cut(name, "/")
+		root, rest = "", libRest
+		for _, lib := range s.libraries(ctx) {
+			if lib.Name == libName {
+				root = lib.MediaDir
+			}
+		}
+		if root == "" {
+			return "", os.ErrNotExist
+		}
+	}
+
+	p, err := safepath.Join(root, rest)
+	if err != nil {
+		return "", os.ErrPermission
+	}
+	// New files are checked by their directory
+	_, err = safepath.Resolve(root, rest)
+	if errors.Is(err, os.ErrNotExist) {
+		_, err = safepath.Resolve(root, path.Dir(rest))
+	}
+	if errors.Is(err, safepath.ErrInvalidPath) {
+		return "", os.ErrPermission
+	}
+	return p, nil
+}
+
+// isRoot reports whether a resolved path is the root of the share or of a
+// library, which can't be moved or deleted
+func (s *Server) isRoot(p string) bool {
+	if p == "" {
+		return true
+	}
+	for _, lib := range s.cfg.MediaLibraries() {
+		if !lib.Remote() && filepath.Clean(lib.MediaDir) == filepath.Clean(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// actor returns the name of the user of a request for the audit log
+func actor(ctx context.Context) string {
+	if p := auth.FromContext(ctx); p != nil {
+		return p.Name
+	}
+	return "anonymous"
+}
+
+// fileSystem maps the share onto the media directories
+type fileSystem struct {
+	s *Server
+}
+
+// Mkdir creates a directory
+func (fs fileSystem) Mkdir(ctx context.Context, name string, perm os.FileMode) error {
+	p, err := fs.s.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if p == "" {
+		return os.ErrExist
+	}
+	return os.Mkdir(p, perm)
+}
+
+// OpenFile opens a file. Video files written from the start are staged
+// and added to the library when they are closed.
+func (fs fileSystem) OpenFile(ctx context.Context, name string, flag int, perm os.FileMode) (webdav.File, error) {
+	p, err := fs.s.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE) != 0 {
+			return nil, os.ErrPermission
+		}
+		return &rootDir{s: fs.s, libs: fs.s.libraries(ctx)}, nil
+	}
+
+	if flag&os.O_TRUNC != 0 && library.IsVideoFile(strings.ToLower(filepath.Ext(p))) {
+		return fs.s.createVideo(ctx, p, flag)
+	}
+	f, err := os.OpenFile(p, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := f.Stat(); err == nil && info.IsDir() {
+		return dir{f}, nil
+	}
+	return f, nil
+}
+
+// RemoveAll deletes a file or a directory with everything in it. Videos are
+// deleted through the library, which removes their processed output too.
+func (fs fileSystem) RemoveAll(ctx context.Context, name string) error {
+	p, err := fs.s.resolve(ctx, name)
+	if err != nil {
+		return err
+	}
+	if fs.s.isRoot(p) {
+		return os.ErrPermission
+	}
+
+	err = filepath.WalkDir(p, func(file string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		video, err := fs.s.db.GetVideoByPath(file)
+		if err != nil || video == nil {
+			return err
+		}
+		return fs.s.lm.DeleteVideo(video.ID, actor(ctx), true)
+	})
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return os.RemoveAll(p)
+}
+
+// Rename moves a file or directory. Moved videos keep their processed
+// output and history.
+func (fs fileSystem) Rename(ctx context.Context, oldName, newName string) error {
+	oldPath, err := fs.s.resolve(ctx, oldName)
+	if err != nil {
+		return err
+	}
+	newPath, err := fs.s.resolve(ctx, newName)
+	if err != nil {
+		return err
+	}
+	if fs.s.isRoot(oldPath) || fs.s.isRoot(newPath) {
+		return os.ErrPermission
+	}
+
+	if err := os.Rename(oldPath, newPath); err != nil {
+		return err
+	}
+	if err := fs.s.db.MoveVideos(oldPath, newPath); err != nil {
+		return err
+	}
+	fs.s.log.InfoContext(ctx, "Moved over WebDAV", "from", oldPath, "to", newPath, "user", actor(ctx))
+	return nil
+}
+
+// Stat describes a file
+func (fs fileSystem) Stat(ctx context.Context, name string) (os.FileInfo, error) {
+	p, err := fs.s.resolve(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if p == "" {
+		return rootInfo{modTime: fs.s.started}, nil
+	}
+	return os.Stat(p)
+}
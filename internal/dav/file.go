@@ -0,0 +1,166 @@
+package dav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/control"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// upload is a video file being written. The data goes to a hidden
+// temporary file next to it, which replaces the file when it is closed, so
+// the librarian never picks up a partial video.
+type upload struct {
+	*os.File
+	s    *Server
+	ctx  context.Context
+	dest string
+}
+
+// createVideo starts writing a video file
+func (s *Server) createVideo(ctx context.Context, p string, flag int) (*upload, error) {
+	if flag&os.O_EXCL != 0 {
+		if _, err := os.Stat(p); err == nil {
+			return nil, os.ErrExist
+		}
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(p), "."+filepath.Base(p)+".*.part")
+	if err != nil {
+		return nil, err
+	}
+	return &upload{File: f, s: s, ctx: ctx, dest: p}, nil
+}
+
+// Close moves the written file into place and adds it to the library
+func (u *upload) Close() error {
+	tmp := u.File.Name()
+	info, err := u.File.Stat()
+	if closeErr := u.File.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return u.s.ingest(u.ctx, tmp, u.dest, info.Size())
+}
+
+// ingest moves a written video file into place and adds it to the library
+// like an upload through the API, or queues the video it replaced for
+// processing again. The video is added before the file appears so the
+// librarian's file watcher doesn't add it a second time. Empty files,
+// which file managers create before writing the data, are only put in
+// place.
+func (s *Server) ingest(ctx context.Context, tmp, dest string, size int64) error {
+	if size == 0 {
+		return os.Rename(tmp, dest)
+	}
+
+	id, err := s.db.PutVideo(filepath.Base(dest), dest, size)
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move upload into place: %w", err)
+	}
+
+	s.log.InfoContext(ctx, "Received video over WebDAV", "path", dest, "id", id)
+	if err := s.db.LogEvent(database.EventUpload, id, actor(ctx), "uploaded over WebDAV to "+dest); err != nil {
+		s.log.ErrorContext(ctx, "Error recording upload event", "err", err)
+	}
+	// When the librarian can't be reached, it picks the video up on its
+	// next run
+	if _, err := s.jobs.Process(ctx); err != nil && !errors.Is(err, control.ErrDisabled) {
+		s.log.WarnContext(ctx, "Error requesting processing from the librarian", "err", err)
+	}
+	return nil
+}
+
+// dir is a directory in a media directory. Hidden files, such as staged
+// uploads and the metadata files of Finder, are left out of listings but
+// can still be opened by name.
+type dir struct {
+	*os.File
+}
+
+// Readdir lists the directory without hidden files
+func (d dir) Readdir(count int) ([]fs.FileInfo, error) {
+	infos, err := d.File.Readdir(count)
+	visible := infos[:0]
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			visible = append(visible, info)
+		}
+	}
+	return visible, err
+}
+
+// rootDir is the root of the share when libraries are configured: a
+// read-only directory listing the libraries the user may see
+type rootDir struct {
+	s      *Server
+	libs   []config.MediaLibrary
+	listed bool
+}
+
+func (d *rootDir) Close() error                   { return nil }
+func (d *rootDir) Read([]byte) (int, error)       { return 0, os.ErrInvalid }
+func (d *rootDir) Seek(int64, int) (int64, error) { return 0, os.ErrInvalid }
+func (d *rootDir) Write([]byte) (int, error)      { return 0, os.ErrPermission }
+func (d *rootDir) Stat() (fs.FileInfo, error)     { return rootInfo{modTime: d.s.started}, nil }
+
+// Readdir lists the libraries as directories. Libraries whose media
+// directory is missing, such as an unmounted disk, are left out.
+func (d *rootDir) Readdir(count int) ([]fs.FileInfo, error) {
+	if d.listed && count > 0 {
+		return nil, io.EOF
+	}
+	d.listed = true
+
+	infos := []fs.FileInfo{}
+	for _, lib := range d.libs {
+		info, err := os.Stat(lib.MediaDir)
+		if err != nil || !info.IsDir() {
+			continue
+		}
+		infos = append(infos, libraryInfo{FileInfo: info, name: lib.Name})
+	}
+	return infos, nil
+}
+
+// rootInfo describes the root of the share
+type rootInfo struct {
+	modTime time.Time
+}
+
+func (rootInfo) Name() string         { return "/" }
+func (rootInfo) Size() int64          { return 0 }
+func (rootInfo) Mode() fs.FileMode    { return fs.ModeDir | 0555 }
+func (i rootInfo) ModTime() time.Time { return i.modTime }
+func (rootInfo) IsDir() bool          { return true }
+func (rootInfo) Sys() any             { return nil }
+
+// libraryInfo describes the media directory of a library under the name
+// of the library
+type libraryInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i libraryInfo) Name() string { return i.name }
@@ -0,0 +1,212 @@
+// Package device detects client devices and limits master playlists to
+// the variants they can play.
+package device
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kaero/streaming/config"
+)
+
+// builtin are the profiles available without configuration. Configured
+// profiles with the same name replace them.
+var builtin = []config.DeviceProfile{
+	{
+		// Chromecasts before the Ultra decode neither HEVC nor 4K
+		Name:          "chromecast",
+		UserAgents:    []string{"CrKey"},
+		MaxHeight:     1080,
+		ExcludeCodecs: []string{"hvc1", "hev1"},
+	},
+	{
+		// Anything above 1080p is wasted on a phone screen
+		Name:       "phone",
+		UserAgents: []string{"iPhone", "Mobile"},
+		MaxHeight:  1080,
+	},
+}
+
+// Profiles are the known device profiles, configured ones first
+type Profiles struct {
+	mu       sync.RWMutex
+	profiles []config.DeviceProfile
+}
+
+// New validates the configured profiles and combines them with the
+// built-in ones
+func New(configured []config.DeviceProfile) (*Profiles, error) {
+	p := &Profiles{}
+	if err := p.Replace(configured); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Replace validates newly configured profiles and uses them instead of
+// the current ones. Nothing changes if they are invalid.
+func (p *Profiles) Replace(configured []config.DeviceProfile) error {
+	var profiles []config.DeviceProfile
+	names := make(map[string]bool)
+
+	for _, d := range configured {
+		if d.Name == "" {
+			return fmt.Errorf("device profile needs a name")
+		}
+		if names[d.Name] {
+			return fmt.Errorf("device profile %q is configured twice", d.Name)
+		}
+		if d.MaxHeight < 0 || d.MaxBitrateKbps < 0 {
+			return fmt.Errorf("device profile %q has a negative limit", d.Name)
+		}
+		names[d.Name] = true
+		profiles = append(profiles, d)
+	}
+
+	for _, d := range builtin {
+		if !names[d.Name] {
+			profiles = append(profiles, d)
+		}
+	}
+
+	p.mu.Lock()
+	p.profiles = profiles
+	p.mu.Unlock()
+	return nil
+}
+
+// List returns all profiles in the order they are matched in
+func (p *Profiles) List() []config.DeviceProfile {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.profiles
+}
+
+// Get returns the profile with a name
+func (p *Profiles) Get(name string) (config.DeviceProfile, bool) {
+	for _, d := range p.List() {
+		if d.Name == name {
+			return d, true
+		}
+	}
+	return config.DeviceProfile{}, false
+}
+
+// Detect returns the first profile matching a User-Agent header
+func (p *Profiles) Detect(userAgent string) (config.DeviceProfile, bool) {
+	ua := strings.ToLower(userAgent)
+	for _, d := range p.List() {
+		for _, s := range d.UserAgents {
+			if s != "" && strings.Contains(ua, strings.ToLower(s)) {
+				return d, true
+			}
+		}
+	}
+	return config.DeviceProfile{}, false
+}
+
+// IsMasterPlaylist reports whether an HLS playlist lists variants
+func IsMasterPlaylist(playlist []byte) bool {
+	return bytes.Contains(playlist, []byte("#EXT-X-STREAM-INF:"))
+}
+
+// variant is an #EXT-X-STREAM-INF entry of a master playlist
+type variant struct {
+	lines     []string // the tag and the URI
+	height    int
+	bandwidth int
+	codecs    string
+}
+
+// Filter removes the variants a device can't play from a master playlist.
+// Attributes missing from the playlist don't limit it. The smallest variant
+// is kept if none fit, as an empty playlist can't be played at all.
+func Filter(playlist []byte, d config.DeviceProfile) []byte {
+	var out []string
+	var variants []variant
+	keptAny := false
+
+	scanner := bufio.NewScanner(bytes.NewReader(playlist))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "#EXT-X-STREAM-INF:") {
+			out = append(out, line)
+			continue
+		}
+
+		// The URI is the next line
+		v := parseStreamInf(line)
+		v.lines = []string{line}
+		if scanner.Scan() {
+			v.lines = append(v.lines, scanner.Text())
+		}
+		variants = append(variants, v)
+
+		if fits(v, d) {
+			out = append(out, v.lines...)
+			keptAny = true
+		}
+	}
+
+	if !keptAny && len(variants) > 0 {
+		smallest := variants[0]
+		for _, v := range variants[1:] {
+			if v.bandwidth < smallest.bandwidth {
+				smallest = v
+			}
+		}
+		out = append(out, smallest.lines...)
+	}
+
+	return []byte(strings.Join(out, "\n") + "\n")
+}
+
+// fits reports whether a device can play a variant
+func fits(v variant, d config.DeviceProfile) bool {
+	if d.MaxHeight > 0 && v.height > d.MaxHeight {
+		return false
+	}
+	if d.MaxBitrateKbps > 0 && v.bandwidth > d.MaxBitrateKbps*1000 {
+		return false
+	}
+	for _, c := range d.ExcludeCodecs {
+		if c != "" && strings.Contains(strings.ToLower(v.codecs), strings.ToLower(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseStreamInf reads the attributes of an #EXT-X-STREAM-INF tag that
+// device profiles can limit
+func parseStreamInf(line string) variant {
+	var v variant
+	attrs := strings.TrimPrefix(line, "#EXT-X-STREAM-INF:")
+	for attrs != "" {
+		var key, value string
+		key, attrs, _ = strings.Cut(attrs, "=")
+		if strings.HasPrefix(attrs, `"`) {
+			// Quoted values such as CODECS contain commas
+			value, attrs, _ = strings.Cut(attrs[1:], `"`)
+			attrs = strings.TrimPrefix(attrs, ",")
+		} else {
+			value, attrs, _ = strings.Cut(attrs, ",")
+		}
+
+		switch strings.TrimSpace(key) {
+		case "BANDWIDTH":
+			v.bandwidth, _ = strconv.Atoi(value)
+		case "RESOLUTION":
+			if _, h, ok := strings.Cut(value, "x"); ok {
+				v.height, _ = strconv.Atoi(h)
+			}
+		case "CODECS":
+			v.codecs = value
+		}
+	}
+	return v
+}
@@ -0,0 +1,474 @@
+package dlna
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/storage"
+)
+
+// Object IDs of the ContentDirectory: the root, a container per library
+// and an item per video
+const (
+	rootID          = "0"
+	libraryIDPrefix = "library:"
+	videoIDPrefix   = "video:"
+)
+
+// UPnP error codes of SOAP faults
+const (
+	errInvalidAction     = 401
+	errInvalidArgs       = 402
+	errActionFailed      = 501
+	errNoSuchObject      = 701
+	errInvalidConnection = 706
+	errCannotProcess     = 720
+)
+
+// soapEnvelopeSpace is the namespace of SOAP envelopes
+const soapEnvelopeSpace = "http://schemas.xmlsoap.org/soap/envelope/"
+
+// dlnaFlags mark source files as streamable with byte range seeking
+const dlnaFlags = "DLNA.ORG_OP=01;DLNA.ORG_CI=0;DLNA.ORG_FLAGS=01700000000000000000000000000000"
+
+// hlsContentType is the content type of the HLS master playlists offered
+// next to the source files
+const hlsContentType = "application/vnd.apple.mpegurl"
+
+// upnpError is a failed action, answered with a SOAP fault
+type upnpError struct {
+	code int
+	desc string
+}
+
+// soapRequest is the envelope of an action call
+type soapRequest struct {
+	Body struct {
+		Action struct {
+			XMLName xml.Name
+			Args    []struct {
+				XMLName xml.Name
+				Value   string `xml:",chardata"`
+			} `xml:",any"`
+		} `xml:",any"`
+	} `xml:"http://schemas.xmlsoap.org/soap/envelope/ Body"`
+}
+
+// arg is an output argument of an action, in the order of the SCPD
+type arg struct {
+	name, value string
+}
+
+// action handles a SOAP action of a service given its input arguments
+type action func(r *http.Request, args map[string]string) ([]arg, *upnpError)
+
+// ContentDirectoryHandler answers the actions of the ContentDirectory
+// service, which clients browse the libraries with
+func (s *Server) ContentDirectoryHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveSOAP(w, r, contentDirectoryType, map[string]action{
+		"Browse": s.browse,
+		"GetSearchCapabilities": func(*http.Request, map[string]string) ([]arg, *upnpError) {
+			return []arg{{"SearchCaps", ""}}, nil
+		},
+		"GetSortCapabilities": func(*http.Request, map[string]string) ([]arg, *upnpError) {
+			return []arg{{"SortCaps", ""}}, nil
+		},
+		"GetSystemUpdateID": func(*http.Request, map[string]string) ([]arg, *upnpError) {
+			return []arg{{"Id", "0"}}, nil
+		},
+	})
+}
+
+// ConnectionManagerHandler answers the actions of the ConnectionManager
+// service. The server only has the implicit connection 0.
+func (s *Server) ConnectionManagerHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveSOAP(w, r, connectionManagerType, map[string]action{
+		"GetProtocolInfo": func(*http.Request, map[string]string) ([]arg, *upnpError) {
+			return []arg{{"Source", protocolInfos()}, {"Sink", ""}}, nil
+		},
+		"GetCurrentConnectionIDs": func(*http.Request, map[string]string) ([]arg, *upnpError) {
+			return []arg{{"ConnectionIDs", "0"}}, nil
+		},
+		"GetCurrentConnectionInfo": func(_ *http.Request, args map[string]string) ([]arg, *upnpError) {
+			if args["ConnectionID"] != "0" {
+				return nil, &upnpError{errInvalidConnection, "Invalid connection reference"}
+			}
+			return []arg{
+				{"RcsID", "-1"},
+				{"AVTransportID", "-1"},
+				{"ProtocolInfo", ""},
+				{"PeerConnectionManager", ""},
+				{"PeerConnectionID", "-1"},
+				{"Direction", "Output"},
+				{"Status", "OK"},
+			}, nil
+		},
+	})
+}
+
+// protocolInfos lists the formats the server offers videos in
+func protocolInfos() string {
+	var infos []string
+	for _, ext := range []string{".mp4", ".mkv", ".avi", ".mov", ".webm", ".flv", ".wmv"} {
+		infos = append(infos, "http-get:*:"+storage.SourceContentType(ext)+":*")
+	}
+	infos = append(infos, "http-get:*:"+hlsContentType+":*")
+	return strings.Join(infos, ",")
+}
+
+// serveSOAP decodes an action call of the service serviceType, runs the
+// action and writes its result or fault
+func (s *Server) serveSOAP(w http.ResponseWriter, r *http.Request, serviceType string, actions map[string]action) {
+	var req soapRequest
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&req); err != nil {
+		writeFault(w, &upnpError{errInvalidAction, "Invalid action"})
+		return
+	}
+	name := req.Body.Action.XMLName.Local
+	handle, ok := actions[name]
+	if !ok {
+		writeFault(w, &upnpError{errInvalidAction, "Invalid action"})
+		return
+	}
+	args := make(map[string]string)
+	for _, a := range req.Body.Action.Args {
+		args[a.XMLName.Local] = a.Value
+	}
+
+	out, uerr := handle(r, args)
+	if uerr != nil {
+		s.log.DebugContext(r.Context(), "DLNA action failed", "action", name, "code", uerr.code, "err", uerr.desc)
+		writeFault(w, uerr)
+		return
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<u:%sResponse xmlns:u="%s">`, name, serviceType)
+	for _, a := range out {
+		fmt.Fprintf(&b, "<%s>", a.name)
+		xml.EscapeText(&b, []byte(a.value))
+		fmt.Fprintf(&b, "</%s>", a.name)
+	}
+	fmt.Fprintf(&b, "</u:%sResponse>", name)
+	writeEnvelope(w, http.StatusOK, b.String())
+}
+
+// writeFault writes the SOAP fault of a failed action
+func writeFault(w http.ResponseWriter, uerr *upnpError) {
+	var desc strings.Builder
+	xml.EscapeText(&desc, []byte(uerr.desc))
+	writeEnvelope(w, http.StatusInternalServerError, fmt.Sprintf(`<s:Fault><faultcode>s:Client</faultcode><faultstring>UPnPError</faultstring>`+
+		`<detail><UPnPError xmlns="urn:schemas-upnp-org:control-1-0"><errorCode>%d</errorCode>`+
+		`<errorDescription>%s</errorDescription></UPnPError></detail></s:Fault>`, uerr.code, desc.String()))
+}
+
+// writeEnvelope writes a SOAP envelope around body
+func writeEnvelope(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Header().Set("Ext", "")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `%s<s:Envelope xmlns:s="%s" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/"><s:Body>%s</s:Body></s:Envelope>`,
+		xml.Header, soapEnvelopeSpace, body)
+}
+
+// browse answers the Browse action, listing an object or its children
+func (s *Server) browse(r *http.Request, args map[string]string) ([]arg, *upnpError) {
+	start, err1 := strconv.Atoi(args["StartingIndex"])
+	count, err2 := strconv.Atoi(args["RequestedCount"])
+	if err1 != nil || err2 != nil || start < 0 || count < 0 {
+		return nil, &upnpError{errInvalidArgs, "Invalid StartingIndex or RequestedCount"}
+	}
+
+	var didl didlLite
+	var total int
+	var uerr *upnpError
+	switch args["BrowseFlag"] {
+	case "BrowseMetadata":
+		uerr = s.metadata(r, args["ObjectID"], &didl)
+		total = 1
+	case "BrowseDirectChildren":
+		total, uerr = s.children(r, args["ObjectID"], start, count, &didl)
+	default:
+		return nil, &upnpError{errInvalidArgs, "Invalid BrowseFlag"}
+	}
+	if uerr != nil {
+		return nil, uerr
+	}
+
+	result, err := didl.marshal()
+	if err != nil {
+		return nil, &upnpError{errActionFailed, err.Error()}
+	}
+	return []arg{
+		{"Result", result},
+		{"NumberReturned", strconv.Itoa(len(didl.Containers) + len(didl.Items))},
+		{"TotalMatches", strconv.Itoa(total)},
+		{"UpdateID", "0"},
+	}, nil
+}
+
+// libraries returns the libraries clients may browse: all but those
+// restricted to some users, as DLNA clients are anonymous. nil stands for
+// the single unnamed library when none are configured.
+func (s *Server) libraries() []config.MediaLibrary {
+	if len(s.cfg.Libraries) == 0 {
+		return nil
+	}
+	libs := []config.MediaLibrary{}
+	for _, lib := range s.cfg.Libraries {
+		if !lib.Restricted() {
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
+
+// library returns the browsable library of a container ID
+func (s *Server) library(id string) (config.MediaLibrary, bool) {
+	name, ok := strings.CutPrefix(id, libraryIDPrefix)
+	if !ok {
+		return config.MediaLibrary{}, false
+	}
+	for _, lib := range s.libraries() {
+		if lib.Name == name {
+			return lib, true
+		}
+	}
+	return config.MediaLibrary{}, false
+}
+
+// videoParent returns the container ID of a video, reporting false for
+// videos in restricted libraries
+func (s *Server) videoParent(video *database.Video) (string, bool) {
+	if len(s.cfg.Libraries) == 0 {
+		return rootID, true
+	}
+	lib, ok := s.cfg.LibraryFor(video.Path)
+	if !ok || lib.Restricted() {
+		return "", false
+	}
+	return libraryIDPrefix + lib.Name, true
+}
+
+// metadata adds the object id to didl
+func (s *Server) metadata(r *http.Request, id string, didl *didlLite) *upnpError {
+	if id == rootID {
+		count, uerr := s.rootChildCount()
+		if uerr != nil {
+			return uerr
+		}
+		didl.Containers = append(didl.Containers, didlContainer{
+			ID: rootID, ParentID: "-1", Restricted: 1, ChildCount: count,
+			Title: s.name, Class: "object.container",
+		})
+		return nil
+	}
+	if lib, ok := s.library(id); ok {
+		c, uerr := s.libraryContainer(lib)
+		if uerr != nil {
+			return uerr
+		}
+		didl.Containers = append(didl.Containers, c)
+		return nil
+	}
+
+	videoID, err := strconv.ParseInt(strings.TrimPrefix(id, videoIDPrefix), 10, 64)
+	if err != nil || !strings.HasPrefix(id, videoIDPrefix) {
+		return &upnpError{errNoSuchObject, "No such object"}
+	}
+	video, err := s.db.GetVideo(videoID)
+	if err != nil || video == nil {
+		return &upnpError{errNoSuchObject, "No such object"}
+	}
+	parent, ok := s.videoParent(video)
+	if !ok {
+		return &upnpError{errNoSuchObject, "No such object"}
+	}
+	didl.Items = append(didl.Items, s.item(r, video, parent))
+	return nil
+}
+
+// children adds up to count children of the container id to didl,
+// starting at start, and returns the number of children. A count of 0
+// asks for all of them.
+func (s *Server) children(r *http.Request, id string, start, count int, didl *didlLite) (int, *upnpError) {
+	if id == rootID && s.libraries() != nil {
+		libs := s.libraries()
+		end := len(libs)
+		if count > 0 {
+			end = min(start+count, end)
+		}
+		for _, lib := range libs[min(start, len(libs)):end] {
+			c, uerr := s.libraryContainer(lib)
+			if uerr != nil {
+				return 0, uerr
+			}
+			didl.Containers = append(didl.Containers, c)
+		}
+		return len(libs), nil
+	}
+
+	var dirs []string
+	if id != rootID {
+		lib, ok := s.library(id)
+		if !ok {
+			return 0, &upnpError{errNoSuchObject, "No such container"}
+		}
+		dirs = []string{lib.MediaDir}
+	}
+	page, err := s.db.ListVideos(database.ListOptions{Dirs: dirs, Limit: count, Offset: start})
+	if err != nil {
+		return 0, &upnpError{errCannotProcess, err.Error()}
+	}
+	// Without a limit the offset isn't applied
+	videos := page.Videos
+	if count == 0 {
+		videos = videos[min(start, len(videos)):]
+	}
+	for _, video := range videos {
+		didl.Items = append(didl.Items, s.item(r, video, id))
+	}
+	return page.Total, nil
+}
+
+// rootChildCount returns the number of children of the root container
+func (s *Server) rootChildCount() (int, *upnpError) {
+	if libs := s.libraries(); libs != nil {
+		return len(libs), nil
+	}
+	page, err := s.db.ListVideos(database.ListOptions{Limit: 1})
+	if err != nil {
+		return 0, &upnpError{errCannotProcess, err.Error()}
+	}
+	return page.Total, nil
+}
+
+// libraryContainer returns the container of a library
+func (s *Server) libraryContainer(lib config.MediaLibrary) (didlContainer, *upnpError) {
+	page, err := s.db.ListVideos(database.ListOptions{Dirs: []string{lib.MediaDir}, Limit: 1})
+	if err != nil {
+		return didlContainer{}, &upnpError{errCannotProcess, err.Error()}
+	}
+	return didlContainer{
+		ID: libraryIDPrefix + lib.Name, ParentID: rootID, Restricted: 1, ChildCount: page.Total,
+		Title: lib.Name, Class: "object.container.storageFolder",
+	}, nil
+}
+
+// item returns the item of a video. It offers the source file, and the
+// HLS output once the video is ready.
+func (s *Server) item(r *http.Request, video *database.Video, parent string) didlItem {
+	base := "http://" + r.Host
+	if r.TLS != nil {
+		base = "https://" + r.Host
+	}
+	id := strconv.FormatInt(video.ID, 10)
+
+	source := didlRes{
+		ProtocolInfo: "http-get:*:" + storage.SourceContentType(video.Path) + ":" + dlnaFlags,
+		Size:         video.Size,
+		URL:          base + s.cfg.Server.Path(escapePath("/dlna/media/"+id+"/"+video.Filename)),
+	}
+	if video.Duration > 0 {
+		source.Duration = formatDuration(video.Duration)
+	}
+	if video.Width > 0 && video.Height > 0 {
+		source.Resolution = fmt.Sprintf("%dx%d", video.Width, video.Height)
+	}
+	item := didlItem{
+		ID:         videoIDPrefix + id,
+		ParentID:   parent,
+		Restricted: 1,
+		Title:      strings.TrimSuffix(video.Filename, filepath.Ext(video.Filename)),
+		Class:      "object.item.videoItem",
+		Date:       video.CreatedAt.Format("2006-01-02"),
+		AlbumArt: &didlAlbumArt{
+			ProfileID: "JPEG_TN",
+			URL:       base + s.cfg.Server.Path("/dlna/artwork/"+id),
+		},
+		Res: []didlRes{source},
+	}
+	if video.Status == database.StatusReady {
+		master := s.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path)
+		item.Res = append(item.Res, didlRes{
+			ProtocolInfo: "http-get:*:" + hlsContentType + ":*",
+			Duration:     source.Duration,
+			URL:          base + s.cfg.Server.Path(escapePath("/dlna/stream/"+filepath.ToSlash(master))),
+		})
+	}
+	return item
+}
+
+// escapePath escapes the segments of a URL path
+func escapePath(p string) string {
+	return (&url.URL{Path: p}).EscapedPath()
+}
+
+// formatDuration formats seconds as H:MM:SS.mmm
+func formatDuration(seconds float64) string {
+	ms := int64(seconds * 1000)
+	return fmt.Sprintf("%d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// didlLite is the Result of Browse, listing objects in DIDL-Lite
+type didlLite struct {
+	XMLName    xml.Name        `xml:"urn:schemas-upnp-org:metadata-1-0/DIDL-Lite/ DIDL-Lite"`
+	DC         string          `xml:"xmlns:dc,attr"`
+	UPnP       string          `xml:"xmlns:upnp,attr"`
+	DLNA       string          `xml:"xmlns:dlna,attr"`
+	Containers []didlContainer `xml:"container"`
+	Items      []didlItem      `xml:"item"`
+}
+
+type didlContainer struct {
+	ID         string `xml:"id,attr"`
+	ParentID   string `xml:"parentID,attr"`
+	Restricted int    `xml:"restricted,attr"`
+	ChildCount int    `xml:"childCount,attr"`
+	Title      string `xml:"dc:title"`
+	Class      string `xml:"upnp:class"`
+}
+
+type didlItem struct {
+	ID         string        `xml:"id,attr"`
+	ParentID   string        `xml:"parentID,attr"`
+	Restricted int           `xml:"restricted,attr"`
+	Title      string        `xml:"dc:title"`
+	Class      string        `xml:"upnp:class"`
+	Date       string        `xml:"dc:date,omitempty"`
+	AlbumArt   *didlAlbumArt `xml:"upnp:albumArtURI,omitempty"`
+	Res        []didlRes     `xml:"res"`
+}
+
+type didlAlbumArt struct {
+	ProfileID string `xml:"dlna:profileID,attr"`
+	URL       string `xml:",chardata"`
+}
+
+type didlRes struct {
+	ProtocolInfo string `xml:"protocolInfo,attr"`
+	Size         int64  `xml:"size,attr,omitempty"`
+	Duration     string `xml:"duration,attr,omitempty"`
+	Resolution   string `xml:"resolution,attr,omitempty"`
+	URL          string `xml:",chardata"`
+}
+
+// marshal encodes the objects with their namespaces
+func (d *didlLite) marshal() (string, error) {
+	d.DC = "http://purl.org/dc/elements/1.1/"
+	d.UPnP = "urn:schemas-upnp-org:metadata-1-0/upnp/"
+	d.DLNA = "urn:schemas-dlna-org:metadata-1-0/"
+	data, err := xml.Marshal(d)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode DIDL-Lite: %w", err)
+	}
+	return string(data), nil
+}
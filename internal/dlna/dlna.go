@@ -0,0 +1,193 @@
+// Package dlna is a DLNA media server: it announces the server on the local
+// network with SSDP and lets smart TVs and other UPnP clients browse the
+// libraries through the ContentDirectory service. Videos are played from
+// their source file or from their HLS output, served by the handlers under
+// /dlna/.
+package dlna
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/middleware"
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/version"
+)
+
+// UPnP types of the device and its services
+const (
+	deviceType            = "urn:schemas-upnp-org:device:MediaServer:1"
+	contentDirectoryType  = "urn:schemas-upnp-org:service:ContentDirectory:1"
+	connectionManagerType = "urn:schemas-upnp-org:service:ConnectionManager:1"
+)
+
+// Server answers discovery and browse requests of DLNA clients
+type Server struct {
+	cfg  *config.Config
+	db   *database.DB
+	tm   *transcoder.Manager
+	log  *slog.Logger
+	udn  string
+	name string
+
+	// conn receives the discovery requests sent to the SSDP multicast
+	// group and out sends the answers and announcements
+	conn   *net.UDPConn
+	out    *net.UDPConn
+	iface  *net.Interface
+	scheme string
+	port   int
+	done   chan struct{}
+	wg     sync.WaitGroup
+}
+
+// New creates the DLNA server. Its identity is derived from the host name
+// and port, so clients recognize it across restarts.
+func New(cfg *config.Config, db *database.DB, tm *transcoder.Manager, logger *slog.Logger) *Server {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	name := cfg.DLNA.FriendlyName
+	if name == "" {
+		name = "Streaming on " + hostname
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s:%d", hostname, cfg.Server.Port)))
+	id := hex.EncodeToString(sum[:16])
+	return &Server{
+		cfg:  cfg,
+		db:   db,
+		tm:   tm,
+		log:  logger,
+		udn:  fmt.Sprintf("uuid:%s-%s-%s-%s-%s", id[:8], id[8:12], id[12:16], id[16:20], id[20:]),
+		name: name,
+	}
+}
+
+// Register adds the device description and the SOAP endpoints of the
+// services to mux, refusing clients outside the local network
+func (s *Server) Register(mux *http.ServeMux) {
+	mux.HandleFunc("GET /dlna/device.xml", LocalOnly(s.DeviceHandler))
+	mux.HandleFunc("GET /dlna/ContentDirectory.xml", LocalOnly(scpdHandler(contentDirectorySCPD)))
+	mux.HandleFunc("GET /dlna/ConnectionManager.xml", LocalOnly(scpdHandler(connectionManagerSCPD)))
+	mux.HandleFunc("POST /dlna/control/ContentDirectory", LocalOnly(s.ContentDirectoryHandler))
+	mux.HandleFunc("POST /dlna/control/ConnectionManager", LocalOnly(s.ConnectionManagerHandler))
+	mux.HandleFunc("/dlna/event/", LocalOnly(SubscribeHandler))
+}
+
+// LocalOnly refuses requests from outside the local network, as DLNA
+// clients can't authenticate
+func LocalOnly(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ip := net.ParseIP(middleware.ClientIP(r))
+		if ip == nil || !(ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast()) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// deviceDescription is the UPnP description of the media server
+type deviceDescription struct {
+	XMLName     xml.Name `xml:"urn:schemas-upnp-org:device-1-0 root"`
+	DLNA        string   `xml:"xmlns:dlna,attr"`
+	SpecVersion struct {
+		Major int `xml:"major"`
+		Minor int `xml:"minor"`
+	} `xml:"specVersion"`
+	Device struct {
+		DeviceType   string    `xml:"deviceType"`
+		FriendlyName string    `xml:"friendlyName"`
+		Manufacturer string    `xml:"manufacturer"`
+		ModelName    string    `xml:"modelName"`
+		ModelNumber  string    `xml:"modelNumber"`
+		UDN          string    `xml:"UDN"`
+		DLNADoc      string    `xml:"dlna:X_DLNADOC"`
+		Services     []service `xml:"serviceList>service"`
+	} `xml:"device"`
+}
+
+type service struct {
+	ServiceType string `xml:"serviceType"`
+	ServiceID   string `xml:"serviceId"`
+	SCPDURL     string `xml:"SCPDURL"`
+	ControlURL  string `xml:"controlURL"`
+	EventSubURL string `xml:"eventSubURL"`
+}
+
+// DeviceHandler serves the device description clients find through the
+// LOCATION of discovery answers
+func (s *Server) DeviceHandler(w http.ResponseWriter, r *http.Request) {
+	var d deviceDescription
+	d.DLNA = "urn:schemas-dlna-org:device-1-0"
+	d.SpecVersion.Major = 1
+	d.Device.DeviceType = deviceType
+	d.Device.FriendlyName = s.name
+	d.Device.Manufacturer = "streaming"
+	d.Device.ModelName = "streaming"
+	d.Device.ModelNumber = version.Get().Version
+	d.Device.UDN = s.udn
+	d.Device.DLNADoc = "DMS-1.50"
+	for _, name := range []string{"ContentDirectory", "ConnectionManager"} {
+		d.Device.Services = append(d.Device.Services, service{
+			ServiceType: "urn:schemas-upnp-org:service:" + name + ":1",
+			ServiceID:   "urn:upnp-org:serviceId:" + name,
+			SCPDURL:     s.cfg.Server.Path("/dlna/" + name + ".xml"),
+			ControlURL:  s.cfg.Server.Path("/dlna/control/" + name),
+			EventSubURL: s.cfg.Server.Path("/dlna/event/" + name),
+		})
+	}
+	writeXML(w, d)
+}
+
+// scpdHandler serves the description of a service
+func scpdHandler(scpd string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+		fmt.Fprint(w, scpd)
+	}
+}
+
+// SubscribeHandler accepts event subscriptions, which some TVs insist on
+// before browsing. No events are sent: the library is browsed again when
+// it is opened.
+func SubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "SUBSCRIBE":
+		sid := r.Header.Get("SID")
+		if sid == "" {
+			b := make([]byte, 16)
+			rand.Read(b)
+			sid = "uuid:" + hex.EncodeToString(b)
+		}
+		// Some TVs only accept the header names as spelled by UPnP
+		w.Header()["SID"] = []string{sid}
+		w.Header()["TIMEOUT"] = []string{fmt.Sprintf("Second-%d", ssdpMaxAge)}
+	case "UNSUBSCRIBE":
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// writeXML writes v as an XML document
+func writeXML(w http.ResponseWriter, v any) {
+	data, err := xml.Marshal(v)
+	if err != nil {
+		http.Error(w, "Error encoding XML", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", `text/xml; charset="utf-8"`)
+	w.Write([]byte(xml.Header))
+	w.Write(data)
+}
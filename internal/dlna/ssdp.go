@@ -0,0 +1,241 @@
+package dlna
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/version"
+)
+
+// ssdpAddr is the multicast group discovery requests and announcements are
+// sent to
+const ssdpAddr = "239.255.255.250:1900"
+
+// ssdpMaxAge is how long clients remember the server, in seconds
+const ssdpMaxAge = 1800
+
+// announceInterval is how often the server announces itself, well within
+// ssdpMaxAge so clients don't forget it
+const announceInterval = 10 * time.Minute
+
+// maxSearchDelay caps the random delay of discovery answers, which the MX
+// header of the request asks for
+const maxSearchDelay = 2 * time.Second
+
+// Start answers discovery requests and announces the server on the local
+// network. port and scheme are those of the HTTP server the description
+// and videos are served from.
+func (s *Server) Start(port int, scheme string) error {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return err
+	}
+	if name := s.cfg.DLNA.Interface; name != "" {
+		if s.iface, err = net.InterfaceByName(name); err != nil {
+			return fmt.Errorf("failed to find interface %s: %w", name, err)
+		}
+	}
+	s.conn, err = net.ListenMulticastUDP("udp4", s.iface, group)
+	if err != nil {
+		return fmt.Errorf("failed to join the SSDP multicast group: %w", err)
+	}
+	s.out, err = net.ListenUDP("udp4", nil)
+	if err != nil {
+		s.conn.Close()
+		return fmt.Errorf("failed to open the SSDP socket: %w", err)
+	}
+	s.scheme, s.port = scheme, port
+	s.done = make(chan struct{})
+
+	s.wg.Add(2)
+	go s.listen()
+	go s.announce()
+	s.log.Info("Announcing DLNA media server", "name", s.name, "udn", s.udn)
+	return nil
+}
+
+// Stop stops answering discovery requests and tells clients the server is
+// gone. Stopping a nil or unstarted server does nothing.
+func (s *Server) Stop() {
+	if s == nil || s.done == nil {
+		return
+	}
+	close(s.done)
+	s.conn.Close()
+	s.wg.Wait()
+	s.notify("ssdp:byebye")
+	s.out.Close()
+}
+
+// notificationTypes are the targets the server is found by: the root
+// device, its UDN, its device type and its service types
+func (s *Server) notificationTypes() []string {
+	return []string{"upnp:rootdevice", s.udn, deviceType, contentDirectoryType, connectionManagerType}
+}
+
+// usn returns the unique service name of a notification type
+func (s *Server) usn(nt string) string {
+	if nt == s.udn {
+		return s.udn
+	}
+	return s.udn + "::" + nt
+}
+
+// serverHeader identifies the server in SSDP messages
+func serverHeader() string {
+	return fmt.Sprintf("%s/1.0 UPnP/1.0 streaming/%s", runtime.GOOS, version.Get().Version)
+}
+
+// listen answers discovery requests until the server is stopped
+func (s *Server) listen() {
+	defer s.wg.Done()
+	buf := make([]byte, 8192)
+	for {
+		n, addr, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			select {
+			case <-s.done:
+				return
+			default:
+			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.log.Warn("Error reading SSDP request", "err", err)
+			continue
+		}
+		req, err := http.ReadRequest(bufio.NewReader(bytes.NewReader(buf[:n])))
+		if err != nil || req.Method != "M-SEARCH" || req.Header.Get("MAN") != `"ssdp:discover"` {
+			continue
+		}
+		s.search(req.Header.Get("ST"), req.Header.Get("MX"), addr)
+	}
+}
+
+// search answers a discovery request for st after a random delay of up to
+// mx seconds
+func (s *Server) search(st, mx string, addr *net.UDPAddr) {
+	var targets []string
+	for _, nt := range s.notificationTypes() {
+		if st == "ssdp:all" || st == nt {
+			targets = append(targets, nt)
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	ip, err := s.localIP(addr)
+	if err != nil {
+		s.log.Warn("Error finding the address to answer an SSDP request from", "client", addr.String(), "err", err)
+		return
+	}
+
+	delay := time.Duration(0)
+	if seconds, err := strconv.Atoi(mx); err == nil && seconds > 0 {
+		delay = min(time.Duration(rand.Int64N(int64(seconds)*int64(time.Second))), maxSearchDelay)
+	}
+	time.AfterFunc(delay, func() {
+		for _, nt := range targets {
+			msg := s.message("HTTP/1.1 200 OK", ip, [][2]string{
+				{"EXT", ""},
+				{"ST", nt},
+				{"USN", s.usn(nt)},
+			})
+			if _, err := s.out.WriteToUDP(msg, addr); err != nil && !errors.Is(err, net.ErrClosed) {
+				s.log.Warn("Error answering SSDP request", "client", addr.String(), "err", err)
+			}
+		}
+	})
+}
+
+// announce tells the local network about the server until it is stopped
+func (s *Server) announce() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(announceInterval)
+	defer ticker.Stop()
+	for {
+		s.notify("ssdp:alive")
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// notify sends a NOTIFY message with subtype nts for every notification
+// type
+func (s *Server) notify(nts string) {
+	group, err := net.ResolveUDPAddr("udp4", ssdpAddr)
+	if err != nil {
+		return
+	}
+	ip, err := s.localIP(group)
+	if err != nil {
+		s.log.Warn("Error finding the address to announce the DLNA server from", "err", err)
+		return
+	}
+	for _, nt := range s.notificationTypes() {
+		msg := s.message("NOTIFY * HTTP/1.1", ip, [][2]string{
+			{"HOST", ssdpAddr},
+			{"NT", nt},
+			{"NTS", nts},
+			{"USN", s.usn(nt)},
+		})
+		if _, err := s.out.WriteToUDP(msg, group); err != nil {
+			s.log.Warn("Error sending SSDP announcement", "err", err)
+			return
+		}
+	}
+}
+
+// message builds an SSDP message with the headers common to announcements
+// and discovery answers, pointing clients at the description served on ip
+func (s *Server) message(start string, ip net.IP, headers [][2]string) []byte {
+	var b strings.Builder
+	b.WriteString(start + "\r\n")
+	headers = append(headers, [][2]string{
+		{"CACHE-CONTROL", fmt.Sprintf("max-age=%d", ssdpMaxAge)},
+		{"DATE", time.Now().UTC().Format(http.TimeFormat)},
+		{"LOCATION", fmt.Sprintf("%s://%s%s", s.scheme, net.JoinHostPort(ip.String(), strconv.Itoa(s.port)), s.cfg.Server.Path("/dlna/device.xml"))},
+		{"SERVER", serverHeader()},
+	}...)
+	for _, h := range headers {
+		b.WriteString(h[0] + ": " + h[1] + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
+
+// localIP returns the address of the server clients at addr reach it on:
+// the first IPv4 address of the configured interface, or the source
+// address the system routes packets to addr from
+func (s *Server) localIP(addr *net.UDPAddr) (net.IP, error) {
+	if s.iface != nil {
+		addrs, err := s.iface.Addrs()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			if ipnet, ok := a.(*net.IPNet); ok && ipnet.IP.To4() != nil {
+				return ipnet.IP, nil
+			}
+		}
+		return nil, fmt.Errorf("interface %s has no IPv4 address", s.iface.Name)
+	}
+	conn, err := net.DialUDP("udp4", nil, addr)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP, nil
+}
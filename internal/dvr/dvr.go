@@ -0,0 +1,270 @@
+// Package dvr records network streams into the libraries on a schedule,
+// like a video recorder: at the start of every recording FFmpeg copies the
+// stream to a file in the library, which is then added and processed like
+// an upload.
+package dvr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/control"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/safepath"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// retryDelay is how long a recording waits before reading the stream
+// again when it failed or dropped before the end
+const retryDelay = 30 * time.Second
+
+// minRemaining is the least time left for which a recording reads a
+// stream again
+const minRemaining = 10 * time.Second
+
+// timeLayout formats the start of a recording in its file names
+const timeLayout = "2006-01-02 15.04"
+
+// Recorder runs the recordings of the configuration
+type Recorder struct {
+	cfg  *config.Config
+	db   *database.DB
+	tm   *transcoder.Manager
+	lm   *library.Manager
+	jobs control.Jobs
+	log  *slog.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates the recorder of the recordings of cfg. Recorded videos are
+// processed through jobs.
+func New(cfg *config.Config, db *database.DB, tm *transcoder.Manager, lm *library.Manager, jobs control.Jobs, logger *slog.Logger) *Recorder {
+	return &Recorder{cfg: cfg, db: db, tm: tm, lm: lm, jobs: jobs, log: logger}
+}
+
+// Start waits for the next run of every recording
+func (r *Recorder) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	r.cancel = cancel
+	for _, rec := range r.cfg.DVR.Recordings {
+		r.wg.Add(1)
+		go r.run(ctx, rec)
+	}
+}
+
+// Stop ends the running recordings, keeping what they recorded so far
+func (r *Recorder) Stop() {
+	if r.cancel == nil {
+		return
+	}
+	r.cancel()
+	r.wg.Wait()
+}
+
+// run records a recording at every run until ctx is cancelled
+func (r *Recorder) run(ctx context.Context, rec config.Recording) {
+	defer r.wg.Done()
+	after := time.Now()
+	for {
+		start := next(rec, after)
+		r.log.Info("Next recording scheduled", "recording", rec.Name, "start", start.Format(time.DateTime))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(start)):
+		}
+		r.record(ctx, rec, start)
+		if ctx.Err() != nil {
+			return
+		}
+		r.prune(rec)
+		// Runs ending early, with the stream, aren't recorded again
+		after = start.Add(rec.Duration())
+	}
+}
+
+// next returns when the next run of a recording after now starts, or the
+// start of the run that is on at now, such as one that started before the
+// librarian did
+func next(rec config.Recording, now time.Time) time.Time {
+	hour, min := rec.StartClock()
+	// Runs that started yesterday may still be on
+	day := now.AddDate(0, 0, -1)
+	for i := 0; i < 9; i++ {
+		start := time.Date(day.Year(), day.Month(), day.Day(), hour, min, 0, 0, now.Location())
+		if rec.RunsOn(start.Weekday()) && start.Add(rec.Duration()).After(now) {
+			return start
+		}
+		day = day.AddDate(0, 0, 1)
+	}
+	// Every recording runs at least once a week
+	return now
+}
+
+// record records a run of a recording that started at start. When the
+// stream fails or drops before the end it is read again after a while,
+// the rest going to a file of its own.
+func (r *Recorder) record(ctx context.Context, rec config.Recording, start time.Time) {
+	end := start.Add(rec.Duration())
+	dir, err := r.dir(rec)
+	if err != nil {
+		r.log.Error("Error creating recording folder", "recording", rec.Name, "err", err)
+		return
+	}
+
+	for part := 1; time.Until(end) > minRemaining; part++ {
+		name := fmt.Sprintf("%s %s.mkv", rec.Name, start.Format(timeLayout))
+		if part > 1 {
+			name = fmt.Sprintf("%s %s (%d).mkv", rec.Name, start.Format(timeLayout), part)
+		}
+		dest := filepath.Join(dir, name)
+		// The librarian doesn't pick up hidden files while they are written
+		tmp := filepath.Join(dir, "."+name+".part")
+
+		r.log.Info("Recording started", "recording", rec.Name, "path", dest, "until", end.Format(time.TimeOnly))
+		result, err := r.tm.Record(ctx, transcoder.RecordJob{
+			Name:       rec.Name,
+			URL:        rec.URL,
+			OutputPath: tmp,
+			Duration:   time.Until(end),
+		})
+		if err := r.ingest(rec, tmp, dest); err != nil {
+			r.log.Error("Error adding recording to the library", "recording", rec.Name, "path", dest, "err", err)
+		}
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err == nil:
+			r.log.Info("Recording finished", "recording", rec.Name, "path", dest)
+			return
+		}
+
+		r.log.Warn("Recording failed", "recording", rec.Name, "err", err, "output", result.StderrTail)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(retryDelay):
+		}
+	}
+}
+
+// dir returns the folder a recording is written to, creating it
+func (r *Recorder) dir(rec config.Recording) (string, error) {
+	// Loading the configuration checked that the library exists
+	lib, _ := r.cfg.RecordingLibrary(rec)
+	dir, err := safepath.Join(lib.MediaDir, rec.Folder)
+	if err != nil {
+		return "", err
+	}
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// ingest moves a recorded file into place and adds it to the library like
+// an upload, or removes it when nothing was recorded. The video is added
+// before the file appears so the file watcher doesn't add it a second
+// time.
+func (r *Recorder) ingest(rec config.Recording, tmp, dest string) error {
+	info, err := os.Stat(tmp)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil || info.Size() == 0 {
+		os.Remove(tmp)
+		return err
+	}
+
+	id, err := r.db.PutVideo(filepath.Base(dest), dest, info.Size())
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to move recording into place: %w", err)
+	}
+
+	if err := r.db.LogEvent(database.EventRecording, id, database.ActorLibrarian, "recorded "+rec.Name+" to "+dest); err != nil {
+		r.log.Error("Error logging recording event", "err", err)
+	}
+	// The librarian may be stopping, which processes the video on its next
+	// run
+	if _, err := r.jobs.Process(context.Background()); err != nil {
+		r.log.Warn("Error requesting processing of recording", "err", err)
+	}
+	return nil
+}
+
+// prune deletes the oldest runs of a recording beyond its keep setting,
+// with their processed output
+func (r *Recorder) prune(rec config.Recording) {
+	if rec.Keep <= 0 {
+		return
+	}
+	dir, err := r.dir(rec)
+	if err != nil {
+		return
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		r.log.Error("Error listing recordings", "recording", rec.Name, "err", err)
+		return
+	}
+
+	// The files of a run, which may be several, share its start time
+	prefix := rec.Name + " "
+	runs := make(map[string][]string)
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || len(name) < len(prefix)+len(timeLayout) {
+			continue
+		}
+		started := name[len(prefix) : len(prefix)+len(timeLayout)]
+		if _, err := time.Parse(timeLayout, started); err != nil {
+			continue
+		}
+		runs[started] = append(runs[started], filepath.Join(dir, name))
+	}
+	starts := make([]string, 0, len(runs))
+	for started := range runs {
+		starts = append(starts, started)
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(starts)))
+	if len(starts) <= rec.Keep {
+		return
+	}
+
+	for _, started := range starts[rec.Keep:] {
+		for _, file := range runs[started] {
+			if err := r.remove(file); err != nil {
+				r.log.Error("Error deleting old recording", "path", file, "err", err)
+				continue
+			}
+			r.log.Info("Deleted old recording", "recording", rec.Name, "path", file)
+		}
+	}
+}
+
+// remove deletes a recorded file, through the library when it is a video
+// of it
+func (r *Recorder) remove(file string) error {
+	video, err := r.db.GetVideoByPath(file)
+	if err != nil {
+		return err
+	}
+	if video == nil {
+		return os.Remove(file)
+	}
+	return r.lm.DeleteVideo(video.ID, database.ActorLibrarian, true)
+}
@@ -0,0 +1,149 @@
+package events
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// subscriberBuffer is the number of events queued per subscriber before
+// further events are dropped for it
+const subscriberBuffer = 64
+
+// Hub tails the audit log and fans new events out to subscribers. Since
+// the librarian runs in its own process, the events table is the channel
+// its progress reaches the server through.
+type Hub struct {
+	db       *database.DB
+	interval time.Duration
+	log      *slog.Logger
+
+	mu     sync.Mutex
+	subs   map[chan *database.Event]struct{}
+	lastID int64
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// NewHub creates a hub polling the audit log every interval and logging
+// to logger
+func NewHub(db *database.DB, interval time.Duration, logger *slog.Logger) *Hub {
+	return &Hub{
+		db:       db,
+		interval: interval,
+		log:      logger,
+		subs:     make(map[chan *database.Event]struct{}),
+		stopCh:   make(chan struct{}),
+		doneCh:   make(chan struct{}),
+	}
+}
+
+// Start begins tailing the audit log. Only events recorded after Start are
+// delivered.
+func (h *Hub) Start() error {
+	latest, err := h.db.ListEvents(database.EventFilter{Limit: 1})
+	if err != nil {
+		return err
+	}
+	if len(latest) > 0 {
+		h.lastID = latest[0].ID
+	}
+
+	go h.run()
+	return nil
+}
+
+// Stop stops tailing and closes all subscriptions
+func (h *Hub) Stop() {
+	close(h.stopCh)
+	<-h.doneCh
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for ch := range h.subs {
+		close(ch)
+		delete(h.subs, ch)
+	}
+}
+
+// Subscribe returns a channel receiving new events. It is closed by
+// Unsubscribe or when the hub stops.
+func (h *Hub) Subscribe() chan *database.Event {
+	ch := make(chan *database.Event, subscriberBuffer)
+
+	h.mu.Lock()
+	h.subs[ch] = struct{}{}
+	h.mu.Unlock()
+
+	return ch
+}
+
+// Unsubscribe removes a subscription and closes its channel
+func (h *Hub) Unsubscribe(ch chan *database.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := h.subs[ch]; ok {
+		delete(h.subs, ch)
+		close(ch)
+	}
+}
+
+// run polls the audit log until the hub stops
+func (h *Hub) run() {
+	defer close(h.doneCh)
+
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.poll()
+		case <-h.stopCh:
+			return
+		}
+	}
+}
+
+// poll publishes the events recorded since the last poll
+func (h *Hub) poll() {
+	for {
+		events, err := h.db.ListEvents(database.EventFilter{
+			AfterID:   h.lastID,
+			Ascending: true,
+			Limit:     500,
+		})
+		if err != nil {
+			h.log.Error("Error reading new events", "err", err)
+			return
+		}
+
+		for _, e := range events {
+			h.publish(e)
+			h.lastID = e.ID
+		}
+
+		if len(events) < 500 {
+			return
+		}
+	}
+}
+
+// publish delivers an event to all subscribers, dropping it for those that
+// fall behind rather than blocking the others
+func (h *Hub) publish(e *database.Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for ch := range h.subs {
+		select {
+		case ch <- e:
+		default:
+			h.log.Warn("Dropping event for a slow subscriber", "event", e.ID)
+		}
+	}
+}
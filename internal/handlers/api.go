@@ -0,0 +1,217 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// anonymousUser is the user ratings are stored under for unauthenticated requests
+const anonymousUser = "anonymous"
+
+// APIError is the JSON body returned for failed API requests
+type APIError struct {
+	Error string `json:"error"`
+}
+
+// RatingResponse is the JSON representation of a user's rating of a video
+type RatingResponse struct {
+	VideoID  int64 `json:"video_id"`
+	Rating   int   `json:"rating"`
+	Favorite bool  `json:"favorite"`
+}
+
+// writeJSON writes v as a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Debug("Error encoding JSON response", "err", err)
+	}
+}
+
+// writeJSONError writes an APIError response with the given status code
+func writeJSONError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, APIError{Error: msg})
+}
+
+// currentUser returns the name of the user making the request
+func (h *Handler) currentUser(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	return anonymousUser
+}
+
+// videoFromPath loads the video named by the {id} path parameter and writes
+// an error response if the ID is invalid or the video doesn't exist. Videos
+// in libraries the user can't see don't exist for them.
+func (h *Handler) videoFromPath(w http.ResponseWriter, r *http.Request) (*database.Video, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid video ID")
+		return nil, false
+	}
+
+	video, err := h.db.WithContext(r.Context()).GetVideo(id)
+	if err != nil || !h.canAccess(r, video.Path) {
+		writeJSONError(w, http.StatusNotFound, "video not found")
+		return nil, false
+	}
+
+	return video, true
+}
+
+// videoIDFromPath is like videoFromPath but only returns the video ID
+func (h *Handler) videoIDFromPath(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return 0, false
+	}
+	return video.ID, true
+}
+
+// writeRating writes the current user's rating of a video as JSON
+func (h *Handler) writeRating(w http.ResponseWriter, r *http.Request, videoID int64) {
+	rating, err := h.db.GetRating(h.currentUser(r), videoID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, RatingResponse{
+		VideoID:  rating.VideoID,
+		Rating:   rating.Rating,
+		Favorite: rating.Favorite,
+	})
+}
+
+// GetRatingHandler returns the current user's rating of a video
+func (h *Handler) GetRatingHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	h.writeRating(w, r, id)
+}
+
+// SetRatingHandler sets the current user's rating of a video from a JSON
+// body such as {"rating": 4}. A rating of 0 clears it.
+func (h *Handler) SetRatingHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Rating int `json:"rating"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if body.Rating < 0 || body.Rating > 5 {
+		writeJSONError(w, http.StatusBadRequest, "rating must be between 0 and 5")
+		return
+	}
+
+	if err := h.db.SetRating(h.currentUser(r), id, body.Rating); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.writeRating(w, r, id)
+}
+
+// FavoriteHandler adds (PUT) or removes (DELETE) a video from the current
+// user's favorites
+func (h *Handler) FavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	favorite := r.Method == http.MethodPut
+	if err := h.db.SetFavorite(h.currentUser(r), id, favorite); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.writeRating(w, r, id)
+}
+
+// EventResponse is the JSON representation of an audit log event
+type EventResponse struct {
+	ID        int64     `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	Type      string    `json:"type"`
+	VideoID   *int64    `json:"video_id,omitempty"`
+	Actor     string    `json:"actor"`
+	Message   string    `json:"message"`
+}
+
+// newEventResponse converts an audit log event into its JSON representation
+func newEventResponse(e *database.Event) EventResponse {
+	er := EventResponse{
+		ID:        e.ID,
+		CreatedAt: e.CreatedAt,
+		Type:      string(e.Type),
+		Actor:     e.Actor,
+		Message:   e.Message,
+	}
+	if e.VideoID.Valid {
+		er.VideoID = &e.VideoID.Int64
+	}
+	return er
+}
+
+// EventsHandler returns audit log events, newest first. It accepts the
+// video_id, type, before, after and limit query parameters.
+func (h *Handler) EventsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := database.EventFilter{
+		Type: database.EventType(query.Get("type")),
+	}
+
+	for param, dest := range map[string]*int64{
+		"video_id": &filter.VideoID,
+		"before":   &filter.BeforeID,
+		"after":    &filter.AfterID,
+	} {
+		if v := query.Get(param); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				writeJSONError(w, http.StatusBadRequest, "invalid "+param)
+				return
+			}
+			*dest = n
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > 1000 {
+			writeJSONError(w, http.StatusBadRequest, "limit must be between 1 and 1000")
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := h.db.ListEvents(filter)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := make([]EventResponse, 0, len(events))
+	for _, e := range events {
+		resp = append(resp, newEventResponse(e))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
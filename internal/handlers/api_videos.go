@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+)
+
+// API pagination limits
+const (
+	apiDefaultPerPage = 50
+	apiMaxPerPage     = 500
+)
+
+// VideoJSON is the JSON representation of a video
+type VideoJSON struct {
+	ID        int64        `json:"id"`
+	Filename  string       `json:"filename"`
+	Library   string       `json:"library,omitempty"`
+	Size      int64        `json:"size"`
+	Duration  float64      `json:"duration"`
+	Status    string       `json:"status"`
+	Error     string       `json:"error,omitempty"`
+	CreatedAt time.Time    `json:"created_at"`
+	UpdatedAt time.Time    `json:"updated_at"`
+	Metadata  MetadataJSON `json:"metadata"`
+	Retry     RetryJSON    `json:"retry"`
+	Rating    int          `json:"rating"`
+	Favorite  bool         `json:"favorite"`
+	Pinned    bool         `json:"pinned"`
+	Tags      []string     `json:"tags"`
+	// Position is where the user stopped watching in seconds, 0 when they
+	// haven't started, and Watched whether they watched it to the end
+	// before
+	Position    float64 `json:"position"`
+	Watched     bool    `json:"watched"`
+	PlayerURL   string  `json:"player_url,omitempty"`
+	StreamURL   string  `json:"stream_url,omitempty"`
+	DirectURL   string  `json:"direct_url"`
+	DownloadURL string  `json:"download_url"`
+	ArtworkURL  string  `json:"artwork_url"`
+}
+
+// MetadataJSON is the JSON representation of a video's technical metadata
+type MetadataJSON struct {
+	Container     string  `json:"container"`
+	VideoCodec    string  `json:"video_codec"`
+	Width         int     `json:"width"`
+	Height        int     `json:"height"`
+	FrameRate     float64 `json:"frame_rate"`
+	BitDepth      int     `json:"bit_depth"`
+	HDR           bool    `json:"hdr"`
+	AudioCodec    string  `json:"audio_codec"`
+	AudioChannels int     `json:"audio_channels"`
+	CoverArt      bool    `json:"cover_art"`
+}
+
+// RetryJSON is the JSON representation of a video's retry state
+type RetryJSON struct {
+	Count        int        `json:"count"`
+	FailureClass string     `json:"failure_class,omitempty"`
+	NextRetryAt  *time.Time `json:"next_retry_at,omitempty"`
+}
+
+// VideoListJSON is a page of videos returned by the list endpoint
+type VideoListJSON struct {
+	Videos  []VideoJSON `json:"videos"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+// VideoStatusJSON is the processing state of a video
+type VideoStatusJSON struct {
+	ID     int64     `json:"id"`
+	Status string    `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Retry  RetryJSON `json:"retry"`
+}
+
+// VariantJSON is a transcoded quality level of a video
+type VariantJSON struct {
+	Name           string     `json:"name"`
+	PlaylistURL    string     `json:"playlist_url"`
+	SizeBytes      int64      `json:"size_bytes"`
+	CreatedAt      time.Time  `json:"created_at"`
+	LastAccessedAt *time.Time `json:"last_accessed_at,omitempty"`
+}
+
+// VariantsJSON lists the variants of a video
+type VariantsJSON struct {
+	MasterPlaylistURL string        `json:"master_playlist_url,omitempty"`
+	Variants          []VariantJSON `json:"variants"`
+}
+
+// newVideoJSON converts a database video into its JSON representation
+func (h *Handler) newVideoJSON(v *database.Video, rating *database.Rating, tags []string) VideoJSON {
+	name := escapeName(h.videoName(v))
+	vj := VideoJSON{
+		ID:        v.ID,
+		Filename:  v.Filename,
+		Library:   h.libraryName(v),
+		Size:      v.Size,
+		Duration:  v.Duration,
+		Status:    string(v.Status),
+		CreatedAt: v.CreatedAt,
+		UpdatedAt: v.UpdatedAt,
+		Metadata: MetadataJSON{
+			Container:     v.Container,
+			VideoCodec:    v.VideoCodec,
+			Width:         v.Width,
+			Height:        v.Height,
+			FrameRate:     v.FrameRate,
+			BitDepth:      v.BitDepth,
+			HDR:           v.HDR,
+			AudioCodec:    v.AudioCodec,
+			AudioChannels: v.AudioChannels,
+			CoverArt:      v.CoverArt,
+		},
+		Retry:       newRetryJSON(v),
+		Pinned:      v.Pinned,
+		Tags:        []string{},
+		DirectURL:   h.config.Server.Path("/direct/" + name),
+		DownloadURL: h.config.Server.Path("/download/" + name),
+		ArtworkURL:  h.config.Server.Path(fmt.Sprintf("/api/v1/videos/%d/artwork", v.ID)),
+	}
+	if v.ErrorMessage.Valid {
+		vj.Error = v.ErrorMessage.String
+	}
+	if tags != nil {
+		vj.Tags = tags
+	}
+	if rating != nil {
+		vj.Rating = rating.Rating
+		vj.Favorite = rating.Favorite
+	}
+	if v.Status == database.StatusReady {
+		vj.PlayerURL = h.config.Server.Path("/player/" + name)
+		vj.StreamURL = h.config.Server.Path("/video/" + name)
+	}
+	return vj
+}
+
+// newRetryJSON converts a video's retry state into its JSON representation
+func newRetryJSON(v *database.Video) RetryJSON {
+	rj := RetryJSON{
+		Count:        v.RetryCount,
+		FailureClass: string(v.FailureClass),
+	}
+	if v.NextRetryAt.Valid {
+		rj.NextRetryAt = &v.NextRetryAt.Time
+	}
+	return rj
+}
+
+// APIListVideosHandler returns a page of videos. It accepts the query,
+// status, tag, library, sort, order, favorites, page and per_page query
+// parameters, which can be combined.
+func (h *Handler) APIListVideosHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	perPage := apiDefaultPerPage
+	if v := query.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeJSONError(w, http.StatusBadRequest, "per_page must be between 1 and 500")
+			return
+		}
+		perPage = n
+	}
+	if sort := query.Get("sort"); sort != "" && !database.ValidSort(sort) {
+		writeJSONError(w, http.StatusBadRequest, "invalid sort order")
+		return
+	}
+
+	opts, page := listOptionsFromQuery(query, perPage)
+	opts.User = h.currentUser(r)
+	opts.Dirs = h.libraryDirs(r, query.Get("library"))
+
+	result, err := h.listVideos(opts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := VideoListJSON{
+		Videos:  make([]VideoJSON, 0, len(result.Videos)),
+		Total:   result.Total,
+		Page:    page,
+		PerPage: perPage,
+	}
+	for _, v := range result.Videos {
+		vj := h.newVideoJSON(v, result.Ratings[v.ID], result.Tags[v.ID])
+		if pos, ok := result.Positions[v.ID]; ok {
+			vj.Position = pos.Position
+		}
+		vj.Watched = result.Watched[v.ID]
+		resp.Videos = append(resp.Videos, vj)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// APIVideoHandler returns a single video with its technical details
+func (h *Handler) APIVideoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	rating, err := h.db.GetRating(h.currentUser(r), video.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tags, err := h.db.TagsForVideos([]int64{video.ID})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	pos, err := h.db.GetPosition(h.currentUser(r), video.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	watched, err := h.db.WatchedVideos(h.currentUser(r), []int64{video.ID})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	vj := h.newVideoJSON(video, rating, tags[video.ID])
+	vj.Position = pos.Position
+	vj.Watched = watched[video.ID]
+	resp, err := h.newVideoDetailJSON(vj, video)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// APIVideoStatusHandler returns the processing state of a video
+func (h *Handler) APIVideoStatusHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newVideoStatusJSON(video))
+}
+
+// newVideoStatusJSON converts the processing state of a video into its JSON
+// representation
+func newVideoStatusJSON(video *database.Video) VideoStatusJSON {
+	resp := VideoStatusJSON{
+		ID:     video.ID,
+		Status: string(video.Status),
+		Retry:  newRetryJSON(video),
+	}
+	if video.ErrorMessage.Valid {
+		resp.Error = video.ErrorMessage.String
+	}
+	return resp
+}
+
+// APIVariantsHandler returns the transcoded variants of a video
+func (h *Handler) APIVariantsHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.db.ListCacheEntries(video.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.newVariantsJSON(video, entries))
+}
+
+// newVariantsJSON converts the cache entries of a video into the JSON
+// representation of its variants
+func (h *Handler) newVariantsJSON(video *database.Video, entries []*database.CacheEntry) VariantsJSON {
+	resp := VariantsJSON{Variants: make([]VariantJSON, 0, len(entries))}
+	if video.Status == database.StatusReady {
+		resp.MasterPlaylistURL = h.config.Server.Path("/stream/" + h.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path))
+	}
+	for _, e := range entries {
+		vj := VariantJSON{
+			Name:        e.Variant,
+			PlaylistURL: h.config.Server.Path("/stream/" + path.Clean(e.Playlist)),
+			SizeBytes:   e.SizeBytes,
+			CreatedAt:   e.CreatedAt,
+		}
+		if e.LastAccessedAt.Valid {
+			vj.LastAccessedAt = &e.LastAccessedAt.Time
+		}
+		resp.Variants = append(resp.Variants, vj)
+	}
+	return resp
+}
+
+// APIDeleteVideoHandler deletes a video and its cached output. By default
+// the video is moved to the trash. With permanent=true the database entry
+// is removed instead, and with source=true the source file is deleted too,
+// which always makes the deletion permanent since there'd be nothing left
+// to restore.
+func (h *Handler) APIDeleteVideoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	deleteSource := query.Get("source") == "true"
+	permanent := deleteSource || query.Get("permanent") == "true"
+
+	var err error
+	if permanent {
+		err = h.library.DeleteVideo(video.ID, h.currentUser(r), deleteSource)
+	} else {
+		err = h.library.RemoveVideo(video.ID, h.currentUser(r))
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// APIReprocessHandler queues a video for processing again, clearing any
+// failure state, and has the librarian process it. When the librarian
+// can't be reached, it picks the video up on its next run.
+func (h *Handler) APIReprocessHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.library.Reprocess(video.ID, h.currentUser(r)); err != nil {
+		if errors.Is(err, library.ErrAlreadyProcessing) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := h.jobs.Process(r.Context()); err != nil {
+		h.log.WarnContext(r.Context(), "Error requesting processing from the librarian", "err", err)
+	}
+
+	video, err := h.db.GetVideo(video.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tags, err := h.db.TagsForVideos([]int64{video.ID})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusAccepted, h.newVideoJSON(video, nil, tags[video.ID]))
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/kaero/streaming/internal/library"
+)
+
+// CacheDirJSON is the JSON representation of a directory in the cache
+type CacheDirJSON struct {
+	Dir            string    `json:"dir"`
+	VideoID        int64     `json:"video_id,omitempty"`
+	Filename       string    `json:"filename,omitempty"`
+	SizeBytes      int64     `json:"size_bytes"`
+	Files          int       `json:"files"`
+	LastAccessedAt time.Time `json:"last_accessed_at"`
+	Busy           bool      `json:"busy"`
+	Streaming      bool      `json:"streaming"`
+	Pinned         bool      `json:"pinned"`
+	Warm           bool      `json:"warm"`
+}
+
+// CacheStatsJSON is the cache usage returned by the cache stats endpoint
+type CacheStatsJSON struct {
+	TotalBytes  int64          `json:"total_bytes"`
+	Directories []CacheDirJSON `json:"directories"`
+}
+
+// PinJSON is the pinned state returned by the pin endpoints
+type PinJSON struct {
+	VideoID int64 `json:"video_id"`
+	Pinned  bool  `json:"pinned"`
+}
+
+// PurgeResultJSON is the outcome of a cache purge
+type PurgeResultJSON struct {
+	Removed    []string `json:"removed"`
+	Skipped    []string `json:"skipped"`
+	FreedBytes int64    `json:"freed_bytes"`
+}
+
+// CacheStatsHandler reports the disk usage of every directory in the
+// cache, largest first
+func (h *Handler) CacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	dirs, err := h.library.CacheUsage()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := CacheStatsJSON{Directories: make([]CacheDirJSON, 0, len(dirs))}
+	for _, d := range dirs {
+		dj := CacheDirJSON{
+			Dir:            d.Dir,
+			SizeBytes:      d.SizeBytes,
+			Files:          d.Files,
+			LastAccessedAt: d.LastAccess,
+			Busy:           d.Busy,
+			Streaming:      d.Streaming,
+			Pinned:         d.Pinned(),
+			Warm:           d.Warm,
+		}
+		if d.Video != nil {
+			dj.VideoID = d.Video.ID
+			dj.Filename = d.Video.Filename
+		}
+		resp.TotalBytes += d.SizeBytes
+		resp.Directories = append(resp.Directories, dj)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// PurgeCacheHandler removes the whole cache. Directories running jobs
+// write to are left alone and reported as skipped.
+func (h *Handler) PurgeCacheHandler(w http.ResponseWriter, r *http.Request) {
+	result, err := h.library.PurgeCache(h.currentUser(r))
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PurgeResultJSON{
+		Removed:    result.Removed,
+		Skipped:    result.Skipped,
+		FreedBytes: result.FreedBytes,
+	})
+}
+
+// PurgeVideoCacheHandler removes the cached output of a video. Videos
+// being transcoded are refused with 409.
+func (h *Handler) PurgeVideoCacheHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	freed, err := h.library.PurgeVideoCache(video.ID, h.currentUser(r))
+	if err != nil {
+		if errors.Is(err, library.ErrCacheBusy) {
+			writeJSONError(w, http.StatusConflict, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PurgeResultJSON{
+		Removed:    []string{h.tm.RelativeToCache(h.tm.CacheDirFor(video.CacheDir, video.Path))},
+		Skipped:    []string{},
+		FreedBytes: freed,
+	})
+}
+
+// PinHandler pins (PUT) or unpins (DELETE) a video. The output of pinned
+// videos is kept when the cache is evicted.
+func (h *Handler) PinHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	pinned := r.Method == http.MethodPut
+	if err := h.library.PinVideo(id, pinned, h.currentUser(r)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, PinJSON{VideoID: id, Pinned: pinned})
+}
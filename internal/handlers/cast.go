@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kaero/streaming/internal/auth"
+)
+
+// CastHandler serves the HLS files of a video to cast devices like
+// StreamHandler, authenticated by the cast token in the path,
+// /cast/{token}/{cache dir}/{file}, instead of credentials the device
+// can't send
+func (h *Handler) CastHandler(w http.ResponseWriter, r *http.Request) {
+	filePath := r.PathValue("path")
+	dir, _, _ := strings.Cut(filePath, "/")
+	p, err := h.auth.CastPrincipal(r.PathValue("token"), dir)
+	if err != nil {
+		// Receivers only report that loading failed, so say why in the log
+		h.log.WarnContext(r.Context(), "Refused cast request", "path", filePath, "err", err)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		http.Error(w, "Cast link expired, cast the video again", http.StatusForbidden)
+		return
+	}
+
+	r2 := r.Clone(r.Context())
+	if p != nil {
+		r2 = r2.WithContext(auth.WithPrincipal(r2.Context(), p))
+	}
+	r2.URL.Path = "/stream/" + filePath
+	r2.URL.RawPath = ""
+	h.StreamHandler(w, r2)
+}
+
+// castURL returns the URL a cast device plays a video from, given the
+// master playlist relative to the cache root, or "" when no token could be
+// issued
+func (h *Handler) castURL(r *http.Request, relativePlaylist string) string {
+	path := h.castPath(r, relativePlaylist)
+	if path == "" {
+		return ""
+	}
+	return h.baseURL(r) + h.config.Server.Path(path)
+}
+
+// castPath is like castURL but returns the path below the server's base
+// path, for clients that put the server URL in front themselves
+func (h *Handler) castPath(r *http.Request, relativePlaylist string) string {
+	dir, _, _ := strings.Cut(relativePlaylist, "/")
+	token, err := h.auth.CastToken(auth.FromContext(r.Context()), dir)
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error issuing cast token", "err", err)
+		return ""
+	}
+	return "/cast/" + token + "/" + escapeName(relativePlaylist)
+}
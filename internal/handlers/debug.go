@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/kaero/streaming/internal/cache"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// startTime is when the process started, used to report uptime
+var startTime = time.Now()
+
+// RuntimeStatus is the JSON body of the runtime status endpoint
+type RuntimeStatus struct {
+	GoVersion     string               `json:"go_version"`
+	StartedAt     time.Time            `json:"started_at"`
+	UptimeSeconds int64                `json:"uptime_seconds"`
+	Goroutines    int                  `json:"goroutines"`
+	Memory        MemoryStatus         `json:"memory"`
+	FFmpeg        []transcoder.Process `json:"ffmpeg"`
+	// Streams counts the active streams per user
+	Streams map[string]int `json:"streams"`
+	// HotCache describes the stream files kept in memory, when enabled
+	HotCache *cache.HotCacheStats `json:"hot_cache,omitempty"`
+}
+
+// MemoryStatus holds the Go runtime memory statistics, in bytes
+type MemoryStatus struct {
+	Alloc      uint64 `json:"alloc"`
+	TotalAlloc uint64 `json:"total_alloc"`
+	Sys        uint64 `json:"sys"`
+	HeapInuse  uint64 `json:"heap_inuse"`
+	HeapIdle   uint64 `json:"heap_idle"`
+	StackInuse uint64 `json:"stack_inuse"`
+	NumGC      uint32 `json:"num_gc"`
+}
+
+// RuntimeHandler reports goroutine and memory statistics and the FFmpeg
+// processes run by this process
+func (h *Handler) RuntimeHandler(w http.ResponseWriter, r *http.Request) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	var hot *cache.HotCacheStats
+	if h.hot != nil {
+		stats := h.hot.Stats()
+		hot = &stats
+	}
+
+	writeJSON(w, http.StatusOK, RuntimeStatus{
+		GoVersion:     runtime.Version(),
+		StartedAt:     startTime,
+		UptimeSeconds: int64(time.Since(startTime).Seconds()),
+		Goroutines:    runtime.NumGoroutine(),
+		Memory: MemoryStatus{
+			Alloc:      mem.Alloc,
+			TotalAlloc: mem.TotalAlloc,
+			Sys:        mem.Sys,
+			HeapInuse:  mem.HeapInuse,
+			HeapIdle:   mem.HeapIdle,
+			StackInuse: mem.StackInuse,
+			NumGC:      mem.NumGC,
+		},
+		FFmpeg:   h.tm.ActiveProcesses(),
+		Streams:  h.streams.Active(),
+		HotCache: hot,
+	})
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// artworkMaxAge is how long clients may cache artwork
+const artworkMaxAge = 24 * time.Hour
+
+// artworkTimeout bounds extracting the artwork of a video
+const artworkTimeout = 30 * time.Second
+
+// VideoDetailJSON is the JSON representation of a single video with its
+// technical details
+type VideoDetailJSON struct {
+	VideoJSON
+	Chapters          []ChapterJSON  `json:"chapters"`
+	Subtitles         []SubtitleJSON `json:"subtitles"`
+	MasterPlaylistURL string         `json:"master_playlist_url,omitempty"`
+	Variants          []VariantJSON  `json:"variants"`
+	CacheSizeBytes    int64          `json:"cache_size_bytes"`
+	// CacheQuotaBytes is cache.max_video_size_gb, 0 for no limit
+	CacheQuotaBytes int64 `json:"cache_quota_bytes"`
+	// DroppedVariants were removed to fit the quota
+	DroppedVariants []string      `json:"dropped_variants"`
+	History         []AttemptJSON `json:"history"`
+}
+
+// ChapterJSON is a chapter marker, in seconds
+type ChapterJSON struct {
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title,omitempty"`
+}
+
+// SubtitleJSON is a subtitle track embedded in the source file
+type SubtitleJSON struct {
+	StreamIndex int    `json:"stream_index"`
+	Codec       string `json:"codec"`
+	Language    string `json:"language,omitempty"`
+	Title       string `json:"title,omitempty"`
+	Default     bool   `json:"default"`
+	Forced      bool   `json:"forced"`
+}
+
+// AttemptJSON is a processing attempt, newest first in the history
+type AttemptJSON struct {
+	StartedAt  time.Time  `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	DurationMs int64      `json:"duration_ms"`
+	Success    bool       `json:"success"`
+	Error      string     `json:"error,omitempty"`
+	Command    string     `json:"command,omitempty"`
+	StderrTail string     `json:"stderr_tail,omitempty"`
+}
+
+// newVideoDetailJSON adds the chapters, subtitles, variants, cache size and
+// processing history of a video to its JSON representation
+func (h *Handler) newVideoDetailJSON(vj VideoJSON, video *database.Video) (VideoDetailJSON, error) {
+	resp := VideoDetailJSON{
+		VideoJSON:       vj,
+		Chapters:        []ChapterJSON{},
+		Subtitles:       []SubtitleJSON{},
+		History:         []AttemptJSON{},
+		DroppedVariants: []string{},
+	}
+
+	chapters, err := h.db.ListChapters(video.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, c := range chapters {
+		resp.Chapters = append(resp.Chapters, ChapterJSON{Start: c.Start, End: c.End, Title: c.Title})
+	}
+
+	subtitles, err := h.db.ListSubtitleTracks(video.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, s := range subtitles {
+		resp.Subtitles = append(resp.Subtitles, SubtitleJSON{
+			StreamIndex: s.StreamIndex,
+			Codec:       s.Codec,
+			Language:    s.Language,
+			Title:       s.Title,
+			Default:     s.Default,
+			Forced:      s.Forced,
+		})
+	}
+
+	entries, err := h.db.ListCacheEntries(video.ID)
+	if err != nil {
+		return resp, err
+	}
+	variants := h.newVariantsJSON(video, entries)
+	resp.MasterPlaylistURL = variants.MasterPlaylistURL
+	resp.Variants = variants.Variants
+	for _, e := range entries {
+		resp.CacheSizeBytes += e.SizeBytes
+	}
+	resp.CacheQuotaBytes = h.config.Cache.MaxVideoSizeBytes()
+	if video.DroppedVariants != "" {
+		resp.DroppedVariants = strings.Split(video.DroppedVariants, ",")
+	}
+
+	attempts, err := h.db.ListAttempts(video.ID)
+	if err != nil {
+		return resp, err
+	}
+	for _, a := range attempts {
+		aj := AttemptJSON{
+			StartedAt:  a.StartedAt,
+			DurationMs: a.DurationMs,
+			Success:    a.Success,
+			Command:    a.Command,
+			StderrTail: a.StderrTail,
+		}
+		if a.FinishedAt.Valid {
+			aj.FinishedAt = &a.FinishedAt.Time
+		}
+		if a.ErrorMessage.Valid {
+			aj.Error = a.ErrorMessage.String
+		}
+		resp.History = append(resp.History, aj)
+	}
+
+	return resp, nil
+}
+
+// artworkLocks serializes extracting the artwork of each video
+var artworkLocks sync.Map
+
+// ArtworkHandler serves the artwork of a video: its embedded cover art or a
+// frame from the video. The image is extracted on the first request and
+// kept in the video's cache directory.
+func (h *Handler) ArtworkHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	artwork := filepath.Join(h.tm.CacheDirFor(video.CacheDir, video.Path), transcoder.ArtworkFile)
+	info, err := os.Stat(artwork)
+	if errors.Is(err, os.ErrNotExist) {
+		mu, _ := artworkLocks.LoadOrStore(video.ID, &sync.Mutex{})
+		mu.(*sync.Mutex).Lock()
+		info, err = os.Stat(artwork)
+		if errors.Is(err, os.ErrNotExist) {
+			if err = h.extractArtwork(r, video, artwork); err == nil {
+				info, err = os.Stat(artwork)
+			}
+		}
+		mu.(*sync.Mutex).Unlock()
+	}
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error extracting artwork", "video", video.Filename, "err", err)
+		writeJSONError(w, http.StatusNotFound, "artwork not available")
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/jpeg")
+	w.Header().Set("ETag", fileETag(info))
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(artworkMaxAge.Seconds())))
+	http.ServeFile(w, r, artwork)
+}
+
+// ThumbnailsHandler serves the seek previews of a video made while
+// processing it: the WebVTT index at thumbnails.vtt and the sprite sheet
+// it points to at thumbnails.jpg. Videos processed before seek previews
+// were made have none until they are processed again.
+func (h *Handler) ThumbnailsHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	name, contentType := transcoder.ThumbnailsIndex, "text/vtt; charset=utf-8"
+	if strings.HasSuffix(r.URL.Path, ".jpg") {
+		name, contentType = transcoder.ThumbnailsFile, "image/jpeg"
+	}
+	file := filepath.Join(h.tm.CacheDirFor(video.CacheDir, video.Path), name)
+	info, err := os.Stat(file)
+	if video.Status != database.StatusReady || err != nil {
+		writeJSONError(w, http.StatusNotFound, "thumbnails not available")
+		return
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("ETag", fileETag(info))
+	w.Header().Set("Cache-Control", "private, max-age="+strconv.Itoa(int(artworkMaxAge.Seconds())))
+	http.ServeFile(w, r, file)
+}
+
+// extractArtwork extracts the artwork of a video into a file
+func (h *Handler) extractArtwork(r *http.Request, video *database.Video, artwork string) error {
+	ctx, cancel := context.WithTimeout(r.Context(), artworkTimeout)
+	defer cancel()
+	return h.tm.ExtractArtwork(ctx, video.Path, artwork, video.CoverArt, video.Duration)
+}
@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/device"
+)
+
+// DeviceJSON is the JSON representation of a device profile
+type DeviceJSON struct {
+	Name           string   `json:"name"`
+	UserAgents     []string `json:"user_agents"`
+	MaxHeight      int      `json:"max_height,omitempty"`
+	MaxBitrateKbps int      `json:"max_bitrate_kbps,omitempty"`
+	ExcludeCodecs  []string `json:"exclude_codecs,omitempty"`
+}
+
+// APIDevicesHandler lists the device profiles, in the order they are
+// matched against the User-Agent header
+func (h *Handler) APIDevicesHandler(w http.ResponseWriter, r *http.Request) {
+	resp := []DeviceJSON{}
+	for _, d := range h.devices.List() {
+		resp = append(resp, DeviceJSON{
+			Name:           d.Name,
+			UserAgents:     d.UserAgents,
+			MaxHeight:      d.MaxHeight,
+			MaxBitrateKbps: d.MaxBitrateKbps,
+			ExcludeCodecs:  d.ExcludeCodecs,
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// deviceProfile returns the device profile of a request, named by the
+// device query parameter or detected from the User-Agent header. It writes
+// an error response for unknown names.
+func (h *Handler) deviceProfile(w http.ResponseWriter, r *http.Request) (config.DeviceProfile, bool, bool) {
+	if name := r.URL.Query().Get("device"); name != "" {
+		d, ok := h.devices.Get(name)
+		if !ok {
+			http.Error(w, "Unknown device profile", http.StatusBadRequest)
+			return config.DeviceProfile{}, false, false
+		}
+		return d, true, true
+	}
+
+	d, found := h.devices.Detect(r.UserAgent())
+	return d, found, true
+}
+
+// serveForDevice serves a master playlist limited to the variants a device
+// can play. It returns false for other playlists, which are served as is.
+func (h *Handler) serveForDevice(w http.ResponseWriter, r *http.Request, file, fullPath string, info os.FileInfo, d config.DeviceProfile) bool {
+	playlist, ok := h.hotContent(file, fullPath, info)
+	if !ok {
+		var err error
+		if playlist, err = os.ReadFile(fullPath); err != nil {
+			return false
+		}
+	}
+	if !device.IsMasterPlaylist(playlist) {
+		return false
+	}
+
+	filtered := device.Filter(playlist, d)
+	w.Header().Set("ETag", deviceETag(info, d.Name))
+	http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(filtered))
+	return true
+}
+
+// deviceETag is like fileETag, but also differs between device profiles
+func deviceETag(info os.FileInfo, profile string) string {
+	return fmt.Sprintf(`"%x-%x-%x"`, info.ModTime().UnixNano(), info.Size(), profile)
+}
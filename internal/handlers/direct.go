@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/middleware"
+	"github.com/kaero/streaming/internal/playback"
+	"github.com/kaero/streaming/internal/storage"
+)
+
+// DirectHandler serves the source file of a video for progressive playback
+// by clients that can play it natively, with Range support for seeking.
+// With ?remux=mp4 the streams are copied into an MP4 on the fly instead,
+// for sources in containers browsers can't play such as MKV.
+func (h *Handler) DirectHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.sourceVideo(w, r, "/direct/")
+	if !ok {
+		return
+	}
+
+	// Count direct plays against the user's stream limit like HLS streams
+	remux := r.URL.Query().Get("remux") == "mp4"
+	if err := h.touchDirect(r, video, remux); err != nil {
+		h.streamRefused(w, err)
+		return
+	}
+
+	if remux {
+		w.Header().Set("Content-Type", "video/mp4")
+		w.Header().Set("Accept-Ranges", "none")
+		cw := &countingWriter{w: w}
+		if err := h.tm.RemuxToMP4(r.Context(), video.Path, cw); err != nil && r.Context().Err() == nil {
+			h.log.ErrorContext(r.Context(), "Error remuxing", "path", video.Path, "err", err)
+			// The status can still be changed if ffmpeg failed before
+			// writing anything, such as for a missing source file
+			if cw.n == 0 {
+				writeJSONError(w, http.StatusInternalServerError, "remuxing failed")
+			}
+		}
+		return
+	}
+
+	h.serveSource(w, r, video)
+}
+
+// touchDirect records a direct play request in its playback session. The
+// position is estimated from the start of the requested byte range.
+func (h *Handler) touchDirect(r *http.Request, video *database.Video, remux bool) error {
+	key := streamKey(r, fmt.Sprintf("direct-%d", video.ID))
+	if _, err := h.streams.Touch(streamUser(r), key, middleware.ClientIP(r)); err != nil {
+		return err
+	}
+
+	variant := "original"
+	if remux {
+		variant = "remux"
+	}
+	h.streams.Update(key, func(s *playback.Session) {
+		s.VideoID, s.Video, s.Variant = video.ID, video.Filename, variant
+		if offset := rangeStart(r.Header.Get("Range")); offset > 0 && video.Size > 0 {
+			s.Position = time.Duration(float64(offset) / float64(video.Size) * video.Duration * float64(time.Second))
+		}
+	})
+	return nil
+}
+
+// rangeStart returns the first byte of a "bytes=N-" Range header, or 0
+func rangeStart(header string) int64 {
+	spec, ok := strings.CutPrefix(header, "bytes=")
+	if !ok {
+		return 0
+	}
+	start, _, _ := strings.Cut(spec, "-")
+	n, err := strconv.ParseInt(start, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// sourceVideo looks up the video named by the request path after prefix,
+// writing an error response if it can't
+func (h *Handler) sourceVideo(w http.ResponseWriter, r *http.Request, prefix string) (*database.Video, bool) {
+	videoFile := strings.TrimPrefix(r.URL.Path, prefix)
+	if videoFile == "" {
+		http.Error(w, "Video file not specified", http.StatusBadRequest)
+		return nil, false
+	}
+
+	videoPath, ok := h.mediaPath(w, r, videoFile)
+	if !ok {
+		return nil, false
+	}
+
+	video, err := h.db.GetVideoByPath(videoPath)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving video from database: %v", err), http.StatusInternalServerError)
+		return nil, false
+	}
+	if video == nil {
+		http.Error(w, "Video not found in the library", http.StatusNotFound)
+		return nil, false
+	}
+
+	return video, true
+}
+
+// serveSource serves the source file of a video with Range and conditional
+// request support. Videos of remote libraries are served by their source.
+func (h *Handler) serveSource(w http.ResponseWriter, r *http.Request, video *database.Video) {
+	if lib, ok := h.config.LibraryFor(video.Path); ok && lib.Remote() {
+		src, err := storage.NewSource(lib)
+		if err != nil {
+			h.log.ErrorContext(r.Context(), "Error opening library source", "library", lib.Name, "err", err)
+			http.Error(w, "Error opening source file", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", storage.SourceContentType(video.Path))
+		src.Serve(w, r, video.Path)
+		return
+	}
+
+	f, err := os.Open(video.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "Source file not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, "Error opening source file", http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, "Error reading source file", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", storage.SourceContentType(video.Path))
+	w.Header().Set("ETag", fileETag(info))
+	http.ServeContent(w, r, filepath.Base(video.Path), info.ModTime(), f)
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+// Write writes b to the underlying writer
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
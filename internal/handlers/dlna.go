@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DLNAMediaHandler serves the source file of a video to DLNA clients, which
+// find it by ID in the ContentDirectory. The file name after the ID only
+// helps clients that look at the extension.
+func (h *Handler) DLNAMediaHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := h.touchDirect(r, video, false); err != nil {
+		h.streamRefused(w, err)
+		return
+	}
+	h.serveSource(w, r, video)
+}
+
+// DLNAStreamHandler serves the HLS files of a video to DLNA clients like
+// StreamHandler, without the authentication of /stream/
+func (h *Handler) DLNAStreamHandler(w http.ResponseWriter, r *http.Request) {
+	r2 := r.Clone(r.Context())
+	r2.URL.Path = "/stream/" + strings.TrimPrefix(r.URL.Path, "/dlna/stream/")
+	r2.URL.RawPath = ""
+	h.StreamHandler(w, r2)
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"mime"
+	"net/http"
+	"path/filepath"
+)
+
+// DownloadHandler sends the source file of a video as an attachment.
+// Interrupted downloads can be resumed with Range and If-Range requests.
+func (h *Handler) DownloadHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.sourceVideo(w, r, "/download/")
+	if !ok {
+		return
+	}
+
+	// Log the start of a download, not every resumed part of it
+	if r.Header.Get("Range") == "" {
+		h.log.InfoContext(r.Context(), "User downloading video", "user", h.currentUser(r), "video", video.Filename)
+	}
+
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": filepath.Base(video.Path),
+	}))
+	h.serveSource(w, r, video)
+}
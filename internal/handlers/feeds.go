@@ -0,0 +1,191 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/storage"
+)
+
+// feedItems is the number of newest videos in a feed
+const feedItems = 50
+
+// FeedData is a feed of the newest videos, rendered as RSS or Atom
+type FeedData struct {
+	Title       string
+	Description string
+	// Link is the web UI page listing the videos, Self the feed itself
+	// and ID the feed without credentials
+	Link    string
+	Self    string
+	ID      string
+	Updated time.Time
+	Items   []FeedItem
+}
+
+// FeedItem is a video in a feed. The enclosure is its direct play URL.
+type FeedItem struct {
+	Title        string
+	Summary      string
+	Link         string
+	EnclosureURL string
+	ContentType  string
+	Size         int64
+	// Duration is in whole seconds, 0 when unknown
+	Duration  int
+	ImageURL  string
+	Published time.Time
+	Updated   time.Time
+}
+
+// FeedHandler serves the newest videos as an RSS or Atom feed, given by
+// the format path value. The feed lists every video the user can see, or
+// those with a tag or in a series: a folder directly below a library.
+// Links carry the API key the feed was requested with, so podcast apps
+// can download the videos.
+func (h *Handler) FeedHandler(w http.ResponseWriter, r *http.Request) {
+	format := r.PathValue("format")
+	if format != "rss" && format != "atom" {
+		http.Error(w, "Unknown feed format", http.StatusNotFound)
+		return
+	}
+
+	opts := database.ListOptions{
+		Sort:      database.SortAdded,
+		Desc:      true,
+		Limit:     feedItems,
+		Dirs:      h.libraryDirs(r, ""),
+		Tag:       r.PathValue("tag"),
+		SkipTotal: true,
+	}
+	base := h.baseURL(r)
+	data := FeedData{
+		Title:       "New videos",
+		Description: "The newest videos of the library",
+		Link:        base + h.config.Server.Path("/"),
+		ID:          base + h.config.Server.Path(r.URL.Path),
+		Self:        base + h.config.Server.Path(r.URL.RequestURI()),
+	}
+	if opts.Tag != "" {
+		data.Title = "New videos tagged " + opts.Tag
+		data.Description = fmt.Sprintf("The newest videos tagged %s", opts.Tag)
+		data.Link += "?tag=" + url.QueryEscape(opts.Tag)
+	}
+	if series := r.PathValue("series"); series != "" {
+		if series == "." || series == ".." || strings.ContainsAny(series, `/\`) {
+			http.Error(w, "Series not found", http.StatusNotFound)
+			return
+		}
+		opts.Dirs = []string{}
+		for _, lib := range h.visibleLibraries(r) {
+			opts.Dirs = append(opts.Dirs, filepath.Join(lib.MediaDir, series))
+		}
+		data.Title = series
+		data.Description = fmt.Sprintf("The newest videos of %s", series)
+	}
+
+	page, err := h.db.ListVideos(opts)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error listing videos: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	data.Updated = time.Now()
+	if len(page.Videos) > 0 {
+		data.Updated = page.Videos[0].CreatedAt
+	}
+	key := ""
+	if auth.FromContext(r.Context()) != nil {
+		key = auth.LinkKey(r)
+	}
+	for _, v := range page.Videos {
+		data.Items = append(data.Items, h.feedItem(base, key, v))
+	}
+
+	if format == "atom" {
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		err = h.templates.AtomTemplate(w, data)
+	} else {
+		w.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+		err = h.templates.RSSTemplate(w, data)
+	}
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error rendering feed", "err", err)
+	}
+}
+
+// feedItem returns the feed item of a video, with links on base that
+// carry key when it isn't empty
+func (h *Handler) feedItem(base, key string, v *database.Video) FeedItem {
+	withKey := func(p string) string {
+		u := base + h.config.Server.Path(p)
+		if key != "" {
+			u += "?access_token=" + url.QueryEscape(key)
+		}
+		return u
+	}
+	name := escapeName(h.videoName(v))
+
+	var summary []string
+	if lib := h.libraryName(v); lib != "" {
+		summary = append(summary, lib)
+	}
+	if series := h.seriesOf(v); series != "" {
+		summary = append(summary, series)
+	}
+	if v.Duration > 0 {
+		summary = append(summary, time.Duration(v.Duration*float64(time.Second)).Round(time.Second).String())
+	}
+	if v.Width > 0 && v.Height > 0 {
+		summary = append(summary, fmt.Sprintf("%dx%d", v.Width, v.Height))
+	}
+
+	return FeedItem{
+		Title:        strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename)),
+		Summary:      strings.Join(summary, " · "),
+		Link:         base + h.config.Server.Path("/player/"+name),
+		EnclosureURL: withKey("/direct/" + name),
+		ContentType:  storage.SourceContentType(v.Path),
+		Size:         v.Size,
+		Duration:     int(v.Duration),
+		ImageURL:     withKey(fmt.Sprintf("/api/v1/videos/%d/artwork", v.ID)),
+		Published:    v.CreatedAt,
+		Updated:      v.UpdatedAt,
+	}
+}
+
+// seriesOf returns the series of a video: the folder it is in directly
+// below its library, or "" for videos at the top of the library
+func (h *Handler) seriesOf(v *database.Video) string {
+	lib, ok := h.config.LibraryFor(v.Path)
+	if !ok {
+		return ""
+	}
+	rel, err := filepath.Rel(lib.MediaDir, v.Path)
+	if err != nil {
+		return ""
+	}
+	series, _, ok := strings.Cut(filepath.ToSlash(rel), "/")
+	if !ok {
+		return ""
+	}
+	return series
+}
+
+// baseURL returns the scheme and host of links handed to other
+// applications: server.public_url, or those the request was made to
+func (h *Handler) baseURL(r *http.Request) string {
+	if h.config.Server.PublicURL != "" {
+		return h.config.Server.PublicURL
+	}
+	if r.TLS != nil {
+		return "https://" + r.Host
+	}
+	return "http://" + r.Host
+}
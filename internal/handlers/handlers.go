@@ -1,16 +1,33 @@
 package handlers
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/cache"
+	"github.com/kaero/streaming/internal/control"
 	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/device"
+	"github.com/kaero/streaming/internal/events"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/live"
+	"github.com/kaero/streaming/internal/playback"
+	"github.com/kaero/streaming/internal/safepath"
+	"github.com/kaero/streaming/internal/telemetry"
 	"github.com/kaero/streaming/internal/templates"
 	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/upload"
 )
 
 // Handler holds all HTTP handlers for the streaming server
@@ -19,37 +36,142 @@ type Handler struct {
 	tm        *transcoder.Manager
 	templates *templates.Templates
 	db        *database.DB
-	refreshCh chan struct{}
+	library   *library.Manager
+	tracker   *cache.AccessTracker
+	// hot serves playlists and first segments from memory, nil when
+	// cache.hot_cache_mb is 0
+	hot       *cache.HotCache
+	uploads   *upload.Store
+	events    *events.Hub
+	auth      *auth.Authenticator
+	streams   *playback.Tracker
+	devices   *device.Profiles
+	// live runs the live channels, nil when none are configured
+	live      *live.Manager
+	// jobs has the librarian scan, process and cancel on behalf of the
+	// web UI and the API
+	jobs      control.Jobs
+	log       *slog.Logger
 }
 
 // VideoView represents a video file with UI metadata
 type VideoView struct {
-	Name     string
-	SizeMB   int64
-	Status   string
-	CanPlay  bool
-	ErrorMsg string
+	ID         int64
+	Name       string
+	// Path is the escaped name of the video in URLs
+	Path       string
+	Library    string
+	SizeMB     int64
+	Status     string
+	CanPlay    bool
+	ErrorMsg   string
+	Resolution string
+	Codec      string
+	// Artwork is the URL of the poster, "" for videos not ready to play
+	Artwork    string
+	// Progress is how far the user got into the video in percent, 0 when
+	// they haven't started it, and Watched whether they watched it to the
+	// end before
+	Progress   int
+	Watched    bool
+	Rating     int
+	Favorite   bool
+	// Pinned videos keep their output when the cache is evicted
+	Pinned     bool
+	RetryInfo  string
+	Tags       []string
 }
 
 // ListData holds data for the list template
 type ListData struct {
-	Videos   []VideoView
-	ShowScan bool
+	Videos     []VideoView
+	// Continue are the videos the user stopped watching part way through
+	Continue   []VideoView
+	ShowScan   bool
+	Status     string
+	Sort       string
+	Desc       bool
+	Favorites  bool
+	Query      string
+	Tag        string
+	Tags       []string
+	// Libraries are the libraries the user can see, when configured
+	Libraries  []string
+	Library    string
+	// Live are the live channels on air
+	Live       []string
+	Page       int
+	TotalPages int
+	Total      int
+	// PageSize is the number of videos per page, which the grid loads
+	// the following pages with from the API
+	PageSize   int
+	PrevURL    string
+	NextURL    string
+	User       string
+	// Admin shows the controls that need the admin scope
+	Admin      bool
 }
 
+// listPageSize is the number of videos shown per page of the HTML list
+const listPageSize = 50
+
 // PlayerData holds data for the player template
 type PlayerData struct {
+	VideoID   int64
 	VideoFile string
+	// VideoPath is VideoFile escaped for URLs
+	VideoPath string
+	// Device is the device profile requested for the stream, if any
+	Device string
+	Details   []DetailRow
+	// ResumeInterval is how often the player saves the playback position,
+	// in seconds, 0 when resuming is disabled
+	ResumeInterval int
+	// LimitMessage replaces the player when the user can't start another stream
+	LimitMessage string
+	// CastURL is the master playlist for cast devices, "" when casting
+	// isn't possible
+	CastURL string
+	// Status is the processing status of the video; videos that aren't
+	// ready show it with ErrorMsg and RetryInfo instead of the player
+	Status    string
+	ErrorMsg  string
+	RetryInfo string
+	// NextName and NextURL are the player of the next video of the series,
+	// offered when this one ends, "" for the last one
+	NextName string
+	NextURL  string
+	// Autoplay starts playing once the stream loaded, when the previous
+	// video of the series played on into this one
+	Autoplay bool
+}
+
+// DetailRow is a labelled line in the video detail view
+type DetailRow struct {
+	Label string
+	Value string
 }
 
-// NewHandler creates a new Handler instance
-func NewHandler(cfg *config.Config, tm *transcoder.Manager, tmpl *templates.Templates, db *database.DB) *Handler {
+// NewHandler creates a new Handler instance running library jobs through
+// jobs and logging to logger
+func NewHandler(cfg *config.Config, tm *transcoder.Manager, tmpl *templates.Templates, db *database.DB, lm *library.Manager, tracker *cache.AccessTracker, uploads *upload.Store, hub *events.Hub, authn *auth.Authenticator, devices *device.Profiles, lv *live.Manager, jobs control.Jobs, logger *slog.Logger) *Handler {
 	return &Handler{
 		config:    cfg,
 		tm:        tm,
 		templates: tmpl,
 		db:        db,
-		refreshCh: make(chan struct{}, 1),
+		library:   lm,
+		tracker:   tracker,
+		hot:       newHotCache(cfg),
+		uploads:   uploads,
+		events:    hub,
+		auth:      authn,
+		streams:   newStreamTracker(cfg),
+		devices:   devices,
+		live:      lv,
+		jobs:      jobs,
+		log:       logger,
 	}
 }
 
@@ -63,8 +185,11 @@ func (h *Handler) VideoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Check if the requested file exists in the database
-	videoPath := filepath.Join(h.config.Media.MediaDir, videoFile)
-	dbVideo, err := h.db.GetVideoByPath(videoPath)
+	videoPath, ok := h.mediaPath(w, r, videoFile)
+	if !ok {
+		return
+	}
+	dbVideo, err := h.db.WithContext(r.Context()).GetVideoByPath(videoPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving video from database: %v", err), http.StatusInternalServerError)
 		return
@@ -89,7 +214,8 @@ func (h *Handler) VideoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 		
 	case database.StatusError:
-		http.Error(w, fmt.Sprintf("Error processing video: %s", dbVideo.ErrorMessage), http.StatusInternalServerError)
+		h.log.WarnContext(r.Context(), "Can't stream video, processing failed", "video", videoFile, "id", dbVideo.ID, "err", dbVideo.ErrorMessage.String)
+		http.Error(w, fmt.Sprintf("Error processing video: %s", dbVideo.ErrorMessage.String), http.StatusInternalServerError)
 		return
 		
 	case database.StatusReady:
@@ -101,39 +227,94 @@ func (h *Handler) VideoHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Create the output directory path
-	outputDir := filepath.Join(h.config.Media.CacheDir, strings.TrimSuffix(videoFile, filepath.Ext(videoFile)))
-	masterPlaylist := filepath.Join(outputDir, videoFile+".m3u8")
+	// Locate the master playlist recorded by the librarian
+	relativePlaylist := h.tm.MasterPlaylistFor(dbVideo.MasterPlaylist, dbVideo.Path)
+	masterPlaylist := filepath.Join(h.config.Media.CacheDir, relativePlaylist)
 	
 	// Check if master playlist exists
 	if _, err := os.Stat(masterPlaylist); os.IsNotExist(err) {
+		h.log.WarnContext(r.Context(), "Can't stream video, playlist is missing", "video", videoFile, "id", dbVideo.ID, "playlist", masterPlaylist)
 		http.Error(w, "Video playlist not found, reprocess the video", http.StatusNotFound)
 		return
 	}
 	
-	// Redirect to the master playlist
-	relativePlaylist := strings.TrimPrefix(masterPlaylist, h.config.Media.CacheDir+"/")
-	http.Redirect(w, r, "/stream/"+relativePlaylist, http.StatusFound)
+	// Refuse to start another stream for users at their limit
+	key := streamKey(r, relativePlaylist)
+	telemetry.SpanFromContext(r.Context()).SetAttributes("playback.session", key)
+	if err := h.streams.Check(streamUser(r), key); err != nil {
+		h.streamRefused(w, err)
+		return
+	}
+	
+	// Redirect to the master playlist, keeping the device hint
+	target := h.config.Server.Path("/stream/" + relativePlaylist)
+	if name := r.URL.Query().Get("device"); name != "" {
+		if _, ok := h.devices.Get(name); !ok {
+			http.Error(w, "Unknown device profile", http.StatusBadRequest)
+			return
+		}
+		target += "?device=" + url.QueryEscape(name)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
 }
 
 // StreamHandler serves HLS files
 func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the file path from the request
 	filePath := strings.TrimPrefix(r.URL.Path, "/stream/")
-	fullPath := filepath.Join(h.config.Media.CacheDir, filePath)
 	
-	// Check if the file exists
-	if _, err := os.Stat(fullPath); os.IsNotExist(err) {
+	// Resolve the file within the cache directory, refusing traversal and
+	// symlinks that point elsewhere
+	fullPath, err := safepath.Resolve(h.config.Media.CacheDir, filePath)
+	if errors.Is(err, safepath.ErrInvalidPath) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	var info os.FileInfo
+	if err == nil {
+		// Check if the file exists, directories aren't listed
+		info, err = os.Stat(fullPath)
+		if err == nil && info.IsDir() {
+			http.Error(w, "File not found", http.StatusNotFound)
+			return
+		}
+	}
+	
+	// Segments missing from the disk may be in object storage
+	remote := os.IsNotExist(err) && h.library.Store().Holds(filePath)
+	if os.IsNotExist(err) && !remote {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil && !remote {
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	
+	// Refuse streams of videos in libraries the user can't see
+	allowed, err := h.canStream(r, filePath)
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error looking up the video of a file", "path", filePath, "err", err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
 		http.Error(w, "File not found", http.StatusNotFound)
 		return
 	}
 	
 	// Set appropriate content type based on file extension
-	switch filepath.Ext(fullPath) {
+	switch filepath.Ext(filePath) {
 	case ".m3u8":
 		w.Header().Set("Content-Type", "application/x-mpegURL")
 	case ".ts":
 		w.Header().Set("Content-Type", "video/MP2T")
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	case ".vtt":
+		w.Header().Set("Content-Type", "text/vtt; charset=utf-8")
 	default:
 		w.Header().Set("Content-Type", "application/octet-stream")
 	}
@@ -141,7 +322,9 @@ func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
 	// Add CORS headers for compatibility
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type")
+	w.Header().Set("Access-Control-Allow-Headers", "Origin, Content-Type, Range")
+	// Cast receivers read the length of segments
+	w.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Range")
 	
 	// Handle OPTIONS request for CORS preflight
 	if r.Method == "OPTIONS" {
@@ -149,6 +332,41 @@ func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
+	// Master playlists depend on the device
+	profile, limited, ok := h.deviceProfile(w, r)
+	if !ok {
+		return
+	}
+	
+	// Count the stream against the user's limit and track its session
+	if err := h.touchStream(r, filePath); err != nil {
+		h.streamRefused(w, err)
+		return
+	}
+	
+	// Record the access for cache eviction
+	h.tracker.Touch(filePath)
+	
+	// Let clients cache the file and revalidate it with conditional requests
+	w.Header().Set("Cache-Control", h.streamCacheControl(filePath))
+	if remote {
+		h.library.Store().Serve(w, r, filePath)
+		return
+	}
+	if filepath.Ext(fullPath) == ".m3u8" {
+		w.Header().Set("Vary", "User-Agent")
+		if limited && h.serveForDevice(w, r, filePath, fullPath, info, profile) {
+			return
+		}
+	}
+	w.Header().Set("ETag", fileETag(info))
+	
+	// Serve playlists and first segments from memory when possible
+	if content, ok := h.hotContent(filePath, fullPath, info); ok {
+		http.ServeContent(w, r, "", info.ModTime(), bytes.NewReader(content))
+		return
+	}
+	
 	// Serve the file
 	http.ServeFile(w, r, fullPath)
 }
@@ -157,21 +375,30 @@ func (h *Handler) StreamHandler(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) ListVideosHandler(w http.ResponseWriter, r *http.Request) {
 	// Handle the scan library action
 	if r.URL.Query().Get("scan") == "true" {
-		// Send a refresh signal
-		select {
-		case h.refreshCh <- struct{}{}:
-			// Signal sent successfully
-		default:
-			// Channel is full, a refresh is already pending
+		// Have the librarian scan, a pending scan covers this one
+		queued, err := h.jobs.Scan(r.Context())
+		if err != nil {
+			h.log.ErrorContext(r.Context(), "Error requesting library scan", "err", err)
+			http.Error(w, "Error requesting library scan: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if queued {
+			h.log.InfoContext(r.Context(), "Library scan requested from web UI")
 		}
 		
 		// Redirect back to the list page
-		http.Redirect(w, r, "/", http.StatusSeeOther)
+		http.Redirect(w, r, h.config.Server.Path("/"), http.StatusSeeOther)
 		return
 	}
 	
-	// Get all videos from the database
-	dbVideos, err := h.db.ListVideos()
+	// Parse filter, sort and pagination parameters
+	opts, page := listOptionsFromQuery(r.URL.Query(), listPageSize)
+	opts.User = h.currentUser(r)
+	library := r.URL.Query().Get("library")
+	opts.Dirs = h.libraryDirs(r, library)
+	
+	// Get the requested page of videos, as the JSON API does
+	result, err := h.listVideos(opts)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving videos from database: %v", err), http.StatusInternalServerError)
 		return
@@ -180,75 +407,260 @@ func (h *Handler) ListVideosHandler(w http.ResponseWriter, r *http.Request) {
 	var videos []VideoView
 	
 	// Convert database videos to view models
-	for _, dbVideo := range dbVideos {
-		canPlay := dbVideo.Status == database.StatusReady
-		errorMsg := ""
-		if dbVideo.Status == database.StatusError && dbVideo.ErrorMessage.Valid {
-			errorMsg = dbVideo.ErrorMessage.String
+	for _, dbVideo := range result.Videos {
+		view := newVideoView(dbVideo)
+		view.Path = escapeName(h.videoName(dbVideo))
+		view.Library = h.libraryName(dbVideo)
+		if rating, ok := result.Ratings[dbVideo.ID]; ok {
+			view.Rating = rating.Rating
+			view.Favorite = rating.Favorite
 		}
-		
-		videos = append(videos, VideoView{
-			Name:     dbVideo.Filename,
-			SizeMB:   dbVideo.Size / (1024 * 1024),
-			Status:   string(dbVideo.Status),
-			CanPlay:  canPlay,
-			ErrorMsg: errorMsg,
-		})
+		if pos, ok := result.Positions[dbVideo.ID]; ok && dbVideo.Duration > 0 {
+			view.Progress = min(int(pos.Position*100/dbVideo.Duration), 100)
+		}
+		view.Watched = result.Watched[dbVideo.ID]
+		if view.CanPlay {
+			view.Artwork = h.config.Server.Path(fmt.Sprintf("/api/v1/videos/%d/artwork", dbVideo.ID))
+		}
+		view.Tags = result.Tags[dbVideo.ID]
+		videos = append(videos, view)
+	}
+	
+	// Files that aren't in the database yet are only shown on the first
+	// unfiltered page
+	var continueWatching []VideoView
+	if page == 1 && len(opts.Statuses) == 0 && !opts.FavoritesOnly && opts.Query == "" && opts.Tag == "" {
+		videos = append(videos, h.unprocessedVideos(r, library)...)
+		// As are the videos to continue watching, above the library
+		if library == "" {
+			continueWatching, err = h.continueWatching(r)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("Error retrieving playback positions: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+	
+	// Offer the tags in use as a filter
+	tags, err := h.db.ListTags()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error retrieving tags from database: %v", err), http.StatusInternalServerError)
+		return
+	}
+	
+	totalPages := (result.Total + listPageSize - 1) / listPageSize
+	data := ListData{
+		Videos:     videos,
+		Continue:   continueWatching,
+		ShowScan:   true,
+		Status:     r.URL.Query().Get("status"),
+		Sort:       opts.Sort,
+		Desc:       opts.Desc,
+		Favorites:  opts.FavoritesOnly,
+		Query:      opts.Query,
+		Tag:        opts.Tag,
+		Tags:       tags,
+		Library:    library,
+		Page:       page,
+		TotalPages: max(totalPages, 1),
+		Total:      result.Total,
+		PageSize:   listPageSize,
+		Live:       h.liveChannelNames(),
+		Admin:      isAdmin(r),
+	}
+	if p := auth.FromContext(r.Context()); p != nil {
+		data.User = p.Name
+	}
+	for _, lib := range h.visibleLibraries(r) {
+		if lib.Name != "" {
+			data.Libraries = append(data.Libraries, lib.Name)
+		}
+	}
+	if page > 1 {
+		data.PrevURL = pageURL(r.URL.Query(), page-1)
+	}
+	if page < totalPages {
+		data.NextURL = pageURL(r.URL.Query(), page+1)
+	}
+	
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = h.templates.ListTemplate(w, data)
+	if err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
+
+// listOptionsFromQuery builds list options from the status, sort, order,
+// favorites and page query parameters and returns them with the requested
+// page number
+func listOptionsFromQuery(query url.Values, pageSize int) (database.ListOptions, int) {
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	
+	opts := database.ListOptions{
+		Sort:   database.SortName,
+		Desc:   query.Get("order") == "desc",
+		Limit:  pageSize,
+		Offset: (page - 1) * pageSize,
+		// Any non-empty value enables the filter, matching the checkbox
+		FavoritesOnly: query.Get("favorites") != "",
+	}
+	if sort := query.Get("sort"); database.ValidSort(sort) {
+		opts.Sort = sort
+	}
+	if status := query.Get("status"); status != "" {
+		opts.Statuses = []database.VideoStatus{database.VideoStatus(status)}
 	}
+	opts.Query = strings.TrimSpace(query.Get("query"))
+	opts.Tag = strings.TrimSpace(query.Get("tag"))
 	
-	// Check for files in the media directory that aren't in the database
-	files, err := os.ReadDir(h.config.Media.MediaDir)
+	return opts, page
+}
+
+// videoList is a page of videos together with the current user's ratings,
+// positions and watched videos, and the tags of the videos
+type videoList struct {
+	*database.VideoPage
+	Ratings   map[int64]*database.Rating
+	Positions map[int64]*database.Position
+	Watched   map[int64]bool
+	Tags      map[int64][]string
+}
+
+// listVideos lists the videos matching opts. It is shared by the web UI and
+// the JSON API, so both filter and sort the same way.
+func (h *Handler) listVideos(opts database.ListOptions) (*videoList, error) {
+	page, err := h.db.ListVideos(opts)
+	if err != nil {
+		return nil, err
+	}
+	
+	ids := make([]int64, len(page.Videos))
+	for i, v := range page.Videos {
+		ids[i] = v.ID
+	}
+	ratings, err := h.db.RatingsForVideos(opts.User, ids)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := h.db.PositionsForVideos(opts.User, ids)
 	if err != nil {
-		// Log the error but continue with whatever we have from the database
-		fmt.Printf("Error reading media directory: %v\n", err)
-	} else {
-		// Check for video files not in the database
+		return nil, err
+	}
+	watched, err := h.db.WatchedVideos(opts.User, ids)
+	if err != nil {
+		return nil, err
+	}
+	tags, err := h.db.TagsForVideos(ids)
+	if err != nil {
+		return nil, err
+	}
+	
+	return &videoList{VideoPage: page, Ratings: ratings, Positions: positions, Watched: watched, Tags: tags}, nil
+}
+
+// pageURL returns the list URL for another page with the same filters
+func pageURL(query url.Values, page int) string {
+	q := url.Values{}
+	for key, values := range query {
+		q[key] = values
+	}
+	q.Set("page", strconv.Itoa(page))
+	return "?" + q.Encode()
+}
+
+// newVideoView converts a database video into its list view model
+func newVideoView(dbVideo *database.Video) VideoView {
+	errorMsg := ""
+	if dbVideo.Status == database.StatusError && dbVideo.ErrorMessage.Valid {
+		errorMsg = dbVideo.ErrorMessage.String
+	}
+	
+	return VideoView{
+		ID:         dbVideo.ID,
+		Name:       dbVideo.Filename,
+		SizeMB:     dbVideo.Size / (1024 * 1024),
+		Status:     string(dbVideo.Status),
+		CanPlay:    dbVideo.Status == database.StatusReady,
+		ErrorMsg:   errorMsg,
+		Resolution: resolutionLabel(dbVideo.Metadata),
+		Codec:      dbVideo.VideoCodec,
+		Pinned:     dbVideo.Pinned,
+		RetryInfo:  retryInfo(dbVideo),
+	}
+}
+
+// retryInfo describes what will happen next to a failed video
+func retryInfo(v *database.Video) string {
+	if v.Status != database.StatusError {
+		return ""
+	}
+	switch {
+	case v.NextRetryAt.Valid:
+		return fmt.Sprintf("Will retry at %s (failed %d times)",
+			v.NextRetryAt.Time.Local().Format("Jan 2 15:04"), v.RetryCount)
+	case v.FailureClass == database.FailureNoSpace:
+		return "Waiting for free space on the cache disk"
+	case v.FailureClass == database.FailurePermanent:
+		return "Not retrying, the file appears to be broken"
+	case v.RetryCount > 0:
+		return fmt.Sprintf("Gave up after %d attempts", v.RetryCount)
+	}
+	return ""
+}
+
+// unprocessedVideos lists video files in the media directories the user
+// can see that the librarian hasn't picked up yet, only those of the named
+// library if one is given
+func (h *Handler) unprocessedVideos(r *http.Request, libName string) []VideoView {
+	var videos []VideoView
+	for _, lib := range h.visibleLibraries(r) {
+		// Remote libraries are only listed by scans
+		if (libName != "" && lib.Name != libName) || lib.Remote() {
+			continue
+		}
+		
+		files, err := os.ReadDir(lib.MediaDir)
+		if err != nil {
+			// Log the error but continue with whatever we have from the database
+			h.log.ErrorContext(r.Context(), "Error reading media directory", "dir", lib.MediaDir, "err", err)
+			continue
+		}
+		
 		for _, file := range files {
 			if file.IsDir() {
 				continue
 			}
 			
+			if !library.IsVideoFile(strings.ToLower(filepath.Ext(file.Name()))) {
+				continue
+			}
+			
+			// Skip files the library already knows about, including the trash
+			known, err := h.db.VideoExists(filepath.Join(lib.MediaDir, file.Name()))
+			if err != nil || known {
+				continue
+			}
+			
 			fileInfo, err := file.Info()
 			if err != nil {
 				continue
 			}
 			
-			ext := strings.ToLower(filepath.Ext(file.Name()))
-			// Check if it's a video file
-			if ext == ".mp4" || ext == ".mkv" || ext == ".avi" || ext == ".mov" || ext == ".webm" {
-				// Check if this file is already in the videos list
-				found := false
-				for _, v := range videos {
-					if v.Name == file.Name() {
-						found = true
-						break
-					}
-				}
-				
-				// If not found, add it as an unprocessed video
-				if !found {
-					videos = append(videos, VideoView{
-						Name:     file.Name(),
-						SizeMB:   fileInfo.Size() / (1024 * 1024),
-						Status:   "unprocessed",
-						CanPlay:  false,
-						ErrorMsg: "Video has not been processed yet",
-					})
-				}
-			}
+			videos = append(videos, VideoView{
+				Name:     file.Name(),
+				Library:  lib.Name,
+				SizeMB:   fileInfo.Size() / (1024 * 1024),
+				Status:   "unprocessed",
+				CanPlay:  false,
+				ErrorMsg: "Video has not been processed yet",
+			})
 		}
 	}
 	
-	data := ListData{
-		Videos:   videos,
-		ShowScan: true,
-	}
-	
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
-	err = h.templates.ListTemplate(w, data)
-	if err != nil {
-		http.Error(w, "Error rendering template", http.StatusInternalServerError)
-	}
+	return videos
 }
 
 // PlayerHandler serves a simple video player for a specific video
@@ -261,8 +673,11 @@ func (h *Handler) PlayerHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	
 	// Check if the video is ready for playing
-	videoPath := filepath.Join(h.config.Media.MediaDir, videoFile)
-	dbVideo, err := h.db.GetVideoByPath(videoPath)
+	videoPath, ok := h.mediaPath(w, r, videoFile)
+	if !ok {
+		return
+	}
+	dbVideo, err := h.db.WithContext(r.Context()).GetVideoByPath(videoPath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Error retrieving video from database: %v", err), http.StatusInternalServerError)
 		return
@@ -274,24 +689,100 @@ func (h *Handler) PlayerHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	
-	// Check if the video is ready
+	data := PlayerData{
+		VideoID:        dbVideo.ID,
+		VideoFile:      videoFile,
+		VideoPath:      escapeName(videoFile),
+		Device:         r.URL.Query().Get("device"),
+		Details:        videoDetails(dbVideo),
+		ResumeInterval: h.config.Server.ResumeSaveInterval,
+		Status:         string(dbVideo.Status),
+	}
+	
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	
+	// Show the processing state of videos that aren't ready yet, which the
+	// page follows until the player can be shown
 	if dbVideo.Status != database.StatusReady {
-		http.Error(w, "Video is not ready for playback", http.StatusPreconditionFailed)
+		view := newVideoView(dbVideo)
+		data.ErrorMsg = view.ErrorMsg
+		data.RetryInfo = view.RetryInfo
+		if err := h.templates.PlayerTemplate(w, data); err != nil {
+			http.Error(w, "Error rendering template", http.StatusInternalServerError)
+		}
 		return
 	}
 	
-	data := PlayerData{
-		VideoFile: videoFile,
+	// Show why the video can't be played instead of a failing player
+	relativePlaylist := h.tm.MasterPlaylistFor(dbVideo.MasterPlaylist, dbVideo.Path)
+	key := streamKey(r, relativePlaylist)
+	telemetry.SpanFromContext(r.Context()).SetAttributes("playback.session", key)
+	if err := h.streams.Check(streamUser(r), key); err != nil {
+		data.LimitMessage = h.streamRefusedMessage(err)
+		if errors.Is(err, playback.ErrTerminated) {
+			w.WriteHeader(http.StatusForbidden)
+		} else {
+			w.WriteHeader(http.StatusTooManyRequests)
+		}
+	}
+	
+	data.CastURL = h.castURL(r, relativePlaylist)
+	data.Autoplay = r.URL.Query().Get("autoplay") != ""
+	
+	// Offer to play on into the next video of the series
+	next, err := h.nextInSeries(r, dbVideo)
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error finding the next video of the series", "video", dbVideo.Filename, "err", err)
+	}
+	if next != nil {
+		data.NextName = next.Filename
+		data.NextURL = h.config.Server.Path("/player/"+escapeName(h.videoName(next))) + "?autoplay=1"
+		if data.Device != "" {
+			data.NextURL += "&device=" + url.QueryEscape(data.Device)
+		}
 	}
 	
-	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	err = h.templates.PlayerTemplate(w, data)
 	if err != nil {
 		http.Error(w, "Error rendering template", http.StatusInternalServerError)
 	}
 }
 
-// RefreshChannel returns a channel that signals when a library refresh is requested
-func (h *Handler) RefreshChannel() <-chan struct{} {
-	return h.refreshCh
-}
\ No newline at end of file
+// resolutionLabel returns a short resolution label such as "1080p"
+func resolutionLabel(meta database.Metadata) string {
+	if meta.Height == 0 {
+		return ""
+	}
+	label := fmt.Sprintf("%dp", meta.Height)
+	if meta.HDR {
+		label += " HDR"
+	}
+	return label
+}
+
+// videoDetails builds the technical detail rows shown for a video
+func videoDetails(v *database.Video) []DetailRow {
+	rows := []DetailRow{
+		{"Size", fmt.Sprintf("%d MB", v.Size/(1024*1024))},
+	}
+	if v.Duration > 0 {
+		d := time.Duration(v.Duration * float64(time.Second)).Round(time.Second)
+		rows = append(rows, DetailRow{"Duration", d.String()})
+	}
+	if v.Container != "" {
+		rows = append(rows, DetailRow{"Container", v.Container})
+	}
+	if v.VideoCodec != "" {
+		rows = append(rows,
+			DetailRow{"Video", fmt.Sprintf("%s, %dx%d, %.3g fps", v.VideoCodec, v.Width, v.Height, v.FrameRate)},
+			DetailRow{"Bit depth", fmt.Sprintf("%d-bit", v.BitDepth)},
+		)
+		if v.HDR {
+			rows = append(rows, DetailRow{"HDR", "yes"})
+		}
+	}
+	if v.AudioCodec != "" {
+		rows = append(rows, DetailRow{"Audio", fmt.Sprintf("%s, %d channels", v.AudioCodec, v.AudioChannels)})
+	}
+	return rows
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// readinessTimeout bounds the time spent on all readiness checks
+const readinessTimeout = 5 * time.Second
+
+// HealthResponse is the JSON body of the health and readiness endpoints
+type HealthResponse struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// CheckResult is the outcome of a single readiness check
+type CheckResult struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// HealthHandler reports that the process is up. It never touches any
+// dependency, so it is suitable as a liveness probe.
+func (h *Handler) HealthHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// ReadyHandler reports whether the server can do useful work: the database
+// answers, ffmpeg and ffprobe are installed and the cache is writable. It
+// responds with 503 if any check fails.
+func (h *Handler) ReadyHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := map[string]error{
+		"database": h.db.Ping(ctx),
+		"ffmpeg":   checkExecutable("ffmpeg"),
+		"ffprobe":  checkExecutable("ffprobe"),
+		"cache":    checkWritable(h.config.Media.CacheDir),
+	}
+
+	resp := HealthResponse{Status: "ready", Checks: make(map[string]CheckResult, len(checks))}
+	status := http.StatusOK
+	for name, err := range checks {
+		result := CheckResult{OK: err == nil}
+		if err != nil {
+			result.Error = err.Error()
+			resp.Status = "not ready"
+			status = http.StatusServiceUnavailable
+		}
+		resp.Checks[name] = result
+	}
+
+	writeJSON(w, status, resp)
+}
+
+// checkExecutable checks that a program can be found in PATH
+func checkExecutable(name string) error {
+	_, err := exec.LookPath(name)
+	return err
+}
+
+// checkWritable checks that a file can be created in dir
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".readyz-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// WatchJSON is a video the current user watched to the end
+type WatchJSON struct {
+	ID        int64     `json:"id"`
+	VideoID   int64     `json:"video_id"`
+	Filename  string    `json:"filename"`
+	Duration  float64   `json:"duration"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+// WatchListJSON is a page of the watch history
+type WatchListJSON struct {
+	Watches []WatchJSON `json:"watches"`
+	Total   int         `json:"total"`
+	Page    int         `json:"page"`
+	PerPage int         `json:"per_page"`
+}
+
+// HistoryHandler returns a page of the current user's watch history, most
+// recent first. It accepts the page and per_page query parameters.
+func (h *Handler) HistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	perPage := apiDefaultPerPage
+	if v := query.Get("per_page"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxPerPage {
+			writeJSONError(w, http.StatusBadRequest, "per_page must be between 1 and 500")
+			return
+		}
+		perPage = n
+	}
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	watches, total, err := h.db.ListWatches(h.currentUser(r), perPage, (page-1)*perPage)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := WatchListJSON{
+		Watches: make([]WatchJSON, 0, len(watches)),
+		Total:   total,
+		Page:    page,
+		PerPage: perPage,
+	}
+	for _, wa := range watches {
+		resp.Watches = append(resp.Watches, newWatchJSON(wa))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// newWatchJSON converts a watch into its JSON representation
+func newWatchJSON(wa *database.Watch) WatchJSON {
+	return WatchJSON{
+		ID:        wa.ID,
+		VideoID:   wa.VideoID,
+		Filename:  wa.Filename,
+		Duration:  wa.Duration,
+		WatchedAt: wa.WatchedAt,
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/iptv"
+)
+
+// IPTVPlaylistHandler serves the live channels and the videos the user can
+// see as an M3U playlist for IPTV players, with the programme guide of the
+// channels. The library and tag parameters restrict the videos, and
+// live=only leaves them out. Links carry the API key the playlist was
+// requested with, like those of feeds.
+func (h *Handler) IPTVPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	base := h.baseURL(r)
+	key := ""
+	if auth.FromContext(r.Context()) != nil {
+		key = auth.LinkKey(r)
+	}
+	withKey := func(p string) string {
+		u := base + h.config.Server.Path(p)
+		if key != "" {
+			u += "?access_token=" + url.QueryEscape(key)
+		}
+		return u
+	}
+
+	var entries []iptv.Entry
+	if h.live != nil {
+		for _, c := range h.live.Channels() {
+			entries = append(entries, iptv.Entry{
+				Title:    c.Name,
+				URL:      withKey("/live/" + url.PathEscape(c.Name) + "/index.m3u8"),
+				Duration: -1,
+				GuideID:  c.Name,
+				Group:    "Live",
+			})
+		}
+	}
+
+	if query.Get("live") != "only" {
+		page, err := h.db.ListVideos(database.ListOptions{
+			Dirs:      h.libraryDirs(r, query.Get("library")),
+			Tag:       query.Get("tag"),
+			SkipTotal: true,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Error listing videos: %v", err), http.StatusInternalServerError)
+			return
+		}
+		for _, v := range page.Videos {
+			duration := int(v.Duration)
+			if duration <= 0 {
+				duration = -1
+			}
+			entries = append(entries, iptv.Entry{
+				Title:    strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename)),
+				URL:      withKey(fmt.Sprintf("/iptv/videos/%d", v.ID)),
+				Duration: duration,
+				Logo:     withKey(fmt.Sprintf("/api/v1/videos/%d/artwork", v.ID)),
+				Group:    h.iptvGroup(v),
+			})
+		}
+	}
+
+	guide := ""
+	if h.live != nil {
+		guide = withKey("/live/" + config.LiveEPGFile)
+	}
+	w.Header().Set("Content-Type", "audio/x-mpegurl; charset=utf-8")
+	w.Header().Set("Content-Disposition", `inline; filename="streaming.m3u"`)
+	if err := iptv.Write(w, guide, entries); err != nil {
+		h.log.ErrorContext(r.Context(), "Error writing IPTV playlist", "err", err)
+	}
+}
+
+// IPTVVideoHandler is the stable URL of a video in IPTV playlists. It
+// redirects to the master playlist of the video once it is processed,
+// wherever its output is in the cache, and to the source file before.
+// The access token is passed on, which also sets the cookie later
+// playlists and segments are authenticated with.
+func (h *Handler) IPTVVideoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	target := "/direct/" + escapeName(h.videoName(video))
+	if video.Status == database.StatusReady {
+		master := h.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path)
+		target = "/stream/" + escapeName(master)
+	}
+	target = h.config.Server.Path(target)
+	if token := r.URL.Query().Get("access_token"); token != "" {
+		target += "?access_token=" + url.QueryEscape(token)
+	}
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// iptvGroup returns the group of a video in IPTV playlists: its series,
+// or its library for videos at the top of one
+func (h *Handler) iptvGroup(v *database.Video) string {
+	series, lib := h.seriesOf(v), h.libraryName(v)
+	switch {
+	case series != "" && lib != "":
+		return lib + " / " + series
+	case series != "":
+		return series
+	case lib != "":
+		return lib
+	}
+	return "Videos"
+}
@@ -0,0 +1,882 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// jellyfinVersion is the Jellyfin server version reported to clients, which
+// refuse servers older than the API they were built against
+const jellyfinVersion = "10.10.0"
+
+// ticksPerSecond converts seconds to the 100 ns ticks Jellyfin measures
+// durations and positions in
+const ticksPerSecond = 10_000_000
+
+// jellyfinLibraryKind marks library IDs. Jellyfin item IDs are GUIDs written
+// as 32 hex digits: videos have their database ID in the low half and zero
+// in the high half, libraries their index in the configuration and this.
+const jellyfinLibraryKind = 1
+
+// jellyfinLatestLimit is the number of videos listed as latest when the
+// client doesn't ask for a number
+const jellyfinLatestLimit = 16
+
+// JellyfinPublicInfo describes the server to Jellyfin clients before they
+// sign in
+type JellyfinPublicInfo struct {
+	LocalAddress           string
+	ServerName             string
+	Version                string
+	ProductName            string
+	OperatingSystem        string
+	Id                     string
+	StartupWizardCompleted bool
+}
+
+// JellyfinUser is the Jellyfin representation of the signed in user
+type JellyfinUser struct {
+	Name                  string
+	ServerId              string
+	Id                    string
+	HasPassword           bool
+	HasConfiguredPassword bool
+	EnableAutoLogin       bool
+	Policy                JellyfinUserPolicy
+	Configuration         JellyfinUserConfiguration
+}
+
+// JellyfinUserPolicy tells clients what the user may do
+type JellyfinUserPolicy struct {
+	IsAdministrator                bool
+	IsDisabled                     bool
+	EnableMediaPlayback            bool
+	EnableAllFolders               bool
+	EnableContentDownloading       bool
+	EnableVideoPlaybackTranscoding bool
+}
+
+// JellyfinUserConfiguration holds the playback preferences of a user
+type JellyfinUserConfiguration struct {
+	PlayDefaultAudioTrack bool
+	SubtitleMode          string
+}
+
+// JellyfinSession describes the session a client signed in to
+type JellyfinSession struct {
+	Id       string
+	UserId   string
+	UserName string
+	ServerId string
+}
+
+// JellyfinAuthResult is returned to clients that signed in
+type JellyfinAuthResult struct {
+	User        JellyfinUser
+	SessionInfo JellyfinSession
+	AccessToken string
+	ServerId    string
+}
+
+// JellyfinUserData is the user's state of an item
+type JellyfinUserData struct {
+	Key                   string
+	PlaybackPositionTicks int64
+	PlayedPercentage      float64 `json:",omitempty"`
+	IsFavorite            bool
+	Played                bool
+}
+
+// JellyfinItem is a video or a library in the Jellyfin API
+type JellyfinItem struct {
+	Name              string
+	ServerId          string
+	Id                string
+	Type              string
+	MediaType         string `json:",omitempty"`
+	CollectionType    string `json:",omitempty"`
+	IsFolder          bool
+	ParentId          string `json:",omitempty"`
+	DateCreated       time.Time
+	Container         string `json:",omitempty"`
+	RunTimeTicks      int64  `json:",omitempty"`
+	Width             int    `json:",omitempty"`
+	Height            int    `json:",omitempty"`
+	ChildCount        int    `json:",omitempty"`
+	LocationType      string
+	ImageTags         map[string]string
+	BackdropImageTags []string
+	UserData          *JellyfinUserData     `json:",omitempty"`
+	MediaSources      []JellyfinMediaSource `json:",omitempty"`
+}
+
+// JellyfinItems is a page of items
+type JellyfinItems struct {
+	Items            []JellyfinItem
+	TotalRecordCount int
+	StartIndex       int
+}
+
+// JellyfinMediaSource tells a client how a video can be played: directly
+// from the source file, or as the HLS stream of the processed video
+type JellyfinMediaSource struct {
+	Id                     string
+	Name                   string
+	Path                   string
+	Protocol               string
+	Type                   string
+	Container              string
+	Size                   int64
+	RunTimeTicks           int64
+	IsRemote               bool
+	SupportsDirectPlay     bool
+	SupportsDirectStream   bool
+	SupportsTranscoding    bool
+	DirectStreamUrl        string `json:",omitempty"`
+	TranscodingUrl         string `json:",omitempty"`
+	TranscodingSubProtocol string `json:",omitempty"`
+	TranscodingContainer   string `json:",omitempty"`
+	MediaStreams           []JellyfinMediaStream
+}
+
+// JellyfinMediaStream is a video or audio stream of a media source
+type JellyfinMediaStream struct {
+	Type          string
+	Codec         string
+	Index         int
+	IsDefault     bool
+	IsExternal    bool
+	Width         int     `json:",omitempty"`
+	Height        int     `json:",omitempty"`
+	RealFrameRate float64 `json:",omitempty"`
+	BitDepth      int     `json:",omitempty"`
+	VideoRange    string  `json:",omitempty"`
+	Channels      int     `json:",omitempty"`
+}
+
+// JellyfinPlaybackInfo is the answer to a playback info request
+type JellyfinPlaybackInfo struct {
+	MediaSources  []JellyfinMediaSource
+	PlaySessionId string
+}
+
+// jellyfinVideoID returns the item ID of a video
+func jellyfinVideoID(id int64) string {
+	return fmt.Sprintf("%032x", id)
+}
+
+// jellyfinLibraryID returns the item ID of the library at an index of
+// config.MediaLibraries
+func jellyfinLibraryID(index int) string {
+	return fmt.Sprintf("%016x%016x", jellyfinLibraryKind, index)
+}
+
+// parseJellyfinID splits an item ID into its kind and number. Clients may
+// send IDs formatted as GUIDs, with dashes.
+func parseJellyfinID(id string) (kind, n uint64, ok bool) {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) != 32 {
+		return 0, 0, false
+	}
+	kind, err := strconv.ParseUint(id[:16], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	n, err = strconv.ParseUint(id[16:], 16, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return kind, n, true
+}
+
+// jellyfinUserID returns a stable user ID derived from the user name
+func jellyfinUserID(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return hex.EncodeToString(sum[:16])
+}
+
+// jellyfinParams returns the query parameters of a request keyed in lower
+// case: Jellyfin treats their names case-insensitively and clients differ
+// in spelling them, e.g. ParentId and parentId
+func jellyfinParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	for key, values := range r.URL.Query() {
+		if len(values) > 0 {
+			params[strings.ToLower(key)] = values[0]
+		}
+	}
+	return params
+}
+
+// jellyfinServer returns the ID and name of the server. The ID is derived
+// from the host name and port like the DLNA device UUID, so clients
+// recognize the server across restarts.
+func (h *Handler) jellyfinServer() (id, name string) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("jellyfin:%s:%d", hostname, h.config.Server.Port)))
+	name = h.config.Jellyfin.ServerName
+	if name == "" {
+		name = "Streaming on " + hostname
+	}
+	return hex.EncodeToString(sum[:16]), name
+}
+
+// JellyfinPublicInfoHandler describes the server to clients adding it
+func (h *Handler) JellyfinPublicInfoHandler(w http.ResponseWriter, r *http.Request) {
+	id, name := h.jellyfinServer()
+	writeJSON(w, http.StatusOK, JellyfinPublicInfo{
+		LocalAddress:           h.baseURL(r) + h.config.Server.Path(""),
+		ServerName:             name,
+		Version:                jellyfinVersion,
+		ProductName:            "Jellyfin Server",
+		OperatingSystem:        runtime.GOOS,
+		Id:                     id,
+		StartupWizardCompleted: true,
+	})
+}
+
+// JellyfinPingHandler answers the reachability checks of clients
+func (h *Handler) JellyfinPingHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, "Jellyfin Server")
+}
+
+// JellyfinBrandingHandler returns the login page branding, which this
+// server doesn't customize
+func (h *Handler) JellyfinBrandingHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"LoginDisclaimer":     "",
+		"CustomCss":           "",
+		"SplashscreenEnabled": false,
+	})
+}
+
+// JellyfinPublicUsersHandler lists the users shown on the login screen:
+// none, users type their name
+func (h *Handler) JellyfinPublicUsersHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, []JellyfinUser{})
+}
+
+// JellyfinQuickConnectHandler tells clients Quick Connect isn't available
+func (h *Handler) JellyfinQuickConnectHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, false)
+}
+
+// JellyfinLoginHandler signs a client in with the username and password of
+// a built-in user from a JSON body such as {"Username": "ann", "Pw": "..."}
+func (h *Handler) JellyfinLoginHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Username string
+		Pw       string
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	p, token, err := h.auth.JellyfinLogin(body.Username, body.Pw)
+	if errors.Is(err, auth.ErrInvalidLogin) || errors.Is(err, auth.ErrNotAllowed) {
+		writeJSONError(w, http.StatusUnauthorized, err.Error())
+		return
+	}
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error signing in Jellyfin client", "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "signing in failed")
+		return
+	}
+	if p != nil {
+		r = r.WithContext(auth.WithPrincipal(r.Context(), p))
+	}
+
+	user := h.jellyfinUser(r)
+	writeJSON(w, http.StatusOK, JellyfinAuthResult{
+		User: user,
+		SessionInfo: JellyfinSession{
+			Id:       strconv.FormatInt(time.Now().UnixNano(), 36),
+			UserId:   user.Id,
+			UserName: user.Name,
+			ServerId: user.ServerId,
+		},
+		AccessToken: token,
+		ServerId:    user.ServerId,
+	})
+}
+
+// jellyfinUser returns the user of a request
+func (h *Handler) jellyfinUser(r *http.Request) JellyfinUser {
+	serverID, _ := h.jellyfinServer()
+	name := h.currentUser(r)
+	return JellyfinUser{
+		Name:                  name,
+		ServerId:              serverID,
+		Id:                    jellyfinUserID(name),
+		HasPassword:           h.auth.PasswordLogin(),
+		HasConfiguredPassword: h.auth.PasswordLogin(),
+		Policy: JellyfinUserPolicy{
+			IsAdministrator:                isAdmin(r),
+			EnableMediaPlayback:            true,
+			EnableAllFolders:               len(h.config.Libraries) == 0,
+			EnableVideoPlaybackTranscoding: true,
+		},
+		Configuration: JellyfinUserConfiguration{
+			PlayDefaultAudioTrack: true,
+			SubtitleMode:          "Default",
+		},
+	}
+}
+
+// JellyfinUserHandler returns the signed in user. Clients ask for it by ID
+// or as /Users/Me; any ID names the signed in user.
+func (h *Handler) JellyfinUserHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, h.jellyfinUser(r))
+}
+
+// JellyfinSystemInfoHandler describes the server to signed in clients
+func (h *Handler) JellyfinSystemInfoHandler(w http.ResponseWriter, r *http.Request) {
+	h.JellyfinPublicInfoHandler(w, r)
+}
+
+// JellyfinCapabilitiesHandler accepts the capabilities clients report
+// after signing in, which this server doesn't use
+func (h *Handler) JellyfinCapabilitiesHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// jellyfinLibrary is a library visible to the user with its index in
+// config.MediaLibraries, which its item ID is derived from
+type jellyfinLibrary struct {
+	index int
+	config.MediaLibrary
+}
+
+// jellyfinLibraries returns the libraries the user of a request may see.
+// Without configured libraries the media directory is the only one.
+func (h *Handler) jellyfinLibraries(r *http.Request) []jellyfinLibrary {
+	admin, user := isAdmin(r), h.currentUser(r)
+
+	var libs []jellyfinLibrary
+	for i, lib := range h.config.MediaLibraries() {
+		if len(h.config.Libraries) == 0 || admin || lib.Allows(user) {
+			libs = append(libs, jellyfinLibrary{index: i, MediaLibrary: lib})
+		}
+	}
+	return libs
+}
+
+// jellyfinLibraryItem returns the item of a library
+func (h *Handler) jellyfinLibraryItem(r *http.Request, lib jellyfinLibrary) (JellyfinItem, error) {
+	page, err := h.db.WithContext(r.Context()).ListVideos(database.ListOptions{Limit: 1, Dirs: []string{lib.MediaDir}})
+	if err != nil {
+		return JellyfinItem{}, err
+	}
+
+	serverID, _ := h.jellyfinServer()
+	name := lib.Name
+	if name == "" {
+		name = "Videos"
+	}
+	return JellyfinItem{
+		Name:              name,
+		ServerId:          serverID,
+		Id:                jellyfinLibraryID(lib.index),
+		Type:              "CollectionFolder",
+		CollectionType:    "movies",
+		IsFolder:          true,
+		ChildCount:        page.Total,
+		LocationType:      "FileSystem",
+		ImageTags:         map[string]string{},
+		BackdropImageTags: []string{},
+	}, nil
+}
+
+// JellyfinViewsHandler lists the libraries of the user as views
+func (h *Handler) JellyfinViewsHandler(w http.ResponseWriter, r *http.Request) {
+	resp := JellyfinItems{Items: []JellyfinItem{}}
+	for _, lib := range h.jellyfinLibraries(r) {
+		item, err := h.jellyfinLibraryItem(r, lib)
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	resp.TotalRecordCount = len(resp.Items)
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// jellyfinParentDirs returns the media directories of the library a
+// listing is restricted to with ParentId, or those of all libraries the
+// user may see. ok is false for unknown or hidden libraries.
+func (h *Handler) jellyfinParentDirs(r *http.Request, parentID string) ([]string, bool) {
+	libs := h.jellyfinLibraries(r)
+	if parentID == "" {
+		if len(h.config.Libraries) == 0 {
+			return nil, true
+		}
+		dirs := []string{}
+		for _, lib := range libs {
+			dirs = append(dirs, lib.MediaDir)
+		}
+		return dirs, true
+	}
+
+	kind, n, ok := parseJellyfinID(parentID)
+	if !ok || kind != jellyfinLibraryKind {
+		return nil, false
+	}
+	for _, lib := range libs {
+		if uint64(lib.index) == n {
+			return []string{lib.MediaDir}, true
+		}
+	}
+	return nil, false
+}
+
+// jellyfinSorts maps Jellyfin sort orders to those of ListVideos
+var jellyfinSorts = map[string]string{
+	"sortname":    database.SortName,
+	"name":        database.SortName,
+	"datecreated": database.SortAdded,
+	"runtime":     database.SortDuration,
+}
+
+// JellyfinItemsHandler lists the videos of a library, or of all libraries
+// the user may see, with the ParentId, SearchTerm, Filters=IsFavorite,
+// Ids, SortBy, SortOrder, StartIndex and Limit parameters
+func (h *Handler) JellyfinItemsHandler(w http.ResponseWriter, r *http.Request) {
+	params := jellyfinParams(r)
+
+	// Clients ask for series, music and the like on their home screens
+	if types := strings.ToLower(params["includeitemtypes"]); types != "" &&
+		!strings.Contains(types, "movie") && !strings.Contains(types, "video") {
+		writeJSON(w, http.StatusOK, JellyfinItems{Items: []JellyfinItem{}})
+		return
+	}
+	filters := strings.ToLower(params["filters"])
+	if strings.Contains(filters, "isresumable") {
+		h.JellyfinResumeHandler(w, r)
+		return
+	}
+	if ids := params["ids"]; ids != "" {
+		h.jellyfinItemsByID(w, r, strings.Split(ids, ","))
+		return
+	}
+
+	dirs, ok := h.jellyfinParentDirs(r, params["parentid"])
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "library not found")
+		return
+	}
+	opts := database.ListOptions{
+		Dirs:          dirs,
+		User:          h.currentUser(r),
+		Query:         strings.TrimSpace(params["searchterm"]),
+		FavoritesOnly: strings.Contains(filters, "isfavorite"),
+		Desc:          strings.EqualFold(params["sortorder"], "Descending"),
+	}
+	sortBy, _, _ := strings.Cut(params["sortby"], ",")
+	if sort, ok := jellyfinSorts[strings.ToLower(sortBy)]; ok {
+		opts.Sort = sort
+	}
+	opts.Offset, _ = strconv.Atoi(params["startindex"])
+	opts.Limit, _ = strconv.Atoi(params["limit"])
+	if opts.Offset < 0 || opts.Limit < 0 {
+		writeJSONError(w, http.StatusBadRequest, "StartIndex and Limit must not be negative")
+		return
+	}
+
+	items, total, err := h.jellyfinVideoItems(r, opts)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, JellyfinItems{Items: items, TotalRecordCount: total, StartIndex: opts.Offset})
+}
+
+// JellyfinLatestHandler lists the newest videos of a library, or of all
+// libraries the user may see, as a plain array
+func (h *Handler) JellyfinLatestHandler(w http.ResponseWriter, r *http.Request) {
+	params := jellyfinParams(r)
+	dirs, ok := h.jellyfinParentDirs(r, params["parentid"])
+	if !ok {
+		writeJSONError(w, http.StatusNotFound, "library not found")
+		return
+	}
+	limit, err := strconv.Atoi(params["limit"])
+	if err != nil || limit <= 0 {
+		limit = jellyfinLatestLimit
+	}
+
+	items, _, err := h.jellyfinVideoItems(r, database.ListOptions{
+		Dirs:  dirs,
+		User:  h.currentUser(r),
+		Sort:  database.SortAdded,
+		Desc:  true,
+		Limit: limit,
+	})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, items)
+}
+
+// JellyfinResumeHandler lists the videos the user stopped watching part
+// way through, most recently watched first
+func (h *Handler) JellyfinResumeHandler(w http.ResponseWriter, r *http.Request) {
+	limit, err := strconv.Atoi(jellyfinParams(r)["limit"])
+	if err != nil || limit <= 0 {
+		limit = jellyfinLatestLimit
+	}
+
+	positions, err := h.db.WithContext(r.Context()).RecentPositions(h.currentUser(r), limit)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	ids := make([]string, len(positions))
+	for i, p := range positions {
+		ids[i] = jellyfinVideoID(p.VideoID)
+	}
+	h.jellyfinItemsByID(w, r, ids)
+}
+
+// jellyfinItemsByID lists the videos with the given item IDs, in order.
+// IDs of unknown videos and videos the user can't see are skipped.
+func (h *Handler) jellyfinItemsByID(w http.ResponseWriter, r *http.Request, ids []string) {
+	db := h.db.WithContext(r.Context())
+	var videos []*database.Video
+	for _, id := range ids {
+		kind, n, ok := parseJellyfinID(strings.TrimSpace(id))
+		if !ok || kind != 0 {
+			continue
+		}
+		video, err := db.GetVideo(int64(n))
+		if err != nil || video.DeletedAt.Valid || !h.canAccess(r, video.Path) {
+			continue
+		}
+		videos = append(videos, video)
+	}
+
+	items, err := h.jellyfinItems(r, videos)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, JellyfinItems{Items: items, TotalRecordCount: len(items)})
+}
+
+// jellyfinVideoItems lists the videos matching opts as items, together
+// with the total number of matches
+func (h *Handler) jellyfinVideoItems(r *http.Request, opts database.ListOptions) ([]JellyfinItem, int, error) {
+	page, err := h.db.WithContext(r.Context()).ListVideos(opts)
+	if err != nil {
+		return nil, 0, err
+	}
+	items, err := h.jellyfinItems(r, page.Videos)
+	return items, page.Total, err
+}
+
+// jellyfinItems converts videos into items with the user data of the user
+// of a request
+func (h *Handler) jellyfinItems(r *http.Request, videos []*database.Video) ([]JellyfinItem, error) {
+	db, user := h.db.WithContext(r.Context()), h.currentUser(r)
+	ids := make([]int64, len(videos))
+	for i, v := range videos {
+		ids[i] = v.ID
+	}
+	ratings, err := db.RatingsForVideos(user, ids)
+	if err != nil {
+		return nil, err
+	}
+	positions, err := db.PositionsForVideos(user, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	items := make([]JellyfinItem, len(videos))
+	for i, v := range videos {
+		items[i] = h.jellyfinItem(v, ratings[v.ID], positions[v.ID])
+	}
+	return items, nil
+}
+
+// jellyfinItem converts a video into an item. rating and position may be
+// nil when the user hasn't rated or started the video.
+func (h *Handler) jellyfinItem(v *database.Video, rating *database.Rating, position *database.Position) JellyfinItem {
+	serverID, _ := h.jellyfinServer()
+	item := JellyfinItem{
+		Name:              strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename)),
+		ServerId:          serverID,
+		Id:                jellyfinVideoID(v.ID),
+		Type:              "Movie",
+		MediaType:         "Video",
+		DateCreated:       v.CreatedAt,
+		Container:         jellyfinContainer(v),
+		RunTimeTicks:      int64(v.Duration * ticksPerSecond),
+		Width:             v.Width,
+		Height:            v.Height,
+		LocationType:      "FileSystem",
+		ImageTags:         map[string]string{"Primary": strconv.FormatInt(v.UpdatedAt.Unix(), 36)},
+		BackdropImageTags: []string{},
+		UserData:          &JellyfinUserData{Key: jellyfinVideoID(v.ID)},
+	}
+	for i, lib := range h.config.MediaLibraries() {
+		if current, ok := h.config.LibraryFor(v.Path); ok && current.MediaDir == lib.MediaDir {
+			item.ParentId = jellyfinLibraryID(i)
+			break
+		}
+	}
+	if rating != nil {
+		item.UserData.IsFavorite = rating.Favorite
+	}
+	if position != nil && position.Position > 0 {
+		item.UserData.PlaybackPositionTicks = int64(position.Position * ticksPerSecond)
+		if v.Duration > 0 {
+			item.UserData.PlayedPercentage = position.Position / v.Duration * 100
+		}
+	}
+	return item
+}
+
+// jellyfinContainer returns the container of the source file of a video
+// as Jellyfin names it, after its extension such as "mkv"
+func jellyfinContainer(v *database.Video) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(v.Filename), "."))
+}
+
+// jellyfinVideo loads the video with an item ID and writes an error
+// response if the ID is invalid or the video doesn't exist for the user
+func (h *Handler) jellyfinVideo(w http.ResponseWriter, r *http.Request, id string) (*database.Video, bool) {
+	kind, n, ok := parseJellyfinID(id)
+	if !ok || kind != 0 {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return nil, false
+	}
+
+	video, err := h.db.WithContext(r.Context()).GetVideo(int64(n))
+	if err != nil || video.DeletedAt.Valid || !h.canAccess(r, video.Path) {
+		writeJSONError(w, http.StatusNotFound, "item not found")
+		return nil, false
+	}
+	return video, true
+}
+
+// JellyfinItemHandler returns a video or a library by its item ID. Videos
+// come with their media sources.
+func (h *Handler) JellyfinItemHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("itemId")
+	if kind, n, ok := parseJellyfinID(id); ok && kind == jellyfinLibraryKind {
+		for _, lib := range h.jellyfinLibraries(r) {
+			if uint64(lib.index) != n {
+				continue
+			}
+			item, err := h.jellyfinLibraryItem(r, lib)
+			if err != nil {
+				writeJSONError(w, http.StatusInternalServerError, err.Error())
+				return
+			}
+			writeJSON(w, http.StatusOK, item)
+			return
+		}
+	}
+
+	video, ok := h.jellyfinVideo(w, r, id)
+	if !ok {
+		return
+	}
+	items, err := h.jellyfinItems(r, []*database.Video{video})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	item := items[0]
+	item.MediaSources = []JellyfinMediaSource{h.jellyfinMediaSource(r, video)}
+
+	writeJSON(w, http.StatusOK, item)
+}
+
+// JellyfinImageHandler serves the artwork of a video as its primary image,
+// whatever image type is asked for
+func (h *Handler) JellyfinImageHandler(w http.ResponseWriter, r *http.Request) {
+	kind, n, ok := parseJellyfinID(r.PathValue("itemId"))
+	if !ok || kind != 0 {
+		writeJSONError(w, http.StatusNotFound, "image not found")
+		return
+	}
+
+	r.SetPathValue("id", strconv.FormatUint(n, 10))
+	h.ArtworkHandler(w, r)
+}
+
+// JellyfinPlaybackInfoHandler tells a client how to play a video
+func (h *Handler) JellyfinPlaybackInfoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.jellyfinVideo(w, r, r.PathValue("itemId"))
+	if !ok {
+		return
+	}
+
+	writeJSON(w, http.StatusOK, JellyfinPlaybackInfo{
+		MediaSources:  []JellyfinMediaSource{h.jellyfinMediaSource(r, video)},
+		PlaySessionId: strconv.FormatInt(time.Now().UnixNano(), 36),
+	})
+}
+
+// jellyfinMediaSource describes how a video can be played. Any client can
+// play the source file directly, while processed videos can also be
+// streamed as HLS, which is what Jellyfin calls transcoding. Both URLs are
+// relative to the server URL the client knows.
+func (h *Handler) jellyfinMediaSource(r *http.Request, v *database.Video) JellyfinMediaSource {
+	id := jellyfinVideoID(v.ID)
+	source := JellyfinMediaSource{
+		Id:                   id,
+		Name:                 strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename)),
+		Path:                 h.videoName(v),
+		Protocol:             "File",
+		Type:                 "Default",
+		Container:            jellyfinContainer(v),
+		Size:                 v.Size,
+		RunTimeTicks:         int64(v.Duration * ticksPerSecond),
+		SupportsDirectPlay:   true,
+		SupportsDirectStream: true,
+		DirectStreamUrl:      "/Videos/" + id + "/stream?static=true&mediaSourceId=" + id,
+		MediaStreams:         []JellyfinMediaStream{},
+	}
+
+	if v.Status == database.StatusReady && v.MasterPlaylist != "" {
+		if path := h.castPath(r, v.MasterPlaylist); path != "" {
+			source.SupportsTranscoding = true
+			source.TranscodingUrl = path
+			source.TranscodingSubProtocol = "hls"
+			source.TranscodingContainer = "ts"
+		}
+	}
+
+	if v.VideoCodec != "" {
+		stream := JellyfinMediaStream{
+			Type:          "Video",
+			Codec:         v.VideoCodec,
+			IsDefault:     true,
+			Width:         v.Width,
+			Height:        v.Height,
+			RealFrameRate: v.FrameRate,
+			BitDepth:      v.BitDepth,
+			VideoRange:    "SDR",
+		}
+		if v.HDR {
+			stream.VideoRange = "HDR"
+		}
+		source.MediaStreams = append(source.MediaStreams, stream)
+	}
+	if v.AudioCodec != "" {
+		source.MediaStreams = append(source.MediaStreams, JellyfinMediaStream{
+			Type:      "Audio",
+			Codec:     v.AudioCodec,
+			Index:     len(source.MediaStreams),
+			IsDefault: true,
+			Channels:  v.AudioChannels,
+		})
+	}
+	return source
+}
+
+// JellyfinVideoHandler serves the streams of a video at
+// /Videos/{itemId}/{file}: the source file as "stream" or "stream.<ext>",
+// and the HLS stream of processed videos as "master.m3u8", which redirects
+// to the cast URL of the video
+func (h *Handler) JellyfinVideoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.jellyfinVideo(w, r, r.PathValue("itemId"))
+	if !ok {
+		return
+	}
+
+	file := r.PathValue("file")
+	switch {
+	case file == "stream" || strings.HasPrefix(file, "stream."):
+		if err := h.touchDirect(r, video, false); err != nil {
+			h.streamRefused(w, err)
+			return
+		}
+		h.serveSource(w, r, video)
+
+	case file == "master.m3u8" || file == "main.m3u8":
+		if video.Status != database.StatusReady || video.MasterPlaylist == "" {
+			writeJSONError(w, http.StatusPreconditionFailed, "video is not ready for streaming")
+			return
+		}
+		path := h.castPath(r, video.MasterPlaylist)
+		if path == "" {
+			writeJSONError(w, http.StatusInternalServerError, "streaming failed")
+			return
+		}
+		http.Redirect(w, r, h.config.Server.Path(path), http.StatusFound)
+
+	default:
+		writeJSONError(w, http.StatusNotFound, "stream not found")
+	}
+}
+
+// JellyfinPlayingHandler records the playback position clients report when
+// playback starts, progresses and stops, from a JSON body such as
+// {"ItemId": "...", "PositionTicks": 7542000000}
+func (h *Handler) JellyfinPlayingHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ItemId        string
+		PositionTicks *int64
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	video, ok := h.jellyfinVideo(w, r, body.ItemId)
+	if !ok {
+		return
+	}
+
+	if body.PositionTicks != nil && *body.PositionTicks >= 0 {
+		position := float64(*body.PositionTicks) / ticksPerSecond
+		if err := h.savePosition(r, video, position); err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// JellyfinFavoriteHandler marks a video as a favorite of the user with
+// POST and unmarks it with DELETE, returning the user data of the video
+func (h *Handler) JellyfinFavoriteHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.jellyfinVideo(w, r, r.PathValue("itemId"))
+	if !ok {
+		return
+	}
+
+	if err := h.db.SetFavorite(h.currentUser(r), video.ID, r.Method == http.MethodPost); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	items, err := h.jellyfinItems(r, []*database.Video{video})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, items[0].UserData)
+}
@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kaero/streaming/internal/control"
+	"github.com/kaero/streaming/internal/library"
+)
+
+// JobsHandler reports the running and queued work of the librarian
+func (h *Handler) JobsHandler(w http.ResponseWriter, r *http.Request) {
+	status, err := h.jobs.Status(r.Context())
+	if err != nil {
+		writeJobsError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, status)
+}
+
+// JobsActionHandler has the librarian scan, process, pause or resume, as
+// named by the action in the path. Scans and processing are queued and
+// answered with 202 and whether they were queued; pausing and resuming
+// answer with the new state.
+func (h *Handler) JobsActionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, action := r.Context(), r.PathValue("action")
+
+	var err error
+	switch action {
+	case "scan", "process":
+		var queued bool
+		if action == "scan" {
+			queued, err = h.jobs.Scan(ctx)
+		} else {
+			queued, err = h.jobs.Process(ctx)
+		}
+		if err != nil {
+			writeJobsError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusAccepted, control.StatusJSON{Action: action, Queued: queued})
+		return
+	case "pause":
+		err = h.jobs.Pause(ctx, h.currentUser(r))
+	case "resume":
+		err = h.jobs.Resume(ctx, h.currentUser(r))
+	default:
+		writeJSONError(w, http.StatusNotFound, "unknown action")
+		return
+	}
+	if err != nil {
+		writeJobsError(w, err)
+		return
+	}
+	h.JobsHandler(w, r)
+}
+
+// CancelJobHandler stops the processing job of a video. The video is marked
+// as failed without a scheduled retry. Videos not being processed are
+// answered with 404.
+func (h *Handler) CancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.jobs.Cancel(r.Context(), id, h.currentUser(r)); err != nil {
+		writeJobsError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeJobsError replies with an error of the librarian: 404 for videos not
+// being processed, 503 when the control API is disabled and 502 when the
+// librarian failed or couldn't be reached
+func writeJobsError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, library.ErrNotProcessing):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, control.ErrDisabled):
+		writeJSONError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeJSONError(w, http.StatusBadGateway, err.Error())
+	}
+}
@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// APIKeyResponse is the JSON representation of a stored API key. The key
+// itself is only included in the response that created it.
+type APIKeyResponse struct {
+	ID         int64      `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Key        string     `json:"key,omitempty"`
+}
+
+// newAPIKeyResponse converts a stored API key into its JSON representation
+func newAPIKeyResponse(k *database.APIKey) APIKeyResponse {
+	resp := APIKeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		CreatedAt: k.CreatedAt,
+	}
+	if resp.Scopes == nil {
+		resp.Scopes = []string{}
+	}
+	if k.LastUsedAt.Valid {
+		resp.LastUsedAt = &k.LastUsedAt.Time
+	}
+	return resp
+}
+
+// ListAPIKeysHandler returns the API keys stored in the database. Keys from
+// the configuration file aren't listed.
+func (h *Handler) ListAPIKeysHandler(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.db.ListAPIKeys()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := make([]APIKeyResponse, 0, len(keys))
+	for _, k := range keys {
+		resp = append(resp, newAPIKeyResponse(k))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateAPIKeyHandler creates an API key from a JSON body such as
+// {"name": "kodi", "scopes": ["read"]}. The generated key is returned once
+// and can't be retrieved later.
+func (h *Handler) CreateAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Name   string   `json:"name"`
+		Scopes []string `json:"scopes"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if body.Name == "" {
+		writeJSONError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if len(body.Scopes) == 0 {
+		writeJSONError(w, http.StatusBadRequest, "at least one scope is required")
+		return
+	}
+	for _, s := range body.Scopes {
+		if !auth.ValidScope(s) {
+			writeJSONError(w, http.StatusBadRequest, "unknown scope "+strconv.Quote(s))
+			return
+		}
+	}
+
+	key, err := auth.GenerateKey()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	stored, err := h.db.CreateAPIKey(body.Name, auth.HashKey(key), body.Scopes)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	resp := newAPIKeyResponse(stored)
+	resp.Key = key
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// DeleteAPIKeyHandler revokes a stored API key
+func (h *Handler) DeleteAPIKeyHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid key ID")
+		return
+	}
+
+	if err := h.db.DeleteAPIKey(id); err != nil {
+		if errors.Is(err, database.ErrAPIKeyNotFound) {
+			writeJSONError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/safepath"
+)
+
+// LibraryJSON is a library the current user can see
+type LibraryJSON struct {
+	Name       string `json:"name"`
+	VideoCount int    `json:"video_count"`
+}
+
+// APILibrariesHandler lists the libraries the current user can see with
+// their number of videos. Without configured libraries the list is empty.
+func (h *Handler) APILibrariesHandler(w http.ResponseWriter, r *http.Request) {
+	resp := []LibraryJSON{}
+	for _, lib := range h.visibleLibraries(r) {
+		if lib.Name == "" {
+			continue
+		}
+		page, err := h.db.ListVideos(database.ListOptions{Dirs: []string{lib.MediaDir}, Limit: 1})
+		if err != nil {
+			writeJSONError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		resp = append(resp, LibraryJSON{Name: lib.Name, VideoCount: page.Total})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// isAdmin reports whether the request was made with the admin scope
+func isAdmin(r *http.Request) bool {
+	p := auth.FromContext(r.Context())
+	return p != nil && p.Has(auth.ScopeAdmin)
+}
+
+// visibleLibraries returns the libraries the user of a request may see
+func (h *Handler) visibleLibraries(r *http.Request) []config.MediaLibrary {
+	admin, user := isAdmin(r), h.currentUser(r)
+
+	var libs []config.MediaLibrary
+	for _, lib := range h.config.MediaLibraries() {
+		if admin || lib.Allows(user) {
+			libs = append(libs, lib)
+		}
+	}
+	return libs
+}
+
+// canAccess reports whether the user of a request may see a video file.
+// Files outside the configured libraries are only visible to admins.
+func (h *Handler) canAccess(r *http.Request, path string) bool {
+	if len(h.config.Libraries) == 0 || isAdmin(r) {
+		return true
+	}
+	lib, ok := h.config.LibraryFor(path)
+	return ok && lib.Allows(h.currentUser(r))
+}
+
+// hasRestrictedLibraries reports whether some library is hidden from
+// some users, so streams need to be checked
+func (h *Handler) hasRestrictedLibraries() bool {
+	for _, lib := range h.config.Libraries {
+		if lib.Restricted() {
+			return true
+		}
+	}
+	return false
+}
+
+// canStream reports whether the user of a request may fetch a file from
+// the HLS cache. The video is looked up by its cache directory; directories
+// no video owns anymore are only served to admins.
+func (h *Handler) canStream(r *http.Request, filePath string) (bool, error) {
+	if !h.hasRestrictedLibraries() || isAdmin(r) {
+		return true, nil
+	}
+	dir, _, _ := strings.Cut(filePath, "/")
+	video, err := h.db.WithContext(r.Context()).GetVideoByCacheDir(dir)
+	if err != nil || video == nil {
+		return false, err
+	}
+	return h.canAccess(r, video.Path), nil
+}
+
+// libraryDirs returns the media directories a listing is restricted to:
+// those of the libraries visible to the user, or only the named one.
+// Without configured libraries the listing isn't restricted.
+func (h *Handler) libraryDirs(r *http.Request, name string) []string {
+	if len(h.config.Libraries) == 0 {
+		return nil
+	}
+
+	dirs := []string{}
+	for _, lib := range h.visibleLibraries(r) {
+		if name == "" || lib.Name == name {
+			dirs = append(dirs, lib.MediaDir)
+		}
+	}
+	return dirs
+}
+
+// mediaPath returns the path of a video file named in a request, writing
+// an error response if the name is invalid or names a library the user
+// can't see. With configured libraries names start with the library name,
+// e.g. "Kids/cartoon.mp4", otherwise they are relative to the media
+// directory.
+func (h *Handler) mediaPath(w http.ResponseWriter, r *http.Request, videoFile string) (string, bool) {
+	root, name := h.config.Media.MediaDir, videoFile
+	if len(h.config.Libraries) > 0 {
+		libName, rest, _ := strings.Cut(videoFile, "/")
+		root = ""
+		for _, lib := range h.visibleLibraries(r) {
+			if lib.Name == libName {
+				root, name = lib.MediaDir, rest
+			}
+		}
+		// Unknown libraries and those the user can't see look the same
+		if root == "" || name == "" {
+			http.Error(w, "Video not found in the library", http.StatusNotFound)
+			return "", false
+		}
+	}
+
+	videoPath, err := safepath.Join(root, name)
+	if err != nil {
+		http.Error(w, "Invalid video path", http.StatusBadRequest)
+		return "", false
+	}
+	return videoPath, true
+}
+
+// videoName returns the name of a video in URLs, the inverse of mediaPath
+func (h *Handler) videoName(v *database.Video) string {
+	lib, ok := h.config.LibraryFor(v.Path)
+	if !ok {
+		return v.Filename
+	}
+	rel, err := filepath.Rel(lib.MediaDir, v.Path)
+	if err != nil {
+		return v.Filename
+	}
+	if lib.Name == "" {
+		return filepath.ToSlash(rel)
+	}
+	return lib.Name + "/" + filepath.ToSlash(rel)
+}
+
+// libraryName returns the name of the library of a video, "" without
+// configured libraries
+func (h *Handler) libraryName(v *database.Video) string {
+	lib, _ := h.config.LibraryFor(v.Path)
+	return lib.Name
+}
+
+// escapeName escapes each element of a slash separated video name for use
+// in a URL path
+func escapeName(name string) string {
+	elems := strings.Split(name, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
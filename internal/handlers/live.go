@@ -0,0 +1,378 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/live"
+	"github.com/kaero/streaming/internal/middleware"
+	"github.com/kaero/streaming/internal/playback"
+	"github.com/kaero/streaming/internal/safepath"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// maxScheduleHours caps how far ahead the schedule of a playlist channel
+// can be listed
+const maxScheduleHours = 7 * 24
+
+// maxOfferBytes caps the SDP offers of WebRTC viewers
+const maxOfferBytes = 64 << 10
+
+// LiveData holds data for the live player template
+type LiveData struct {
+	Channel string
+	Live    bool
+	// Since is when the stream started, formatted for display
+	Since string
+	// Playing is the title of the video a playlist channel is playing
+	Playing string
+	// WebRTC lets the player try WebRTC before HLS
+	WebRTC bool
+	// LimitMessage replaces the player when the user can't start another stream
+	LimitMessage string
+}
+
+// APILiveHandler lists the live channels and whether they are on air
+func (h *Handler) APILiveHandler(w http.ResponseWriter, r *http.Request) {
+	resp := []live.Status{}
+	if h.live != nil {
+		resp = append(resp, h.live.Channels()...)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// LivePageHandler serves the player of a live channel, which waits for
+// the stream while the channel is off air
+func (h *Handler) LivePageHandler(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.liveChannel(r.PathValue("channel"))
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+
+	data := LiveData{Channel: status.Name, Live: status.Live, Playing: status.Playing, WebRTC: status.WebRTC}
+	if status.Since != nil {
+		data.Since = status.Since.Local().Format("15:04")
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := h.streams.Check(streamUser(r), liveStreamKey(r, status.Name)); err != nil {
+		data.LimitMessage = h.streamRefusedMessage(err)
+	}
+	if err := h.templates.LiveTemplate(w, data); err != nil {
+		http.Error(w, "Error rendering template", http.StatusInternalServerError)
+	}
+}
+
+// LiveStreamHandler serves the playlists and segments of a live channel.
+// Viewers count against server.max_streams_per_user like videos.
+func (h *Handler) LiveStreamHandler(w http.ResponseWriter, r *http.Request) {
+	name, file := r.PathValue("channel"), r.PathValue("file")
+	status, ok := h.liveChannel(name)
+	if !ok {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if status.Type == "playlist" {
+		h.livePlaylist(w, r, name, file)
+		return
+	}
+	dir, _ := h.live.Dir(name)
+	fullPath, err := safepath.Resolve(dir, file)
+	if errors.Is(err, safepath.ErrInvalidPath) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(fullPath)
+	}
+	if err != nil || info.IsDir() {
+		// Segments behind the live edge are deleted, and nothing exists
+		// while the channel is off air
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	if !h.touchLive(w, r, name, playlistVariant(file)) {
+		return
+	}
+
+	visibility := "public"
+	if h.config.Auth.StreamsProtected() {
+		visibility = "private"
+	}
+	if filepath.Ext(file) == ".m3u8" {
+		// Players poll live playlists for new segments
+		w.Header().Set("Content-Type", "application/x-mpegURL")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "video/MP2T")
+		w.Header().Set("Cache-Control", fmt.Sprintf("%s, max-age=%d", visibility, h.config.Live.SegmentDuration*h.config.Live.PlaylistEntries))
+	}
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	http.ServeFile(w, r, fullPath)
+}
+
+// LiveWHEPHandler starts playing a live channel over WebRTC with WHEP: the
+// player posts its SDP offer and receives the answer, with all ICE
+// candidates of the server, and the URL of the session in Location.
+// Viewers count against server.max_streams_per_user like HLS ones.
+func (h *Handler) LiveWHEPHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("channel")
+	status, ok := h.liveChannel(name)
+	if !ok || !status.WebRTC {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/sdp" {
+		http.Error(w, "Offer must be application/sdp", http.StatusUnsupportedMediaType)
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, maxOfferBytes))
+	if err != nil {
+		http.Error(w, "Error reading offer", http.StatusBadRequest)
+		return
+	}
+	if !h.touchLive(w, r, name, "webrtc") {
+		return
+	}
+
+	user, key, ip := streamUser(r), liveStreamKey(r, name), middleware.ClientIP(r)
+	keep := func() bool {
+		_, err := h.streams.Touch(user, key, ip)
+		return err == nil
+	}
+	id, answer, err := h.live.Offer(r.Context(), name, string(offer), keep)
+	switch {
+	case errors.Is(err, live.ErrNoStream):
+		http.Error(w, "Channel is off air", http.StatusServiceUnavailable)
+		return
+	case errors.Is(err, live.ErrInvalidOffer):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		h.log.ErrorContext(r.Context(), "Error answering WebRTC offer", "channel", name, "err", err)
+		http.Error(w, "Error starting WebRTC session", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/sdp")
+	w.Header().Set("Location", h.config.Server.Path("/live/"+url.PathEscape(name)+"/whep/"+id))
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// LiveWHEPEndHandler ends a WebRTC session when the player stops
+func (h *Handler) LiveWHEPEndHandler(w http.ResponseWriter, r *http.Request) {
+	if h.live == nil || !h.live.EndSession(r.PathValue("channel"), r.PathValue("id")) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// livePlaylist serves the playlist of a playlist channel, which is
+// rendered from the time of the request
+func (h *Handler) livePlaylist(w http.ResponseWriter, r *http.Request, name, file string) {
+	if file != transcoder.LivePlaylist {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	playlist, err := h.live.Playlist(name, time.Now())
+	if errors.Is(err, live.ErrOffAir) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error rendering live playlist", "channel", name, "err", err)
+		http.Error(w, "Error rendering playlist", http.StatusInternalServerError)
+		return
+	}
+	if !h.touchLive(w, r, name, "") {
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-mpegURL")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Write(playlist)
+}
+
+// LiveVideoHandler serves the segments of the videos a playlist channel
+// plays, which are files of the cache like those served below /stream/
+func (h *Handler) LiveVideoHandler(w http.ResponseWriter, r *http.Request) {
+	name, filePath := r.PathValue("channel"), r.PathValue("path")
+	if status, ok := h.liveChannel(name); !ok || status.Type != "playlist" {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	fullPath, err := safepath.Resolve(h.config.Media.CacheDir, filePath)
+	if errors.Is(err, safepath.ErrInvalidPath) {
+		http.Error(w, "Invalid file path", http.StatusBadRequest)
+		return
+	}
+	var info os.FileInfo
+	if err == nil {
+		info, err = os.Stat(fullPath)
+	}
+	// Segments missing from the disk may be in object storage
+	remote := os.IsNotExist(err) && h.library.Store().Holds(filePath)
+	if (err != nil && !remote) || (info != nil && info.IsDir()) {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+
+	// Refuse segments of videos in libraries the user can't see
+	allowed, err := h.canStream(r, filePath)
+	if err != nil {
+		h.log.ErrorContext(r.Context(), "Error looking up the video of a file", "path", filePath, "err", err)
+		http.Error(w, "Error reading file", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	}
+	if !h.touchLive(w, r, name, "") {
+		return
+	}
+	h.tracker.Touch(filePath)
+
+	switch filepath.Ext(filePath) {
+	case ".ts":
+		w.Header().Set("Content-Type", "video/MP2T")
+	case ".m4s":
+		w.Header().Set("Content-Type", "video/iso.segment")
+	case ".mp4":
+		w.Header().Set("Content-Type", "video/mp4")
+	default:
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	w.Header().Set("Cache-Control", h.streamCacheControl(filePath))
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if remote {
+		h.library.Store().Serve(w, r, filePath)
+		return
+	}
+	http.ServeFile(w, r, fullPath)
+}
+
+// APILiveScheduleHandler lists what a playlist channel plays over the next
+// hours, 24 unless the hours parameter says otherwise
+func (h *Handler) APILiveScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("channel")
+	if status, ok := h.liveChannel(name); !ok || status.Type != "playlist" {
+		writeJSONError(w, http.StatusNotFound, "Playlist channel not found")
+		return
+	}
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxScheduleHours {
+			writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("hours must be between 1 and %d", maxScheduleHours))
+			return
+		}
+		hours = n
+	}
+
+	programmes, err := h.live.Schedule(name, time.Now(), time.Duration(hours)*time.Hour)
+	if errors.Is(err, live.ErrOffAir) {
+		programmes = []live.Programme{}
+	} else if err != nil {
+		h.log.ErrorContext(r.Context(), "Error building live schedule", "channel", name, "err", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to build schedule")
+		return
+	}
+	writeJSON(w, http.StatusOK, programmes)
+}
+
+// EPGData holds data for the programme guide template
+type EPGData struct {
+	Channels []EPGChannel
+}
+
+// EPGChannel is a live channel in the programme guide
+type EPGChannel struct {
+	Name       string
+	Link       string
+	Programmes []live.Programme
+}
+
+// EPGHandler serves the programme guide of the live channels as XMLTV for
+// IPTV players, with the next day of every playlist channel
+func (h *Handler) EPGHandler(w http.ResponseWriter, r *http.Request) {
+	data := EPGData{}
+	if h.live != nil {
+		base := h.baseURL(r)
+		now := time.Now()
+		for _, c := range h.live.Channels() {
+			channel := EPGChannel{Name: c.Name, Link: base + h.config.Server.Path("/live/"+url.PathEscape(c.Name))}
+			if c.Type == "playlist" {
+				programmes, err := h.live.Schedule(c.Name, now, 24*time.Hour)
+				if err != nil && !errors.Is(err, live.ErrOffAir) {
+					h.log.ErrorContext(r.Context(), "Error building live schedule", "channel", c.Name, "err", err)
+				}
+				channel.Programmes = programmes
+			}
+			data.Channels = append(data.Channels, channel)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	if err := h.templates.EPGTemplate(w, data); err != nil {
+		h.log.ErrorContext(r.Context(), "Error rendering programme guide", "err", err)
+	}
+}
+
+// touchLive counts a viewer of a live channel, writing the error and
+// reporting false when the user can't start another stream
+func (h *Handler) touchLive(w http.ResponseWriter, r *http.Request, name, variant string) bool {
+	key := liveStreamKey(r, name)
+	if _, err := h.streams.Touch(streamUser(r), key, middleware.ClientIP(r)); err != nil {
+		h.streamRefused(w, err)
+		return false
+	}
+	h.streams.Update(key, func(s *playback.Session) {
+		s.Video = "Live: " + name
+		if variant != "" {
+			s.Variant = variant
+		}
+	})
+	return true
+}
+
+// liveChannel returns the status of a live channel, reporting false when
+// there is no channel of that name
+func (h *Handler) liveChannel(name string) (live.Status, bool) {
+	if h.live == nil {
+		return live.Status{}, false
+	}
+	return h.live.Channel(name)
+}
+
+// liveStreamKey identifies a viewer of a live channel like streamKey does
+// the viewers of a video
+func liveStreamKey(r *http.Request, channel string) string {
+	return streamKey(r, "live:"+channel+"/"+transcoder.LivePlaylist)
+}
+
+// liveChannelNames returns the names of the live channels on air
+func (h *Handler) liveChannelNames() []string {
+	var names []string
+	if h.live == nil {
+		return names
+	}
+	for _, c := range h.live.Channels() {
+		if c.Live {
+			names = append(names, c.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/middleware"
+)
+
+// LoginData holds data for the login template
+type LoginData struct {
+	Next     string
+	Username string
+	Error    string
+	// Password and OIDC tell which ways of signing in are enabled
+	Password bool
+	OIDC     bool
+}
+
+// LoginPageHandler shows the login form
+func (h *Handler) LoginPageHandler(w http.ResponseWriter, r *http.Request) {
+	h.renderLogin(w, http.StatusOK, LoginData{Next: auth.LocalPath(r.URL.Query().Get("next"))})
+}
+
+// LoginHandler checks the submitted credentials of a built-in user and
+// starts a session
+func (h *Handler) LoginHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.auth.PasswordLogin() {
+		http.NotFound(w, r)
+		return
+	}
+	username := r.PostFormValue("username")
+	next := auth.LocalPath(r.PostFormValue("next"))
+
+	p, err := h.auth.Login(username, r.PostFormValue("password"))
+	if errors.Is(err, auth.ErrInvalidLogin) {
+		h.log.WarnContext(r.Context(), "Failed login", "user", username, "client_ip", middleware.ClientIP(r))
+		h.renderLogin(w, http.StatusUnauthorized, LoginData{Next: next, Username: username, Error: "Invalid username or password"})
+		return
+	}
+	if errors.Is(err, auth.ErrNotAllowed) {
+		h.log.WarnContext(r.Context(), "Login of a user not allowed", "user", username, "client_ip", middleware.ClientIP(r))
+		h.renderLogin(w, http.StatusForbidden, LoginData{Next: next, Username: username, Error: "You are not allowed to sign in"})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if err := h.auth.StartSession(w, r, p); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, h.config.Server.Path(next), http.StatusSeeOther)
+}
+
+// renderLogin renders the login form with the given status code
+func (h *Handler) renderLogin(w http.ResponseWriter, status int, data LoginData) {
+	data.Password = h.auth.PasswordLogin()
+	data.OIDC = h.auth.OIDC() != nil
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := h.templates.LoginTemplate(w, data); err != nil {
+		h.log.Error("Error rendering login template", "err", err)
+	}
+}
@@ -0,0 +1,15 @@
+package handlers
+
+import "net/http"
+
+// LogoHandler serves the image set as ui.logo, which the pages show in
+// their headers. Browsers revalidate it, so a replaced file shows up
+// without a restart.
+func (h *Handler) LogoHandler(w http.ResponseWriter, r *http.Request) {
+	if h.config.UI.Logo == "" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, h.config.UI.Logo)
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// watchedFraction is how far into a video a position counts as watched to
+// the end, so the video starts from the beginning next time
+const watchedFraction = 0.95
+
+// PositionResponse is the JSON representation of where the current user
+// stopped watching a video
+type PositionResponse struct {
+	VideoID   int64      `json:"video_id"`
+	Position  float64    `json:"position"`
+	UpdatedAt *time.Time `json:"updated_at,omitempty"`
+}
+
+// writePosition writes the current user's playback position in a video as
+// JSON
+func (h *Handler) writePosition(w http.ResponseWriter, r *http.Request, videoID int64) {
+	pos, err := h.db.GetPosition(h.currentUser(r), videoID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := PositionResponse{VideoID: pos.VideoID, Position: pos.Position}
+	if !pos.UpdatedAt.IsZero() {
+		resp.UpdatedAt = &pos.UpdatedAt
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetPositionHandler returns where the current user stopped watching a
+// video, 0 if they haven't started it
+func (h *Handler) GetPositionHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	h.writePosition(w, r, id)
+}
+
+// SetPositionHandler stores the current user's playback position in seconds
+// from a JSON body such as {"position": 754.2}. Positions near the end
+// clear it, as the video was watched.
+func (h *Handler) SetPositionHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Position *float64 `json:"position"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if body.Position == nil || *body.Position < 0 {
+		writeJSONError(w, http.StatusBadRequest, "position must be a number of seconds")
+		return
+	}
+
+	if err := h.savePosition(r, video, *body.Position); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	h.writePosition(w, r, video.ID)
+}
+
+// savePosition stores the current user's playback position in seconds, or
+// clears it near the end of the video, adding the video to their watch
+// history
+func (h *Handler) savePosition(r *http.Request, video *database.Video, position float64) error {
+	user := h.currentUser(r)
+	if video.Duration > 0 && position >= video.Duration*watchedFraction {
+		if _, err := h.db.AddWatch(user, video); err != nil {
+			return err
+		}
+		return h.db.ClearPosition(user, video.ID)
+	}
+	return h.db.SetPosition(user, video.ID, position)
+}
+
+// ClearPositionHandler forgets where the current user stopped watching a
+// video
+func (h *Handler) ClearPositionHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.ClearPosition(h.currentUser(r), id); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// ProgressJSON is the processing progress of a video
+type ProgressJSON struct {
+	ID     int64  `json:"id"`
+	Status string `json:"status"`
+	// Percent is the share of the video transcoded, averaged over variants
+	Percent float64 `json:"percent"`
+	// ETASeconds is the estimated time left, omitted until FFmpeg reports
+	// its speed
+	ETASeconds    *float64              `json:"eta_seconds,omitempty"`
+	ActiveVariant string                `json:"active_variant,omitempty"`
+	Variants      []VariantProgressJSON `json:"variants"`
+	StartedAt     *time.Time            `json:"started_at,omitempty"`
+	UpdatedAt     *time.Time            `json:"updated_at,omitempty"`
+}
+
+// VariantProgressJSON is the transcoding progress of one variant
+type VariantProgressJSON struct {
+	Name     string  `json:"name"`
+	Percent  float64 `json:"percent"`
+	Position float64 `json:"position"`
+	Speed    float64 `json:"speed"`
+}
+
+// APIProgressHandler returns how far processing of a video got. Ready
+// videos are at 100 percent, videos that haven't started at 0.
+func (h *Handler) APIProgressHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	resp, err := h.videoProgress(video)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// videoProgress computes how far processing of a video got
+func (h *Handler) videoProgress(video *database.Video) (ProgressJSON, error) {
+	resp := ProgressJSON{
+		ID:       video.ID,
+		Status:   string(video.Status),
+		Variants: []VariantProgressJSON{},
+	}
+
+	switch video.Status {
+	case database.StatusReady:
+		resp.Percent = 100
+
+	case database.StatusProcessing:
+		progress, err := h.db.ListProgress(video.ID)
+		if err != nil {
+			return resp, err
+		}
+		fillProgress(&resp, progress, video.Duration)
+	}
+
+	return resp, nil
+}
+
+// fillProgress computes the overall progress of a video from the progress
+// of its variants. The variant with the most work left is reported as the
+// active one and determines the ETA.
+func fillProgress(resp *ProgressJSON, progress []*database.Progress, duration float64) {
+	if len(progress) == 0 || duration <= 0 {
+		return
+	}
+
+	var total float64
+	var slowest *database.Progress
+	for _, p := range progress {
+		position := math.Min(p.Position, duration)
+		percent := math.Round(position/duration*1000) / 10
+		total += percent
+		resp.Variants = append(resp.Variants, VariantProgressJSON{
+			Name:     p.Variant,
+			Percent:  percent,
+			Position: position,
+			Speed:    p.Speed,
+		})
+
+		if slowest == nil || p.Position < slowest.Position {
+			slowest = p
+		}
+		if resp.StartedAt == nil || p.StartedAt.Before(*resp.StartedAt) {
+			resp.StartedAt = &p.StartedAt
+		}
+		if resp.UpdatedAt == nil || p.UpdatedAt.After(*resp.UpdatedAt) {
+			resp.UpdatedAt = &p.UpdatedAt
+		}
+	}
+
+	resp.Percent = math.Round(total/float64(len(progress))*10) / 10
+	resp.ActiveVariant = slowest.Variant
+	if slowest.Speed > 0 {
+		eta := math.Round(math.Max(duration-slowest.Position, 0) / slowest.Speed)
+		resp.ETASeconds = &eta
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+)
+
+// SessionJSON is the JSON representation of a playback session
+type SessionJSON struct {
+	ID              string    `json:"id"`
+	User            string    `json:"user"`
+	ClientIP        string    `json:"client_ip"`
+	VideoID         int64     `json:"video_id,omitempty"`
+	Video           string    `json:"video,omitempty"`
+	Variant         string    `json:"variant,omitempty"`
+	StartedAt       time.Time `json:"started_at"`
+	LastSeenAt      time.Time `json:"last_seen_at"`
+	PositionSeconds float64   `json:"position_seconds"`
+}
+
+// ListSessionsHandler returns who is watching what
+func (h *Handler) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	sessions := h.streams.Sessions()
+
+	resp := make([]SessionJSON, 0, len(sessions))
+	for _, s := range sessions {
+		resp = append(resp, SessionJSON{
+			ID:              s.ID,
+			User:            s.User,
+			ClientIP:        s.ClientIP,
+			VideoID:         s.VideoID,
+			Video:           s.Video,
+			Variant:         s.Variant,
+			StartedAt:       s.StartedAt,
+			LastSeenAt:      s.LastSeen,
+			PositionSeconds: s.Position.Seconds(),
+		})
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// TerminateSessionHandler stops a playback session. Its further playlist
+// and segment requests are refused until the player gives up.
+func (h *Handler) TerminateSessionHandler(w http.ResponseWriter, r *http.Request) {
+	if !h.streams.Terminate(r.PathValue("id")) {
+		writeJSONError(w, http.StatusNotFound, "session not found")
+		return
+	}
+
+	h.log.InfoContext(r.Context(), "User terminated playback session", "user", h.currentUser(r), "session", r.PathValue("id"))
+	w.WriteHeader(http.StatusNoContent)
+}
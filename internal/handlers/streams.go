@@ -0,0 +1,230 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/cache"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/middleware"
+	"github.com/kaero/streaming/internal/playback"
+	"github.com/kaero/streaming/internal/telemetry"
+)
+
+// playlistMaxAge is how long playlists may be cached
+const playlistMaxAge = 5 * time.Second
+
+// minStreamIdle is the shortest time a stream counts as active after its
+// last request
+const minStreamIdle = 30 * time.Second
+
+// newStreamTracker creates the tracker enforcing server.max_streams_per_user.
+// Players fetch a segment every segment duration, so a stream ends after
+// three segments without a request.
+func newStreamTracker(cfg *config.Config) *playback.Tracker {
+	idle := max(3*time.Duration(cfg.Server.SegmentDuration)*time.Second, minStreamIdle)
+	return playback.NewTracker(cfg.Server.MaxStreamsPerUser, idle)
+}
+
+// newHotCache creates the memory cache of stream files limited by
+// cache.hot_cache_mb, or nil when it is disabled
+func newHotCache(cfg *config.Config) *cache.HotCache {
+	if cfg.Cache.HotCacheMB <= 0 {
+		return nil
+	}
+	return cache.NewHotCache(cfg.Cache.HotCacheBytes())
+}
+
+// isHot reports whether a stream file is kept in memory: playlists, which
+// players poll, and the first cache.hot_segments segments of each variant,
+// which every player starting the video fetches
+func (h *Handler) isHot(file string) bool {
+	if strings.HasSuffix(file, ".m3u8") {
+		return true
+	}
+	name, ok := strings.CutSuffix(file, ".ts")
+	if !ok {
+		return false
+	}
+	// Segments are numbered with three digits after the variant height,
+	// e.g. "movie.mkv_720001.ts"
+	_, suffix, ok := cutLast(name, "_")
+	if !ok || len(suffix) < 4 || !isDigits(suffix) {
+		return false
+	}
+	index, err := strconv.Atoi(suffix[len(suffix)-3:])
+	return err == nil && index < h.config.Cache.HotSegments
+}
+
+// hotContent returns the content of a hot stream file from memory, reading
+// it into memory when it isn't cached or changed. It reports false for
+// files that aren't hot or can't be read, which are served from disk.
+func (h *Handler) hotContent(file, fullPath string, info os.FileInfo) ([]byte, bool) {
+	if h.hot == nil || !h.isHot(file) {
+		return nil, false
+	}
+	if content, ok := h.hot.Get(file, info); ok {
+		return content, true
+	}
+	content, err := os.ReadFile(fullPath)
+	if err != nil || int64(len(content)) != info.Size() {
+		return nil, false
+	}
+	h.hot.Put(file, info, content)
+	return content, true
+}
+
+// streamUser returns who a stream is counted against: the authenticated
+// user or key, or the client address for anonymous requests
+func streamUser(r *http.Request) string {
+	if p := auth.FromContext(r.Context()); p != nil {
+		return p.Name
+	}
+	return "anonymous@" + middleware.ClientIP(r)
+}
+
+// streamKey identifies a playback session by the credential and address it
+// is made from and the cache directory of the video being watched
+func streamKey(r *http.Request, relativePath string) string {
+	dir, _, _ := strings.Cut(relativePath, "/")
+	sum := sha256.Sum256([]byte(auth.Token(r) + "|" + middleware.ClientIP(r) + "|" + dir))
+	return hex.EncodeToString(sum[:8])
+}
+
+// streamRefusedMessage explains why a stream was refused
+func (h *Handler) streamRefusedMessage(err error) string {
+	if errors.Is(err, playback.ErrTerminated) {
+		return "This stream was stopped by an administrator."
+	}
+	return fmt.Sprintf("You are already watching %d videos at the same time, which is the limit. Stop one of them and try again.", h.config.Server.MaxStreamsPerUser)
+}
+
+// streamRefused writes the error response for a refused stream
+func (h *Handler) streamRefused(w http.ResponseWriter, err error) {
+	status := http.StatusTooManyRequests
+	if errors.Is(err, playback.ErrTerminated) {
+		status = http.StatusForbidden
+	}
+	writeJSONError(w, status, h.streamRefusedMessage(err))
+}
+
+// touchStream records a request for an HLS file in its playback session.
+// The video is looked up when the session starts, while the variant and
+// position follow the playlists and segments requested.
+func (h *Handler) touchStream(r *http.Request, filePath string) error {
+	key := streamKey(r, filePath)
+	started, err := h.streams.Touch(streamUser(r), key, middleware.ClientIP(r))
+	if err != nil {
+		return err
+	}
+	// The requests of a playback share the session in their traces
+	telemetry.SpanFromContext(r.Context()).SetAttributes("playback.session", key, "playback.started", started)
+
+	dir, file, _ := strings.Cut(filePath, "/")
+	var video *database.Video
+	if started {
+		if video, err = h.db.WithContext(r.Context()).GetVideoByCacheDir(dir); err != nil {
+			h.log.ErrorContext(r.Context(), "Error looking up the video of a file", "path", filePath, "err", err)
+		}
+	}
+
+	h.streams.Update(key, func(s *playback.Session) {
+		if video != nil {
+			s.VideoID, s.Video = video.ID, video.Filename
+		}
+		if variant := playlistVariant(file); variant != "" {
+			s.Variant = variant
+		}
+		if i := segmentIndex(file, s.Variant); i >= 0 {
+			s.Position = time.Duration(i*h.config.Server.SegmentDuration) * time.Second
+		}
+	})
+	return nil
+}
+
+// playlistVariant returns the variant of a variant playlist, e.g. "720p"
+// for "movie.mkv_720.m3u8", or "" for other files
+func playlistVariant(file string) string {
+	name, ok := strings.CutSuffix(file, ".m3u8")
+	if !ok {
+		return ""
+	}
+	_, height, ok := cutLast(name, "_")
+	if !ok || !isDigits(height) {
+		return ""
+	}
+	return height + "p"
+}
+
+// segmentIndex returns the index of a segment of a variant, e.g. 12 for
+// "movie.mkv_720012.ts" of "720p", or -1 for other files
+func segmentIndex(file, variant string) int {
+	name, ok := strings.CutSuffix(file, ".ts")
+	if !ok || variant == "" {
+		return -1
+	}
+	_, suffix, ok := cutLast(name, "_")
+	index, ok2 := strings.CutPrefix(suffix, strings.TrimSuffix(variant, "p"))
+	if !ok || !ok2 || len(index) < 3 || !isDigits(index) {
+		return -1
+	}
+	i, err := strconv.Atoi(index)
+	if err != nil {
+		return -1
+	}
+	return i
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
+
+// isDigits reports whether s is a non-empty string of decimal digits
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// streamCacheControl returns the Cache-Control header for a stream file.
+// Reprocessing or repairing a video rewrites its playlists and segments
+// under the same names, so playlists are cached briefly and segments may
+// be stored but are revalidated with their ETag on every use, which costs
+// a 304 instead of a stale segment playing against a new playlist.
+// Protected streams are kept out of shared caches.
+func (h *Handler) streamCacheControl(file string) string {
+	visibility := "public"
+	if h.config.Auth.StreamsProtected() {
+		visibility = "private"
+	}
+
+	if filepath.Ext(file) == ".m3u8" {
+		return fmt.Sprintf("%s, max-age=%d", visibility, int(playlistMaxAge.Seconds()))
+	}
+	return visibility + ", no-cache"
+}
+
+// fileETag derives an ETag from the size and modification time of a file
+func fileETag(info os.FileInfo) string {
+	return fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+}
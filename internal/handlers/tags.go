@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTagLength is the longest tag accepted, in characters
+const maxTagLength = 64
+
+// ListTagsHandler returns the tags in use, sorted
+func (h *Handler) ListTagsHandler(w http.ResponseWriter, r *http.Request) {
+	tags, err := h.db.ListTags()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+
+	writeJSON(w, http.StatusOK, tags)
+}
+
+// SetTagsHandler replaces the tags of a video from a JSON body such as
+// {"tags": ["documentary", "4k"]} and returns them
+func (h *Handler) SetTagsHandler(w http.ResponseWriter, r *http.Request) {
+	id, ok := h.videoIDFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	for _, tag := range body.Tags {
+		if utf8.RuneCountInString(strings.TrimSpace(tag)) > maxTagLength {
+			writeJSONError(w, http.StatusBadRequest, "tags can't be longer than 64 characters")
+			return
+		}
+	}
+
+	if err := h.db.SetTags(id, body.Tags); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	tags, err := h.db.TagsForVideos([]int64{id})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	resp := tags[id]
+	if resp == nil {
+		resp = []string{}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
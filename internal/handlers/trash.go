@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// TrashedVideoJSON is a video in the trash
+type TrashedVideoJSON struct {
+	ID        int64     `json:"id"`
+	Filename  string    `json:"filename"`
+	Library   string    `json:"library,omitempty"`
+	Size      int64     `json:"size"`
+	DeletedAt time.Time `json:"deleted_at"`
+	// PurgeAt is when the video is removed for good, absent when the
+	// trash is kept
+	PurgeAt *time.Time `json:"purge_at,omitempty"`
+}
+
+// TrashJSON lists the videos in the trash
+type TrashJSON struct {
+	Videos []TrashedVideoJSON `json:"videos"`
+}
+
+// TrashHandler lists the videos in the trash the user can see, most
+// recently deleted first, with when they are purged
+func (h *Handler) TrashHandler(w http.ResponseWriter, r *http.Request) {
+	videos, err := h.db.WithContext(r.Context()).ListDeletedVideos()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	retention := time.Duration(h.config.Library.TrashRetentionDays) * 24 * time.Hour
+	resp := TrashJSON{Videos: []TrashedVideoJSON{}}
+	for _, v := range videos {
+		if !h.canAccess(r, v.Path) {
+			continue
+		}
+		tv := TrashedVideoJSON{
+			ID:        v.ID,
+			Filename:  v.Filename,
+			Library:   h.libraryName(v),
+			Size:      v.Size,
+			DeletedAt: v.DeletedAt.Time,
+		}
+		if retention > 0 {
+			purgeAt := v.DeletedAt.Time.Add(retention)
+			tv.PurgeAt = &purgeAt
+		}
+		resp.Videos = append(resp.Videos, tv)
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// RestoreVideoHandler moves a video out of the trash and has the
+// librarian process it again, as its output was removed with it. When the
+// librarian can't be reached, it picks the video up on its next run.
+func (h *Handler) RestoreVideoHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid video ID")
+		return
+	}
+
+	db := h.db.WithContext(r.Context())
+	video, err := db.GetDeletedVideo(id)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if video == nil || !h.canAccess(r, video.Path) {
+		if video, err := db.GetVideo(id); err == nil && h.canAccess(r, video.Path) {
+			writeJSONError(w, http.StatusConflict, "video is not in the trash")
+			return
+		}
+		writeJSONError(w, http.StatusNotFound, "video not found")
+		return
+	}
+
+	if err := h.library.RestoreVideo(video.ID, h.currentUser(r)); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if _, err := h.jobs.Process(r.Context()); err != nil {
+		h.log.WarnContext(r.Context(), "Error requesting processing from the librarian", "err", err)
+	}
+
+	video, err = h.db.GetVideo(video.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tags, err := h.db.TagsForVideos([]int64{video.ID})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.newVideoJSON(video, nil, tags[video.ID]))
+}
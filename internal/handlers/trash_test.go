@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/control"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// idleJobs is a librarian that accepts processing requests without doing
+// anything
+type idleJobs struct {
+	control.Jobs
+}
+
+func (idleJobs) Process(context.Context) (bool, error) { return true, nil }
+
+// newTrashTestHandler returns a handler on a new database and library in
+// a temporary directory, with the routes of the trash
+func newTrashTestHandler(t *testing.T) (*Handler, *database.DB, http.Handler) {
+	t.Helper()
+	dir := t.TempDir()
+	cfgFile := filepath.Join(dir, "config.toml")
+	settings := fmt.Sprintf("[media]\nmedia_dir = %q\ncache_dir = %q\n[database]\npath = %q\n",
+		filepath.Join(dir, "media"), filepath.Join(dir, "cache"), filepath.Join(dir, "library.db"))
+	if err := os.WriteFile(cfgFile, []byte(settings), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(cfgFile)
+	if err != nil {
+		t.Fatalf("config.Load: %v", err)
+	}
+
+	db, err := database.New(cfg.Database.Path)
+	if err != nil {
+		t.Fatalf("database.New: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	lm, err := library.New(cfg, db, transcoder.NewManager(cfg, logger), logger)
+	if err != nil {
+		t.Fatalf("library.New: %v", err)
+	}
+
+	h := NewHandler(cfg, nil, nil, db, lm, nil, nil, nil, nil, nil, nil, idleJobs{}, logger)
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/v1/trash", h.TrashHandler)
+	mux.HandleFunc("POST /api/v1/videos/{id}/restore", h.RestoreVideoHandler)
+	return h, db, mux
+}
+
+func TestTrashHandlers(t *testing.T) {
+	h, db, mux := newTrashTestHandler(t)
+
+	id, err := db.AddVideo("movie.mkv", filepath.Join(h.config.Media.MediaDir, "movie.mkv"), 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.AddVideo("other.mkv", filepath.Join(h.config.Media.MediaDir, "other.mkv"), 100); err != nil {
+		t.Fatal(err)
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+	restore := fmt.Sprintf("/api/v1/videos/%d/restore", id)
+
+	if rec := serve("POST", restore); rec.Code != http.StatusConflict {
+		t.Errorf("restoring a video not in the trash: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+
+	if err := h.library.RemoveVideo(id, "test"); err != nil {
+		t.Fatalf("RemoveVideo: %v", err)
+	}
+
+	rec := serve("GET", "/api/v1/trash")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("listing the trash: status %d: %s", rec.Code, rec.Body)
+	}
+	var trash TrashJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Videos) != 1 || trash.Videos[0].ID != id {
+		t.Fatalf("trash = %+v, want video %d", trash.Videos, id)
+	}
+	tv := trash.Videos[0]
+	if want := tv.DeletedAt.AddDate(0, 0, h.config.Library.TrashRetentionDays); tv.PurgeAt == nil || !tv.PurgeAt.Equal(want) {
+		t.Errorf("purge_at = %v, want %v", tv.PurgeAt, want)
+	}
+
+	rec = serve("POST", restore)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("restoring: status %d: %s", rec.Code, rec.Body)
+	}
+	var video VideoJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &video); err != nil {
+		t.Fatal(err)
+	}
+	if video.ID != id || video.Status != string(database.StatusPending) {
+		t.Errorf("restored video %d has status %s, want %d pending", video.ID, video.Status, id)
+	}
+
+	rec = serve("GET", "/api/v1/trash")
+	if err := json.Unmarshal(rec.Body.Bytes(), &trash); err != nil {
+		t.Fatal(err)
+	}
+	if len(trash.Videos) != 0 {
+		t.Errorf("trash holds %+v after restoring, want nothing", trash.Videos)
+	}
+
+	if rec := serve("POST", "/api/v1/videos/999/restore"); rec.Code != http.StatusNotFound {
+		t.Errorf("restoring an unknown video: status %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/library"
+	"github.com/kaero/streaming/internal/upload"
+)
+
+// UploadDirName is the directory inside the media directory receiving
+// uploads where they are staged. Staging on the same file system lets
+// finished uploads be moved into place with a rename.
+const UploadDirName = ".uploads"
+
+// UploadResponse is the JSON representation of an upload
+type UploadResponse struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+	VideoID   int64     `json:"video_id,omitempty"`
+}
+
+// newUploadResponse converts an upload into its JSON representation
+func newUploadResponse(u *upload.Upload) UploadResponse {
+	return UploadResponse{
+		ID:        u.ID,
+		Filename:  u.Filename,
+		Size:      u.Size,
+		Offset:    u.Offset,
+		CreatedAt: u.CreatedAt,
+	}
+}
+
+// writeUploadError maps upload store errors to API error responses
+func writeUploadError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, upload.ErrNotFound):
+		writeJSONError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, upload.ErrOffsetMismatch), errors.Is(err, upload.ErrBusy):
+		writeJSONError(w, http.StatusConflict, err.Error())
+	default:
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// CreateUploadHandler starts an upload from a JSON body such as
+// {"filename": "movie.mkv", "size": 1048576}. The data is then sent with
+// PATCH requests to the returned location.
+func (h *Handler) CreateUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Filename string `json:"filename"`
+		Size     int64  `json:"size"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+
+	filename := filepath.Base(body.Filename)
+	if filename != body.Filename || strings.HasPrefix(filename, ".") {
+		writeJSONError(w, http.StatusBadRequest, "invalid filename")
+		return
+	}
+	if !library.IsVideoFile(strings.ToLower(filepath.Ext(filename))) {
+		writeJSONError(w, http.StatusBadRequest, "unsupported file type")
+		return
+	}
+
+	maxSize := h.config.Media.MaxUploadSizeMB * 1024 * 1024
+	if body.Size <= 0 {
+		writeJSONError(w, http.StatusBadRequest, "size must be positive")
+		return
+	}
+	if maxSize > 0 && body.Size > maxSize {
+		writeJSONError(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("uploads are limited to %d MB", h.config.Media.MaxUploadSizeMB))
+		return
+	}
+
+	u, err := h.uploads.Create(filename, body.Size)
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	h.log.InfoContext(r.Context(), "Started upload", "upload", u.ID, "filename", u.Filename, "size", u.Size)
+	w.Header().Set("Location", h.config.Server.Path("/api/v1/uploads/"+u.ID))
+	writeJSON(w, http.StatusCreated, newUploadResponse(u))
+}
+
+// GetUploadHandler returns the state of an upload. Clients resume an
+// interrupted upload by sending the data from the returned offset.
+func (h *Handler) GetUploadHandler(w http.ResponseWriter, r *http.Request) {
+	u, err := h.uploads.Get(r.PathValue("id"))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUploadResponse(u))
+}
+
+// UploadChunkHandler appends the request body to an upload. The
+// Upload-Offset header must match the number of bytes received so far.
+// Once the last chunk arrives the file is moved into the media directory
+// and queued for processing.
+func (h *Handler) UploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		writeJSONError(w, http.StatusBadRequest, "missing or invalid Upload-Offset header")
+		return
+	}
+
+	u, err := h.uploads.Append(r.PathValue("id"), offset, r.Body)
+	if err != nil {
+		if u != nil {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+		}
+		writeUploadError(w, err)
+		return
+	}
+
+	w.Header().Set("Upload-Offset", strconv.FormatInt(u.Offset, 10))
+	if !u.Complete() {
+		writeJSON(w, http.StatusOK, newUploadResponse(u))
+		return
+	}
+
+	videoID, err := h.finishUpload(r.Context(), u, h.currentUser(r))
+	if err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	resp := newUploadResponse(u)
+	resp.VideoID = videoID
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// DeleteUploadHandler cancels an upload
+func (h *Handler) DeleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if err := h.uploads.Remove(r.PathValue("id")); err != nil {
+		writeUploadError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// finishUpload moves a complete upload into the media directory and adds it
+// to the library as pending. The video is added before the file appears so
+// the librarian's file watcher doesn't add it a second time.
+func (h *Handler) finishUpload(ctx context.Context, u *upload.Upload, actor string) (int64, error) {
+	dest, err := h.uploadDestination(u.Filename)
+	if err != nil {
+		return 0, err
+	}
+
+	videoID, err := h.db.AddVideo(filepath.Base(dest), dest, u.Size)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := h.uploads.Finish(u.ID, dest); err != nil {
+		if delErr := h.db.DeleteVideo(videoID); delErr != nil {
+			h.log.ErrorContext(ctx, "Error removing video after failed upload", "id", videoID, "err", delErr)
+		}
+		return 0, err
+	}
+
+	h.log.InfoContext(ctx, "Finished upload", "upload", u.ID, "path", dest, "id", videoID)
+	if err := h.db.LogEvent(database.EventUpload, videoID, actor, "uploaded to "+dest); err != nil {
+		h.log.ErrorContext(ctx, "Error recording upload event", "err", err)
+	}
+
+	return videoID, nil
+}
+
+// uploadDestination picks a path in the media directory for an uploaded
+// file, adding a numeric suffix if the name is already taken on disk or in
+// the library. With configured libraries uploads go to the first one with
+// a local media directory.
+func (h *Handler) uploadDestination(filename string) (string, error) {
+	lib, _ := h.config.UploadLibrary()
+	mediaDir := lib.MediaDir
+	ext := filepath.Ext(filename)
+	name := strings.TrimSuffix(filename, ext)
+
+	for i := 0; i < 1000; i++ {
+		candidate := filename
+		if i > 0 {
+			candidate = fmt.Sprintf("%s (%d)%s", name, i, ext)
+		}
+		dest := filepath.Join(mediaDir, candidate)
+
+		if _, err := os.Stat(dest); !os.IsNotExist(err) {
+			continue
+		}
+		exists, err := h.db.VideoExists(dest)
+		if err != nil {
+			return "", err
+		}
+		if !exists {
+			return dest, nil
+		}
+	}
+
+	return "", fmt.Errorf("no free file name for %s", filename)
+}
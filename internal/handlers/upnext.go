@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"unicode"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// continueWatchingItems is the number of videos in the continue watching
+// row of the web UI
+const continueWatchingItems = 10
+
+// continueWatching lists the videos the user of a request stopped watching
+// part way through, most recently watched first, skipping videos that
+// can't be played or that the user can't see
+func (h *Handler) continueWatching(r *http.Request) ([]VideoView, error) {
+	db := h.db.WithContext(r.Context())
+	positions, err := db.RecentPositions(h.currentUser(r), continueWatchingItems)
+	if err != nil {
+		return nil, err
+	}
+
+	var views []VideoView
+	for _, p := range positions {
+		video, err := db.GetVideo(p.VideoID)
+		if err != nil || video.DeletedAt.Valid || video.Status != database.StatusReady || !h.canAccess(r, video.Path) {
+			continue
+		}
+		view := newVideoView(video)
+		view.Path = escapeName(h.videoName(video))
+		view.Artwork = h.config.Server.Path(fmt.Sprintf("/api/v1/videos/%d/artwork", video.ID))
+		if video.Duration > 0 {
+			view.Progress = min(int(p.Position*100/video.Duration), 100)
+		}
+		views = append(views, view)
+	}
+	return views, nil
+}
+
+// nextInSeries returns the video following a video in its series: the
+// next ready video of the folder directly below the library, ordered by
+// path with numbers compared by value, so "Episode 10" follows
+// "Episode 9" and "Season 2" follows "Season 1". It returns nil for the
+// last video of a series and for videos that aren't in one.
+func (h *Handler) nextInSeries(r *http.Request, video *database.Video) (*database.Video, error) {
+	series := h.seriesOf(video)
+	if series == "" {
+		return nil, nil
+	}
+	lib, _ := h.config.LibraryFor(video.Path)
+
+	page, err := h.db.WithContext(r.Context()).ListVideos(database.ListOptions{
+		Sort:      database.SortName,
+		Statuses:  []database.VideoStatus{database.StatusReady},
+		Dirs:      []string{filepath.Join(lib.MediaDir, series)},
+		SkipTotal: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	episodes := page.Videos
+	sort.SliceStable(episodes, func(i, j int) bool {
+		return naturalLess(episodes[i].Path, episodes[j].Path)
+	})
+	for i, v := range episodes {
+		if v.ID == video.ID {
+			if i+1 < len(episodes) {
+				return episodes[i+1], nil
+			}
+			return nil, nil
+		}
+	}
+
+	// The video isn't ready itself, so follow it by its path
+	for _, v := range episodes {
+		if naturalLess(video.Path, v.Path) {
+			return v, nil
+		}
+	}
+	return nil, nil
+}
+
+// naturalLess orders strings the way people number things: runs of digits
+// are compared by value, the rest by character, ignoring case
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si, sj := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na, nb := trimZeros(ra[si:i]), trimZeros(rb[sj:j])
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if string(na) != string(nb) {
+				return string(na) < string(nb)
+			}
+			continue
+		}
+		ca, cb := unicode.ToLower(ra[i]), unicode.ToLower(rb[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	return len(ra)-i < len(rb)-j
+}
+
+// trimZeros drops the leading zeros of a number
+func trimZeros(digits []rune) []rune {
+	for len(digits) > 1 && digits[0] == '0' {
+		digits = digits[1:]
+	}
+	return digits
+}
+
+// APINextVideoHandler returns the video following a video in its series,
+// which the player offers to play next
+func (h *Handler) APINextVideoHandler(w http.ResponseWriter, r *http.Request) {
+	video, ok := h.videoFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	next, err := h.nextInSeries(r, video)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if next == nil {
+		writeJSONError(w, http.StatusNotFound, "no next video in the series")
+		return
+	}
+
+	rating, err := h.db.GetRating(h.currentUser(r), next.ID)
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	tags, err := h.db.TagsForVideos([]int64{next.ID})
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, h.newVideoJSON(next, rating, tags[next.ID]))
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/auth"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// UserResponse is the JSON representation of a built-in user
+type UserResponse struct {
+	ID          int64      `json:"id"`
+	Username    string     `json:"username"`
+	Role        string     `json:"role"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// newUserResponse converts a user into its JSON representation
+func newUserResponse(u *database.User) UserResponse {
+	resp := UserResponse{
+		ID:        u.ID,
+		Username:  u.Username,
+		Role:      u.Role,
+		CreatedAt: u.CreatedAt,
+	}
+	if u.LastLoginAt.Valid {
+		resp.LastLoginAt = &u.LastLoginAt.Time
+	}
+	return resp
+}
+
+// userRequest is the JSON body for creating and updating users
+type userRequest struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+}
+
+// validate checks the password and role of a request, when they are set
+func (req userRequest) validate() error {
+	if req.Password != "" && len(req.Password) < auth.MinPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", auth.MinPasswordLength)
+	}
+	if req.Role != "" && !auth.ValidRole(req.Role) {
+		return fmt.Errorf("unknown role %q", req.Role)
+	}
+	return nil
+}
+
+// ListUsersHandler returns the built-in users
+func (h *Handler) ListUsersHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := h.db.ListUsers()
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	resp := make([]UserResponse, 0, len(users))
+	for _, u := range users {
+		resp = append(resp, newUserResponse(u))
+	}
+
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// CreateUserHandler creates a user from a JSON body such as
+// {"username": "alice", "password": "...", "role": "viewer"}
+func (h *Handler) CreateUserHandler(w http.ResponseWriter, r *http.Request) {
+	var body userRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if body.Username == "" || body.Password == "" || body.Role == "" {
+		writeJSONError(w, http.StatusBadRequest, "username, password and role are required")
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hash, err := auth.HashPassword(body.Password)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	user, err := h.db.CreateUser(body.Username, hash, body.Role)
+	if err != nil {
+		writeJSONError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, newUserResponse(user))
+}
+
+// UpdateUserHandler changes the password and/or role of a user
+func (h *Handler) UpdateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body userRequest
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid JSON body")
+		return
+	}
+	if err := body.validate(); err != nil {
+		writeJSONError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if body.Password != "" {
+		hash, err := auth.HashPassword(body.Password)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		user.PasswordHash = hash
+	}
+	if body.Role != "" {
+		user.Role = body.Role
+	}
+
+	if err := h.db.UpdateUser(user.ID, user.PasswordHash, user.Role); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, newUserResponse(user))
+}
+
+// DeleteUserHandler removes a user, which also ends their sessions
+func (h *Handler) DeleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.userFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.db.DeleteUser(user.ID); err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// userFromPath looks up the user named by the {id} path parameter, writing
+// an error response if it can't
+func (h *Handler) userFromPath(w http.ResponseWriter, r *http.Request) (*database.User, bool) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid user ID")
+		return nil, false
+	}
+
+	user, err := h.db.GetUser(id)
+	if errors.Is(err, database.ErrUserNotFound) {
+		writeJSONError(w, http.StatusNotFound, err.Error())
+		return nil, false
+	}
+	if err != nil {
+		writeJSONError(w, http.StatusInternalServerError, err.Error())
+		return nil, false
+	}
+
+	return user, true
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/kaero/streaming/internal/transcoder"
+	"github.com/kaero/streaming/internal/version"
+)
+
+// ffmpegVersion runs FFmpeg only once, its version doesn't change while
+// the server runs
+var ffmpegVersion = sync.OnceValues(transcoder.FFmpegVersion)
+
+// APIVersionHandler reports the build of the server and the FFmpeg it uses
+func (h *Handler) APIVersionHandler(w http.ResponseWriter, r *http.Request) {
+	info := version.Get()
+	if v, err := ffmpegVersion(); err == nil {
+		info.FFmpeg = v
+	}
+
+	writeJSON(w, http.StatusOK, info)
+}
@@ -0,0 +1,144 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// Server-sent event timing
+const (
+	// sseProgressInterval is how often the progress of videos being
+	// processed is sent, matching how often FFmpeg reports it
+	sseProgressInterval = 2 * time.Second
+	// sseKeepAlive is how often a comment is sent on quiet streams, so
+	// proxies don't close them
+	sseKeepAlive = 30 * time.Second
+	// sseMaxProcessing caps the videos whose progress is sent per interval
+	sseMaxProcessing = 100
+)
+
+// VideoEventsHandler streams the processing state of the videos the user
+// can see as server-sent events, so pages update without polling: a
+// "status" event with a VideoStatusJSON whenever the status of a video
+// changes, and a "progress" event with a ProgressJSON for every video
+// being processed every 2 seconds. The video_id query parameter limits
+// the stream to one video.
+func (h *Handler) VideoEventsHandler(w http.ResponseWriter, r *http.Request) {
+	var only int64
+	if v := r.URL.Query().Get("video_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid video_id")
+			return
+		}
+		only = id
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v any) bool {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return false
+		}
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data); err != nil {
+			return false
+		}
+		return rc.Flush() == nil
+	}
+
+	events := h.events.Subscribe()
+	defer h.events.Unsubscribe(events)
+
+	progress := time.NewTicker(sseProgressInterval)
+	defer progress.Stop()
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+
+	// Start with the progress of the videos already being processed
+	if !h.sendProgress(r, only, send) || rc.Flush() != nil {
+		return
+	}
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Type != database.EventStatusChange || !e.VideoID.Valid {
+				continue
+			}
+			if only != 0 && e.VideoID.Int64 != only {
+				continue
+			}
+			video, err := h.db.WithContext(r.Context()).GetVideo(e.VideoID.Int64)
+			if err != nil || !h.canAccess(r, video.Path) {
+				continue
+			}
+			if !send("status", newVideoStatusJSON(video)) {
+				return
+			}
+		case <-progress.C:
+			if !h.sendProgress(r, only, send) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	}
+}
+
+// sendProgress sends the progress of the videos being processed that the
+// user can see, or only of the video with ID only when it isn't 0,
+// reporting whether the client is still connected
+func (h *Handler) sendProgress(r *http.Request, only int64, send func(string, any) bool) bool {
+	db := h.db.WithContext(r.Context())
+	var videos []*database.Video
+	if only != 0 {
+		video, err := db.GetVideo(only)
+		if err != nil || !h.canAccess(r, video.Path) || video.Status != database.StatusProcessing {
+			return true
+		}
+		videos = []*database.Video{video}
+	} else {
+		page, err := db.ListVideos(database.ListOptions{
+			Statuses:  []database.VideoStatus{database.StatusProcessing},
+			Dirs:      h.libraryDirs(r, ""),
+			Sort:      database.SortUpdated,
+			Limit:     sseMaxProcessing,
+			SkipTotal: true,
+		})
+		if err != nil {
+			h.log.ErrorContext(r.Context(), "Error listing videos being processed", "err", err)
+			return true
+		}
+		videos = page.Videos
+	}
+
+	for _, video := range videos {
+		resp, err := h.videoProgress(video)
+		if err != nil {
+			h.log.ErrorContext(r.Context(), "Error getting processing progress", "video", video.Filename, "err", err)
+			continue
+		}
+		if !send("progress", resp) {
+			return false
+		}
+	}
+	return true
+}
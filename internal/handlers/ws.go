@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket timing
+const (
+	wsWriteWait  = 10 * time.Second
+	wsPongWait   = 60 * time.Second
+	wsPingPeriod = wsPongWait * 9 / 10
+)
+
+// wsUpgrader upgrades control channel connections. The default origin check
+// only accepts same-origin browser connections.
+var wsUpgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// ControlRequest is an action sent by a control channel client, such as
+// {"id": "1", "action": "reprocess", "video_id": 42}
+type ControlRequest struct {
+	ID      string `json:"id"`
+	Action  string `json:"action"`
+	VideoID int64  `json:"video_id,omitempty"`
+}
+
+// ControlMessage is sent to control channel clients. Messages of type
+// "event" carry a new audit log event, messages of type "result" answer a
+// ControlRequest with the same ID.
+type ControlMessage struct {
+	Type   string         `json:"type"`
+	Event  *EventResponse `json:"event,omitempty"`
+	ID     string         `json:"id,omitempty"`
+	Action string         `json:"action,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// ControlHandler serves the WebSocket control channel. New audit log events,
+// such as status changes of videos being processed, are pushed to the
+// client, and the client can send scan, process, reprocess, cancel, pause
+// and resume actions.
+func (h *Handler) ControlHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied with an error
+		h.log.ErrorContext(r.Context(), "Error upgrading control channel", "err", err)
+		return
+	}
+	defer conn.Close()
+
+	actor := h.currentUser(r)
+	events := h.events.Subscribe()
+	defer h.events.Unsubscribe(events)
+
+	// Results are produced by action goroutines while the loop below owns
+	// writing to the connection
+	results := make(chan ControlMessage, 16)
+	done := make(chan struct{})
+	defer close(done)
+	readDone := make(chan struct{})
+
+	go func() {
+		defer close(readDone)
+
+		conn.SetReadLimit(4096)
+		conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+		})
+
+		for {
+			var req ControlRequest
+			if err := conn.ReadJSON(&req); err != nil {
+				return
+			}
+			go func() {
+				msg := h.runControlAction(r.Context(), req, actor)
+				select {
+				case results <- msg:
+				case <-done:
+				}
+			}()
+		}
+	}()
+
+	ping := time.NewTicker(wsPingPeriod)
+	defer ping.Stop()
+
+	for {
+		var msg ControlMessage
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			er := newEventResponse(e)
+			msg = ControlMessage{Type: "event", Event: &er}
+		case msg = <-results:
+		case <-readDone:
+			return
+		case <-ping.C:
+			conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+			continue
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+		if err := conn.WriteJSON(msg); err != nil {
+			return
+		}
+	}
+}
+
+// runControlAction performs an action requested over the control channel.
+// Scans and processing run in the librarian.
+func (h *Handler) runControlAction(ctx context.Context, req ControlRequest, actor string) ControlMessage {
+	var err error
+	switch req.Action {
+	case "scan":
+		_, err = h.jobs.Scan(ctx)
+	case "process":
+		_, err = h.jobs.Process(ctx)
+	case "reprocess":
+		if err = h.library.Reprocess(req.VideoID, actor); err == nil {
+			_, err = h.jobs.Process(ctx)
+		}
+	case "cancel":
+		err = h.jobs.Cancel(ctx, req.VideoID, actor)
+	case "pause":
+		err = h.jobs.Pause(ctx, actor)
+	case "resume":
+		err = h.jobs.Resume(ctx, actor)
+	default:
+		err = errors.New("unknown action")
+	}
+
+	msg := ControlMessage{Type: "result", ID: req.ID, Action: req.Action}
+	if err != nil {
+		msg.Error = err.Error()
+	}
+	return msg
+}
@@ -0,0 +1,64 @@
+// Package iptv writes the extended M3U playlists IPTV players such as
+// TiviMate, Kodi or VLC load, with the attributes they read for channel
+// names, logos, groups and the programme guide.
+package iptv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Entry is a channel or video of a playlist
+type Entry struct {
+	Title string
+	URL   string
+	// Duration is in seconds, -1 for live channels and unknown durations
+	Duration int
+	// GuideID is the channel id of the entry in the XMLTV guide, "" for
+	// videos
+	GuideID string
+	Logo    string
+	// Group is the category players list the entry under
+	Group string
+}
+
+// Write writes entries as an extended M3U playlist, pointing players at
+// the XMLTV guide at guideURL when it isn't empty
+func Write(w io.Writer, guideURL string, entries []Entry) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString("#EXTM3U")
+	if guideURL != "" {
+		// Players differ in which of the two they read
+		fmt.Fprintf(bw, ` url-tvg="%s" x-tvg-url="%s"`, attr(guideURL), attr(guideURL))
+	}
+	bw.WriteString("\n")
+
+	for _, e := range entries {
+		fmt.Fprintf(bw, "#EXTINF:%d", e.Duration)
+		if e.GuideID != "" {
+			fmt.Fprintf(bw, ` tvg-id="%s"`, attr(e.GuideID))
+		}
+		fmt.Fprintf(bw, ` tvg-name="%s"`, attr(e.Title))
+		if e.Logo != "" {
+			fmt.Fprintf(bw, ` tvg-logo="%s"`, attr(e.Logo))
+		}
+		if e.Group != "" {
+			fmt.Fprintf(bw, ` group-title="%s"`, attr(e.Group))
+		}
+		fmt.Fprintf(bw, ",%s\n%s\n", line(e.Title), line(e.URL))
+	}
+	return bw.Flush()
+}
+
+// attr makes s safe as a quoted attribute value. M3U has no escaping, so
+// double quotes become single quotes.
+func attr(s string) string {
+	return strings.ReplaceAll(line(s), `"`, "'")
+}
+
+// line keeps s on a single line
+func line(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,621 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/utils"
+)
+
+// ErrCacheBusy is returned when purging a cache that a running job writes to
+var ErrCacheBusy = errors.New("cache is in use by a running job")
+
+// streamingWindow is how recently a video's output must have been served
+// for it to count as being streamed. Accesses reach the database every 30
+// seconds, and players may pause between segments.
+const streamingWindow = 5 * time.Minute
+
+// staleWorkAge is how long a directory in media.work_dir stays untouched
+// before it is considered left behind by a crashed transcode
+const staleWorkAge = 24 * time.Hour
+
+// ErrCacheDiskFull is returned when a video can't be transcoded as the
+// cache disk has less than cache.min_free_disk_gb free
+var ErrCacheDiskFull = errors.New("not enough free space on the cache disk")
+
+// CacheDir describes a directory in the cache and the video it belongs to
+type CacheDir struct {
+	Dir        string          // relative to the cache root
+	Video      *database.Video // nil for directories no video uses
+	SizeBytes  int64           // including the segments in object storage
+	Files      int             // on the disk
+	LastAccess time.Time
+	// Busy is set while a transcode or other FFmpeg job writes to the directory
+	Busy bool
+	// Streaming is set while the output is being streamed, going by the
+	// last access recorded in the cache inventory
+	Streaming bool
+	// Warm is set for the output of the newest videos of libraries with
+	// warm_newest, which is kept like that of pinned videos
+	Warm bool
+}
+
+// Pinned reports whether the directory holds the output of a pinned video
+func (d *CacheDir) Pinned() bool {
+	return d.Video != nil && d.Video.Pinned
+}
+
+// PurgeResult describes the outcome of purging the whole cache
+type PurgeResult struct {
+	Removed    []string
+	Skipped    []string // directories in use by a running job
+	FreedBytes int64
+}
+
+// EvictOptions select the cache directories EvictCache removes. Without
+// OlderThan, MaxSize and MinFree every selected directory is removed.
+type EvictOptions struct {
+	// OlderThan removes directories that haven't been accessed for as long
+	OlderThan time.Duration
+	// MaxSize removes the least recently used directories until the whole
+	// cache is at most this many bytes
+	MaxSize int64
+	// MinFree removes the least recently used directories until the cache
+	// disk has this many bytes free
+	MinFree int64
+	// VideoID limits eviction to the directory of a video, if set
+	VideoID int64
+	// DryRun reports what would be removed without removing anything
+	DryRun bool
+}
+
+// EvictResult describes the outcome of EvictCache
+type EvictResult struct {
+	Removed    []*CacheDir // least recently used first
+	Skipped    []string    // directories being transcoded or streamed
+	FreedBytes int64
+}
+
+// EvictCache removes cache directories by age and total size, least
+// recently streamed first, going by the access times of the cache
+// inventory. The output of pinned videos and videos kept warm is kept, and
+// directories a running job writes to or that are being streamed are
+// skipped. The actor is
+// recorded in the audit log.
+func (m *Manager) EvictCache(opts EvictOptions, actor string) (*EvictResult, error) {
+	m.evictMu.Lock()
+	defer m.evictMu.Unlock()
+
+	dirs, err := m.CacheUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, dir := range dirs {
+		total += dir.SizeBytes
+	}
+	sort.SliceStable(dirs, func(i, j int) bool { return dirs[i].LastAccess.Before(dirs[j].LastAccess) })
+
+	selectAll := opts.OlderThan <= 0 && opts.MaxSize <= 0 && opts.MinFree <= 0
+
+	// Don't empty the cache for free space it can't provide
+	var free int64
+	if opts.MinFree > 0 {
+		if free, err = utils.FreeDiskSpace(m.settings().Media.CacheDir); err != nil {
+			m.log.Warn("Can't tell the free space of the cache disk", "err", err)
+			opts.MinFree = 0
+		} else if free+evictable(dirs) < opts.MinFree {
+			m.log.Warn("Evicting the cache can't free enough disk space", "free_bytes", free, "min_free_bytes", opts.MinFree)
+			opts.MinFree = 0
+		}
+	}
+
+	result := &EvictResult{Removed: []*CacheDir{}, Skipped: []string{}}
+	for _, dir := range dirs {
+		if opts.VideoID != 0 && (dir.Video == nil || dir.Video.ID != opts.VideoID) {
+			continue
+		}
+		if dir.Pinned() || dir.Warm {
+			continue
+		}
+		expired := opts.OlderThan > 0 && time.Since(dir.LastAccess) > opts.OlderThan
+		oversize := opts.MaxSize > 0 && total > opts.MaxSize
+		diskFull := opts.MinFree > 0 && free < opts.MinFree
+		if !selectAll && !expired && !oversize && !diskFull {
+			continue
+		}
+		if dir.Busy || dir.Streaming {
+			result.Skipped = append(result.Skipped, dir.Dir)
+			continue
+		}
+
+		if !opts.DryRun {
+			var videoID int64
+			if dir.Video != nil {
+				videoID = dir.Video.ID
+			}
+			if _, err := m.removeCacheDir(dir.Dir, videoID, actor); err != nil {
+				return result, err
+			}
+			if err := m.db.DeleteCacheEntriesForDir(dir.Dir); err != nil {
+				m.log.Error("Error clearing cache inventory", "dir", dir.Dir, "err", err)
+			}
+		}
+		result.Removed = append(result.Removed, dir)
+		result.FreedBytes += dir.SizeBytes
+		total -= dir.SizeBytes
+		free += dir.SizeBytes
+	}
+
+	if !opts.DryRun && len(result.Removed) > 0 {
+		m.log.Info("Evicted cache", "removed", len(result.Removed), "skipped", len(result.Skipped),
+			"freed_bytes", result.FreedBytes)
+	}
+	return result, nil
+}
+
+// evictable returns the combined size of the directories that aren't
+// pinned or kept warm, no job writes to and nobody streams
+func evictable(dirs []*CacheDir) int64 {
+	var size int64
+	for _, dir := range dirs {
+		if !dir.Pinned() && !dir.Warm && !dir.Busy && !dir.Streaming {
+			size += dir.SizeBytes
+		}
+	}
+	return size
+}
+
+// StartCacheCleanup starts a background job that removes cached output
+// to keep the cache within the limits of the [cache] settings, and the
+// output crashed transcodes left in the work directory. It runs right away
+// and then every cache.cleanup_interval_minutes, following changes of the
+// interval on reload. Every cache.fsck_interval_hours it reconciles the
+// cache with the database.
+func (m *Manager) StartCacheCleanup() {
+	go func() {
+		interval := m.cleanupInterval()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		lastFsck := time.Now()
+
+		// A restart shouldn't postpone the cleanup by a whole interval
+		m.cleanupCache()
+		for {
+			select {
+			case <-ticker.C:
+			case <-m.cleanupReloadCh:
+				if next := m.cleanupInterval(); next != interval {
+					interval = next
+					ticker.Reset(interval)
+					m.log.Info("Cache cleanup interval changed", "interval", interval)
+				}
+				continue
+			case <-m.stopChan:
+				return
+			}
+
+			if m.fsckDue(lastFsck) {
+				lastFsck = time.Now()
+				if _, err := m.Fsck(false, database.ActorServer); err != nil {
+					m.log.Error("Error reconciling cache", "err", err)
+				}
+			}
+			m.cleanupCache()
+		}
+	}()
+}
+
+// cleanupInterval returns cache.cleanup_interval_minutes as a duration
+func (m *Manager) cleanupInterval() time.Duration {
+	minutes := m.settings().Cache.CleanupIntervalMinutes
+	if minutes <= 0 {
+		minutes = config.DefaultCacheCleanupInterval
+	}
+	return time.Duration(minutes) * time.Minute
+}
+
+// cleanupCache removes stale work directories and evicts output by the
+// limits of the cache policy
+func (m *Manager) cleanupCache() {
+	m.removeStaleWork()
+	opts := policyOptions(m.settings().Cache)
+	if opts.OlderThan <= 0 && opts.MaxSize <= 0 && opts.MinFree <= 0 {
+		return
+	}
+	if _, err := m.EvictCache(opts, database.ActorServer); err != nil {
+		m.log.Error("Error cleaning up cache", "err", err)
+	}
+}
+
+// policyOptions returns the eviction limits cache.policy selects. The
+// free disk space is kept with every policy.
+func policyOptions(limits config.CacheConfig) EvictOptions {
+	opts := EvictOptions{MinFree: limits.MinFreeDiskBytes()}
+	if limits.Policy != config.CachePolicySize {
+		opts.OlderThan = time.Duration(limits.MaxAgeHours) * time.Hour
+	}
+	if limits.Policy != config.CachePolicyAge {
+		opts.MaxSize = limits.MaxSizeBytes()
+	}
+	return opts
+}
+
+// removeStaleWork removes the directories in the work directory that
+// haven't been written to for staleWorkAge
+func (m *Manager) removeStaleWork() {
+	workDir := m.settings().Media.WorkDirPath()
+	entries, err := os.ReadDir(workDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < staleWorkAge {
+			continue
+		}
+		path := filepath.Join(workDir, entry.Name())
+		if err := os.RemoveAll(path); err != nil {
+			m.log.Error("Error removing stale work directory", "dir", path, "err", err)
+			continue
+		}
+		m.log.Info("Removed stale work directory", "dir", path)
+	}
+}
+
+// ensureCacheSpace makes room in the cache before a video is transcoded
+// into output of about estimate bytes, evicting the least recently streamed
+// output down to cache.max_size_gb, unless cache.policy is "age", and until
+// cache.min_free_disk_gb is free after the output is written. The output of
+// the jobs running meanwhile counts as written already. It fails with
+// ErrCacheDiskFull when not enough space could be freed, or the work
+// directory can't hold the output. Otherwise the estimate is reserved until
+// release is called.
+func (m *Manager) ensureCacheSpace(estimate int64) (release func(), err error) {
+	m.spaceMu.Lock()
+	defer m.spaceMu.Unlock()
+
+	limits := m.settings().Cache
+	opts := policyOptions(limits)
+	opts.OlderThan = 0
+	needed := m.reserved + estimate
+	if opts.MaxSize > 0 || opts.MinFree > 0 {
+		if opts.MinFree > 0 {
+			opts.MinFree += needed
+		}
+		if _, err := m.EvictCache(opts, database.ActorLibrarian); err != nil {
+			return nil, fmt.Errorf("failed to make room in the cache: %w", err)
+		}
+	}
+
+	// Without a way to measure free space the transcode is left to try
+	cacheDir := m.settings().Media.CacheDir
+	if free, err := utils.FreeDiskSpace(cacheDir); err == nil && free-needed < limits.MinFreeDiskBytes() {
+		return nil, fmt.Errorf("%w: %.1f GB free, %.1f GB needed, cache.min_free_disk_gb is %g", ErrCacheDiskFull,
+			float64(free)/(1<<30), float64(needed)/(1<<30), limits.MinFreeDiskGB)
+	}
+	// The work directory may be on a disk of its own
+	workDir := m.settings().Media.WorkDirPath()
+	if err := os.MkdirAll(workDir, 0755); err == nil {
+		if free, err := utils.FreeDiskSpace(workDir); err == nil && free < needed {
+			return nil, fmt.Errorf("%w: %.1f GB free in the work directory, %.1f GB needed", ErrCacheDiskFull,
+				float64(free)/(1<<30), float64(needed)/(1<<30))
+		}
+	}
+
+	m.reserved += estimate
+	return func() {
+		m.spaceMu.Lock()
+		m.reserved -= estimate
+		m.spaceMu.Unlock()
+	}, nil
+}
+
+// requeueWaitingForSpace moves the videos waiting for free space on the
+// cache disk back to pending, as many as there is room for now, longest
+// waiting first, and returns how many it requeued
+func (m *Manager) requeueWaitingForSpace() (int, error) {
+	videos, err := m.db.ListWaitingForSpace()
+	if err != nil || len(videos) == 0 {
+		return 0, err
+	}
+	free, err := utils.FreeDiskSpace(m.settings().Media.CacheDir)
+	if err != nil {
+		// Let processing find out
+		free = math.MaxInt64
+	}
+
+	m.spaceMu.Lock()
+	free -= m.reserved
+	m.spaceMu.Unlock()
+	free -= m.settings().Cache.MinFreeDiskBytes()
+
+	requeued := 0
+	for _, video := range videos {
+		estimate := m.tm.EstimateOutputSize(video.Path, video.Duration, m.repairVariants(video))
+		if estimate > free {
+			// Keep the order, the next one in line goes first
+			break
+		}
+		if err := m.db.RequeueWaitingForSpace(video.ID); err != nil {
+			return requeued, err
+		}
+		m.logStatusChange(video.ID, database.StatusPending, "enough free space on the cache disk")
+		free -= estimate
+		requeued++
+	}
+	return requeued, nil
+}
+
+// CacheUsage reports the size of every directory in the cache, largest
+// first. Usage is measured on disk and in object storage, so directories
+// left behind by deleted videos are reported as well.
+func (m *Manager) CacheUsage() ([]*CacheDir, error) {
+	entries, err := os.ReadDir(m.settings().Media.CacheDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cache directory: %w", err)
+	}
+
+	owners, busy, err := m.cacheOwners()
+	if err != nil {
+		return nil, err
+	}
+	lastAccess, err := m.db.CacheDirLastAccess()
+	if err != nil {
+		return nil, err
+	}
+	lastStreamed, err := m.db.CacheDirLastStreamed()
+	if err != nil {
+		return nil, err
+	}
+	warm, err := m.warmVideos()
+	if err != nil {
+		return nil, err
+	}
+	stored, err := m.store.Usage(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var dirs []*CacheDir
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		name := entry.Name()
+		if isWorkDir(name) {
+			continue
+		}
+		size, files, err := dirSize(filepath.Join(m.settings().Media.CacheDir, name))
+		if err != nil {
+			return nil, err
+		}
+
+		dir := &CacheDir{
+			Dir:        name,
+			Video:      owners[name],
+			SizeBytes:  size + stored[name],
+			Files:      files,
+			LastAccess: lastAccess[name],
+			Busy:       busy[name],
+			Streaming:  time.Since(lastStreamed[name]) < streamingWindow,
+			Warm:       owners[name] != nil && warm[owners[name].ID],
+		}
+		if dir.LastAccess.IsZero() {
+			if info, err := entry.Info(); err == nil {
+				dir.LastAccess = info.ModTime()
+			}
+		}
+		dirs = append(dirs, dir)
+		delete(stored, name)
+	}
+
+	// Segments in object storage whose directory is gone from the disk
+	for name, size := range stored {
+		dirs = append(dirs, &CacheDir{
+			Dir:        name,
+			Video:      owners[name],
+			SizeBytes:  size,
+			LastAccess: lastAccess[name],
+			Busy:       busy[name],
+		})
+	}
+
+	sort.Slice(dirs, func(i, j int) bool {
+		if dirs[i].SizeBytes != dirs[j].SizeBytes {
+			return dirs[i].SizeBytes > dirs[j].SizeBytes
+		}
+		return dirs[i].Dir < dirs[j].Dir
+	})
+	return dirs, nil
+}
+
+// PinVideo pins or unpins a video, keeping its output in the cache
+// regardless of the cache limits. The actor is recorded in the audit log.
+func (m *Manager) PinVideo(id int64, pinned bool, actor string) error {
+	if err := m.db.SetPinned(id, pinned); err != nil {
+		return err
+	}
+	msg := "unpinned"
+	if pinned {
+		msg = "pinned"
+	}
+	m.logEvent(database.EventPin, id, actor, msg)
+	return nil
+}
+
+// PurgeVideoCache removes the cached output of a video and returns the
+// number of bytes freed. Videos being transcoded are refused with
+// ErrCacheBusy. The video stays in the library and has to be reprocessed
+// before it can be streamed again. The actor is recorded in the audit log.
+func (m *Manager) PurgeVideoCache(id int64, actor string) (int64, error) {
+	video, err := m.db.GetVideo(id)
+	if err != nil {
+		return 0, err
+	}
+
+	dir := m.tm.RelativeToCache(m.tm.CacheDirFor(video.CacheDir, video.Path))
+	_, busy, err := m.cacheOwners()
+	if err != nil {
+		return 0, err
+	}
+	if busy[dir] {
+		return 0, ErrCacheBusy
+	}
+
+	freed, err := m.removeCacheDir(dir, video.ID, actor)
+	if err != nil {
+		return 0, err
+	}
+	if err := m.db.DeleteCacheEntries(video.ID); err != nil {
+		m.log.Error("Error clearing cache inventory", "video", video.Filename, "err", err)
+	}
+	return freed, nil
+}
+
+// PurgeCache removes every directory in the cache except those a running
+// job writes to, which are reported as skipped. The actor is recorded in
+// the audit log.
+func (m *Manager) PurgeCache(actor string) (*PurgeResult, error) {
+	dirs, err := m.CacheUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &PurgeResult{Removed: []string{}, Skipped: []string{}}
+	for _, dir := range dirs {
+		if dir.Busy {
+			result.Skipped = append(result.Skipped, dir.Dir)
+			continue
+		}
+
+		var videoID int64
+		if dir.Video != nil {
+			videoID = dir.Video.ID
+		}
+		freed, err := m.removeCacheDir(dir.Dir, videoID, actor)
+		if err != nil {
+			return result, err
+		}
+		if err := m.db.DeleteCacheEntriesForDir(dir.Dir); err != nil {
+			m.log.Error("Error clearing cache inventory", "dir", dir.Dir, "err", err)
+		}
+		result.Removed = append(result.Removed, dir.Dir)
+		result.FreedBytes += freed
+	}
+
+	m.log.Info("Purged cache", "removed", len(result.Removed), "skipped", len(result.Skipped),
+		"freed_bytes", result.FreedBytes)
+	return result, nil
+}
+
+// removeCacheDir deletes a directory relative to the cache root, records
+// the removal in the audit log and returns the number of bytes freed.
+// videoID is the video the directory belongs to, 0 if none.
+func (m *Manager) removeCacheDir(dir string, videoID int64, actor string) (int64, error) {
+	if dir == "" || dir == "." || strings.Contains(dir, "/") || strings.HasPrefix(dir, "..") {
+		return 0, fmt.Errorf("refusing to purge %q: not a cache directory", dir)
+	}
+
+	path := filepath.Join(m.settings().Media.CacheDir, dir)
+	size, _, err := dirSize(path)
+	if err != nil {
+		return 0, err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return 0, fmt.Errorf("failed to remove cache %s: %w", dir, err)
+	}
+	stored, err := m.store.Remove(m.ctx, dir)
+	size += stored
+	if err != nil {
+		return size, fmt.Errorf("failed to remove cache %s: %w", dir, err)
+	}
+
+	m.logEvent(database.EventCacheCleanup, videoID, actor, fmt.Sprintf("purged cache %s (%d bytes)", path, size))
+	return size, nil
+}
+
+// cacheOwners maps the cache directories of the library to their videos,
+// and reports the directories running jobs write to: those of videos the
+// librarian is processing and those FFmpeg processes of this server write
+// output into, such as artwork extraction.
+func (m *Manager) cacheOwners() (map[string]*database.Video, map[string]bool, error) {
+	videos, err := m.db.ListAllVideos()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	owners := make(map[string]*database.Video)
+	busy := make(map[string]bool)
+	for _, video := range videos {
+		dir := m.tm.RelativeToCache(m.tm.CacheDirFor(video.CacheDir, video.Path))
+		if owners[dir] == nil || video.Status == database.StatusProcessing {
+			owners[dir] = video
+		}
+		if video.Status == database.StatusProcessing {
+			busy[dir] = true
+		}
+	}
+
+	for _, p := range m.tm.ActiveProcesses() {
+		if !filepath.IsAbs(p.Output) {
+			continue
+		}
+		rel := m.tm.RelativeToCache(p.Output)
+		if dir, _, found := strings.Cut(rel, "/"); found && dir != ".." {
+			busy[dir] = true
+		}
+	}
+
+	return owners, busy, nil
+}
+
+// isWorkDir reports whether a directory in the cache is the default work
+// directory, the output of live channels or one utils.ReplaceDir uses to
+// swap output in, rather than the output of a video
+func isWorkDir(name string) bool {
+	if name == config.DefaultWorkDirName || name == config.DefaultLiveDirName {
+		return true
+	}
+	return strings.HasPrefix(name, ".") && (strings.HasSuffix(name, ".new") || strings.HasSuffix(name, ".old"))
+}
+
+// dirSize returns the combined size and number of the regular files in a
+// directory tree. A missing directory is empty.
+func dirSize(path string) (int64, int, error) {
+	var size int64
+	var files int
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		size += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("failed to measure cache %s: %w", path, err)
+	}
+	return size, files, nil
+}
@@ -0,0 +1,92 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// FsckResult describes the outcome of Fsck
+type FsckResult struct {
+	// Orphaned are the cache directories no video uses
+	Orphaned []*CacheDir
+	// Requeued are the ready videos whose output is missing
+	Requeued   []*database.Video
+	FreedBytes int64
+}
+
+// Fsck reconciles the cache with the database after crashes or manual
+// deletions. Cache directories no video uses are removed, and ready videos
+// whose master playlist is missing are put back into the processing queue.
+// Output that was evicted or purged is missing on purpose and left alone,
+// as are directories a running job writes to. With dryRun nothing is
+// changed. The actor is recorded in the audit log.
+func (m *Manager) Fsck(dryRun bool, actor string) (*FsckResult, error) {
+	m.evictMu.Lock()
+	defer m.evictMu.Unlock()
+
+	dirs, err := m.CacheUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &FsckResult{Orphaned: []*CacheDir{}, Requeued: []*database.Video{}}
+	for _, dir := range dirs {
+		if dir.Video != nil || dir.Busy {
+			continue
+		}
+		if !dryRun {
+			if _, err := m.removeCacheDir(dir.Dir, 0, actor); err != nil {
+				return result, err
+			}
+		}
+		result.Orphaned = append(result.Orphaned, dir)
+		result.FreedBytes += dir.SizeBytes
+	}
+
+	videos, err := m.db.ListAllVideos()
+	if err != nil {
+		return result, err
+	}
+	// Evicting and purging clear the inventory of a video, so output that
+	// is gone while the inventory remains was lost
+	cached, err := m.db.CachedVideoIDs()
+	if err != nil {
+		return result, err
+	}
+	for _, video := range videos {
+		if video.Status != database.StatusReady || !cached[video.ID] {
+			continue
+		}
+		master := filepath.Join(m.settings().Media.CacheDir, m.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path))
+		if _, err := os.Stat(master); !os.IsNotExist(err) {
+			continue
+		}
+
+		if !dryRun {
+			if err := m.db.SetVideoPending(video.ID); err != nil {
+				return result, err
+			}
+			if err := m.db.DeleteCacheEntries(video.ID); err != nil {
+				m.log.Error("Error clearing cache inventory", "video", video.Filename, "err", err)
+			}
+			m.logEvent(database.EventStatusChange, video.ID, actor, "queued for reprocessing, its output is missing")
+		}
+		result.Requeued = append(result.Requeued, video)
+	}
+
+	if !dryRun && (len(result.Orphaned) > 0 || len(result.Requeued) > 0) {
+		m.log.Info("Reconciled cache", "orphaned", len(result.Orphaned), "requeued", len(result.Requeued),
+			"freed_bytes", result.FreedBytes)
+	}
+	return result, nil
+}
+
+// fsckDue reports whether the scheduled Fsck is due, going by
+// cache.fsck_interval_hours and when it last ran
+func (m *Manager) fsckDue(last time.Time) bool {
+	interval := time.Duration(m.settings().Cache.FsckIntervalHours) * time.Hour
+	return interval > 0 && time.Since(last) >= interval
+}
@@ -0,0 +1,106 @@
+package library
+
+import (
+	"context"
+	"errors"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// ErrNotProcessing is returned by CancelProcessing when this process isn't
+// running a job for the video
+var ErrNotProcessing = errors.New("video is not being processed by this process")
+
+// ErrAlreadyProcessing is returned by Reprocess while a video is being processed
+var ErrAlreadyProcessing = errors.New("video is currently being processed")
+
+// startJob registers a running processing job for a video. The returned
+// context is cancelled by CancelProcessing or with parent; done must be
+// called once the job finished.
+func (m *Manager) startJob(parent context.Context, videoID int64) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+
+	m.jobsMu.Lock()
+	m.jobs[videoID] = cancel
+	m.jobsMu.Unlock()
+
+	return ctx, func() {
+		m.jobsMu.Lock()
+		delete(m.jobs, videoID)
+		m.jobsMu.Unlock()
+		cancel()
+	}
+}
+
+// ActiveJobs returns the IDs of the videos this process is processing
+func (m *Manager) ActiveJobs() []int64 {
+	m.jobsMu.Lock()
+	defer m.jobsMu.Unlock()
+
+	ids := make([]int64, 0, len(m.jobs))
+	for id := range m.jobs {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// CancelProcessing stops the running processing job of a video. The video
+// is marked as failed without a scheduled retry. The actor is recorded in
+// the audit log.
+func (m *Manager) CancelProcessing(id int64, actor string) error {
+	m.jobsMu.Lock()
+	cancel, ok := m.jobs[id]
+	m.jobsMu.Unlock()
+
+	if !ok {
+		return ErrNotProcessing
+	}
+	cancel()
+
+	m.log.Info("Cancelled processing of video", "id", id)
+	m.logEvent(database.EventStatusChange, id, actor, "processing cancelled")
+	return nil
+}
+
+// Reprocess clears the failure state of a video and queues it for
+// processing again. The actor is recorded in the audit log.
+func (m *Manager) Reprocess(id int64, actor string) error {
+	video, err := m.db.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	if video.Status == database.StatusProcessing {
+		return ErrAlreadyProcessing
+	}
+
+	if err := m.db.ResetRetries(id); err != nil {
+		return err
+	}
+
+	m.logEvent(database.EventStatusChange, id, actor, "queued for reprocessing")
+	return nil
+}
+
+// Pause stops processing from starting new jobs. Running jobs finish, and
+// the videos not started yet stay pending until Resume.
+func (m *Manager) Pause(actor string) {
+	if m.paused.Swap(true) {
+		return
+	}
+	m.log.Info("Paused processing")
+	m.LogEvent(database.EventStatusChange, actor, "processing paused")
+}
+
+// Resume lets processing start new jobs again after Pause
+func (m *Manager) Resume(actor string) {
+	if !m.paused.Swap(false) {
+		return
+	}
+	m.log.Info("Resumed processing")
+	m.LogEvent(database.EventStatusChange, actor, "processing resumed")
+}
+
+// Paused reports whether processing is paused
+func (m *Manager) Paused() bool {
+	return m.paused.Load()
+}
@@ -1,18 +1,25 @@
 package library
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/fsnotify/fsnotify"
 	
 	"github.com/kaero/streaming/config"
 	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/notify"
+	"github.com/kaero/streaming/internal/queue"
+	"github.com/kaero/streaming/internal/storage"
+	"github.com/kaero/streaming/internal/telemetry"
 	"github.com/kaero/streaming/internal/transcoder"
 )
 
@@ -21,30 +28,185 @@ type Manager struct {
 	config    *config.Config
 	db        *database.DB
 	tm        *transcoder.Manager
+	// store keeps the segments of the cache, see cache.backend
+	store     storage.Store
+	// queue shares the pending videos with other librarians, nil with the
+	// "database" queue backend
+	queue     queue.Queue
 	watcher   *fsnotify.Watcher
 	watcherMu sync.Mutex
 	isWatching bool
 	stopChan   chan struct{}
+	jobsMu     sync.Mutex
+	jobs       map[int64]context.CancelFunc
+	// paused keeps ProcessPending from starting new jobs, see Pause
+	paused     atomic.Bool
+	// processMu keeps runs of ProcessPendingVideos from picking up the
+	// same videos
+	processMu sync.Mutex
+	// evictMu keeps evictions, which measure the cache first, apart
+	evictMu   sync.Mutex
+	// spaceMu guards reserved, the estimated output size of the running
+	// jobs, see ensureCacheSpace
+	spaceMu   sync.Mutex
+	reserved  int64
+	notifier  *notify.Notifier
+	// configMu guards config and notifier, which Reload replaces
+	configMu  sync.Mutex
+	// reloadCh wakes the periodic scan up to pick up a new interval
+	reloadCh  chan struct{}
+	// cleanupReloadCh does the same for the cache cleanup
+	cleanupReloadCh chan struct{}
+	// ctx is cancelled by Close, stopping the running jobs
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopOnce  sync.Once
+	log       *slog.Logger
 }
 
-// New creates a new library manager
-func New(cfg *config.Config, db *database.DB, tm *transcoder.Manager) (*Manager, error) {
+// New creates a new library manager logging to logger
+func New(cfg *config.Config, db *database.DB, tm *transcoder.Manager, logger *slog.Logger) (*Manager, error) {
+	notifier, err := notify.New(cfg.Notify.Targets, logger)
+	if err != nil {
+		return nil, err
+	}
+	store, err := storage.New(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	q, err := queue.New(cfg, logger)
+	if err != nil {
+		return nil, err
+	}
+	
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Manager{
 		config:    cfg,
 		db:        db,
 		tm:        tm,
+		store:     store,
+		queue:     q,
 		stopChan:  make(chan struct{}),
+		jobs:      make(map[int64]context.CancelFunc),
+		notifier:  notifier,
+		reloadCh:  make(chan struct{}, 1),
+		cleanupReloadCh: make(chan struct{}, 1),
+		ctx:       ctx,
+		cancel:    cancel,
+		log:       logger,
 	}, nil
 }
 
-// ScanLibrary scans the media directory for new videos
+// Store returns where the segments of the cache are kept
+func (m *Manager) Store() storage.Store {
+	return m.store
+}
+
+// settings returns the configuration in effect
+func (m *Manager) settings() *config.Config {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+	return m.config
+}
+
+// notify returns the notifier for the configured targets
+func (m *Manager) notify() *notify.Notifier {
+	m.configMu.Lock()
+	defer m.configMu.Unlock()
+	return m.notifier
+}
+
+// Reload switches to a reloaded configuration: the periodic scan and the
+// cache cleanup move to their new intervals, and new retry, trash, cache
+// and notification settings apply from now on. Nothing changes if the notify targets are invalid.
+func (m *Manager) Reload(cfg *config.Config) error {
+	notifier, err := notify.New(cfg.Notify.Targets, m.log)
+	if err != nil {
+		return err
+	}
+	
+	m.configMu.Lock()
+	m.config = cfg
+	m.notifier = notifier
+	m.configMu.Unlock()
+	
+	for _, ch := range []chan struct{}{m.reloadCh, m.cleanupReloadCh} {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// ScanLibrary scans the media directories and reconciles the library with
+// them. New files are added, changed files are queued for reprocessing and
+// videos whose file is gone are moved to the trash.
 func (m *Manager) ScanLibrary() error {
-	log.Println("Scanning library for new videos...")
+	_, err := m.Scan(database.ActorLibrarian)
+	return err
+}
+
+// Scan walks every media directory and applies the result in one
+// transaction, returning the changes. The actor is recorded in the audit log.
+func (m *Manager) Scan(actor string) (*database.ScanSummary, error) {
+	m.log.Info("Scanning library for new videos")
+	
+	var files []database.ScannedFile
+	var keepDirs []string
+	for _, lib := range m.settings().MediaLibraries() {
+		var found []database.ScannedFile
+		var err error
+		if lib.Remote() {
+			found, err = m.scanSource(lib)
+			if err != nil {
+				// Keep the videos of a source that can't be reached, like
+				// those of an unmounted drive
+				m.log.Error("Error scanning library source, skipping removal of missing videos", "library", lib.Name, "err", err)
+				keepDirs = append(keepDirs, lib.MediaDir)
+				continue
+			}
+		} else {
+			found, err = scanDir(lib.MediaDir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		
+		// An empty media directory usually means an unmounted drive rather
+		// than a deliberately emptied library, so keep existing entries in
+		// that case
+		if len(found) == 0 {
+			m.log.Warn("No videos found, skipping removal of missing videos", "dir", lib.MediaDir)
+			keepDirs = append(keepDirs, lib.MediaDir)
+		}
+		files = append(files, found...)
+	}
 	
-	mediaDir := m.config.Media.MediaDir
+	summary, err := m.db.ReconcileScan(files, keepDirs)
+	if err != nil {
+		return nil, err
+	}
 	
-	// Walk through the media directory
-	return filepath.Walk(mediaDir, func(path string, info os.FileInfo, err error) error {
+	// Clean up the cache of videos whose source file disappeared
+	for _, video := range summary.Removed {
+		m.removeCache(video)
+		m.log.Info("Moved missing video to trash", "video", video.Filename, "id", video.ID)
+		m.logEvent(database.EventDelete, video.ID, actor,
+			"source file missing, moved to trash: "+video.Path)
+	}
+	
+	msg := fmt.Sprintf("Scan complete: %d added, %d updated, %d removed",
+		summary.Added, summary.Updated, len(summary.Removed))
+	m.log.Info("Scan complete", "added", summary.Added, "updated", summary.Updated, "removed", len(summary.Removed))
+	m.logEvent(database.EventScan, 0, actor, msg)
+	return summary, nil
+}
+
+// scanDir walks a media directory, collecting video files
+func scanDir(mediaDir string) ([]database.ScannedFile, error) {
+	var files []database.ScannedFile
+	err := filepath.Walk(mediaDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -56,48 +218,115 @@ func (m *Manager) ScanLibrary() error {
 		
 		// Check if it's a video file
 		ext := strings.ToLower(filepath.Ext(info.Name()))
-		if !isVideoFile(ext) {
+		if !IsVideoFile(ext) {
 			return nil
 		}
 		
-		// Check if this video already exists in the database
-		exists, err := m.db.VideoExists(path)
-		if err != nil {
-			log.Printf("Error checking video existence: %v", err)
-			return nil
-		}
-		
-		// If the video doesn't exist in the database, add it
-		if !exists {
-			id, err := m.db.AddVideo(info.Name(), path, info.Size())
-			if err != nil {
-				log.Printf("Error adding video to database: %v", err)
-				return nil
-			}
-			
-			log.Printf("Added new video to library: %s (ID: %d)", info.Name(), id)
-		}
-		
+		files = append(files, database.ScannedFile{
+			Filename: info.Name(),
+			Path:     path,
+			Size:     info.Size(),
+		})
 		return nil
 	})
+	if err != nil {
+		// Never reconcile against a partial walk, it would trash everything
+		// that wasn't reached
+		return nil, fmt.Errorf("failed to walk media directory: %w", err)
+	}
+	return files, nil
 }
 
-// ProcessPendingVideos processes all pending videos
+// scanSource lists the video files of a remote library
+func (m *Manager) scanSource(lib config.MediaLibrary) ([]database.ScannedFile, error) {
+	src, err := storage.NewSource(lib)
+	if err != nil {
+		return nil, err
+	}
+	listed, err := src.Scan(m.ctx)
+	if err != nil {
+		return nil, err
+	}
+	
+	var files []database.ScannedFile
+	for _, f := range listed {
+		name := path.Base(f.Path)
+		if !IsVideoFile(strings.ToLower(path.Ext(name))) {
+			continue
+		}
+		files = append(files, database.ScannedFile{Filename: name, Path: f.Path, Size: f.Size})
+	}
+	return files, nil
+}
+
+// ProcessSummary reports the outcome of a ProcessPending run
+type ProcessSummary struct {
+	Ready  int
+	Failed int
+	// Skipped videos were still queued when the run was cancelled and stay
+	// pending
+	Skipped int
+}
+
+// ProcessPendingVideos processes all pending videos, including failed
+// videos whose retry is due. Concurrent calls run one after another. Close
+// cancels the run.
 func (m *Manager) ProcessPendingVideos() error {
+	_, err := m.ProcessPending(m.ctx)
+	return err
+}
+
+// ProcessPending is like ProcessPendingVideos, but reports the outcome.
+// Cancelling ctx cancels the running jobs and leaves their videos and the
+// remaining ones pending. While paused, the videos not started yet are
+// left pending too. With a shared queue, the pending videos are queued and
+// those this librarian claims are processed, see processQueued.
+func (m *Manager) ProcessPending(ctx context.Context) (*ProcessSummary, error) {
+	m.processMu.Lock()
+	defer m.processMu.Unlock()
+	
+	requeued, err := m.db.RequeueDueRetries(time.Now())
+	if err != nil {
+		return nil, fmt.Errorf("failed to requeue retries: %w", err)
+	}
+	if requeued > 0 {
+		m.log.Info("Retrying failed videos", "count", requeued)
+	}
+	
+	spaced, err := m.requeueWaitingForSpace()
+	if err != nil {
+		m.log.Error("Error requeueing videos waiting for space", "err", err)
+	}
+	if spaced > 0 {
+		m.log.Info("Transcoding videos that were waiting for free space", "count", spaced)
+	}
+	
+	warmed, err := m.requeueWarm()
+	if err != nil {
+		m.log.Error("Error requeueing videos to keep warm", "err", err)
+	}
+	if warmed > 0 {
+		m.log.Info("Transcoding evicted videos to keep the cache warm", "count", warmed)
+	}
+	
 	pendingVideos, err := m.db.GetPendingVideos()
 	if err != nil {
-		return fmt.Errorf("failed to get pending videos: %w", err)
+		return nil, fmt.Errorf("failed to get pending videos: %w", err)
+	}
+	if m.queue != nil {
+		return m.processQueued(ctx, pendingVideos)
 	}
 	
+	summary := &ProcessSummary{}
 	if len(pendingVideos) == 0 {
-		log.Println("No pending videos to process")
-		return nil
+		m.log.Debug("No pending videos to process")
+		return summary, nil
 	}
 	
-	log.Printf("Processing %d pending videos", len(pendingVideos))
+	m.log.Info("Processing pending videos", "count", len(pendingVideos))
 	
 	// Create a worker pool
-	numWorkers := m.config.Library.ProcessingThreads
+	numWorkers := m.settings().Library.ProcessingThreads
 	if numWorkers <= 0 {
 		numWorkers = 1
 	}
@@ -107,6 +336,7 @@ func (m *Manager) ProcessPendingVideos() error {
 	
 	// Create a wait group to wait for all workers
 	var wg sync.WaitGroup
+	var summaryMu sync.Mutex
 	
 	// Start workers
 	for i := 0; i < numWorkers; i++ {
@@ -115,7 +345,21 @@ func (m *Manager) ProcessPendingVideos() error {
 			defer wg.Done()
 			
 			for video := range jobs {
-				m.processVideo(video)
+				if ctx.Err() != nil || m.paused.Load() {
+					summaryMu.Lock()
+					summary.Skipped++
+					summaryMu.Unlock()
+					continue
+				}
+				
+				ready := m.processVideo(ctx, video)
+				summaryMu.Lock()
+				if ready {
+					summary.Ready++
+				} else {
+					summary.Failed++
+				}
+				summaryMu.Unlock()
 			}
 		}(i)
 	}
@@ -131,37 +375,372 @@ func (m *Manager) ProcessPendingVideos() error {
 	// Wait for all workers to finish
 	wg.Wait()
 	
-	return nil
+	return summary, nil
 }
 
-// processVideo processes a single video
-func (m *Manager) processVideo(video *database.Video) {
-	log.Printf("Processing video: %s", video.Filename)
+// processVideo processes a single video, reporting whether it became
+// ready. Cancelling ctx cancels the job like CancelProcessing.
+func (m *Manager) processVideo(parent context.Context, video *database.Video) bool {
+	m.log.Info("Processing video", "video", video.Filename, "id", video.ID)
+	
+	traced, span := telemetry.Start(parent, "process video", "video.id", video.ID, "video.file", video.Filename)
+	defer span.End()
+	// Queries are traced with the job but outlive its cancellation
+	db := m.db.WithContext(context.WithoutCancel(traced))
 	
 	// Update status to processing
-	if err := m.db.SetVideoProcessing(video.ID); err != nil {
-		log.Printf("Error setting video as processing: %v", err)
-		return
+	if err := db.SetVideoProcessing(video.ID); err != nil {
+		m.log.Error("Error setting video as processing", "video", video.Filename, "err", err)
+		return false
 	}
+	m.logStatusChange(video.ID, database.StatusProcessing, "")
+	
+	// Register the job so it can be cancelled
+	ctx, done := m.startJob(traced, video.ID)
+	defer done()
 	
-	// Process the video
-	masterPath, err := m.tm.PrepareVideo(video.Path)
+	// Record the attempt so failures keep their history
+	attemptID, err := db.StartAttempt(video.ID)
 	if err != nil {
-		log.Printf("Error processing video: %v", err)
-		m.db.SetVideoError(video.ID, err.Error())
-		return
+		m.log.Error("Error recording processing attempt", "video", video.Filename, "err", err)
+	}
+	
+	// Probe the source for its technical metadata
+	_, stage := telemetry.Start(ctx, "probe")
+	probe, err := m.tm.Probe(video.Path)
+	stage.SetError(err)
+	stage.End()
+	if err != nil {
+		span.SetError(err)
+		m.finishAttempt(attemptID, &transcoder.PrepareResult{}, err)
+		m.log.Error("Error probing video", "video", video.Filename, "err", err)
+		m.setVideoError(video, err, "")
+		return false
+	}
+	
+	if err := db.SetVideoMetadata(video.ID, probe.Duration, metadataFromProbe(probe)); err != nil {
+		m.log.Error("Error storing video metadata", "video", video.Filename, "err", err)
 	}
+	if err := db.SetVideoTracks(video.ID, chaptersFromProbe(probe), subtitlesFromProbe(probe)); err != nil {
+		m.log.Error("Error storing chapters and subtitle tracks", "video", video.Filename, "err", err)
+	}
+	
+	// Don't fill up the cache disk, wait until there is room for the
+	// output instead
+	repair := m.repairVariants(video)
+	_, stage = telemetry.Start(ctx, "cache space")
+	release, err := m.ensureCacheSpace(m.tm.EstimateOutputSize(video.Path, probe.Duration, repair))
+	stage.SetError(err)
+	stage.End()
+	if err != nil {
+		span.SetError(err)
+		m.finishAttempt(attemptID, &transcoder.PrepareResult{}, err)
+		m.log.Error("Error making room in the cache", "video", video.Filename, "err", err)
+		m.setVideoError(video, err, "")
+		return false
+	}
+	defer release()
 	
-	// Get video duration (in the future we can get this from ffmpeg)
-	duration := 0.0 // For now, we don't have a way to get the duration
+	// Process the video, recording the progress for the server. Variants
+	// queued for repair are transcoded into the output in place.
+	progress := newProgressRecorder(m.db, video.ID, m.log)
+	var result *transcoder.PrepareResult
+	stageCtx, stage := telemetry.Start(ctx, "transcode", "repair", strings.Join(repair, ","))
+	if len(repair) > 0 {
+		master := m.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path)
+		result, err = m.tm.RepairVariants(stageCtx, video.Path, m.tm.CacheDirFor(video.CacheDir, video.Path),
+			strings.TrimSuffix(path.Base(master), ".m3u8"), repair, progress.report)
+	} else {
+		result, err = m.tm.PrepareVideo(stageCtx, video.Path, progress.report)
+	}
+	stage.SetError(err)
+	stage.End()
+	span.SetError(err)
+	progress.finish()
+	m.finishAttempt(attemptID, result, err)
+	if err != nil && parent.Err() != nil {
+		// Interrupted by a shutdown rather than cancelled on request, so
+		// the next run picks the video up again
+		m.log.Info("Interrupted processing of video", "video", video.Filename)
+		if err := db.SetVideoPending(video.ID); err != nil {
+			m.log.Error("Error setting video as pending", "video", video.Filename, "err", err)
+		}
+		m.logStatusChange(video.ID, database.StatusPending, "interrupted")
+		return false
+	}
+	if err != nil {
+		m.log.Error("Error processing video", "video", video.Filename, "err", err)
+		m.setVideoError(video, err, result.StderrTail())
+		return false
+	}
 	
 	// Update status to ready
-	if err := m.db.SetVideoReady(video.ID, duration); err != nil {
-		log.Printf("Error setting video as ready: %v", err)
+	_, stage = telemetry.Start(ctx, "mark ready")
+	if len(repair) > 0 {
+		err = m.markRepaired(video.ID, probe.Duration, result)
+	} else {
+		err = m.markReady(video.ID, probe.Duration, result, database.ActorLibrarian)
+	}
+	stage.SetError(err)
+	stage.End()
+	if err != nil {
+		span.SetError(err)
+		m.log.Error("Error setting video as ready", "video", video.Filename, "err", err)
+		return false
+	}
+	
+	m.log.Info("Video processed successfully", "video", video.Filename, "output", result.MasterPath)
+	m.notify().Notify(notify.Event{
+		Type:     notify.EventReady,
+		VideoID:  video.ID,
+		Filename: video.Filename,
+		Path:     video.Path,
+		Duration: probe.Duration,
+	})
+	return true
+}
+
+// markReady makes a video ready to stream from the output of a successful
+// transcode
+func (m *Manager) markReady(videoID int64, duration float64, result *transcoder.PrepareResult, actor string) error {
+	cacheDir := m.tm.RelativeToCache(result.OutputDir)
+	masterPlaylist := m.tm.RelativeToCache(result.MasterPath)
+	if err := m.db.SetVideoReady(videoID, duration, cacheDir, masterPlaylist); err != nil {
+		return err
+	}
+	m.logEvent(database.EventStatusChange, videoID, actor, "status changed to "+string(database.StatusReady))
+	m.recordCacheEntries(videoID, result.Variants)
+	if err := m.db.SetDroppedVariants(videoID, result.Dropped); err != nil {
+		m.log.Error("Error recording dropped variants", "id", videoID, "err", err)
+	}
+	if len(result.Dropped) > 0 {
+		m.logEvent(database.EventCacheCleanup, videoID, actor,
+			"dropped "+strings.Join(result.Dropped, ", ")+" to fit cache.max_video_size_gb")
+	}
+	
+	// Artwork taken from the previous source is extracted again on request
+	if err := os.Remove(filepath.Join(result.OutputDir, transcoder.ArtworkFile)); err != nil && !os.IsNotExist(err) {
+		m.log.Error("Error removing stale artwork", "err", err)
+	}
+	
+	// Segments left on the disk are still served from there
+	if err := m.store.Offload(m.ctx, cacheDir+"/"); err != nil {
+		m.log.Error("Error offloading segments", "dir", cacheDir, "err", err)
+	}
+	return nil
+}
+
+// markRepaired makes a video ready to stream again after RepairVariants
+// replaced some of its variants, updating only their inventory
+func (m *Manager) markRepaired(videoID int64, duration float64, result *transcoder.PrepareResult) error {
+	cacheDir := m.tm.RelativeToCache(result.OutputDir)
+	masterPlaylist := m.tm.RelativeToCache(result.MasterPath)
+	if err := m.db.SetVideoReady(videoID, duration, cacheDir, masterPlaylist); err != nil {
+		return err
+	}
+	
+	var names []string
+	for _, v := range result.Variants {
+		names = append(names, v.Name)
+		playlist := m.tm.RelativeToCache(v.Playlist)
+		if err := m.db.UpsertCacheEntry(videoID, v.Name, playlist, v.SizeBytes, v.Checksum); err != nil {
+			m.log.Error("Error recording cache entry", "id", videoID, "variant", v.Name, "err", err)
+		}
+		if err := m.store.Offload(m.ctx, strings.TrimSuffix(playlist, ".m3u8")); err != nil {
+			m.log.Error("Error offloading segments", "playlist", playlist, "err", err)
+		}
+	}
+	m.logEvent(database.EventStatusChange, videoID, database.ActorLibrarian,
+		"status changed to "+string(database.StatusReady)+", repaired "+strings.Join(names, ", "))
+	return nil
+}
+
+// RecordTranscode stores the output of a transcode made outside the
+// processing queue, such as by the transcode command, so the video becomes
+// ready without being processed again. Files in a media directory that the
+// library doesn't know yet are added.
+func (m *Manager) RecordTranscode(path string, probe *transcoder.ProbeResult, result *transcoder.PrepareResult, actor string) (int64, error) {
+	if _, ok := m.settings().LibraryFor(path); !ok {
+		return 0, fmt.Errorf("%s is not inside a media directory", path)
+	}
+	
+	video, err := m.db.GetVideoByPath(path)
+	if err != nil {
+		return 0, err
+	}
+	var id int64
+	if video != nil {
+		id = video.ID
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return 0, err
+		}
+		if id, err = m.db.AddVideo(filepath.Base(path), path, info.Size()); err != nil {
+			return 0, err
+		}
+		m.logEvent(database.EventScan, id, actor, "added by transcode: "+path)
+	}
+	
+	if err := m.db.SetVideoMetadata(id, probe.Duration, metadataFromProbe(probe)); err != nil {
+		return 0, err
+	}
+	if err := m.db.SetVideoTracks(id, chaptersFromProbe(probe), subtitlesFromProbe(probe)); err != nil {
+		return 0, err
+	}
+	if err := m.markReady(id, probe.Duration, result, actor); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// recordCacheEntries replaces the cache inventory of a video with the
+// variants produced by a successful transcode
+func (m *Manager) recordCacheEntries(videoID int64, variants []transcoder.Variant) {
+	if err := m.db.DeleteCacheEntries(videoID); err != nil {
+		m.log.Error("Error clearing cache inventory", "id", videoID, "err", err)
+		return
+	}
+	
+	for _, v := range variants {
+		playlist := m.tm.RelativeToCache(v.Playlist)
+		if err := m.db.UpsertCacheEntry(videoID, v.Name, playlist, v.SizeBytes, v.Checksum); err != nil {
+			m.log.Error("Error recording cache entry", "id", videoID, "variant", v.Name, "err", err)
+		}
+	}
+}
+
+// removeCache deletes the cached output of a video and its inventory
+func (m *Manager) removeCache(video *database.Video) {
+	dir := m.tm.CacheDirFor(video.CacheDir, video.Path)
+	if err := os.RemoveAll(dir); err != nil {
+		m.log.Error("Error removing cache", "video", video.Filename, "err", err)
+	}
+	if _, err := m.store.Remove(m.ctx, m.tm.RelativeToCache(dir)); err != nil {
+		m.log.Error("Error removing cache", "video", video.Filename, "err", err)
+	}
+	if err := m.db.DeleteCacheEntries(video.ID); err != nil {
+		m.log.Error("Error clearing cache inventory", "video", video.Filename, "err", err)
+	}
+}
+
+// setVideoError marks a video as failed, classifies the failure and
+// schedules a retry when it is worth one. Videos that failed for lack of
+// disk space wait until there is enough instead.
+func (m *Manager) setVideoError(video *database.Video, err error, output string) {
+	class := classifyFailure(err, output)
+	retry := m.nextRetry(video, class)
+	var dberr error
+	if class == database.FailureNoSpace {
+		dberr = m.db.SetVideoWaitingForSpace(video.ID, err.Error())
+	} else {
+		dberr = m.db.SetVideoFailed(video.ID, err.Error(), class, retry)
+	}
+	if dberr != nil {
+		m.log.Error("Error setting video as failed", "video", video.Filename, "err", dberr)
+		return
+	}
+	
+	detail := fmt.Sprintf("%s (%s failure", err.Error(), class)
+	if class == database.FailureNoSpace {
+		detail = err.Error() + " (waiting for free space)"
+	} else if retry.Valid {
+		detail += ", retry at " + retry.Time.Local().Format(time.RFC3339) + ")"
+	} else {
+		detail += ", giving up)"
+	}
+	m.logStatusChange(video.ID, database.StatusError, detail)
+	
+	e := notify.Event{
+		Type:         notify.EventFailed,
+		VideoID:      video.ID,
+		Filename:     video.Filename,
+		Path:         video.Path,
+		Error:        err.Error(),
+		FailureClass: string(class),
+	}
+	if retry.Valid {
+		e.NextRetryAt = &retry.Time
+	}
+	m.notify().Notify(e)
+}
+
+// logStatusChange records a status transition made by the librarian
+func (m *Manager) logStatusChange(id int64, status database.VideoStatus, detail string) {
+	msg := "status changed to " + string(status)
+	if detail != "" {
+		msg += ": " + detail
+	}
+	m.logEvent(database.EventStatusChange, id, database.ActorLibrarian, msg)
+}
+
+// LogEvent appends an event that isn't about a video to the audit log,
+// logging any failure
+func (m *Manager) LogEvent(eventType database.EventType, actor, msg string) {
+	m.logEvent(eventType, 0, actor, msg)
+}
+
+// logEvent appends an event to the audit log, logging any failure
+func (m *Manager) logEvent(eventType database.EventType, videoID int64, actor, msg string) {
+	if err := m.db.LogEvent(eventType, videoID, actor, msg); err != nil {
+		m.log.Error("Error recording event", "type", eventType, "err", err)
+	}
+}
+
+// finishAttempt records the outcome of a processing attempt
+func (m *Manager) finishAttempt(attemptID int64, result *transcoder.PrepareResult, err error) {
+	if attemptID == 0 {
 		return
 	}
 	
-	log.Printf("Video processed successfully: %s, output at: %s", video.Filename, masterPath)
+	errorMsg := ""
+	if err != nil {
+		errorMsg = err.Error()
+	}
+	if ferr := m.db.FinishAttempt(attemptID, result.Commands(), result.StderrTail(), errorMsg); ferr != nil {
+		m.log.Error("Error recording processing attempt", "attempt", attemptID, "err", ferr)
+	}
+}
+
+// metadataFromProbe converts probe output into database metadata
+func metadataFromProbe(probe *transcoder.ProbeResult) database.Metadata {
+	return database.Metadata{
+		Container:     probe.Container,
+		VideoCodec:    probe.VideoCodec,
+		Width:         probe.Width,
+		Height:        probe.Height,
+		FrameRate:     probe.FrameRate,
+		BitDepth:      probe.BitDepth,
+		HDR:           probe.HDR,
+		AudioCodec:    probe.AudioCodec,
+		AudioChannels: probe.AudioChannels,
+		CoverArt:      probe.CoverArt,
+	}
+}
+
+// chaptersFromProbe converts the chapters found by the probe step
+func chaptersFromProbe(probe *transcoder.ProbeResult) []database.Chapter {
+	chapters := make([]database.Chapter, len(probe.Chapters))
+	for i, c := range probe.Chapters {
+		chapters[i] = database.Chapter{Start: c.Start, End: c.End, Title: c.Title}
+	}
+	return chapters
+}
+
+// subtitlesFromProbe converts the subtitle streams found by the probe step
+func subtitlesFromProbe(probe *transcoder.ProbeResult) []database.SubtitleTrack {
+	tracks := make([]database.SubtitleTrack, len(probe.Subtitles))
+	for i, s := range probe.Subtitles {
+		tracks[i] = database.SubtitleTrack{
+			StreamIndex: s.Index,
+			Codec:       s.Codec,
+			Language:    s.Language,
+			Title:       s.Title,
+			Default:     s.Default,
+			Forced:      s.Forced,
+		}
+	}
+	return tracks
 }
 
 // StartWatching starts watching the media directory for changes
@@ -181,9 +760,15 @@ func (m *Manager) StartWatching() error {
 	m.watcher = watcher
 	m.isWatching = true
 	
-	// Add the media directory to the watcher
-	if err := watcher.Add(m.config.Media.MediaDir); err != nil {
-		return fmt.Errorf("failed to watch media directory: %w", err)
+	// Add the media directories to the watcher
+	// Remote libraries are only picked up by the periodic scan
+	for _, lib := range m.settings().MediaLibraries() {
+		if lib.Remote() {
+			continue
+		}
+		if err := watcher.Add(lib.MediaDir); err != nil {
+			return fmt.Errorf("failed to watch media directory: %w", err)
+		}
 	}
 	
 	// Start the watcher goroutine
@@ -198,14 +783,14 @@ func (m *Manager) StartWatching() error {
 				if event.Op&(fsnotify.Create|fsnotify.Write) != 0 {
 					// Check if it's a video file
 					ext := strings.ToLower(filepath.Ext(event.Name))
-					if !isVideoFile(ext) {
+					if !IsVideoFile(ext) {
 						continue
 					}
 					
 					// Get file info
 					info, err := os.Stat(event.Name)
 					if err != nil {
-						log.Printf("Error getting file info: %v", err)
+						m.log.Error("Error getting file info", "err", err)
 						continue
 					}
 					
@@ -217,7 +802,7 @@ func (m *Manager) StartWatching() error {
 					// Check if this video already exists in the database
 					exists, err := m.db.VideoExists(event.Name)
 					if err != nil {
-						log.Printf("Error checking video existence: %v", err)
+						m.log.Error("Error checking video existence", "path", event.Name, "err", err)
 						continue
 					}
 					
@@ -225,11 +810,13 @@ func (m *Manager) StartWatching() error {
 					if !exists {
 						id, err := m.db.AddVideo(filepath.Base(event.Name), event.Name, info.Size())
 						if err != nil {
-							log.Printf("Error adding video to database: %v", err)
+							m.log.Error("Error adding video to database", "path", event.Name, "err", err)
 							continue
 						}
 						
-						log.Printf("Added new video to library: %s (ID: %d)", info.Name(), id)
+						m.log.Info("Added new video to library", "video", info.Name(), "id", id)
+						m.logEvent(database.EventScan, id, database.ActorLibrarian,
+							"added by file watcher: "+event.Name)
 					}
 				}
 				
@@ -237,7 +824,7 @@ func (m *Manager) StartWatching() error {
 				if !ok {
 					return
 				}
-				log.Printf("Watcher error: %v", err)
+				m.log.Error("Watcher error", "err", err)
 				
 			case <-m.stopChan:
 				watcher.Close()
@@ -246,7 +833,11 @@ func (m *Manager) StartWatching() error {
 		}
 	}()
 	
-	log.Printf("Started watching media directory: %s", m.config.Media.MediaDir)
+	for _, lib := range m.settings().MediaLibraries() {
+		if !lib.Remote() {
+			m.log.Info("Started watching media directory", "dir", lib.MediaDir)
+		}
+	}
 	return nil
 }
 
@@ -259,37 +850,185 @@ func (m *Manager) StopWatching() {
 		return
 	}
 	
-	close(m.stopChan)
+	m.stopOnce.Do(func() { close(m.stopChan) })
 	m.isWatching = false
 	
-	log.Println("Stopped watching media directory")
+	m.log.Info("Stopped watching media directories")
 }
 
-// StartPeriodicScan starts periodic scanning
+// StartPeriodicScan starts periodic scanning, following changes of the
+// interval on reload
 func (m *Manager) StartPeriodicScan() {
-	interval := m.config.Library.ScanIntervalMinutes
-	if interval <= 0 {
-		log.Println("Periodic scanning disabled")
-		return
-	}
-	
-	log.Printf("Starting periodic library scan every %d minutes", interval)
-	
 	go func() {
-		ticker := time.NewTicker(time.Duration(interval) * time.Minute)
-		defer ticker.Stop()
+		var ticker *time.Ticker
+		var tick <-chan time.Time
+		interval := 0
+		
+		// reset restarts the ticker when the interval changed
+		reset := func() {
+			next := m.settings().Library.ScanIntervalMinutes
+			if ticker != nil && next == interval {
+				return
+			}
+			interval = next
+			if ticker != nil {
+				ticker.Stop()
+				ticker, tick = nil, nil
+			}
+			if interval <= 0 {
+				m.log.Info("Periodic scanning disabled")
+				return
+			}
+			m.log.Info("Starting periodic library scan", "interval_minutes", interval)
+			ticker = time.NewTicker(time.Duration(interval) * time.Minute)
+			tick = ticker.C
+		}
+		reset()
+		defer func() {
+			if ticker != nil {
+				ticker.Stop()
+			}
+		}()
 		
 		for {
 			select {
-			case <-ticker.C:
+			case <-tick:
 				if err := m.ScanLibrary(); err != nil {
-					log.Printf("Error scanning library: %v", err)
+					m.log.Error("Error scanning library", "err", err)
 				}
 				
 				if err := m.ProcessPendingVideos(); err != nil {
-					log.Printf("Error processing pending videos: %v", err)
+					m.log.Error("Error processing pending videos", "err", err)
 				}
 				
+			case <-m.reloadCh:
+				reset()
+				
+			case <-m.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// RemoveVideo moves a video to the trash and removes its cached output.
+// The metadata stays recoverable until the trash is purged. The actor is
+// recorded in the audit log.
+func (m *Manager) RemoveVideo(id int64, actor string) error {
+	video, err := m.db.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	
+	if err := m.db.SoftDeleteVideo(id); err != nil {
+		return err
+	}
+	
+	m.removeCache(video)
+	
+	m.log.Info("Moved video to trash", "video", video.Filename, "id", id)
+	m.logEvent(database.EventDelete, id, actor, "moved to trash: "+video.Path)
+	return nil
+}
+
+// DeleteVideo permanently removes a video, its cached output and its
+// history. With deleteSource the source file is deleted as well, otherwise
+// it is left on disk and the next scan adds it again. The actor is recorded
+// in the audit log.
+func (m *Manager) DeleteVideo(id int64, actor string, deleteSource bool) error {
+	video, err := m.db.GetVideo(id)
+	if err != nil {
+		return err
+	}
+	
+	if deleteSource {
+		if err := m.removeSource(video); err != nil {
+			return err
+		}
+	}
+	
+	m.removeCache(video)
+	
+	if err := m.db.DeleteVideo(id); err != nil {
+		return err
+	}
+	
+	msg := "permanently deleted: " + video.Path
+	if deleteSource {
+		msg += " (source file removed)"
+	}
+	m.log.Info("Deleted video", "video", video.Filename, "id", id)
+	m.logEvent(database.EventDelete, id, actor, msg)
+	return nil
+}
+
+// removeSource deletes the source file of a video. Files outside the media
+// directories are never touched.
+func (m *Manager) removeSource(video *database.Video) error {
+	lib, ok := m.settings().LibraryFor(video.Path)
+	if !ok {
+		return fmt.Errorf("refusing to delete %s: not inside a media directory", video.Path)
+	}
+	if lib.Remote() {
+		return fmt.Errorf("refusing to delete %s: videos of %s sources are read-only", video.Path, lib.Source.Type)
+	}
+	
+	if err := os.Remove(video.Path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete source file: %w", err)
+	}
+	return nil
+}
+
+// RestoreVideo moves a video out of the trash and queues it for processing.
+// The actor is recorded in the audit log.
+func (m *Manager) RestoreVideo(id int64, actor string) error {
+	if err := m.db.RestoreVideo(id); err != nil {
+		return err
+	}
+	
+	m.log.Info("Restored video from trash", "id", id)
+	m.logEvent(database.EventRestore, id, actor, "restored from trash")
+	return nil
+}
+
+// PurgeTrash permanently removes videos that exceeded the trash retention
+func (m *Manager) PurgeTrash() error {
+	retention := time.Duration(m.settings().Library.TrashRetentionDays) * 24 * time.Hour
+	purged, err := m.db.PurgeDeletedVideos(retention)
+	if err != nil {
+		return err
+	}
+	
+	for _, video := range purged {
+		m.logEvent(database.EventPurge, video.ID, database.ActorLibrarian,
+			"purged from trash: "+video.Path)
+	}
+	if len(purged) > 0 {
+		m.log.Info("Purged videos from trash", "count", len(purged))
+	}
+	return nil
+}
+
+// StartTrashPurge starts a background job that purges the trash hourly,
+// unless the retention is disabled at the time
+func (m *Manager) StartTrashPurge() {
+	if m.settings().Library.TrashRetentionDays <= 0 {
+		m.log.Info("Trash purging disabled")
+	}
+	
+	go func() {
+		ticker := time.NewTicker(1 * time.Hour)
+		defer ticker.Stop()
+		
+		for {
+			if m.settings().Library.TrashRetentionDays > 0 {
+				if err := m.PurgeTrash(); err != nil {
+					m.log.Error("Error purging trash", "err", err)
+				}
+			}
+			
+			select {
+			case <-ticker.C:
 			case <-m.stopChan:
 				return
 			}
@@ -297,8 +1036,8 @@ func (m *Manager) StartPeriodicScan() {
 	}()
 }
 
-// isVideoFile checks if a file extension is a video format
-func isVideoFile(ext string) bool {
+// IsVideoFile checks if a lowercase file extension is a video format
+func IsVideoFile(ext string) bool {
 	videoExts := []string{".mp4", ".mkv", ".avi", ".mov", ".webm", ".flv", ".wmv"}
 	for _, e := range videoExts {
 		if ext == e {
@@ -308,8 +1047,17 @@ func isVideoFile(ext string) bool {
 	return false
 }
 
-// Close closes the library manager
+// Close stops the watcher and the background jobs, cancels the running
+// processing jobs, waits until their videos are requeued and closes the
+// connection to the shared queue
 func (m *Manager) Close() {
 	m.StopWatching()
-	// The stopChan is already closed in StopWatching()
+	m.stopOnce.Do(func() { close(m.stopChan) })
+
+	m.cancel()
+	m.processMu.Lock()
+	m.processMu.Unlock()
+	if m.queue != nil {
+		m.queue.Close()
+	}
 }
\ No newline at end of file
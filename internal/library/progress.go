@@ -0,0 +1,57 @@
+package library
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// progressInterval is how often the progress of a variant is stored while
+// it is transcoded
+const progressInterval = 2 * time.Second
+
+// progressRecorder stores the progress of a video's transcode in the
+// database, where the server reads it from
+type progressRecorder struct {
+	db      *database.DB
+	videoID int64
+	log     *slog.Logger
+
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// newProgressRecorder creates a recorder for a video, clearing progress
+// left behind by an earlier run
+func newProgressRecorder(db *database.DB, videoID int64, logger *slog.Logger) *progressRecorder {
+	if err := db.ClearProgress(videoID); err != nil {
+		logger.Error("Error clearing processing progress", "id", videoID, "err", err)
+	}
+	return &progressRecorder{db: db, videoID: videoID, log: logger, last: make(map[string]time.Time)}
+}
+
+// report stores a progress report, skipping reports that arrive within
+// progressInterval of the previous one of the same variant
+func (r *progressRecorder) report(p transcoder.Progress) {
+	r.mu.Lock()
+	if !p.Done && time.Since(r.last[p.Variant]) < progressInterval {
+		r.mu.Unlock()
+		return
+	}
+	r.last[p.Variant] = time.Now()
+	r.mu.Unlock()
+
+	if err := r.db.SetProgress(r.videoID, p.Variant, p.Position, p.Speed); err != nil {
+		r.log.Error("Error storing processing progress", "id", r.videoID, "err", err)
+	}
+}
+
+// finish clears the progress once processing ended
+func (r *progressRecorder) finish() {
+	if err := r.db.ClearProgress(r.videoID); err != nil {
+		r.log.Error("Error clearing processing progress", "id", r.videoID, "err", err)
+	}
+}
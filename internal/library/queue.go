@@ -0,0 +1,142 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/queue"
+)
+
+// processQueued shares the pending videos with the other librarians
+// through the queue, then processes the videos this librarian claims
+// until the queue is empty, ctx is cancelled or processing is paused.
+// Videos claimed by others aren't counted in the summary.
+func (m *Manager) processQueued(ctx context.Context, pending []*database.Video) (*ProcessSummary, error) {
+	ids := make([]int64, len(pending))
+	for i, v := range pending {
+		ids[i] = v.ID
+	}
+	added, err := m.queue.Enqueue(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if added > 0 {
+		m.log.Info("Queued pending videos", "count", added)
+	}
+
+	numWorkers := m.settings().Library.ProcessingThreads
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
+
+	summary := &ProcessSummary{}
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for ctx.Err() == nil && !m.paused.Load() {
+				id, ok, err := m.queue.Claim(ctx)
+				if err != nil {
+					mu.Lock()
+					if firstErr == nil && ctx.Err() == nil {
+						firstErr = err
+					}
+					mu.Unlock()
+					return
+				}
+				if !ok {
+					return
+				}
+
+				processed, ready := m.processClaimed(ctx, id)
+				if !processed {
+					continue
+				}
+				mu.Lock()
+				if ready {
+					summary.Ready++
+				} else {
+					summary.Failed++
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return summary, firstErr
+}
+
+// processClaimed processes a video claimed from the queue, extending the
+// claim while it runs, and removes it from the queue afterwards. Videos
+// that are gone or were finished by another librarian meanwhile are only
+// removed, reporting processed as false. A video whose claim is lost to
+// another librarian is cancelled and left to it.
+func (m *Manager) processClaimed(ctx context.Context, id int64) (processed, ready bool) {
+	// The job leaves the queue even on shutdown; it is pending again then
+	// and queued anew by the next run
+	defer func() {
+		if err := m.queue.Ack(context.WithoutCancel(ctx), id); err != nil {
+			m.log.Error("Error removing job from the queue", "id", id, "err", err)
+		}
+	}()
+
+	video, err := m.db.GetVideo(id)
+	if err != nil {
+		m.log.Warn("Skipping queued video that can't be loaded", "id", id, "err", err)
+		return false, false
+	}
+	// Videos left processing by a librarian whose claim expired are taken
+	// over, the others were dealt with already
+	if video.Status != database.StatusPending && video.Status != database.StatusProcessing {
+		m.log.Debug("Skipping queued video that was processed meanwhile", "id", id, "status", video.Status)
+		return false, false
+	}
+
+	jobCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		m.heartbeat(jobCtx, id, cancel, stop)
+	}()
+
+	ready = m.processVideo(jobCtx, video)
+	close(stop)
+	wg.Wait()
+	return true, ready
+}
+
+// heartbeat extends the claim of a running job until stop is closed,
+// calling cancel when another librarian took the job over
+func (m *Manager) heartbeat(ctx context.Context, id int64, cancel func(), stop <-chan struct{}) {
+	ticker := time.NewTicker(m.queue.HeartbeatInterval())
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			err := m.queue.Heartbeat(ctx, id)
+			if errors.Is(err, queue.ErrLeaseLost) {
+				m.log.Warn("Lost the claim of a job to another librarian, cancelling it", "id", id)
+				cancel()
+				return
+			}
+			if err != nil {
+				// Redis may be back before the visibility timeout ends
+				m.log.Error("Error extending the claim of a job", "id", id, "err", err)
+			}
+		case <-stop:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// permanentFailurePatterns are error and ffmpeg output fragments that mean
+// the source itself is unusable, so retrying won't help
+var permanentFailurePatterns = []string{
+	"invalid data found when processing input",
+	"moov atom not found",
+	"no video stream found",
+	"does not contain any stream",
+	"could not find codec parameters",
+	"unsupported codec",
+	"decoder not found",
+	"no such file or directory",
+	"permission denied",
+}
+
+// classifyFailure decides whether a processing failure is worth retrying
+// from the error and the ffmpeg output that came with it
+func classifyFailure(err error, output string) database.FailureClass {
+	// Cancelled jobs were stopped on purpose and only rerun on request
+	if errors.Is(err, context.Canceled) {
+		return database.FailurePermanent
+	}
+
+	text := strings.ToLower(err.Error() + "\n" + output)
+	// A full disk fails ffmpeg halfway through, the guard before
+	// transcoding only estimates the output
+	if errors.Is(err, ErrCacheDiskFull) || strings.Contains(text, "no space left on device") {
+		return database.FailureNoSpace
+	}
+	for _, pattern := range permanentFailurePatterns {
+		if strings.Contains(text, pattern) {
+			return database.FailurePermanent
+		}
+	}
+	return database.FailureTransient
+}
+
+// nextRetry schedules the retry after a failure, doubling the configured
+// backoff with every previous attempt. No retry is scheduled for permanent
+// failures or once the retry budget is spent, nor for videos waiting for
+// free space, which requeueWaitingForSpace takes care of.
+func (m *Manager) nextRetry(video *database.Video, class database.FailureClass) sql.NullTime {
+	maxRetries := m.settings().Library.MaxRetries
+	// RetryCount doesn't include the failure being recorded yet
+	if class == database.FailurePermanent || class == database.FailureNoSpace || video.RetryCount+1 > maxRetries {
+		return sql.NullTime{}
+	}
+
+	backoff := time.Duration(m.settings().Library.RetryBackoffMinutes) * time.Minute
+	backoff <<= min(video.RetryCount, 10)
+	return sql.NullTime{Time: time.Now().UTC().Add(backoff), Valid: true}
+}
@@ -0,0 +1,202 @@
+package library
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// VerifyOptions select the videos VerifyCache checks and how
+type VerifyOptions struct {
+	// VideoID limits the check to a single video when set
+	VideoID int64
+	// Quick skips comparing checksums, which reads every segment
+	Quick bool
+	// Repair queues the broken variants to be transcoded again
+	Repair bool
+}
+
+// BrokenVariant is a variant whose output VerifyCache found damaged
+type BrokenVariant struct {
+	Video   *database.Video
+	Variant string // e.g. "720p"
+	Problem string
+	// whole is set when replacing the variant alone won't fix it
+	whole bool
+}
+
+// VerifyResult describes the outcome of VerifyCache
+type VerifyResult struct {
+	// Checked counts the variants checked
+	Checked int
+	Broken  []BrokenVariant
+	// Requeued are the videos queued to repair their broken variants
+	Requeued []*database.Video
+}
+
+// VerifyCache cross-checks the output of ready videos with the cache
+// inventory: the master playlist lists every recorded variant, every
+// variant playlist is complete and its segments exist, and unless
+// opts.Quick the segments still match the checksum recorded when they were
+// transcoded. Videos whose master playlist is missing are left to Fsck.
+// With opts.Repair only the broken variants are queued to be transcoded
+// again, or the whole video when that won't do. The actor is recorded in
+// the audit log.
+func (m *Manager) VerifyCache(opts VerifyOptions, actor string) (*VerifyResult, error) {
+	var videos []*database.Video
+	if opts.VideoID != 0 {
+		video, err := m.db.GetVideo(opts.VideoID)
+		if err != nil {
+			return nil, err
+		}
+		videos = []*database.Video{video}
+	} else {
+		var err error
+		if videos, err = m.db.ListAllVideos(); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &VerifyResult{Broken: []BrokenVariant{}, Requeued: []*database.Video{}}
+	for _, video := range videos {
+		if video.Status != database.StatusReady {
+			continue
+		}
+		// Evicted and purged output has no inventory
+		entries, err := m.db.ListCacheEntries(video.ID)
+		if err != nil {
+			return result, err
+		}
+		if len(entries) == 0 {
+			continue
+		}
+
+		broken, err := m.verifyVideo(video, entries, opts.Quick)
+		if err != nil {
+			return result, err
+		}
+		result.Checked += len(entries)
+		result.Broken = append(result.Broken, broken...)
+		if !opts.Repair || len(broken) == 0 {
+			continue
+		}
+		if err := m.queueRepair(video, broken, actor); err != nil {
+			return result, err
+		}
+		result.Requeued = append(result.Requeued, video)
+	}
+
+	if len(result.Broken) > 0 {
+		m.log.Warn("Cache verify found broken variants", "broken", len(result.Broken), "requeued", len(result.Requeued))
+	}
+	return result, nil
+}
+
+// verifyVideo checks the variants of a video recorded in its inventory
+func (m *Manager) verifyVideo(video *database.Video, entries []*database.CacheEntry, quick bool) ([]BrokenVariant, error) {
+	cacheDir := m.settings().Media.CacheDir
+	master := filepath.Join(cacheDir, filepath.FromSlash(m.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path)))
+	if _, err := os.Stat(master); os.IsNotExist(err) {
+		return nil, nil
+	}
+	uris, err := transcoder.PlaylistURIs(master)
+	if err != nil {
+		return []BrokenVariant{{Video: video, Variant: "master", Problem: err.Error(), whole: true}}, nil
+	}
+	listed := make(map[string]bool)
+	for _, uri := range uris {
+		listed[filepath.Join(filepath.Dir(master), filepath.FromSlash(uri))] = true
+	}
+
+	var broken []BrokenVariant
+	for _, e := range entries {
+		playlist := filepath.Join(cacheDir, filepath.FromSlash(e.Playlist))
+		if !listed[playlist] {
+			broken = append(broken, BrokenVariant{Video: video, Variant: e.Variant,
+				Problem: "not listed in the master playlist", whole: true})
+			continue
+		}
+		if problem := m.verifyVariant(playlist, e.Checksum, quick); problem != "" {
+			broken = append(broken, BrokenVariant{Video: video, Variant: e.Variant, Problem: problem})
+		}
+	}
+	return broken, nil
+}
+
+// verifyVariant returns what is wrong with the output of a variant, or ""
+// when nothing is
+func (m *Manager) verifyVariant(playlist, checksum string, quick bool) string {
+	segments, err := transcoder.VerifyVariant(playlist)
+	if err != nil {
+		return err.Error()
+	}
+	// Segments in object storage can't be checked without downloading them
+	if _, err := os.Stat(segments[0]); os.IsNotExist(err) && m.store.Holds(m.tm.RelativeToCache(segments[0])) {
+		return ""
+	}
+	if err := transcoder.VerifySegments(segments); err != nil {
+		return err.Error()
+	}
+
+	if quick || checksum == "" {
+		return ""
+	}
+	sum, err := transcoder.VariantChecksum(playlist)
+	if err != nil {
+		return err.Error()
+	}
+	if sum != checksum {
+		return "segments don't match their checksum"
+	}
+	return ""
+}
+
+// queueRepair puts a video with broken variants back into the processing
+// queue to transcode those variants again. The whole video is transcoded
+// again when its master playlist is damaged or a variant isn't in its
+// transcode profile anymore.
+func (m *Manager) queueRepair(video *database.Video, broken []BrokenVariant, actor string) error {
+	profile := m.tm.ProfileFor(video.Path)
+	var names []string
+	whole := false
+	for _, b := range broken {
+		names = append(names, b.Variant)
+		inProfile := slices.ContainsFunc(profile.Variants, func(q config.QualityVariant) bool {
+			return q.Name() == b.Variant
+		})
+		whole = whole || b.whole || !inProfile
+	}
+
+	if whole {
+		if err := m.db.SetVideoPending(video.ID); err != nil {
+			return err
+		}
+		m.logEvent(database.EventStatusChange, video.ID, actor,
+			"queued for reprocessing, cache verify found "+strings.Join(names, ", ")+" broken")
+		return nil
+	}
+	if err := m.db.SetVideoRepair(video.ID, names); err != nil {
+		return err
+	}
+	m.logEvent(database.EventStatusChange, video.ID, actor, "queued to repair "+strings.Join(names, ", "))
+	return nil
+}
+
+// repairVariants returns the variants of a pending video queued for
+// repair, or nil when the whole video is to be transcoded, as it is when
+// its output is gone
+func (m *Manager) repairVariants(video *database.Video) []string {
+	if video.RepairVariants == "" {
+		return nil
+	}
+	master := filepath.Join(m.settings().Media.CacheDir, m.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path))
+	if _, err := os.Stat(master); err != nil {
+		return nil
+	}
+	return strings.Split(video.RepairVariants, ",")
+}
@@ -0,0 +1,68 @@
+package library
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// warmVideos returns the IDs of the videos kept warm: the warm_newest most
+// recently added videos of each library, counting only those with one of
+// its warm_tags when set
+func (m *Manager) warmVideos() (map[int64]bool, error) {
+	warm := make(map[int64]bool)
+	for _, lib := range m.settings().MediaLibraries() {
+		if lib.WarmNewest <= 0 {
+			continue
+		}
+		page, err := m.db.ListVideos(database.ListOptions{
+			Sort:      database.SortAdded,
+			Desc:      true,
+			Limit:     lib.WarmNewest,
+			AnyTags:   lib.WarmTags,
+			Dirs:      []string{lib.MediaDir},
+			SkipTotal: true,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to list videos to keep warm: %w", err)
+		}
+		for _, video := range page.Videos {
+			warm[video.ID] = true
+		}
+	}
+	return warm, nil
+}
+
+// requeueWarm puts the ready videos kept warm whose output is gone, such
+// as evicted before they were kept warm, back into the processing queue
+// and returns how many it requeued
+func (m *Manager) requeueWarm() (int, error) {
+	warm, err := m.warmVideos()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for id := range warm {
+		video, err := m.db.GetVideo(id)
+		if err != nil {
+			return requeued, err
+		}
+		if video.Status != database.StatusReady {
+			continue
+		}
+		master := filepath.Join(m.settings().Media.CacheDir, m.tm.MasterPlaylistFor(video.MasterPlaylist, video.Path))
+		if _, err := os.Stat(master); !os.IsNotExist(err) {
+			continue
+		}
+
+		if err := m.db.SetVideoPending(id); err != nil {
+			return requeued, err
+		}
+		m.logEvent(database.EventStatusChange, id, database.ActorLibrarian, "queued to keep the cache warm")
+		requeued++
+	}
+	return requeued, nil
+}
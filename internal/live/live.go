@@ -0,0 +1,293 @@
+// Package live runs the live channels of the server: for every channel an
+// FFmpeg process waits for an encoder such as OBS to push a stream with
+// RTMP or SRT, and writes it as live HLS to a directory of its own below
+// the cache. When the stream ends the channel waits for the next one.
+//
+// Playlist channels instead play processed library videos around the
+// clock, one after another, from the HLS output already in the cache.
+package live
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// retryDelay is how long a channel waits before listening again when
+// FFmpeg exits without having received a stream, so a broken setup, such
+// as a port in use, doesn't spin
+const retryDelay = 10 * time.Second
+
+// Status describes a live channel
+type Status struct {
+	Name string `json:"name"`
+	// Type is "push" for channels streamed to the server and "playlist"
+	// for channels playing library videos
+	Type string `json:"type"`
+	// Protocol is "rtmp" or "srt", for push channels
+	Protocol string `json:"protocol,omitempty"`
+	// Live reports whether a stream is being received and can be played,
+	// or for playlist channels whether there are videos to play
+	Live bool `json:"live"`
+	// Playing is the title of the video a playlist channel is playing
+	Playing string `json:"playing,omitempty"`
+	// Since is when the stream started, when live
+	Since *time.Time `json:"since,omitempty"`
+	// Variants are the qualities the stream is transcoded to, none when it
+	// is segmented as it is pushed
+	Variants []string `json:"variants"`
+	// WebRTC reports whether the stream can also be played over WebRTC
+	// with WHEP, at /live/<name>/whep
+	WebRTC bool `json:"webrtc"`
+}
+
+// Manager runs the live channels
+type Manager struct {
+	cfg *config.Config
+	db  *database.DB
+	tm  *transcoder.Manager
+	log *slog.Logger
+
+	mu       sync.Mutex
+	channels map[string]*channel
+	// sessions are the WebRTC viewers by ID, guarded by mu
+	sessions map[string]*session
+
+	// webrtc serves the WebRTC viewers of all channels, listening on
+	// udpMux when all of them share a port
+	webrtc     *webrtc.API
+	udpMux     *ice.MultiUDPMuxDefault
+	iceServers []webrtc.ICEServer
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// channel is a configured live channel and the stream it is receiving
+type channel struct {
+	config.LiveChannel
+	dir string
+	// profile is the transcode profile of the channel, nil when the
+	// stream is segmented as it is pushed
+	profile *config.TranscodeProfile
+	// since is when the current stream started, zero while waiting
+	since time.Time
+	// relay sends the stream to WebRTC viewers, nil when the channel
+	// isn't offered over WebRTC
+	relay *relay
+
+	// build guards lineup, the videos a playlist channel plays
+	build  sync.Mutex
+	lineup *lineup
+}
+
+// New creates the manager of the live channels of cfg, failing when the
+// ports of WebRTC viewers can't be listened on
+func New(cfg *config.Config, db *database.DB, tm *transcoder.Manager, logger *slog.Logger) (*Manager, error) {
+	m := &Manager{
+		cfg:        cfg,
+		db:         db,
+		tm:         tm,
+		log:        logger,
+		channels:   make(map[string]*channel),
+		sessions:   make(map[string]*session),
+		iceServers: iceServers(cfg.Live.WebRTC),
+	}
+	for _, c := range cfg.Live.Channels {
+		ch := &channel{
+			LiveChannel: c,
+			dir:         filepath.Join(cfg.Media.LiveDirPath(), c.Name),
+		}
+		if c.Profile != "" {
+			// Loading the configuration checked that the profile exists
+			profile, _ := cfg.Profile(c.Profile)
+			ch.profile = &profile
+		}
+		m.channels[c.Name] = ch
+		if c.WebRTC == "" {
+			continue
+		}
+
+		if m.webrtc == nil {
+			var err error
+			if m.webrtc, m.udpMux, err = newWebRTC(cfg.Live.WebRTC); err != nil {
+				m.close()
+				return nil, err
+			}
+		}
+		var err error
+		if ch.relay, err = newRelay(c.Name, c.WebRTC != "video"); err != nil {
+			m.close()
+			return nil, fmt.Errorf("live channel %q: %w", c.Name, err)
+		}
+	}
+	return m, nil
+}
+
+// Start starts waiting for the streams of all push channels
+func (m *Manager) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	for _, ch := range m.channels {
+		if ch.Playlist() {
+			continue
+		}
+		m.wg.Add(1)
+		go m.run(ctx, ch)
+		m.log.Info("Waiting for live stream", "channel", ch.Name, "protocol", ch.Protocol())
+	}
+}
+
+// Stop ends the streams being received and the WebRTC sessions, and
+// removes their output. Stopping a nil manager does nothing.
+func (m *Manager) Stop() {
+	if m == nil {
+		return
+	}
+	if m.cancel != nil {
+		m.cancel()
+		m.wg.Wait()
+		os.RemoveAll(m.cfg.Media.LiveDirPath())
+	}
+	m.endSessions("")
+	m.close()
+}
+
+// close stops listening for WebRTC traffic
+func (m *Manager) close() {
+	for _, ch := range m.channels {
+		if ch.relay != nil {
+			ch.relay.close()
+		}
+	}
+	if m.udpMux != nil {
+		m.udpMux.Close()
+	}
+}
+
+// run receives the streams of a channel one after another until ctx is
+// cancelled
+func (m *Manager) run(ctx context.Context, ch *channel) {
+	defer m.wg.Done()
+	var rtp *transcoder.LiveRTP
+	if ch.relay != nil {
+		rtp = ch.relay.ports()
+	}
+	for ctx.Err() == nil {
+		// Players of the previous stream mustn't get segments of the next
+		if err := os.RemoveAll(ch.dir); err != nil {
+			m.log.Error("Error removing live output", "channel", ch.Name, "err", err)
+		}
+
+		job := transcoder.LiveJob{
+			Channel:         ch.Name,
+			Listen:          ch.Listen,
+			OutputDir:       ch.dir,
+			Profile:         ch.profile,
+			SegmentDuration: m.cfg.Live.SegmentDuration,
+			PlaylistEntries: m.cfg.Live.PlaylistEntries,
+			RTP:             rtp,
+			Progress: func(p transcoder.Progress) {
+				if p.Frame > 0 || p.Position > 0 {
+					m.setLive(ch, true)
+				}
+			},
+		}
+		result, err := m.tm.RunLive(ctx, job)
+		received := m.setLive(ch, false)
+		// WebRTC viewers reconnect to the next stream
+		m.endSessions(ch.Name)
+		if ctx.Err() != nil {
+			return
+		}
+		switch {
+		case err != nil:
+			m.log.Warn("Live stream failed", "channel", ch.Name, "err", err, "output", result.StderrTail)
+		case !received.IsZero():
+			m.log.Info("Live stream ended", "channel", ch.Name, "duration", time.Since(received).Round(time.Second).String())
+		}
+		if received.IsZero() {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retryDelay):
+			}
+		}
+	}
+}
+
+// setLive marks the stream of a channel as received or ended, returning
+// when it started
+func (m *Manager) setLive(ch *channel, live bool) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	since := ch.since
+	switch {
+	case live && since.IsZero():
+		ch.since = time.Now()
+		m.log.Info("Live stream started", "channel", ch.Name)
+	case !live:
+		ch.since = time.Time{}
+	}
+	return since
+}
+
+// Channels returns the status of every channel, sorted by name
+func (m *Manager) Channels() []Status {
+	var statuses []Status
+	for name := range m.channels {
+		status, _ := m.Channel(name)
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+// Channel returns the status of a channel, reporting false when there is
+// none of that name. A stream counts as live once its playlist is written.
+func (m *Manager) Channel(name string) (Status, bool) {
+	ch, ok := m.channels[name]
+	if !ok {
+		return Status{}, false
+	}
+	if ch.Playlist() {
+		return m.playlistStatus(ch), true
+	}
+	m.mu.Lock()
+	since := ch.since
+	m.mu.Unlock()
+
+	status := Status{Name: ch.Name, Type: "push", Protocol: ch.Protocol(), Variants: []string{}, WebRTC: ch.relay != nil}
+	if ch.profile != nil {
+		for _, q := range ch.profile.Variants {
+			status.Variants = append(status.Variants, q.Name())
+		}
+	}
+	if !since.IsZero() && transcoder.LivePlayable(ch.dir, ch.profile) {
+		status.Live = true
+		status.Since = &since
+	}
+	return status, true
+}
+
+// Dir returns the directory the output of a push channel is written to,
+// reporting false when there is no push channel of that name
+func (m *Manager) Dir(name string) (string, bool) {
+	ch, ok := m.channels[name]
+	if !ok || ch.Playlist() {
+		return "", false
+	}
+	return ch.dir, true
+}
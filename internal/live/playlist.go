@@ -0,0 +1,448 @@
+package live
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/rand/v2"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/internal/database"
+)
+
+// lineupTTL is how long the videos of a playlist channel are kept before
+// they are looked up again, so new videos join and deleted ones leave
+const lineupTTL = time.Minute
+
+// maxProgrammes caps the programmes a schedule lists
+const maxProgrammes = 1000
+
+// epoch is when every playlist channel started its first round. The
+// schedule follows from the clock alone, so all viewers see the same
+// video and restarts don't start over.
+var epoch = time.Unix(0, 0)
+
+// ErrOffAir is returned for playlist channels without a processed video to
+// play
+var ErrOffAir = errors.New("channel has no videos to play")
+
+// Programme is a video in the schedule of a playlist channel
+type Programme struct {
+	VideoID int64     `json:"video_id"`
+	Title   string    `json:"title"`
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+}
+
+// item is a video of a playlist channel with the segments of the variant
+// it is played in
+type item struct {
+	videoID int64
+	title   string
+	// playlist and modTime identify the variant playlist the segments were
+	// read from, relative to the cache root
+	playlist string
+	modTime  time.Time
+	// init is the init segment of fMP4 output, "" for MPEG-TS
+	init     string
+	segments []segment
+	duration float64
+}
+
+// segment is a segment of a video, its path relative to the cache root
+type segment struct {
+	path     string
+	duration float64
+}
+
+// lineup is what a playlist channel plays in a round
+type lineup struct {
+	items []*item
+	// duration and segments are the length of a round in seconds and
+	// segments
+	duration float64
+	segments int64
+	// targetDuration is the longest segment rounded up, which players
+	// expect to stay the same
+	targetDuration int
+	built          time.Time
+}
+
+// cursor points at a segment in the schedule of a playlist channel
+type cursor struct {
+	round int64
+	order []int
+	// index is the position of the item in the order of the round, and
+	// segment the position of the segment in the item
+	index   int
+	segment int
+	// sequence counts the segments played since the epoch
+	sequence int64
+	// offset is how far into the item the segment starts, in seconds
+	offset float64
+	// position is how far into the item the time located is, in seconds
+	position float64
+}
+
+// order returns the order the items play in during a round: by name, or
+// shuffled with the channel and round as the seed
+func (l *lineup) order(ch *channel, round int64) []int {
+	order := make([]int, len(l.items))
+	for i := range order {
+		order[i] = i
+	}
+	if ch.Shuffle {
+		h := fnv.New64a()
+		h.Write([]byte(ch.Name))
+		rng := rand.New(rand.NewPCG(h.Sum64(), uint64(round)))
+		rng.Shuffle(len(order), func(i, j int) { order[i], order[j] = order[j], order[i] })
+	}
+	return order
+}
+
+// item returns the item a cursor is in
+func (l *lineup) item(c *cursor) *item {
+	return l.items[c.order[c.index]]
+}
+
+// locate returns the cursor of the segment playing at t
+func (l *lineup) locate(ch *channel, t time.Time) *cursor {
+	elapsed := t.Sub(epoch).Seconds()
+	c := &cursor{round: int64(math.Floor(elapsed / l.duration))}
+	c.order = l.order(ch, c.round)
+	c.sequence = c.round * l.segments
+	offset := elapsed - float64(c.round)*l.duration
+
+	for c.index = range c.order {
+		it := l.item(c)
+		if offset < it.duration || c.index == len(c.order)-1 {
+			c.position = offset
+			for c.segment = 0; c.segment < len(it.segments)-1; c.segment++ {
+				if offset < c.offset+it.segments[c.segment].duration {
+					break
+				}
+				c.offset += it.segments[c.segment].duration
+			}
+			c.sequence += int64(c.segment)
+			return c
+		}
+		offset -= it.duration
+		c.sequence += int64(len(it.segments))
+	}
+	return c
+}
+
+// back moves a cursor to the previous segment
+func (l *lineup) back(ch *channel, c *cursor) {
+	c.sequence--
+	if c.segment > 0 {
+		c.segment--
+		c.offset -= l.item(c).segments[c.segment].duration
+		return
+	}
+	if c.index > 0 {
+		c.index--
+	} else {
+		c.round--
+		c.order = l.order(ch, c.round)
+		c.index = len(c.order) - 1
+	}
+	it := l.item(c)
+	c.segment = len(it.segments) - 1
+	c.offset = it.duration - it.segments[c.segment].duration
+}
+
+// forward moves a cursor to the next segment
+func (l *lineup) forward(ch *channel, c *cursor) {
+	c.sequence++
+	it := l.item(c)
+	if c.segment < len(it.segments)-1 {
+		c.offset += it.segments[c.segment].duration
+		c.segment++
+		return
+	}
+	if c.index < len(c.order)-1 {
+		c.index++
+	} else {
+		c.round++
+		c.order = l.order(ch, c.round)
+		c.index = 0
+	}
+	c.segment, c.offset = 0, 0
+}
+
+// discontinuity returns the discontinuity sequence number of a cursor,
+// which counts the videos started since the epoch
+func (l *lineup) discontinuity(c *cursor) int64 {
+	return c.round*int64(len(l.items)) + int64(c.index)
+}
+
+// playlistLineup returns what a playlist channel plays, looking the videos
+// up again once the lineup is older than lineupTTL. It fails with
+// ErrOffAir when there is nothing to play.
+func (m *Manager) playlistLineup(ch *channel) (*lineup, error) {
+	ch.build.Lock()
+	defer ch.build.Unlock()
+	if ch.lineup == nil || time.Since(ch.lineup.built) > lineupTTL {
+		l, err := m.buildLineup(ch)
+		if err != nil {
+			if ch.lineup == nil {
+				return nil, err
+			}
+			m.log.Error("Error looking up the videos of a live channel", "channel", ch.Name, "err", err)
+		} else {
+			ch.lineup = l
+		}
+	}
+	if len(ch.lineup.items) == 0 {
+		return nil, ErrOffAir
+	}
+	return ch.lineup, nil
+}
+
+// buildLineup looks up the processed videos a playlist channel plays, in
+// the order of their names. Videos whose segments can't be read, such as
+// those evicted from the cache, are left out.
+func (m *Manager) buildLineup(ch *channel) (*lineup, error) {
+	page, err := m.db.ListVideos(database.ListOptions{
+		Statuses:  []database.VideoStatus{database.StatusReady},
+		Tag:       ch.Tag,
+		Dirs:      m.playlistDirs(ch),
+		SkipTotal: true,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list videos: %w", err)
+	}
+
+	l := &lineup{built: time.Now()}
+	previous := make(map[int64]*item)
+	if ch.lineup != nil {
+		for _, it := range ch.lineup.items {
+			previous[it.videoID] = it
+		}
+	}
+	for _, v := range page.Videos {
+		it, err := m.lineupItem(ch, v, previous[v.ID])
+		if err != nil {
+			m.log.Debug("Leaving video out of live channel", "channel", ch.Name, "video", v.Path, "err", err)
+			continue
+		}
+		l.items = append(l.items, it)
+		l.duration += it.duration
+		l.segments += int64(len(it.segments))
+		for _, s := range it.segments {
+			l.targetDuration = max(l.targetDuration, int(math.Ceil(s.duration)))
+		}
+	}
+	return l, nil
+}
+
+// playlistDirs returns the directories a playlist channel plays the videos
+// of, nil for all of them
+func (m *Manager) playlistDirs(ch *channel) []string {
+	if ch.Library == "" && ch.Folder == "" {
+		return nil
+	}
+	dirs := []string{}
+	for _, lib := range m.cfg.MediaLibraries() {
+		if ch.Library == "" || lib.Name == ch.Library {
+			dirs = append(dirs, filepath.Join(lib.MediaDir, ch.Folder))
+		}
+	}
+	return dirs
+}
+
+// lineupItem returns a video as played by a playlist channel, in the
+// largest variant up to max_height. The segments of prev are reused when
+// its variant playlist didn't change.
+func (m *Manager) lineupItem(ch *channel, v *database.Video, prev *item) (*item, error) {
+	entries, err := m.db.ListCacheEntries(v.ID)
+	if err != nil {
+		return nil, err
+	}
+	var best *database.CacheEntry
+	bestHeight := 0
+	for _, e := range entries {
+		height, err := strconv.Atoi(strings.TrimSuffix(e.Variant, "p"))
+		if err != nil {
+			continue
+		}
+		fits := ch.MaxHeight == 0 || height <= ch.MaxHeight
+		bestFits := best != nil && (ch.MaxHeight == 0 || bestHeight <= ch.MaxHeight)
+		switch {
+		case best == nil,
+			fits && !bestFits,
+			fits && height > bestHeight,
+			!fits && !bestFits && height < bestHeight:
+			best, bestHeight = e, height
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no variants")
+	}
+
+	playlist := path.Clean(best.Playlist)
+	info, err := os.Stat(filepath.Join(m.cfg.Media.CacheDir, filepath.FromSlash(playlist)))
+	if err != nil {
+		return nil, err
+	}
+	if prev != nil && prev.playlist == playlist && prev.modTime.Equal(info.ModTime()) {
+		return prev, nil
+	}
+
+	it := &item{
+		videoID:  v.ID,
+		title:    strings.TrimSuffix(v.Filename, filepath.Ext(v.Filename)),
+		playlist: playlist,
+		modTime:  info.ModTime(),
+	}
+	if err := m.readVariant(it); err != nil {
+		return nil, err
+	}
+	if len(it.segments) == 0 || it.duration <= 0 {
+		return nil, fmt.Errorf("variant playlist %s has no segments", playlist)
+	}
+	return it, nil
+}
+
+// readVariant reads the segments of the variant playlist of an item
+func (m *Manager) readVariant(it *item) error {
+	f, err := os.Open(filepath.Join(m.cfg.Media.CacheDir, filepath.FromSlash(it.playlist)))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dir := path.Dir(it.playlist)
+	duration := -1.0
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			_, uri, _ := strings.Cut(line, `URI="`)
+			uri, _, _ = strings.Cut(uri, `"`)
+			it.init = path.Join(dir, uri)
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			if duration, err = strconv.ParseFloat(value, 64); err != nil {
+				return fmt.Errorf("invalid segment duration %q", value)
+			}
+		case line != "" && !strings.HasPrefix(line, "#") && duration > 0:
+			it.segments = append(it.segments, segment{path: path.Join(dir, line), duration: duration})
+			it.duration += duration
+			duration = -1
+		}
+	}
+	return scanner.Err()
+}
+
+// Playlist renders the live playlist of a playlist channel at t: the last
+// live.playlist_entries segments that started playing, with a
+// discontinuity wherever the next video starts. Segment URIs are relative,
+// "v/" followed by the path of the segment in the cache.
+func (m *Manager) Playlist(name string, t time.Time) ([]byte, error) {
+	ch, ok := m.channels[name]
+	if !ok || !ch.Playlist() {
+		return nil, fmt.Errorf("no playlist channel %q", name)
+	}
+	l, err := m.playlistLineup(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	c := l.locate(ch, t)
+	for i := 1; i < m.cfg.Live.PlaylistEntries; i++ {
+		l.back(ch, c)
+	}
+
+	var b strings.Builder
+	version := 3
+	if l.item(c).init != "" {
+		version = 6
+	}
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n", version, l.targetDuration)
+	fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", c.sequence, l.discontinuity(c))
+	for i := 0; i < m.cfg.Live.PlaylistEntries; i++ {
+		if i > 0 {
+			l.forward(ch, c)
+		}
+		it := l.item(c)
+		if c.segment == 0 && i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if it.init != "" && (c.segment == 0 || i == 0) {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=\"%s\"\n", segmentURI(it.init))
+		}
+		s := it.segments[c.segment]
+		fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration, segmentURI(s.path))
+	}
+	return []byte(b.String()), nil
+}
+
+// segmentURI returns the URI of a segment in a channel playlist
+func segmentURI(p string) string {
+	parts := strings.Split(p, "/")
+	for i, part := range parts {
+		parts[i] = url.PathEscape(part)
+	}
+	return "v/" + strings.Join(parts, "/")
+}
+
+// Schedule returns the programmes of a playlist channel from the one
+// playing at t until t+d
+func (m *Manager) Schedule(name string, t time.Time, d time.Duration) ([]Programme, error) {
+	ch, ok := m.channels[name]
+	if !ok || !ch.Playlist() {
+		return nil, fmt.Errorf("no playlist channel %q", name)
+	}
+	l, err := m.playlistLineup(ch)
+	if err != nil {
+		return nil, err
+	}
+
+	c := l.locate(ch, t)
+	start := t.Add(-seconds(c.position))
+	var programmes []Programme
+	for len(programmes) < maxProgrammes && start.Before(t.Add(d)) {
+		it := l.item(c)
+		end := start.Add(seconds(it.duration))
+		programmes = append(programmes, Programme{
+			VideoID: it.videoID,
+			Title:   it.title,
+			Start:   start.Round(time.Millisecond),
+			End:     end.Round(time.Millisecond),
+		})
+		start = end
+		// Skip to the first segment of the next item
+		c.segment = len(it.segments) - 1
+		l.forward(ch, c)
+	}
+	return programmes, nil
+}
+
+// seconds converts seconds to a duration
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
+
+// playlistStatus returns the status of a playlist channel, which is live
+// while there are videos to play
+func (m *Manager) playlistStatus(ch *channel) Status {
+	status := Status{Name: ch.Name, Type: "playlist", Variants: []string{}}
+	l, err := m.playlistLineup(ch)
+	if err != nil {
+		return status
+	}
+	status.Live = true
+	status.Playing = l.item(l.locate(ch, time.Now())).title
+	return status
+}
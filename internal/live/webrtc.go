@@ -0,0 +1,334 @@
+package live
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pion/ice/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/webrtc/v4"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/transcoder"
+)
+
+// gatherTimeout caps how long answering an offer waits for the ICE
+// candidates of the server. Answers carry all candidates, as WHEP players
+// aren't required to take more of them later.
+const gatherTimeout = 5 * time.Second
+
+// connectTimeout is how long a WebRTC viewer has to connect after its
+// offer was answered
+const connectTimeout = 30 * time.Second
+
+// keepaliveInterval is how often connected WebRTC viewers are counted
+// against the stream limits, as they don't request anything while
+// watching
+const keepaliveInterval = 10 * time.Second
+
+// Errors returned for refused WebRTC viewers
+var (
+	ErrNoWebRTC     = errors.New("channel isn't offered over WebRTC")
+	ErrNoStream     = errors.New("channel isn't receiving a stream")
+	ErrInvalidOffer = errors.New("invalid SDP offer")
+)
+
+// relay forwards the RTP packets FFmpeg sends for a channel to the WebRTC
+// viewers of the channel. Its ports stay the same for every stream the
+// channel receives.
+type relay struct {
+	video, audio         *webrtc.TrackLocalStaticRTP
+	videoConn, audioConn *net.UDPConn
+}
+
+// newRelay listens for the RTP packets of a channel on local ports, with
+// audio unless the channel sends video only
+func newRelay(name string, audio bool) (*relay, error) {
+	r := &relay{}
+	var err error
+	r.video, r.videoConn, err = relayTrack(name, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeH264, ClockRate: 90000,
+		SDPFmtpLine: "level-asymmetry-allowed=1;packetization-mode=1;profile-level-id=42e01f"})
+	if err != nil || !audio {
+		return r, err
+	}
+	r.audio, r.audioConn, err = relayTrack(name, webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: 48000, Channels: 2})
+	if err != nil {
+		r.close()
+	}
+	return r, err
+}
+
+// relayTrack creates a track of a channel and the local port its packets
+// arrive on
+func relayTrack(name string, codec webrtc.RTPCodecCapability) (*webrtc.TrackLocalStaticRTP, *net.UDPConn, error) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to listen for RTP: %w", err)
+	}
+	track, err := webrtc.NewTrackLocalStaticRTP(codec, codec.MimeType, name)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	go forward(conn, track)
+	return track, conn, nil
+}
+
+// forward sends the packets arriving on conn to every viewer of track
+// until conn is closed
+func forward(conn *net.UDPConn, track *webrtc.TrackLocalStaticRTP) {
+	buf := make([]byte, 1500)
+	for {
+		n, _, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		// Viewers that went away are dropped by their sessions
+		track.Write(buf[:n])
+	}
+}
+
+// ports returns where FFmpeg sends the stream to
+func (r *relay) ports() *transcoder.LiveRTP {
+	rtp := &transcoder.LiveRTP{VideoPort: r.videoConn.LocalAddr().(*net.UDPAddr).Port}
+	if r.audioConn != nil {
+		rtp.AudioPort = r.audioConn.LocalAddr().(*net.UDPAddr).Port
+	}
+	return rtp
+}
+
+// tracks returns the tracks viewers receive
+func (r *relay) tracks() []*webrtc.TrackLocalStaticRTP {
+	if r.audio == nil {
+		return []*webrtc.TrackLocalStaticRTP{r.video}
+	}
+	return []*webrtc.TrackLocalStaticRTP{r.video, r.audio}
+}
+
+// close stops listening for packets
+func (r *relay) close() {
+	if r.videoConn != nil {
+		r.videoConn.Close()
+	}
+	if r.audioConn != nil {
+		r.audioConn.Close()
+	}
+}
+
+// session is a WebRTC viewer of a channel
+type session struct {
+	channel string
+	pc      *webrtc.PeerConnection
+	// done is closed when the session ends
+	done chan struct{}
+	once sync.Once
+}
+
+// newWebRTC sets up the WebRTC stack shared by the viewers of all
+// channels, listening on live.webrtc.udp_port when it is set
+func newWebRTC(cfg config.LiveWebRTC) (*webrtc.API, *ice.MultiUDPMuxDefault, error) {
+	media := &webrtc.MediaEngine{}
+	if err := media.RegisterDefaultCodecs(); err != nil {
+		return nil, nil, err
+	}
+	// The default interceptors resend lost packets when viewers ask
+	interceptors := &interceptor.Registry{}
+	if err := webrtc.RegisterDefaultInterceptors(media, interceptors); err != nil {
+		return nil, nil, err
+	}
+
+	settings := webrtc.SettingEngine{}
+	if len(cfg.PublicIPs) > 0 {
+		settings.SetNAT1To1IPs(cfg.PublicIPs, webrtc.ICECandidateTypeHost)
+	}
+	var mux *ice.MultiUDPMuxDefault
+	if cfg.UDPPort > 0 {
+		var err error
+		mux, err = ice.NewMultiUDPMuxFromPort(cfg.UDPPort)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to listen on WebRTC port %d: %w", cfg.UDPPort, err)
+		}
+		settings.SetICEUDPMux(mux)
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(media), webrtc.WithInterceptorRegistry(interceptors), webrtc.WithSettingEngine(settings))
+	return api, mux, nil
+}
+
+// iceServers returns the STUN and TURN servers of the configuration
+func iceServers(cfg config.LiveWebRTC) []webrtc.ICEServer {
+	if len(cfg.ICEServers) == 0 {
+		return nil
+	}
+	return []webrtc.ICEServer{{
+		URLs:       cfg.ICEServers,
+		Username:   cfg.ICEUsername,
+		Credential: cfg.ICECredential,
+	}}
+}
+
+// Offer answers the SDP offer of a WebRTC viewer of a push channel,
+// returning the ID of the new session and the SDP answer. While the viewer
+// is connected keep is called regularly, and the session ends once it
+// returns false. Sessions also end with the stream.
+func (m *Manager) Offer(ctx context.Context, name, offer string, keep func() bool) (string, string, error) {
+	ch, ok := m.channels[name]
+	if !ok || ch.relay == nil {
+		return "", "", ErrNoWebRTC
+	}
+	m.mu.Lock()
+	live := !ch.since.IsZero()
+	m.mu.Unlock()
+	if !live {
+		return "", "", ErrNoStream
+	}
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", fmt.Errorf("failed to generate session ID: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	pc, err := m.webrtc.NewPeerConnection(webrtc.Configuration{ICEServers: m.iceServers})
+	if err != nil {
+		return "", "", err
+	}
+	for _, track := range ch.relay.tracks() {
+		sender, err := pc.AddTrack(track)
+		if err != nil {
+			pc.Close()
+			return "", "", err
+		}
+		// Reading the reports of the viewer lets the interceptors act on
+		// them
+		go func() {
+			buf := make([]byte, 1500)
+			for {
+				if _, _, err := sender.Read(buf); err != nil {
+					return
+				}
+			}
+		}()
+	}
+
+	if err := pc.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+	answer, err := pc.CreateAnswer(nil)
+	if err != nil {
+		pc.Close()
+		return "", "", fmt.Errorf("%w: %v", ErrInvalidOffer, err)
+	}
+	gathered := webrtc.GatheringCompletePromise(pc)
+	if err := pc.SetLocalDescription(answer); err != nil {
+		pc.Close()
+		return "", "", err
+	}
+	select {
+	case <-gathered:
+	case <-time.After(gatherTimeout):
+	case <-ctx.Done():
+		pc.Close()
+		return "", "", ctx.Err()
+	}
+
+	s := &session{channel: name, pc: pc, done: make(chan struct{})}
+	m.mu.Lock()
+	m.sessions[id] = s
+	m.mu.Unlock()
+
+	connected := make(chan struct{})
+	var connectOnce sync.Once
+	pc.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		switch state {
+		case webrtc.PeerConnectionStateConnected:
+			connectOnce.Do(func() {
+				close(connected)
+				m.log.Info("WebRTC viewer connected", "channel", name, "session", id)
+			})
+		case webrtc.PeerConnectionStateFailed, webrtc.PeerConnectionStateClosed:
+			go m.endSession(id)
+		}
+	})
+	go m.watchSession(id, s, connected, keep)
+
+	return id, pc.LocalDescription().SDP, nil
+}
+
+// watchSession ends a session when its viewer doesn't connect in time or
+// may no longer watch
+func (m *Manager) watchSession(id string, s *session, connected <-chan struct{}, keep func() bool) {
+	select {
+	case <-connected:
+	case <-s.done:
+		return
+	case <-time.After(connectTimeout):
+		m.endSession(id)
+		return
+	}
+
+	ticker := time.NewTicker(keepaliveInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if !keep() {
+				m.endSession(id)
+				return
+			}
+		}
+	}
+}
+
+// EndSession ends a WebRTC session of a channel, reporting false when
+// there is none of that ID
+func (m *Manager) EndSession(name, id string) bool {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok || s.channel != name {
+		return false
+	}
+	m.endSession(id)
+	return true
+}
+
+// endSession closes a session and forgets it
+func (m *Manager) endSession(id string) {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	delete(m.sessions, id)
+	m.mu.Unlock()
+	if !ok {
+		return
+	}
+	s.once.Do(func() {
+		close(s.done)
+		s.pc.Close()
+	})
+}
+
+// endSessions closes the sessions of a channel, or of all channels when
+// name is ""
+func (m *Manager) endSessions(name string) {
+	m.mu.Lock()
+	var ids []string
+	for id, s := range m.sessions {
+		if name == "" || s.channel == name {
+			ids = append(ids, id)
+		}
+	}
+	m.mu.Unlock()
+	for _, id := range ids {
+		m.endSession(id)
+	}
+}
@@ -0,0 +1,127 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedTimeFormat is the time in the name of rotated files, e.g.
+// streaming-20260102T150405.log
+const rotatedTimeFormat = "20060102T150405"
+
+// File is a log file that is rotated once it grows past a size. Rotated
+// files are renamed with the time of the rotation and removed once they
+// are older than the maximum age.
+type File struct {
+	path    string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// OpenFile opens the log file at path for appending, creating it if
+// needed. A maxSize of 0 never rotates it and a maxAge of 0 keeps rotated
+// files forever.
+func OpenFile(path string, maxSize int64, maxAge time.Duration) (*File, error) {
+	lf := &File{path: path, maxSize: maxSize, maxAge: maxAge}
+	if err := lf.open(); err != nil {
+		return nil, err
+	}
+	lf.removeOld()
+	return lf, nil
+}
+
+// open opens the file at the path, picking up the size it already has
+func (lf *File) open() error {
+	f, err := os.OpenFile(lf.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	lf.f = f
+	lf.size = info.Size()
+	return nil
+}
+
+// Write appends p to the file, rotating it first when p would take it
+// past the maximum size
+func (lf *File) Write(p []byte) (int, error) {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+
+	if lf.maxSize > 0 && lf.size > 0 && lf.size+int64(len(p)) > lf.maxSize {
+		if err := lf.rotate(); err != nil {
+			// Keep logging to the full file rather than losing messages
+			fmt.Fprintf(os.Stderr, "Error rotating log file: %v\n", err)
+		}
+	}
+	n, err := lf.f.Write(p)
+	lf.size += int64(n)
+	return n, err
+}
+
+// rotate renames the file and starts a new one. When another process
+// writing the same file rotated it already, the new file is just opened.
+func (lf *File) rotate() error {
+	current, err := lf.f.Stat()
+	if err != nil {
+		return err
+	}
+	if info, err := os.Stat(lf.path); err == nil && os.SameFile(current, info) {
+		if err := os.Rename(lf.path, lf.rotatedName(time.Now())); err != nil {
+			return err
+		}
+	}
+	lf.f.Close()
+	if err := lf.open(); err != nil {
+		return err
+	}
+	go lf.removeOld()
+	return nil
+}
+
+// rotatedName returns the name a file rotated at t gets
+func (lf *File) rotatedName(t time.Time) string {
+	ext := filepath.Ext(lf.path)
+	return fmt.Sprintf("%s-%s%s", strings.TrimSuffix(lf.path, ext), t.Format(rotatedTimeFormat), ext)
+}
+
+// removeOld removes the rotated files older than the maximum age
+func (lf *File) removeOld() {
+	if lf.maxAge <= 0 {
+		return
+	}
+	ext := filepath.Ext(lf.path)
+	pattern := strings.TrimSuffix(lf.path, ext) + "-*" + ext
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return
+	}
+	cutoff := time.Now().Add(-lf.maxAge)
+	for _, name := range matches {
+		stamp := strings.TrimSuffix(strings.TrimPrefix(name, strings.TrimSuffix(lf.path, ext)+"-"), ext)
+		t, err := time.ParseInLocation(rotatedTimeFormat, stamp, time.Local)
+		if err != nil || !t.Before(cutoff) {
+			continue
+		}
+		os.Remove(name)
+	}
+}
+
+// Close closes the file
+func (lf *File) Close() error {
+	lf.mu.Lock()
+	defer lf.mu.Unlock()
+	return lf.f.Close()
+}
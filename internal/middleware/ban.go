@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// BanList refuses requests from banned IP addresses and ranges
+func BanList(banned []*net.IPNet) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(banned) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if ip := net.ParseIP(ClientIP(r)); ip != nil && containsIP(banned, ip) {
+				http.Error(w, "Forbidden", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import "net/http"
+
+// BasePath serves the wrapped handler under a path prefix, for reverse
+// proxies forwarding a sub-path such as /media. The prefix is removed from
+// request paths, and requests outside of it are not found.
+func BasePath(prefix string) Middleware {
+	return func(next http.Handler) http.Handler {
+		if prefix == "" {
+			return next
+		}
+		stripped := http.StripPrefix(prefix, next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == prefix {
+				http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+				return
+			}
+			stripped.ServeHTTP(w, r)
+		})
+	}
+}
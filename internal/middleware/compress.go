@@ -0,0 +1,215 @@
+package middleware
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// minCompressSize is the smallest response worth compressing, when its size
+// is known in advance
+const minCompressSize = 512
+
+// compressibleTypes are the content types that are compressed. Video
+// segments are already compressed and served with sendfile, so they are
+// left alone.
+var compressibleTypes = map[string]bool{
+	"application/json":              true,
+	"application/x-mpegurl":         true,
+	"application/vnd.apple.mpegurl": true,
+	"text/html":                     true,
+	"text/plain":                    true,
+	"text/css":                      true,
+	"text/javascript":               true,
+}
+
+var (
+	gzipPool = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	zlibPool = sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}
+)
+
+// Compress compresses playlists, JSON and HTML responses with gzip or
+// deflate, as negotiated with the Accept-Encoding header
+func Compress() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+			if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+			defer cw.Close()
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from an Accept-Encoding header,
+// preferring gzip when both are accepted equally
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "gzip" && name != "deflate" {
+			continue
+		}
+
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter compresses the response body once the headers show that
+// the response is worth compressing
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	enc      io.WriteCloser
+	decided  bool
+}
+
+// WriteHeader decides whether to compress the response from its headers
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.decided {
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.decided = true
+
+	h := cw.Header()
+	if cw.shouldCompress(status) {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed body is a different representation, but it still
+		// matches conditional requests for the uncompressed one
+		if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+			h.Set("ETag", "W/"+etag)
+		}
+
+		if cw.encoding == "gzip" {
+			gz := gzipPool.Get().(*gzip.Writer)
+			gz.Reset(cw.ResponseWriter)
+			cw.enc = gz
+		} else {
+			zw := zlibPool.Get().(*zlib.Writer)
+			zw.Reset(cw.ResponseWriter)
+			cw.enc = zw
+		}
+	}
+	if compressibleTypes[mediaType(h.Get("Content-Type"))] {
+		h.Add("Vary", "Accept-Encoding")
+	}
+
+	cw.ResponseWriter.WriteHeader(status)
+}
+
+// shouldCompress reports whether a response with the current headers and
+// status gets compressed
+func (cw *compressWriter) shouldCompress(status int) bool {
+	h := cw.Header()
+	if status < 200 || status == http.StatusNoContent || status == http.StatusNotModified ||
+		status == http.StatusPartialContent || h.Get("Content-Encoding") != "" {
+		return false
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < minCompressSize {
+		return false
+	}
+	return compressibleTypes[mediaType(h.Get("Content-Type"))]
+}
+
+// Write compresses b if the response is compressed
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if !cw.decided {
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.enc != nil {
+		return cw.enc.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// ReadFrom copies uncompressed responses with the underlying writer, so
+// segments are still sent with sendfile
+func (cw *compressWriter) ReadFrom(src io.Reader) (int64, error) {
+	if cw.decided && cw.enc == nil {
+		if rf, ok := cw.ResponseWriter.(io.ReaderFrom); ok {
+			return rf.ReadFrom(src)
+		}
+	}
+	return io.Copy(struct{ io.Writer }{cw}, src)
+}
+
+// Flush sends compressed data buffered so far to the client
+func (cw *compressWriter) Flush() {
+	if cw.enc != nil {
+		if f, ok := cw.enc.(interface{ Flush() error }); ok {
+			f.Flush()
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack takes over the connection, as done by WebSocket upgrades
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("response writer doesn't support hijacking")
+	}
+	cw.decided = true
+	return h.Hijack()
+}
+
+// Unwrap returns the wrapped writer for http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// Close finishes the compressed stream and returns the encoder to its pool
+func (cw *compressWriter) Close() {
+	if cw.enc == nil {
+		return
+	}
+	cw.enc.Close()
+
+	switch enc := cw.enc.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(enc)
+	case *zlib.Writer:
+		zlibPool.Put(enc)
+	}
+	cw.enc = nil
+}
+
+// mediaType returns the lower case media type of a Content-Type header
+func mediaType(contentType string) string {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return strings.ToLower(strings.TrimSpace(contentType))
+	}
+	return mt
+}
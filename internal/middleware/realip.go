@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces the remote address of requests relayed by a trusted
+// reverse proxy with the client address from the X-Forwarded-For header, so
+// later middleware and handlers see the real client. Entries added by
+// trusted proxies are skipped from the right; the first untrusted one is the
+// client, as anything left of it could have been forged.
+func RealIP(trusted []*net.IPNet) Middleware {
+	return func(next http.Handler) http.Handler {
+		if len(trusted) == 0 {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if remote := net.ParseIP(ClientIP(r)); remote != nil && containsIP(trusted, remote) {
+				if client := forwardedClient(r.Header.Values("X-Forwarded-For"), trusted); client != "" {
+					r.RemoteAddr = net.JoinHostPort(client, "0")
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// forwardedClient returns the rightmost untrusted address of the
+// X-Forwarded-For headers
+func forwardedClient(headers []string, trusted []*net.IPNet) string {
+	var hops []string
+	for _, h := range headers {
+		for _, hop := range strings.Split(h, ",") {
+			hops = append(hops, strings.TrimSpace(hop))
+		}
+	}
+
+	for i := len(hops) - 1; i >= 0; i-- {
+		ip := net.ParseIP(hops[i])
+		if ip == nil {
+			return ""
+		}
+		if !containsIP(trusted, ip) {
+			return ip.String()
+		}
+	}
+	return ""
+}
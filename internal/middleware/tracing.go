@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/kaero/streaming/internal/telemetry"
+)
+
+// Tracing starts a span for every request, continuing the trace of a
+// caller that sent a traceparent header. The span is named after the
+// route once TraceRoute found it.
+func Tracing() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := telemetry.Extract(r.Context(), r.Header)
+			ctx, span := telemetry.StartServer(ctx, r.Method,
+				"http.request.method", r.Method,
+				"url.path", r.URL.Path,
+				"client.address", ClientIP(r),
+				"user_agent.original", r.UserAgent(),
+				"request_id", RequestIDFromContext(r.Context()),
+			)
+			defer span.End()
+
+			rec := &responseRecorder{ResponseWriter: w}
+			next.ServeHTTP(rec, r.WithContext(ctx))
+			if rec.status == 0 {
+				rec.status = http.StatusOK
+			}
+			span.SetAttributes("http.response.status_code", rec.status, "http.response.body.size", rec.bytes)
+			if rec.status >= 500 {
+				span.SetError(errStatus(rec.status))
+			}
+		})
+	}
+}
+
+// TraceRoute names the span of each request after the route of mux that
+// serves it, such as "GET /api/v1/videos/{id}"
+func TraceRoute(mux *http.ServeMux) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The mux sets the pattern on the request it is given
+		mux.ServeHTTP(w, r)
+		if r.Pattern == "" {
+			return
+		}
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		span := telemetry.SpanFromContext(r.Context())
+		span.SetName(r.Method + " " + route)
+		span.SetAttributes("http.route", route)
+	})
+}
+
+// errStatus is the error of a request answered with a server error
+type errStatus int
+
+func (e errStatus) Error() string {
+	return http.StatusText(int(e))
+}
@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// defaultCommandTimeout is how long a command may run when its target
+// doesn't set timeout_seconds
+const defaultCommandTimeout = time.Minute
+
+// commandOutputLimit is how much of the end of a failed command's output
+// is logged
+const commandOutputLimit = 2000
+
+// checkCommand validates the command of a command target
+func checkCommand(t config.NotifyTarget) error {
+	if len(t.Command) == 0 || t.Command[0] == "" {
+		return errors.New("needs a command")
+	}
+	if _, err := exec.LookPath(t.Command[0]); err != nil {
+		return fmt.Errorf("has a command that can't be run: %w", err)
+	}
+	if t.TimeoutSeconds < 0 {
+		return errors.New("has a negative timeout_seconds")
+	}
+	return nil
+}
+
+// run executes the command of a target for an event. The event is passed
+// in STREAMING_* environment variables and on stdin, as JSON or rendered
+// by the template. The command is killed when it exceeds its timeout.
+func (n *Notifier) run(t *target, e Event) {
+	input, err := t.input(e)
+	if err != nil {
+		n.log.Error("Error preparing notification", "event", e.Type, "target", t.Name, "err", err)
+		return
+	}
+
+	timeout := defaultCommandTimeout
+	if t.TimeoutSeconds > 0 {
+		timeout = time.Duration(t.TimeoutSeconds) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.Command[0], t.Command[1:]...)
+	cmd.Env = append(os.Environ(), e.environ()...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Don't wait for children of the command still holding the output open
+	cmd.WaitDelay = 5 * time.Second
+
+	start := time.Now()
+	err = cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err != nil {
+		n.log.Error("Notify command failed", "event", e.Type, "target", t.Name, "err", err,
+			"output", outputTail(output.String()))
+		return
+	}
+	n.log.Info("Notify command finished", "event", e.Type, "target", t.Name,
+		"duration", time.Since(start).Round(time.Millisecond))
+}
+
+// input returns what a command reads on stdin: the rendered template, or
+// the event as JSON without one
+func (t *target) input(e Event) ([]byte, error) {
+	if t.tmpl != nil {
+		rendered, err := t.render(e)
+		return []byte(rendered), err
+	}
+	return json.Marshal(e)
+}
+
+// environ returns the event as environment variables for commands.
+// Variables for fields the event doesn't have are left out.
+func (e Event) environ() []string {
+	env := []string{
+		"STREAMING_EVENT=" + e.Type,
+		"STREAMING_EVENT_TIME=" + e.Time.Format(time.RFC3339),
+		"STREAMING_VIDEO_ID=" + strconv.FormatInt(e.VideoID, 10),
+		"STREAMING_VIDEO_FILENAME=" + e.Filename,
+		"STREAMING_VIDEO_PATH=" + e.Path,
+		"STREAMING_MESSAGE=" + e.Message(),
+	}
+	if e.Duration > 0 {
+		env = append(env, "STREAMING_VIDEO_DURATION="+strconv.FormatFloat(e.Duration, 'f', -1, 64))
+	}
+	if e.Error != "" {
+		env = append(env, "STREAMING_ERROR="+e.Error, "STREAMING_FAILURE_CLASS="+e.FailureClass)
+	}
+	if e.NextRetryAt != nil {
+		env = append(env, "STREAMING_NEXT_RETRY_AT="+e.NextRetryAt.Format(time.RFC3339))
+	}
+	return env
+}
+
+// outputTail returns the end of a command's output for the log
+func outputTail(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) > commandOutputLimit {
+		s = "..." + strings.ToValidUTF8(s[len(s)-commandOutputLimit:], "")
+	}
+	return s
+}
@@ -0,0 +1,281 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// Event types notifications can be sent for
+const (
+	EventReady  = "video_ready"
+	EventFailed = "video_failed"
+)
+
+// Target types
+const (
+	TypeWebhook = "webhook"
+	TypeNtfy    = "ntfy"
+	TypeDiscord = "discord"
+	TypeCommand = "command"
+)
+
+// deliveryAttempts is how often a notification is sent before giving up.
+// The delay between attempts starts at retryDelay and doubles.
+const (
+	deliveryAttempts = 3
+	retryDelay       = 5 * time.Second
+)
+
+// discordMaxContent is the longest message Discord accepts
+const discordMaxContent = 2000
+
+// Event describes a processing result. It is the data notification
+// templates are executed with and the default webhook body.
+type Event struct {
+	Type         string     `json:"type"`
+	VideoID      int64      `json:"video_id"`
+	Filename     string     `json:"filename"`
+	Path         string     `json:"path"`
+	Duration     float64    `json:"duration,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	FailureClass string     `json:"failure_class,omitempty"`
+	NextRetryAt  *time.Time `json:"next_retry_at,omitempty"`
+	Time         time.Time  `json:"time"`
+}
+
+// Message returns a one-line description of the event, the default text
+// of ntfy and Discord notifications
+func (e Event) Message() string {
+	if e.Type == EventFailed {
+		msg := fmt.Sprintf("Processing %s failed: %s", e.Filename, e.Error)
+		if e.NextRetryAt != nil {
+			msg += ", retrying at " + e.NextRetryAt.Local().Format(time.RFC3339)
+		}
+		return msg
+	}
+	return fmt.Sprintf("%s is ready to stream", e.Filename)
+}
+
+// Notifier sends events to the configured targets
+type Notifier struct {
+	targets []*target
+	client  *http.Client
+	log     *slog.Logger
+}
+
+// target is a validated notification target
+type target struct {
+	config.NotifyTarget
+	events map[string]bool
+	tmpl   *template.Template
+}
+
+// templateFuncs are available in notification templates. json encodes a
+// value, so strings can be embedded in JSON bodies safely.
+var templateFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// New validates the configured targets and creates a notifier for them.
+// A notifier without targets sends nothing, failed deliveries are logged to
+// logger.
+func New(targets []config.NotifyTarget, logger *slog.Logger) (*Notifier, error) {
+	n := &Notifier{client: &http.Client{Timeout: 10 * time.Second}, log: logger}
+
+	for _, cfg := range targets {
+		t := &target{NotifyTarget: cfg, events: make(map[string]bool)}
+		if t.Name == "" {
+			t.Name = t.Type
+		}
+
+		var err error
+		switch t.Type {
+		case TypeWebhook, TypeNtfy, TypeDiscord:
+			u, err := url.Parse(t.URL)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return nil, fmt.Errorf("notify target %q needs an http or https url", t.Name)
+			}
+		case TypeCommand:
+			if err := checkCommand(cfg); err != nil {
+				return nil, fmt.Errorf("notify target %q %w", t.Name, err)
+			}
+		default:
+			return nil, fmt.Errorf("notify target %q has unknown type %q", t.Name, t.Type)
+		}
+
+		if len(t.Events) == 0 {
+			t.Events = []string{EventReady, EventFailed}
+		}
+		for _, e := range t.Events {
+			if e != EventReady && e != EventFailed {
+				return nil, fmt.Errorf("notify target %q has unknown event %q", t.Name, e)
+			}
+			t.events[e] = true
+		}
+
+		if t.Template != "" {
+			t.tmpl, err = template.New(t.Name).Funcs(templateFuncs).Parse(t.Template)
+			if err != nil {
+				return nil, fmt.Errorf("notify target %q has an invalid template: %w", t.Name, err)
+			}
+		}
+		if t.ContentType == "" {
+			t.ContentType = "application/json"
+		}
+
+		n.targets = append(n.targets, t)
+	}
+
+	return n, nil
+}
+
+// Notify sends an event to every target subscribed to it. Delivery
+// happens in the background and failures are only logged, so processing
+// never waits for a slow service.
+func (n *Notifier) Notify(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+
+	for _, t := range n.targets {
+		if !t.events[e.Type] {
+			continue
+		}
+		go n.deliver(t, e)
+	}
+}
+
+// deliver sends an event to a target, retrying failed attempts. Commands
+// are run once.
+func (n *Notifier) deliver(t *target, e Event) {
+	if t.Type == TypeCommand {
+		n.run(t, e)
+		return
+	}
+
+	req, err := t.request(e)
+	if err != nil {
+		n.log.Error("Error preparing notification", "event", e.Type, "target", t.Name, "err", err)
+		return
+	}
+
+	delay := retryDelay
+	for attempt := 1; ; attempt++ {
+		err = n.send(req)
+		if err == nil {
+			return
+		}
+		if attempt == deliveryAttempts {
+			break
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+	n.log.Error("Error sending notification", "event", e.Type, "target", t.Name, "err", err)
+}
+
+// send performs a prepared request, which can be sent repeatedly
+func (n *Notifier) send(req *preparedRequest) error {
+	r, err := http.NewRequest(http.MethodPost, req.url, bytes.NewReader(req.body))
+	if err != nil {
+		return err
+	}
+	for k, v := range req.header {
+		r.Header.Set(k, v)
+	}
+
+	resp, err := n.client.Do(r)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// preparedRequest is the rendered notification sent to a target
+type preparedRequest struct {
+	url    string
+	header map[string]string
+	body   []byte
+}
+
+// request renders the notification of an event in the target's format
+func (t *target) request(e Event) (*preparedRequest, error) {
+	req := &preparedRequest{url: t.URL, header: make(map[string]string)}
+
+	// The template renders the webhook body and the message of the others
+	var rendered string
+	if t.tmpl != nil {
+		var err error
+		if rendered, err = t.render(e); err != nil {
+			return nil, err
+		}
+	} else if t.Type != TypeWebhook {
+		rendered = e.Message()
+	}
+
+	switch t.Type {
+	case TypeWebhook:
+		if t.tmpl != nil {
+			req.body = []byte(rendered)
+		} else {
+			body, err := json.Marshal(e)
+			if err != nil {
+				return nil, err
+			}
+			req.body = body
+		}
+		req.header["Content-Type"] = t.ContentType
+
+	case TypeNtfy:
+		req.body = []byte(rendered)
+		if e.Type == EventFailed {
+			req.header["Title"] = "Processing failed"
+			req.header["Tags"] = "warning"
+		} else {
+			req.header["Title"] = "Video ready"
+			req.header["Tags"] = "white_check_mark"
+		}
+
+	case TypeDiscord:
+		if len(rendered) > discordMaxContent {
+			rendered = strings.ToValidUTF8(rendered[:discordMaxContent-3], "") + "..."
+		}
+		body, err := json.Marshal(map[string]string{"content": rendered})
+		if err != nil {
+			return nil, err
+		}
+		req.body = body
+		req.header["Content-Type"] = "application/json"
+	}
+
+	for k, v := range t.Headers {
+		req.header[k] = v
+	}
+	return req, nil
+}
+
+// render executes the target's template with an event
+func (t *target) render(e Event) (string, error) {
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, e); err != nil {
+		return "", fmt.Errorf("failed to render template: %w", err)
+	}
+	return buf.String(), nil
+}
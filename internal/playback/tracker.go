@@ -0,0 +1,199 @@
+package playback
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Errors returned for refused streams
+var (
+	ErrLimitReached = errors.New("stream limit reached")
+	ErrTerminated   = errors.New("session was terminated")
+)
+
+// Session is a video being watched
+type Session struct {
+	ID        string
+	User      string
+	ClientIP  string
+	VideoID   int64
+	Video     string
+	Variant   string
+	StartedAt time.Time
+	LastSeen  time.Time
+	// Position is estimated from the last segment or byte range requested
+	Position time.Duration
+}
+
+// Tracker keeps the playback sessions of all users and limits the streams
+// each user is watching. A session is active while its playlists or
+// segments keep being requested and ends once it was idle for longer than
+// the idle timeout.
+type Tracker struct {
+	max  int
+	idle time.Duration
+
+	mu         sync.Mutex
+	sessions   map[string]*Session
+	terminated map[string]time.Time
+	lastPrune  time.Time
+}
+
+// NewTracker creates a tracker allowing max streams per user, or any
+// number of streams if max is 0
+func NewTracker(max int, idle time.Duration) *Tracker {
+	return &Tracker{
+		max:        max,
+		idle:       idle,
+		sessions:   make(map[string]*Session),
+		terminated: make(map[string]time.Time),
+	}
+}
+
+// Check returns why a user may not watch a stream: ErrTerminated if the
+// session was terminated, or ErrLimitReached if it is new and the user is
+// at the limit
+func (t *Tracker) Check(user, stream string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.check(user, stream, time.Now())
+}
+
+// Touch records a request for a stream, starting a session if it is new.
+// It returns the same errors as Check without recording anything, and
+// whether the session was started.
+func (t *Tracker) Touch(user, stream, clientIP string) (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.lastPrune) > t.idle {
+		t.prune(now)
+		t.lastPrune = now
+	}
+
+	if err := t.check(user, stream, now); err != nil {
+		// Keep terminated sessions blocked while their player retries
+		if errors.Is(err, ErrTerminated) {
+			t.terminated[stream] = now
+		}
+		return false, err
+	}
+
+	s, ok := t.sessions[stream]
+	if ok && now.Sub(s.LastSeen) <= t.idle {
+		s.LastSeen = now
+		return false, nil
+	}
+
+	t.sessions[stream] = &Session{
+		ID:        stream,
+		User:      user,
+		ClientIP:  clientIP,
+		StartedAt: now,
+		LastSeen:  now,
+	}
+	return true, nil
+}
+
+// Update changes a session with fn, if it exists
+func (t *Tracker) Update(stream string, fn func(s *Session)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if s, ok := t.sessions[stream]; ok {
+		fn(s)
+	}
+}
+
+// Terminate ends a session and refuses its further requests until they
+// stopped for the idle timeout. It returns false if there is no such
+// active session.
+func (t *Tracker) Terminate(stream string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	s, ok := t.sessions[stream]
+	if !ok || now.Sub(s.LastSeen) > t.idle {
+		return false
+	}
+	delete(t.sessions, stream)
+	t.terminated[stream] = now
+	return true
+}
+
+// Sessions returns the active sessions, oldest first
+func (t *Tracker) Sessions() []Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	var sessions []Session
+	for _, s := range t.sessions {
+		if now.Sub(s.LastSeen) <= t.idle {
+			sessions = append(sessions, *s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].StartedAt.Before(sessions[j].StartedAt)
+	})
+	return sessions
+}
+
+// Active returns the number of active streams of each user
+func (t *Tracker) Active() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := time.Now()
+	active := make(map[string]int)
+	for _, s := range t.sessions {
+		if now.Sub(s.LastSeen) <= t.idle {
+			active[s.User]++
+		}
+	}
+	return active
+}
+
+// check implements Check, the caller must hold the lock
+func (t *Tracker) check(user, stream string, now time.Time) error {
+	if at, ok := t.terminated[stream]; ok && now.Sub(at) <= t.idle {
+		return ErrTerminated
+	}
+	if t.max <= 0 {
+		return nil
+	}
+	if s, ok := t.sessions[stream]; ok && now.Sub(s.LastSeen) <= t.idle {
+		return nil
+	}
+
+	active := 0
+	for _, s := range t.sessions {
+		if s.User == user && now.Sub(s.LastSeen) <= t.idle {
+			active++
+		}
+	}
+	if active >= t.max {
+		return ErrLimitReached
+	}
+	return nil
+}
+
+// prune forgets sessions that went idle and terminations that expired, the
+// caller must hold the lock
+func (t *Tracker) prune(now time.Time) {
+	for stream, s := range t.sessions {
+		if now.Sub(s.LastSeen) > t.idle {
+			delete(t.sessions, stream)
+		}
+	}
+	for stream, at := range t.terminated {
+		if now.Sub(at) > t.idle {
+			delete(t.terminated, stream)
+		}
+	}
+}
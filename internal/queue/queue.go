@@ -0,0 +1,85 @@
+// Package queue shares the processing queue between librarians on several
+// machines. Each job is claimed by one librarian at a time, which extends
+// its claim with heartbeats; jobs of librarians that stopped sending them
+// are handed to another one once the visibility timeout has passed.
+package queue
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// ErrLeaseLost is returned by Heartbeat when the job was handed to another
+// librarian, after this one missed heartbeats for the visibility timeout
+var ErrLeaseLost = errors.New("job was taken over by another librarian")
+
+// Queue holds the IDs of the videos waiting to be processed
+type Queue interface {
+	// Enqueue adds videos that are neither queued nor claimed, returning
+	// how many were added
+	Enqueue(ctx context.Context, ids []int64) (int, error)
+	// Claim takes the next video off the queue for this librarian,
+	// reporting false when the queue is empty
+	Claim(ctx context.Context) (int64, bool, error)
+	// Heartbeat extends the claim of a running job
+	Heartbeat(ctx context.Context, id int64) error
+	// Ack removes a finished job, whatever its outcome
+	Ack(ctx context.Context, id int64) error
+	// HeartbeatInterval is how often the claim of a running job has to be
+	// extended
+	HeartbeatInterval() time.Duration
+	// Close releases the connection
+	Close() error
+}
+
+// New returns the queue queue.backend selects, logging to logger. The
+// "database" backend needs no queue, as the librarian picks the pending
+// videos from the database itself, so nil is returned for it.
+func New(cfg *config.Config, logger *slog.Logger) (Queue, error) {
+	q := cfg.Queue
+	if q.Backend != "redis" {
+		return nil, nil
+	}
+
+	owner, err := ownerID()
+	if err != nil {
+		return nil, err
+	}
+	return &Redis{
+		client: &redisClient{
+			addr:     q.Redis.Addr,
+			username: q.Redis.Username,
+			password: q.Redis.Password,
+			db:       q.Redis.DB,
+			tls:      q.Redis.TLS,
+		},
+		prefix:     q.Redis.KeyPrefix,
+		owner:      owner,
+		visibility: time.Duration(q.VisibilityTimeoutSeconds) * time.Second,
+		heartbeat:  time.Duration(q.HeartbeatSeconds) * time.Second,
+		log:        logger,
+	}, nil
+}
+
+// ownerID identifies this librarian in claims: the host name, the process
+// ID and a random suffix, so restarted processes don't reuse the claims of
+// their predecessor
+func ownerID() (string, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate queue owner ID: %w", err)
+	}
+	return fmt.Sprintf("%s:%d:%s", host, os.Getpid(), hex.EncodeToString(b)), nil
+}
@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"time"
+)
+
+// Redis keeps the queue in a Redis server shared by the librarians. The
+// keys, all starting with the configured prefix, are:
+//
+//   - queue: list of the IDs waiting to be claimed, oldest first
+//   - queued: set of the IDs queued or claimed, so no video is queued twice
+//   - leases: sorted set of the claimed IDs by the end of their claim, in
+//     milliseconds of the Redis server clock
+//   - owners: hash of the claimed IDs to the librarian holding them
+//
+// Every operation is a Lua script, which Redis runs atomically.
+type Redis struct {
+	client     *redisClient
+	prefix     string
+	owner      string
+	visibility time.Duration
+	heartbeat  time.Duration
+	log        *slog.Logger
+}
+
+// enqueueScript queues the IDs in ARGV that aren't queued or claimed yet
+const enqueueScript = `
+local added = 0
+for _, id in ipairs(ARGV) do
+	if redis.call('SADD', KEYS[1], id) == 1 then
+		redis.call('RPUSH', KEYS[2], id)
+		added = added + 1
+	end
+end
+return added`
+
+// claimScript puts jobs whose claim expired back at the front of the
+// queue, then claims the next one for ARGV[2] for ARGV[1] milliseconds
+const claimScript = `
+local t = redis.call('TIME')
+local now = t[1] * 1000 + math.floor(t[2] / 1000)
+for _, id in ipairs(redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', now)) do
+	redis.call('ZREM', KEYS[2], id)
+	redis.call('HDEL', KEYS[3], id)
+	redis.call('LPUSH', KEYS[1], id)
+end
+local id = redis.call('LPOP', KEYS[1])
+if not id then
+	return false
+end
+redis.call('ZADD', KEYS[2], now + tonumber(ARGV[1]), id)
+redis.call('HSET', KEYS[3], id, ARGV[2])
+return id`
+
+// heartbeatScript extends the claim of ARGV[3] by ARGV[1] milliseconds if
+// ARGV[2] still holds it
+const heartbeatScript = `
+if redis.call('HGET', KEYS[2], ARGV[3]) ~= ARGV[2] then
+	return 0
+end
+local t = redis.call('TIME')
+redis.call('ZADD', KEYS[1], t[1] * 1000 + math.floor(t[2] / 1000) + tonumber(ARGV[1]), ARGV[3])
+return 1`
+
+// ackScript removes the job ARGV[2] if ARGV[1] still holds it
+const ackScript = `
+if redis.call('HGET', KEYS[2], ARGV[2]) ~= ARGV[1] then
+	return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[2])
+redis.call('HDEL', KEYS[2], ARGV[2])
+redis.call('SREM', KEYS[3], ARGV[2])
+return 1`
+
+// key returns the name of a key of the queue
+func (r *Redis) key(name string) string {
+	return r.prefix + name
+}
+
+// Enqueue adds videos that are neither queued nor claimed
+func (r *Redis) Enqueue(ctx context.Context, ids []int64) (int, error) {
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	args := make([]string, len(ids))
+	for i, id := range ids {
+		args[i] = strconv.FormatInt(id, 10)
+	}
+	added, err := r.client.eval(ctx, enqueueScript, []string{r.key("queued"), r.key("queue")}, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to queue videos: %w", err)
+	}
+	n, _ := added.(int64)
+	return int(n), nil
+}
+
+// Claim takes the next video off the queue, first requeueing the jobs
+// whose librarian stopped sending heartbeats
+func (r *Redis) Claim(ctx context.Context) (int64, bool, error) {
+	reply, err := r.client.eval(ctx, claimScript,
+		[]string{r.key("queue"), r.key("leases"), r.key("owners")},
+		strconv.FormatInt(r.visibility.Milliseconds(), 10), r.owner)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to claim a job: %w", err)
+	}
+	if reply == nil {
+		return 0, false, nil
+	}
+	s, _ := reply.(string)
+	id, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, false, fmt.Errorf("invalid job %q in the queue", s)
+	}
+	return id, true, nil
+}
+
+// Heartbeat extends the claim of a running job by the visibility timeout
+func (r *Redis) Heartbeat(ctx context.Context, id int64) error {
+	reply, err := r.client.eval(ctx, heartbeatScript, []string{r.key("leases"), r.key("owners")},
+		strconv.FormatInt(r.visibility.Milliseconds(), 10), r.owner, strconv.FormatInt(id, 10))
+	if err != nil {
+		return fmt.Errorf("failed to extend the claim of a job: %w", err)
+	}
+	if reply != int64(1) {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+// Ack removes a finished job. Jobs another librarian took over are left
+// to it.
+func (r *Redis) Ack(ctx context.Context, id int64) error {
+	reply, err := r.client.eval(ctx, ackScript, []string{r.key("leases"), r.key("owners"), r.key("queued")},
+		r.owner, strconv.FormatInt(id, 10))
+	if err != nil {
+		return fmt.Errorf("failed to remove a finished job: %w", err)
+	}
+	if reply != int64(1) {
+		r.log.Warn("Finished a job another librarian took over", "id", id)
+	}
+	return nil
+}
+
+// HeartbeatInterval returns queue.heartbeat_seconds
+func (r *Redis) HeartbeatInterval() time.Duration {
+	return r.heartbeat
+}
+
+// Close closes the connection to Redis
+func (r *Redis) Close() error {
+	return r.client.close()
+}
@@ -0,0 +1,196 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadReply(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  any
+		// err is the redisError expected, or "io" for a failed read
+		err string
+	}{
+		{name: "simple string", input: "+OK\r\n", want: "OK"},
+		{name: "integer", input: ":-42\r\n", want: int64(-42)},
+		{name: "bulk string", input: "$5\r\nhe\r\no\r\n", want: "he\r\no"},
+		{name: "empty bulk string", input: "$0\r\n\r\n", want: ""},
+		{name: "nil bulk string", input: "$-1\r\n", want: nil},
+		{name: "empty array", input: "*0\r\n", want: []any{}},
+		{name: "nil array", input: "*-1\r\n", want: nil},
+		{
+			name:  "nested arrays",
+			input: "*3\r\n$2\r\n42\r\n*2\r\n:1\r\n$-1\r\n*-1\r\n",
+			want:  []any{"42", []any{int64(1), nil}, nil},
+		},
+		{name: "error", input: "-ERR unknown command\r\n", err: "ERR unknown command"},
+		{name: "error in array", input: "*2\r\n-NOSCRIPT missing\r\n:1\r\n", err: "NOSCRIPT missing"},
+		{name: "error in nested array", input: "*2\r\n*1\r\n-ERR inner\r\n-ERR outer\r\n", err: "ERR inner"},
+		{name: "truncated line", input: "+OK", err: "io"},
+		{name: "truncated bulk string", input: "$5\r\nhel", err: "io"},
+		{name: "truncated array", input: "*2\r\n:1\r\n", err: "io"},
+		{name: "bulk string without CRLF", input: "$2\r\nhello\r\n", err: "io"},
+		{name: "missing CR", input: "+OK\n", err: "io"},
+		{name: "unknown type", input: "!oops\r\n", err: "io"},
+		{name: "invalid length", input: "$x\r\n", err: "io"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := readReply(bufio.NewReader(strings.NewReader(tt.input)))
+			var re redisError
+			switch {
+			case tt.err == "":
+				if err != nil {
+					t.Fatalf("readReply(%q) failed: %v", tt.input, err)
+				}
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("readReply(%q) = %#v, want %#v", tt.input, got, tt.want)
+				}
+			case tt.err == "io":
+				if err == nil || errors.As(err, &re) {
+					t.Errorf("readReply(%q) = %#v, %v, want a read error", tt.input, got, err)
+				}
+			default:
+				if !errors.As(err, &re) || string(re) != tt.err {
+					t.Errorf("readReply(%q) = %#v, %v, want redis error %q", tt.input, got, err, tt.err)
+				}
+			}
+		})
+	}
+}
+
+// TestReadReplyInStep checks that the reply after an error within an array
+// is read whole, as the connection is kept for the next command
+func TestReadReplyInStep(t *testing.T) {
+	rd := bufio.NewReader(strings.NewReader("*2\r\n-ERR first\r\n$3\r\nfoo\r\n+NEXT\r\n"))
+	if _, err := readReply(rd); err == nil {
+		t.Fatal("array with an error reply succeeded")
+	}
+	if got, err := readReply(rd); err != nil || got != "NEXT" {
+		t.Errorf("next reply = %#v, %v, want NEXT", got, err)
+	}
+}
+
+// TestDoKeepsConnection checks that error replies keep the connection,
+// while failed reads drop it
+func TestDoKeepsConnection(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	c := &redisClient{conn: client, rd: bufio.NewReader(client)}
+
+	replies := []string{"-ERR wrong type\r\n", "$5\r\nhel"}
+	go func() {
+		rd := bufio.NewReader(server)
+		for _, reply := range replies {
+			// Read the command, an array of one bulk string
+			for i := 0; i < 3; i++ {
+				if _, err := rd.ReadString('\n'); err != nil {
+					return
+				}
+			}
+			io.WriteString(server, reply)
+		}
+		server.Close()
+	}()
+
+	_, err := c.do(context.Background(), "GET")
+	var re redisError
+	if !errors.As(err, &re) {
+		t.Fatalf("do = %v, want a redis error", err)
+	}
+	if c.conn == nil {
+		t.Fatal("error reply dropped the connection")
+	}
+
+	if _, err := c.do(context.Background(), "GET"); err == nil || errors.As(err, &re) {
+		t.Fatalf("do = %v, want a read error", err)
+	}
+	if c.conn != nil {
+		t.Error("truncated reply kept the connection")
+	}
+}
+
+// fakeRedis returns a Redis queue whose server answers the commands it
+// receives with replies, in order, and records the commands
+func fakeRedis(t *testing.T, replies ...string) (*Redis, *[][]string) {
+	t.Helper()
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close(); server.Close() })
+
+	var commands [][]string
+	go func() {
+		rd := bufio.NewReader(server)
+		for _, reply := range replies {
+			cmd, err := readReply(rd)
+			if err != nil {
+				return
+			}
+			var args []string
+			for _, arg := range cmd.([]any) {
+				args = append(args, arg.(string))
+			}
+			commands = append(commands, args)
+			io.WriteString(server, reply)
+		}
+	}()
+
+	r := &Redis{
+		client:     &redisClient{conn: client, rd: bufio.NewReader(client)},
+		prefix:     "streaming:",
+		owner:      "librarian-1",
+		visibility: time.Minute,
+		log:        slog.New(slog.NewTextHandler(io.Discard, nil)),
+	}
+	return r, &commands
+}
+
+func TestRedisReplies(t *testing.T) {
+	ctx := context.Background()
+
+	// Lua's false arrives as a nil bulk string
+	r, commands := fakeRedis(t, "$-1\r\n", "$2\r\n17\r\n", "$3\r\nabc\r\n")
+	if id, ok, err := r.Claim(ctx); err != nil || ok {
+		t.Errorf("Claim of an empty queue = %d, %v, %v", id, ok, err)
+	}
+	if id, ok, err := r.Claim(ctx); err != nil || !ok || id != 17 {
+		t.Errorf("Claim = %d, %v, %v, want job 17", id, ok, err)
+	}
+	if _, _, err := r.Claim(ctx); err == nil {
+		t.Error("Claim of an invalid ID succeeded")
+	}
+	claim := (*commands)[1]
+	want := []string{"EVAL", claimScript, "3", "streaming:queue", "streaming:leases", "streaming:owners", "60000", "librarian-1"}
+	if !reflect.DeepEqual(claim, want) {
+		t.Errorf("Claim sent %q, want %q", claim, want)
+	}
+
+	r, _ = fakeRedis(t, ":1\r\n", ":0\r\n", "-BUSY script running\r\n")
+	if err := r.Heartbeat(ctx, 17); err != nil {
+		t.Errorf("Heartbeat = %v", err)
+	}
+	if err := r.Heartbeat(ctx, 17); !errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Heartbeat of a lost claim = %v, want ErrLeaseLost", err)
+	}
+	var re redisError
+	if err := r.Heartbeat(ctx, 17); !errors.As(err, &re) || errors.Is(err, ErrLeaseLost) {
+		t.Errorf("Heartbeat with an error reply = %v, want the redis error", err)
+	}
+
+	r, _ = fakeRedis(t, ":1\r\n", ":0\r\n")
+	if err := r.Ack(ctx, 17); err != nil {
+		t.Errorf("Ack = %v", err)
+	}
+	if err := r.Ack(ctx, 17); err != nil {
+		t.Errorf("Ack of a job taken over = %v, want it left to the other librarian", err)
+	}
+}
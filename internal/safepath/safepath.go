@@ -0,0 +1,68 @@
+// Package safepath resolves file names taken from requests to paths that
+// are confined to a root directory.
+package safepath
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrInvalidPath is returned for names that would leave the root directory
+var ErrInvalidPath = errors.New("invalid path")
+
+// Join returns the path of a slash separated name below root. It rejects
+// names with ".." elements, backslashes or NUL bytes rather than cleaning
+// them, so traversal attempts fail instead of resolving to another file.
+// Symlinks aren't followed, see Resolve.
+func Join(root, name string) (string, error) {
+	if strings.ContainsAny(name, "\\\x00") {
+		return "", ErrInvalidPath
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == ".." {
+			return "", ErrInvalidPath
+		}
+	}
+
+	p := filepath.Join(root, filepath.FromSlash(name))
+	if !Within(root, p) {
+		return "", ErrInvalidPath
+	}
+	return p, nil
+}
+
+// Resolve is like Join, but also follows symlinks and rejects the name if
+// its target is outside root. Errors for missing files match
+// os.ErrNotExist. The returned path is the unresolved one.
+func Resolve(root, name string) (string, error) {
+	p, err := Join(root, name)
+	if err != nil {
+		return "", err
+	}
+
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve root directory: %w", err)
+	}
+	real, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", err
+	}
+	if !Within(realRoot, real) {
+		return "", ErrInvalidPath
+	}
+	return p, nil
+}
+
+// Within reports whether path p is root or below it, comparing the paths
+// lexically without following symlinks
+func Within(root, p string) bool {
+	rel, err := filepath.Rel(filepath.Clean(root), p)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
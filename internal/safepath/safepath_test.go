@@ -0,0 +1,139 @@
+package safepath
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestJoin(t *testing.T) {
+	root := filepath.FromSlash("/media")
+	tests := []struct {
+		name string
+		want string // "" when the name is rejected
+	}{
+		{"movie.mkv", "/media/movie.mkv"},
+		{"Show/Season 1/episode.mkv", "/media/Show/Season 1/episode.mkv"},
+		{"", "/media"},
+		{"./movie.mkv", "/media/movie.mkv"},
+		{"a/./b/../../c", ""},
+		{"..", ""},
+		{"../etc/passwd", ""},
+		{"Show/../../etc/passwd", ""},
+		{"Show/..", ""},
+		{"..\\etc\\passwd", ""},
+		{"movie.mkv\x00.jpg", ""},
+		// Absolute names stay below the root
+		{"/etc/passwd", "/media/etc/passwd"},
+		{"//etc/passwd", "/media/etc/passwd"},
+		// Names arrive decoded, so encoded traversal is a literal name
+		{"%2e%2e/etc/passwd", "/media/%2e%2e/etc/passwd"},
+		{"..%2fetc%2fpasswd", "/media/..%2fetc%2fpasswd"},
+		{"%2e%2e%2f%2e%2e%2fetc", "/media/%2e%2e%2f%2e%2e%2fetc"},
+		// Dots that aren't a whole element are file names
+		{"...", "/media/..."},
+		{"..movie.mkv", "/media/..movie.mkv"},
+	}
+	for _, tt := range tests {
+		got, err := Join(root, tt.name)
+		if tt.want == "" {
+			if !errors.Is(err, ErrInvalidPath) {
+				t.Errorf("Join(%q) = %q, %v, want ErrInvalidPath", tt.name, got, err)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("Join(%q) = %q, %v, want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestWithin(t *testing.T) {
+	tests := []struct {
+		root, p string
+		want    bool
+	}{
+		{"/media", "/media", true},
+		{"/media", "/media/movie.mkv", true},
+		{"/media/", "/media/Show/episode.mkv", true},
+		{"/media", "/media/../etc/passwd", false},
+		{"/media", "/etc/passwd", false},
+		{"/media", "/", false},
+		{"/media", "/media2/movie.mkv", false},
+		{"/media", "/med", false},
+		{"/media", "/media/..movie.mkv", true},
+		{"/media", "relative/movie.mkv", false},
+	}
+	for _, tt := range tests {
+		if got := Within(filepath.FromSlash(tt.root), filepath.FromSlash(tt.p)); got != tt.want {
+			t.Errorf("Within(%q, %q) = %v, want %v", tt.root, tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	root := filepath.Join(dir, "media")
+	outside := filepath.Join(dir, "secret")
+	for _, d := range []string{filepath.Join(root, "Show"), outside} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, f := range []string{filepath.Join(root, "Show", "episode.mkv"), filepath.Join(outside, "passwd")} {
+		if err := os.WriteFile(f, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	links := map[string]string{
+		"inside.mkv":  filepath.Join(root, "Show", "episode.mkv"),
+		"Shows":       filepath.Join(root, "Show"),
+		"escape":      outside,
+		"escape.mkv":  filepath.Join(outside, "passwd"),
+		"relative":    filepath.Join("..", "secret"),
+		"dangling.mk": filepath.Join(root, "missing.mkv"),
+	}
+	for name, target := range links {
+		if err := os.Symlink(target, filepath.Join(root, name)); err != nil {
+			t.Skipf("can't create symlinks: %v", err)
+		}
+	}
+
+	for _, name := range []string{"Show/episode.mkv", "inside.mkv", "Shows/episode.mkv", "Show"} {
+		got, err := Resolve(root, name)
+		if want := filepath.Join(root, filepath.FromSlash(name)); err != nil || got != want {
+			t.Errorf("Resolve(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+
+	for _, name := range []string{"escape", "escape/passwd", "escape.mkv", "relative/passwd", "Show/../escape.mkv", "../secret/passwd", "%2e%2e/secret/passwd"} {
+		got, err := Resolve(root, name)
+		if err == nil {
+			t.Errorf("Resolve(%q) = %q, want an error", name, got)
+		}
+	}
+
+	for _, name := range []string{"missing.mkv", "dangling.mk", "%2e%2e/secret/passwd"} {
+		if _, err := Resolve(root, name); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Resolve(%q) error %v, want os.ErrNotExist", name, err)
+		}
+	}
+	for _, name := range []string{"escape", "escape.mkv", "relative/passwd", "../secret/passwd"} {
+		if _, err := Resolve(root, name); !errors.Is(err, ErrInvalidPath) {
+			t.Errorf("Resolve(%q) error %v, want ErrInvalidPath", name, err)
+		}
+	}
+
+	// A root reached through a symlink still confines names
+	linkedRoot := filepath.Join(dir, "library")
+	if err := os.Symlink(root, linkedRoot); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Resolve(linkedRoot, "Show/episode.mkv"); err != nil {
+		t.Errorf("Resolve below a symlinked root: %v", err)
+	}
+	if _, err := Resolve(linkedRoot, "escape.mkv"); !errors.Is(err, ErrInvalidPath) {
+		t.Errorf("Resolve(escape.mkv) below a symlinked root error %v, want ErrInvalidPath", err)
+	}
+}
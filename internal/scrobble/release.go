@@ -0,0 +1,96 @@
+package scrobble
+
+import (
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Release is what a video file is of, as told by its name: an episode of
+// a show or a movie
+type Release struct {
+	// Title is the title of the movie or the show
+	Title string `json:"title"`
+	// Year is the release year, 0 when the name doesn't tell
+	Year int `json:"year,omitempty"`
+	// Season and Episode number the episodes of shows, both 0 for movies
+	Season  int `json:"season,omitempty"`
+	Episode int `json:"episode,omitempty"`
+}
+
+// IsEpisode reports whether the release is an episode of a show
+func (r Release) IsEpisode() bool {
+	return r.Episode > 0
+}
+
+var (
+	// episodePatterns match "Show S01E02" and "Show 1x02" names, the show
+	// being the first group
+	episodePatterns = []*regexp.Regexp{
+		regexp.MustCompile(`(?i)^(.*?)[\s._-]*\bs(\d{1,2})[\s._-]*e(\d{1,3})\b`),
+		regexp.MustCompile(`(?i)^(.*?)[\s._-]*\b(\d{1,2})x(\d{2,3})\b`),
+	}
+	// yearPattern matches the year after a title, in parentheses or not
+	yearPattern = regexp.MustCompile(`^(.*?)[\s._(\[-]+((?:19|20)\d{2})(?:[\s._)\]-]|$)`)
+	// seasonFolder matches the "Season 1" folders episodes are sorted in
+	seasonFolder = regexp.MustCompile(`(?i)^(season|series|staffel|saison)[\s._-]*\d+$|^s\d{1,2}$`)
+)
+
+// ParseRelease tells what a video file is of from its path, following the
+// naming of Plex, Jellyfin and release groups: "Show (2019) S01E02.mkv"
+// or "Show/Season 1/1x02.mkv" for episodes and "Movie (2020).mkv" for
+// movies. Names matching neither are movies without a year.
+func ParseRelease(path string) Release {
+	name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	for _, p := range episodePatterns {
+		m := p.FindStringSubmatch(name)
+		if m == nil {
+			continue
+		}
+		r := Release{}
+		r.Season, _ = strconv.Atoi(m[2])
+		r.Episode, _ = strconv.Atoi(m[3])
+		r.Title, r.Year = titleAndYear(m[1])
+		// Episodes named by number only take the show from their folder
+		if r.Title == "" {
+			r.Title, r.Year = titleAndYear(showFolder(path))
+		}
+		return r
+	}
+
+	r := Release{}
+	r.Title, r.Year = titleAndYear(name)
+	return r
+}
+
+// titleAndYear splits a name into the title and the year that follows
+// it, dropping the rest, such as the release tags after the year
+func titleAndYear(name string) (string, int) {
+	if m := yearPattern.FindStringSubmatch(name); m != nil && cleanTitle(m[1]) != "" {
+		year, _ := strconv.Atoi(m[2])
+		return cleanTitle(m[1]), year
+	}
+	return cleanTitle(name), 0
+}
+
+// cleanTitle turns the dots and underscores of release names into spaces
+func cleanTitle(s string) string {
+	s = strings.NewReplacer(".", " ", "_", " ").Replace(s)
+	return strings.Join(strings.Fields(strings.Trim(s, " -([")), " ")
+}
+
+// showFolder returns the folder of an episode named after its show,
+// skipping season folders
+func showFolder(path string) string {
+	dir := filepath.Dir(path)
+	for i := 0; i < 2 && dir != "." && dir != string(filepath.Separator); i++ {
+		name := filepath.Base(dir)
+		if !seasonFolder.MatchString(name) {
+			return name
+		}
+		dir = filepath.Dir(dir)
+	}
+	return ""
+}
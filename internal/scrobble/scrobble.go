@@ -0,0 +1,200 @@
+// Package scrobble sends the videos users watched to the end to services
+// keeping their watch history, such as Trakt. The watch history in the
+// database is the source of truth: every target keeps track of the last
+// watch it was sent, so watches recorded while a service was down, or
+// before a target was added, are sent once it can be reached.
+package scrobble
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// Target types
+const (
+	TypeTrakt   = "trakt"
+	TypeWebhook = "webhook"
+)
+
+// pollInterval is how often the watch history is checked for watches to
+// send
+const pollInterval = time.Minute
+
+// batchSize is the most watches sent to a target at once
+const batchSize = 100
+
+// maxBackoff caps the time between attempts to reach a failing target.
+// The wait starts at pollInterval and doubles.
+const maxBackoff = time.Hour
+
+// sender sends watches to a service
+type sender interface {
+	// send sends watches, oldest first, returning how many of the first
+	// ones were sent
+	send(ctx context.Context, watches []*database.Watch) (int, error)
+}
+
+// target is a validated scrobble target
+type target struct {
+	config.ScrobbleTarget
+	sender sender
+	// retryAt is when a failing target is tried again, backoff the wait
+	// after the next failure
+	retryAt time.Time
+	backoff time.Duration
+}
+
+// Scrobbler sends the watch history to the configured targets
+type Scrobbler struct {
+	db      *database.DB
+	targets []*target
+	log     *slog.Logger
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New validates the configured targets and creates a scrobbler for them.
+// A scrobbler without targets sends nothing, failed deliveries are logged
+// to logger.
+func New(targets []config.ScrobbleTarget, db *database.DB, logger *slog.Logger) (*Scrobbler, error) {
+	s := &Scrobbler{db: db, log: logger}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	names := make(map[string]bool, len(targets))
+	for _, cfg := range targets {
+		if cfg.Name == "" {
+			return nil, fmt.Errorf("scrobble target of type %q needs a name", cfg.Type)
+		}
+		if names[cfg.Name] {
+			return nil, fmt.Errorf("scrobble target %q is configured twice", cfg.Name)
+		}
+		names[cfg.Name] = true
+
+		t := &target{ScrobbleTarget: cfg}
+		switch cfg.Type {
+		case TypeTrakt:
+			if cfg.ClientID == "" || cfg.ClientSecret == "" {
+				return nil, fmt.Errorf("scrobble target %q needs the client_id and client_secret of a Trakt API app", cfg.Name)
+			}
+			t.sender = &trakt{cfg: cfg, db: db, client: client, log: logger}
+		case TypeWebhook:
+			w, err := newWebhook(cfg, client)
+			if err != nil {
+				return nil, fmt.Errorf("scrobble target %q %w", cfg.Name, err)
+			}
+			t.sender = w
+		default:
+			return nil, fmt.Errorf("scrobble target %q has unknown type %q", cfg.Name, cfg.Type)
+		}
+		s.targets = append(s.targets, t)
+	}
+
+	return s, nil
+}
+
+// Start sends new watches to the targets in the background until Stop is
+// called
+func (s *Scrobbler) Start() {
+	if len(s.targets) == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	s.wg.Add(1)
+	go s.run(ctx)
+}
+
+// Stop stops sending watches, waiting for the running delivery
+func (s *Scrobbler) Stop() {
+	if s.cancel == nil {
+		return
+	}
+	s.cancel()
+	s.wg.Wait()
+}
+
+// run checks for new watches every pollInterval until ctx is cancelled
+func (s *Scrobbler) run(ctx context.Context) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		for _, t := range s.targets {
+			if time.Now().Before(t.retryAt) {
+				continue
+			}
+			if err := s.scrobble(ctx, t); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				t.backoff = min(max(2*t.backoff, pollInterval), maxBackoff)
+				t.retryAt = time.Now().Add(t.backoff)
+				s.log.Warn("Error sending watch history", "target", t.Name, "err", err, "retry_at", t.retryAt.Format(time.DateTime))
+				continue
+			}
+			t.backoff = 0
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// scrobble sends the watches a target hasn't received yet, a batch at a
+// time. Watches of users the target isn't for are skipped.
+func (s *Scrobbler) scrobble(ctx context.Context, t *target) error {
+	last, err := s.db.ScrobbledUpTo(t.Name)
+	if err != nil {
+		return err
+	}
+	for {
+		watches, err := s.db.WatchesAfter(last, batchSize)
+		if err != nil || len(watches) == 0 {
+			return err
+		}
+
+		var send []*database.Watch
+		for _, w := range watches {
+			if t.Sends(w.User) {
+				send = append(send, w)
+			}
+		}
+		// Skipped watches past the last one sent count as sent
+		upTo := watches[len(watches)-1].ID
+		sent := 0
+		if len(send) > 0 {
+			sent, err = t.sender.send(ctx, send)
+			if err != nil {
+				upTo = last
+				if sent > 0 {
+					upTo = send[sent-1].ID
+				}
+			}
+		}
+		if upTo > last {
+			if err := s.db.SetScrobbledUpTo(t.Name, upTo); err != nil {
+				return err
+			}
+			if sent > 0 {
+				s.log.Info("Sent watch history", "target", t.Name, "watches", sent)
+			}
+			last = upTo
+		}
+		if err != nil {
+			return err
+		}
+		if len(watches) < batchSize {
+			return nil
+		}
+	}
+}
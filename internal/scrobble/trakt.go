@@ -0,0 +1,320 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// traktAPI is the base URL of the Trakt API
+const traktAPI = "https://api.trakt.tv"
+
+// traktRedirectURI is the redirect URI of apps without one, which Trakt
+// expects when refreshing tokens
+const traktRedirectURI = "urn:ietf:wg:oauth:2.0:oob"
+
+// tokenRefreshMargin is how long before it expires an access token is
+// refreshed
+const tokenRefreshMargin = 24 * time.Hour
+
+// ErrNotLoggedIn is returned when a Trakt target has no access token yet
+var ErrNotLoggedIn = errors.New("not logged in to Trakt, run \"streaming scrobble login\"")
+
+// errUnauthorized is returned when Trakt refuses the access token
+var errUnauthorized = errors.New("access token refused")
+
+// trakt adds watches to the history of a Trakt account
+type trakt struct {
+	cfg    config.ScrobbleTarget
+	db     *database.DB
+	client *http.Client
+	log    *slog.Logger
+}
+
+// traktMovie and traktShow are the items of history requests, matched by
+// title and year as the library doesn't know their Trakt IDs
+type traktMovie struct {
+	Title     string    `json:"title"`
+	Year      int       `json:"year,omitempty"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+type traktShow struct {
+	Title   string        `json:"title"`
+	Year    int           `json:"year,omitempty"`
+	Seasons []traktSeason `json:"seasons"`
+}
+
+type traktSeason struct {
+	Number   int            `json:"number"`
+	Episodes []traktEpisode `json:"episodes"`
+}
+
+type traktEpisode struct {
+	Number    int       `json:"number"`
+	WatchedAt time.Time `json:"watched_at"`
+}
+
+// traktHistory is the body of history requests
+type traktHistory struct {
+	Movies []traktMovie `json:"movies,omitempty"`
+	Shows  []traktShow  `json:"shows,omitempty"`
+}
+
+// traktHistoryResult is the answer to history requests
+type traktHistoryResult struct {
+	Added struct {
+		Movies   int `json:"movies"`
+		Episodes int `json:"episodes"`
+	} `json:"added"`
+	NotFound struct {
+		Movies []traktMovie `json:"movies"`
+		Shows  []traktShow  `json:"shows"`
+	} `json:"not_found"`
+}
+
+// traktToken is the answer to token requests
+type traktToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	CreatedAt    int64  `json:"created_at"`
+}
+
+// history builds the history request of watches, grouping episodes by
+// show and season
+func history(watches []*database.Watch) traktHistory {
+	var h traktHistory
+	shows := make(map[Release]int)
+	for _, w := range watches {
+		r := ParseRelease(w.Path)
+		if !r.IsEpisode() {
+			h.Movies = append(h.Movies, traktMovie{Title: r.Title, Year: r.Year, WatchedAt: w.WatchedAt.UTC()})
+			continue
+		}
+
+		key := Release{Title: r.Title, Year: r.Year}
+		i, ok := shows[key]
+		if !ok {
+			i = len(h.Shows)
+			shows[key] = i
+			h.Shows = append(h.Shows, traktShow{Title: r.Title, Year: r.Year})
+		}
+		show := &h.Shows[i]
+		var season *traktSeason
+		for j := range show.Seasons {
+			if show.Seasons[j].Number == r.Season {
+				season = &show.Seasons[j]
+			}
+		}
+		if season == nil {
+			show.Seasons = append(show.Seasons, traktSeason{Number: r.Season})
+			season = &show.Seasons[len(show.Seasons)-1]
+		}
+		season.Episodes = append(season.Episodes, traktEpisode{Number: r.Episode, WatchedAt: w.WatchedAt.UTC()})
+	}
+	return h
+}
+
+// send adds the watches to the history of the account. Videos Trakt
+// doesn't know by their title are logged and skipped.
+func (t *trakt) send(ctx context.Context, watches []*database.Watch) (int, error) {
+	token, err := t.token(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	var result traktHistoryResult
+	err = t.post(ctx, "/sync/history", token, history(watches), &result)
+	if errors.Is(err, errUnauthorized) {
+		// The token may have been revoked or expired early
+		if token, err = t.refresh(ctx); err != nil {
+			return 0, err
+		}
+		err = t.post(ctx, "/sync/history", token, history(watches), &result)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	for _, m := range result.NotFound.Movies {
+		t.log.Warn("Trakt doesn't know watched movie", "target", t.cfg.Name, "title", m.Title, "year", m.Year)
+	}
+	for _, s := range result.NotFound.Shows {
+		t.log.Warn("Trakt doesn't know watched show", "target", t.cfg.Name, "title", s.Title, "year", s.Year)
+	}
+	return len(watches), nil
+}
+
+// token returns the access token of the account, refreshing it when it is
+// about to expire
+func (t *trakt) token(ctx context.Context) (string, error) {
+	tok, err := t.db.GetScrobbleToken(t.cfg.Name)
+	if err != nil {
+		return "", err
+	}
+	if tok == nil {
+		return "", ErrNotLoggedIn
+	}
+	if time.Until(tok.ExpiresAt) < tokenRefreshMargin {
+		return t.refresh(ctx)
+	}
+	return tok.AccessToken, nil
+}
+
+// refresh trades the refresh token for a new access token
+func (t *trakt) refresh(ctx context.Context) (string, error) {
+	tok, err := t.db.GetScrobbleToken(t.cfg.Name)
+	if err != nil {
+		return "", err
+	}
+	if tok == nil || tok.RefreshToken == "" {
+		return "", ErrNotLoggedIn
+	}
+
+	var fresh traktToken
+	err = t.post(ctx, "/oauth/token", "", map[string]string{
+		"refresh_token": tok.RefreshToken,
+		"client_id":     t.cfg.ClientID,
+		"client_secret": t.cfg.ClientSecret,
+		"redirect_uri":  traktRedirectURI,
+		"grant_type":    "refresh_token",
+	}, &fresh)
+	if errors.Is(err, errUnauthorized) {
+		return "", fmt.Errorf("%w, the refresh token was refused", ErrNotLoggedIn)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to refresh Trakt token: %w", err)
+	}
+	if err := storeToken(t.db, t.cfg.Name, fresh); err != nil {
+		return "", err
+	}
+	return fresh.AccessToken, nil
+}
+
+// storeToken stores the tokens Trakt handed out
+func storeToken(db *database.DB, name string, tok traktToken) error {
+	return db.SetScrobbleToken(name, &database.ScrobbleToken{
+		AccessToken:  tok.AccessToken,
+		RefreshToken: tok.RefreshToken,
+		ExpiresAt:    time.Unix(tok.CreatedAt+tok.ExpiresIn, 0),
+	})
+}
+
+// post sends a JSON request to the Trakt API, with the access token when
+// it isn't "", decoding the JSON answer into out
+func (t *trakt) post(ctx context.Context, path, token string, body, out any) error {
+	_, err := traktPost(ctx, t.client, t.cfg.ClientID, path, token, body, out)
+	return err
+}
+
+// traktPost sends a JSON request to the Trakt API, returning the status
+// of the answer. Answers other than 2xx are errors.
+func traktPost(ctx context.Context, client *http.Client, clientID, path, token string, body, out any) (int, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, traktAPI+path, bytes.NewReader(b))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("trakt-api-version", "2")
+	req.Header.Set("trakt-api-key", clientID)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusUnauthorized:
+		return resp.StatusCode, errUnauthorized
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	case out == nil:
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("invalid answer from Trakt: %w", err)
+	}
+	return resp.StatusCode, nil
+}
+
+// DeviceCode is the code a user enters on the Trakt website to let a
+// target add to the history of their account
+type DeviceCode struct {
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	DeviceCode      string `json:"device_code"`
+	ExpiresIn       int    `json:"expires_in"`
+	Interval        int    `json:"interval"`
+}
+
+// Login logs a Trakt target in to an account with the device flow of
+// Trakt: show is called with the code the user enters on the Trakt
+// website, after which Login waits for them to approve, storing the
+// tokens in db
+func Login(ctx context.Context, cfg config.ScrobbleTarget, db *database.DB, show func(DeviceCode)) error {
+	if cfg.Type != TypeTrakt {
+		return fmt.Errorf("scrobble target %q isn't a Trakt target", cfg.Name)
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+
+	var code DeviceCode
+	if _, err := traktPost(ctx, client, cfg.ClientID, "/oauth/device/code", "", map[string]string{"client_id": cfg.ClientID}, &code); err != nil {
+		return fmt.Errorf("failed to get a Trakt device code: %w", err)
+	}
+	show(code)
+
+	interval := time.Duration(max(code.Interval, 1)) * time.Second
+	deadline := time.Now().Add(time.Duration(code.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+
+		var tok traktToken
+		status, err := traktPost(ctx, client, cfg.ClientID, "/oauth/device/token", "", map[string]string{
+			"code":          code.DeviceCode,
+			"client_id":     cfg.ClientID,
+			"client_secret": cfg.ClientSecret,
+		}, &tok)
+		switch status {
+		case http.StatusOK:
+			if err != nil {
+				return err
+			}
+			return storeToken(db, cfg.Name, tok)
+		case http.StatusBadRequest:
+			// The user hasn't approved yet
+		case http.StatusTooManyRequests:
+			interval += time.Second
+		case http.StatusNotFound, http.StatusConflict:
+			return errors.New("the Trakt device code is invalid or was already used")
+		case http.StatusGone:
+			return errors.New("the Trakt device code expired")
+		case http.StatusTeapot:
+			return errors.New("access was denied on Trakt")
+		default:
+			return fmt.Errorf("failed to log in to Trakt: %w", err)
+		}
+	}
+	return errors.New("the Trakt device code expired")
+}
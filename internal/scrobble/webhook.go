@@ -0,0 +1,90 @@
+package scrobble
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/database"
+)
+
+// WebhookEvent is the JSON body webhook targets receive for every watch
+type WebhookEvent struct {
+	Event     string    `json:"event"`
+	User      string    `json:"user"`
+	VideoID   int64     `json:"video_id"`
+	Filename  string    `json:"filename"`
+	Path      string    `json:"path"`
+	Duration  float64   `json:"duration"`
+	WatchedAt time.Time `json:"watched_at"`
+	// Release is what the video is of, as told by its file name
+	Release Release `json:"release"`
+}
+
+// webhook posts every watch to a URL as JSON
+type webhook struct {
+	cfg    config.ScrobbleTarget
+	client *http.Client
+}
+
+// newWebhook checks the URL of a webhook target
+func newWebhook(cfg config.ScrobbleTarget, client *http.Client) (*webhook, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("needs an http or https url")
+	}
+	return &webhook{cfg: cfg, client: client}, nil
+}
+
+// send posts the watches one at a time, stopping at the first failure
+func (wh *webhook) send(ctx context.Context, watches []*database.Watch) (int, error) {
+	for i, w := range watches {
+		if err := wh.post(ctx, w); err != nil {
+			return i, err
+		}
+	}
+	return len(watches), nil
+}
+
+// post posts a watch
+func (wh *webhook) post(ctx context.Context, w *database.Watch) error {
+	body, err := json.Marshal(WebhookEvent{
+		Event:     "watched",
+		User:      w.User,
+		VideoID:   w.VideoID,
+		Filename:  w.Filename,
+		Path:      w.Path,
+		Duration:  w.Duration,
+		WatchedAt: w.WatchedAt.UTC(),
+		Release:   ParseRelease(w.Path),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// propfindBody asks a WebDAV server for the properties Scan needs
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<propfind xmlns="DAV:"><prop><resourcetype/><getcontentlength/></prop></propfind>`
+
+// davMultistatus is the response to PROPFIND
+type davMultistatus struct {
+	Responses []davResponse `xml:"DAV: response"`
+}
+
+type davResponse struct {
+	Href      string        `xml:"DAV: href"`
+	Propstats []davPropstat `xml:"DAV: propstat"`
+}
+
+type davPropstat struct {
+	Status string  `xml:"DAV: status"`
+	Prop   davProp `xml:"DAV: prop"`
+}
+
+type davProp struct {
+	ResourceType  davResourceType `xml:"DAV: resourcetype"`
+	ContentLength int64           `xml:"DAV: getcontentlength"`
+}
+
+type davResourceType struct {
+	Collection *struct{} `xml:"DAV: collection"`
+}
+
+// propfind lists a directory of a WebDAV source
+func (s *httpSource) propfind(ctx context.Context, dir string) ([]sourceEntry, error) {
+	header := http.Header{
+		"Depth":        {"1"},
+		"Content-Type": {"application/xml; charset=utf-8"},
+	}
+	_, data, err := s.do(ctx, "PROPFIND", s.fileURL(dir), header, propfindBody)
+	if err != nil {
+		return nil, err
+	}
+	var ms davMultistatus
+	if err := xml.Unmarshal(data, &ms); err != nil {
+		return nil, fmt.Errorf("failed to decode the listing of %s: %w", s.fileURL(dir).Redacted(), err)
+	}
+
+	var entries []sourceEntry
+	for _, resp := range ms.Responses {
+		rel, ok := s.relativeLink(s.fileURL(dir), resp.Href)
+		if !ok || rel == dir {
+			continue
+		}
+		for _, ps := range resp.Propstats {
+			if !strings.Contains(ps.Status, " 200 ") {
+				continue
+			}
+			e := sourceEntry{rel: rel, size: ps.Prop.ContentLength}
+			if ps.Prop.ResourceType.Collection != nil {
+				e.dir = true
+				if !strings.HasSuffix(e.rel, "/") {
+					e.rel += "/"
+				}
+			}
+			entries = append(entries, e)
+			break
+		}
+	}
+	return entries, nil
+}
+
+// linkPattern matches the links of directory listing pages
+var linkPattern = regexp.MustCompile(`(?i)href\s*=\s*["']([^"']+)["']`)
+
+// listing lists a directory of a web server by following the links of its
+// listing page. Only links below the directory count, which leaves out
+// the parent directory and sorting links. The sizes of files are taken
+// from HEAD requests.
+func (s *httpSource) listing(ctx context.Context, dir string) ([]sourceEntry, error) {
+	_, data, err := s.do(ctx, http.MethodGet, s.fileURL(dir), nil, "")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []sourceEntry
+	seen := make(map[string]bool)
+	for _, match := range linkPattern.FindAllStringSubmatch(string(data), -1) {
+		href := html.UnescapeString(match[1])
+		if strings.ContainsAny(href, "?#") {
+			continue
+		}
+		rel, ok := s.relativeLink(s.fileURL(dir), href)
+		if !ok || !strings.HasPrefix(rel, dir) || rel == dir || seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		if strings.HasSuffix(rel, "/") {
+			entries = append(entries, sourceEntry{rel: rel, dir: true})
+			continue
+		}
+		resp, _, err := s.do(ctx, http.MethodHead, s.fileURL(rel), nil, "")
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, sourceEntry{rel: rel, size: resp.ContentLength})
+	}
+	return entries, nil
+}
+
+// relativeLink resolves a link found in the listing of the directory at
+// dirURL and returns its path relative to the base URL, reporting false
+// for links outside of it
+func (s *httpSource) relativeLink(dirURL *url.URL, href string) (string, bool) {
+	ref, err := url.Parse(href)
+	if err != nil {
+		return "", false
+	}
+	u := dirURL.ResolveReference(ref)
+	if u.Host != s.base.Host {
+		return "", false
+	}
+	rel, ok := strings.CutPrefix(u.Path, s.base.Path)
+	if !ok || strings.Contains("/"+rel, "/../") {
+		return "", false
+	}
+	return rel, true
+}
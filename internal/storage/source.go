@@ -0,0 +1,336 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// sourceURLExpiry is how long the presigned URLs FFmpeg reads videos in
+// S3 sources from are valid, long enough for the slowest transcode
+const sourceURLExpiry = 24 * time.Hour
+
+// sourceRequestTimeout limits each request made while scanning a source
+const sourceRequestTimeout = time.Minute
+
+// sourceContentTypes maps the extensions of source videos to their
+// content types
+var sourceContentTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".mkv":  "video/x-matroska",
+	".avi":  "video/x-msvideo",
+	".mov":  "video/quicktime",
+	".webm": "video/webm",
+	".flv":  "video/x-flv",
+	".wmv":  "video/x-ms-wmv",
+}
+
+// SourceContentType returns the content type of a source video
+func SourceContentType(p string) string {
+	if ct, ok := sourceContentTypes[strings.ToLower(filepath.Ext(p))]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
+
+// Source lists and reads the videos of a library kept outside the local
+// file system. Paths are those of the videos in the library, below the
+// Root of the source config.
+type Source interface {
+	// Scan lists every file of the source, videos or not
+	Scan(ctx context.Context) ([]SourceFile, error)
+	// Input returns the URL FFmpeg reads a video from
+	Input(path string) (string, error)
+	// Serve answers a request for the original of a video
+	Serve(w http.ResponseWriter, r *http.Request, path string)
+}
+
+// SourceFile is a file found by Source.Scan
+type SourceFile struct {
+	Path string
+	Size int64
+}
+
+// NewSource returns the source a remote library reads its videos from
+func NewSource(lib config.MediaLibrary) (Source, error) {
+	src := lib.Source
+	root := src.Root()
+	switch src.Type {
+	case "s3":
+		s := src.S3
+		endpoint, err := url.Parse(s.EndpointURL())
+		if err != nil {
+			return nil, fmt.Errorf("invalid source.s3.endpoint: %w", err)
+		}
+		prefix := strings.Trim(s.Prefix, "/")
+		if prefix != "" {
+			prefix += "/"
+		}
+		return &s3Source{
+			client: &s3Client{
+				endpoint:  endpoint,
+				region:    s.Region,
+				bucket:    s.Bucket,
+				accessKey: s.AccessKey,
+				secretKey: s.SecretKey,
+				pathStyle: s.PathStyle,
+				http:      &http.Client{Timeout: 5 * time.Minute},
+			},
+			root:   root,
+			prefix: prefix,
+		}, nil
+	case "webdav", "http":
+		base, err := url.Parse(src.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid source.url: %w", err)
+		}
+		if !strings.HasSuffix(base.Path, "/") {
+			base.Path += "/"
+		}
+		base.RawPath = ""
+		return &httpSource{
+			base:     base,
+			root:     root,
+			username: src.Username,
+			password: src.Password,
+			webdav:   src.Type == "webdav",
+			http:     &http.Client{},
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown source type %q", src.Type)
+}
+
+// Input returns what FFmpeg reads a video from: its path, or a URL for
+// videos of remote libraries
+func Input(cfg *config.Config, path string) (string, error) {
+	lib, ok := cfg.LibraryFor(path)
+	if !ok || !lib.Remote() {
+		return path, nil
+	}
+	src, err := NewSource(lib)
+	if err != nil {
+		return "", err
+	}
+	return src.Input(path)
+}
+
+// relativePath returns the slash separated path of a video below root
+func relativePath(root, p string) (string, error) {
+	rel, ok := strings.CutPrefix(p, root+"/")
+	if !ok || rel == "" {
+		return "", fmt.Errorf("%s is not in %s", p, root)
+	}
+	return rel, nil
+}
+
+// s3Source reads videos from an S3 compatible bucket
+type s3Source struct {
+	client *s3Client
+	root   string
+	// prefix is the folder of the videos in the bucket, empty or ending
+	// with a slash
+	prefix string
+}
+
+// Scan lists the objects below the prefix
+func (s *s3Source) Scan(ctx context.Context) ([]SourceFile, error) {
+	objects, err := s.client.list(ctx, s.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the bucket: %w", err)
+	}
+	files := make([]SourceFile, 0, len(objects))
+	for _, obj := range objects {
+		rel := strings.TrimPrefix(obj.Key, s.prefix)
+		if rel == "" || strings.HasSuffix(rel, "/") {
+			continue
+		}
+		files = append(files, SourceFile{Path: path.Join(s.root, rel), Size: obj.Size})
+	}
+	return files, nil
+}
+
+// Input returns a presigned URL of the video
+func (s *s3Source) Input(p string) (string, error) {
+	rel, err := relativePath(s.root, p)
+	if err != nil {
+		return "", err
+	}
+	return s.client.presign(s.prefix+rel, sourceURLExpiry, time.Now()), nil
+}
+
+// Serve redirects to a presigned URL of the video
+func (s *s3Source) Serve(w http.ResponseWriter, r *http.Request, p string) {
+	input, err := s.Input(p)
+	if err != nil {
+		http.Error(w, "Source file not found", http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	http.Redirect(w, r, input, http.StatusFound)
+}
+
+// httpSource reads videos from a WebDAV server, or from a web server with
+// directory listings
+type httpSource struct {
+	// base is the URL of the directory the videos are in, ending with a
+	// slash
+	base     *url.URL
+	root     string
+	username string
+	password string
+	// webdav lists directories with PROPFIND rather than by following the
+	// links of the listing pages
+	webdav bool
+	http   *http.Client
+}
+
+// fileURL returns the URL of a file below the base directory
+func (s *httpSource) fileURL(rel string) *url.URL {
+	u := *s.base
+	u.Path += rel
+	return &u
+}
+
+// newRequest creates a request with the credentials of the source
+func (s *httpSource) newRequest(ctx context.Context, method string, u *url.URL, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), body)
+	if err != nil {
+		return nil, err
+	}
+	if s.username != "" {
+		req.SetBasicAuth(s.username, s.password)
+	}
+	return req, nil
+}
+
+// do sends a request made by newRequest, timing it out with
+// sourceRequestTimeout, and reads the response body
+func (s *httpSource) do(ctx context.Context, method string, u *url.URL, header http.Header, body string) (*http.Response, []byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, sourceRequestTimeout)
+	defer cancel()
+	req, err := s.newRequest(ctx, method, u, strings.NewReader(body))
+	if err != nil {
+		return nil, nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 16<<20))
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s %s: %w", method, u.Redacted(), err)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, nil, fmt.Errorf("%s %s: %s", method, u.Redacted(), resp.Status)
+	}
+	return resp, data, nil
+}
+
+// Scan walks the directories below the base URL
+func (s *httpSource) Scan(ctx context.Context) ([]SourceFile, error) {
+	var files []SourceFile
+	dirs := []string{""}
+	seen := map[string]bool{"": true}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+
+		var entries []sourceEntry
+		var err error
+		if s.webdav {
+			entries, err = s.propfind(ctx, dir)
+		} else {
+			entries, err = s.listing(ctx, dir)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			if e.dir {
+				if !seen[e.rel] {
+					seen[e.rel] = true
+					dirs = append(dirs, e.rel)
+				}
+				continue
+			}
+			files = append(files, SourceFile{Path: path.Join(s.root, e.rel), Size: e.size})
+		}
+	}
+	return files, nil
+}
+
+// sourceEntry is a file or directory listed in a directory of a source,
+// relative to the base URL and with directories ending in a slash
+type sourceEntry struct {
+	rel  string
+	dir  bool
+	size int64
+}
+
+// Input returns the URL of the video with the credentials of the source,
+// which FFmpeg sends as basic authentication
+func (s *httpSource) Input(p string) (string, error) {
+	rel, err := relativePath(s.root, p)
+	if err != nil {
+		return "", err
+	}
+	u := s.fileURL(rel)
+	if s.username != "" {
+		u.User = url.UserPassword(s.username, s.password)
+	}
+	return u.String(), nil
+}
+
+// Serve proxies the video including range and conditional requests, as
+// players can't be sent the credentials
+func (s *httpSource) Serve(w http.ResponseWriter, r *http.Request, p string) {
+	rel, err := relativePath(s.root, p)
+	if err != nil {
+		http.Error(w, "Source file not found", http.StatusNotFound)
+		return
+	}
+	req, err := s.newRequest(r.Context(), r.Method, s.fileURL(rel), nil)
+	if err != nil {
+		http.Error(w, "Error reading source file", http.StatusInternalServerError)
+		return
+	}
+	for _, name := range []string{"Range", "If-Range", "If-None-Match", "If-Modified-Since"} {
+		if v := r.Header.Get(name); v != "" {
+			req.Header.Set(name, v)
+		}
+	}
+	resp, err := s.http.Do(req)
+	if err != nil {
+		http.Error(w, "Error reading source file", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		http.Error(w, "Source file not found", http.StatusNotFound)
+		return
+	case resp.StatusCode >= 400:
+		http.Error(w, "Error reading source file", http.StatusBadGateway)
+		return
+	}
+	for _, header := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
@@ -0,0 +1,251 @@
+// Package storage keeps the segments of the transcoding cache, either in
+// the cache directory or in S3 compatible object storage, and reads the
+// videos of libraries kept in object storage or on web servers.
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// Store keeps the segments of the published cache directories. Playlists
+// and artwork always stay in the cache directory, where the server reads
+// them to filter variants by device and to extract artwork. Names and
+// directories are relative to the cache root and slash separated.
+type Store interface {
+	// Offload moves the segments whose names start with prefix, such as
+	// those of a cache directory when it ends with a slash, into the store
+	Offload(ctx context.Context, prefix string) error
+	// Holds reports whether a file missing from the cache directory is
+	// kept by the store
+	Holds(name string) bool
+	// Serve answers a request for a file the store holds
+	Serve(w http.ResponseWriter, r *http.Request, name string)
+	// Remove deletes what the store keeps of a cache directory and
+	// returns the number of bytes freed
+	Remove(ctx context.Context, dir string) (int64, error)
+	// Usage returns the number of bytes the store keeps of each cache
+	// directory
+	Usage(ctx context.Context) (map[string]int64, error)
+}
+
+// New returns the store cache.backend selects, logging to logger
+func New(cfg *config.Config, logger *slog.Logger) (Store, error) {
+	if cfg.Cache.Backend != "s3" {
+		return Local{}, nil
+	}
+
+	s := cfg.Cache.S3
+	endpoint, err := url.Parse(s.EndpointURL())
+	if err != nil {
+		return nil, fmt.Errorf("invalid cache.s3.endpoint: %w", err)
+	}
+	return &S3{
+		client: &s3Client{
+			endpoint:  endpoint,
+			region:    s.Region,
+			bucket:    s.Bucket,
+			accessKey: s.AccessKey,
+			secretKey: s.SecretKey,
+			pathStyle: s.PathStyle,
+			http:      &http.Client{Timeout: 5 * time.Minute},
+		},
+		cacheDir: cfg.Media.CacheDir,
+		prefix:   s.Prefix,
+		proxy:    s.Serve == "proxy",
+		expiry:   time.Duration(s.URLExpiryMinutes) * time.Minute,
+		log:      logger,
+	}, nil
+}
+
+// Local keeps everything in the cache directory
+type Local struct{}
+
+// Offload does nothing, the segments stay where they are
+func (Local) Offload(ctx context.Context, prefix string) error { return nil }
+
+// Holds reports false, files missing from the cache directory are missing
+func (Local) Holds(name string) bool { return false }
+
+// Serve answers 404, Local holds nothing
+func (Local) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	http.Error(w, "File not found", http.StatusNotFound)
+}
+
+// Remove does nothing, removing the cache directory removes everything
+func (Local) Remove(ctx context.Context, dir string) (int64, error) { return 0, nil }
+
+// Usage reports nothing, the cache directory is measured on disk
+func (Local) Usage(ctx context.Context) (map[string]int64, error) {
+	return map[string]int64{}, nil
+}
+
+// S3 keeps the segments in an S3 compatible bucket, under the key of
+// their path in the cache directory
+type S3 struct {
+	client   *s3Client
+	cacheDir string
+	prefix   string
+	// proxy streams segments through the server rather than redirecting
+	// players to presigned URLs
+	proxy  bool
+	expiry time.Duration
+	log    *slog.Logger
+}
+
+// isSegment reports whether a file of the HLS output is a segment, or the
+// init segment of fMP4 output, which are kept in the bucket
+func isSegment(name string) bool {
+	switch path.Ext(name) {
+	case ".ts", ".m4s", ".mp4":
+		return true
+	}
+	return false
+}
+
+// segmentContentTypes are the content types segments are uploaded with
+var segmentContentTypes = map[string]string{
+	".ts":  "video/MP2T",
+	".m4s": "video/iso.segment",
+	".mp4": "video/mp4",
+}
+
+// key returns the object key of a file in the cache directory
+func (s *S3) key(name string) string {
+	return s.prefix + name
+}
+
+// Offload uploads the segments whose names start with prefix and removes
+// them from the disk. Segments a previous transcode left in the bucket
+// under prefix are removed first. Uploaded segments are only removed from
+// the disk once they are in the bucket, so they can be served throughout.
+func (s *S3) Offload(ctx context.Context, prefix string) error {
+	if _, err := s.removePrefix(ctx, prefix); err != nil {
+		return err
+	}
+
+	dir, _ := path.Split(prefix)
+	root := filepath.Join(s.cacheDir, filepath.FromSlash(dir))
+	return filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !isSegment(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(s.cacheDir, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if err := s.client.put(ctx, s.key(name), segmentContentTypes[path.Ext(name)], f, info.Size()); err != nil {
+			return fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+		return os.Remove(p)
+	})
+}
+
+// Holds reports whether name is a segment, which are kept in the bucket
+func (s *S3) Holds(name string) bool {
+	return isSegment(name)
+}
+
+// Serve redirects to a presigned URL of a segment, or proxies it
+// including range and conditional requests
+func (s *S3) Serve(w http.ResponseWriter, r *http.Request, name string) {
+	if !s.proxy {
+		// The URL expires, so the redirect must not outlive it in caches
+		w.Header().Set("Cache-Control", "no-store")
+		http.Redirect(w, r, s.client.presign(s.key(name), s.expiry, time.Now()), http.StatusFound)
+		return
+	}
+
+	resp, err := s.client.get(r.Context(), r.Method, s.key(name), r.Header)
+	if err != nil {
+		s.log.ErrorContext(r.Context(), "Error fetching segment", "name", name, "err", err)
+		http.Error(w, "Error reading file", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		http.Error(w, "File not found", http.StatusNotFound)
+		return
+	case resp.StatusCode >= 400:
+		s.log.ErrorContext(r.Context(), "Error fetching segment", "name", name, "status", resp.Status)
+		http.Error(w, "Error reading file", http.StatusBadGateway)
+		return
+	}
+	for _, header := range []string{"Content-Length", "Content-Range", "Accept-Ranges", "ETag", "Last-Modified"} {
+		if v := resp.Header.Get(header); v != "" {
+			w.Header().Set(header, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// Remove deletes the objects of a cache directory
+func (s *S3) Remove(ctx context.Context, dir string) (int64, error) {
+	return s.removePrefix(ctx, dir+"/")
+}
+
+// removePrefix deletes the objects whose names start with prefix and
+// returns their size
+func (s *S3) removePrefix(ctx context.Context, prefix string) (int64, error) {
+	objects, err := s.client.list(ctx, s.key(prefix))
+	if err != nil {
+		return 0, fmt.Errorf("failed to list %s: %w", prefix, err)
+	}
+	var freed int64
+	for _, obj := range objects {
+		if err := s.client.delete(ctx, obj.Key); err != nil {
+			return freed, fmt.Errorf("failed to remove %s: %w", obj.Key, err)
+		}
+		freed += obj.Size
+	}
+	return freed, nil
+}
+
+// Usage lists the bucket and adds up the size of the objects of each
+// cache directory
+func (s *S3) Usage(ctx context.Context) (map[string]int64, error) {
+	objects, err := s.client.list(ctx, s.prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the bucket: %w", err)
+	}
+	usage := make(map[string]int64)
+	for _, obj := range objects {
+		dir, _, found := strings.Cut(strings.TrimPrefix(obj.Key, s.prefix), "/")
+		if found {
+			usage[dir] += obj.Size
+		}
+	}
+	return usage, nil
+}
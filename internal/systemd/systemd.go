@@ -0,0 +1,145 @@
+// Package systemd implements the parts of the systemd service protocol the
+// services use: readiness notification, watchdog pings and socket
+// activation. Outside of systemd every function is a no-op.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// States sent with Notify
+const (
+	Ready     = "READY=1"
+	Stopping  = "STOPPING=1"
+	Reloading = "RELOADING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation
+const listenFDsStart = 3
+
+// Notify sends a state such as Ready to the service manager, optionally
+// followed by more states like "STATUS=...". It reports false when the
+// process isn't run by systemd with Type=notify.
+func Notify(states ...string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// A leading @ denotes an abstract socket
+	if strings.HasPrefix(path, "@") {
+		path = "\x00" + path[1:]
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, fmt.Errorf("failed to connect to systemd: %w", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte(strings.Join(states, "\n"))); err != nil {
+		return false, fmt.Errorf("failed to notify systemd: %w", err)
+	}
+	return true, nil
+}
+
+// Status returns a STATUS state describing the service in systemctl status
+func Status(format string, args ...any) string {
+	return "STATUS=" + fmt.Sprintf(format, args...)
+}
+
+// WatchdogInterval returns the interval systemd expects watchdog pings in,
+// 0 when the watchdog isn't enabled for this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// StartWatchdog pings the watchdog at half the interval systemd expects
+// while healthy returns nil, so systemd restarts the service when it hangs
+// or stays unhealthy. It does nothing when the watchdog isn't enabled.
+// Cancel ctx to stop pinging. Skipped and failed pings are logged to
+// logger.
+func StartWatchdog(ctx context.Context, healthy func(context.Context) error, logger *slog.Logger) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	logger.Info("Pinging the systemd watchdog", "interval", interval/2)
+
+	go func() {
+		ticker := time.NewTicker(interval / 2)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+			case <-ctx.Done():
+				return
+			}
+
+			checkCtx, cancel := context.WithTimeout(ctx, interval/2)
+			err := healthy(checkCtx)
+			cancel()
+			if err != nil {
+				logger.Warn("Skipping watchdog ping, service unhealthy", "err", err)
+				continue
+			}
+			if _, err := Notify(Watchdog); err != nil {
+				logger.Error("Error pinging watchdog", "err", err)
+			}
+		}
+	}()
+}
+
+// Listeners returns the sockets passed by socket activation, keyed by the
+// FileDescriptorName of their socket unit, which defaults to the unit's
+// name. It returns nil when the process wasn't socket activated. The
+// environment is cleared, so only the first call gets the sockets.
+func Listeners() (map[string]net.Listener, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	listeners := make(map[string]net.Listener, count)
+	for i := 0; i < count; i++ {
+		fd := listenFDsStart + i
+		name := "unknown"
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		ln, err := net.FileListener(f)
+		// FileListener dups the descriptor, so the inherited one can go
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("socket %s passed by systemd is not a listening socket: %w", name, err)
+		}
+		listeners[name] = ln
+	}
+	return listeners, nil
+}
@@ -0,0 +1,307 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/version"
+)
+
+const (
+	// queueSize is how many ended spans wait for export, more are dropped
+	// rather than slowing down requests
+	queueSize = 4096
+	// batchSize is the most spans sent in one export
+	batchSize = 512
+	// exportInterval is how long ended spans wait for a batch to fill up
+	exportInterval = 5 * time.Second
+	// exportTimeout bounds an export request
+	exportTimeout = 10 * time.Second
+)
+
+// scopeName names the instrumentation in exported spans
+const scopeName = "github.com/kaero/streaming"
+
+// Tracer exports the spans of the process to a collector
+type Tracer struct {
+	url      string
+	headers  map[string]string
+	resource []keyValue
+	ratio    float64
+	client   *http.Client
+	log      *slog.Logger
+
+	// queue holds the ended spans waiting for export. It is never
+	// closed, as requests may still end spans during shutdown; stop
+	// closing ends the export instead.
+	queue   chan spanData
+	stop    chan struct{}
+	done    chan struct{}
+	dropped atomic.Int64
+}
+
+// Setup starts exporting spans as configured by cfg, doing nothing when
+// telemetry is disabled. The returned function flushes the spans not yet
+// exported and stops, waiting at most until ctx is done.
+func Setup(cfg config.TelemetryConfig, logger *slog.Logger) func(ctx context.Context) {
+	if !cfg.Enabled {
+		return func(context.Context) {}
+	}
+
+	host, _ := os.Hostname()
+	t := &Tracer{
+		url:     strings.TrimSuffix(cfg.Endpoint, "/") + "/v1/traces",
+		headers: cfg.Headers,
+		resource: attributes([]any{
+			"service.name", cfg.ServiceName,
+			"service.version", version.Get().Version,
+			"host.name", host,
+		}),
+		ratio:  cfg.SampleRatio,
+		client: &http.Client{Timeout: exportTimeout},
+		log:    logger,
+		queue:  make(chan spanData, queueSize),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go t.run()
+	tracer.Store(t)
+	logger.Info("Exporting traces", "endpoint", t.url, "sample_ratio", cfg.SampleRatio)
+
+	return func(ctx context.Context) {
+		tracer.Store(nil)
+		close(t.stop)
+		select {
+		case <-t.done:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// enqueue queues an ended span for export, dropping it when the queue is
+// full because the collector can't keep up, or when the export stopped
+func (t *Tracer) enqueue(s spanData) {
+	select {
+	case <-t.stop:
+		return
+	default:
+	}
+	select {
+	case t.queue <- s:
+	default:
+		t.dropped.Add(1)
+	}
+}
+
+// run exports the queued spans in batches until stop closes, then exports
+// the spans still queued
+func (t *Tracer) run() {
+	defer close(t.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []spanData
+	for {
+		select {
+		case <-t.stop:
+			t.flush(batch)
+			return
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		case <-ticker.C:
+		}
+		t.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// flush exports batch and the spans still queued
+func (t *Tracer) flush(batch []spanData) {
+	for {
+		select {
+		case s := <-t.queue:
+			batch = append(batch, s)
+			if len(batch) < batchSize {
+				continue
+			}
+		default:
+			t.export(batch)
+			return
+		}
+		t.export(batch)
+		batch = batch[:0]
+	}
+}
+
+// export sends spans to the collector. Failures are logged and the spans
+// dropped, tracing mustn't get in the way of serving.
+func (t *Tracer) export(spans []spanData) {
+	if dropped := t.dropped.Swap(0); dropped > 0 {
+		t.log.Warn("Dropped spans, the collector doesn't keep up", "spans", dropped)
+	}
+	if len(spans) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: t.resource},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: scopeName, Version: version.Get().Version}, Spans: spans}},
+	}}})
+	if err != nil {
+		t.log.Error("Error encoding spans", "err", err)
+		return
+	}
+	req, err := http.NewRequest(http.MethodPost, t.url, bytes.NewReader(body))
+	if err != nil {
+		t.log.Error("Error exporting spans", "err", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range t.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.log.Warn("Error exporting spans", "spans", len(spans), "err", err)
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		t.log.Warn("Collector refused spans", "spans", len(spans), "status", resp.StatusCode, "response", strings.TrimSpace(string(msg)))
+		return
+	}
+	io.Copy(io.Discard, resp.Body)
+}
+
+// data returns the span as exported, ending at end. The caller holds s.mu.
+func (s *Span) data(end time.Time) spanData {
+	d := spanData{
+		TraceID:           hex.EncodeToString(s.traceID[:]),
+		SpanID:            hex.EncodeToString(s.spanID[:]),
+		Name:              s.name,
+		Kind:              int(s.kind),
+		StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(end.UnixNano(), 10),
+		Attributes:        attributes(s.attrs),
+	}
+	if s.parentID != [8]byte{} {
+		d.ParentSpanID = hex.EncodeToString(s.parentID[:])
+	}
+	if s.failed {
+		d.Status = &status{Code: statusError, Message: s.err}
+	}
+	return d
+}
+
+// statusError is the OTLP status code of failed spans
+const statusError = 2
+
+// exportRequest and the types below are the JSON encoding of an OTLP
+// ExportTraceServiceRequest. IDs are hex and 64 bit integers strings.
+type exportRequest struct {
+	ResourceSpans []resourceSpans `json:"resourceSpans"`
+}
+
+type resourceSpans struct {
+	Resource   resource     `json:"resource"`
+	ScopeSpans []scopeSpans `json:"scopeSpans"`
+}
+
+type resource struct {
+	Attributes []keyValue `json:"attributes"`
+}
+
+type scopeSpans struct {
+	Scope scope      `json:"scope"`
+	Spans []spanData `json:"spans"`
+}
+
+type scope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type spanData struct {
+	TraceID           string     `json:"traceId"`
+	SpanID            string     `json:"spanId"`
+	ParentSpanID      string     `json:"parentSpanId,omitempty"`
+	Name              string     `json:"name"`
+	Kind              int        `json:"kind"`
+	StartTimeUnixNano string     `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+	Attributes        []keyValue `json:"attributes,omitempty"`
+	Status            *status    `json:"status,omitempty"`
+}
+
+type status struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type keyValue struct {
+	Key   string   `json:"key"`
+	Value anyValue `json:"value"`
+}
+
+type anyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+}
+
+// attributes converts alternating keys and values to OTLP attributes.
+// Values other than strings, numbers and booleans are formatted as text,
+// durations in milliseconds.
+func attributes(kv []any) []keyValue {
+	var attrs []keyValue
+	for i := 0; i+1 < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok {
+			continue
+		}
+		var v anyValue
+		switch x := kv[i+1].(type) {
+		case string:
+			v.StringValue = &x
+		case bool:
+			v.BoolValue = &x
+		case int:
+			v.IntValue = intString(int64(x))
+		case int64:
+			v.IntValue = intString(x)
+		case float64:
+			v.DoubleValue = &x
+		case time.Duration:
+			ms := float64(x) / float64(time.Millisecond)
+			v.DoubleValue = &ms
+		default:
+			s := fmt.Sprint(x)
+			v.StringValue = &s
+		}
+		attrs = append(attrs, keyValue{Key: key, Value: v})
+	}
+	return attrs
+}
+
+// intString formats an integer as OTLP JSON does
+func intString(i int64) *string {
+	s := strconv.FormatInt(i, 10)
+	return &s
+}
@@ -0,0 +1,183 @@
+// Package telemetry traces HTTP requests, database calls and transcode jobs
+// with OpenTelemetry spans, which are exported to a collector with OTLP
+// over HTTP in its JSON encoding. Until Setup is called, or with
+// telemetry disabled, starting a span costs next to nothing and records
+// nothing.
+package telemetry
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Kind is the role of a span in a trace
+type Kind int
+
+// The span kinds of OTLP
+const (
+	KindInternal Kind = 1
+	KindServer   Kind = 2
+)
+
+// tracer is the tracer set up by Setup, nil while tracing is off
+var tracer atomic.Pointer[Tracer]
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// Span is an operation of a trace. All methods can be called on a nil
+// span, which is what Start returns while tracing is off, and do nothing
+// for spans that aren't sampled.
+type Span struct {
+	// tracer exports the span when it ends, nil for spans that aren't
+	// recorded: unsampled ones and remote parents
+	tracer   *Tracer
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	sampled  bool
+	kind     Kind
+	start    time.Time
+
+	mu     sync.Mutex
+	name   string
+	attrs  []any
+	err    string
+	failed bool
+	ended  bool
+}
+
+// Start starts a span as a child of the span in ctx, or of a new trace,
+// with attributes given as alternating keys and values like slog. The
+// span must be ended.
+func Start(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	return start(ctx, name, KindInternal, kv)
+}
+
+// StartServer starts the span of a request served, as a child of the
+// caller's span put into ctx by Extract
+func StartServer(ctx context.Context, name string, kv ...any) (context.Context, *Span) {
+	return start(ctx, name, KindServer, kv)
+}
+
+// start starts a span of a kind
+func start(ctx context.Context, name string, kind Kind, kv []any) (context.Context, *Span) {
+	t := tracer.Load()
+	if t == nil {
+		return ctx, nil
+	}
+
+	s := &Span{kind: kind, name: name, start: time.Now()}
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.traceID, s.parentID, s.sampled = parent.traceID, parent.spanID, parent.sampled
+	} else {
+		rand.Read(s.traceID[:])
+		s.sampled = t.sample(s.traceID)
+	}
+	rand.Read(s.spanID[:])
+	if s.sampled {
+		s.tracer = t
+		s.attrs = kv
+	}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// SpanFromContext returns the current span of ctx, nil when there is none
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// TraceID returns the ID of the trace of the span in ctx, "" when there
+// is none or it isn't recorded
+func TraceID(ctx context.Context) string {
+	s := SpanFromContext(ctx)
+	if s == nil || !s.sampled {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// SetName renames the span, for names only known once the work is done
+func (s *Span) SetName(name string) {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	s.mu.Lock()
+	s.name = name
+	s.mu.Unlock()
+}
+
+// SetAttributes adds attributes given as alternating keys and values
+func (s *Span) SetAttributes(kv ...any) {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	s.mu.Lock()
+	s.attrs = append(s.attrs, kv...)
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with err, doing nothing for nil
+func (s *Span) SetError(err error) {
+	if s == nil || s.tracer == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	s.failed, s.err = true, err.Error()
+	s.mu.Unlock()
+}
+
+// End ends the span and queues it for export. Ending a span again does
+// nothing.
+func (s *Span) End() {
+	if s == nil || s.tracer == nil {
+		return
+	}
+	end := time.Now()
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	data := s.data(end)
+	s.mu.Unlock()
+	s.tracer.enqueue(data)
+}
+
+// sample decides whether a new trace is recorded, comparing the random
+// part of its ID with the sample ratio like the TraceIDRatioBased sampler
+// of OpenTelemetry
+func (t *Tracer) sample(traceID [16]byte) bool {
+	if t.ratio >= 1 {
+		return true
+	}
+	return binary.BigEndian.Uint64(traceID[8:])>>1 < uint64(t.ratio*(1<<63))
+}
+
+// Extract returns ctx with the caller's span of a W3C traceparent header
+// as the parent of the spans started with it. Requests without a valid
+// header get ctx back.
+func Extract(ctx context.Context, h http.Header) context.Context {
+	parts := strings.Split(strings.TrimSpace(h.Get("traceparent")), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return ctx
+	}
+	s := &Span{}
+	flags, err1 := hex.DecodeString(parts[3])
+	_, err2 := hex.Decode(s.traceID[:], []byte(parts[1]))
+	_, err3 := hex.Decode(s.spanID[:], []byte(parts[2]))
+	if err1 != nil || err2 != nil || err3 != nil || s.traceID == [16]byte{} || s.spanID == [8]byte{} {
+		return ctx
+	}
+	s.sampled = flags[0]&1 == 1
+	return context.WithValue(ctx, spanKey{}, s)
+}
@@ -0,0 +1,60 @@
+//go:build ignore
+
+// fetch_hls downloads the pinned release of hls.js into static/vendor, to
+// be embedded into the binary. Run it through go generate after changing
+// hlsVersion.
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// hlsVersion is the release of hls.js the player is tested with
+const hlsVersion = "1.5.17"
+
+func main() {
+	url := fmt.Sprintf("https://cdn.jsdelivr.net/npm/hls.js@%s/dist/hls.min.js", hlsVersion)
+	dest := filepath.Join("static", "vendor", "hls.min.js")
+	if err := fetch(url, dest); err != nil {
+		fmt.Fprintln(os.Stderr, "error fetching hls.js:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Fetched hls.js", hlsVersion, "to", dest)
+}
+
+// fetch downloads url to dest, replacing dest only once the download is
+// complete
+func fetch(url, dest string) error {
+	resp, err := http.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(dest), ".hls.min.js.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, resp.Body); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -0,0 +1,12 @@
+//go:build !nohls
+
+package templates
+
+import _ "embed"
+
+// hlsJS fails the build when hls.js wasn't fetched with go generate, as
+// the player would then only play in browsers with native HLS support.
+// Build with -tags nohls to leave it out on purpose.
+//
+//go:embed static/vendor/hls.min.js
+var hlsJS []byte
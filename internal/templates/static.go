@@ -0,0 +1,73 @@
+package templates
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"io/fs"
+	"net/http"
+	"strings"
+)
+
+// StaticPrefix is the path the static assets are served under
+const StaticPrefix = "/static/"
+
+// staticFS holds the player scripts and styles, including hls.js in
+// vendor/ once it was fetched with go generate, so the web UI works
+// without internet access
+//
+//go:embed static
+var staticFS embed.FS
+
+// assets maps the names of the static assets to a hash of their content,
+// which versions their URLs
+var assets = hashAssets()
+
+// hashAssets hashes the embedded assets
+func hashAssets() map[string]string {
+	hashes := make(map[string]string)
+	fs.WalkDir(staticFS, "static", func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		b, err := staticFS.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(b)
+		hashes[strings.TrimPrefix(p, "static/")] = hex.EncodeToString(sum[:6])
+		return nil
+	})
+	return hashes
+}
+
+// staticURL returns the versioned URL of a static asset below basePath,
+// or "" when the build doesn't include it
+func staticURL(basePath, name string) string {
+	hash, ok := assets[name]
+	if !ok {
+		return ""
+	}
+	return basePath + StaticPrefix + name + "?v=" + hash
+}
+
+// StaticHandler serves the static assets under StaticPrefix. Requests for
+// the current version of an asset may be cached for good, as a changed
+// asset gets a new URL.
+func StaticHandler() http.Handler {
+	sub, _ := fs.Sub(staticFS, "static")
+	files := http.StripPrefix(StaticPrefix, http.FileServerFS(sub))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, StaticPrefix)
+		if name == "" || strings.HasSuffix(name, "/") {
+			http.NotFound(w, r)
+			return
+		}
+		if v := r.URL.Query().Get("v"); v != "" && v == assets[name] {
+			w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		} else {
+			w.Header().Set("Cache-Control", "no-cache")
+		}
+		files.ServeHTTP(w, r)
+	})
+}
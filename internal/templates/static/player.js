@@ -0,0 +1,500 @@
+// Plays HLS streams in a <video> element: with hls.js where the browser
+// supports Media Source Extensions, natively in Safari and on iOS and
+// Android otherwise. hls.js adds a quality selector and recovers from
+// network and decoding errors. Streams with several audio or subtitle
+// renditions get menus to pick them, which remember the choice.
+(function() {
+    'use strict';
+
+    // At most this many fatal errors are recovered from within
+    // recoveryWindow, after which the player gives up
+    var maxRecoveries = 5;
+    var recoveryWindow = 60000;
+
+    // Names a variant by its height and bitrate, e.g. "720p · 2.8 Mbps"
+    function levelName(level) {
+        var name = level.height ? level.height + 'p' : 'Audio';
+        if (level.bitrate) {
+            name += ' · ' + (level.bitrate / 1000000).toFixed(1) + ' Mbps';
+        }
+        return name;
+    }
+
+    // Remembered track choices are kept in the browser under this key:
+    // the audio language picked by the default audio language of videos,
+    // so dubs are picked for videos in some languages only, and the
+    // subtitle language picked, or "off", by the audio language playing
+    var choicesKey = 'streaming.tracks';
+
+    // Shows or hides the label around a selector, and the .player-bar
+    // around it while none of its selectors are shown
+    function showChoice(select, visible) {
+        var label = select.closest('label') || select;
+        label.hidden = !visible;
+        var bar = select.closest('.player-bar');
+        if (bar) {
+            bar.hidden = !Array.prototype.some.call(bar.querySelectorAll('label'), function(l) { return !l.hidden; });
+        }
+    }
+
+    function loadChoices() {
+        try {
+            var choices = JSON.parse(localStorage.getItem(choicesKey));
+            if (choices && choices.audio && choices.subtitles) {
+                return choices;
+            }
+        } catch (e) {
+            // Storage disabled or garbled, start over
+        }
+        return {audio: {}, subtitles: {}};
+    }
+
+    function saveChoice(kind, key, value) {
+        var choices = loadChoices();
+        choices[kind][key] = value;
+        try {
+            localStorage.setItem(choicesKey, JSON.stringify(choices));
+        } catch (e) {
+            // Not remembered when storage is disabled
+        }
+    }
+
+    // Returns the index of the track in the remembered language lang, -1
+    // when subtitles were turned off, or undefined when nothing is
+    // remembered or no track is in that language
+    function remembered(tracks, lang) {
+        if (lang === undefined) {
+            return undefined;
+        }
+        if (lang === 'off') {
+            return -1;
+        }
+        for (var i = 0; i < tracks.length; i++) {
+            if (tracks[i].lang === lang) {
+                return i;
+            }
+        }
+        return undefined;
+    }
+
+    // Names a rendition by its name and language, e.g. "Commentary (en)"
+    function trackName(track, i) {
+        if (track.name && track.lang && track.name.toLowerCase() !== track.lang.toLowerCase()) {
+            return track.name + ' (' + track.lang + ')';
+        }
+        return track.name || track.lang || 'Track ' + (i + 1);
+    }
+
+    // Renditions of hls.js and of the browser, as lists of {name, lang,
+    // default} with the selected index, -1 for none, and a way to select
+    function hlsTracks(hls) {
+        var list = function(tracks) {
+            return tracks.map(function(t) { return {name: t.name, lang: t.lang || '', default: !!t.default}; });
+        };
+        return {
+            audio: {
+                list: function() { return list(hls.audioTracks); },
+                current: function() { return hls.audioTrack; },
+                select: function(i) { hls.audioTrack = i; }
+            },
+            subtitles: {
+                list: function() { return list(hls.subtitleTracks); },
+                current: function() { return hls.subtitleDisplay ? hls.subtitleTrack : -1; },
+                select: function(i) {
+                    hls.subtitleTrack = i;
+                    hls.subtitleDisplay = i >= 0;
+                }
+            }
+        };
+    }
+
+    function nativeTracks(video) {
+        var audio = function() { return Array.prototype.slice.call(video.audioTracks || []); };
+        var text = function() {
+            return Array.prototype.filter.call(video.textTracks, function(t) {
+                return t.kind === 'subtitles' || t.kind === 'captions';
+            });
+        };
+        var list = function(tracks) {
+            return tracks.map(function(t) { return {name: t.label, lang: t.language || '', default: t.kind === 'main'}; });
+        };
+        return {
+            audio: {
+                list: function() { return list(audio()); },
+                current: function() { return audio().findIndex(function(t) { return t.enabled; }); },
+                select: function(i) { audio().forEach(function(t, j) { t.enabled = i === j; }); }
+            },
+            subtitles: {
+                list: function() { return list(text()); },
+                current: function() { return text().findIndex(function(t) { return t.mode === 'showing'; }); },
+                select: function(i) { text().forEach(function(t, j) { t.mode = i === j ? 'showing' : 'disabled'; }); }
+            }
+        };
+    }
+
+    // Fills a selector with renditions, shown when there is a choice, with
+    // "Off" first when off is true. chosen is called with the index of
+    // renditions the user picks.
+    function trackMenu(select, tracks, off, chosen) {
+        if (!select) {
+            return;
+        }
+        var list = tracks.list();
+        select.replaceChildren();
+        if (off) {
+            var none = document.createElement('option');
+            none.value = '-1';
+            none.textContent = 'Off';
+            select.appendChild(none);
+        }
+        list.forEach(function(track, i) {
+            var option = document.createElement('option');
+            option.value = String(i);
+            option.textContent = trackName(track, i);
+            select.appendChild(option);
+        });
+        select.value = String(tracks.current());
+        select.onchange = function() {
+            var i = parseInt(select.value, 10);
+            tracks.select(i);
+            chosen(i);
+        };
+        showChoice(select, list.length > (off ? 0 : 1));
+    }
+
+    // Selects the remembered audio and subtitle renditions and fills their
+    // menus, remembering what the user picks
+    function setupTracks(tracks, options) {
+        var audio = tracks.audio.list();
+        var defaultAudio = Math.max(audio.findIndex(function(t) { return t.default; }), 0);
+        // Videos are told apart by the language of their default audio
+        var audioKey = audio.length > 0 ? audio[defaultAudio].lang : '';
+        var playingLang = function() {
+            var i = tracks.audio.current();
+            return i >= 0 && i < audio.length ? audio[i].lang : audioKey;
+        };
+
+        var pick = remembered(audio, loadChoices().audio[audioKey]);
+        if (pick !== undefined && pick !== tracks.audio.current()) {
+            tracks.audio.select(pick);
+        }
+
+        var pickSubtitles = function() {
+            var subtitles = tracks.subtitles.list();
+            var pick = remembered(subtitles, loadChoices().subtitles[playingLang()]);
+            if (pick !== undefined && pick !== tracks.subtitles.current()) {
+                tracks.subtitles.select(pick);
+            }
+            trackMenu(options.subtitles, tracks.subtitles, true, function(i) {
+                saveChoice('subtitles', playingLang(), i < 0 ? 'off' : subtitles[i].lang);
+            });
+        };
+        pickSubtitles();
+
+        trackMenu(options.audio, tracks.audio, false, function(i) {
+            saveChoice('audio', audioKey, audio[i].lang);
+            // Subtitles follow the language now playing
+            pickSubtitles();
+        });
+    }
+
+    function showError(options, message) {
+        if (options.error) {
+            options.error.textContent = message;
+            options.error.hidden = false;
+        }
+    }
+
+    // Fills the quality selector with the variants, best first, and lets
+    // it switch between them. Switches take effect at the next segment,
+    // so playback doesn't stall.
+    function setupQuality(hls, select) {
+        if (!select) {
+            return;
+        }
+        var auto = document.createElement('option');
+        auto.value = '-1';
+        auto.textContent = 'Auto';
+        select.replaceChildren(auto);
+
+        var order = hls.levels.map(function(level, i) { return i; });
+        order.sort(function(a, b) {
+            return (hls.levels[b].height - hls.levels[a].height) || (hls.levels[b].bitrate - hls.levels[a].bitrate);
+        });
+        order.forEach(function(i) {
+            var option = document.createElement('option');
+            option.value = String(i);
+            option.textContent = levelName(hls.levels[i]);
+            select.appendChild(option);
+        });
+
+        select.value = '-1';
+        select.onchange = function() {
+            hls.nextLevel = parseInt(select.value, 10);
+        };
+        hls.on(Hls.Events.LEVEL_SWITCHED, function(event, data) {
+            auto.textContent = hls.autoLevelEnabled ? 'Auto (' + levelName(hls.levels[data.level]) + ')' : 'Auto';
+        });
+        showChoice(select, hls.levels.length > 1);
+    }
+
+    // Plays src with hls.js, recovering from fatal errors: network errors
+    // load again after a growing delay, decoding errors reset the media
+    // pipeline. Streams the server refuses, such as over the stream
+    // limit, aren't retried.
+    function attachHls(video, src, options) {
+        var hls = new Hls({
+            // Starts with the variant suiting the measured bandwidth
+            startLevel: -1,
+            capLevelToPlayerSize: true
+        });
+        var recoveries = 0;
+        var firstRecovery = 0;
+
+        hls.on(Hls.Events.MANIFEST_PARSED, function() {
+            setupQuality(hls, options.quality);
+            setupTracks(hlsTracks(hls), options);
+            if (options.autoplay) {
+                video.play().catch(function() {});
+            }
+        });
+        hls.on(Hls.Events.ERROR, function(event, data) {
+            if (!data.fatal) {
+                return;
+            }
+            var status = data.response && data.response.code;
+            if (status === 403 || status === 429) {
+                hls.destroy();
+                showError(options, 'The server refused the stream (' + status + '), reload the page to try again.');
+                return;
+            }
+
+            var now = Date.now();
+            if (now - firstRecovery > recoveryWindow) {
+                firstRecovery = now;
+                recoveries = 0;
+            }
+            recoveries++;
+            if (recoveries > maxRecoveries) {
+                hls.destroy();
+                showError(options, 'Playback failed: ' + data.details + '. Reload the page to try again.');
+                return;
+            }
+
+            switch (data.type) {
+            case Hls.ErrorTypes.NETWORK_ERROR:
+                console.log('Network error, loading again:', data.details);
+                setTimeout(function() { hls.startLoad(); }, Math.min(1000 * recoveries, 5000));
+                break;
+            case Hls.ErrorTypes.MEDIA_ERROR:
+                console.log('Media error, recovering:', data.details);
+                // A second decoding error in a row is often an audio codec
+                // mismatch
+                if (recoveries > 1) {
+                    hls.swapAudioCodec();
+                }
+                hls.recoverMediaError();
+                break;
+            default:
+                hls.destroy();
+                showError(options, 'Playback failed: ' + data.details + '. Reload the page to try again.');
+            }
+        });
+
+        hls.loadSource(src);
+        hls.attachMedia(video);
+        return hls;
+    }
+
+    // attach plays the HLS stream src in video. options are:
+    //   quality:   a <select> for the variants, in a .player-bar shown
+    //              when there is more than one
+    //   audio:     a <select> for the audio renditions, shown when there
+    //              is more than one
+    //   subtitles: a <select> for the subtitle renditions, shown when
+    //              there are any
+    //   error:     an element showing why playback failed
+    //   autoplay:  start playing once the stream loaded
+    function attach(video, src, options) {
+        options = options || {};
+        if (window.Hls && Hls.isSupported()) {
+            return attachHls(video, src, options);
+        }
+
+        if (options.quality) {
+            showChoice(options.quality, false);
+        }
+        if (!video.canPlayType('application/vnd.apple.mpegurl')) {
+            showError(options, 'This browser can\'t play HLS streams. Use the playlist link with an external player.');
+            return null;
+        }
+        video.addEventListener('error', function() {
+            showError(options, 'Playback failed, reload the page to try again.');
+        });
+        // Safari adds the renditions as it reads the playlists
+        var tracks = nativeTracks(video);
+        var refresh = function() { setupTracks(tracks, options); };
+        video.addEventListener('loadedmetadata', refresh, {once: true});
+        video.textTracks.addEventListener('addtrack', refresh);
+        if (video.audioTracks) {
+            video.audioTracks.addEventListener('addtrack', refresh);
+        }
+        video.src = src;
+        if (options.autoplay) {
+            video.play().catch(function() {});
+        }
+        return null;
+    }
+
+    // Formats seconds as a time in the video, e.g. "1:02:03" or "2:03"
+    function formatTime(seconds) {
+        seconds = Math.max(0, Math.floor(seconds));
+        var h = Math.floor(seconds / 3600);
+        var m = Math.floor(seconds / 60) % 60;
+        var s = seconds % 60;
+        var ss = (s < 10 ? '0' : '') + s;
+        if (h > 0) {
+            return h + ':' + (m < 10 ? '0' : '') + m + ':' + ss;
+        }
+        return m + ':' + ss;
+    }
+
+    // Parses a WebVTT timestamp, e.g. "00:01:02.500", into seconds
+    function parseTime(text) {
+        return text.split(':').reduce(function(total, part) { return total * 60 + parseFloat(part); }, 0);
+    }
+
+    // Parses a WebVTT thumbnails index into cues of {start, end, url, x,
+    // y, w, h}, resolving image URLs against the index URL
+    function parseThumbnails(text, base) {
+        var cues = [];
+        text.split(/\r?\n\r?\n/).forEach(function(block) {
+            var lines = block.trim().split(/\r?\n/);
+            var timing = lines.findIndex(function(line) { return line.indexOf('-->') >= 0; });
+            if (timing < 0 || !lines[timing + 1]) {
+                return;
+            }
+            var times = lines[timing].split('-->');
+            var target = lines[timing + 1].split('#xywh=');
+            var xywh = (target[1] || '').split(',').map(Number);
+            if (xywh.length !== 4) {
+                return;
+            }
+            cues.push({
+                start: parseTime(times[0].trim()),
+                end: parseTime(times[1].trim().split(' ')[0]),
+                url: new URL(target[0], base).href,
+                x: xywh[0], y: xywh[1], w: xywh[2], h: xywh[3]
+            });
+        });
+        return cues;
+    }
+
+    // previews turns timeline, a .player-timeline element below the video,
+    // into a seek bar showing how far the video played. Hovering it shows
+    // the time, with a thumbnail from the WebVTT index at thumbnailsURL
+    // when the video has one, and clicking it seeks there.
+    function previews(video, timeline, thumbnailsURL) {
+        var played = timeline.querySelector('.player-timeline-played');
+        var preview = timeline.querySelector('.player-preview');
+        var image = preview.querySelector('.player-preview-image');
+        var label = preview.querySelector('.player-preview-time');
+        var cues = [];
+
+        fetch(thumbnailsURL, {credentials: 'same-origin'})
+            .then(function(resp) { return resp.ok ? resp.text() : ''; })
+            .then(function(text) { cues = parseThumbnails(text, new URL(thumbnailsURL, location.href).href); })
+            .catch(function() {});
+
+        var timeAt = function(event) {
+            var rect = timeline.getBoundingClientRect();
+            var fraction = Math.min(Math.max((event.clientX - rect.left) / rect.width, 0), 1);
+            return {fraction: fraction, time: fraction * video.duration, width: rect.width};
+        };
+
+        video.addEventListener('loadedmetadata', function() {
+            timeline.hidden = !isFinite(video.duration);
+        });
+        video.addEventListener('timeupdate', function() {
+            if (video.duration) {
+                played.style.width = (video.currentTime / video.duration * 100) + '%';
+            }
+        });
+        timeline.addEventListener('mousemove', function(event) {
+            var at = timeAt(event);
+            var cue = cues.find(function(c) { return at.time >= c.start && at.time < c.end; });
+            image.hidden = !cue;
+            if (cue) {
+                image.style.width = cue.w + 'px';
+                image.style.height = cue.h + 'px';
+                image.style.backgroundImage = 'url("' + cue.url + '")';
+                image.style.backgroundPosition = -cue.x + 'px ' + -cue.y + 'px';
+            }
+            label.textContent = formatTime(at.time);
+            preview.hidden = false;
+            var half = preview.offsetWidth / 2;
+            preview.style.left = Math.min(Math.max(at.fraction * at.width, half), at.width - half) + 'px';
+        });
+        timeline.addEventListener('mouseleave', function() {
+            preview.hidden = true;
+        });
+        timeline.addEventListener('click', function(event) {
+            video.currentTime = timeAt(event).time;
+        });
+    }
+
+    // shortcuts adds the usual player keys to the page: space or k plays
+    // and pauses, the left and right arrows seek 5 seconds and j and l 10,
+    // the up and down arrows change the volume, f toggles full screen, m
+    // mutes and the digits seek to that tenth of the video. Keys typed
+    // into form fields are left alone.
+    function shortcuts(video) {
+        var seek = function(by) {
+            video.currentTime = Math.min(Math.max(video.currentTime + by, 0), video.duration || 0);
+        };
+        var fullscreen = function() {
+            var container = video.closest('.video-container') || video;
+            if (document.fullscreenElement) {
+                document.exitFullscreen();
+            } else if (container.requestFullscreen) {
+                container.requestFullscreen().catch(function() {});
+            } else if (video.webkitEnterFullscreen) {
+                video.webkitEnterFullscreen();
+            }
+        };
+
+        // Captured before the native controls see the keys, so focused
+        // controls don't act on them twice
+        document.addEventListener('keydown', function(event) {
+            if (event.ctrlKey || event.altKey || event.metaKey || event.target.closest('input, select, textarea, button, a')) {
+                return;
+            }
+            var key = event.key;
+            if (key === ' ' || key === 'k') {
+                if (video.paused) {
+                    video.play().catch(function() {});
+                } else {
+                    video.pause();
+                }
+            } else if (key === 'ArrowLeft' || key === 'ArrowRight') {
+                seek(key === 'ArrowLeft' ? -5 : 5);
+            } else if (key === 'j' || key === 'l') {
+                seek(key === 'j' ? -10 : 10);
+            } else if (key === 'ArrowUp' || key === 'ArrowDown') {
+                video.volume = Math.min(Math.max(video.volume + (key === 'ArrowUp' ? 0.1 : -0.1), 0), 1);
+                video.muted = false;
+            } else if (key === 'f') {
+                fullscreen();
+            } else if (key === 'm') {
+                video.muted = !video.muted;
+            } else if (key >= '0' && key <= '9' && video.duration) {
+                video.currentTime = video.duration * parseInt(key, 10) / 10;
+            } else {
+                return;
+            }
+            event.preventDefault();
+        }, true);
+    }
+
+    window.StreamingPlayer = {attach: attach, previews: previews, shortcuts: shortcuts};
+})();
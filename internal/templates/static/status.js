@@ -0,0 +1,63 @@
+// Follows the processing state of videos over the server-sent events of
+// /api/v1/videos/events, and describes it the way the server renders it
+// into pages.
+(function() {
+    'use strict';
+
+    // Describes what will happen next to a failed video from its status
+    // or video JSON, "" when nothing will
+    function retryInfo(video) {
+        if (video.status !== 'error') {
+            return '';
+        }
+        var retry = video.retry;
+        if (retry.next_retry_at) {
+            var at = new Date(retry.next_retry_at).toLocaleString([], {month: 'short', day: 'numeric', hour: '2-digit', minute: '2-digit'});
+            return 'Will retry at ' + at + ' (failed ' + retry.count + ' times)';
+        }
+        if (retry.failure_class === 'insufficient_space') {
+            return 'Waiting for free space on the cache disk';
+        }
+        if (retry.failure_class === 'permanent') {
+            return 'Not retrying, the file appears to be broken';
+        }
+        if (retry.count > 0) {
+            return 'Gave up after ' + retry.count + ' attempts';
+        }
+        return '';
+    }
+
+    // Describes the progress of a video being processed, e.g.
+    // "42.5%, 3 min left"
+    function progressText(progress) {
+        var text = progress.percent.toFixed(1) + '%';
+        if (progress.eta_seconds !== undefined) {
+            var mins = Math.floor(progress.eta_seconds / 60);
+            text += ', ' + (mins > 0 ? mins + ' min' : Math.round(progress.eta_seconds) + ' s') + ' left';
+        }
+        return text;
+    }
+
+    // Calls handlers.status with the new state of videos whose status
+    // changes and handlers.progress with the progress of videos being
+    // processed, of all videos or only of the one with ID videoID. The
+    // browser reconnects after network errors. Returns the EventSource,
+    // or null when the browser has none.
+    function follow(base, videoID, handlers) {
+        if (!window.EventSource) {
+            return null;
+        }
+        var url = base + '/api/v1/videos/events' + (videoID ? '?video_id=' + videoID : '');
+        var source = new EventSource(url);
+        ['status', 'progress'].forEach(function(name) {
+            if (handlers[name]) {
+                source.addEventListener(name, function(event) {
+                    handlers[name](JSON.parse(event.data));
+                });
+            }
+        });
+        return source;
+    }
+
+    window.StreamingStatus = {retryInfo: retryInfo, progressText: progressText, follow: follow};
+})();
@@ -0,0 +1,72 @@
+// Picks the light or dark theme of the page: the one the user chose with a
+// .theme-toggle button, kept in the browser, or else the default of the
+// server from the data-default attribute of this script, or else the one
+// of the system. Loaded in the head, so pages don't flash in the wrong
+// theme.
+(function() {
+    'use strict';
+
+    var key = 'streaming.theme';
+    var root = document.documentElement;
+    var fallback = document.currentScript.dataset.default || '';
+    var system = window.matchMedia('(prefers-color-scheme: dark)');
+
+    function stored() {
+        try {
+            return localStorage.getItem(key);
+        } catch (e) {
+            // Storage is disabled
+            return null;
+        }
+    }
+
+    function store(theme) {
+        try {
+            if (theme) {
+                localStorage.setItem(key, theme);
+            } else {
+                localStorage.removeItem(key);
+            }
+        } catch (e) {
+            // The choice only lasts for this page
+        }
+    }
+
+    // Sets the theme the stylesheets follow, leaving it to the system when
+    // neither the user nor the server chose one
+    function apply(theme) {
+        if (theme === 'light' || theme === 'dark') {
+            root.dataset.theme = theme;
+        } else {
+            delete root.dataset.theme;
+        }
+    }
+
+    // The theme shown when the user didn't choose one
+    function unchosen() {
+        return fallback || (system.matches ? 'dark' : 'light');
+    }
+
+    // Switches to the other theme. Switching back to the theme the page
+    // has anyway forgets the choice, so it follows the system again.
+    function toggle() {
+        var shown = root.dataset.theme || (system.matches ? 'dark' : 'light');
+        var next = shown === 'dark' ? 'light' : 'dark';
+        store(next === unchosen() ? null : next);
+        apply(stored() || next);
+    }
+
+    apply(stored() || fallback);
+
+    document.addEventListener('click', function(event) {
+        if (event.target.closest && event.target.closest('.theme-toggle')) {
+            toggle();
+        }
+    });
+    // Follow the toggles of other tabs
+    window.addEventListener('storage', function(event) {
+        if (event.key === key) {
+            apply(stored() || fallback);
+        }
+    });
+})();
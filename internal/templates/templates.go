@@ -2,36 +2,84 @@ package templates
 
 import (
 	"embed"
+	"encoding/xml"
 	"html/template"
 	"io"
-	"log"
+	"strings"
+	texttemplate "text/template"
+	"time"
 )
 
-//go:embed templates/*.gohtml
+//go:generate go run fetch_hls.go
+
+//go:embed templates/*.gohtml templates/*.goxml
 var templateFS embed.FS
 
 // Templates holds parsed templates
 type Templates struct {
 	list   *template.Template
 	player *template.Template
+	live   *template.Template
+	login  *template.Template
+	// rss and atom render feeds. XML isn't escaped automatically, so
+	// every value goes through the xml function.
+	rss  *texttemplate.Template
+	atom *texttemplate.Template
+	// epg renders the programme guide of the live channels, likewise
+	epg *texttemplate.Template
+}
+
+// feedFuncs are the functions of the feed templates
+var feedFuncs = texttemplate.FuncMap{
+	"xml":     escapeXML,
+	"rfc822":  func(t time.Time) string { return t.UTC().Format(time.RFC1123Z) },
+	"rfc3339": func(t time.Time) string { return t.UTC().Format(time.RFC3339) },
+	"xmltv":   func(t time.Time) string { return t.UTC().Format("20060102150405 -0700") },
 }
 
-// New creates a new Templates instance
-func New() *Templates {
+// escapeXML escapes text for XML elements and attribute values
+func escapeXML(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+// Theme customizes the look of the pages
+type Theme struct {
+	// Default is "light" or "dark" for visitors who didn't pick a theme,
+	// "" or "auto" to follow their system
+	Default string
+	// Accent is the color of links and buttons, "" for the built-in one
+	Accent string
+	// Logo is the URL of the image shown in page headers, "" for none
+	Logo string
+}
+
+// New creates a new Templates instance. Links in the templates are
+// prefixed with basePath through the base function, and the pages look
+// the way theme says through the theme function.
+func New(basePath string, theme Theme) *Templates {
+	if theme.Default == "auto" {
+		theme.Default = ""
+	}
 	t := &Templates{}
-	
-	// Parse templates from embedded filesystem
-	var err error
-	
-	t.list, err = template.ParseFS(templateFS, "templates/list.gohtml")
-	if err != nil {
-		log.Fatalf("Failed to parse list template: %v", err)
+	funcs := template.FuncMap{
+		"base": func() string { return basePath },
+		// static returns the URL of a static asset, "" when the build
+		// doesn't include it
+		"static": func(name string) string { return staticURL(basePath, name) },
+		"theme":  func() Theme { return theme },
 	}
 	
-	t.player, err = template.ParseFS(templateFS, "templates/player.gohtml")
-	if err != nil {
-		log.Fatalf("Failed to parse player template: %v", err)
-	}
+	// Parse templates from embedded filesystem, which can only fail with
+	// a broken build
+	t.list = template.Must(template.New("list.gohtml").Funcs(funcs).ParseFS(templateFS, "templates/list.gohtml", "templates/theme.gohtml"))
+	t.player = template.Must(template.New("player.gohtml").Funcs(funcs).ParseFS(templateFS, "templates/player.gohtml", "templates/theme.gohtml"))
+	t.live = template.Must(template.New("live.gohtml").Funcs(funcs).ParseFS(templateFS, "templates/live.gohtml", "templates/theme.gohtml"))
+	t.login = template.Must(template.New("login.gohtml").Funcs(funcs).ParseFS(templateFS, "templates/login.gohtml", "templates/theme.gohtml"))
+	t.rss = texttemplate.Must(texttemplate.New("feed.rss.goxml").Funcs(feedFuncs).ParseFS(templateFS, "templates/feed.rss.goxml"))
+	t.atom = texttemplate.Must(texttemplate.New("feed.atom.goxml").Funcs(feedFuncs).ParseFS(templateFS, "templates/feed.atom.goxml"))
+	t.epg = texttemplate.Must(texttemplate.New("epg.xmltv.goxml").Funcs(feedFuncs).ParseFS(templateFS, "templates/epg.xmltv.goxml"))
 	
 	return t
 }
@@ -44,4 +92,29 @@ func (t *Templates) ListTemplate(w io.Writer, data interface{}) error {
 // PlayerTemplate renders the video player template
 func (t *Templates) PlayerTemplate(w io.Writer, data interface{}) error {
 	return t.player.Execute(w, data)
-}
\ No newline at end of file
+}
+
+// LiveTemplate renders the player of a live channel
+func (t *Templates) LiveTemplate(w io.Writer, data interface{}) error {
+	return t.live.Execute(w, data)
+}
+
+// LoginTemplate renders the login form
+func (t *Templates) LoginTemplate(w io.Writer, data interface{}) error {
+	return t.login.Execute(w, data)
+}
+
+// RSSTemplate renders a feed as RSS 2.0
+func (t *Templates) RSSTemplate(w io.Writer, data interface{}) error {
+	return t.rss.Execute(w, data)
+}
+
+// AtomTemplate renders a feed as Atom
+func (t *Templates) AtomTemplate(w io.Writer, data interface{}) error {
+	return t.atom.Execute(w, data)
+}
+
+// EPGTemplate renders the programme guide of the live channels as XMLTV
+func (t *Templates) EPGTemplate(w io.Writer, data interface{}) error {
+	return t.epg.Execute(w, data)
+}
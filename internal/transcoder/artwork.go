@@ -0,0 +1,79 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ArtworkFile is the name of the artwork image in a video's cache directory
+const ArtworkFile = "artwork.jpg"
+
+// artworkWidth is the width artwork taken from a frame is scaled to
+const artworkWidth = 480
+
+// artworkPosition is how far into a video the frame used as artwork is
+// taken from, skipping intros that are often black
+const artworkPosition = 0.1
+
+// ExtractArtwork writes a JPEG image representing a video to output: the
+// embedded cover art if the video has one, a frame from the video
+// otherwise. The image is written to a temporary file first, so readers
+// never see a partial image.
+func (tm *Manager) ExtractArtwork(ctx context.Context, videoPath, output string, coverArt bool, duration float64) error {
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create artwork directory: %w", err)
+	}
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(output), ".artwork-*.jpg")
+	if err != nil {
+		return fmt.Errorf("failed to create artwork file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	var args []string
+	if coverArt {
+		// Attached pictures are the video streams that aren't "V" streams
+		args = []string{"-v", "error", "-y", "-i", input, "-map", "0:v", "-map", "-0:V"}
+	} else {
+		args = []string{
+			"-v", "error", "-y",
+			"-ss", strconv.FormatFloat(duration*artworkPosition, 'f', 3, 64),
+			"-i", input,
+			"-map", "0:V:0",
+			"-vf", fmt.Sprintf("scale=%d:-2", artworkWidth),
+		}
+	}
+	args = append(args, "-frames:v", "1", "-q:v", "3", "-f", "image2", tmp.Name())
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	defer tm.trackProcess(cmd, videoPath, output)()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("artwork extraction failed: %w: %s", err, redactInput(strings.TrimSpace(tailLines(stderr.String(), 5)), input, videoPath))
+	}
+
+	if info, err := os.Stat(tmp.Name()); err != nil || info.Size() == 0 {
+		return fmt.Errorf("artwork extraction produced no image")
+	}
+	if err := os.Rename(tmp.Name(), output); err != nil {
+		return fmt.Errorf("failed to store artwork: %w", err)
+	}
+	return nil
+}
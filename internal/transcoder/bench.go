@@ -0,0 +1,141 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/kaero/streaming/config"
+)
+
+// BenchSetting is an encoder setting to benchmark
+type BenchSetting struct {
+	Encoder string
+	// Preset is the x264 preset, "" for the encoder's default
+	Preset string
+}
+
+// String returns the setting for people, e.g. "libx264 veryfast"
+func (s BenchSetting) String() string {
+	if s.Preset == "" {
+		return s.Encoder
+	}
+	return s.Encoder + " " + s.Preset
+}
+
+// Hardware reports whether the setting uses a hardware encoder
+func (s BenchSetting) Hardware() bool {
+	return s.Encoder != SoftwareEncoder
+}
+
+// HWAccel returns the server.hwaccel setting selecting the encoder,
+// "none" for libx264
+func (s BenchSetting) HWAccel() string {
+	for name, enc := range hwaccelEncoders["h264"] {
+		if enc == s.Encoder {
+			return name
+		}
+	}
+	return "none"
+}
+
+// BenchResult is the outcome of encoding a sample with one setting
+type BenchResult struct {
+	Setting BenchSetting
+	Elapsed time.Duration
+	// Frames and Seconds are the amount of video encoded
+	Frames  int64
+	Seconds float64
+	Size    int64
+	// StderrTail is the end of FFmpeg's output, kept when it failed
+	StderrTail string
+}
+
+// FPS returns the frames encoded per second
+func (r *BenchResult) FPS() float64 {
+	return float64(r.Frames) / r.Elapsed.Seconds()
+}
+
+// Realtime returns how many times faster than playback the sample was
+// encoded
+func (r *BenchResult) Realtime() float64 {
+	return r.Seconds / r.Elapsed.Seconds()
+}
+
+// BenchSettings returns the settings to benchmark: libx264 with each of
+// presets and every hardware encoder FFmpeg has with its default preset
+func BenchSettings(presets []string) ([]BenchSetting, error) {
+	available, err := Encoders()
+	if err != nil {
+		return nil, err
+	}
+	if !available[SoftwareEncoder] {
+		return nil, fmt.Errorf("ffmpeg lacks the %s encoder", SoftwareEncoder)
+	}
+
+	var settings []BenchSetting
+	for _, preset := range presets {
+		settings = append(settings, BenchSetting{Encoder: SoftwareEncoder, Preset: preset})
+	}
+	for _, enc := range hardwareEncoders {
+		if available[enc] {
+			settings = append(settings, BenchSetting{Encoder: enc})
+		}
+	}
+	return settings, nil
+}
+
+// Bench encodes the first seconds of source, or of a generated test
+// pattern when source is "", to variant q with setting, the way transcodes
+// do without audio. The output is written to dir and removed afterwards.
+func Bench(ctx context.Context, source string, seconds float64, setting BenchSetting, q config.QualityVariant, dir string) (*BenchResult, error) {
+	scale := fmt.Sprintf("scale=%d:%d", q.Width, q.Height)
+	duration := strconv.FormatFloat(seconds, 'f', -1, 64)
+	input, filters, codec := encoderArgs(setting.Encoder, setting.Preset)
+
+	args := []string{"-hide_banner", "-nostats", "-progress", "pipe:1", "-y"}
+	args = append(args, input...)
+	if source == "" {
+		args = append(args, "-f", "lavfi", "-t", duration, "-i", "testsrc2=size=1920x1080:rate=30")
+	} else {
+		args = append(args, "-t", duration, "-i", source)
+	}
+	args = append(args, "-map", "0:v:0", "-an", "-vf", videoFilter(scale, filters))
+	args = append(args, codec...)
+	output := filepath.Join(dir, "bench.ts")
+	args = append(args, "-b:v", q.Bitrate(), "-f", "mpegts", output)
+	defer os.Remove(output)
+
+	result := &BenchResult{Setting: setting}
+	var last Progress
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = &progressWriter{report: func(p Progress) { last = p }}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	start := time.Now()
+	err := cmd.Run()
+	result.Elapsed = time.Since(start)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("benchmark cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		result.StderrTail = tailLines(stderr.String(), stderrTailLines)
+		return result, fmt.Errorf("encoding failed: %v", err)
+	}
+
+	result.Frames = last.Frame
+	result.Seconds = last.Position
+	if info, err := os.Stat(output); err == nil {
+		result.Size = info.Size()
+	}
+	if result.Frames == 0 || result.Seconds == 0 {
+		return result, fmt.Errorf("ffmpeg encoded no video")
+	}
+	return result, nil
+}
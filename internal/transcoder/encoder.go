@@ -0,0 +1,82 @@
+package transcoder
+
+import "strings"
+
+// SoftwareEncoder is the H.264 encoder transcodes use without hardware
+// acceleration
+const SoftwareEncoder = "libx264"
+
+// softwareEncoders are the encoders of each video codec without hardware
+// acceleration
+var softwareEncoders = map[string]string{
+	"h264": SoftwareEncoder,
+	"hevc": "libx265",
+}
+
+// hwaccelEncoders are the hardware encoders of each video codec selected by
+// the hwaccel setting
+var hwaccelEncoders = map[string]map[string]string{
+	"h264": {
+		"nvenc":        "h264_nvenc",
+		"qsv":          "h264_qsv",
+		"vaapi":        "h264_vaapi",
+		"videotoolbox": "h264_videotoolbox",
+	},
+	"hevc": {
+		"nvenc":        "hevc_nvenc",
+		"qsv":          "hevc_qsv",
+		"vaapi":        "hevc_vaapi",
+		"videotoolbox": "hevc_videotoolbox",
+	},
+}
+
+// hardwareEncoders are the hardware H.264 encoders benchmarks try when
+// FFmpeg has them
+var hardwareEncoders = []string{"h264_nvenc", "h264_qsv", "h264_vaapi", "h264_videotoolbox"}
+
+// vaapiDevice is the render node the VAAPI encoder runs on
+const vaapiDevice = "/dev/dri/renderD128"
+
+// EncoderFor returns the encoder of a video codec for a hwaccel setting,
+// the software encoder for "none"
+func EncoderFor(codec, hwaccel string) string {
+	if enc, ok := hwaccelEncoders[codec][hwaccel]; ok {
+		return enc
+	}
+	if enc, ok := softwareEncoders[codec]; ok {
+		return enc
+	}
+	return SoftwareEncoder
+}
+
+// encoderArgs returns the FFmpeg arguments encoding video with encoder:
+// the options going before the input, the filters going after scale, and
+// the encoder options. The preset only applies to libx264 and libx265,
+// hardware encoders run with their default preset.
+func encoderArgs(encoder, preset string) (input, filters, codec []string) {
+	codec = []string{"-c:v", encoder}
+	switch encoder {
+	case SoftwareEncoder:
+		codec = append(codec, "-crf", "23", "-preset", preset)
+	case "libx265":
+		codec = append(codec, "-crf", "28", "-preset", preset)
+	case "h264_vaapi", "hevc_vaapi":
+		// VAAPI encodes frames uploaded to the GPU
+		input = []string{"-vaapi_device", vaapiDevice}
+		filters = []string{"format=nv12", "hwupload"}
+	}
+	// Apple players only take HEVC tagged as hvc1
+	if strings.HasPrefix(encoder, "hevc_") || encoder == "libx265" {
+		codec = append(codec, "-tag:v", "hvc1")
+	}
+	return input, filters, codec
+}
+
+// videoFilter joins the scale filter, if any, with the filters an encoder
+// needs, returning "" when there is nothing to filter
+func videoFilter(scale string, filters []string) string {
+	if scale != "" {
+		filters = append([]string{scale}, filters...)
+	}
+	return strings.Join(filters, ",")
+}
@@ -0,0 +1,72 @@
+package transcoder
+
+import (
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+)
+
+// FFmpegVersion returns the version line of the installed FFmpeg, such as
+// "ffmpeg version 6.1.1"
+func FFmpegVersion() (string, error) {
+	out, err := exec.Command("ffmpeg", "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg not usable: %w", err)
+	}
+	line, _, _ := strings.Cut(string(out), "\n")
+	// Drop the copyright notice following the version
+	line, _, _ = strings.Cut(line, " Copyright")
+	return strings.TrimSpace(line), nil
+}
+
+// CheckTools verifies that ffmpeg, with the video and audio encoders of
+// the transcode profiles, and ffprobe are installed. Whether the hardware
+// itself is usable only shows when encoding, as with the bench command.
+func CheckTools(profiles []config.TranscodeProfile) error {
+	if _, err := exec.LookPath("ffprobe"); err != nil {
+		return fmt.Errorf("ffprobe not found: %w", err)
+	}
+
+	available, err := Encoders()
+	if err != nil {
+		return err
+	}
+
+	var missing, required []string
+	for _, p := range profiles {
+		for _, enc := range []string{EncoderFor(p.VideoCodec, p.HWAccel), p.AudioCodec} {
+			if !slices.Contains(required, enc) {
+				required = append(required, enc)
+			}
+		}
+	}
+	for _, enc := range required {
+		if !available[enc] {
+			missing = append(missing, enc)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("ffmpeg lacks the %s encoders", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// Encoders returns the names of the encoders the installed FFmpeg has
+func Encoders() (map[string]bool, error) {
+	out, err := exec.Command("ffmpeg", "-hide_banner", "-encoders").Output()
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg not usable: %w", err)
+	}
+
+	// Encoders are listed as " V....D libx264   description"
+	available := make(map[string]bool)
+	for _, line := range strings.Split(string(out), "\n") {
+		if fields := strings.Fields(line); len(fields) >= 2 {
+			available[fields[1]] = true
+		}
+	}
+	return available, nil
+}
@@ -0,0 +1,209 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/kaero/streaming/config"
+)
+
+// LivePlaylist is the playlist players of a live channel load: the master
+// playlist when the stream is transcoded, the only media playlist
+// otherwise
+const LivePlaylist = "index.m3u8"
+
+// livePreset is the x264 and x265 preset of live transcodes, fast enough to
+// keep up with the stream on most machines
+const livePreset = "veryfast"
+
+// liveStderrBytes caps the FFmpeg output kept of a live stream, which runs
+// for hours
+const liveStderrBytes = 64 << 10
+
+// LiveJob is a live stream FFmpeg waits for and segments into HLS
+type LiveJob struct {
+	// Channel names the stream in the process list
+	Channel string
+	// Listen is the rtmp:// or srt:// URL the stream is pushed to
+	Listen    string
+	OutputDir string
+	// Profile is the transcode profile the stream is encoded to the
+	// variants of, nil to segment the stream as it is
+	Profile         *config.TranscodeProfile
+	SegmentDuration int
+	PlaylistEntries int
+	// RTP also sends the stream to local ports for WebRTC viewers, nil
+	// for HLS only
+	RTP *LiveRTP
+	// Progress receives progress reports once the stream is received
+	Progress func(Progress)
+}
+
+// LiveRTP are the local UDP ports a live stream is sent to as RTP, in the
+// codecs browsers play over WebRTC: H.264 without B-frames and Opus.
+// AudioPort is 0 for streams without audio.
+type LiveRTP struct {
+	VideoPort int
+	AudioPort int
+}
+
+// rtpArgs returns the FFmpeg outputs of a stream sent to rtp. Keyframes
+// come every second, as WebRTC viewers joining the stream wait for one.
+func rtpArgs(rtp *LiveRTP) []string {
+	args := []string{
+		"-map", "0:v:0", "-an",
+		"-c:v", SoftwareEncoder, "-preset", "ultrafast", "-tune", "zerolatency",
+		"-profile:v", "baseline", "-pix_fmt", "yuv420p", "-bf", "0",
+		"-force_key_frames", "expr:gte(t,n_forced*1)",
+		"-f", "rtp", "-payload_type", "96", fmt.Sprintf("rtp://127.0.0.1:%d?pkt_size=1200", rtp.VideoPort),
+	}
+	if rtp.AudioPort > 0 {
+		args = append(args,
+			"-map", "0:a:0", "-vn",
+			"-c:a", "libopus", "-ar", "48000", "-ac", "2", "-b:a", "128k",
+			"-f", "rtp", "-payload_type", "111", fmt.Sprintf("rtp://127.0.0.1:%d?pkt_size=1200", rtp.AudioPort),
+		)
+	}
+	return args
+}
+
+// RunLive waits for a stream to be pushed to job.Listen and writes it to
+// the output directory as live HLS until the stream ends, LivePlaylist
+// being what players load. FFmpeg is killed when ctx is cancelled.
+func (tm *Manager) RunLive(ctx context.Context, job LiveJob) (*JobResult, error) {
+	if err := os.MkdirAll(job.OutputDir, 0755); err != nil {
+		return &JobResult{}, err
+	}
+
+	args := []string{"-nostats", "-progress", "pipe:1"}
+	var variants []config.QualityVariant
+	var input, filters, codec []string
+	if job.Profile != nil {
+		variants = job.Profile.Variants
+		input, filters, codec = encoderArgs(EncoderFor(job.Profile.VideoCodec, job.Profile.HWAccel), livePreset)
+		args = append(args, input...)
+	}
+	args = append(args, listenArgs(job.Listen)...)
+
+	// Playlists keep a window of segments behind the live edge, older
+	// segments are deleted
+	hls := func(playlist string) []string {
+		return []string{
+			"-f", "hls",
+			"-hls_time", strconv.Itoa(job.SegmentDuration),
+			"-hls_list_size", strconv.Itoa(job.PlaylistEntries),
+			"-hls_flags", "delete_segments+independent_segments",
+			"-hls_segment_type", "mpegts",
+			"-hls_segment_filename", strings.TrimSuffix(playlist, ".m3u8") + "_%05d.ts",
+			playlist,
+		}
+	}
+	if len(variants) == 0 {
+		args = append(args, "-map", "0:v:0?", "-map", "0:a:0?", "-c", "copy")
+		args = append(args, hls(filepath.Join(job.OutputDir, LivePlaylist))...)
+	} else {
+		// One output per variant, with keyframes where segments start
+		p := job.Profile
+		keyframes := fmt.Sprintf("expr:gte(t,n_forced*%d)", job.SegmentDuration)
+		for _, q := range variants {
+			args = append(args, "-map", "0:v:0", "-map", "0:a:0?")
+			args = append(args, codec...)
+			scale := fmt.Sprintf("scale=%d:%d", q.Width, q.Height)
+			args = append(args, "-vf", videoFilter(scale, filters), "-b:v", q.Bitrate(), "-force_key_frames", keyframes)
+			args = append(args, "-c:a", p.AudioCodec, "-b:a", fmt.Sprintf("%dk", p.AudioBitrateKbps))
+			if p.AudioChannels > 0 {
+				args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+			}
+			args = append(args, hls(variantPlaylist(job.OutputDir, strings.TrimSuffix(LivePlaylist, ".m3u8"), q))...)
+		}
+		if _, err := GenerateHLSMasterPlaylist(strings.TrimSuffix(LivePlaylist, ".m3u8"), job.OutputDir, variants, nil); err != nil {
+			return &JobResult{}, err
+		}
+	}
+	if job.RTP != nil {
+		args = append(args, rtpArgs(job.RTP)...)
+	}
+
+	stderr := &tailBuffer{max: liveStderrBytes}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = stderr
+	if job.Progress != nil {
+		cmd.Stdout = &progressWriter{report: job.Progress}
+	}
+	result := &JobResult{Command: cmd.String()}
+	if err := cmd.Start(); err != nil {
+		return result, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	done := tm.trackProcess(cmd, "live:"+job.Channel, job.OutputDir)
+	err := cmd.Wait()
+	done()
+
+	result.StderrTail = tailLines(stderr.String(), stderrTailLines)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("live stream stopped: %w", ctx.Err())
+	}
+	if err != nil {
+		return result, fmt.Errorf("live stream failed: %v", err)
+	}
+	return result, nil
+}
+
+// LivePlayable reports whether the output of a live stream encoded with
+// profile, nil when it isn't transcoded, can be played. The master playlist
+// is written up front, the media playlists with the first segment.
+func LivePlayable(dir string, profile *config.TranscodeProfile) bool {
+	playlist := filepath.Join(dir, LivePlaylist)
+	if profile != nil && len(profile.Variants) > 0 {
+		playlist = variantPlaylist(dir, strings.TrimSuffix(LivePlaylist, ".m3u8"), profile.Variants[0])
+	}
+	_, err := os.Stat(playlist)
+	return err == nil
+}
+
+// listenArgs returns the input arguments making FFmpeg wait for a stream
+// pushed to listen instead of connecting to it
+func listenArgs(listen string) []string {
+	if strings.HasPrefix(strings.ToLower(listen), "srt:") {
+		if u, err := url.Parse(listen); err == nil && u.Query().Get("mode") == "" {
+			q := u.Query()
+			q.Set("mode", "listener")
+			u.RawQuery = q.Encode()
+			listen = u.String()
+		}
+		return []string{"-i", listen}
+	}
+	return []string{"-listen", "1", "-i", listen}
+}
+
+// tailBuffer keeps the last bytes written to it, at least max of them
+type tailBuffer struct {
+	max int
+	mu  sync.Mutex
+	buf []byte
+}
+
+// Write appends p, dropping all but the last max bytes once the buffer
+// grows past twice max
+func (b *tailBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > 2*b.max {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-b.max:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the bytes kept
+func (b *tailBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return string(b.buf)
+}
@@ -0,0 +1,263 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// ProbeResult holds the technical metadata of a media file as reported by ffprobe
+type ProbeResult struct {
+	Container  string
+	Duration   float64
+	StartTime  float64 // timestamp the file starts at, in seconds
+	VideoCodec string
+	Width      int
+	Height     int
+	FrameRate  float64
+	BitDepth   int
+	HDR        bool
+	// FieldOrder is ffprobe's field_order, e.g. "progressive" or "tt"
+	FieldOrder    string
+	AudioCodec    string
+	AudioChannels int
+	// CoverArt is set when the file embeds a picture, such as a poster
+	CoverArt  bool
+	Chapters  []Chapter
+	Subtitles []SubtitleStream
+	// Streams lists all streams of the file, including those not used
+	Streams []Stream
+}
+
+// Stream is a stream of a media file as reported by ffprobe
+type Stream struct {
+	Index    int
+	Type     string // "video", "audio", "subtitle", ...
+	Codec    string
+	Language string
+	Title    string
+	// Used is set for the streams that are transcoded
+	Used bool
+}
+
+// Interlaced reports whether the video stream is interlaced
+func (p *ProbeResult) Interlaced() bool {
+	switch p.FieldOrder {
+	case "tt", "bb", "tb", "bt":
+		return true
+	}
+	return false
+}
+
+// Chapter is a chapter marker of a media file, in seconds
+type Chapter struct {
+	Start float64
+	End   float64
+	Title string
+}
+
+// SubtitleStream is a subtitle track of a media file
+type SubtitleStream struct {
+	// Index is the stream index within the file, as used by -map 0:N
+	Index    int
+	Codec    string
+	Language string
+	Title    string
+	Default  bool
+	Forced   bool
+}
+
+// ffprobeOutput mirrors the parts of `ffprobe -print_format json` we use
+type ffprobeOutput struct {
+	Streams  []ffprobeStream  `json:"streams"`
+	Chapters []ffprobeChapter `json:"chapters"`
+	Format   struct {
+		FormatName string `json:"format_name"`
+		Duration   string `json:"duration"`
+		StartTime  string `json:"start_time"`
+	} `json:"format"`
+}
+
+type ffprobeStream struct {
+	Index            int    `json:"index"`
+	CodecType        string `json:"codec_type"`
+	CodecName        string `json:"codec_name"`
+	Width            int    `json:"width"`
+	Height           int    `json:"height"`
+	AvgFrameRate     string `json:"avg_frame_rate"`
+	RFrameRate       string `json:"r_frame_rate"`
+	PixFmt           string `json:"pix_fmt"`
+	BitsPerRawSample string `json:"bits_per_raw_sample"`
+	ColorTransfer    string `json:"color_transfer"`
+	FieldOrder       string `json:"field_order"`
+	Channels         int    `json:"channels"`
+	Disposition      struct {
+		AttachedPic int `json:"attached_pic"`
+		Default     int `json:"default"`
+		Forced      int `json:"forced"`
+	} `json:"disposition"`
+	Tags ffprobeTags `json:"tags"`
+}
+
+type ffprobeChapter struct {
+	StartTime string      `json:"start_time"`
+	EndTime   string      `json:"end_time"`
+	Tags      ffprobeTags `json:"tags"`
+}
+
+type ffprobeTags struct {
+	Language string `json:"language"`
+	Title    string `json:"title"`
+}
+
+// Probe runs ffprobe on a file and returns its technical metadata
+func Probe(path string) (*ProbeResult, error) {
+	cmd := exec.Command("ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		path,
+	)
+	output, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return nil, fmt.Errorf("ffprobe failed: %v: %s", err, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	return parseProbeOutput(output)
+}
+
+// Probe runs ffprobe on a video of the library, which may be read from a
+// remote source
+func (tm *Manager) Probe(videoPath string) (*ProbeResult, error) {
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		return nil, err
+	}
+	probe, err := Probe(input)
+	if err != nil && input != videoPath {
+		return nil, errors.New(redactInput(err.Error(), input, videoPath))
+	}
+	return probe, err
+}
+
+// parseProbeOutput converts ffprobe JSON output into a ProbeResult
+func parseProbeOutput(data []byte) (*ProbeResult, error) {
+	var out ffprobeOutput
+	if err := json.Unmarshal(data, &out); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	result := &ProbeResult{
+		Container: out.Format.FormatName,
+	}
+	result.Duration, _ = strconv.ParseFloat(out.Format.Duration, 64)
+	result.StartTime, _ = strconv.ParseFloat(out.Format.StartTime, 64)
+
+	for _, stream := range out.Streams {
+		result.Streams = append(result.Streams, Stream{
+			Index:    stream.Index,
+			Type:     stream.CodecType,
+			Codec:    stream.CodecName,
+			Language: stream.Tags.Language,
+			Title:    stream.Tags.Title,
+		})
+		used := &result.Streams[len(result.Streams)-1].Used
+
+		switch stream.CodecType {
+		case "video":
+			if stream.Disposition.AttachedPic == 1 {
+				result.CoverArt = true
+				continue
+			}
+			// Skip already picked streams
+			if result.VideoCodec != "" {
+				continue
+			}
+			*used = true
+			result.VideoCodec = stream.CodecName
+			result.Width = stream.Width
+			result.Height = stream.Height
+			result.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			if result.FrameRate == 0 {
+				result.FrameRate = parseFrameRate(stream.RFrameRate)
+			}
+			result.BitDepth = bitDepth(stream)
+			result.HDR = stream.ColorTransfer == "smpte2084" || stream.ColorTransfer == "arib-std-b67"
+			result.FieldOrder = stream.FieldOrder
+		case "audio":
+			// Audio streams after the first become audio renditions
+			*used = true
+			if result.AudioCodec != "" {
+				continue
+			}
+			result.AudioCodec = stream.CodecName
+			result.AudioChannels = stream.Channels
+		case "subtitle":
+			*used = textSubtitleCodecs[stream.CodecName]
+			result.Subtitles = append(result.Subtitles, SubtitleStream{
+				Index:    stream.Index,
+				Codec:    stream.CodecName,
+				Language: stream.Tags.Language,
+				Title:    stream.Tags.Title,
+				Default:  stream.Disposition.Default == 1,
+				Forced:   stream.Disposition.Forced == 1,
+			})
+		}
+	}
+
+	for _, chapter := range out.Chapters {
+		c := Chapter{Title: chapter.Tags.Title}
+		c.Start, _ = strconv.ParseFloat(chapter.StartTime, 64)
+		c.End, _ = strconv.ParseFloat(chapter.EndTime, 64)
+		result.Chapters = append(result.Chapters, c)
+	}
+
+	if result.VideoCodec == "" {
+		return nil, fmt.Errorf("no video stream found")
+	}
+
+	return result, nil
+}
+
+// parseFrameRate parses an ffprobe rational such as "30000/1001"
+func parseFrameRate(rate string) float64 {
+	num, den, ok := strings.Cut(rate, "/")
+	if !ok {
+		f, _ := strconv.ParseFloat(rate, 64)
+		return f
+	}
+
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// bitDepth determines the bit depth of a video stream, falling back to the
+// pixel format when ffprobe doesn't report bits_per_raw_sample
+func bitDepth(stream ffprobeStream) int {
+	if bits, err := strconv.Atoi(stream.BitsPerRawSample); err == nil && bits > 0 {
+		return bits
+	}
+
+	switch {
+	case strings.Contains(stream.PixFmt, "12le"), strings.Contains(stream.PixFmt, "12be"):
+		return 12
+	case strings.Contains(stream.PixFmt, "10le"), strings.Contains(stream.PixFmt, "10be"),
+		stream.PixFmt == "p010le":
+		return 10
+	case stream.PixFmt != "":
+		return 8
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+package transcoder
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// Progress is a progress report of a running transcode
+type Progress struct {
+	Variant string // e.g. "720p"
+	// Position is how far into the source the transcode got, in seconds
+	Position float64
+	// Speed is the transcoding speed relative to playback, 0 when unknown
+	Speed float64
+	// Frame is the number of frames written
+	Frame int64
+	Done  bool
+}
+
+// progressWriter parses the key=value blocks FFmpeg writes with -progress
+// and reports each completed block
+type progressWriter struct {
+	report  func(Progress)
+	buf     []byte
+	current Progress
+}
+
+// Write buffers FFmpeg output and parses every complete line
+func (w *progressWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.parseLine(strings.TrimSpace(string(w.buf[:i])))
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// parseLine handles one key=value line. A block ends with the "progress"
+// key, which is "end" once FFmpeg is done.
+func (w *progressWriter) parseLine(line string) {
+	key, value, _ := strings.Cut(line, "=")
+	switch key {
+	case "frame":
+		if frame, err := strconv.ParseInt(value, 10, 64); err == nil {
+			w.current.Frame = frame
+		}
+	case "out_time_us":
+		// Negative or N/A before the first frame was written
+		if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+			w.current.Position = float64(us) / 1e6
+		}
+	case "speed":
+		speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		if err != nil {
+			speed = 0
+		}
+		w.current.Speed = speed
+	case "progress":
+		w.current.Done = value == "end"
+		w.report(w.current)
+	}
+}
@@ -0,0 +1,107 @@
+package transcoder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+)
+
+// variantPlaylist returns the path of the playlist of a variant in dir
+func variantPlaylist(dir, videoFileName string, q config.QualityVariant) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_%d.m3u8", videoFileName, q.Height))
+}
+
+// fitQuota drops the variants with the highest bitrate from the output in
+// dir until it fits cache.max_video_size_gb, always keeping the variant
+// with the lowest bitrate. It returns the variants kept, in their original
+// order, and the names of those dropped.
+func (tm *Manager) fitQuota(dir, videoFileName string, qualities []config.QualityVariant) ([]config.QualityVariant, []string, error) {
+	limit := tm.settings().Cache.MaxVideoSizeBytes()
+	if limit <= 0 || len(qualities) == 0 {
+		return qualities, nil, nil
+	}
+
+	sizes := make([]int64, len(qualities))
+	var total int64
+	for i, q := range qualities {
+		sizes[i] = variantSize(variantPlaylist(dir, videoFileName, q))
+		total += sizes[i]
+	}
+	if total <= limit {
+		return qualities, nil, nil
+	}
+
+	// Highest bitrate first, the last one is kept regardless
+	order := make([]int, len(qualities))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(a, b int) int {
+		return qualities[b].VideoBitrateKbps - qualities[a].VideoBitrateKbps
+	})
+
+	drop := make(map[int]bool)
+	var dropped []string
+	for _, i := range order[:len(order)-1] {
+		if total <= limit {
+			break
+		}
+		if err := removeVariant(variantPlaylist(dir, videoFileName, qualities[i])); err != nil {
+			return nil, nil, err
+		}
+		drop[i] = true
+		dropped = append(dropped, qualities[i].Name())
+		total -= sizes[i]
+	}
+	if total > limit {
+		tm.log.Warn("Output exceeds cache.max_video_size_gb with a single variant", "video", videoFileName,
+			"size_bytes", total, "limit_bytes", limit)
+	}
+
+	var kept []config.QualityVariant
+	for i, q := range qualities {
+		if !drop[i] {
+			kept = append(kept, q)
+		}
+	}
+	return kept, dropped, nil
+}
+
+// removeVariant removes a variant playlist and its segments, which share
+// the playlist name without extension as a prefix
+func removeVariant(playlist string) error {
+	matches, err := filepath.Glob(globEscape(strings.TrimSuffix(playlist, ".m3u8")) + "*")
+	if err != nil {
+		return err
+	}
+	for _, match := range matches {
+		if err := os.Remove(match); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", filepath.Base(match), err)
+		}
+	}
+	return nil
+}
+
+// containerOverhead is the share MPEG-TS and fMP4 packaging adds on top of
+// the encoded streams, generously
+const containerOverhead = 1.1
+
+// EstimateOutputSize estimates the size of the output of a video of the
+// given duration from the target bitrates of its transcode profile. Only
+// the named variants are counted when names isn't empty, as when they are
+// repaired.
+func (tm *Manager) EstimateOutputSize(videoPath string, duration float64, names []string) int64 {
+	profile := tm.ProfileFor(videoPath)
+	var kbps int
+	for _, q := range profile.Variants {
+		if len(names) > 0 && !slices.Contains(names, q.Name()) {
+			continue
+		}
+		kbps += q.VideoBitrateKbps + profile.AudioBitrateKbps
+	}
+	return int64(duration * float64(kbps) * 1000 / 8 * containerOverhead)
+}
@@ -0,0 +1,80 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// recordStopTimeout is how long FFmpeg has to finish the file of a
+// recording when it is stopped
+const recordStopTimeout = 10 * time.Second
+
+// RecordJob is a network stream FFmpeg copies to a file for a set time
+type RecordJob struct {
+	// Name names the recording in the process list
+	Name string
+	// URL is the stream, over http(s), rtsp, rtmp or srt
+	URL        string
+	OutputPath string
+	Duration   time.Duration
+}
+
+// recordInputArgs returns the options of reading a network stream: HTTP
+// streams reconnect after dropouts and RTSP runs over TCP, which doesn't
+// lose packets on the way
+func recordInputArgs(url string) []string {
+	scheme, _, _ := strings.Cut(strings.ToLower(url), "://")
+	switch scheme {
+	case "http", "https":
+		return []string{"-reconnect", "1", "-reconnect_streamed", "1", "-reconnect_on_network_error", "1", "-reconnect_delay_max", "30"}
+	case "rtsp", "rtsps":
+		return []string{"-rtsp_transport", "tcp"}
+	}
+	return nil
+}
+
+// Record copies a network stream to a Matroska file until job.Duration has
+// passed or the stream ends. The streams are copied as they are, the
+// video and audio FFmpeg picks by default, which for HLS playlists are
+// those of the best variant. FFmpeg is stopped when ctx is cancelled,
+// leaving what was recorded so far.
+func (tm *Manager) Record(ctx context.Context, job RecordJob) (*JobResult, error) {
+	args := []string{"-nostats", "-y"}
+	args = append(args, recordInputArgs(job.URL)...)
+	args = append(args,
+		"-i", job.URL,
+		"-t", strconv.FormatFloat(job.Duration.Seconds(), 'f', 0, 64),
+		"-c", "copy", "-sn", "-dn",
+		"-f", "matroska", job.OutputPath,
+	)
+
+	// Recordings run for hours like live streams
+	stderr := &tailBuffer{max: liveStderrBytes}
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	// Interrupting FFmpeg lets it finish the file, it is killed when it
+	// doesn't stop in time
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = recordStopTimeout
+	cmd.Stderr = stderr
+	result := &JobResult{Command: cmd.String()}
+	if err := cmd.Start(); err != nil {
+		return result, fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	done := tm.trackProcess(cmd, "record:"+job.Name, job.OutputPath)
+	err := cmd.Wait()
+	done()
+
+	result.StderrTail = tailLines(stderr.String(), stderrTailLines)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("recording stopped: %w", ctx.Err())
+	}
+	if err != nil {
+		return result, fmt.Errorf("recording failed: %v", err)
+	}
+	return result, nil
+}
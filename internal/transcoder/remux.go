@@ -0,0 +1,49 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+)
+
+// RemuxToMP4 copies the first video and audio stream of a video into a
+// fragmented MP4 written to w, without re-encoding. Browsers can play the
+// result progressively, but can't seek past what was received. FFmpeg is
+// killed when ctx is cancelled, such as when the client disconnects.
+func (tm *Manager) RemuxToMP4(ctx context.Context, videoPath string, w io.Writer) error {
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		return err
+	}
+	args := []string{
+		"-v", "error",
+		"-i", input,
+		"-map", "0:v:0",
+		"-map", "0:a:0?",
+		"-c", "copy",
+		"-movflags", "frag_keyframe+empty_moov+default_base_moof",
+		"-f", "mp4",
+		"pipe:1",
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	defer tm.trackProcess(cmd, videoPath, "remux")()
+
+	if err := cmd.Wait(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("remuxing failed: %w: %s", err, redactInput(strings.TrimSpace(tailLines(stderr.String(), 5)), input, videoPath))
+	}
+	return nil
+}
@@ -0,0 +1,336 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/telemetry"
+)
+
+// Rendition types of the #EXT-X-MEDIA tags of a master playlist
+const (
+	RenditionAudio     = "AUDIO"
+	RenditionSubtitles = "SUBTITLES"
+)
+
+// Group IDs the variants refer to their renditions with
+const (
+	audioGroup     = "audio"
+	subtitlesGroup = "subs"
+)
+
+// Rendition is an audio or subtitle track of the output besides the audio
+// of the variants, listed in the master playlist with an #EXT-X-MEDIA tag
+type Rendition struct {
+	Type     string // RenditionAudio or RenditionSubtitles
+	Name     string
+	Language string
+	Default  bool
+	Forced   bool
+	// URI is the media playlist relative to the master playlist. It is
+	// empty for the audio muxed into the variants.
+	URI string
+}
+
+// textSubtitleCodecs are the subtitle codecs FFmpeg converts to WebVTT.
+// Picture based subtitles such as PGS and DVD subtitles are left out.
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"webvtt":   true,
+	"mov_text": true,
+	"text":     true,
+}
+
+// mediaURI matches the URI of an #EXT-X-MEDIA tag, a rendition of a
+// master playlist
+var mediaURI = regexp.MustCompile(`#EXT-X-MEDIA:.*URI="([^"]+)"`)
+
+// renditionPlaylist returns the path of the playlist of the rendition of
+// stream index of the source in dir, e.g. "movie.mkv_audio2.m3u8". The
+// names don't end in digits after the last underscore, so they are never
+// taken for a variant.
+func renditionPlaylist(dir, videoFileName, kind string, index int) string {
+	return filepath.Join(dir, fmt.Sprintf("%s_%s%d.m3u8", videoFileName, kind, index))
+}
+
+// transcodeRenditions writes the audio streams of a video after the first
+// one, which the variants carry, as audio renditions into workDir, and its
+// text subtitles as WebVTT subtitle renditions. qualities are the variants
+// already in workDir, whose segments the subtitles are aligned with. A
+// rendition that fails is left out, the video plays without it. The jobs
+// are recorded in result.
+func (tm *Manager) transcodeRenditions(ctx context.Context, videoPath, workDir, videoFileName string, profile config.TranscodeProfile, qualities []config.QualityVariant, probe *ProbeResult, result *PrepareResult) []Rendition {
+	var audio []Stream
+	for _, s := range probe.Streams {
+		if s.Type == "audio" {
+			audio = append(audio, s)
+		}
+	}
+	var subtitles []SubtitleStream
+	for _, s := range probe.Subtitles {
+		if textSubtitleCodecs[s.Codec] {
+			subtitles = append(subtitles, s)
+		}
+	}
+	if len(audio) < 2 && len(subtitles) == 0 {
+		return nil
+	}
+
+	ctx, span := telemetry.Start(ctx, "transcode renditions", "audio", len(audio)-1, "subtitles", len(subtitles))
+	defer span.End()
+
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		span.SetError(err)
+		tm.log.Warn("Error transcoding audio and subtitle tracks", "video", videoPath, "err", err)
+		return nil
+	}
+
+	var renditions []Rendition
+	for i, s := range audio {
+		r := Rendition{Type: RenditionAudio, Name: trackName(s.Title, s.Language, "Audio", i+1), Language: trackLanguage(s.Language)}
+		if i == 0 {
+			// The audio of the variants
+			r.Default = true
+			renditions = append(renditions, r)
+			continue
+		}
+
+		playlist := renditionPlaylist(workDir, videoFileName, "audio", s.Index)
+		job, err := tm.transcodeAudio(ctx, VideoJob{SourceFile: videoPath, Input: input, OutputPath: playlist, Profile: profile}, s.Index)
+		result.Jobs = append(result.Jobs, job)
+		if err == nil {
+			var segments []string
+			if segments, err = VerifyVariant(playlist); err == nil {
+				err = VerifySegments(segments)
+			}
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			tm.log.Warn("Error transcoding audio track", "video", videoPath, "stream", s.Index, "err", err)
+			continue
+		}
+		r.URI = filepath.Base(playlist)
+		renditions = append(renditions, r)
+	}
+	if len(renditions) == 1 {
+		// Only the audio of the variants is left
+		renditions = nil
+	}
+
+	offset := segmentsOffset(variantPlaylist(workDir, videoFileName, qualities[0]))
+	for i, s := range subtitles {
+		playlist := renditionPlaylist(workDir, videoFileName, "sub", s.Index)
+		job, err := tm.extractSubtitles(ctx, VideoJob{SourceFile: videoPath, Input: input, OutputPath: playlist}, s.Index, probe.Duration, offset)
+		result.Jobs = append(result.Jobs, job)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			tm.log.Warn("Error extracting subtitles", "video", videoPath, "stream", s.Index, "err", err)
+			continue
+		}
+		renditions = append(renditions, Rendition{
+			Type:     RenditionSubtitles,
+			Name:     trackName(s.Title, s.Language, "Subtitles", i+1),
+			Language: trackLanguage(s.Language),
+			Default:  s.Default,
+			Forced:   s.Forced,
+			URI:      filepath.Base(playlist),
+		})
+	}
+	return renditions
+}
+
+// transcodeAudio transcodes stream index of a video into an audio only HLS
+// rendition at job.OutputPath, with the audio settings and segments of
+// the variants
+func (tm *Manager) transcodeAudio(ctx context.Context, job VideoJob, index int) (*JobResult, error) {
+	p := job.Profile
+	source := job.SourceFile
+	if job.Input != "" {
+		source = job.Input
+	}
+	args := []string{"-i", source, "-map", fmt.Sprintf("0:%d", index), "-vn",
+		"-c:a", p.AudioCodec, "-b:a", fmt.Sprintf("%dk", p.AudioBitrateKbps)}
+	if p.AudioChannels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
+	}
+	args = append(args,
+		"-f", "hls",
+		"-hls_time", strconv.Itoa(p.SegmentDuration),
+		"-hls_segment_type", p.SegmentFormat,
+		"-hls_list_size", strconv.Itoa(tm.settings().Server.PlaylistEntries),
+		"-hls_playlist_type", "event",
+		"-hls_segment_filename", fmt.Sprintf("%s%%03d.ts", strings.TrimSuffix(job.OutputPath, ".m3u8")),
+		job.OutputPath,
+	)
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	result := &JobResult{Command: redactInput(cmd.String(), source, job.SourceFile)}
+	output, err := tm.runFFmpeg(cmd, job)
+	result.StderrTail = redactInput(tailLines(string(output), stderrTailLines), source, job.SourceFile)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("transcoding cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		return result, fmt.Errorf("transcoding failed: %v", err)
+	}
+	return result, nil
+}
+
+// extractSubtitles converts subtitle stream index of a video to WebVTT
+// next to job.OutputPath, and writes the subtitle playlist listing it as
+// a single segment of duration seconds. offset is where the segments of
+// the variants start, in 90 kHz MPEG-TS ticks, which players line up the
+// cues with.
+func (tm *Manager) extractSubtitles(ctx context.Context, job VideoJob, index int, duration float64, offset int64) (*JobResult, error) {
+	if duration <= 0 {
+		return &JobResult{}, fmt.Errorf("video has no known duration")
+	}
+	source := job.SourceFile
+	if job.Input != "" {
+		source = job.Input
+	}
+	vtt := strings.TrimSuffix(job.OutputPath, ".m3u8") + ".vtt"
+
+	cmd := exec.CommandContext(ctx, "ffmpeg", "-i", source, "-map", fmt.Sprintf("0:%d", index),
+		"-c:s", "webvtt", "-f", "webvtt", vtt)
+	result := &JobResult{Command: redactInput(cmd.String(), source, job.SourceFile)}
+	output, err := tm.runFFmpeg(cmd, job)
+	result.StderrTail = redactInput(tailLines(string(output), stderrTailLines), source, job.SourceFile)
+	if ctx.Err() != nil {
+		return result, fmt.Errorf("extraction cancelled: %w", ctx.Err())
+	}
+	if err != nil {
+		return result, fmt.Errorf("extraction failed: %v", err)
+	}
+
+	if err := addTimestampMap(vtt, offset); err != nil {
+		return result, err
+	}
+	return result, writeSubtitlePlaylist(job.OutputPath, filepath.Base(vtt), duration)
+}
+
+// addTimestampMap adds the X-TIMESTAMP-MAP header to a WebVTT file, which
+// maps its start to offset in the MPEG-TS timeline of the segments. The
+// file is left as it is when the offset is unknown.
+func addTimestampMap(vtt string, offset int64) error {
+	if offset <= 0 {
+		return nil
+	}
+	content, err := os.ReadFile(vtt)
+	if err != nil {
+		return fmt.Errorf("failed to read subtitles: %w", err)
+	}
+	header, rest, _ := strings.Cut(string(content), "\n")
+	content = []byte(fmt.Sprintf("%s\nX-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000\n%s", strings.TrimRight(header, "\r"), offset, rest))
+	if err := os.WriteFile(vtt, content, 0644); err != nil {
+		return fmt.Errorf("failed to write subtitles: %w", err)
+	}
+	return nil
+}
+
+// writeSubtitlePlaylist writes a subtitle playlist listing the WebVTT file
+// named vtt as its only segment
+func writeSubtitlePlaylist(playlist, vtt string, duration float64) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", int(math.Ceil(duration)))
+	b.WriteString("#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n")
+	fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n#EXT-X-ENDLIST\n", duration, vtt)
+	if err := os.WriteFile(playlist, []byte(b.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write subtitle playlist: %w", err)
+	}
+	return nil
+}
+
+// segmentsOffset returns where the segments of a variant playlist start in
+// 90 kHz MPEG-TS ticks, FFmpeg moving the start of the source a bit later,
+// or 0 when it can't be read, such as for fMP4 segments
+func segmentsOffset(playlist string) int64 {
+	segments, err := VerifyVariant(playlist)
+	if err != nil {
+		return 0
+	}
+	probe, err := Probe(segments[0])
+	if err != nil {
+		return 0
+	}
+	return int64(math.Round(probe.StartTime * 90000))
+}
+
+// trackName names a track after its title or language, or else after its
+// kind and number, e.g. "Audio 2"
+func trackName(title, language, kind string, n int) string {
+	switch {
+	case title != "":
+		return title
+	case trackLanguage(language) != "":
+		return language
+	}
+	return fmt.Sprintf("%s %d", kind, n)
+}
+
+// trackLanguage returns the language of a track, "" when it is unknown
+func trackLanguage(language string) string {
+	if language == "und" {
+		return ""
+	}
+	return language
+}
+
+// mediaTag returns the #EXT-X-MEDIA tag of a rendition
+func (r Rendition) mediaTag() string {
+	group := audioGroup
+	if r.Type == RenditionSubtitles {
+		group = subtitlesGroup
+	}
+	tag := fmt.Sprintf("#EXT-X-MEDIA:TYPE=%s,GROUP-ID=\"%s\",NAME=\"%s\"", r.Type, group, strings.ReplaceAll(r.Name, `"`, "'"))
+	if r.Language != "" {
+		tag += fmt.Sprintf(",LANGUAGE=\"%s\"", strings.ReplaceAll(r.Language, `"`, ""))
+	}
+	tag += ",DEFAULT=" + yesNo(r.Default) + ",AUTOSELECT=YES"
+	if r.Type == RenditionSubtitles {
+		tag += ",FORCED=" + yesNo(r.Forced)
+	}
+	if r.URI != "" {
+		tag += fmt.Sprintf(",URI=\"%s\"", r.URI)
+	}
+	return tag
+}
+
+// yesNo formats a boolean attribute of a playlist tag
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// RenditionURIs returns the media playlists of the renditions a master
+// playlist lists
+func RenditionURIs(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("playlist %s is missing", filepath.Base(path))
+	}
+	var uris []string
+	for _, m := range mediaURI.FindAllSubmatch(content, -1) {
+		uris = append(uris, string(m[1]))
+	}
+	return uris, nil
+}
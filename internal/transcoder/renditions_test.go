@@ -0,0 +1,128 @@
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kaero/streaming/config"
+)
+
+func TestGenerateHLSMasterPlaylistRenditions(t *testing.T) {
+	dir := t.TempDir()
+	qualities := []config.QualityVariant{
+		{Width: 1280, Height: 720, VideoBitrateKbps: 2800},
+		{Width: 640, Height: 360, VideoBitrateKbps: 800},
+	}
+	renditions := []Rendition{
+		{Type: RenditionAudio, Name: "English", Language: "eng", Default: true},
+		{Type: RenditionAudio, Name: `Director's "commentary"`, Language: "eng", URI: "movie.mkv_audio2.m3u8"},
+		{Type: RenditionSubtitles, Name: "Français", Language: "fre", Forced: true, URI: "movie.mkv_sub3.m3u8"},
+	}
+
+	master, err := GenerateHLSMasterPlaylist("movie.mkv", dir, qualities, renditions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := os.ReadFile(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := `#EXTM3U
+#EXT-X-VERSION:3
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="English",LANGUAGE="eng",DEFAULT=YES,AUTOSELECT=YES
+#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="audio",NAME="Director's 'commentary'",LANGUAGE="eng",DEFAULT=NO,AUTOSELECT=YES,URI="movie.mkv_audio2.m3u8"
+#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="subs",NAME="Français",LANGUAGE="fre",DEFAULT=NO,AUTOSELECT=YES,FORCED=YES,URI="movie.mkv_sub3.m3u8"
+#EXT-X-STREAM-INF:BANDWIDTH=2800000,RESOLUTION=1280x720,NAME="720p",AUDIO="audio",SUBTITLES="subs"
+movie.mkv_720.m3u8
+#EXT-X-STREAM-INF:BANDWIDTH=800000,RESOLUTION=640x360,NAME="360p",AUDIO="audio",SUBTITLES="subs"
+movie.mkv_360.m3u8
+`
+	if string(content) != want {
+		t.Errorf("master playlist:\n%s\nwant:\n%s", content, want)
+	}
+
+	uris, err := RenditionURIs(master)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(uris, " ") != "movie.mkv_audio2.m3u8 movie.mkv_sub3.m3u8" {
+		t.Errorf("RenditionURIs = %q", uris)
+	}
+}
+
+func TestGenerateHLSMasterPlaylistWithoutRenditions(t *testing.T) {
+	dir := t.TempDir()
+	master, err := GenerateHLSMasterPlaylist("movie.mkv", dir, []config.QualityVariant{{Width: 640, Height: 360, VideoBitrateKbps: 800}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(master)
+	if strings.Contains(string(content), "EXT-X-MEDIA") || strings.Contains(string(content), "AUDIO=") {
+		t.Errorf("master playlist lists renditions:\n%s", content)
+	}
+}
+
+func TestSubtitleRendition(t *testing.T) {
+	dir := t.TempDir()
+	vtt := filepath.Join(dir, "movie.mkv_sub3.vtt")
+	cues := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHello\n"
+	if err := os.WriteFile(vtt, []byte(cues), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := addTimestampMap(vtt, 126000); err != nil {
+		t.Fatal(err)
+	}
+	content, _ := os.ReadFile(vtt)
+	want := "WEBVTT\nX-TIMESTAMP-MAP=MPEGTS:126000,LOCAL:00:00:00.000\n\n00:00:01.000 --> 00:00:02.000\nHello\n"
+	if string(content) != want {
+		t.Errorf("subtitles:\n%q\nwant:\n%q", content, want)
+	}
+
+	playlist := renditionPlaylist(dir, "movie.mkv", "sub", 3)
+	if err := writeSubtitlePlaylist(playlist, filepath.Base(vtt), 125.5); err != nil {
+		t.Fatal(err)
+	}
+	segments, err := VerifyVariant(playlist)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 1 || segments[0] != vtt {
+		t.Errorf("segments = %q, want %q", segments, vtt)
+	}
+	content, _ = os.ReadFile(playlist)
+	if !strings.Contains(string(content), "#EXT-X-TARGETDURATION:126\n") || !strings.Contains(string(content), "#EXTINF:125.500,\n") {
+		t.Errorf("subtitle playlist:\n%s", content)
+	}
+}
+
+func TestAddTimestampMapUnknownOffset(t *testing.T) {
+	vtt := filepath.Join(t.TempDir(), "sub.vtt")
+	os.WriteFile(vtt, []byte("WEBVTT\n"), 0644)
+	if err := addTimestampMap(vtt, 0); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := os.ReadFile(vtt); string(content) != "WEBVTT\n" {
+		t.Errorf("subtitles changed to %q", content)
+	}
+}
+
+func TestTrackName(t *testing.T) {
+	tests := []struct {
+		title, language string
+		want            string
+	}{
+		{"Commentary", "eng", "Commentary"},
+		{"", "eng", "eng"},
+		{"", "und", "Audio 2"},
+		{"", "", "Audio 2"},
+	}
+	for _, tt := range tests {
+		if got := trackName(tt.title, tt.language, "Audio", 2); got != tt.want {
+			t.Errorf("trackName(%q, %q) = %q, want %q", tt.title, tt.language, got, tt.want)
+		}
+	}
+}
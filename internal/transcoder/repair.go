@@ -0,0 +1,95 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/utils"
+)
+
+// RepairVariants transcodes the named variants of a video again and puts
+// them into its existing output in dir, leaving the other variants and the
+// master playlist alone. base is the name of the master playlist without
+// extension, which the names of all output files start with. The result
+// describes the repaired variants only.
+func (tm *Manager) RepairVariants(ctx context.Context, videoPath, dir, base string, names []string, progress func(Progress)) (*PrepareResult, error) {
+	result := &PrepareResult{OutputDir: dir, MasterPath: filepath.Join(dir, base+".m3u8")}
+
+	profile := tm.ProfileFor(videoPath)
+	var qualities []config.QualityVariant
+	for _, name := range names {
+		i := slices.IndexFunc(profile.Variants, func(q config.QualityVariant) bool { return q.Name() == name })
+		if i < 0 {
+			return result, fmt.Errorf("variant %s is not in transcode profile %s anymore", name, profile.Name)
+		}
+		qualities = append(qualities, profile.Variants[i])
+	}
+
+	workDir, err := tm.newWorkDir(dir)
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(workDir)
+
+	if err := tm.transcodeVariants(ctx, videoPath, workDir, base, profile, qualities, progress, result); err != nil {
+		return result, err
+	}
+	for _, q := range qualities {
+		segments, err := VerifyVariant(variantPlaylist(workDir, base, q))
+		if err == nil {
+			err = VerifySegments(segments)
+		}
+		if err != nil {
+			return result, fmt.Errorf("incomplete output: %w", err)
+		}
+	}
+
+	for _, q := range qualities {
+		if err := replaceVariant(workDir, dir, base, q); err != nil {
+			return result, err
+		}
+	}
+	result.Variants = reportVariants(dir, base, qualities)
+	return result, nil
+}
+
+// replaceVariant moves a variant from workDir into dir. The segments go
+// first and the playlist last, so players streaming the variant meanwhile
+// find every segment their playlist lists. Segments of the old variant the
+// new playlist doesn't list are removed afterwards.
+func replaceVariant(workDir, dir, base string, q config.QualityVariant) error {
+	playlist := variantPlaylist(workDir, base, q)
+	segments, err := VerifyVariant(playlist)
+	if err != nil {
+		return err
+	}
+
+	keep := map[string]bool{filepath.Base(playlist): true}
+	for _, segment := range segments {
+		rel, err := filepath.Rel(workDir, segment)
+		if err != nil {
+			return err
+		}
+		if err := utils.MoveFile(segment, filepath.Join(dir, rel)); err != nil {
+			return fmt.Errorf("failed to replace %s: %w", rel, err)
+		}
+		keep[rel] = true
+	}
+	target := variantPlaylist(dir, base, q)
+	if err := utils.MoveFile(playlist, target); err != nil {
+		return fmt.Errorf("failed to replace %s: %w", filepath.Base(target), err)
+	}
+
+	stale, _ := filepath.Glob(globEscape(strings.TrimSuffix(target, ".m3u8")) + "*")
+	for _, path := range stale {
+		if !keep[filepath.Base(path)] {
+			os.Remove(path)
+		}
+	}
+	return nil
+}
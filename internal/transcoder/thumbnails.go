@@ -0,0 +1,115 @@
+package transcoder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Seek preview files in a video's cache directory: a sprite sheet of
+// thumbnails and a WebVTT index telling which part of it shows which time
+// of the video
+const (
+	ThumbnailsFile  = "thumbnails.jpg"
+	ThumbnailsIndex = "thumbnails.vtt"
+)
+
+// Seek preview layout
+const (
+	// thumbnailWidth is the width of a thumbnail, the height follows the
+	// aspect ratio of the video
+	thumbnailWidth = 160
+	// thumbnailColumns is the number of thumbnails per row of the sheet
+	thumbnailColumns = 10
+	// maxThumbnails caps the thumbnails of long videos, which are taken
+	// further apart instead
+	maxThumbnails = 100
+	// minThumbnailInterval is the shortest time between thumbnails
+	minThumbnailInterval = 10
+)
+
+// ExtractThumbnails writes the seek previews of a video into dir: a sprite
+// sheet with a thumbnail every 10 seconds, or 100 thumbnails spread over
+// longer videos, and a WebVTT file indexing it. Only keyframes are
+// decoded, so thumbnails show the keyframe nearest to their time, which
+// keeps extracting them fast.
+func (tm *Manager) ExtractThumbnails(ctx context.Context, videoPath, dir string) error {
+	probe, err := tm.Probe(videoPath)
+	if err != nil {
+		return err
+	}
+	if probe.Duration <= 0 || probe.Width <= 0 || probe.Height <= 0 {
+		return fmt.Errorf("video has no known duration or size")
+	}
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		return err
+	}
+
+	interval := math.Max(minThumbnailInterval, math.Ceil(probe.Duration/maxThumbnails))
+	count := int(math.Ceil(probe.Duration / interval))
+	rows := (count + thumbnailColumns - 1) / thumbnailColumns
+	height := int(math.Round(float64(thumbnailWidth)*float64(probe.Height)/float64(probe.Width)/2)) * 2
+
+	tmp, err := os.CreateTemp(dir, ".thumbnails-*.jpg")
+	if err != nil {
+		return fmt.Errorf("failed to create thumbnails file: %w", err)
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{
+		"-v", "error", "-y",
+		"-skip_frame", "nokey",
+		"-i", input,
+		"-map", "0:V:0",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:%d,tile=%dx%d", interval, thumbnailWidth, height, thumbnailColumns, rows),
+		"-frames:v", "1", "-q:v", "5", "-f", "image2", tmp.Name(),
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start ffmpeg: %w", err)
+	}
+	defer tm.trackProcess(cmd, videoPath, filepath.Join(dir, ThumbnailsFile))()
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("thumbnail extraction failed: %w: %s", err, redactInput(strings.TrimSpace(tailLines(stderr.String(), 5)), input, videoPath))
+	}
+	if info, err := os.Stat(tmp.Name()); err != nil || info.Size() == 0 {
+		return fmt.Errorf("thumbnail extraction produced no image")
+	}
+
+	var index strings.Builder
+	index.WriteString("WEBVTT\n")
+	for i := 0; i < count; i++ {
+		start := float64(i) * interval
+		end := math.Min(start+interval, probe.Duration)
+		fmt.Fprintf(&index, "\n%s --> %s\n%s#xywh=%d,%d,%d,%d\n",
+			vttTime(start), vttTime(end), ThumbnailsFile,
+			(i%thumbnailColumns)*thumbnailWidth, (i/thumbnailColumns)*height, thumbnailWidth, height)
+	}
+	if err := os.WriteFile(filepath.Join(dir, ThumbnailsIndex), []byte(index.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write thumbnails index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(dir, ThumbnailsFile)); err != nil {
+		return fmt.Errorf("failed to store thumbnails: %w", err)
+	}
+	return nil
+}
+
+// vttTime formats seconds as a WebVTT timestamp, e.g. "01:02:03.500"
+func vttTime(seconds float64) string {
+	d := time.Duration(seconds * float64(time.Second)).Round(time.Millisecond)
+	return fmt.Sprintf("%02d:%02d:%02d.%03d",
+		int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60, d.Milliseconds()%1000)
+}
@@ -1,40 +1,181 @@
 package transcoder
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/kaero/streaming/config"
+	"github.com/kaero/streaming/internal/storage"
+	"github.com/kaero/streaming/internal/telemetry"
+	"github.com/kaero/streaming/internal/utils"
 )
 
 // VideoJob represents a transcoding task
 type VideoJob struct {
-	SourceFile      string
-	OutputPath      string
-	Width           int
-	Height          int
-	Bitrate         string
-	SegmentDuration int
+	SourceFile string
+	// Input is what FFmpeg reads the source from when that isn't
+	// SourceFile, such as the URL of a video of a remote library
+	Input      string
+	OutputPath string
+	Variant    config.QualityVariant
+	Profile    config.TranscodeProfile
+	// Progress receives progress reports while FFmpeg runs, if set
+	Progress func(Progress)
 }
 
+// JobResult describes a finished FFmpeg invocation
+type JobResult struct {
+	Command    string
+	StderrTail string
+}
+
+// PrepareResult describes the outcome of preparing a video for streaming
+type PrepareResult struct {
+	OutputDir  string
+	MasterPath string
+	Jobs       []*JobResult
+	Variants   []Variant
+	// Dropped are the variants removed to fit cache.max_video_size_gb
+	Dropped []string
+}
+
+// Variant describes a transcoded quality level of a video
+type Variant struct {
+	Name      string // e.g. "720p"
+	Playlist  string // absolute path of the variant playlist
+	SizeBytes int64  // combined size of the playlist and its segments
+	// Checksum is the VariantChecksum of the segments
+	Checksum string
+}
+
+// Commands returns the FFmpeg commands run for all variants, one per line
+func (r *PrepareResult) Commands() string {
+	var cmds []string
+	for _, job := range r.Jobs {
+		if job.Command != "" {
+			cmds = append(cmds, job.Command)
+		}
+	}
+	return strings.Join(cmds, "\n")
+}
+
+// StderrTail returns the combined stderr tails of all variants
+func (r *PrepareResult) StderrTail() string {
+	var tails []string
+	for _, job := range r.Jobs {
+		if job.StderrTail != "" {
+			tails = append(tails, job.StderrTail)
+		}
+	}
+	return strings.Join(tails, "\n---\n")
+}
+
+// stderrTailLines is the number of FFmpeg output lines kept per job
+const stderrTailLines = 30
+
 // Manager handles the transcoding operations
 type Manager struct {
 	activeJobs map[string]bool
+	processes  map[int]Process
 	mutex      sync.Mutex
 	config     *config.Config
+	log        *slog.Logger
+}
+
+// Process describes a running FFmpeg process
+type Process struct {
+	PID       int       `json:"pid"`
+	Source    string    `json:"source"`
+	Output    string    `json:"output"`
+	StartedAt time.Time `json:"started_at"`
 }
 
-// NewManager creates a new transcoding manager
-func NewManager(cfg *config.Config) *Manager {
+// NewManager creates a new transcoding manager logging to logger
+func NewManager(cfg *config.Config, logger *slog.Logger) *Manager {
 	return &Manager{
 		activeJobs: make(map[string]bool),
+		processes:  make(map[int]Process),
 		config:     cfg,
+		log:        logger,
+	}
+}
+
+// settings returns the configuration in effect
+func (tm *Manager) settings() *config.Config {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	return tm.config
+}
+
+// Reload switches to a reloaded configuration, which applies to the jobs
+// started afterwards
+func (tm *Manager) Reload(cfg *config.Config) {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	tm.config = cfg
+}
+
+// ActiveProcesses returns the FFmpeg processes currently run by this manager
+func (tm *Manager) ActiveProcesses() []Process {
+	tm.mutex.Lock()
+	defer tm.mutex.Unlock()
+	
+	procs := make([]Process, 0, len(tm.processes))
+	for _, p := range tm.processes {
+		procs = append(procs, p)
+	}
+	sort.Slice(procs, func(i, j int) bool { return procs[i].StartedAt.Before(procs[j].StartedAt) })
+	return procs
+}
+
+// runFFmpeg runs an FFmpeg command to completion, tracking it as an active
+// process while it runs, and returns its combined output. Stdout is left
+// alone if the command already writes it elsewhere.
+func (tm *Manager) runFFmpeg(cmd *exec.Cmd, job VideoJob) ([]byte, error) {
+	var output bytes.Buffer
+	if cmd.Stdout == nil {
+		cmd.Stdout = &output
+	}
+	cmd.Stderr = &output
+	
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	
+	defer tm.trackProcess(cmd, job.SourceFile, job.OutputPath)()
+	
+	err := cmd.Wait()
+	return output.Bytes(), err
+}
+
+// trackProcess lists a started FFmpeg command as an active process until
+// the returned function is called, logging the command at debug level
+func (tm *Manager) trackProcess(cmd *exec.Cmd, source, output string) func() {
+	pid := cmd.Process.Pid
+	tm.log.Debug("Running FFmpeg", "pid", pid, "command", cmd.String())
+	tm.mutex.Lock()
+	tm.processes[pid] = Process{
+		PID:       pid,
+		Source:    source,
+		Output:    output,
+		StartedAt: time.Now(),
+	}
+	tm.mutex.Unlock()
+	
+	return func() {
+		tm.mutex.Lock()
+		delete(tm.processes, pid)
+		tm.mutex.Unlock()
 	}
 }
 
@@ -56,86 +197,194 @@ func (tm *Manager) SetJobActive(jobKey string, active bool) {
 	}
 }
 
-// TranscodeToHLS transcodes a video file to HLS format
-func (tm *Manager) TranscodeToHLS(job VideoJob) error {
+// TranscodeToHLS transcodes a video file to HLS format. FFmpeg is killed
+// when ctx is cancelled.
+func (tm *Manager) TranscodeToHLS(ctx context.Context, job VideoJob) (*JobResult, error) {
 	// Create a unique key for this job
-	jobKey := fmt.Sprintf("%s_%d_%d_%s", job.SourceFile, job.Width, job.Height, job.Bitrate)
+	q := job.Variant
+	jobKey := fmt.Sprintf("%s_%d_%d_%d", job.SourceFile, q.Width, q.Height, q.VideoBitrateKbps)
 	
 	// Check if this job is already in progress
 	if tm.IsJobActive(jobKey) {
-		return nil
+		return &JobResult{}, nil
 	}
 	
 	// Mark job as active
 	tm.SetJobActive(jobKey, true)
 	defer tm.SetJobActive(jobKey, false)
 	
+	ctx, span := telemetry.Start(ctx, "ffmpeg "+q.Name(), "variant", q.Name(), "profile", job.Profile.Name)
+	defer span.End()
+	
 	// Create output directory if it doesn't exist
 	if err := os.MkdirAll(filepath.Dir(job.OutputPath), 0755); err != nil {
-		return err
+		return &JobResult{}, err
 	}
 	
-	// Build FFmpeg command for HLS transcoding
-	args := []string{
-		"-i", job.SourceFile,
-		"-c:v", "libx264",
-		"-crf", "23",
-		"-preset", tm.config.Server.TranscodePreset,
-		"-c:a", "aac",
-		"-b:a", "128k",
+	// Build FFmpeg command for HLS transcoding with the encoders of the profile
+	p := job.Profile
+	input, filters, codec := encoderArgs(EncoderFor(p.VideoCodec, p.HWAccel), p.Preset)
+	source := job.SourceFile
+	if job.Input != "" {
+		source = job.Input
 	}
-	
-	// Add resolution parameters if specified
-	if job.Width > 0 && job.Height > 0 {
-		args = append(args, "-vf", fmt.Sprintf("scale=%d:%d", job.Width, job.Height))
+	args := append(input, "-i", source)
+	// The first video and audio stream, as probed, which the audio
+	// renditions of the other audio streams go with
+	args = append(args, "-map", "0:V:0", "-map", "0:a:0?")
+	args = append(args, codec...)
+	args = append(args, "-c:a", p.AudioCodec, "-b:a", fmt.Sprintf("%dk", p.AudioBitrateKbps))
+	if p.AudioChannels > 0 {
+		args = append(args, "-ac", strconv.Itoa(p.AudioChannels))
 	}
 	
-	// Add bitrate if specified
-	if job.Bitrate != "" {
-		args = append(args, "-b:v", job.Bitrate)
-	}
+	// Scale to the variant and encode at its bitrate
+	scale := fmt.Sprintf("scale=%d:%d", q.Width, q.Height)
+	args = append(args, "-vf", videoFilter(scale, filters), "-b:v", q.Bitrate())
 	
 	// Add HLS specific parameters
 	args = append(args, 
 		"-f", "hls",
-		"-hls_time", strconv.Itoa(job.SegmentDuration),
-		"-hls_segment_type", tm.config.Server.SegmentFormat,
-		"-hls_list_size", strconv.Itoa(tm.config.Server.PlaylistEntries),
+		"-hls_time", strconv.Itoa(p.SegmentDuration),
+		"-hls_segment_type", p.SegmentFormat,
+		"-hls_list_size", strconv.Itoa(tm.settings().Server.PlaylistEntries),
 		"-hls_playlist_type", "event",
 		"-hls_segment_filename", fmt.Sprintf("%s%%03d.ts", strings.TrimSuffix(job.OutputPath, ".m3u8")),
 		job.OutputPath,
 	)
 	
+	// Report progress through stdout
+	if job.Progress != nil {
+		args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	}
+	
 	// Execute FFmpeg command
-	cmd := exec.Command("ffmpeg", args...)
-	output, err := cmd.CombinedOutput()
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	if job.Progress != nil {
+		cmd.Stdout = &progressWriter{report: job.Progress}
+	}
+	result := &JobResult{Command: redactInput(cmd.String(), source, job.SourceFile)}
+	output, err := tm.runFFmpeg(cmd, job)
+	result.StderrTail = redactInput(tailLines(string(output), stderrTailLines), source, job.SourceFile)
+	if ctx.Err() != nil {
+		span.SetError(ctx.Err())
+		return result, fmt.Errorf("transcoding cancelled: %w", ctx.Err())
+	}
 	if err != nil {
-		log.Printf("FFmpeg error: %v\nOutput: %s\n", err, output)
-		return fmt.Errorf("transcoding failed: %v", err)
+		span.SetError(err)
+		tm.log.Error("FFmpeg failed", "err", err, "output", result.StderrTail)
+		return result, fmt.Errorf("transcoding failed: %v", err)
 	}
 	
-	return nil
+	return result, nil
+}
+
+// variantSize returns the combined size of a variant playlist and its
+// segments, which share the playlist name without extension as a prefix
+func variantSize(playlist string) int64 {
+	matches, _ := filepath.Glob(globEscape(strings.TrimSuffix(playlist, ".m3u8")) + "*")
+	var size int64
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// globEscape escapes glob metacharacters in a literal path
+func globEscape(path string) string {
+	var b strings.Builder
+	for _, r := range path {
+		switch r {
+		case '*', '?', '[', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// redactInput replaces the URL FFmpeg read a video of a remote library
+// from, which carries credentials, with the path of the video in s
+func redactInput(s, input, videoPath string) string {
+	if input == videoPath {
+		return s
+	}
+	return strings.ReplaceAll(s, input, videoPath)
+}
+
+// tailLines returns the last n lines of s
+func tailLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Caveats lists the ways the variants a source is transcoded to are known
+// to fall short of it, as FFmpeg is run without tone mapping, deinterlacing
+// or pixel format conversion and scales to a fixed size
+func Caveats(probe *ProbeResult, variants []config.QualityVariant) []string {
+	var caveats []string
+	if probe.HDR {
+		caveats = append(caveats, "HDR source is not tone mapped, colors will look washed out")
+	}
+	if probe.BitDepth > 8 {
+		caveats = append(caveats, fmt.Sprintf("%d-bit source keeps its bit depth, which many players can't decode", probe.BitDepth))
+	}
+	if probe.Interlaced() {
+		caveats = append(caveats, "interlaced source is not deinterlaced, motion will show combing")
+	}
+	
+	for _, q := range variants {
+		if probe.Height > 0 && probe.Height < q.Height {
+			caveats = append(caveats, fmt.Sprintf("%s is upscaled from %dp", q.Name(), probe.Height))
+		}
+		if probe.Width > 0 && probe.Height > 0 {
+			source := float64(probe.Width) / float64(probe.Height)
+			target := float64(q.Width) / float64(q.Height)
+			if source/target < 0.99 || source/target > 1.01 {
+				caveats = append(caveats, fmt.Sprintf("%s is stretched from %.2f:1 to %.2f:1", q.Name(), source, target))
+			}
+		}
+	}
+	return caveats
 }
 
-// GenerateHLSMasterPlaylist creates a master playlist for adaptive streaming
-func GenerateHLSMasterPlaylist(videoFile, outputDir string, qualities []map[string]string) (string, error) {
+// GenerateHLSMasterPlaylist creates a master playlist for adaptive streaming,
+// listing the audio and subtitle renditions the variants go with
+func GenerateHLSMasterPlaylist(videoFile, outputDir string, qualities []config.QualityVariant, renditions []Rendition) (string, error) {
 	// Create master playlist
 	masterPlaylist := "#EXTM3U\n"
 	masterPlaylist += "#EXT-X-VERSION:3\n"
 	
+	// Add the renditions and the groups the variants refer to them with
+	var groups string
+	for _, kind := range []string{RenditionAudio, RenditionSubtitles} {
+		listed := false
+		for _, r := range renditions {
+			if r.Type == kind {
+				masterPlaylist += r.mediaTag() + "\n"
+				listed = true
+			}
+		}
+		if listed && kind == RenditionAudio {
+			groups += fmt.Sprintf(",AUDIO=\"%s\"", audioGroup)
+		} else if listed {
+			groups += fmt.Sprintf(",SUBTITLES=\"%s\"", subtitlesGroup)
+		}
+	}
+	
 	// Add each quality variant
 	for _, quality := range qualities {
-		width := quality["width"]
-		height := quality["height"]
-		bitrate := quality["bitrate"]
-		
-		bandwidthKbps, _ := strconv.Atoi(strings.TrimSuffix(bitrate, "k"))
-		bandwidthBps := bandwidthKbps * 1000
+		bandwidthBps := quality.VideoBitrateKbps * 1000
 		
-		masterPlaylist += fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%s,NAME=\"%sp\"\n", 
-			bandwidthBps, width+"x"+height, height)
+		masterPlaylist += fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d,NAME=\"%s\"%s\n", 
+			bandwidthBps, quality.Width, quality.Height, quality.Name(), groups)
 		
-		variantFile := fmt.Sprintf("%s_%s.m3u8", filepath.Base(videoFile), height)
+		variantFile := fmt.Sprintf("%s_%d.m3u8", filepath.Base(videoFile), quality.Height)
 		masterPlaylist += variantFile + "\n"
 	}
 	
@@ -149,59 +398,242 @@ func GenerateHLSMasterPlaylist(videoFile, outputDir string, qualities []map[stri
 	return masterPath, nil
 }
 
-// PrepareVideo prepares a video for HLS streaming
-func (tm *Manager) PrepareVideo(videoPath string) (string, error) {
-	// Create destination directory
+// OutputDir returns the cache directory new HLS output of a video is written to
+func (tm *Manager) OutputDir(videoPath string) string {
 	videoFileName := filepath.Base(videoPath)
-	outputDir := filepath.Join(tm.config.Media.CacheDir, strings.TrimSuffix(videoFileName, filepath.Ext(videoFileName)))
+	name := strings.TrimSuffix(videoFileName, filepath.Ext(videoFileName))
 	
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return "", err
+	// Files with the same name in different libraries must not share a
+	// cache directory
+	if lib, ok := tm.settings().LibraryFor(videoPath); ok && lib.Name != "" {
+		name = lib.Name + "-" + name
+	}
+	return filepath.Join(tm.settings().Media.CacheDir, name)
+}
+
+// ProfileFor returns the transcode profile of a video, with the preset of
+// its library when it overrides the preset
+func (tm *Manager) ProfileFor(videoPath string) config.TranscodeProfile {
+	cfg := tm.settings()
+	// Loading the configuration checked that the profile exists
+	profile, _ := cfg.Profile("")
+	if lib, ok := cfg.LibraryFor(videoPath); ok && lib.TranscodePreset != "" {
+		profile.Preset = lib.TranscodePreset
+	}
+	return profile
+}
+
+// Input returns what FFmpeg reads a video from, a URL for videos of
+// remote libraries
+func (tm *Manager) Input(videoPath string) (string, error) {
+	return storage.Input(tm.settings(), videoPath)
+}
+
+// CacheDirFor resolves the absolute cache directory of a video from the
+// location recorded in the database. Videos processed before cache locations
+// were recorded fall back to the default layout.
+func (tm *Manager) CacheDirFor(storedDir, videoPath string) string {
+	if storedDir == "" {
+		return tm.OutputDir(videoPath)
+	}
+	return filepath.Join(tm.settings().Media.CacheDir, storedDir)
+}
+
+// MasterPlaylistFor resolves the master playlist of a video, relative to the
+// cache root, from the location recorded in the database. Videos processed
+// before cache locations were recorded fall back to the default layout.
+func (tm *Manager) MasterPlaylistFor(storedPlaylist, videoPath string) string {
+	if storedPlaylist != "" {
+		return storedPlaylist
+	}
+	masterPath := filepath.Join(tm.OutputDir(videoPath), filepath.Base(videoPath)+".m3u8")
+	return tm.RelativeToCache(masterPath)
+}
+
+// RelativeToCache converts an absolute path inside the cache into a path
+// relative to the cache root
+func (tm *Manager) RelativeToCache(path string) string {
+	rel, err := filepath.Rel(tm.settings().Media.CacheDir, path)
+	if err != nil {
+		return path
 	}
+	return filepath.ToSlash(rel)
+}
+
+// PrepareVideo prepares a video for HLS streaming. The output is written
+// to a directory in media.work_dir and only replaces the video's cache
+// directory once VerifyHLS found it complete, so players never fetch
+// half-written playlists. The returned result is non-nil even on failure so callers can
+// record the commands that were run. Cancelling ctx stops all running
+// FFmpeg jobs. progress, if not nil, is called concurrently with the
+// progress of every variant.
+func (tm *Manager) PrepareVideo(ctx context.Context, videoPath string, progress func(Progress)) (*PrepareResult, error) {
+	result := &PrepareResult{}
+
+	videoFileName := filepath.Base(videoPath)
+	outputDir := tm.OutputDir(videoPath)
+	result.OutputDir = outputDir
+
+	workDir, err := tm.newWorkDir(outputDir)
+	if err != nil {
+		return result, err
+	}
+	defer os.RemoveAll(workDir)
 	
 	// Define quality variants
-	qualities := []map[string]string{
-		{"width": "1280", "height": "720", "bitrate": "2500k"},
-		//{"width": "854", "height": "480", "bitrate": "1000k"},
-		//{"width": "640", "height": "360", "bitrate": "500k"},
+	profile := tm.ProfileFor(videoPath)
+	qualities := profile.Variants
+	
+	if err := tm.transcodeVariants(ctx, videoPath, workDir, videoFileName, profile, qualities, progress, result); err != nil {
+		return result, err
+	}
+	
+	// Drop the largest variants until the output fits the quota
+	_, span := telemetry.Start(ctx, "fit quota")
+	qualities, result.Dropped, err = tm.fitQuota(workDir, videoFileName, qualities)
+	span.SetAttributes("dropped", strings.Join(result.Dropped, ","))
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return result, fmt.Errorf("failed to fit the cache quota: %w", err)
+	}
+	
+	// Audio and subtitle tracks are nice to have, so the video is ready
+	// without those that failed
+	var renditions []Rendition
+	if probe, err := tm.Probe(videoPath); err != nil {
+		tm.log.Warn("Error probing audio and subtitle tracks", "video", videoPath, "err", err)
+	} else {
+		renditions = tm.transcodeRenditions(ctx, videoPath, workDir, videoFileName, profile, qualities, probe, result)
+	}
+	if ctx.Err() != nil {
+		return result, ctx.Err()
+	}
+	
+	// Generate master playlist
+	if _, err := GenerateHLSMasterPlaylist(videoFileName, workDir, qualities, renditions); err != nil {
+		return result, err
+	}
+	_, span = telemetry.Start(ctx, "verify output")
+	err = VerifyHLS(workDir, videoFileName+".m3u8")
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return result, fmt.Errorf("incomplete output: %w", err)
+	}
+
+	// Seek previews are nice to have, so the video is ready without them
+	_, span = telemetry.Start(ctx, "extract thumbnails")
+	err = tm.ExtractThumbnails(ctx, videoPath, workDir)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		if ctx.Err() != nil {
+			return result, ctx.Err()
+		}
+		tm.log.Warn("Error extracting seek thumbnails", "video", videoPath, "err", err)
+	}
+
+	// Keep the artwork the server extracted from the previous output, then
+	// swap the complete output in
+	_, span = telemetry.Start(ctx, "swap output")
+	os.Rename(filepath.Join(outputDir, ArtworkFile), filepath.Join(workDir, ArtworkFile))
+	err = utils.ReplaceDir(workDir, outputDir)
+	span.SetError(err)
+	span.End()
+	if err != nil {
+		return result, err
+	}
+	result.MasterPath = filepath.Join(outputDir, videoFileName+".m3u8")
+	
+	result.Variants = reportVariants(outputDir, videoFileName, qualities)
+	return result, nil
+}
+
+// newWorkDir creates a work directory of its own for output that goes to
+// outputDir, so failed and concurrent runs don't get in the way of each
+// other
+func (tm *Manager) newWorkDir(outputDir string) (string, error) {
+	workRoot := tm.settings().Media.WorkDirPath()
+	if err := os.MkdirAll(workRoot, 0755); err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	workDir, err := os.MkdirTemp(workRoot, filepath.Base(outputDir)+"-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create work directory: %w", err)
+	}
+	return workDir, nil
+}
+
+// transcodeVariants transcodes a video into the given variants in workDir
+// at once, recording the jobs in result
+func (tm *Manager) transcodeVariants(ctx context.Context, videoPath, workDir, videoFileName string, profile config.TranscodeProfile, qualities []config.QualityVariant, progress func(Progress), result *PrepareResult) (err error) {
+	ctx, span := telemetry.Start(ctx, "transcode variants", "variants", len(qualities))
+	defer func() {
+		span.SetError(err)
+		span.End()
+	}()
+
+	input, err := tm.Input(videoPath)
+	if err != nil {
+		return err
 	}
 	
-	// Start transcoding for each quality
 	var wg sync.WaitGroup
-	for _, quality := range qualities {
+	result.Jobs = make([]*JobResult, len(qualities))
+	errs := make([]error, len(qualities))
+	for i, quality := range qualities {
 		wg.Add(1)
-		go func(q map[string]string) {
+		go func(i int, q config.QualityVariant) {
 			defer wg.Done()
 			
-			width, _ := strconv.Atoi(q["width"])
-			height, _ := strconv.Atoi(q["height"])
-			
-			outputFile := filepath.Join(outputDir, 
-				fmt.Sprintf("%s_%s.m3u8", videoFileName, q["height"]))
-			
+			outputFile := variantPlaylist(workDir, videoFileName, q)
 			job := VideoJob{
-				SourceFile:      videoPath,
-				OutputPath:      outputFile,
-				Width:           width,
-				Height:          height,
-				Bitrate:         q["bitrate"],
-				SegmentDuration: tm.config.Server.SegmentDuration,
+				SourceFile: videoPath,
+				Input:      input,
+				OutputPath: outputFile,
+				Variant:    q,
+				Profile:    profile,
+			}
+			if progress != nil {
+				variant := q.Name()
+				job.Progress = func(p Progress) {
+					p.Variant = variant
+					progress(p)
+				}
 			}
 			
-			if err := tm.TranscodeToHLS(job); err != nil {
-				log.Printf("Error transcoding %s to %s: %v", videoPath, outputFile, err)
+			result.Jobs[i], errs[i] = tm.TranscodeToHLS(ctx, job)
+			if errs[i] != nil {
+				tm.log.Error("Error transcoding", "source", videoPath, "output", outputFile, "err", errs[i])
 			}
-		}(quality)
+		}(i, quality)
 	}
 	
 	// Wait for all transcoding jobs to complete
 	wg.Wait()
-	
-	// Generate master playlist
-	masterPath, err := GenerateHLSMasterPlaylist(videoFileName, outputDir, qualities)
-	if err != nil {
-		return "", err
+
+	for i, err := range errs {
+		if err != nil {
+			return fmt.Errorf("variant %s: %w", qualities[i].Name(), err)
+		}
 	}
-	
-	return masterPath, nil
-}
\ No newline at end of file
+	return nil
+}
+
+// reportVariants describes the variants of the output in dir with their
+// size on disk and checksum
+func reportVariants(dir, videoFileName string, qualities []config.QualityVariant) []Variant {
+	var variants []Variant
+	for _, q := range qualities {
+		playlist := variantPlaylist(dir, videoFileName, q)
+		checksum, _ := VariantChecksum(playlist)
+		variants = append(variants, Variant{
+			Name:      q.Name(),
+			Playlist:  playlist,
+			SizeBytes: variantSize(playlist),
+			Checksum:  checksum,
+		})
+	}
+	return variants
+}
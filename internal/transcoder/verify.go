@@ -0,0 +1,137 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// mapURI matches the URI of an #EXT-X-MAP tag, the init segment of fMP4
+// playlists
+var mapURI = regexp.MustCompile(`#EXT-X-MAP:.*URI="([^"]+)"`)
+
+// VerifyHLS checks that the HLS output in dir is complete before it is
+// published: the master playlist lists variants, and every variant and
+// rendition passes VerifyVariant and VerifySegments. A transcode that was
+// cut short without FFmpeg noticing leaves a playlist without
+// #EXT-X-ENDLIST.
+func VerifyHLS(dir, masterName string) error {
+	variants, err := PlaylistURIs(filepath.Join(dir, masterName))
+	if err != nil {
+		return err
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("master playlist %s lists no variants", masterName)
+	}
+	renditions, err := RenditionURIs(filepath.Join(dir, masterName))
+	if err != nil {
+		return err
+	}
+
+	for _, variant := range append(variants, renditions...) {
+		segments, err := VerifyVariant(filepath.Join(dir, filepath.FromSlash(variant)))
+		if err != nil {
+			return err
+		}
+		if err := VerifySegments(segments); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyVariant checks that a variant playlist exists, ends with
+// #EXT-X-ENDLIST and has segments. It returns the paths of the segments,
+// starting with the init segment of fMP4 output.
+func VerifyVariant(playlist string) ([]string, error) {
+	name := filepath.Base(playlist)
+	content, err := os.ReadFile(playlist)
+	if err != nil {
+		return nil, fmt.Errorf("variant playlist %s is missing", name)
+	}
+	if !bytes.Contains(content, []byte("#EXT-X-ENDLIST")) {
+		return nil, fmt.Errorf("variant playlist %s is incomplete", name)
+	}
+
+	uris, err := PlaylistURIs(playlist)
+	if err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("variant playlist %s has no segments", name)
+	}
+	if m := mapURI.FindSubmatch(content); m != nil {
+		uris = append([]string{string(m[1])}, uris...)
+	}
+	segments := make([]string, len(uris))
+	for i, uri := range uris {
+		segments[i] = filepath.Join(filepath.Dir(playlist), filepath.FromSlash(uri))
+	}
+	return segments, nil
+}
+
+// VerifySegments checks that every segment exists and isn't empty
+func VerifySegments(segments []string) error {
+	for _, segment := range segments {
+		info, err := os.Stat(segment)
+		if err != nil || info.Size() == 0 {
+			return fmt.Errorf("segment %s is missing or empty", filepath.Base(segment))
+		}
+	}
+	return nil
+}
+
+// VariantChecksum returns the SHA-256 of the segments of a variant, in the
+// order of its playlist, to notice segments that were damaged on disk
+func VariantChecksum(playlist string) (string, error) {
+	segments, err := VerifyVariant(playlist)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, segment := range segments {
+		f, err := os.Open(segment)
+		if err != nil {
+			return "", fmt.Errorf("segment %s is missing", filepath.Base(segment))
+		}
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read segment %s: %w", filepath.Base(segment), err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// PlaylistURIs returns the URIs of a playlist, the lines that aren't tags
+// or comments, such as the variants of a master playlist
+func PlaylistURIs(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("playlist %s is missing", filepath.Base(path))
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "#EXTM3U" {
+		return nil, fmt.Errorf("%s is not an HLS playlist", filepath.Base(path))
+	}
+	var uris []string
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			uris = append(uris, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	return uris, nil
+}
@@ -0,0 +1,285 @@
+package upload
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors returned by Store
+var (
+	ErrNotFound       = errors.New("upload not found")
+	ErrOffsetMismatch = errors.New("upload offset mismatch")
+	ErrBusy           = errors.New("upload is already receiving data")
+	ErrIncomplete     = errors.New("upload is incomplete")
+)
+
+// Upload is a file being uploaded in chunks. Its data is staged in a .part
+// file next to a JSON sidecar holding the metadata, so an upload can be
+// resumed after the connection or the server restarts.
+type Upload struct {
+	ID        string    `json:"id"`
+	Filename  string    `json:"filename"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Complete reports whether all bytes of the upload have been received
+func (u *Upload) Complete() bool {
+	return u.Offset == u.Size
+}
+
+// Store keeps track of uploads staged in a directory
+type Store struct {
+	dir string
+	log *slog.Logger
+
+	mu   sync.Mutex
+	busy map[string]bool
+}
+
+// NewStore creates a store staging uploads in dir and logging to logger
+func NewStore(dir string, logger *slog.Logger) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create upload directory: %w", err)
+	}
+
+	return &Store{
+		dir:  dir,
+		log:  logger,
+		busy: make(map[string]bool),
+	}, nil
+}
+
+// Create starts a new upload of size bytes
+func (s *Store) Create(filename string, size int64) (*Upload, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	u := &Upload{
+		ID:        id,
+		Filename:  filename,
+		Size:      size,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(u)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode upload: %w", err)
+	}
+	if err := os.WriteFile(s.infoPath(id), data, 0644); err != nil {
+		return nil, fmt.Errorf("failed to write upload info: %w", err)
+	}
+	if err := os.WriteFile(s.partPath(id), nil, 0644); err != nil {
+		os.Remove(s.infoPath(id))
+		return nil, fmt.Errorf("failed to create upload file: %w", err)
+	}
+
+	return u, nil
+}
+
+// Get returns an upload with its current offset
+func (s *Store) Get(id string) (*Upload, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	data, err := os.ReadFile(s.infoPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to read upload info: %w", err)
+	}
+
+	u := &Upload{}
+	if err := json.Unmarshal(data, u); err != nil {
+		return nil, fmt.Errorf("failed to parse upload info: %w", err)
+	}
+
+	info, err := os.Stat(s.partPath(id))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to stat upload file: %w", err)
+	}
+	u.Offset = info.Size()
+
+	return u, nil
+}
+
+// Append writes a chunk read from r at offset, which must match the number
+// of bytes received so far. At most the remaining size of the upload is
+// read. Bytes written before a read error are kept, so the client can
+// resume from the returned offset.
+func (s *Store) Append(id string, offset int64, r io.Reader) (*Upload, error) {
+	if !s.acquire(id) {
+		return nil, ErrBusy
+	}
+	defer s.release(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if offset != u.Offset {
+		return u, ErrOffsetMismatch
+	}
+
+	f, err := os.OpenFile(s.partPath(id), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return u, fmt.Errorf("failed to open upload file: %w", err)
+	}
+	defer f.Close()
+
+	n, err := io.Copy(f, io.LimitReader(r, u.Size-u.Offset))
+	u.Offset += n
+	if err != nil {
+		return u, fmt.Errorf("failed to write upload data: %w", err)
+	}
+
+	return u, nil
+}
+
+// Finish moves a complete upload to dest and forgets about it
+func (s *Store) Finish(id, dest string) error {
+	if !s.acquire(id) {
+		return ErrBusy
+	}
+	defer s.release(id)
+
+	u, err := s.Get(id)
+	if err != nil {
+		return err
+	}
+	if !u.Complete() {
+		return ErrIncomplete
+	}
+
+	if err := os.Rename(s.partPath(id), dest); err != nil {
+		return fmt.Errorf("failed to move upload into place: %w", err)
+	}
+	os.Remove(s.infoPath(id))
+
+	return nil
+}
+
+// Remove cancels an upload and deletes its staged data
+func (s *Store) Remove(id string) error {
+	if !s.acquire(id) {
+		return ErrBusy
+	}
+	defer s.release(id)
+
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+
+	os.Remove(s.partPath(id))
+	if err := os.Remove(s.infoPath(id)); err != nil {
+		return fmt.Errorf("failed to remove upload: %w", err)
+	}
+
+	return nil
+}
+
+// PurgeStale removes uploads that haven't received data for maxAge
+func (s *Store) PurgeStale(maxAge time.Duration) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		s.log.Error("Error reading upload directory", "err", err)
+		return
+	}
+
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validID(id) {
+			continue
+		}
+
+		lastWrite := s.infoPath(id)
+		if _, err := os.Stat(s.partPath(id)); err == nil {
+			lastWrite = s.partPath(id)
+		}
+		info, err := os.Stat(lastWrite)
+		if err != nil || time.Since(info.ModTime()) < maxAge {
+			continue
+		}
+
+		if err := s.Remove(id); err != nil {
+			s.log.Error("Error removing stale upload", "upload", id, "err", err)
+			continue
+		}
+		s.log.Info("Removed stale upload", "upload", id)
+	}
+}
+
+// StartCleanup periodically removes uploads abandoned for maxAge
+func (s *Store) StartCleanup(maxAge time.Duration) {
+	ticker := time.NewTicker(1 * time.Hour)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.PurgeStale(maxAge)
+	}
+}
+
+// acquire marks an upload as busy, returning false if it already is
+func (s *Store) acquire(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.busy[id] {
+		return false
+	}
+	s.busy[id] = true
+	return true
+}
+
+// release clears the busy mark set by acquire
+func (s *Store) release(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.busy, id)
+}
+
+func (s *Store) infoPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *Store) partPath(id string) string {
+	return filepath.Join(s.dir, id+".part")
+}
+
+// newID generates a random upload ID
+func newID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate upload ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// validID reports whether id looks like an ID generated by newID, which
+// keeps user supplied IDs from escaping the upload directory
+func validID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
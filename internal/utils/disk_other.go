@@ -0,0 +1,11 @@
+//go:build !unix
+
+package utils
+
+import "errors"
+
+// FreeDiskSpace returns the bytes available on the file system holding
+// path. It isn't supported on this platform.
+func FreeDiskSpace(path string) (int64, error) {
+	return 0, errors.ErrUnsupported
+}
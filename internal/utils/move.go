@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// ReplaceDir moves the directory src to dst, replacing whatever is at dst.
+// Readers of dst see either the old or the new directory, never a partly
+// written one: src is renamed into place, after being copied next to dst
+// first when it is on another file system. The directories used on the way
+// are hidden next to dst.
+func ReplaceDir(src, dst string) error {
+	parent, name := filepath.Split(dst)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return err
+	}
+
+	staged := filepath.Join(parent, "."+name+".new")
+	os.RemoveAll(staged)
+	if err := os.Rename(src, staged); err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return fmt.Errorf("failed to move %s: %w", src, err)
+		}
+		// Renames don't cross file systems, copy to the destination's
+		if err := copyDir(src, staged); err != nil {
+			os.RemoveAll(staged)
+			return fmt.Errorf("failed to copy %s: %w", src, err)
+		}
+		os.RemoveAll(src)
+	}
+
+	// A directory can't be renamed over another one, so the old one is
+	// moved aside for the moment it takes to swap them
+	old := filepath.Join(parent, "."+name+".old")
+	os.RemoveAll(old)
+	if err := os.Rename(dst, old); err != nil && !os.IsNotExist(err) {
+		os.RemoveAll(staged)
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	if err := os.Rename(staged, dst); err != nil {
+		os.Rename(old, dst)
+		os.RemoveAll(staged)
+		return fmt.Errorf("failed to replace %s: %w", dst, err)
+	}
+	return os.RemoveAll(old)
+}
+
+// MoveFile moves the file src to dst, replacing whatever is at dst in one
+// step. A file on another file system is copied next to dst first.
+func MoveFile(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil || !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	staged := filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".new")
+	if err := copyFile(src, staged); err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := os.Rename(staged, dst); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	return os.Remove(src)
+}
+
+// copyDir copies the directories and regular files of the tree src to dst
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		return copyFile(path, target)
+	})
+}
+
+// copyFile copies a regular file, syncing it to disk
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
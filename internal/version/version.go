@@ -0,0 +1,82 @@
+// Package version reports which build of the application is running. The
+// version, commit and build date are injected at build time:
+//
+//	go build -ldflags "-X github.com/kaero/streaming/internal/version.Version=1.4.0" ./cmd/streaming
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Set with -ldflags "-X ..." at build time
+var (
+	// Version is the semantic version of the release
+	Version = "dev"
+	// Commit is the git commit the binary was built from
+	Commit = ""
+	// Date is the build date, RFC 3339 in UTC
+	Date = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// FFmpeg is the version line of the installed FFmpeg, filled in by
+	// callers that detected it
+	FFmpeg string `json:"ffmpeg,omitempty"`
+}
+
+// Get returns the build information. Values not injected at build time are
+// taken from what the Go toolchain recorded: the module version for
+// `go install`, the VCS revision and time for builds from a checkout.
+func Get() Info {
+	info := Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: Date,
+		GoVersion: runtime.Version(),
+	}
+
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+		info.Version = bi.Main.Version
+	}
+
+	settings := make(map[string]string)
+	for _, s := range bi.Settings {
+		settings[s.Key] = s.Value
+	}
+	if info.Commit == "" && settings["vcs.revision"] != "" {
+		info.Commit = settings["vcs.revision"]
+		if settings["vcs.modified"] == "true" {
+			info.Commit += "-dirty"
+		}
+	}
+	if info.BuildDate == "" {
+		info.BuildDate = settings["vcs.time"]
+	}
+	return info
+}
+
+// String formats the version for log lines, e.g. "1.4.0 (3a7aa02)"
+func (i Info) String() string {
+	if i.Commit == "" {
+		return i.Version
+	}
+	commit, dirty := strings.CutSuffix(i.Commit, "-dirty")
+	if len(commit) > 7 {
+		commit = commit[:7]
+	}
+	if dirty {
+		commit += "-dirty"
+	}
+	return i.Version + " (" + commit + ")"
+}